@@ -2,13 +2,19 @@ package exe
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/0xADE/ade-ctld/execline"
+	"github.com/0xADE/ade-ctld/response"
 )
 
 // Application represents an application entry
@@ -23,33 +29,105 @@ type Client struct {
 	reader *bufio.Reader
 	mu     sync.Mutex
 	socket string
+
+	// State set through typed methods, replayed by Reconnect when the session can't be resumed
+	session    string
+	nameFilter string
+	lang       string
+
+	caps *Capabilities // what the daemon supports, fetched once per connection
+}
+
+// Capabilities describes what a daemon supports
+type Capabilities struct {
+	Protocols []string // Protocol headers accepted
+	Features  []string // Optional features enabled
+	Commands  []string // Commands served
+	ListLimit int      // Entries returned by list before paging, 0 when unlimited
 }
 
 const protoVer = "TXT01" // cmdlist protocol, text format, v01
 
-// NewClient creates a new client and connects to the server
+// NewClient creates a new client and connects to the server at ADE_INDEXD_SOCK, or the
+// default socket of the user
 func NewClient() (*Client, error) {
 	socketPath, err := getSocketPath()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get socket path: %w", err)
 	}
+	return Dial(socketPath)
+}
+
+// Dial creates a new client connected to the server listening on socketPath, e.g. an
+// exetest.Server
+func Dial(socketPath string) (*Client, error) {
+	conn, err := dial(socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		socket: socketPath,
+	}, nil
+}
 
+// dial connects to the server and sends the protocol header
+func dial(socketPath string) (net.Conn, error) {
 	conn, err := net.Dial("unix", socketPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to socket %s: %w", socketPath, err)
+		return nil, fmt.Errorf("%w: failed to connect to socket %s: %w", ErrNotConnected, socketPath, err)
 	}
 
 	// Send header
 	if _, err := conn.Write([]byte(protoVer)); err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to send header: %w", err)
+		return nil, fmt.Errorf("failed to send header: %w", connError(err))
 	}
+	return conn, nil
+}
 
-	return &Client{
-		conn:   conn,
-		reader: bufio.NewReader(conn),
-		socket: socketPath,
-	}, nil
+// Reconnect re-dials the server, e.g. after a daemon restart. A persisted session is resumed,
+// otherwise the name filter and language set through this client are replayed.
+func (c *Client) Reconnect() error {
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	conn, err := dial(c.socket)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	c.caps = nil // the daemon may have been upgraded
+	token, nameFilter, lang := c.session, c.nameFilter, c.lang
+	c.mu.Unlock()
+
+	if token != "" {
+		resumed, err := c.ResumeSession(token)
+		if err != nil {
+			return err
+		}
+		if resumed {
+			return nil
+		}
+	}
+
+	if lang != "" {
+		if err := c.SetLang(lang); err != nil {
+			return err
+		}
+	}
+	if nameFilter != "" {
+		if err := c.SetFilterName(nameFilter); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Close closes the connection
@@ -57,11 +135,33 @@ func (c *Client) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.conn != nil {
-		return c.conn.Close()
+		err := c.conn.Close()
+		c.conn = nil
+		return err
 	}
 	return nil
 }
 
+// aliveWait is how long Alive waits for the daemon to tell the connection closed
+const aliveWait = time.Millisecond
+
+// Alive reports whether the connection is open and wasn't closed by the daemon meanwhile,
+// e.g. on a restart, so that Reconnect can be called before sending the next command rather
+// than after it failed. Nothing is sent; a reply or heartbeat waiting to be read is left
+// for the next read.
+func (c *Client) Alive() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return false
+	}
+	// A deadline already past would fail the read before looking at the socket
+	c.conn.SetReadDeadline(time.Now().Add(aliveWait))
+	defer c.conn.SetReadDeadline(time.Time{})
+	_, err := c.reader.Peek(1)
+	return err == nil || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
 // FormatArgument formats an argument according to its type
 func FormatArgument(arg any) string {
 	switch v := arg.(type) {
@@ -126,51 +226,146 @@ func (c *Client) SendCommand(cmdName string, args ...any) error {
 
 // sendCommand is the internal version without locking
 func (c *Client) sendCommand(cmdName string, args ...any) error {
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+
 	// Send arguments with type detection
 	for _, arg := range args {
 		formatted := FormatArgument(arg)
 		if _, err := fmt.Fprintf(c.conn, "%s\n", formatted); err != nil {
-			return fmt.Errorf("failed to send argument: %w", err)
+			return fmt.Errorf("failed to send argument: %w", connError(err))
 		}
 	}
 
 	// Send command
 	if _, err := fmt.Fprintf(c.conn, "%s\n", cmdName); err != nil {
-		return fmt.Errorf("failed to send command: %w", err)
+		return fmt.Errorf("failed to send command: %w", connError(err))
 	}
 
 	return nil
 }
 
+// Reply is a reply read by Exec
+type Reply struct {
+	Attrs map[string]string // Parsed attributes
+	Raw   string            // Attribute block as sent
+	Body  string            // Body lines, empty for replies without a body
+}
+
+// Exec sends any command and reads its reply. Error replies are returned along with a
+// *ServerError; the reply is nil only when the exchange itself failed.
+func (c *Client) Exec(cmdName string, args ...any) (*Reply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.sendCommand(cmdName, args...); err != nil {
+		return nil, err
+	}
+	for {
+		frame, err := readRawFrame(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if isHeartbeat(frame.attrs) {
+			continue
+		}
+		return &Reply{Attrs: frame.attrs, Raw: frame.raw, Body: frame.body}, responseError(frame.attrs)
+	}
+}
+
+// ReadEvent waits for the next event of a subscription, like "event: index-updated" with the
+// generation and changes attributes, skipping heartbeats. Nothing else should be sent on
+// the connection meanwhile, its replies could be read as events. Closing Conn stops waiting.
+func (c *Client) ReadEvent() (*Reply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil, ErrNotConnected
+	}
+	for {
+		frame, err := readRawFrame(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if isHeartbeat(frame.attrs) {
+			continue
+		}
+		return &Reply{Attrs: frame.attrs, Raw: frame.raw, Body: frame.body}, nil
+	}
+}
+
 // Conn returns the underlying connection
 func (c *Client) Conn() net.Conn {
 	return c.conn
 }
 
-func ReadResponse(conn net.Conn) {
+// ReadResponse prints a reply read from conn. It returns the *ServerError of an error reply,
+// or ErrServerClosed when the connection ends before the reply does. Heartbeats are skipped.
+func ReadResponse(conn net.Conn) error {
 	reader := bufio.NewReader(conn)
 
+	var (
+		frame   rawFrame
+		readErr error
+	)
+	for {
+		frame, readErr = readRawFrame(reader)
+		if readErr != nil || !isHeartbeat(frame.attrs) {
+			break
+		}
+	}
+
+	// Print response to stdout, usage hints of failed commands are for the user and go to stderr
+	for line := range strings.SplitAfterSeq(frame.raw, "\n") {
+		if strings.HasPrefix(line, "hint: ") {
+			fmt.Fprint(os.Stderr, line)
+			continue
+		}
+		fmt.Print(line)
+	}
+	if frame.hasBody {
+		fmt.Print("body:\n")
+		fmt.Print(frame.body)
+	}
+
+	if readErr != nil {
+		return readErr
+	}
+	return responseError(frame.attrs)
+}
+
+// rawFrame is a frame as read by readRawFrame
+type rawFrame struct {
+	raw     string            // attrs block as sent
+	attrs   map[string]string // parsed attributes
+	body    string
+	hasBody bool // the frame had a body: marker, the body may still be empty
+}
+
+// readRawFrame reads one frame
+func readRawFrame(reader *bufio.Reader) (rawFrame, error) {
 	// Read header
 	header := make([]byte, 5)
 	_, err := io.ReadFull(reader, header)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to read response header: %v\n", err)
-		return
+		return rawFrame{}, connError(err)
 	}
 
 	// Read attrs block and check for body: header
 	attrs := strings.Builder{}
+	fields := make(map[string]string)
 	body := strings.Builder{}
-	hasBody := false
 	seenBodyHeader := false
+	var readErr error
 
 	for {
 		line, err := reader.ReadString('\n')
-		if err == io.EOF {
-			break
-		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Read error: %v\n", err)
+			readErr = connError(err)
 			break
 		}
 
@@ -207,24 +402,16 @@ func ReadResponse(conn net.Conn) {
 		if !seenBodyHeader {
 			// Still reading headers
 			attrs.WriteString(line)
+			if key, value, ok := strings.Cut(line, ":"); ok {
+				fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
 		} else {
 			// Reading body content
 			body.WriteString(line)
 		}
 	}
 
-	// Build full response for logging
-	fullResponse := attrs.String()
-	if hasBody {
-		fullResponse += "body:\n" + body.String()
-	}
-
-	// Print response to stdout
-	fmt.Print(attrs.String())
-	if hasBody {
-		fmt.Print("body:\n")
-		fmt.Print(body.String())
-	}
+	return rawFrame{raw: attrs.String(), attrs: fields, body: body.String(), hasBody: seenBodyHeader}, readErr
 }
 
 // isEndOfResponse checks if we've reached the end of response marker (\n\n)
@@ -267,10 +454,11 @@ func (c *Client) ResetFilters() error {
 	}
 
 	// Check for errors
-	if errMsg, ok := attrs["error"]; ok {
-		return fmt.Errorf("server error: %s", errMsg)
+	if err := responseError(attrs); err != nil {
+		return err
 	}
 
+	c.nameFilter = ""
 	return nil
 }
 
@@ -291,14 +479,71 @@ func (c *Client) SetFilterName(query string) error {
 	}
 
 	// Check for errors
-	if errMsg, ok := attrs["error"]; ok {
-		return fmt.Errorf("server error: %s", errMsg)
+	if err := responseError(attrs); err != nil {
+		return err
 	}
 
+	c.mu.Lock()
+	c.nameFilter = query
+	c.mu.Unlock()
 	return nil
 
 }
 
+// AddFilterName adds a name filter matching any of terms, like +filter-name, and returns
+// the number of name filters now set. Unlike the filter of SetFilterName, it's lost on
+// Reconnect unless the session was persisted.
+func (c *Client) AddFilterName(terms ...string) (int, error) {
+	return c.addFilter("+filter-name", "name-filters", terms)
+}
+
+// AddFilterCat adds a category filter matching entries in all of categories, like
+// +filter-cat, and returns the number of category filters now set
+func (c *Client) AddFilterCat(categories ...string) (int, error) {
+	return c.addFilter("+filter-cat", "cat-filters", categories)
+}
+
+// AddFilterPath adds a path filter matching entries below any of paths, like +filter-path,
+// and returns the number of path filters now set
+func (c *Client) AddFilterPath(paths ...string) (int, error) {
+	return c.addFilter("+filter-path", "path-filters", paths)
+}
+
+// addFilter sends a +filter command with values as strings and returns the count of the
+// family attr of the reply, -1 when the daemon doesn't tell, as older ones don't. The
+// daemon refuses a filter without values.
+func (c *Client) addFilter(cmdName, family string, values []string) (int, error) {
+	args := make([]any, len(values))
+	for i, value := range values {
+		// Quoted, so that values like "or" aren't taken for operators
+		args[i] = `"` + value
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.sendCommand(cmdName, args...); err != nil {
+		return 0, fmt.Errorf("failed to send %s command: %w", cmdName, err)
+	}
+	attrs, _, err := c.readResponse()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := responseError(attrs); err != nil {
+		return 0, err
+	}
+
+	value, ok := attrs[family]
+	if !ok {
+		return -1, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s in reply: %q", family, value)
+	}
+	return count, nil
+}
+
 // List retrieves the list of applications matching current filters
 func (c *Client) List() ([]Application, error) {
 	c.mu.Lock()
@@ -316,11 +561,129 @@ func (c *Client) List() ([]Application, error) {
 	}
 
 	// Check for errors
-	if errMsg, ok := attrs["error"]; ok {
-		return nil, fmt.Errorf("server error: %s", errMsg)
+	if err := responseError(attrs); err != nil {
+		return nil, err
 	}
 
-	// Parse body
+	return parseApplications(body), nil
+}
+
+// Page is a page of the applications matching current filters, see ListPage
+type Page struct {
+	Applications []Application
+	Len          int    // Applications matching the filters, on all pages
+	Cursor       string // Resumes after this page, empty on the last one
+	Restarted    bool   // The cursor was invalid, e.g. the index was rebuilt: this is the first page again
+}
+
+// ListPage retrieves the first page of the applications matching current filters for an
+// empty cursor, else the page after the one cursor came with. When the index was rebuilt
+// in between, the first page is returned again with Restarted set: the pages read so far
+// are outdated.
+func (c *Client) ListPage(cursor string) (*Page, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	if cursor == "" {
+		err = c.sendCommand("list")
+	} else {
+		err = c.sendCommand("list-next", `"`+cursor)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to send list command: %w", err)
+	}
+
+	attrs, body, err := c.readResponse()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := responseError(attrs); err != nil {
+		return nil, err
+	}
+
+	page := &Page{
+		Applications: parseApplications(body),
+		Cursor:       attrs["cursor"],
+		Restarted:    attrs["cursor-invalid"] == "t",
+	}
+	page.Len, _ = strconv.Atoi(attrs["len"])
+	return page, nil
+}
+
+// Search retrieves the applications matching query, best matches first, without touching
+// the filters. A limit of 0 uses the server default.
+func (c *Client) Search(query string, limit int) ([]Application, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Always a string, even when the query reads like a number or an operator
+	args := []any{`"` + query}
+	if limit > 0 {
+		args = append(args, limit)
+	}
+	if err := c.sendCommand("search", args...); err != nil {
+		return nil, fmt.Errorf("failed to send search command: %w", err)
+	}
+
+	attrs, body, err := c.readResponse()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := responseError(attrs); err != nil {
+		return nil, err
+	}
+
+	return parseApplications(body), nil
+}
+
+// Capabilities returns what the daemon supports. It's asked once per connection; daemons
+// predating the capabilities command are reported as supporting nothing.
+func (c *Client) Capabilities() (*Capabilities, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.caps != nil {
+		return c.caps, nil
+	}
+	if err := c.sendCommand("capabilities"); err != nil {
+		return nil, fmt.Errorf("failed to send capabilities command: %w", err)
+	}
+	attrs, body, err := c.readResponse()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := responseError(attrs); err != nil {
+		if errors.Is(err, &ServerError{Code: response.StatusUnknownCommand}) {
+			c.caps = &Capabilities{}
+			return c.caps, nil
+		}
+		return nil, err
+	}
+
+	caps := &Capabilities{
+		Protocols: strings.Fields(attrs["protocols"]),
+		Features:  strings.Fields(attrs["features"]),
+		Commands:  strings.Fields(body),
+	}
+	caps.ListLimit, _ = strconv.Atoi(attrs["list-limit"])
+	c.caps = caps
+	return caps, nil
+}
+
+// Supports reports whether the daemon has an optional feature (e.g. "events", "sessions")
+// or serves a command, so frontends can fall back against older daemons. It's false when
+// the capabilities can't be fetched.
+func (c *Client) Supports(feature string) bool {
+	caps, err := c.Capabilities()
+	if err != nil {
+		return false
+	}
+	return slices.Contains(caps.Features, feature) || slices.Contains(caps.Commands, feature)
+}
+
+// parseApplications parses the "<id> <name>" lines of a list body
+func parseApplications(body string) []Application {
 	var apps []Application
 	lines := strings.SplitSeq(strings.TrimSpace(body), "\n")
 	for line := range lines {
@@ -342,67 +705,229 @@ func (c *Client) List() ([]Application, error) {
 			Name: name,
 		})
 	}
+	return apps
+}
 
-	return apps, nil
+// RunOptions are the options of a run request
+type RunOptions struct {
+	Terminal bool     // Run in a terminal even if the entry doesn't ask for one
+	Nice     *int     // Niceness to launch with instead of the entry's own
+	File     string   // File to open, passed for the file field codes of the entry
+	Args     []string // Extra arguments, appended to the command line
+	Action   string   // Key of the desktop action to launch instead of the entry itself
 }
 
 // Run executes an application by ID
 func (c *Client) Run(id int64) error {
+	return c.RunWith(id, RunOptions{})
+}
+
+// RunInTerminal executes an application by ID in a terminal
+func (c *Client) RunInTerminal(id int64) error {
+	return c.RunWith(id, RunOptions{Terminal: true})
+}
+
+// RunFile executes an application by ID with a file to open
+func (c *Client) RunFile(id int64, path string) error {
+	return c.RunWith(id, RunOptions{File: path})
+}
+
+// RunWith executes an application by ID with options. Args are quoted with execline.Join,
+// the way the server splits them; a file or arguments holding a line break can't be sent
+// and execline.ErrNewline is returned for them.
+func (c *Client) RunWith(id int64, opts RunOptions) error {
+	_, err := c.run(id, opts)
+	return err
+}
+
+// RunAction executes a desktop action of an application by ID, like "new-private-window" of
+// a browser, and returns the pid of the process started. The keys of the actions of an
+// application are in the actions attribute of its info. An unknown key is a ServerError
+// with code StatusNotFound.
+func (c *Client) RunAction(id int64, actionKey string) (int, error) {
+	attrs, err := c.run(id, RunOptions{Action: actionKey})
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(attrs["pid"])
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid %q in run response: %w", attrs["pid"], err)
+	}
+	return pid, nil
+}
+
+// run sends a run request and returns the attributes of the reply
+func (c *Client) run(id int64, opts RunOptions) (map[string]string, error) {
+	if err := execline.Valid(append([]string{opts.File, opts.Action}, opts.Args...)); err != nil {
+		return nil, err
+	}
+
+	var args []any
+	if opts.Terminal {
+		args = append(args, "opt: terminal")
+	}
+	if opts.Nice != nil {
+		args = append(args, "opt: nice="+strconv.Itoa(*opts.Nice))
+	}
+	if opts.File != "" {
+		args = append(args, "file: "+opts.File)
+	}
+	if len(opts.Args) > 0 {
+		args = append(args, "args: "+execline.Join(opts.Args))
+	}
+	if opts.Action != "" {
+		args = append(args, "action: "+opts.Action)
+	}
+	args = append(args, id)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Send run command with id
-	if err := c.sendCommand("run", id); err != nil {
-		return fmt.Errorf("failed to send run command: %w", err)
+	if err := c.sendCommand("run", args...); err != nil {
+		return nil, fmt.Errorf("failed to send run command: %w", err)
 	}
 
 	// Read response
 	attrs, _, err := c.readResponse()
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	// Check for errors
-	if errMsg, ok := attrs["error"]; ok {
-		return fmt.Errorf("server error: %s", errMsg)
+	if err := responseError(attrs); err != nil {
+		return nil, err
 	}
 
+	return attrs, nil
+}
+
+// SetLang sets the language of returned names
+func (c *Client) SetLang(lang string) error {
+	if err := c.simpleCommand("lang", `"`+lang); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.lang = lang
+	c.mu.Unlock()
 	return nil
 }
 
-// RunInTerminal executes an application by ID in a terminal
-func (c *Client) RunInTerminal(id int64) error {
+// PersistSession asks the server to snapshot the session state (filters, language) and returns
+// the token to resume it with. Reconnect resumes it automatically.
+func (c *Client) PersistSession() (string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Send opt: terminal, id, run
-	if err := c.sendCommand("run", "opt: terminal", id); err != nil {
-		return fmt.Errorf("failed to send run command: %w", err)
+	if err := c.sendCommand("session", `"persist`); err != nil {
+		return "", fmt.Errorf("failed to send session command: %w", err)
+	}
+
+	attrs, _, err := c.readResponse()
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if err := responseError(attrs); err != nil {
+		return "", err
+	}
+
+	c.session = attrs["token"]
+	return c.session, nil
+}
+
+// ResumeSession restores the state persisted under token. It reports false when the server
+// doesn't know the token (expired or never persisted).
+func (c *Client) ResumeSession(token string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.sendCommand("session", `"resume`, `"`+token); err != nil {
+		return false, fmt.Errorf("failed to send session command: %w", err)
+	}
+
+	attrs, _, err := c.readResponse()
+	if err != nil {
+		return false, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if err := responseError(attrs); err != nil {
+		return false, err
+	}
+
+	if attrs["resumed"] != "t" {
+		c.session = ""
+		return false, nil
+	}
+	c.session = token
+	return true, nil
+}
+
+// Pin pins an application to the top of the list
+func (c *Client) Pin(id int64) error {
+	return c.simpleCommand("pin", id)
+}
+
+// Unpin removes the pin from an application
+func (c *Client) Unpin(id int64) error {
+	return c.simpleCommand("unpin", id)
+}
+
+// Nop sends a command the daemon acknowledges doing nothing, to check the connection or
+// keep it alive
+func (c *Client) Nop() error {
+	return c.simpleCommand("nop")
+}
+
+// simpleCommand sends a command and checks its response for errors
+func (c *Client) simpleCommand(cmdName string, args ...any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.sendCommand(cmdName, args...); err != nil {
+		return fmt.Errorf("failed to send %s command: %w", cmdName, err)
 	}
 
-	// Read response
 	attrs, _, err := c.readResponse()
 	if err != nil {
 		return fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// Check for errors
-	if errMsg, ok := attrs["error"]; ok {
-		return fmt.Errorf("server error: %s", errMsg)
+	if err := responseError(attrs); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// readResponse is a private method that returns parsed response
+// readResponse is a private method that returns parsed response. Heartbeats the server
+// sends on idle connections are skipped.
 func (c *Client) readResponse() (map[string]string, string, error) {
+	for {
+		attrs, body, err := c.readFrame()
+		if err != nil || !isHeartbeat(attrs) {
+			return attrs, body, err
+		}
+	}
+}
+
+// isHeartbeat reports whether a frame is a keep-alive heartbeat rather than a reply
+func isHeartbeat(attrs map[string]string) bool {
+	_, ok := attrs["heartbeat"]
+	return ok && len(attrs) == 1
+}
+
+// readFrame reads a single frame
+func (c *Client) readFrame() (map[string]string, string, error) {
+	if c.conn == nil {
+		return nil, "", ErrNotConnected
+	}
 	reader := c.reader
 
 	// Read header
 	header := make([]byte, 5)
 	_, err := io.ReadFull(reader, header)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read response header: %w", err)
+		return nil, "", fmt.Errorf("failed to read response header: %w", connError(err))
 	}
 
 	// Read attrs block and check for body: header
@@ -412,11 +937,9 @@ func (c *Client) readResponse() (map[string]string, string, error) {
 
 	for {
 		line, err := reader.ReadString('\n')
-		if err == io.EOF {
-			break
-		}
 		if err != nil {
-			return nil, "", fmt.Errorf("read error: %w", err)
+			// The reply ends with a blank line, EOF before it means the server went away
+			return nil, "", fmt.Errorf("read error: %w", connError(err))
 		}
 
 		// Check if this is the body: header
@@ -0,0 +1,272 @@
+package exe
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/client/exe/exetest"
+	"github.com/0xADE/ade-ctld/execline"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = Describe("ListPage", func() {
+	var (
+		srv    *exetest.Server
+		client *Client
+	)
+
+	BeforeEach(func() {
+		srv = exetest.NewServer()
+		srv.Handle("list", func(*parser.Command) *response.Response {
+			return response.New().Set("len", "3").Set("cursor", "page1").Body("1 Alpha", "2 Beta")
+		})
+		srv.Handle("list-next", func(cmd *parser.Command) *response.Response {
+			if cmd.Args[0].Str == "page1" {
+				return response.New().Set("len", "3").Set("offset", "2").Body("3 Gamma")
+			}
+			return response.New().Set("len", "3").Set("cursor-invalid", "t").Set("cursor", "page1").Body("1 Alpha", "2 Beta")
+		})
+		socket, err := srv.Start()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(srv.Close)
+
+		client, err = Dial(socket)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(client.Close)
+	})
+
+	It("should follow cursors to the last page", func() {
+		page, err := client.ListPage("")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(page).To(gomega.Equal(&Page{
+			Applications: []Application{{ID: 1, Name: "Alpha"}, {ID: 2, Name: "Beta"}},
+			Len:          3,
+			Cursor:       "page1",
+		}))
+
+		page, err = client.ListPage(page.Cursor)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(page.Applications).To(gomega.Equal([]Application{{ID: 3, Name: "Gamma"}}))
+		gomega.Expect(page.Cursor).To(gomega.BeEmpty())
+		gomega.Expect(page.Restarted).To(gomega.BeFalse())
+		gomega.Expect(srv.Received("list-next")).To(gomega.Equal([]exetest.Command{{Name: "list-next", Args: []any{"page1"}}}))
+	})
+
+	It("should report a restarted list", func() {
+		page, err := client.ListPage("stale")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(page.Restarted).To(gomega.BeTrue())
+		gomega.Expect(page.Applications).To(gomega.HaveLen(2))
+		gomega.Expect(page.Cursor).To(gomega.Equal("page1"))
+	})
+})
+
+var _ = Describe("ReadEvent", func() {
+	It("should return the events of a subscription", func() {
+		srv := exetest.NewServer()
+		socket, err := srv.Start()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(srv.Close)
+		client, err := Dial(socket)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		_, err = client.Exec("subscribe")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		srv.Emit(response.New().Set("heartbeat", "1714564800"))
+		srv.Emit(response.New().Set("event", "index-updated").Set("generation", "2").Set("changes", "3"))
+
+		event, err := client.ReadEvent()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(event.Attrs).To(gomega.Equal(map[string]string{"event": "index-updated", "generation": "2", "changes": "3"}))
+
+		gomega.Expect(client.Conn().Close()).To(gomega.Succeed())
+		_, err = client.ReadEvent()
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+})
+
+var _ = Describe("ReadResponse", func() {
+	// readResponse returns what ReadResponse prints of reply, a heartbeat before it
+	readResponse := func(reply string) string {
+		stdout := os.Stdout
+		r, w, err := os.Pipe()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		os.Stdout = w
+		DeferCleanup(func() { os.Stdout = stdout })
+
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		go func() {
+			defer serverConn.Close()
+			serverConn.Write([]byte("TXT01heartbeat: 1700000000\n\n\n" + reply))
+		}()
+		gomega.Expect(ReadResponse(clientConn)).To(gomega.Succeed())
+		w.Close()
+		out, err := io.ReadAll(r)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		return string(out)
+	}
+
+	It("should print the body of a reply", func() {
+		gomega.Expect(readResponse("TXT01cmd: list\nstatus: 0\nlen: 2\n\nbody:\n1 vim\n2 emacs\n\n\n")).
+			To(gomega.Equal("cmd: list\nstatus: 0\nlen: 2\n\nbody:\n1 vim\n2 emacs\n"))
+	})
+
+	It("should print the marker of an empty body", func() {
+		gomega.Expect(readResponse("TXT01cmd: list\nstatus: 0\nlen: 0\n\nbody:\n\n\n")).
+			To(gomega.Equal("cmd: list\nstatus: 0\nlen: 0\n\nbody:\n"))
+	})
+
+	It("should print replies without a body as they are", func() {
+		gomega.Expect(readResponse("TXT01cmd: pin\nstatus: 0\n\n\n")).To(gomega.Equal("cmd: pin\nstatus: 0\n"))
+	})
+})
+
+var _ = Describe("Nop", func() {
+	It("should round-trip without side effects", func() {
+		srv := exetest.NewServer(exetest.Application{ID: 1, Name: "viewer"})
+		socket, err := srv.Start()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(srv.Close)
+		client, err := Dial(socket)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(client.Close)
+
+		gomega.Expect(client.Nop()).To(gomega.Succeed())
+		gomega.Expect(srv.Received("nop")).To(gomega.HaveLen(1))
+		gomega.Expect(client.List()).To(gomega.HaveLen(1))
+	})
+})
+
+var _ = Describe("Alive", func() {
+	It("should tell a connection the daemon closed without sending anything", func() {
+		srv := exetest.NewServer(exetest.Application{ID: 1, Name: "viewer"})
+		socket, err := srv.Start()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(srv.Close)
+		client, err := Dial(socket)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(client.Close)
+
+		gomega.Expect(client.Alive()).To(gomega.BeTrue())
+		gomega.Expect(client.List()).To(gomega.HaveLen(1))
+		gomega.Expect(client.Alive()).To(gomega.BeTrue())
+
+		srv.Disconnect()
+		gomega.Eventually(client.Alive).Should(gomega.BeFalse())
+		gomega.Expect(client.Reconnect()).To(gomega.Succeed())
+		gomega.Expect(client.Alive()).To(gomega.BeTrue())
+		gomega.Expect(client.List()).To(gomega.HaveLen(1))
+		gomega.Expect(srv.Received("list")).To(gomega.HaveLen(2))
+	})
+
+	It("should be false once closed", func() {
+		srv := exetest.NewServer()
+		socket, err := srv.Start()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(srv.Close)
+		client, err := Dial(socket)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Expect(client.Close()).To(gomega.Succeed())
+		gomega.Expect(client.Alive()).To(gomega.BeFalse())
+	})
+})
+
+var _ = Describe("AddFilterName", func() {
+	var (
+		srv    *exetest.Server
+		client *Client
+	)
+
+	BeforeEach(func() {
+		srv = exetest.NewServer(exetest.Application{ID: 1, Name: "Firefox"}, exetest.Application{ID: 2, Name: "Terminal"})
+		socket, err := srv.Start()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(srv.Close)
+		client, err = Dial(socket)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(client.Close)
+	})
+
+	It("should return the number of name filters set", func() {
+		gomega.Expect(client.AddFilterName("fire")).To(gomega.Equal(1))
+		gomega.Expect(client.AddFilterName("term", "or")).To(gomega.Equal(2))
+		gomega.Expect(srv.Received("+filter-name")[1].Args).To(gomega.Equal([]any{"term", "or"}))
+		gomega.Expect(client.List()).To(gomega.HaveLen(2))
+	})
+
+	It("should surface the refusal of a filter without values", func() {
+		_, err := client.AddFilterName()
+		gomega.Expect(errors.Is(err, &ServerError{Code: response.StatusBadArgument})).To(gomega.BeTrue())
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("no values")))
+	})
+
+	It("should return -1 when the daemon doesn't tell", func() {
+		srv.Handle("+filter-cat", func(*parser.Command) *response.Response {
+			return response.New().Set("cmd", "+filter-cat").Set("status", "0")
+		})
+		gomega.Expect(client.AddFilterCat("Game")).To(gomega.Equal(-1))
+	})
+})
+
+var _ = Describe("RunWith", func() {
+	var (
+		srv    *exetest.Server
+		client *Client
+	)
+
+	BeforeEach(func() {
+		srv = exetest.NewServer(exetest.Application{ID: 1, Name: "viewer"})
+		socket, err := srv.Start()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(srv.Close)
+
+		client, err = Dial(socket)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(client.Close)
+	})
+
+	It("should send the options and the args quoted for the server to split", func() {
+		nice := 5
+		args := []string{"--title", "My \"Pictures\"", "", `c:\dir`, "$HOME"}
+		gomega.Expect(client.RunWith(1, RunOptions{Terminal: true, Nice: &nice, File: "/tmp/a b.png", Args: args})).To(gomega.Succeed())
+
+		received := srv.Received("run")
+		gomega.Expect(received).To(gomega.HaveLen(1))
+		sent := received[0].Args
+		gomega.Expect(sent).To(gomega.HaveLen(5))
+		gomega.Expect(sent[:3]).To(gomega.Equal([]any{"opt: terminal", "opt: nice=5", "file: /tmp/a b.png"}))
+		gomega.Expect(sent[4]).To(gomega.Equal(int64(1)))
+		line, ok := strings.CutPrefix(sent[3].(string), "args: ")
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(execline.Split(line)).To(gomega.Equal(args))
+	})
+
+	It("should request the action and return the pid", func() {
+		srv.SetApplications(exetest.Application{ID: 1, Name: "Firefox", Actions: []string{"new-window", "new-private-window"}})
+		pid, err := client.RunAction(1, "new-private-window")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(pid).To(gomega.BeNumerically(">", 0))
+		gomega.Expect(srv.Received("run")).To(gomega.Equal([]exetest.Command{{Name: "run", Args: []any{"action: new-private-window", int64(1)}}}))
+
+		_, err = client.RunAction(1, "new-tab")
+		gomega.Expect(errors.Is(err, &ServerError{Code: response.StatusNotFound})).To(gomega.BeTrue())
+		_, err = client.RunAction(1, "new\nwindow")
+		gomega.Expect(err).To(gomega.MatchError(execline.ErrNewline))
+		gomega.Expect(srv.Received("run")).To(gomega.HaveLen(2))
+	})
+
+	It("should refuse args holding a line break without sending them", func() {
+		gomega.Expect(client.RunWith(1, RunOptions{Args: []string{"a\nb"}})).To(gomega.MatchError(execline.ErrNewline))
+		gomega.Expect(client.RunWith(1, RunOptions{File: "/tmp/a\nb"})).To(gomega.MatchError(execline.ErrNewline))
+		gomega.Expect(srv.Received("run")).To(gomega.BeEmpty())
+	})
+})
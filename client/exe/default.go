@@ -0,0 +1,203 @@
+package exe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// The package-level functions are for scripts doing one thing or a few: each runs a single
+// operation on the client returned by Default, connecting on first use and reconnecting once
+// when the daemon went away since the last call, e.g. because it was restarted.
+//
+//	if err := exe.RunByName(ctx, "firefox"); err != nil {
+//		log.Fatal(err)
+//	}
+
+// DefaultTimeout bounds the package-level functions called with a context without deadline
+const DefaultTimeout = 10 * time.Second
+
+// listRestarts is the number of list restarts List tolerates, for indexes rebuilt meanwhile
+const listRestarts = 3
+
+var (
+	defaultMu     sync.Mutex // serializes the package-level functions
+	defaultClient *Client
+	defaultErr    error // why the socket of the default client couldn't be determined
+)
+
+// ErrNoApplication is returned by RunByName when no application has the name asked for
+var ErrNoApplication = errors.New("no application with that name")
+
+// Default returns the client behind the package-level functions, for the socket at
+// ADE_INDEXD_SOCK or the default socket of the user. It's created on first use, connects
+// lazily and is kept for the life of the process. Its methods can be used directly too,
+// but don't reconnect by themselves; the package-level functions can be called from several
+// goroutines and run one at a time.
+func Default() *Client {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	client, _ := lockedDefault()
+	return client
+}
+
+// lockedDefault is Default with defaultMu held
+func lockedDefault() (*Client, error) {
+	if defaultClient == nil {
+		socket, err := getSocketPath()
+		defaultClient, defaultErr = &Client{socket: socket}, err
+	}
+	return defaultClient, defaultErr
+}
+
+// List returns every application the daemon lists, following pages. A list restarted
+// because the index was rebuilt is read again from the first page.
+func List(ctx context.Context) ([]Application, error) {
+	return withDefault(ctx, true, func(c *Client) ([]Application, error) {
+		var apps []Application
+		page, err := c.ListPage("")
+		for restarts := 0; err == nil; page, err = c.ListPage(page.Cursor) {
+			if page.Restarted {
+				if restarts++; restarts > listRestarts {
+					return nil, fmt.Errorf("index rebuilt %d times while listing it", restarts)
+				}
+				apps = apps[:0]
+			}
+			apps = append(apps, page.Applications...)
+			if page.Cursor == "" {
+				return apps, nil
+			}
+		}
+		return nil, err
+	})
+}
+
+// Search returns the applications matching query, best matches first, at most limit of them
+// or the server default for 0
+func Search(ctx context.Context, query string, limit int) ([]Application, error) {
+	return withDefault(ctx, true, func(c *Client) ([]Application, error) {
+		return c.Search(query, limit)
+	})
+}
+
+// Run launches the application with the given ID
+func Run(ctx context.Context, id int64) error {
+	_, err := withDefault(ctx, false, func(c *Client) (struct{}, error) {
+		return struct{}{}, c.Run(id)
+	})
+	return err
+}
+
+// RunByName launches the application named name, ignoring case, like "firefox". Of several
+// applications of that name the best ranked by search is launched. ErrNoApplication is
+// returned when none has it.
+func RunByName(ctx context.Context, name string) error {
+	_, err := withDefault(ctx, false, func(c *Client) (struct{}, error) {
+		apps, err := c.Search(name, 0)
+		if err != nil {
+			return struct{}{}, err
+		}
+		for _, app := range apps {
+			if strings.EqualFold(app.Name, name) {
+				return struct{}{}, c.Run(app.ID)
+			}
+		}
+		return struct{}{}, fmt.Errorf("%w: %q", ErrNoApplication, name)
+	})
+	return err
+}
+
+// withDefault runs op on the default client within the deadline of ctx, DefaultTimeout if
+// it has none. The client is connected first if it isn't. When the daemon went away since
+// the last call, idempotent operations are retried once on a new connection; others are
+// preceded by a nop on a connection kept from an earlier call, so that nothing is sent twice.
+// A connection interrupted by ctx or the timeout is dropped, the reply may still come.
+func withDefault[T any](ctx context.Context, idempotent bool, op func(*Client) (T, error)) (T, error) {
+	var zero T
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	c, err := lockedDefault()
+	if err != nil {
+		return zero, fmt.Errorf("failed to get socket path: %w", err)
+	}
+	if ctx.Err() != nil {
+		return zero, ctx.Err()
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	for attempt := 0; ; attempt++ {
+		c.mu.Lock()
+		reused := c.conn != nil
+		c.mu.Unlock()
+		if !reused {
+			if err := c.Reconnect(); err != nil {
+				// A daemon restarting may close the connection it just accepted. Nothing
+				// of op was sent yet, so any operation can be tried again.
+				if attempt == 0 && errors.Is(err, ErrServerClosed) {
+					c.Close()
+					continue
+				}
+				return zero, err
+			}
+		}
+
+		result, err := within(ctx, c, func() (T, error) {
+			if reused && !idempotent {
+				// Older daemons don't know nop, any reply will do
+				if err := c.Nop(); err != nil && !errors.Is(err, &ServerError{Code: response.StatusUnknownCommand}) {
+					return zero, err
+				}
+				reused = false
+			}
+			return op(c)
+		})
+		gone := errors.Is(err, ErrServerClosed) || errors.Is(err, ErrNotConnected)
+		if gone {
+			c.Close()
+		}
+		// A reused connection may have been closed by a daemon since restarted: once the
+		// operation itself was sent, only idempotent ones are tried again
+		if gone && attempt == 0 && (idempotent || reused) {
+			continue
+		}
+		return result, err
+	}
+}
+
+// within runs op with the connection of c interrupted at the deadline of ctx or when ctx is
+// done, and drops the connection if it was
+func within[T any](ctx context.Context, c *Client, op func() (T, error)) (T, error) {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		var zero T
+		return zero, ErrNotConnected
+	}
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	result, err := op()
+	interrupted := !stop() || !time.Now().Before(deadline)
+	conn.SetDeadline(time.Time{})
+	if interrupted && err != nil {
+		c.Close()
+		// The connection may time out a little before ctx does
+		if ctx.Err() == nil {
+			return result, context.DeadlineExceeded
+		}
+		return result, ctx.Err()
+	}
+	return result, err
+}
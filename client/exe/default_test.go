@@ -0,0 +1,195 @@
+package exe
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/0xADE/ade-ctld/client/exe/exetest"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = Describe("Default", func() {
+	var srv *exetest.Server
+
+	BeforeEach(func() {
+		srv = exetest.NewServer(
+			exetest.Application{ID: 1, Name: "Firefox"},
+			exetest.Application{ID: 2, Name: "Firefox Nightly"},
+			exetest.Application{ID: 3, Name: "Terminal"},
+		)
+		socket, err := srv.Start()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(srv.Close)
+
+		GinkgoT().Setenv("ADE_INDEXD_SOCK", socket)
+		resetDefault()
+		DeferCleanup(resetDefault)
+	})
+
+	// conn returns the connection of the default client, nil when it has none
+	conn := func() any {
+		c := Default()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.conn == nil {
+			return nil
+		}
+		return c.conn
+	}
+
+	It("should list, search and run with one connection kept between calls", func() {
+		ctx := context.Background()
+		gomega.Expect(conn()).To(gomega.BeNil())
+
+		apps, err := List(ctx)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(apps).To(gomega.HaveLen(3))
+		first := conn()
+		gomega.Expect(first).NotTo(gomega.BeNil())
+
+		apps, err = Search(ctx, "term", 0)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(apps).To(gomega.Equal([]Application{{ID: 3, Name: "Terminal"}}))
+		gomega.Expect(Run(ctx, 3)).To(gomega.Succeed())
+		gomega.Expect(conn()).To(gomega.BeIdenticalTo(first))
+		gomega.Expect(srv.Received("run")).To(gomega.Equal([]exetest.Command{{Name: "run", Args: []any{int64(3)}}}))
+	})
+
+	It("should follow the pages of the list and start over when restarted", func() {
+		restarted := false
+		srv.Handle("list", func(*parser.Command) *response.Response {
+			return response.New().Set("len", "3").Set("cursor", "page1").Body("1 Alpha", "2 Beta")
+		})
+		srv.Handle("list-next", func(*parser.Command) *response.Response {
+			if !restarted {
+				restarted = true
+				return response.New().Set("len", "3").Set("cursor-invalid", "t").Set("cursor", "page1").Body("1 Alpha", "2 Beta")
+			}
+			return response.New().Set("len", "3").Set("offset", "2").Body("3 Gamma")
+		})
+
+		apps, err := List(context.Background())
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(apps).To(gomega.Equal([]Application{{ID: 1, Name: "Alpha"}, {ID: 2, Name: "Beta"}, {ID: 3, Name: "Gamma"}}))
+	})
+
+	It("should run the application of the name asked for, ignoring case", func() {
+		gomega.Expect(RunByName(context.Background(), "FIREFOX")).To(gomega.Succeed())
+		gomega.Expect(srv.Received("run")).To(gomega.Equal([]exetest.Command{{Name: "run", Args: []any{int64(1)}}}))
+
+		err := RunByName(context.Background(), "fire")
+		gomega.Expect(errors.Is(err, ErrNoApplication)).To(gomega.BeTrue())
+		gomega.Expect(srv.Received("run")).To(gomega.HaveLen(1))
+	})
+
+	It("should return the typed errors of the client", func() {
+		err := Run(context.Background(), 42)
+		gomega.Expect(errors.Is(err, &ServerError{Code: response.StatusNotFound})).To(gomega.BeTrue())
+
+		srv.Close()
+		resetDefault()
+		_, err = List(context.Background())
+		gomega.Expect(errors.Is(err, ErrNotConnected)).To(gomega.BeTrue())
+	})
+
+	It("should reconnect when the daemon was restarted between calls", func() {
+		ctx := context.Background()
+		_, err := Search(ctx, "term", 0)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		first := conn()
+
+		srv.Disconnect()
+		apps, err := Search(ctx, "term", 0)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(apps).To(gomega.HaveLen(1))
+		gomega.Expect(conn()).NotTo(gomega.BeIdenticalTo(first))
+
+		srv.Disconnect()
+		srv.Reset()
+		gomega.Expect(Run(ctx, 3)).To(gomega.Succeed())
+		// The nop found the connection gone, so run went out once, on the new one
+		gomega.Expect(srv.Received("run")).To(gomega.HaveLen(1))
+		gomega.Expect(srv.Received("nop")).To(gomega.BeEmpty())
+	})
+
+	It("should probe a kept connection with nop before running, even on older daemons", func() {
+		ctx := context.Background()
+		gomega.Expect(Run(ctx, 1)).To(gomega.Succeed())
+		gomega.Expect(Run(ctx, 2)).To(gomega.Succeed())
+		gomega.Expect(srv.Received("nop")).To(gomega.HaveLen(1))
+
+		srv.Handle("nop", nil)
+		gomega.Expect(Run(ctx, 3)).To(gomega.Succeed())
+		gomega.Expect(srv.Received("run")).To(gomega.HaveLen(3))
+	})
+
+	It("should be safe to call from several goroutines, across a restart", func() {
+		ctx := context.Background()
+		var wg sync.WaitGroup
+		errs := make(chan error, 30)
+		for i := range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if i == 5 {
+					// Lists and searches cut short by the restart are sent again
+					srv.Disconnect()
+				}
+				_, err := List(ctx)
+				errs <- err
+				_, err = Search(ctx, "fire", 0)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- Run(ctx, 1)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		}
+		gomega.Expect(srv.Received("run")).To(gomega.HaveLen(10))
+	})
+
+	It("should give up at the deadline and drop the connection", func() {
+		ctx := context.Background()
+		gomega.Expect(Run(ctx, 1)).To(gomega.Succeed())
+
+		srv.SetLatency(time.Second)
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err := Search(timeout, "fire", 0)
+		gomega.Expect(err).To(gomega.MatchError(context.DeadlineExceeded))
+		gomega.Expect(conn()).To(gomega.BeNil())
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		gomega.Expect(Run(canceled, 1)).To(gomega.MatchError(context.Canceled))
+
+		srv.SetLatency(0)
+		gomega.Expect(Run(ctx, 1)).To(gomega.Succeed())
+	})
+})
+
+// resetDefault closes and forgets the default client, for the next one to read
+// ADE_INDEXD_SOCK again
+func resetDefault() {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultClient != nil {
+		defaultClient.Close()
+	}
+	defaultClient, defaultErr = nil, nil
+}
@@ -0,0 +1,75 @@
+package exe
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+
+	"github.com/0xADE/ade-ctld/response"
+)
+
+var (
+	// ErrNotConnected is returned when the client has no connection: the server couldn't be
+	// dialed or the client was closed. Dial failures also wrap the underlying net error.
+	ErrNotConnected = errors.New("not connected")
+	// ErrServerClosed is returned when the server went away while a command was sent or its
+	// reply was read. The underlying error (EOF, EPIPE, ECONNRESET) is wrapped too.
+	ErrServerClosed = errors.New("server closed the connection")
+)
+
+// ServerError is an error reply of the server
+type ServerError struct {
+	Code    response.Status // Numeric status of the reply
+	Command string          // Command that failed, as echoed by the server
+	Type    string          // Short error, e.g. "index not found"
+	Desc    string          // Human readable description
+}
+
+func (e *ServerError) Error() string {
+	msg := fmt.Sprintf("server error: %s: %s (status %d)", e.Command, e.Type, e.Code)
+	if e.Desc != "" {
+		msg += ": " + e.Desc
+	}
+	return msg
+}
+
+// Is matches another *ServerError by status code, so callers can test for a kind of failure:
+//
+//	errors.Is(err, &exe.ServerError{Code: response.StatusNotFound})
+func (e *ServerError) Is(target error) bool {
+	t, ok := target.(*ServerError)
+	return ok && t.Code == e.Code
+}
+
+// responseError returns the *ServerError of an error reply, nil for successful replies
+func responseError(attrs map[string]string) error {
+	errType, ok := attrs["error"]
+	if !ok {
+		return nil
+	}
+	code := response.StatusInternal
+	if n, err := strconv.Atoi(attrs["status"]); err == nil && n != 0 {
+		code = response.Status(n)
+	}
+	return &ServerError{
+		Code:    code,
+		Command: attrs["error-cmd"],
+		Type:    errType,
+		Desc:    attrs["desc"],
+	}
+}
+
+// connError classifies a failed read or write on the connection
+func connError(err error) error {
+	switch {
+	case errors.Is(err, net.ErrClosed):
+		return fmt.Errorf("%w: %w", ErrNotConnected, err)
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNRESET):
+		return fmt.Errorf("%w: %w", ErrServerClosed, err)
+	}
+	return err
+}
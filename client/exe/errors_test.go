@@ -0,0 +1,209 @@
+package exe
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/0xADE/ade-ctld/client/exe/exetest"
+	"github.com/0xADE/ade-ctld/response"
+
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+// fakeServer accepts one client and answers every command with the reply returned by respond.
+// A reply without the terminating blank line is cut short: the connection is closed after it.
+func fakeServer(socketPath string, respond func(cmd string) string) net.Listener {
+	listener, err := net.Listen("unix", socketPath)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		if _, err := io.ReadFull(reader, make([]byte, 5)); err != nil {
+			return
+		}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			// Arguments are strings, numbers or operators, the command ends the request
+			if strings.HasPrefix(line, `"`) || strings.Trim(line, "0123456789") == "" {
+				continue
+			}
+			reply := respond(line)
+			if _, err := io.WriteString(conn, reply); err != nil || !strings.HasSuffix(reply, "\n\n\n") {
+				return
+			}
+		}
+	}()
+	return listener
+}
+
+var _ = Describe("Client errors", func() {
+	var (
+		tmpDir     string
+		socketPath string
+		oldSock    string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-client-test-*")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		socketPath = filepath.Join(tmpDir, "indexd")
+		oldSock = os.Getenv("ADE_INDEXD_SOCK")
+		os.Setenv("ADE_INDEXD_SOCK", socketPath)
+	})
+
+	AfterEach(func() {
+		os.Setenv("ADE_INDEXD_SOCK", oldSock)
+		os.RemoveAll(tmpDir)
+	})
+
+	// startMock serves apps on the socket NewClient connects to
+	startMock := func(apps ...exetest.Application) *exetest.Server {
+		srv := exetest.NewServer(apps...)
+		socket, err := srv.Start()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		DeferCleanup(srv.Close)
+		os.Setenv("ADE_INDEXD_SOCK", socket)
+		return srv
+	}
+
+	It("should return a not found ServerError for an unknown id", func() {
+		srv := startMock()
+
+		client, err := NewClient()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		defer client.Close()
+
+		err = client.Run(42)
+		var serverErr *ServerError
+		gomega.Expect(errors.As(err, &serverErr)).To(gomega.BeTrue())
+		gomega.Expect(serverErr.Code).To(gomega.Equal(response.StatusNotFound))
+		gomega.Expect(serverErr.Command).To(gomega.Equal("run"))
+		gomega.Expect(serverErr.Type).To(gomega.Equal("index not found"))
+		gomega.Expect(serverErr.Desc).To(gomega.Equal("Requested index not found."))
+		gomega.Expect(errors.Is(err, &ServerError{Code: response.StatusNotFound})).To(gomega.BeTrue())
+		gomega.Expect(errors.Is(err, &ServerError{Code: response.StatusBadArgument})).To(gomega.BeFalse())
+		gomega.Expect(srv.Received("run")).To(gomega.Equal([]exetest.Command{{Name: "run", Args: []any{int64(42)}}}))
+	})
+
+	It("should return a bad argument ServerError and keep the connection usable", func() {
+		srv := startMock(exetest.Application{ID: 1, Name: "vim"})
+		srv.Fail("lang", response.StatusBadArgument, "missing locale", "lang command requires a locale")
+
+		client, err := NewClient()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		defer client.Close()
+
+		err = client.SetLang("")
+		gomega.Expect(errors.Is(err, &ServerError{Code: response.StatusBadArgument})).To(gomega.BeTrue())
+		gomega.Expect(err.Error()).To(gomega.ContainSubstring("missing locale"))
+
+		gomega.Expect(client.Pin(1)).To(gomega.Succeed())
+	})
+
+	It("should wrap connection refused in ErrNotConnected", func() {
+		listener, err := net.Listen("unix", socketPath)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		// Leave the socket file behind without anybody accepting on it
+		listener.(*net.UnixListener).SetUnlinkOnClose(false)
+		listener.Close()
+
+		_, err = NewClient()
+		gomega.Expect(errors.Is(err, ErrNotConnected)).To(gomega.BeTrue())
+		gomega.Expect(errors.Is(err, syscall.ECONNREFUSED)).To(gomega.BeTrue())
+	})
+
+	It("should return ErrServerClosed when the server goes away mid-reply", func() {
+		listener := fakeServer(socketPath, func(string) string {
+			return "TXT01cmd: list\nstatus: 0\nlen: 3\n\nbody:\n1 vim\n"
+		})
+		defer listener.Close()
+
+		client, err := NewClient()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		defer client.Close()
+
+		_, err = client.List()
+		gomega.Expect(errors.Is(err, ErrServerClosed)).To(gomega.BeTrue())
+		gomega.Expect(errors.Is(err, io.EOF)).To(gomega.BeTrue())
+		var serverErr *ServerError
+		gomega.Expect(errors.As(err, &serverErr)).To(gomega.BeFalse())
+	})
+
+	It("should skip heartbeats sent before a reply", func() {
+		listener := fakeServer(socketPath, func(cmd string) string {
+			return "TXT01heartbeat: 1700000000\n\n\nTXT01heartbeat: 1700000030\n\n\n" +
+				"TXT01error-cmd: " + cmd + "\nerror: index not found\nstatus: 3\n\n\n"
+		})
+		defer listener.Close()
+
+		client, err := NewClient()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		defer client.Close()
+
+		err = client.Pin(7)
+		gomega.Expect(errors.Is(err, &ServerError{Code: response.StatusNotFound})).To(gomega.BeTrue())
+	})
+
+	It("should fetch capabilities once and report supported features", func() {
+		srv := startMock()
+
+		client, err := NewClient()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		defer client.Close()
+
+		gomega.Expect(client.Supports("events")).To(gomega.BeTrue())
+		gomega.Expect(client.Supports("subscribe")).To(gomega.BeTrue())
+		gomega.Expect(client.Supports("policy")).To(gomega.BeFalse())
+		caps, err := client.Capabilities()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(caps.Protocols).To(gomega.Equal([]string{"TXT01"}))
+		gomega.Expect(caps.Features).To(gomega.ContainElements("events", "sessions"))
+		gomega.Expect(caps.Commands).To(gomega.ContainElements("list", "subscribe"))
+		gomega.Expect(caps.ListLimit).To(gomega.Equal(128))
+		gomega.Expect(srv.Received("capabilities")).To(gomega.HaveLen(1))
+	})
+
+	It("should report nothing supported by daemons without capabilities", func() {
+		srv := startMock()
+		srv.Handle("capabilities", nil)
+
+		client, err := NewClient()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		defer client.Close()
+
+		gomega.Expect(client.Supports("events")).To(gomega.BeFalse())
+		caps, err := client.Capabilities()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(caps.Commands).To(gomega.BeEmpty())
+	})
+
+	It("should return ErrNotConnected once closed", func() {
+		startMock()
+
+		client, err := NewClient()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(client.Close()).To(gomega.Succeed())
+
+		gomega.Expect(client.Pin(1)).To(gomega.MatchError(ErrNotConnected))
+		_, err = client.List()
+		gomega.Expect(errors.Is(err, ErrNotConnected)).To(gomega.BeTrue())
+	})
+})
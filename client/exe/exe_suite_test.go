@@ -0,0 +1,13 @@
+package exe
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+func TestExe(t *testing.T) {
+	gomega.RegisterFailHandler(Fail)
+	RunSpecs(t, "Exe Client Suite")
+}
@@ -0,0 +1,89 @@
+package exetest_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+	"github.com/0xADE/ade-ctld/client/exe/exetest"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// A launcher tested against the mock instead of a running daemon
+func Example() {
+	srv := exetest.NewServer(
+		exetest.Application{ID: 1, Name: "Firefox"},
+		exetest.Application{ID: 2, Name: "Terminal"},
+	)
+	socket, err := srv.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Close()
+
+	client, err := exe.Dial(socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	client.SetFilterName("term")
+	apps, _ := client.List()
+	for _, app := range apps {
+		client.Run(app.ID)
+	}
+
+	for _, cmd := range srv.Commands() {
+		fmt.Println(cmd.Name, cmd.Args)
+	}
+	// Output:
+	// filter-name [term]
+	// list []
+	// run [2]
+}
+
+// Checking how a frontend copes with a failing daemon
+func ExampleServer_Fail() {
+	srv := exetest.NewServer(exetest.Application{ID: 1, Name: "Firefox"})
+	socket, err := srv.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Close()
+
+	client, err := exe.Dial(socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	srv.Fail("run", response.StatusInternal, "exec failed", "permission denied")
+	fmt.Println(client.Run(1))
+	srv.Recover("run")
+	fmt.Println(client.Run(1))
+	// Output:
+	// server error: run: exec failed (status 1): permission denied
+	// <nil>
+}
+
+// A script launching firefox: the package-level functions find the daemon through
+// ADE_INDEXD_SOCK, pointed at the mock here
+func ExampleServer_runByName() {
+	srv := exetest.NewServer(exetest.Application{ID: 1, Name: "Firefox"})
+	socket, err := srv.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Close()
+	os.Setenv("ADE_INDEXD_SOCK", socket)
+
+	if err := exe.RunByName(context.Background(), "firefox"); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(srv.Received("run"))
+	// Output:
+	// [{run [1]}]
+}
@@ -0,0 +1,13 @@
+package exetest_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestExetest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Exetest Suite")
+}
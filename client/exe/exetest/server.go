@@ -0,0 +1,586 @@
+// Package exetest provides an in-memory stand-in for ade-exe-ctld, so code built on
+// client/exe can be tested without the daemon. It reads requests with the daemon's own
+// parser and writes replies with its response package, so the framing is the real one.
+//
+//	srv := exetest.NewServer(exetest.Application{ID: 1, Name: "Firefox"})
+//	socket, err := srv.Start()
+//	...
+//	defer srv.Close()
+//	client, err := exe.Dial(socket)
+package exetest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// Application is an entry served by the mock
+type Application struct {
+	ID      int64
+	Name    string
+	Actions []string // Keys of its desktop actions, like "new-private-window"
+}
+
+// Op is a boolean operator argument: "or", "and" or "not"
+type Op string
+
+// Command is a command the server received. Arguments are string for strings, int64 for
+// integers, bool for t and f, and Op for operators.
+type Command struct {
+	Name string
+	Args []any
+}
+
+// Handler answers a command. The request is the one parsed by the daemon's parser.
+type Handler func(cmd *parser.Command) *response.Response
+
+// Server is a mock daemon serving a fixed set of applications. Besides the built-in
+// commands (list, search, filter-name, +filter-name, 0filters, run, pin, unpin, info, lang,
+// session, subscribe, unsubscribe and capabilities) it answers whatever Handle registers;
+// other commands get the unknown command error of the daemon.
+type Server struct {
+	mu       sync.Mutex
+	apps     []Application
+	handlers map[string]Handler
+	failures map[string]*response.Response
+	latency  time.Duration
+	received []Command
+
+	// State of the built-in commands, shared by all connections like in the daemon
+	nameFilters []string
+	nameExprs   int // filter-name and +filter-name commands the terms come from
+	lang        string
+	pins        map[int64]bool
+	sessions    map[string]session
+	pid         int
+
+	listener net.Listener
+	dir      string
+	conns    map[*conn]bool // connections, true when subscribed
+	wg       sync.WaitGroup
+}
+
+type session struct {
+	nameFilters []string
+	nameExprs   int
+	lang        string
+}
+
+type conn struct {
+	net.Conn
+	mu sync.Mutex // serializes replies and events
+}
+
+func (c *conn) write(r *response.Response) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := r.WriteTo(c.Conn)
+	return err
+}
+
+// NewServer creates a mock daemon serving apps
+func NewServer(apps ...Application) *Server {
+	s := &Server{
+		apps:     slices.Clone(apps),
+		failures: make(map[string]*response.Response),
+		pins:     make(map[int64]bool),
+		sessions: make(map[string]session),
+		conns:    make(map[*conn]bool),
+		pid:      10000,
+	}
+	s.handlers = map[string]Handler{
+		"list":         s.list,
+		"search":       s.search,
+		"filter-name":  s.filterName,
+		"+filter-name": s.filterName,
+		"0filters":     s.resetFilters,
+		"run":          s.run,
+		"pin":          s.pin,
+		"unpin":        s.pin,
+		"info":         s.info,
+		"lang":         s.setLang,
+		"session":      s.session,
+		"subscribe":    s.subscribe,
+		"unsubscribe":  s.subscribe,
+		"capabilities": s.capabilities,
+		"nop":          s.nop,
+	}
+	return s
+}
+
+// Start listens on a socket in a new temporary directory and returns its path
+func (s *Server) Start() (string, error) {
+	dir, err := os.MkdirTemp("", "exetest-*")
+	if err != nil {
+		return "", err
+	}
+	socketPath := filepath.Join(dir, "indexd")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.dir = dir
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.accept(listener)
+	return socketPath, nil
+}
+
+// Close stops the server, closing every connection
+func (s *Server) Close() error {
+	s.mu.Lock()
+	listener, dir := s.listener, s.dir
+	s.listener = nil
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	if listener == nil {
+		return nil
+	}
+	err := listener.Close()
+	s.wg.Wait()
+	os.RemoveAll(dir)
+	return err
+}
+
+// Disconnect closes every connection and keeps accepting new ones, like a daemon restarted
+// on the same socket
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// SetApplications replaces the applications served
+func (s *Server) SetApplications(apps ...Application) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apps = slices.Clone(apps)
+}
+
+// SetLatency delays every reply by d
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Handle makes h answer the command name instead of the built-in handler. A nil h removes
+// the command, so the server behaves like a daemon predating it.
+func (s *Server) Handle(name string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h == nil {
+		delete(s.handlers, name)
+		return
+	}
+	s.handlers[name] = h
+}
+
+// Fail makes every following name command fail with the given error reply, until Recover
+func (s *Server) Fail(name string, status response.Status, errType, desc string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[name] = response.Error(name, status, errType, desc)
+}
+
+// Recover undoes Fail for the command name
+func (s *Server) Recover(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, name)
+}
+
+// Emit sends an event to every subscribed connection, e.g.
+//
+//	srv.Emit(response.New().Set("event", "index-updated").Set("generation", "2"))
+func (s *Server) Emit(event *response.Response) {
+	s.mu.Lock()
+	var subscribers []*conn
+	for c, subscribed := range s.conns {
+		if subscribed {
+			subscribers = append(subscribers, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range subscribers {
+		c.write(event)
+	}
+}
+
+// Commands returns every command received so far, in order
+func (s *Server) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.received)
+}
+
+// Received returns the commands named name received so far, in order
+func (s *Server) Received(name string) []Command {
+	var result []Command
+	for _, cmd := range s.Commands() {
+		if cmd.Name == name {
+			result = append(result, cmd)
+		}
+	}
+	return result
+}
+
+// Reset forgets the commands received so far
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = nil
+}
+
+func (s *Server) accept(listener net.Listener) {
+	defer s.wg.Done()
+	for {
+		raw, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{Conn: raw}
+		s.mu.Lock()
+		if s.listener == nil {
+			s.mu.Unlock()
+			raw.Close()
+			return
+		}
+		s.conns[c] = false
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c *conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	p, err := parser.NewParser(c)
+	if err != nil {
+		c.write(response.Error("parser", response.StatusParseError, "invalid header", err.Error()))
+		return
+	}
+	for {
+		cmd, err := p.ParseCommand()
+		if errors.Is(err, parser.ErrSyntax) {
+			c.write(response.Error("parser", response.StatusParseError, "parse error", err.Error()))
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		reply := s.execute(c, cmd)
+		if err := c.write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// execute records a command and builds its reply
+func (s *Server) execute(c *conn, cmd *parser.Command) *response.Response {
+	s.mu.Lock()
+	s.received = append(s.received, Command{Name: cmd.Name, Args: goArgs(cmd.Args)})
+	latency := s.latency
+	failure, failing := s.failures[cmd.Name]
+	handler := s.handlers[cmd.Name]
+	if cmd.Name == "subscribe" || cmd.Name == "unsubscribe" {
+		if _, ok := s.conns[c]; ok && handler != nil && !failing {
+			s.conns[c] = cmd.Name == "subscribe"
+		}
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	switch {
+	case failing:
+		return failure
+	case handler == nil:
+		return response.Error(cmd.Name, response.StatusUnknownCommand, "unknown command", "Command not recognized")
+	}
+	return handler(cmd)
+}
+
+// goArgs converts parsed arguments to the values recorded in Command
+func goArgs(args []parser.Value) []any {
+	result := make([]any, 0, len(args))
+	for _, arg := range args {
+		switch {
+		case arg.Type == parser.TypeString:
+			result = append(result, arg.Str)
+		case arg.Type == parser.TypeInt:
+			result = append(result, arg.Int)
+		case arg.Str != "":
+			result = append(result, Op(arg.Str))
+		default:
+			result = append(result, arg.Bool)
+		}
+	}
+	return result
+}
+
+// matching returns the applications whose name contains any of filters, all without filters
+func (s *Server) matching(filters []string) []Application {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(filters) == 0 {
+		return slices.Clone(s.apps)
+	}
+	var result []Application
+	for _, app := range s.apps {
+		name := strings.ToLower(app.Name)
+		for _, filter := range filters {
+			if strings.Contains(name, strings.ToLower(filter)) {
+				result = append(result, app)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// lookup returns the application with the id of the first argument
+func (s *Server) lookup(cmd *parser.Command) (Application, *response.Response) {
+	var id int64 = -1
+	for _, arg := range cmd.Args {
+		if arg.Type == parser.TypeInt {
+			id = arg.Int
+		}
+	}
+	if id < 0 {
+		return Application{}, response.Error(cmd.Name, response.StatusBadArgument, "missing id", cmd.Name+" command requires an id parameter")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, app := range s.apps {
+		if app.ID == id {
+			return app, nil
+		}
+	}
+	return Application{}, response.Error(cmd.Name, response.StatusNotFound, "index not found", "Requested index not found.")
+}
+
+func appLines(apps []Application) []string {
+	lines := make([]string, 0, len(apps))
+	for _, app := range apps {
+		lines = append(lines, fmt.Sprintf("%d %s", app.ID, app.Name))
+	}
+	return lines
+}
+
+func (s *Server) list(*parser.Command) *response.Response {
+	s.mu.Lock()
+	filters := slices.Clone(s.nameFilters)
+	total := len(s.apps)
+	s.mu.Unlock()
+
+	apps := s.matching(filters)
+	return response.New().Set("len", strconv.Itoa(len(apps))).Set("total", strconv.Itoa(total)).Body(appLines(apps)...)
+}
+
+func (s *Server) search(cmd *parser.Command) *response.Response {
+	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeString || strings.TrimSpace(cmd.Args[0].Str) == "" {
+		return response.Error("search", response.StatusBadArgument, "missing query", "search command requires a query string")
+	}
+	apps := s.matching([]string{cmd.Args[0].Str})
+	total := len(apps)
+	if len(cmd.Args) > 1 && cmd.Args[1].Type == parser.TypeInt && int(cmd.Args[1].Int) < len(apps) {
+		apps = apps[:cmd.Args[1].Int]
+	}
+	return response.New().Set("cmd", "search").Set("status", "0").Set("len", strconv.Itoa(total)).Body(appLines(apps)...)
+}
+
+func (s *Server) filterName(cmd *parser.Command) *response.Response {
+	args := cmd.Args
+	allowEmpty := len(args) > 0 && args[0].Type == parser.TypeString && args[0].Str == "opt: allow-empty"
+	if allowEmpty {
+		args = args[1:]
+	}
+	var names []string
+	for _, arg := range args {
+		if arg.Type == parser.TypeString {
+			names = append(names, arg.Str)
+		}
+	}
+	if cmd.Name == "+filter-name" && len(names) == 0 && !allowEmpty {
+		return response.Error(cmd.Name, response.StatusBadArgument, "no values", `+filter-name command needs at least one string value, "opt: allow-empty makes it a no-op`)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cmd.Name == "filter-name" {
+		s.nameFilters, s.nameExprs = nil, 0
+	}
+	if len(names) > 0 {
+		s.nameFilters = append(s.nameFilters, names...)
+		s.nameExprs++
+	}
+	return response.New().Set("cmd", cmd.Name).Set("status", "0").Set("name-filters", strconv.Itoa(s.nameExprs))
+}
+
+func (s *Server) resetFilters(*parser.Command) *response.Response {
+	s.mu.Lock()
+	s.nameFilters, s.nameExprs = nil, 0
+	s.mu.Unlock()
+	return response.New().Set("cmd", "0filters").Set("status", "0")
+}
+
+func (s *Server) run(cmd *parser.Command) *response.Response {
+	app, errReply := s.lookup(cmd)
+	if errReply != nil {
+		return errReply
+	}
+	for _, arg := range cmd.Args {
+		if key, ok := strings.CutPrefix(arg.Str, "action: "); ok && arg.Type == parser.TypeString && !slices.Contains(app.Actions, key) {
+			return response.Error("run", response.StatusNotFound, "action not found", "Can't run application, it has no such action.")
+		}
+	}
+	s.mu.Lock()
+	s.pid++
+	pid := s.pid
+	s.mu.Unlock()
+	return response.New().Set("cmd", "run").Set("idx", strconv.FormatInt(app.ID, 10)).Set("status", "0").Set("pid", strconv.Itoa(pid))
+}
+
+func (s *Server) nop(*parser.Command) *response.Response {
+	return response.New().Set("cmd", "nop").Set("status", "0")
+}
+
+func (s *Server) pin(cmd *parser.Command) *response.Response {
+	app, errReply := s.lookup(cmd)
+	if errReply != nil {
+		return errReply
+	}
+	s.mu.Lock()
+	s.pins[app.ID] = cmd.Name == "pin"
+	s.mu.Unlock()
+	return response.New().Set("cmd", cmd.Name).Set("idx", strconv.FormatInt(app.ID, 10)).Set("status", "0")
+}
+
+func (s *Server) info(cmd *parser.Command) *response.Response {
+	app, errReply := s.lookup(cmd)
+	if errReply != nil {
+		return errReply
+	}
+	s.mu.Lock()
+	pinned := s.pins[app.ID]
+	s.mu.Unlock()
+	pinnedAttr := "f"
+	if pinned {
+		pinnedAttr = "t"
+	}
+	reply := response.New().
+		Set("cmd", "info").
+		Set("idx", strconv.FormatInt(app.ID, 10)).
+		Set("status", "0").
+		Set("name", app.Name)
+	if len(app.Actions) > 0 {
+		reply.Set("actions", strings.Join(app.Actions, ";"))
+	}
+	return reply.Set("pinned", pinnedAttr)
+}
+
+func (s *Server) setLang(cmd *parser.Command) *response.Response {
+	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeString {
+		return response.Error("lang", response.StatusBadArgument, "missing parameter", "lang command requires a string parameter")
+	}
+	s.mu.Lock()
+	s.lang = cmd.Args[0].Str
+	s.mu.Unlock()
+	return response.New().Set("cmd", "lang").Set("status", "0").Set("lang", cmd.Args[0].Str)
+}
+
+func (s *Server) session(cmd *parser.Command) *response.Response {
+	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeString {
+		return response.Error("session", response.StatusBadArgument, "missing action", "session command requires an action: persist or resume")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch cmd.Args[0].Str {
+	case "persist":
+		token := fmt.Sprintf("exetest-%d", len(s.sessions)+1)
+		s.sessions[token] = session{nameFilters: slices.Clone(s.nameFilters), nameExprs: s.nameExprs, lang: s.lang}
+		expires := time.Now().Add(24 * time.Hour).Unix()
+		return response.New().Set("cmd", "session").Set("status", "0").Set("token", token).Set("expires", strconv.FormatInt(expires, 10))
+	case "resume":
+		if len(cmd.Args) < 2 || cmd.Args[1].Type != parser.TypeString {
+			return response.Error("session", response.StatusBadArgument, "missing token", "session resume requires a token parameter")
+		}
+		state, ok := s.sessions[cmd.Args[1].Str]
+		if !ok {
+			return response.New().Set("cmd", "session").Set("status", "0").Set("resumed", "f").Set("reason", "unknown")
+		}
+		s.nameFilters, s.nameExprs, s.lang = slices.Clone(state.nameFilters), state.nameExprs, state.lang
+		return response.New().Set("cmd", "session").Set("status", "0").Set("resumed", "t")
+	}
+	return response.Error("session", response.StatusBadArgument, "invalid action", "session action must be persist or resume")
+}
+
+func (s *Server) subscribe(cmd *parser.Command) *response.Response {
+	reply := response.New().Set("cmd", cmd.Name).Set("status", "0")
+	if cmd.Name == "subscribe" {
+		reply.Set("generation", "1")
+	}
+	return reply
+}
+
+func (s *Server) capabilities(*parser.Command) *response.Response {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.handlers))
+	for name := range s.handlers {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	slices.Sort(names)
+
+	var features []string
+	for _, f := range []struct{ name, command string }{
+		{"events", "subscribe"},
+		{"sessions", "session"},
+		{"search", "search"},
+	} {
+		if slices.Contains(names, f.command) {
+			features = append(features, f.name)
+		}
+	}
+	return response.New().
+		Set("cmd", "capabilities").
+		Set("status", "0").
+		Set("protocols", response.Header).
+		Set("features", strings.Join(features, " ")).
+		Set("list-limit", "128").
+		Set("len", strconv.Itoa(len(names))).
+		Body(names...)
+}
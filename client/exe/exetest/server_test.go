@@ -0,0 +1,177 @@
+package exetest_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+	"github.com/0xADE/ade-ctld/client/exe/exetest"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		srv    *exetest.Server
+		socket string
+	)
+
+	BeforeEach(func() {
+		srv = exetest.NewServer(
+			exetest.Application{ID: 1, Name: "Firefox"},
+			exetest.Application{ID: 2, Name: "Files"},
+			exetest.Application{ID: 3, Name: "Terminal"},
+		)
+		var err error
+		socket, err = srv.Start()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(srv.Close)
+	})
+
+	dial := func() *exe.Client {
+		client, err := exe.Dial(socket)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(client.Close)
+		return client
+	}
+
+	It("should serve and filter its applications", func() {
+		client := dial()
+		Expect(client.List()).To(HaveLen(3))
+
+		Expect(client.SetFilterName("fi")).To(Succeed())
+		Expect(client.List()).To(Equal([]exe.Application{{ID: 1, Name: "Firefox"}, {ID: 2, Name: "Files"}}))
+
+		Expect(client.ResetFilters()).To(Succeed())
+		srv.SetApplications(exetest.Application{ID: 9, Name: "Vim"})
+		Expect(client.List()).To(Equal([]exe.Application{{ID: 9, Name: "Vim"}}))
+	})
+
+	It("should record every command with its arguments", func() {
+		client := dial()
+		Expect(client.RunInTerminal(3)).To(Succeed())
+		Expect(client.SetLang("de")).To(Succeed())
+
+		Expect(srv.Received("run")).To(Equal([]exetest.Command{{Name: "run", Args: []any{"opt: terminal", int64(3)}}}))
+		Expect(srv.Commands()).To(HaveLen(2))
+		Expect(srv.Commands()[1]).To(Equal(exetest.Command{Name: "lang", Args: []any{"de"}}))
+
+		srv.Reset()
+		Expect(srv.Commands()).To(BeEmpty())
+	})
+
+	It("should answer unknown ids like the daemon", func() {
+		err := dial().Run(42)
+		Expect(errors.Is(err, &exe.ServerError{Code: response.StatusNotFound})).To(BeTrue())
+	})
+
+	It("should inject errors per command until recovered", func() {
+		client := dial()
+		srv.Fail("pin", response.StatusInternal, "internal error", "disk full")
+
+		err := client.Pin(1)
+		var serverErr *exe.ServerError
+		Expect(errors.As(err, &serverErr)).To(BeTrue())
+		Expect(serverErr.Code).To(Equal(response.StatusInternal))
+		Expect(serverErr.Desc).To(Equal("disk full"))
+		Expect(client.Unpin(1)).To(Succeed())
+
+		srv.Recover("pin")
+		Expect(client.Pin(1)).To(Succeed())
+	})
+
+	It("should delay replies by the latency", func() {
+		client := dial()
+		srv.SetLatency(50 * time.Millisecond)
+		start := time.Now()
+		Expect(client.Pin(1)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+	})
+
+	It("should behave like an older daemon without a removed command", func() {
+		srv.Handle("capabilities", nil)
+		client := dial()
+		Expect(client.Supports("events")).To(BeFalse())
+	})
+
+	It("should answer commands registered with Handle", func() {
+		srv.Handle("stats", func(cmd *parser.Command) *response.Response {
+			return response.New().Set("cmd", "stats").Set("status", "0").Set("tracked", "7")
+		})
+		reply, err := dial().Exec("stats")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reply.Attrs).To(HaveKeyWithValue("tracked", "7"))
+		Expect(dial().Supports("stats")).To(BeTrue())
+	})
+
+	It("should resume persisted sessions", func() {
+		client := dial()
+		Expect(client.SetFilterName("term")).To(Succeed())
+		token, err := client.PersistSession()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.ResetFilters()).To(Succeed())
+
+		resumed, err := dial().ResumeSession(token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resumed).To(BeTrue())
+		Expect(client.List()).To(Equal([]exe.Application{{ID: 3, Name: "Terminal"}}))
+
+		resumed, err = client.ResumeSession("bogus")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resumed).To(BeFalse())
+	})
+
+	It("should emit events to subscribed connections only", func() {
+		subscriber, err := net.Dial("unix", socket)
+		Expect(err).NotTo(HaveOccurred())
+		defer subscriber.Close()
+		reader := bufio.NewReader(subscriber)
+
+		_, err = io.WriteString(subscriber, "TXT01subscribe\n")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readFrame(reader)).To(HavePrefix("TXT01cmd: subscribe\nstatus: 0\n"))
+
+		srv.Emit(response.New().Set("event", "index-updated").Set("generation", "2"))
+		Expect(readFrame(reader)).To(Equal("TXT01event: index-updated\ngeneration: 2\n\n\n"))
+		Expect(srv.Received("subscribe")).To(HaveLen(1))
+	})
+
+	It("should drop its connections and accept new ones on Disconnect", func() {
+		client := dial()
+		Expect(client.Pin(1)).To(Succeed())
+
+		srv.Disconnect()
+		Expect(errors.Is(client.Pin(1), exe.ErrServerClosed)).To(BeTrue())
+		Expect(dial().Pin(1)).To(Succeed())
+	})
+
+	It("should reply to malformed requests with the parser's error", func() {
+		conn, err := net.Dial("unix", socket)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		_, err = io.WriteString(conn, "TXT01bogus\nlist\n")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readFrame(reader)).To(ContainSubstring("status: 5\n"))
+		Expect(readFrame(reader)).To(HavePrefix("TXT01len: 3\n"))
+	})
+})
+
+// readFrame reads one reply or event, up to its terminating blank lines
+func readFrame(reader *bufio.Reader) string {
+	var frame strings.Builder
+	for !strings.HasSuffix(frame.String(), "\n\n\n") {
+		line, err := reader.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		frame.WriteString(line)
+	}
+	return frame.String()
+}
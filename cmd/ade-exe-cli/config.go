@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+)
+
+// originOpt asks config where every value comes from
+const originOpt = `"opt: origin`
+
+// configSetting is a setting as printed with config --json
+type configSetting struct {
+	Value  string `json:"value"`
+	Origin string `json:"origin"`
+}
+
+// configPath is a search path as printed with config --json
+type configPath struct {
+	Path   string `json:"path"`
+	Origin string `json:"origin"`
+}
+
+// configOutput is the configuration as printed with config --json
+type configOutput struct {
+	Settings map[string]configSetting `json:"settings"`
+	Paths    []configPath             `json:"paths"`
+}
+
+// replyAttrs are the attrs of a config reply that aren't settings
+var replyAttrs = map[string]bool{"cmd": true, "status": true, "len": true, "req": true}
+
+// printConfig prints the configuration of the daemon: the reply as sent, with where every
+// value comes from when origin is set, or with asJSON a JSON object of the settings and
+// the search paths, origins included
+func printConfig(client *exe.Client, out io.Writer, asJSON, origin bool) error {
+	var args []any
+	if origin || asJSON {
+		args = append(args, originOpt)
+	}
+	reply, err := client.Exec("config", args...)
+	if err != nil {
+		return err
+	}
+	if !asJSON {
+		fmt.Fprint(out, reply.Raw)
+		fmt.Fprintf(out, "body:\n%s", reply.Body)
+		return nil
+	}
+
+	config := configOutput{Settings: make(map[string]configSetting), Paths: []configPath{}}
+	for name, value := range reply.Attrs {
+		if replyAttrs[name] || strings.HasPrefix(name, "origin-") {
+			continue
+		}
+		config.Settings[name] = configSetting{Value: value, Origin: reply.Attrs["origin-"+name]}
+	}
+	for _, line := range bodyLines(reply.Body) {
+		origin, path, _ := strings.Cut(line, " ")
+		config.Paths = append(config.Paths, configPath{Path: path, Origin: origin})
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s\n", data)
+	return nil
+}
+
+// runConfig prints the configuration of the daemon and exits with the status of the reply
+func runConfig(client *exe.Client, args []string) {
+	var asJSON, origin bool
+	for _, arg := range args {
+		switch arg {
+		case "--json", "-json":
+			asJSON = true
+		case "--origin", "-origin":
+			origin = true
+		default:
+			fmt.Fprintf(os.Stderr, "Usage: %s config [--origin] [--json]\n", os.Args[0])
+			os.Exit(1)
+		}
+	}
+
+	err := printConfig(client, os.Stdout, asJSON, origin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get the configuration: %v\n", err)
+	}
+	client.Close()
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("config", func() {
+	var (
+		out      bytes.Buffer
+		client   *exe.Client
+		requests func() []string
+	)
+
+	BeforeEach(func() {
+		socketPath := filepath.Join(GinkgoT().TempDir(), "indexd")
+		DeferCleanup(os.Setenv, "ADE_INDEXD_SOCK", os.Getenv("ADE_INDEXD_SOCK"))
+		os.Setenv("ADE_INDEXD_SOCK", socketPath)
+
+		var listener net.Listener
+		listener, requests = stubServer(socketPath)
+		DeferCleanup(listener.Close)
+
+		var err error
+		client, err = exe.NewClient()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(client.Close)
+		out.Reset()
+	})
+
+	It("should print the reply as sent", func() {
+		Expect(printConfig(client, &out, false, false)).To(Succeed())
+		Expect(requests()).To(Equal([]string{"config"}))
+		Expect(out.String()).To(HavePrefix("cmd: config\nstatus: 0\nworkers: 8\n"))
+		Expect(out.String()).To(HaveSuffix("body:\nenv /usr/bin\nrc /home/me/bin\n"))
+	})
+
+	It("should ask for the origins with --origin", func() {
+		Expect(printConfig(client, &out, false, true)).To(Succeed())
+		Expect(requests()).To(Equal([]string{`"opt: origin config`}))
+	})
+
+	It("should print the settings and paths with their origins as JSON", func() {
+		Expect(printConfig(client, &out, true, false)).To(Succeed())
+		Expect(requests()).To(Equal([]string{`"opt: origin config`}))
+
+		var printed configOutput
+		Expect(json.Unmarshal(out.Bytes(), &printed)).To(Succeed())
+		Expect(printed).To(Equal(configOutput{
+			Settings: map[string]configSetting{
+				"workers": {Value: "8", Origin: "env"},
+				"sort":    {Value: "freq", Origin: "default"},
+			},
+			Paths: []configPath{{Path: "/usr/bin", Origin: "env"}, {Path: "/home/me/bin", Origin: "rc"}},
+		}))
+	})
+})
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/perm"
+)
+
+// exportIndex writes the records of export-index to out, a JSON object per line, and
+// returns how many there were
+func exportIndex(client *exe.Client, out io.Writer) (int, error) {
+	reply, err := client.Exec("export-index")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.WriteString(out, reply.Body); err != nil {
+		return 0, err
+	}
+	return len(bodyLines(reply.Body)), nil
+}
+
+// exportFile writes the records of export-index to path. The file is replaced once
+// complete, so a failed export leaves the previous one alone.
+func exportFile(client *exe.Client, path string) (int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	out := bufio.NewWriter(tmp)
+	n, err := exportIndex(client, out)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(perm.File())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+// readRecords reads an export-index file, skipping blank lines
+func readRecords(r io.Reader) ([]indexer.Record, error) {
+	var records []indexer.Record
+	scanner := bufio.NewScanner(r)
+	// Entries with many localized names make long lines
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record indexer.Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// recordNames returns the name of every path of records, as indexer.DiffNames takes them
+func recordNames(records []indexer.Record) map[string]string {
+	names := make(map[string]string, len(records))
+	for _, record := range records {
+		names[record.Path] = record.Name
+	}
+	return names
+}
+
+// compareIndex prints how the records of an export-index file differ from the live index,
+// from the live index to the file, with the lines of diff
+func compareIndex(client *exe.Client, file io.Reader, out io.Writer) error {
+	records, err := readRecords(file)
+	if err != nil {
+		return err
+	}
+	var live strings.Builder
+	if _, err := exportIndex(client, &live); err != nil {
+		return err
+	}
+	liveRecords, err := readRecords(strings.NewReader(live.String()))
+	if err != nil {
+		return fmt.Errorf("live index: %w", err)
+	}
+
+	diff := indexer.DiffNames(recordNames(liveRecords), recordNames(records))
+	fmt.Fprintf(out, "live: %d\nfile: %d\n", len(liveRecords), len(records))
+	fmt.Fprintf(out, "added: %d\nremoved: %d\nrenamed: %d\n", len(diff.Added), len(diff.Removed), len(diff.Renamed))
+	for _, change := range diff.Added {
+		fmt.Fprintf(out, "+ %s %s\n", change.Path, change.Name)
+	}
+	for _, change := range diff.Removed {
+		fmt.Fprintf(out, "- %s %s\n", change.Path, change.Name)
+	}
+	for _, change := range diff.Renamed {
+		fmt.Fprintf(out, "~ %s %s -> %s\n", change.Path, change.OldName, change.Name)
+	}
+	return nil
+}
+
+// runExport writes the whole index to the file of --out, or to stdout without it, and
+// exits with the status of the export
+func runExport(client *exe.Client, args []string) {
+	var path string
+	switch {
+	case len(args) == 0:
+	case len(args) == 2 && (args[0] == "--out" || args[0] == "-out"):
+		path = args[1]
+	case len(args) == 1 && strings.HasPrefix(args[0], "--out="):
+		path = strings.TrimPrefix(args[0], "--out=")
+	default:
+		fmt.Fprintf(os.Stderr, "Usage: %s export [--out <file>]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	var err error
+	if path == "" {
+		_, err = exportIndex(client, os.Stdout)
+	} else {
+		var n int
+		if n, err = exportFile(client, path); err == nil {
+			fmt.Fprintf(os.Stderr, "Exported %d entries to %s\n", n, path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export the index: %v\n", err)
+	}
+	client.Close()
+	os.Exit(exitCode(err))
+}
+
+// runImport compares an export-index file with the live index. Only --dry-run is
+// supported: the index is built from the filesystem, there is nothing to import into.
+func runImport(client *exe.Client, args []string) {
+	if len(args) != 2 || (args[0] != "--dry-run" && args[0] != "-dry-run") {
+		fmt.Fprintf(os.Stderr, "Usage: %s import --dry-run <file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "The index is built from the filesystem, import only compares a file with it\n")
+		os.Exit(1)
+	}
+
+	file, err := os.Open(args[1])
+	if err == nil {
+		err = compareIndex(client, file, os.Stdout)
+		file.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to compare %s with the index: %v\n", args[1], err)
+	}
+	client.Close()
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+	"github.com/0xADE/ade-ctld/client/exe/exetest"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("export and import", func() {
+	var (
+		srv    *exetest.Server
+		client *exe.Client
+		out    bytes.Buffer
+	)
+
+	liveRecords := []string{
+		`{"id":1,"key":"firefox.desktop","revision":2,"name":"Firefox","names":{"de":"Firefox Webbrowser"},"path":"/usr/share/applications/firefox.desktop","exec":"firefox %u","desktop":true,"terminal":false,"self-tool":false,"mod-time":"2024-04-01T09:30:00Z"}`,
+		`{"id":2,"key":"true","revision":1,"name":"true","path":"/usr/bin/true","exec":"/usr/bin/true","desktop":false,"terminal":false,"self-tool":false,"mod-time":"2024-04-01T08:30:00Z"}`,
+	}
+
+	BeforeEach(func() {
+		srv = exetest.NewServer()
+		srv.Handle("export-index", func(*parser.Command) *response.Response {
+			return response.New().Set("cmd", "export-index").Set("status", "0").Set("len", "2").Body(liveRecords...)
+		})
+		socket, err := srv.Start()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(srv.Close)
+		client, err = exe.Dial(socket)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(client.Close)
+		out.Reset()
+	})
+
+	It("should write the records to the file, replacing it whole", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "index.jsonl")
+		Expect(os.WriteFile(path, []byte("previous export\n"), 0644)).To(Succeed())
+
+		Expect(exportFile(client, path)).To(Equal(2))
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(strings.Join(liveRecords, "\n") + "\n"))
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		Expect(filepath.Glob(filepath.Join(filepath.Dir(path), ".index.jsonl.*"))).To(BeEmpty())
+	})
+
+	It("should leave the previous file alone when the export fails", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "index.jsonl")
+		Expect(os.WriteFile(path, []byte("previous export\n"), 0644)).To(Succeed())
+		srv.Fail("export-index", response.StatusInternal, "internal error", "out of memory")
+
+		_, err := exportFile(client, path)
+		Expect(err).To(HaveOccurred())
+		Expect(os.ReadFile(path)).To(Equal([]byte("previous export\n")))
+	})
+
+	It("should compare an exported file with the live index without changing anything", func() {
+		file := strings.Join([]string{
+			`{"id":1,"key":"firefox.desktop","revision":3,"name":"Firefox ESR","path":"/usr/share/applications/firefox.desktop","exec":"firefox %u","desktop":true,"terminal":false,"self-tool":false,"mod-time":"2024-04-01T09:30:00Z"}`,
+			"",
+			`{"id":2,"key":"gimp.desktop","revision":1,"name":"GIMP","path":"/usr/share/applications/gimp.desktop","exec":"gimp %U","desktop":true,"terminal":false,"self-tool":false,"mod-time":"2024-04-01T09:30:00Z","fields-of-later-versions":1}`,
+		}, "\n")
+
+		Expect(compareIndex(client, strings.NewReader(file), &out)).To(Succeed())
+		Expect(out.String()).To(Equal("live: 2\nfile: 2\nadded: 1\nremoved: 1\nrenamed: 1\n" +
+			"+ /usr/share/applications/gimp.desktop GIMP\n" +
+			"- /usr/bin/true true\n" +
+			"~ /usr/share/applications/firefox.desktop Firefox -> Firefox ESR\n"))
+		Expect(srv.Commands()).To(HaveExactElements(HaveField("Name", "export-index")))
+	})
+
+	It("should tell the line of a record it can't read", func() {
+		err := compareIndex(client, strings.NewReader(liveRecords[0]+"\n{\"id\":\n"), &out)
+		Expect(err).To(MatchError(HavePrefix("line 2: ")))
+		Expect(out.String()).To(BeEmpty())
+	})
+})
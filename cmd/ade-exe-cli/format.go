@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+)
+
+// listRestarts is the number of list restarts printList tolerates, for indexes rebuilt meanwhile
+const listRestarts = 3
+
+// listItem is an application as a --format template sees it
+type listItem struct {
+	ID   int64  // as passed to run, info or pin
+	Name string // in the language set with lang
+	Pos  int    // position in the list, from 1
+}
+
+// formatEscapes are the escapes of --format templates, for shells not making them easy to type
+var formatEscapes = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n")
+
+// parseFormat parses a --format template, after replacing the escapes \t, \n and \\. The
+// template is tried on an empty application, so that references to unknown fields fail
+// here rather than after the list was asked for.
+func parseFormat(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Parse(formatEscapes.Replace(format))
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, listItem{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// printList prints every application matching the filters with tmpl, one per line,
+// following the pages of the list. A list restarted because the index was rebuilt is
+// read again from the first page.
+func printList(client *exe.Client, out io.Writer, tmpl *template.Template) error {
+	var apps []exe.Application
+	page, err := client.ListPage("")
+	for restarts := 0; err == nil; page, err = client.ListPage(page.Cursor) {
+		if page.Restarted {
+			if restarts++; restarts > listRestarts {
+				return fmt.Errorf("index rebuilt %d times while listing it", restarts)
+			}
+			apps = apps[:0]
+		}
+		apps = append(apps, page.Applications...)
+		if page.Cursor == "" {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, app := range apps {
+		if err := tmpl.Execute(out, listItem{ID: app.ID, Name: app.Name, Pos: i + 1}); err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+// runList prints the applications with the template of --format and exits with the status
+// of the list
+func runList(client *exe.Client, args []string) {
+	var format string
+	switch {
+	case len(args) == 2 && (args[0] == "--format" || args[0] == "-format"):
+		format = args[1]
+	case len(args) == 1 && strings.HasPrefix(args[0], "--format="):
+		format = strings.TrimPrefix(args[0], "--format=")
+	default:
+		fmt.Fprintf(os.Stderr, "Usage: %s list [--format <template>]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	tmpl, err := parseFormat(format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid format: %v\n", err)
+		os.Exit(1)
+	}
+	err = printList(client, os.Stdout, tmpl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list applications: %v\n", err)
+	}
+	client.Close()
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+	"github.com/0xADE/ade-ctld/client/exe/exetest"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("list --format", func() {
+	var (
+		srv    *exetest.Server
+		client *exe.Client
+		out    bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		srv = exetest.NewServer(
+			exetest.Application{ID: 7, Name: "Firefox"},
+			exetest.Application{ID: 9, Name: "GNU Image Manipulation Program"},
+		)
+		socket, err := srv.Start()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(srv.Close)
+		client, err = exe.Dial(socket)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(client.Close)
+		out.Reset()
+	})
+
+	format := func(format string) error {
+		tmpl, err := parseFormat(format)
+		if err != nil {
+			return err
+		}
+		return printList(client, &out, tmpl)
+	}
+
+	It("should print a line per application with the escapes replaced", func() {
+		Expect(format(`{{.ID}}\t{{.Name}}`)).To(Succeed())
+		Expect(out.String()).To(Equal("7\tFirefox\n9\tGNU Image Manipulation Program\n"))
+	})
+
+	It("should give the position and the template functions", func() {
+		Expect(format(`{{.Pos}}. {{printf "%q" .Name}}{{if eq .ID 9}} (last){{end}}`)).To(Succeed())
+		Expect(out.String()).To(Equal("1. \"Firefox\"\n2. \"GNU Image Manipulation Program\" (last)\n"))
+	})
+
+	It("should follow the pages of the list", func() {
+		srv.Handle("list", func(*parser.Command) *response.Response {
+			return response.New().Set("cmd", "list").Set("status", "0").Set("len", "2").Set("cursor", "c1").Body("7 Firefox")
+		})
+		srv.Handle("list-next", func(*parser.Command) *response.Response {
+			return response.New().Set("cmd", "list-next").Set("status", "0").Set("len", "2").Body("9 GIMP")
+		})
+		Expect(format(`{{.Name}}`)).To(Succeed())
+		Expect(out.String()).To(Equal("Firefox\nGIMP\n"))
+		Expect(srv.Received("list-next")).To(Equal([]exetest.Command{{Name: "list-next", Args: []any{"c1"}}}))
+	})
+
+	It("should reject invalid templates before sending anything", func() {
+		for _, invalid := range []string{`{{.ID`, `{{.Path}}`, `{{frobnicate .Name}}`} {
+			Expect(format(invalid)).To(HaveOccurred(), invalid)
+		}
+		Expect(srv.Commands()).To(BeEmpty())
+		Expect(out.String()).To(BeEmpty())
+	})
+})
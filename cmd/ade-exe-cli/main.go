@@ -2,9 +2,11 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/0xADE/ade-ctld/client/exe"
@@ -14,14 +16,29 @@ func main() {
 	if len(os.Args) < 2 {
 		fmt.Fprintf(os.Stderr, "Usage: %s <command> [args...]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Commands:\n")
-		fmt.Fprintf(os.Stderr, "  list                     - List all applications\n")
+		fmt.Fprintf(os.Stderr, "  list [--format <template>] - List all applications, with --format one line each from a\n")
+		fmt.Fprintf(os.Stderr, "                             text/template of {{.ID}}, {{.Name}} and {{.Pos}} (from 1)\n")
 		fmt.Fprintf(os.Stderr, "  list-next <offset> [limit] - Get next page of results\n")
+		fmt.Fprintf(os.Stderr, "  search <query> [limit]   - Find applications, leaving the filters alone\n")
 		fmt.Fprintf(os.Stderr, "  filter-name <name>       - Filter by name\n")
 		fmt.Fprintf(os.Stderr, "  filter-cat <cat>         - Filter by category\n")
 		fmt.Fprintf(os.Stderr, "  reset-filters            - Reset all filters\n")
+		fmt.Fprintf(os.Stderr, "  categories               - List categories with their display names\n")
 		fmt.Fprintf(os.Stderr, "  run <id>                 - Run application by ID\n")
+		fmt.Fprintf(os.Stderr, "  kill <pid>               - Stop application started by run\n")
+		fmt.Fprintf(os.Stderr, "  pin <id>                 - Pin application to the top of the list\n")
+		fmt.Fprintf(os.Stderr, "  unpin <id>               - Unpin application\n")
+		fmt.Fprintf(os.Stderr, "  info <id>                - Show application details\n")
+		fmt.Fprintf(os.Stderr, "  lang-list <id>           - Show locales the application is translated to\n")
+		fmt.Fprintf(os.Stderr, "  which <path|pid>         - Find the application owning an executable or process\n")
 		fmt.Fprintf(os.Stderr, "  lang <locale>            - Set language\n")
+		fmt.Fprintf(os.Stderr, "  diff [gen-a] [gen-b]     - Show entries added, removed or renamed between index generations\n")
+		fmt.Fprintf(os.Stderr, "  config [--origin] [--json] - Show the configuration of the daemon\n")
+		fmt.Fprintf(os.Stderr, "  export [--out <file>]    - Write the whole index as JSON lines, to stdout without --out\n")
+		fmt.Fprintf(os.Stderr, "  import --dry-run <file>  - Show how an exported index differs from the live one\n")
 		fmt.Fprintf(os.Stderr, "  interactive              - Interactive mode\n")
+		fmt.Fprintf(os.Stderr, "  script [--keep-going] [--json] <file|-> - Run the commands of a file over one connection\n")
+		fmt.Fprintf(os.Stderr, "Exit status: 0 on success, the reply status (1-6) on server errors, %d when the daemon can't be reached\n", exitConnection)
 		os.Exit(1)
 	}
 
@@ -29,7 +46,7 @@ func main() {
 	client, err := exe.NewClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create client: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 	defer client.Close()
 
@@ -39,13 +56,33 @@ func main() {
 		runInteractive(client)
 		return
 	}
+	if cmd == "script" {
+		runScript(client, os.Args[2:])
+		return
+	}
+	if cmd == "config" {
+		runConfig(client, os.Args[2:])
+		return
+	}
+	if cmd == "export" {
+		runExport(client, os.Args[2:])
+		return
+	}
+	if cmd == "import" {
+		runImport(client, os.Args[2:])
+		return
+	}
+	if cmd == "list" && len(os.Args) > 2 {
+		runList(client, os.Args[2:])
+		return
+	}
 
 	// Execute command
 	switch cmd {
 	case "list":
 		if err := client.SendCommand("list", nil); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 	case "list-next":
 		if len(os.Args) < 3 {
@@ -54,7 +91,7 @@ func main() {
 		}
 		if err := client.SendCommand("list-next", os.Args[2:]); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 	case "filter-name":
 		if len(os.Args) < 3 {
@@ -63,7 +100,7 @@ func main() {
 		}
 		if err := client.SendCommand("+filter-name", []string{os.Args[2]}); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 	case "filter-cat":
 		if len(os.Args) < 3 {
@@ -72,12 +109,17 @@ func main() {
 		}
 		if err := client.SendCommand("+filter-cat", []string{os.Args[2]}); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
+		}
+	case "categories":
+		if err := client.SendCommand("categories"); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
+			os.Exit(exitCode(err))
 		}
 	case "reset-filters":
 		if err := client.SendCommand("0filters", nil); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 	case "run":
 		if len(os.Args) < 3 {
@@ -86,12 +128,71 @@ func main() {
 		}
 		if err := client.SendCommand("run", []string{os.Args[2]}); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+	case "kill", "pin", "unpin", "info", "lang-list":
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s %s <number>\n", os.Args[0], cmd)
+			os.Exit(1)
+		}
+		n, err := strconv.ParseInt(os.Args[2], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid number %q: %v\n", os.Args[2], err)
+			os.Exit(1)
+		}
+		if err := client.SendCommand(cmd, n); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+	case "search":
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s search <query> [limit]\n", os.Args[0])
+			os.Exit(1)
+		}
+		args := []any{`"` + os.Args[2]}
+		if len(os.Args) >= 4 {
+			limit, err := strconv.ParseInt(os.Args[3], 10, 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid limit %q: %v\n", os.Args[3], err)
+				os.Exit(1)
+			}
+			args = append(args, limit)
+		}
+		if err := client.SendCommand("search", args...); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+	case "diff":
+		var args []any
+		for _, arg := range os.Args[2:] {
+			generation, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid generation %q: %v\n", arg, err)
+				os.Exit(1)
+			}
+			args = append(args, generation)
+		}
+		if err := client.SendCommand("diff", args...); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+	case "which":
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s which <path|pid>\n", os.Args[0])
 			os.Exit(1)
 		}
+		var arg any = os.Args[2]
+		if pid, err := strconv.ParseInt(os.Args[2], 10, 64); err == nil {
+			arg = pid
+		}
+		if err := client.SendCommand("which", arg); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
+			os.Exit(exitCode(err))
+		}
 	case "reindex":
 		if err := client.SendCommand("reindex", []string{os.Args[2]}); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 	case "lang":
 		if len(os.Args) < 3 {
@@ -100,7 +201,7 @@ func main() {
 		}
 		if err := client.SendCommand("lang", []string{os.Args[2]}); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", cmd)
@@ -108,50 +209,63 @@ func main() {
 	}
 
 	// Read and print response
-	exe.ReadResponse(client.Conn())
+	respErr := exe.ReadResponse(client.Conn())
 
 	// Close connection and exit in non-interactive mode
 	log.Printf("[DEBUG] Closing connection and exiting")
-	os.Exit(0)
+	client.Close()
+	os.Exit(exitCode(respErr))
+}
+
+// exitConnection is the exit status when the daemon can't be reached or goes away
+const exitConnection = 10
+
+// exitCode maps an error to the exit status: the reply status for server errors (1-6),
+// exitConnection for connection failures and 1 for anything else
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var serverErr *exe.ServerError
+	if errors.As(err, &serverErr) {
+		return int(serverErr.Code)
+	}
+	if errors.Is(err, exe.ErrNotConnected) || errors.Is(err, exe.ErrServerClosed) {
+		return exitConnection
+	}
+	return 1
 }
 
 func runInteractive(client *exe.Client) {
 	scanner := bufio.NewScanner(os.Stdin)
+	r := &runner{client: client, out: os.Stdout, keepGoing: true, reconnect: true}
 
 	fmt.Println("Interactive mode. Type commands or 'exit' to quit.")
+	fmt.Println("End a line with \\ to continue it, .source <file> runs a script, .sleep <ms> pauses.")
 	fmt.Print("> ")
 
+	pending := ""
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
-
-		if line == "exit" || line == "quit" {
-			break
-		}
-
-		if line == "" {
-			fmt.Print("> ")
+		if continued, ok := strings.CutSuffix(line, `\`); ok {
+			pending += continued
+			fmt.Print(". ")
 			continue
 		}
+		line, pending = strings.TrimSpace(pending+line), ""
 
-		// Parse command
-		parts := strings.Fields(line)
-		if len(parts) == 0 {
-			fmt.Print("> ")
-			continue
+		if line == "exit" || line == "quit" {
+			break
 		}
 
-		cmd := parts[0]
-		args := parts[1:]
-
-		// Send command with type detection
-		if err := client.SendCommand(cmd, args); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to send command: %v\n", err)
+		if line == "" || strings.HasPrefix(line, "#") {
 			fmt.Print("> ")
 			continue
 		}
 
-		// Read response
-		exe.ReadResponse(client.Conn())
+		// Errors are printed by the runner. A connection lost, e.g. to a daemon restart, is
+		// dialed again before the next command, so the session goes on.
+		r.exec(line)
 
 		fmt.Print("> ")
 	}
@@ -160,3 +274,49 @@ func runInteractive(client *exe.Client) {
 		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
 	}
 }
+
+// runScript runs the script named in args, "-" for stdin, and exits with the status of the
+// first failed step
+func runScript(client *exe.Client, args []string) {
+	r := &runner{client: client, out: os.Stdout}
+	var path string
+	for _, arg := range args {
+		switch arg {
+		case "--keep-going", "-keep-going":
+			r.keepGoing = true
+		case "--json", "-json":
+			r.json = true
+		default:
+			if path != "" {
+				fmt.Fprintf(os.Stderr, "Usage: %s script [--keep-going] [--json] <file|->\n", os.Args[0])
+				os.Exit(1)
+			}
+			path = arg
+		}
+	}
+	if path == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s script [--keep-going] [--json] <file|->\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	script := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open script: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		script = file
+	}
+
+	err := r.run(script)
+	if err != nil {
+		var serverErr *exe.ServerError
+		if !errors.As(err, &serverErr) {
+			fmt.Fprintf(os.Stderr, "Script failed: %v\n", err)
+		}
+	}
+	client.Close()
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+)
+
+// maxSourceDepth bounds nested .source commands, so a script sourcing itself fails instead
+// of recursing forever
+const maxSourceDepth = 8
+
+// runner executes command lines against one connection: protocol commands as `cmd args...`
+// and the meta commands .sleep and .source
+type runner struct {
+	client    *exe.Client
+	out       io.Writer
+	json      bool // print a JSON object per step instead of the replies
+	keepGoing bool // run the remaining steps after a failed one
+	reconnect bool // redial before a command when the daemon closed the connection
+	depth     int  // nesting of .source
+	step      int  // steps run so far
+}
+
+// stepResult is a step as printed with --json
+type stepResult struct {
+	Step   int               `json:"step"`
+	Line   string            `json:"line"`
+	Status int               `json:"status"`
+	Attrs  map[string]string `json:"attrs,omitempty"`
+	Body   []string          `json:"body,omitempty"`
+	Error  string            `json:"error,omitempty"`
+	Slept  *int64            `json:"slept-ms,omitempty"`
+}
+
+// run executes a script: one command per line, lines ending with a backslash continued on
+// the next one, blank lines and # comments skipped. It stops at the first failed step unless
+// keepGoing is set, and returns the error of the first failed step.
+func (r *runner) run(script io.Reader) error {
+	scanner := bufio.NewScanner(script)
+	var (
+		first   error
+		pending string
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if continued, ok := strings.CutSuffix(line, `\`); ok {
+			pending += continued
+			continue
+		}
+		line, pending = strings.TrimSpace(pending+line), ""
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		err := r.exec(line)
+		if err == nil {
+			continue
+		}
+		if first == nil {
+			first = err
+		}
+		// Nothing more can be sent once the connection is gone
+		if !r.keepGoing || errors.Is(err, exe.ErrNotConnected) || errors.Is(err, exe.ErrServerClosed) {
+			return first
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if pending != "" {
+		return fmt.Errorf("script ends in a continued line: %q", pending)
+	}
+	return first
+}
+
+// exec runs a single command line and prints its outcome
+func (r *runner) exec(line string) error {
+	r.step++
+	result := stepResult{Step: r.step, Line: line}
+	if !r.json {
+		fmt.Fprintf(r.out, "> %s\n", line)
+	}
+
+	name, args, err := parseLine(line)
+	switch {
+	case err != nil:
+	case name == ".sleep":
+		err = r.sleep(args, &result)
+	case name == ".source":
+		var ran bool
+		if ran, err = r.source(args); ran {
+			// The sourced steps printed their own outcome
+			return err
+		}
+	case strings.HasPrefix(name, "."):
+		err = fmt.Errorf("unknown meta command %s", name)
+	default:
+		if r.reconnect && !r.client.Alive() {
+			if err = r.client.Reconnect(); err != nil {
+				break
+			}
+			fmt.Fprintln(r.out, "(reconnected)")
+		}
+		var reply *exe.Reply
+		reply, err = r.client.Exec(name, args...)
+		if reply != nil {
+			result.Attrs = reply.Attrs
+			result.Body = bodyLines(reply.Body)
+			if !r.json {
+				fmt.Fprint(r.out, reply.Raw)
+				if reply.Body != "" {
+					fmt.Fprintf(r.out, "body:\n%s", reply.Body)
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		result.Status = exitCode(err)
+		result.Error = err.Error()
+		if !r.json {
+			var serverErr *exe.ServerError
+			if !errors.As(err, &serverErr) {
+				fmt.Fprintf(r.out, "error: %v\n", err)
+			}
+		}
+	}
+	if r.json {
+		data, _ := json.Marshal(result)
+		fmt.Fprintf(r.out, "%s\n", data)
+	} else {
+		fmt.Fprintln(r.out)
+	}
+	return err
+}
+
+// sleep pauses for the given number of milliseconds
+func (r *runner) sleep(args []any, result *stepResult) error {
+	if len(args) != 1 {
+		return errors.New("usage: .sleep <ms>")
+	}
+	ms, ok := args[0].(int64)
+	if !ok || ms < 0 {
+		return fmt.Errorf("invalid duration %v, expected milliseconds", args[0])
+	}
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	result.Slept = &ms
+	return nil
+}
+
+// source runs the commands of a file as part of the current script. ran reports whether the
+// file was run at all, as opposed to failing to open.
+func (r *runner) source(args []any) (ran bool, err error) {
+	if len(args) != 1 {
+		return false, errors.New("usage: .source <file>")
+	}
+	path, ok := args[0].(string)
+	if !ok {
+		return false, fmt.Errorf("invalid file name %v", args[0])
+	}
+	path = strings.TrimPrefix(path, `"`)
+	if r.depth >= maxSourceDepth {
+		return false, fmt.Errorf("%s: .source nested too deep", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	r.depth++
+	defer func() { r.depth-- }()
+	return true, r.run(file)
+}
+
+// parseLine splits a command line into the command and its arguments: integers are sent as
+// ints, t/f and the operators as such, anything else as a string. Double quotes keep spaces
+// and force a string, as in `filter-name "Web Browser"` or `search "42"`.
+func parseLine(line string) (string, []any, error) {
+	var words []any
+	for rest := strings.TrimSpace(line); rest != ""; rest = strings.TrimSpace(rest) {
+		if quoted, ok := strings.CutPrefix(rest, `"`); ok {
+			end := strings.IndexByte(quoted, '"')
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated quote in %q", line)
+			}
+			// The protocol string prefix, so FormatArgument doesn't guess the type
+			words = append(words, `"`+quoted[:end])
+			rest = quoted[end+1:]
+			continue
+		}
+		word, after, _ := strings.Cut(rest, " ")
+		rest = after
+		if n, err := strconv.ParseInt(word, 10, 64); err == nil {
+			words = append(words, n)
+		} else {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return "", nil, errors.New("empty command")
+	}
+	name, ok := words[0].(string)
+	if !ok || strings.HasPrefix(name, `"`) {
+		return "", nil, fmt.Errorf("not a command: %v", words[0])
+	}
+	return name, words[1:], nil
+}
+
+// bodyLines splits a reply body into its lines
+func bodyLines(body string) []string {
+	if body == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+	"github.com/0xADE/ade-ctld/client/exe/exetest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// stubReplies answers the commands of testdata/session.txt and config
+var stubReplies = map[string]string{
+	"lang":         "TXT01cmd: lang\nstatus: 0\n\n\n",
+	"+filter-name": "TXT01cmd: +filter-name\nstatus: 0\n\n\n",
+	"list":         "TXT01cmd: list\nstatus: 0\nlen: 2\n\nbody:\nid: 7\nname: Firefox\n\nid: 9\nname: Chromium\n\n\n",
+	"info":         "TXT01error-cmd: info\nerror: index not found\nstatus: 3\ndesc: Requested index not found.\n\n\n",
+	"run":          "TXT01cmd: run\nstatus: 0\npid: 4242\n\n\n",
+	"config":       "TXT01cmd: config\nstatus: 0\nworkers: 8\norigin-workers: env\nsort: freq\norigin-sort: default\nlen: 2\n\nbody:\nenv /usr/bin\nrc /home/me/bin\n\n\n",
+}
+
+// stubServer accepts one client and answers its commands from stubReplies, recording each
+// request as its argument lines followed by the command
+func stubServer(socketPath string) (net.Listener, func() []string) {
+	listener, err := net.Listen("unix", socketPath)
+	Expect(err).NotTo(HaveOccurred())
+
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		if _, err := io.ReadFull(reader, make([]byte, 5)); err != nil {
+			return
+		}
+		var args []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			reply, ok := stubReplies[line]
+			if !ok {
+				args = append(args, line)
+				continue
+			}
+			mu.Lock()
+			requests = append(requests, strings.Join(append(args, line), " "))
+			mu.Unlock()
+			args = nil
+			if _, err := io.WriteString(conn, reply); err != nil {
+				return
+			}
+		}
+	}()
+	return listener, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+var _ = Describe("Scripts", func() {
+	var (
+		out      bytes.Buffer
+		r        *runner
+		requests func() []string
+	)
+
+	BeforeEach(func() {
+		tmpDir := GinkgoT().TempDir()
+		socketPath := filepath.Join(tmpDir, "indexd")
+		DeferCleanup(os.Setenv, "ADE_INDEXD_SOCK", os.Getenv("ADE_INDEXD_SOCK"))
+		os.Setenv("ADE_INDEXD_SOCK", socketPath)
+
+		var listener net.Listener
+		listener, requests = stubServer(socketPath)
+		DeferCleanup(listener.Close)
+
+		client, err := exe.NewClient()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		out.Reset()
+		r = &runner{client: client, out: &out}
+	})
+
+	runFixture := func(name string) error {
+		script, err := os.Open(filepath.Join("testdata", name))
+		Expect(err).NotTo(HaveOccurred())
+		defer script.Close()
+		return r.run(script)
+	}
+
+	expectGolden := func(name string) {
+		golden, err := os.ReadFile(filepath.Join("testdata", name))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out.String()).To(Equal(string(golden)))
+	}
+
+	It("should stop at the first failed step", func() {
+		err := runFixture("session.txt")
+		Expect(exitCode(err)).To(Equal(3))
+		expectGolden("session.golden")
+		Expect(requests()).To(Equal([]string{
+			`"de lang`,
+			`"Web Browser +filter-name`,
+			"list",
+			"42 info",
+		}))
+	})
+
+	It("should run the remaining steps with keepGoing and print JSON", func() {
+		r.keepGoing = true
+		r.json = true
+		err := runFixture("session.txt")
+		Expect(exitCode(err)).To(Equal(3))
+		expectGolden("session.keep-going.json.golden")
+		Expect(requests()).To(HaveLen(5))
+		Expect(requests()[4]).To(Equal("7 run"))
+	})
+
+	It("should run sourced files as part of the script", func() {
+		dir := GinkgoT().TempDir()
+		inner := filepath.Join(dir, "inner.txt")
+		Expect(os.WriteFile(inner, []byte("list\n"), 0644)).To(Succeed())
+
+		Expect(r.run(strings.NewReader("lang de\n.source " + inner + "\nrun 7\n"))).To(Succeed())
+		Expect(requests()).To(Equal([]string{`"de lang`, "list", "7 run"}))
+	})
+
+	It("should fail on sources that loop", func() {
+		self := filepath.Join(GinkgoT().TempDir(), "self.txt")
+		Expect(os.WriteFile(self, []byte(".source "+self+"\n"), 0644)).To(Succeed())
+
+		err := r.run(strings.NewReader(".source " + self + "\nlist\n"))
+		Expect(err).To(MatchError(ContainSubstring("nested too deep")))
+		Expect(exitCode(err)).To(Equal(1))
+		Expect(requests()).To(BeEmpty())
+	})
+
+	It("should fail on unknown meta commands and missing sources without sending", func() {
+		r.keepGoing = true
+		err := r.run(strings.NewReader(".frobnicate\n.source /nonexistent\n.sleep soon\n"))
+		Expect(err).To(MatchError(ContainSubstring(".frobnicate")))
+		Expect(strings.Count(out.String(), "error: ")).To(Equal(3))
+		Expect(requests()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Interactive reconnect", func() {
+	It("should redial a connection lost to a daemon restart before the next command", func() {
+		srv := exetest.NewServer(exetest.Application{ID: 7, Name: "Firefox"})
+		socket, err := srv.Start()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(srv.Close)
+		client, err := exe.Dial(socket)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		var out bytes.Buffer
+		r := &runner{client: client, out: &out, keepGoing: true, reconnect: true}
+		Expect(r.exec("list")).To(Succeed())
+		Expect(out.String()).NotTo(ContainSubstring("(reconnected)"))
+
+		srv.Disconnect()
+		Eventually(client.Alive).Should(BeFalse())
+		out.Reset()
+		Expect(r.exec("list")).To(Succeed())
+		Expect(out.String()).To(HavePrefix("> list\n(reconnected)\n"))
+		Expect(out.String()).To(ContainSubstring("7 Firefox"))
+		Expect(srv.Received("list")).To(HaveLen(2))
+	})
+
+	It("should report a daemon still down and retry on the next command", func() {
+		srv := exetest.NewServer()
+		socket, err := srv.Start()
+		Expect(err).NotTo(HaveOccurred())
+		client, err := exe.Dial(socket)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		var out bytes.Buffer
+		r := &runner{client: client, out: &out, keepGoing: true, reconnect: true}
+		Expect(srv.Close()).To(Succeed())
+		err = r.exec("list")
+		Expect(err).To(MatchError(exe.ErrNotConnected))
+		Expect(out.String()).To(ContainSubstring("error: "))
+		Expect(out.String()).NotTo(ContainSubstring("(reconnected)"))
+		Expect(client.Alive()).To(BeFalse())
+	})
+})
+
+var _ = DescribeTable("parseLine",
+	func(line, name string, args []any, fails bool) {
+		gotName, gotArgs, err := parseLine(line)
+		if fails {
+			Expect(err).To(HaveOccurred())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gotName).To(Equal(name))
+		Expect(gotArgs).To(Equal(args))
+	},
+	Entry("no arguments", "list", "list", []any{}, false),
+	Entry("integers", "list-next 20 10", "list-next", []any{int64(20), int64(10)}, false),
+	Entry("words", "+filter-cat Network", "+filter-cat", []any{"Network"}, false),
+	Entry("quoted strings keep spaces", `+filter-name  "Web Browser" or`, "+filter-name", []any{`"Web Browser`, "or"}, false),
+	Entry("quoted numbers stay strings", `search "42"`, "search", []any{`"42`}, false),
+	Entry("unterminated quote", `search "web`, "", nil, true),
+	Entry("quoted command", `"list"`, "", nil, true),
+)
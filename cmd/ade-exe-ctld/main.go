@@ -9,6 +9,7 @@ import (
 
 	"github.com/0xADE/ade-ctld/internal/config"
 	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/indexer/fdlimit"
 	"github.com/0xADE/ade-ctld/server"
 )
 
@@ -25,6 +26,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Large trees need many descriptors while scanning
+	if limit, err := fdlimit.Raise(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to raise the open file limit (%d): %v\n", limit, err)
+	}
+
 	// Create indexer
 	idx := indexer.NewIndexer()
 
@@ -32,19 +38,24 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start indexing
-	if err := idx.Start(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start indexer: %v\n", err)
-		os.Exit(1)
+	// Reindex when ignore files change, from the first run on
+	if err := idx.WatchIgnoreFiles(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to watch ignore files: %v\n", err)
 	}
 
-	// Create server
+	// Create server, before indexing as it sets up what the daemon of a split setup indexes
 	srv, err := server.NewServer(idx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create server: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Start indexing
+	if err := idx.Start(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start indexer: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Start server in goroutine
 	serverErr := make(chan error, 1)
 	go func() {
@@ -57,14 +68,22 @@ func main() {
 
 	fmt.Println("ade-exe-ctld started")
 
-	select {
-	case sig := <-sigChan:
-		fmt.Printf("\nReceived signal: %v\n", sig)
+	shutdown := func() {
 		cancel()
 		idx.Stop()
 		if err := srv.Stop(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error stopping server: %v\n", err)
 		}
+	}
+
+	select {
+	case sig := <-sigChan:
+		fmt.Printf("\nReceived signal: %v\n", sig)
+		shutdown()
+	case <-srv.Idle():
+		// Started on demand, e.g. by socket activation: nothing to do until the next client
+		fmt.Println("Idle, exiting")
+		shutdown()
 	case err := <-serverErr:
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
@@ -0,0 +1,104 @@
+// Package execline splits command lines into arguments and joins arguments back into
+// command lines, with the quoting of desktop entry Exec values: arguments are separated by
+// spaces or tabs, and double quotes keep an argument whole, a backslash in them escaping the
+// next character. Clients quote the arguments of run requests with Join and the server
+// splits them with Split, so both agree on argument boundaries. Split(Join(args)) returns
+// args for every args without line breaks, which can't be sent in a request.
+package execline
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrNewline is returned for command lines, and arguments, holding a line break
+	ErrNewline = errors.New("line break in command line")
+	// ErrUnterminated is returned for command lines ending inside double quotes
+	ErrUnterminated = errors.New("unterminated quote in command line")
+)
+
+// reserved are the characters an argument is quoted for, those the desktop entry
+// specification reserves
+const reserved = " \t\"'\\><~|&;$*?#()`"
+
+// escaped are the characters escaped with a backslash inside double quotes
+const escaped = "\"`$\\"
+
+// Split splits line into arguments. Unquoted backslashes are kept as they are, and nothing
+// is expanded: $VAR references and field codes are left to desktop.ExpandExecArgs.
+func Split(line string) ([]string, error) {
+	if strings.ContainsAny(line, "\n\r") {
+		return nil, ErrNewline
+	}
+
+	var (
+		args   []string
+		arg    strings.Builder
+		inArg  bool
+		quoted bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(line):
+			i++
+			arg.WriteByte(line[i])
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (c == ' ' || c == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+			}
+			arg.Reset()
+			inArg = false
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, ErrUnterminated
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// Join joins args into a command line, quoting the empty ones and those holding reserved
+// characters. Arguments holding a line break are joined too, but Split rejects the result;
+// see Valid.
+func Join(args []string) string {
+	var line strings.Builder
+	for i, arg := range args {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		if arg != "" && !strings.ContainsAny(arg, reserved) {
+			line.WriteString(arg)
+			continue
+		}
+		line.WriteByte('"')
+		for j := 0; j < len(arg); j++ {
+			if strings.IndexByte(escaped, arg[j]) >= 0 {
+				line.WriteByte('\\')
+			}
+			line.WriteByte(arg[j])
+		}
+		line.WriteByte('"')
+	}
+	return line.String()
+}
+
+// Valid returns ErrNewline if an argument of args holds a line break, the only arguments
+// Join can't round-trip
+func Valid(args []string) error {
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "\n\r") {
+			return ErrNewline
+		}
+	}
+	return nil
+}
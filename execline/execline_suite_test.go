@@ -0,0 +1,13 @@
+package execline
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestExecline(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Execline Suite")
+}
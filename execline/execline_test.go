@@ -0,0 +1,89 @@
+package execline
+
+import (
+	"math/rand/v2"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// randomArgs returns up to 5 arguments made of runes quoting cares about, empty ones included
+func randomArgs(r *rand.Rand) []string {
+	runes := []rune(" \t\"'\\$`%;#*~a z/é.-=")
+	args := make([]string, r.IntN(6))
+	for i := range args {
+		var arg strings.Builder
+		for range r.IntN(8) {
+			arg.WriteRune(runes[r.IntN(len(runes))])
+		}
+		args[i] = arg.String()
+	}
+	return args
+}
+
+var _ = Describe("execline", func() {
+	It("should split arguments by spaces and tabs, keeping quoted ones whole", func() {
+		args, err := Split(`  gimp	"My Pictures/a b.png" -n"ew win"dow "" "say \"hi\" \$HOME \\" c:\dir`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(args).To(Equal([]string{"gimp", "My Pictures/a b.png", "-new window", "", `say "hi" $HOME \`, `c:\dir`}))
+	})
+
+	It("should leave references and field codes unexpanded", func() {
+		Expect(Split(`$HOME %f "${X}"`)).To(Equal([]string{"$HOME", "%f", "${X}"}))
+	})
+
+	It("should split blank lines into no arguments", func() {
+		Expect(Split("")).To(BeEmpty())
+		Expect(Split(" \t ")).To(BeEmpty())
+	})
+
+	It("should reject unterminated quotes", func() {
+		_, err := Split(`gimp "a b`)
+		Expect(err).To(MatchError(ErrUnterminated))
+		_, err = Split(`gimp "a b\"`)
+		Expect(err).To(MatchError(ErrUnterminated))
+	})
+
+	It("should reject line breaks", func() {
+		_, err := Split("gimp\nrm")
+		Expect(err).To(MatchError(ErrNewline))
+		_, err = Split(`"a` + "\r" + `b"`)
+		Expect(err).To(MatchError(ErrNewline))
+		Expect(Valid([]string{"a", "b\nc"})).To(MatchError(ErrNewline))
+		Expect(Valid([]string{"a", `b c"`})).To(Succeed())
+	})
+
+	It("should only quote the arguments needing it", func() {
+		Expect(Join([]string{"gimp", "a b.png", "", `"hi"`, "$HOME", `c:\dir`, "-x=1"})).
+			To(Equal(`gimp "a b.png" "" "\"hi\"" "\$HOME" "c:\\dir" -x=1`))
+		Expect(Join(nil)).To(BeEmpty())
+	})
+
+	It("should round-trip any arguments without line breaks", func() {
+		r := rand.New(rand.NewPCG(1, 2))
+		for range 10000 {
+			args := randomArgs(r)
+			line := Join(args)
+			Expect(strings.ContainsAny(line, "\n\r")).To(BeFalse())
+			split, err := Split(line)
+			Expect(err).NotTo(HaveOccurred(), "%q", line)
+			if len(args) == 0 {
+				Expect(split).To(BeEmpty())
+			} else {
+				Expect(split).To(Equal(args), "%q", line)
+			}
+		}
+	})
+
+	It("should reject joined arguments holding line breaks", func() {
+		r := rand.New(rand.NewPCG(3, 4))
+		for range 1000 {
+			args := append(randomArgs(r), "a\nb")
+			r.Shuffle(len(args), func(i, j int) { args[i], args[j] = args[j], args[i] })
+			_, err := Split(Join(args))
+			Expect(err).To(MatchError(ErrNewline))
+			Expect(Valid(args)).To(MatchError(ErrNewline))
+		}
+	})
+})
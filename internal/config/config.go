@@ -1,19 +1,30 @@
 package config
 
 import (
-	"bufio"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/0xADE/ade-ctld/internal/events"
+	"github.com/0xADE/ade-ctld/internal/perm"
 	"github.com/fsnotify/fsnotify"
 	"github.com/kelseyhightower/envconfig"
 )
 
-const idxrc = "~/.config/ade/indexd.rc"
+const (
+	idxrc    = "~/.config/ade/indexd.rc"
+	systemrc = "/etc/ade/indexd.rc" // defaults of the admin, read before the user's rc
+
+	systemSocket = "/run/ade/indexd" // default socket of the system daemon
+)
 
 var (
 	globalConfig *config
@@ -24,19 +35,62 @@ type config struct {
 	static  env
 	dynamic rc
 	watcher *fsnotify.Watcher
+	bus     atomic.Pointer[events.Bus] // told about rc reloads
+
+	originsMu sync.RWMutex
+	origins   map[string]Origin // variable -> where its value comes from, when not defaulted
 }
 
 type (
 	env struct {
-		Path       string `envconfig:"PATH"`
-		Terminal   string `envconfig:"ADE_DEFAULT_TERM"`
-		UnixSocket string `envconfig:"ADE_INDEXD_SOCK"`
-		Workers    int    `envconfig:"ADE_INDEXD_WORKERS" default:"4"`
-		ListLimit  int    `envconfig:"ADE_INDEXD_LIST_LIMIT" default:"128"`
+		Path            string        `envconfig:"PATH"`
+		Terminal        string        `envconfig:"ADE_DEFAULT_TERM"`
+		UnixSocket      string        `envconfig:"ADE_INDEXD_SOCK"`
+		Workers         int           `envconfig:"ADE_INDEXD_WORKERS" default:"4"`
+		ListLimit       int           `envconfig:"ADE_INDEXD_LIST_LIMIT" default:"128"`
+		Sort            string        `envconfig:"ADE_INDEXD_SORT" default:"freq"`
+		Launch          string        `envconfig:"ADE_INDEXD_LAUNCH" default:"auto"`
+		SessionTTL      time.Duration `envconfig:"ADE_INDEXD_SESSION_TTL" default:"24h"`
+		EventWindow     time.Duration `envconfig:"ADE_INDEXD_EVENT_WINDOW" default:"300ms"`
+		EventInterval   time.Duration `envconfig:"ADE_INDEXD_EVENT_INTERVAL" default:"1s"`
+		RecentFiles     bool          `envconfig:"ADE_INDEXD_RECENT_FILES" default:"false"`
+		Umask           uint32        `envconfig:"ADE_INDEXD_UMASK" default:"0022"`
+		Heartbeat       time.Duration `envconfig:"ADE_INDEXD_HEARTBEAT" default:"30s"`
+		IdleTimeout     time.Duration `envconfig:"ADE_INDEXD_IDLE_TIMEOUT" default:"0"`
+		IdleExit        seconds       `envconfig:"ADE_INDEXD_IDLE_EXIT" default:"0"`
+		InspectWrappers bool          `envconfig:"ADE_INDEXD_INSPECT_WRAPPERS" default:"false"`
+		Exclude         string        `envconfig:"ADE_INDEXD_EXCLUDE"`
+		Prune           string        `envconfig:"ADE_INDEXD_PRUNE" default:".git:.hg:.svn:node_modules:.cache:Trash:.local/share/Trash:__pycache__"`
+		AuditMaxAge     time.Duration `envconfig:"ADE_INDEXD_AUDIT_MAX_AGE" default:"720h"`
+		AuditMaxSize    int64         `envconfig:"ADE_INDEXD_AUDIT_MAX_SIZE" default:"10485760"`
+		AppLogMaxAge    time.Duration `envconfig:"ADE_INDEXD_APP_LOG_MAX_AGE" default:"336h"`
+		InvalidUTF8     string        `envconfig:"ADE_INDEXD_INVALID_UTF8" default:"reject"`
+		LogSensitive    bool          `envconfig:"ADE_INDEXD_LOG_SENSITIVE" default:"false"`
+		ChangedBinary   string        `envconfig:"ADE_INDEXD_CHANGED_BINARY" default:"warn"`
+		Transliterate   bool          `envconfig:"ADE_INDEXD_TRANSLITERATE" default:"false"`
+		System          bool          `envconfig:"ADE_INDEXD_SYSTEM" default:"false"`
+		Upstream        string        `envconfig:"ADE_INDEXD_UPSTREAM"`
+		CacheDir        string        `envconfig:"ADE_INDEXD_CACHE_DIR"`
+		SlowRoot        time.Duration `envconfig:"ADE_INDEXD_SLOW_ROOT" default:"10s"`
+		AutostartDirs   string        `envconfig:"ADE_INDEXD_AUTOSTART_DIRS" default:"~/.config/autostart:/etc/xdg/autostart"`
+		AppImageDirs    string        `envconfig:"ADE_INDEXD_APPIMAGE_DIRS"`
+		MaxEntries      int           `envconfig:"ADE_INDEXD_MAX_ENTRIES" default:"200000"`
+		FileMode        uint32        `envconfig:"ADE_INDEXD_FILE_MODE" default:"0600"`
+		Companions      string        `envconfig:"ADE_INDEXD_COMPANIONS" default:"ade-exe-cli:ade-exe-client"`
+		RunSelf         bool          `envconfig:"ADE_INDEXD_RUN_SELF" default:"false"`
+		MaxFilters      int           `envconfig:"ADE_INDEXD_MAX_FILTERS" default:"64"`
+		RunDebounceMS   int           `envconfig:"ADE_INDEXD_RUN_DEBOUNCE_MS" default:"0"`
+		MaxFilterBytes  int           `envconfig:"ADE_INDEXD_MAX_FILTER_BYTES" default:"65536"`
 	}
 	rc struct {
 		sync.RWMutex
+		system          []string // system rc file lines, read-only
 		additionalPaths []string
+		scanRoots       map[string][]string // scanner name -> extra roots
+		renames         []RenameRule        // display names of executables, first match wins
+		lines           []string            // rc file lines as edited in memory
+		loaded          []string            // rc file lines as last loaded or saved
+		loadedHash      [sha256.Size]byte   // hash of the rc file content as last loaded or saved
 	}
 )
 
@@ -47,11 +101,16 @@ func Init() error {
 		globalConfig = &config{}
 
 		// Load environment variables
-		if err = envconfig.Process("", &globalConfig.static); err != nil {
+		if err = globalConfig.loadEnv(); err != nil {
 			return
 		}
+		// Before the rc file is created
+		perm.Set(globalConfig.FileMode())
 
-		// Set default socket path if not provided
+		// Set default socket path if not provided, the system daemon serves every user
+		if globalConfig.static.UnixSocket == "" && globalConfig.static.System {
+			globalConfig.static.UnixSocket = systemSocket
+		}
 		if globalConfig.static.UnixSocket == "" {
 			currentUser, err := user.Current()
 			if err != nil {
@@ -82,6 +141,15 @@ func Init() error {
 	return err
 }
 
+// loadEnv reads the settings of the environment, recording which ones are set there
+func (c *config) loadEnv() error {
+	if err := envconfig.Process("", &c.static); err != nil {
+		return err
+	}
+	c.trackEnv()
+	return nil
+}
+
 // Run starts the configuration watcher loop
 func Run() error {
 	if globalConfig == nil {
@@ -102,47 +170,109 @@ func Get() *config {
 	return globalConfig
 }
 
+// For testing purposes - allow overriding the rc file locations
+var (
+	rcPathFunc       = func() string { return expandPath(idxrc) }
+	systemRCPathFunc = func() string { return systemrc }
+)
+
+// loadRC reads the system rc file and the user's one. The settings of both apply, those
+// of the user after the system ones; only the user's file is edited and saved.
 func (c *config) loadRC() error {
-	rcPath := expandPath(idxrc)
+	rcPath := rcPathFunc()
+
+	// The system rc file is optional
+	system, err := os.ReadFile(systemRCPathFunc())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 
 	// Create directory if it doesn't exist
 	rcDir := filepath.Dir(rcPath)
-	if err := os.MkdirAll(rcDir, 0750); err != nil {
+	if err := os.MkdirAll(rcDir, perm.Dir()); err != nil {
 		return err
 	}
 
 	// Try to read rc file
-	file, err := os.Open(rcPath)
+	data, err := os.ReadFile(rcPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Create empty file
-			file, err = os.Create(rcPath)
-			if err != nil {
-				return err
-			}
-			file.Close()
-			return nil
+		if !os.IsNotExist(err) {
+			return err
 		}
-		return err
+		// Create empty file
+		file, err := os.OpenFile(rcPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm.File())
+		if err != nil {
+			return err
+		}
+		file.Close()
 	}
-	defer file.Close()
 
 	c.dynamic.Lock()
 	defer c.dynamic.Unlock()
 
-	c.dynamic.additionalPaths = []string{}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	c.dynamic.system = splitLines(system)
+	lines := splitLines(data)
+	// Edits not saved yet survive a reload unless the file changed in the same lines
+	if c.dynamic.dirty() {
+		merged, conflict := merge3(c.dynamic.loaded, c.dynamic.lines, lines)
+		if conflict != nil {
+			fmt.Fprintf(os.Stderr, "Dropping unsaved config changes conflicting with %s\n", rcPath)
+		} else {
+			c.dynamic.setLoaded(data)
+			c.dynamic.setLines(merged)
+			return nil
+		}
+	}
+	c.dynamic.setLoaded(data)
+	return nil
+}
+
+// setLoaded makes data the rc file content as last seen on disk and the state in memory
+func (r *rc) setLoaded(data []byte) {
+	r.loaded = splitLines(data)
+	r.loadedHash = sha256.Sum256(data)
+	r.setLines(r.loaded)
+}
+
+// dirty reports whether the rc file was edited in memory since it was loaded or saved
+func (r *rc) dirty() bool {
+	return !slices.Equal(r.lines, r.loaded)
+}
+
+// setLines replaces the rc file lines in memory and the settings parsed from them, after
+// those of the system rc file
+func (r *rc) setLines(lines []string) {
+	r.lines = slices.Clone(lines)
+	r.additionalPaths = []string{}
+	r.scanRoots = make(map[string][]string)
+	r.renames = nil
+	for _, line := range slices.Concat(r.system, lines) {
+		line = strings.TrimSpace(line)
 		// Skip empty lines and comments
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
+		// "scan <scanner> <root>" adds a root to a named scanner
+		if rest, ok := strings.CutPrefix(line, "scan "); ok {
+			name, root, ok := strings.Cut(strings.TrimSpace(rest), " ")
+			if root = strings.TrimSpace(root); ok && root != "" {
+				r.scanRoots[name] = append(r.scanRoots[name], expandPath(root))
+			}
+			continue
+		}
+		// "rename <regexp> <template>" gives matching executables a display name
+		if rest, ok := strings.CutPrefix(line, "rename "); ok {
+			rule, err := ParseRenameRule(rest)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping rc line: %v\n", err)
+				continue
+			}
+			r.renames = append(r.renames, rule)
+			continue
+		}
 		expanded := expandPath(line)
-		c.dynamic.additionalPaths = append(c.dynamic.additionalPaths, expanded)
+		r.additionalPaths = append(r.additionalPaths, expanded)
 	}
-
-	return scanner.Err()
 }
 
 func (c *config) setupWatcher() error {
@@ -152,7 +282,7 @@ func (c *config) setupWatcher() error {
 	}
 
 	c.watcher = watcher
-	rcPath := expandPath(idxrc)
+	rcPath := rcPathFunc()
 	rcDir := filepath.Dir(rcPath)
 
 	// Watch the directory
@@ -160,9 +290,22 @@ func (c *config) setupWatcher() error {
 		return err
 	}
 
+	// The system rc directory is watched if it exists, it's not ours to create
+	if systemDir := filepath.Dir(systemRCPathFunc()); systemDir != rcDir {
+		if err := watcher.Add(systemDir); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Can't watch %s: %v\n", systemDir, err)
+		}
+	}
+
 	return nil
 }
 
+// SetBus makes the watcher publish events.ConfigChanged on bus after every reload of the
+// rc file
+func (c *config) SetBus(bus *events.Bus) {
+	c.bus.Store(bus)
+}
+
 func (c *config) watchLoop() {
 	for {
 		select {
@@ -170,12 +313,18 @@ func (c *config) watchLoop() {
 			if !ok {
 				return
 			}
-			rcPath := expandPath(idxrc)
-			if event.Name == rcPath && (event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create) {
+			rcPath := event.Name
+			if rcPath != rcPathFunc() && rcPath != systemRCPathFunc() {
+				continue
+			}
+			// A removed system rc file takes its paths along
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 || (rcPath == systemRCPathFunc() && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0) {
 				if err := c.loadRC(); err != nil {
 					// Log error but continue
 					fmt.Fprintf(os.Stderr, "Error reloading config: %v\n", err)
+					continue
 				}
+				events.Publish(c.bus.Load(), events.ConfigChanged{Path: rcPath})
 			}
 		case err, ok := <-c.watcher.Errors:
 			if !ok {
@@ -186,21 +335,32 @@ func (c *config) watchLoop() {
 	}
 }
 
-// Path returns all paths to search (PATH + additional paths from rc)
+// Path returns all paths to search: PATH followed by the additional paths from the system
+// rc file and then the user's one. Like in the shell the first occurrence of a directory
+// wins, later repeats are dropped.
 func (c *config) Path() []string {
+	searchPaths := c.SearchPaths()
+	paths := make([]string, 0, len(searchPaths))
+	for _, p := range searchPaths {
+		paths = append(paths, p.Path)
+	}
+	return paths
+}
+
+// RCPaths returns the additional paths from the rc files alone, without PATH, those of the
+// system rc file first
+func (c *config) RCPaths() []string {
 	c.dynamic.RLock()
 	defer c.dynamic.RUnlock()
+	return slices.Clone(c.dynamic.additionalPaths)
+}
 
-	paths := strings.Split(c.static.Path, ":")
-	// Filter empty paths
-	filtered := make([]string, 0, len(paths)+len(c.dynamic.additionalPaths))
-	for _, p := range paths {
-		if p != "" {
-			filtered = append(filtered, p)
-		}
-	}
-	filtered = append(filtered, c.dynamic.additionalPaths...)
-	return filtered
+// ScanRoots returns the roots added to the named scanner in the rc files with
+// "scan <scanner> <root>" lines
+func (c *config) ScanRoots(name string) []string {
+	c.dynamic.RLock()
+	defer c.dynamic.RUnlock()
+	return slices.Clone(c.dynamic.scanRoots[name])
 }
 
 // Terminal returns the default terminal command
@@ -228,14 +388,290 @@ func (c *config) Workers() int {
 	return c.static.Workers
 }
 
-// ListLimit returns the configured list limit
+// ListLimit returns the configured list limit, 0 when lists aren't limited
 func (c *config) ListLimit() int {
-	if c.static.ListLimit <= 0 {
+	if c.static.ListLimit < 0 {
 		return 128 // Default
 	}
 	return c.static.ListLimit
 }
 
+// SetListLimit overrides the list limit like ADE_INDEXD_LIST_LIMIT, so tests can page lists
+// of a few entries. Settings tells it comes from a flag.
+func (c *config) SetListLimit(limit int) {
+	c.static.ListLimit = limit
+	c.setOrigin("ADE_INDEXD_LIST_LIMIT", FromFlag)
+}
+
+// SortOrder returns the order lists are sorted in when the request doesn't ask for one:
+// freq, name or mtime
+func (c *config) SortOrder() string {
+	return c.static.Sort
+}
+
+// InvalidUTF8 returns what happens to request strings that aren't valid UTF-8: reject or
+// replace
+func (c *config) InvalidUTF8() string {
+	return c.static.InvalidUTF8
+}
+
+// LaunchMode returns how launched entries are started: exec, systemd or auto
+func (c *config) LaunchMode() string {
+	return c.static.Launch
+}
+
+// SessionTTL returns how long persisted session snapshots can be resumed
+func (c *config) SessionTTL() time.Duration {
+	if c.static.SessionTTL <= 0 {
+		return 24 * time.Hour // Default
+	}
+	return c.static.SessionTTL
+}
+
+// EventWindow returns the window index changes are coalesced in before subscribers are notified
+func (c *config) EventWindow() time.Duration {
+	if c.static.EventWindow <= 0 {
+		return 300 * time.Millisecond // Default
+	}
+	return c.static.EventWindow
+}
+
+// EventInterval returns the minimum time between two events sent to one subscriber
+func (c *config) EventInterval() time.Duration {
+	if c.static.EventInterval <= 0 {
+		return time.Second // Default
+	}
+	return c.static.EventInterval
+}
+
+// Heartbeat returns how long a subscriber may go without events before a heartbeat is sent,
+// 0 disables heartbeats
+func (c *config) Heartbeat() time.Duration {
+	if c.static.Heartbeat < 0 {
+		return 0
+	}
+	return c.static.Heartbeat
+}
+
+// RunDebounce returns the window within which a run repeating the previous one of the same
+// entry gets that launch back instead of starting another instance, 0 disables it
+func (c *config) RunDebounce() time.Duration {
+	return time.Duration(max(c.static.RunDebounceMS, 0)) * time.Millisecond
+}
+
+// IdleExit returns how long the daemon may go without clients and commands before it
+// exits, 0 keeps it running
+func (c *config) IdleExit() time.Duration {
+	return max(time.Duration(c.static.IdleExit), 0)
+}
+
+// seconds is a duration given as a number of seconds, or with a unit like other durations
+type seconds time.Duration
+
+// Decode implements envconfig.Decoder
+func (s *seconds) Decode(value string) error {
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		*s = seconds(n * float64(time.Second))
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*s = seconds(d)
+	return nil
+}
+
+// IdleTimeout returns how long a connection may stay silent before it's closed, 0 disables
+// the timeout
+func (c *config) IdleTimeout() time.Duration {
+	if c.static.IdleTimeout < 0 {
+		return 0
+	}
+	return c.static.IdleTimeout
+}
+
+// RecentFiles reports whether files opened through run are recorded in recently-used.xbel
+func (c *config) RecentFiles() bool {
+	return c.static.RecentFiles
+}
+
+// InspectWrappers reports whether trivial wrapper scripts are resolved to the binary they
+// exec when matching executables
+func (c *config) InspectWrappers() bool {
+	return c.static.InspectWrappers
+}
+
+// LogSensitive reports whether client strings, entry names and command lines are logged as
+// they are instead of redacted
+func (c *config) LogSensitive() bool {
+	return c.static.LogSensitive
+}
+
+// ChangedBinary returns what run does with a binary replaced or rewritten since it was
+// indexed: warn (launch it anyway) or refuse
+func (c *config) ChangedBinary() string {
+	return c.static.ChangedBinary
+}
+
+// Transliterate reports whether names written in Cyrillic, Greek or kana are also matched
+// by their Latin spelling
+func (c *config) Transliterate() bool {
+	return c.static.Transliterate
+}
+
+// System reports whether the daemon runs system-wide: it indexes what all users share and
+// serves read-only commands to everyone, see Upstream
+func (c *config) System() bool {
+	return c.static.System
+}
+
+// Upstream returns the socket of the system daemon whose index the daemon mirrors along
+// with its own, empty if it indexes everything itself
+func (c *config) Upstream() string {
+	return expandPath(c.static.Upstream)
+}
+
+// CacheDir returns the cache directory the daemon keeps its state in, in an ade
+// subdirectory: the run index and the logs. It's ADE_INDEXD_CACHE_DIR when set, the user
+// cache directory ($XDG_CACHE_HOME, ~/.cache by default) otherwise.
+func (c *config) CacheDir() (string, error) {
+	if c.static.CacheDir != "" {
+		return expandPath(c.static.CacheDir), nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user cache directory: %w", err)
+	}
+	return cacheDir, nil
+}
+
+// SetCacheDir overrides the cache directory like ADE_INDEXD_CACHE_DIR, so tests can point
+// a whole daemon at a temporary directory. Set it before the daemon is created. Settings
+// tells it comes from a flag.
+func (c *config) SetCacheDir(dir string) {
+	c.static.CacheDir = dir
+	c.setOrigin("ADE_INDEXD_CACHE_DIR", FromFlag)
+}
+
+// Exclude returns the glob patterns of files and directories scanners leave out, from the
+// colon separated ADE_INDEXD_EXCLUDE
+func (c *config) Exclude() []string {
+	var patterns []string
+	for _, pattern := range strings.Split(c.static.Exclude, ":") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// Prune returns the directories scanners never walk into, from the colon separated
+// ADE_INDEXD_PRUNE. Setting it empty prunes nothing.
+func (c *config) Prune() []string {
+	var dirs []string
+	for _, dir := range strings.Split(c.static.Prune, ":") {
+		if dir = strings.Trim(strings.TrimSpace(dir), "/"); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// AutostartDirs returns the directories of desktop files started with the session, from the
+// colon separated ADE_INDEXD_AUTOSTART_DIRS. The desktop scanner leaves them out even if they
+// are among its roots. Setting it empty leaves out none.
+func (c *config) AutostartDirs() []string {
+	var dirs []string
+	for _, dir := range strings.Split(c.static.AutostartDirs, ":") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, filepath.Clean(expandPath(dir)))
+		}
+	}
+	return dirs
+}
+
+// AppImageDirs returns the directories AppImages are kept in, like ~/Applications, from the
+// colon separated ADE_INDEXD_APPIMAGE_DIRS. The appimage scanner walks them along with its
+// roots of the rc file. Unset, it walks those alone.
+func (c *config) AppImageDirs() []string {
+	var dirs []string
+	for _, dir := range strings.Split(c.static.AppImageDirs, ":") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, filepath.Clean(expandPath(dir)))
+		}
+	}
+	return dirs
+}
+
+// Companions returns the names of the binaries installed next to the daemon's own that
+// are tagged along with it, from the colon separated ADE_INDEXD_COMPANIONS. Setting it
+// empty tags the daemon alone.
+func (c *config) Companions() []string {
+	var names []string
+	for _, name := range strings.Split(c.static.Companions, ":") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// RunSelf reports whether run may launch the daemon's own binary, which would start another
+// daemon fighting this one over the socket
+func (c *config) RunSelf() bool {
+	return c.static.RunSelf
+}
+
+// MaxFilters returns the number of filter expressions of each kind (name, category, path)
+// and of excluded categories a client may set, 0 for no limit
+func (c *config) MaxFilters() int {
+	return max(c.static.MaxFilters, 0)
+}
+
+// MaxFilterBytes returns the approximate memory all filters together may take, 0 for no limit
+func (c *config) MaxFilterBytes() int {
+	return max(c.static.MaxFilterBytes, 0)
+}
+
+// MaxEntries returns the number of entries beyond which indexing leaves out what it finds,
+// 0 for no limit
+func (c *config) MaxEntries() int {
+	return max(c.static.MaxEntries, 0)
+}
+
+// SlowRoot returns the time beyond which the scan of a root is logged as a warning, 0
+// never warns
+func (c *config) SlowRoot() time.Duration {
+	return max(c.static.SlowRoot, 0)
+}
+
+// AuditMaxAge returns how long audit log segments are kept, 0 keeps them forever
+func (c *config) AuditMaxAge() time.Duration {
+	return max(c.static.AuditMaxAge, 0)
+}
+
+// AuditMaxSize returns the total size in bytes the audit log is trimmed to, 0 means no cap
+func (c *config) AuditMaxSize() int64 {
+	return max(c.static.AuditMaxSize, 0)
+}
+
+// AppLogMaxAge returns how long the log of an application not launched since is kept, 0
+// keeps them forever
+func (c *config) AppLogMaxAge() time.Duration {
+	return max(c.static.AppLogMaxAge, 0)
+}
+
+// FileMode returns the mode of the files the daemon creates, see the perm package
+func (c *config) FileMode() os.FileMode {
+	return os.FileMode(c.static.FileMode & 0o777)
+}
+
+// Umask returns the file mode creation mask launched applications start with
+func (c *config) Umask() int {
+	return int(c.static.Umask & 0o777)
+}
+
 func expandPath(path string) string {
 	if strings.HasPrefix(path, "~") {
 		home, err := os.UserHomeDir()
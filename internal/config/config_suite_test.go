@@ -0,0 +1,20 @@
+package config
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Config Suite")
+}
+
+// The system rc file of the machine running the tests must not leak into them
+var _ = BeforeEach(func() {
+	oldSystemPath := systemRCPathFunc
+	systemRCPathFunc = func() string { return "/nonexistent/ade/indexd.rc" }
+	DeferCleanup(func() { systemRCPathFunc = oldSystemPath })
+})
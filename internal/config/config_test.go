@@ -0,0 +1,205 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/events"
+	"github.com/0xADE/ade-ctld/internal/perm"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Path", func() {
+	It("should drop repeated directories keeping the first occurrence", func() {
+		c := &config{static: env{Path: "/usr/local/bin:/usr/bin::/bin:/usr/bin/:/home/me/bin:/usr/local/bin"}}
+		c.dynamic.setLines([]string{"/home/me/bin", "/opt/tools/bin", "# /bin", "/bin"})
+
+		Expect(c.Path()).To(Equal([]string{"/usr/local/bin", "/usr/bin", "/bin", "/home/me/bin", "/opt/tools/bin"}))
+	})
+})
+
+var _ = Describe("RCPaths", func() {
+	It("should return only the paths of the rc file", func() {
+		c := &config{static: env{Path: "/usr/bin:/bin"}}
+		c.dynamic.setLines([]string{"/home/me/bin", "# /opt/old", "scan appimages /home/me/apps", "/bin"})
+
+		Expect(c.RCPaths()).To(Equal([]string{"/home/me/bin", "/bin"}))
+	})
+})
+
+var _ = Describe("CacheDir", func() {
+	It("should honor XDG_CACHE_HOME and the override", func() {
+		xdg := GinkgoT().TempDir()
+		GinkgoT().Setenv("XDG_CACHE_HOME", xdg)
+		c := &config{}
+		Expect(c.CacheDir()).To(Equal(xdg))
+
+		dir := GinkgoT().TempDir()
+		c.SetCacheDir(dir)
+		Expect(c.CacheDir()).To(Equal(dir))
+
+		home, err := os.UserHomeDir()
+		Expect(err).NotTo(HaveOccurred())
+		c = &config{static: env{CacheDir: "~/state"}}
+		Expect(c.CacheDir()).To(Equal(filepath.Join(home, "state")))
+	})
+})
+
+var _ = Describe("AppImageDirs", func() {
+	It("should walk no directory unless set", func() {
+		GinkgoT().Setenv("ADE_INDEXD_APPIMAGE_DIRS", "")
+		c := &config{}
+		Expect(c.loadEnv()).To(Succeed())
+		Expect(c.AppImageDirs()).To(BeEmpty())
+
+		home, err := os.UserHomeDir()
+		Expect(err).NotTo(HaveOccurred())
+		c = &config{static: env{AppImageDirs: "~/Applications: /opt/apps/::"}}
+		Expect(c.AppImageDirs()).To(Equal([]string{filepath.Join(home, "Applications"), "/opt/apps"}))
+	})
+})
+
+var _ = Describe("ListLimit", func() {
+	It("should take 0 for unlimited and fall back to the default below", func() {
+		GinkgoT().Setenv("ADE_INDEXD_LIST_LIMIT", "0")
+		c := &config{}
+		Expect(c.loadEnv()).To(Succeed())
+		Expect(c.ListLimit()).To(Equal(0))
+
+		c = &config{static: env{ListLimit: -1}}
+		Expect(c.ListLimit()).To(Equal(128))
+
+		c.SetListLimit(3)
+		Expect(c.ListLimit()).To(Equal(3))
+		Expect(c.origin("ADE_INDEXD_LIST_LIMIT")).To(Equal(FromFlag))
+	})
+})
+
+var _ = Describe("system rc file", func() {
+	var systemPath, userPath string
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		systemPath = filepath.Join(dir, "etc", "indexd.rc")
+		userPath = filepath.Join(dir, "home", "indexd.rc")
+		Expect(os.MkdirAll(filepath.Dir(systemPath), 0755)).To(Succeed())
+
+		oldPath, oldSystemPath := rcPathFunc, systemRCPathFunc
+		rcPathFunc = func() string { return userPath }
+		systemRCPathFunc = func() string { return systemPath }
+		DeferCleanup(func() { rcPathFunc, systemRCPathFunc = oldPath, oldSystemPath })
+	})
+
+	It("should add the paths of the user after those of the system", func() {
+		Expect(os.WriteFile(systemPath, []byte("/opt/site/bin\n/usr/games\nscan appimage /opt/apps\n"), 0644)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Dir(userPath), 0750)).To(Succeed())
+		Expect(os.WriteFile(userPath, []byte("/home/me/bin\n/usr/games\nscan appimage /home/me/apps\n"), 0600)).To(Succeed())
+
+		c := &config{static: env{Path: "/usr/bin"}}
+		Expect(c.loadRC()).To(Succeed())
+
+		Expect(c.RCPaths()).To(Equal([]string{"/opt/site/bin", "/usr/games", "/home/me/bin", "/usr/games"}))
+		Expect(c.Path()).To(Equal([]string{"/usr/bin", "/opt/site/bin", "/usr/games", "/home/me/bin"}))
+		Expect(c.ScanRoots("appimage")).To(Equal([]string{"/opt/apps", "/home/me/apps"}))
+	})
+
+	It("should save only the lines of the user", func() {
+		Expect(os.WriteFile(systemPath, []byte("/opt/site/bin\n"), 0644)).To(Succeed())
+
+		c := &config{}
+		Expect(c.loadRC()).To(Succeed())
+		Expect(c.AddPath("/home/me/bin")).To(BeTrue())
+		_, err := c.Save()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.ReadFile(userPath)).To(Equal([]byte("/home/me/bin\n")))
+		Expect(c.RCPaths()).To(Equal([]string{"/opt/site/bin", "/home/me/bin"}))
+	})
+
+	It("should do without a system rc file", func() {
+		c := &config{}
+		Expect(c.loadRC()).To(Succeed())
+		Expect(c.RCPaths()).To(BeEmpty())
+	})
+
+	It("should create the rc file of the user with the configured mode", func() {
+		DeferCleanup(perm.Set, perm.DefaultFile)
+		perm.Set(0640)
+		c := &config{}
+		Expect(c.loadRC()).To(Succeed())
+
+		info, err := os.Stat(userPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+		info, err = os.Stat(filepath.Dir(userPath))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0750)))
+	})
+
+	It("should reload when the system rc file changes", func() {
+		c := &config{}
+		Expect(c.loadRC()).To(Succeed())
+		Expect(c.setupWatcher()).To(Succeed())
+		DeferCleanup(c.watcher.Close)
+		go c.watchLoop()
+
+		bus := events.NewBus()
+		DeferCleanup(bus.Close)
+		c.SetBus(bus)
+		changed := make(chan events.ConfigChanged, 8)
+		events.Subscribe(bus, func(ev events.ConfigChanged) {
+			if slices.Equal(c.RCPaths(), []string{"/opt/site/bin"}) {
+				changed <- ev
+			}
+		})
+
+		Expect(os.WriteFile(systemPath, []byte("/opt/site/bin\n"), 0644)).To(Succeed())
+		Eventually(changed).Should(Receive(Equal(events.ConfigChanged{Path: systemPath})))
+	})
+})
+
+var _ = Describe("seconds", func() {
+	It("should read a bare number as seconds and accept durations", func() {
+		var s seconds
+		Expect(s.Decode("300")).To(Succeed())
+		Expect(time.Duration(s)).To(Equal(5 * time.Minute))
+		Expect(s.Decode("1.5")).To(Succeed())
+		Expect(time.Duration(s)).To(Equal(1500 * time.Millisecond))
+		Expect(s.Decode("10m")).To(Succeed())
+		Expect(time.Duration(s)).To(Equal(10 * time.Minute))
+		Expect(s.Decode("soon")).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("watcher", func() {
+	It("should publish the reloads of the rc file", func() {
+		rcPath := filepath.Join(GinkgoT().TempDir(), "indexd.rc")
+		oldPath := rcPathFunc
+		rcPathFunc = func() string { return rcPath }
+		DeferCleanup(func() { rcPathFunc = oldPath })
+
+		c := &config{}
+		Expect(c.loadRC()).To(Succeed())
+		Expect(c.setupWatcher()).To(Succeed())
+		DeferCleanup(c.watcher.Close)
+		go c.watchLoop()
+
+		bus := events.NewBus()
+		DeferCleanup(bus.Close)
+		c.SetBus(bus)
+		changed := make(chan events.ConfigChanged, 8)
+		events.Subscribe(bus, func(ev events.ConfigChanged) {
+			// By then the new paths are in effect
+			if slices.Equal(c.RCPaths(), []string{"/opt/tools/bin"}) {
+				changed <- ev
+			}
+		})
+
+		Expect(os.WriteFile(rcPath, []byte("/opt/tools/bin\n"), 0600)).To(Succeed())
+		Eventually(changed).Should(Receive(Equal(events.ConfigChanged{Path: rcPath})))
+	})
+})
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// RenameRule gives executables a display name: "rename <regexp> <template>" lines of the rc
+// files. The pattern is matched against the name, or against the full path if it holds a
+// "/"; the template is expanded like regexp.Regexp.Expand, so $1 or ${name} stand for the
+// submatches.
+type RenameRule struct {
+	Pattern  *regexp.Regexp
+	Template string
+	path     bool // match the path rather than the name
+}
+
+// ParseRenameRule parses the "<regexp> <template>" rest of a rename line
+func ParseRenameRule(rest string) (RenameRule, error) {
+	pattern, template, ok := strings.Cut(strings.TrimSpace(rest), " ")
+	template = strings.TrimSpace(template)
+	if !ok || template == "" {
+		return RenameRule{}, fmt.Errorf("rename %q: want a pattern and a template", rest)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return RenameRule{}, fmt.Errorf("rename %q: %w", rest, err)
+	}
+	return RenameRule{Pattern: re, Template: template, path: strings.Contains(pattern, "/")}, nil
+}
+
+// Apply returns the display name the rule gives the executable at path named name, and
+// whether it matches
+func (r RenameRule) Apply(name, path string) (string, bool) {
+	subject := name
+	if r.path {
+		subject = path
+	}
+	match := r.Pattern.FindStringSubmatchIndex(subject)
+	if match == nil {
+		return "", false
+	}
+	return string(r.Pattern.ExpandString(nil, r.Template, subject, match)), true
+}
+
+// Rename returns the display name the first matching rule of rules gives the executable at
+// path named name, and whether one matched
+func Rename(rules []RenameRule, name, path string) (string, bool) {
+	for _, rule := range rules {
+		if renamed, ok := rule.Apply(name, path); ok {
+			return renamed, true
+		}
+	}
+	return "", false
+}
+
+// RenameRules returns the rename rules of the rc files, those of the system rc file first.
+// The first one matching an executable wins.
+func (c *config) RenameRules() []RenameRule {
+	c.dynamic.RLock()
+	defer c.dynamic.RUnlock()
+	return slices.Clone(c.dynamic.renames)
+}
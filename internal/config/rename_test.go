@@ -0,0 +1,59 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RenameRules", func() {
+	It("should parse the rename lines and leave the paths alone", func() {
+		c := &config{}
+		c.dynamic.system = []string{"rename ^python([0-9.]+)$ Python $1"}
+		c.dynamic.setLines([]string{
+			"/home/me/bin",
+			"rename ^(?P<tool>[a-z]+)-cli$ ${tool} (command line)",
+			"rename ^broken( Broken",
+			"rename ^lonely$",
+			"# rename ^x$ X",
+		})
+
+		Expect(c.RCPaths()).To(Equal([]string{"/home/me/bin"}))
+		rules := c.RenameRules()
+		Expect(rules).To(HaveLen(2))
+		Expect(rules[0].Pattern.String()).To(Equal("^python([0-9.]+)$"))
+		Expect(rules[1].Template).To(Equal("${tool} (command line)"))
+	})
+
+	It("should apply the first matching rule", func() {
+		var rules []RenameRule
+		for _, line := range []string{
+			"^python3$ Python 3",
+			"^python([0-9.]+)$ Python $1",
+			"/opt/.*/bin/(.+)$ Opt $1",
+		} {
+			rule, err := ParseRenameRule(line)
+			Expect(err).NotTo(HaveOccurred())
+			rules = append(rules, rule)
+		}
+
+		rename := func(name, path string) string {
+			renamed, ok := Rename(rules, name, path)
+			if !ok {
+				return name
+			}
+			return renamed
+		}
+		Expect(rename("python3", "/usr/bin/python3")).To(Equal("Python 3"))
+		Expect(rename("python3.12", "/usr/bin/python3.12")).To(Equal("Python 3.12"))
+		// Patterns holding a slash match the path
+		Expect(rename("tool", "/opt/kit/bin/tool")).To(Equal("Opt tool"))
+		Expect(rename("tool", "/usr/bin/tool")).To(Equal("tool"))
+	})
+
+	It("should refuse lines without a template or with an invalid pattern", func() {
+		_, err := ParseRenameRule("^python$")
+		Expect(err).To(HaveOccurred())
+		_, err = ParseRenameRule("^python($ Python")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,288 @@
+package config
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/perm"
+)
+
+// ConflictError is returned by Save when the rc file was changed on disk in the same lines
+// that were edited in memory. Nothing is written then.
+type ConflictError struct {
+	Path string
+	Diff []string // Conflicting lines: "-" as on disk, "+" as in memory
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s changed on disk in the lines edited in memory", e.Path)
+}
+
+// AddPath adds a search path to the rc file in memory, see Save. It returns false if the
+// path is there already.
+func (c *config) AddPath(path string) bool {
+	path = strings.TrimSpace(path)
+	c.dynamic.Lock()
+	defer c.dynamic.Unlock()
+
+	if path == "" || slices.ContainsFunc(c.dynamic.lines, func(line string) bool { return strings.TrimSpace(line) == path }) {
+		return false
+	}
+	c.dynamic.setLines(append(slices.Clip(c.dynamic.lines), path))
+	return true
+}
+
+// RemovePath removes a search path from the rc file in memory, see Save. It returns false
+// if the path isn't there.
+func (c *config) RemovePath(path string) bool {
+	path = strings.TrimSpace(path)
+	c.dynamic.Lock()
+	defer c.dynamic.Unlock()
+
+	lines := slices.DeleteFunc(slices.Clone(c.dynamic.lines), func(line string) bool { return strings.TrimSpace(line) == path })
+	if len(lines) == len(c.dynamic.lines) {
+		return false
+	}
+	c.dynamic.setLines(lines)
+	return true
+}
+
+// Save writes the rc file as edited in memory. When the file changed on disk since it was
+// loaded, the edits of both sides are merged line by line, keeping comments and lines this
+// package doesn't know, and merged is true. Edits of the same lines on both sides make it
+// fail with a *ConflictError.
+func (c *config) Save() (merged bool, err error) {
+	c.dynamic.Lock()
+	defer c.dynamic.Unlock()
+
+	rcPath := rcPathFunc()
+	content, merged, err := c.render(rcPath)
+	if err != nil {
+		return false, err
+	}
+	if err := writeFileAtomic(rcPath, content, perm.File()); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", rcPath, err)
+	}
+	c.dynamic.setLoaded(content)
+	return merged, nil
+}
+
+// Preview returns the content Save would write, without writing it. It fails the same way
+// Save does.
+func (c *config) Preview() (content []byte, merged bool, err error) {
+	c.dynamic.Lock()
+	defer c.dynamic.Unlock()
+	return c.render(rcPathFunc())
+}
+
+// render builds the rc file content to write to rcPath, merged with the edits made on disk.
+// The caller holds the dynamic lock.
+func (c *config) render(rcPath string) (content []byte, merged bool, err error) {
+	data, err := os.ReadFile(rcPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	lines := c.dynamic.lines
+	if sha256.Sum256(data) != c.dynamic.loadedHash {
+		var conflict []string
+		lines, conflict = merge3(c.dynamic.loaded, c.dynamic.lines, splitLines(data))
+		if conflict != nil {
+			return nil, false, &ConflictError{Path: rcPath, Diff: conflict}
+		}
+		merged = true
+	}
+	return []byte(joinLines(lines)), merged, nil
+}
+
+// writeFileAtomic replaces path with data through a temporary file renamed over it, so
+// readers never see a partial file. The file and then the directory are synced, so the
+// rename survives a crash. An existing file keeps its permissions.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// splitLines splits file content into lines without their line breaks
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// joinLines is the reverse of splitLines, ending every line with a line break
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// merge3 merges the changes made from base to ours and from base to theirs. Regions where
+// only one side changed take that side. Lines both sides inserted at the same place are all
+// kept, theirs first, since the order of rc lines doesn't matter, and so are lines inserted
+// by one side next to lines the other side only deleted. Regions of base changed
+// differently on both sides are conflicts: merged is nil then and conflict lists the lines
+// of both sides.
+func merge3(base, ours, theirs []string) (merged, conflict []string) {
+	toOurs := matchLines(base, ours)
+	toTheirs := matchLines(base, theirs)
+
+	merged = []string{}
+	i, j, k := 0, 0, 0
+	for i <= len(base) {
+		// Find the next base line kept by both sides, or the end
+		b := i
+		for b < len(base) && (toOurs[b] < 0 || toTheirs[b] < 0) {
+			b++
+		}
+		oursEnd, theirsEnd := len(ours), len(theirs)
+		if b < len(base) {
+			oursEnd, theirsEnd = toOurs[b], toTheirs[b]
+		}
+
+		baseChunk, oursChunk, theirsChunk := base[i:b], ours[j:oursEnd], theirs[k:theirsEnd]
+		switch {
+		case slices.Equal(oursChunk, baseChunk):
+			merged = append(merged, theirsChunk...)
+		case slices.Equal(theirsChunk, baseChunk), slices.Equal(oursChunk, theirsChunk):
+			merged = append(merged, oursChunk...)
+		case len(baseChunk) == 0:
+			merged = append(merged, theirsChunk...)
+			for _, line := range oursChunk {
+				if !slices.Contains(theirsChunk, line) {
+					merged = append(merged, line)
+				}
+			}
+		case isSubsequence(oursChunk, baseChunk) && isSubsequence(baseChunk, theirsChunk):
+			merged = append(merged, withoutDeleted(theirsChunk, baseChunk, oursChunk)...)
+		case isSubsequence(theirsChunk, baseChunk) && isSubsequence(baseChunk, oursChunk):
+			merged = append(merged, withoutDeleted(oursChunk, baseChunk, theirsChunk)...)
+		default:
+			for _, line := range theirsChunk {
+				conflict = append(conflict, "-"+line)
+			}
+			for _, line := range oursChunk {
+				conflict = append(conflict, "+"+line)
+			}
+		}
+
+		if b == len(base) {
+			break
+		}
+		merged = append(merged, base[b])
+		i, j, k = b+1, oursEnd+1, theirsEnd+1
+	}
+
+	if conflict != nil {
+		return nil, conflict
+	}
+	return merged, nil
+}
+
+// isSubsequence reports whether all lines of a appear in b in the same order
+func isSubsequence(a, b []string) bool {
+	i := 0
+	for _, line := range b {
+		if i < len(a) && a[i] == line {
+			i++
+		}
+	}
+	return i == len(a)
+}
+
+// withoutDeleted returns the lines of grown, a superset of base, without the base lines
+// missing from shrunk
+func withoutDeleted(grown, base, shrunk []string) []string {
+	kept := matchLines(base, shrunk)
+	inGrown := matchLines(base, grown)
+	drop := make(map[int]bool)
+	for i, j := range kept {
+		if j < 0 {
+			drop[inGrown[i]] = true
+		}
+	}
+
+	result := []string{}
+	for i, line := range grown {
+		if !drop[i] {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// matchLines matches the lines of a to a longest common subsequence of b: the result holds
+// the index in b of every line of a, -1 for lines not kept
+func matchLines(a, b []string) []int {
+	// lcs[x][y] is the length of the longest common subsequence of a[x:] and b[y:]
+	lcs := make([][]int, len(a)+1)
+	for x := range lcs {
+		lcs[x] = make([]int, len(b)+1)
+	}
+	for x := len(a) - 1; x >= 0; x-- {
+		for y := len(b) - 1; y >= 0; y-- {
+			if a[x] == b[y] {
+				lcs[x][y] = lcs[x+1][y+1] + 1
+			} else {
+				lcs[x][y] = max(lcs[x+1][y], lcs[x][y+1])
+			}
+		}
+	}
+
+	match := make([]int, len(a))
+	x, y := 0, 0
+	for x < len(a) {
+		switch {
+		case y < len(b) && a[x] == b[y]:
+			match[x] = y
+			x++
+			y++
+		case y < len(b) && lcs[x][y+1] >= lcs[x+1][y]:
+			y++
+		default:
+			match[x] = -1
+			x++
+		}
+	}
+	return match
+}
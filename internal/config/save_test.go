@@ -0,0 +1,158 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Save", func() {
+	var (
+		tmpDir  string
+		rcPath  string
+		oldPath func() string
+		c       *config
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-config-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		rcPath = filepath.Join(tmpDir, "indexd.rc")
+		oldPath = rcPathFunc
+		rcPathFunc = func() string { return rcPath }
+
+		Expect(os.WriteFile(rcPath, []byte("# my tools\n/opt/tools/bin\n/srv/bin\nscan appimage /opt/apps\n"), 0600)).To(Succeed())
+		c = &config{}
+		Expect(c.loadRC()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		rcPathFunc = oldPath
+		os.RemoveAll(tmpDir)
+	})
+
+	read := func() string {
+		data, err := os.ReadFile(rcPath)
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	It("should write the changes made in memory", func() {
+		Expect(c.AddPath("~/bin")).To(BeTrue())
+		Expect(c.AddPath("~/bin")).To(BeFalse())
+		Expect(c.RemovePath("/srv/bin")).To(BeTrue())
+		Expect(c.RemovePath("/srv/bin")).To(BeFalse())
+
+		merged, err := c.Save()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(merged).To(BeFalse())
+		Expect(read()).To(Equal("# my tools\n/opt/tools/bin\nscan appimage /opt/apps\n~/bin\n"))
+
+		info, err := os.Stat(rcPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		entries, err := os.ReadDir(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("should preview the file without writing it", func() {
+		Expect(os.WriteFile(rcPath, []byte(read()+"/home/me/go/bin\n"), 0600)).To(Succeed())
+		c.AddPath("/usr/games")
+
+		content, merged, err := c.Preview()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(merged).To(BeTrue())
+		Expect(string(content)).To(HaveSuffix("/home/me/go/bin\n/usr/games\n"))
+		Expect(read()).NotTo(ContainSubstring("/usr/games"))
+
+		// Nothing was saved, so saving still merges
+		merged, err = c.Save()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(merged).To(BeTrue())
+		Expect(read()).To(Equal(string(content)))
+	})
+
+	It("should merge edits made on disk since the file was loaded", func() {
+		// Edited by the user while the daemon runs
+		Expect(os.WriteFile(rcPath, []byte("# my tools\n/opt/tools/bin\n# builds\n/srv/bin\n/home/me/go/bin\nscan appimage /opt/apps\n"), 0600)).To(Succeed())
+
+		c.AddPath("/usr/games")
+		c.RemovePath("/opt/tools/bin")
+
+		merged, err := c.Save()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(merged).To(BeTrue())
+		Expect(read()).To(Equal("# my tools\n# builds\n/srv/bin\n/home/me/go/bin\nscan appimage /opt/apps\n/usr/games\n"))
+		Expect(c.Path()).To(ContainElements("/srv/bin", "/home/me/go/bin", "/usr/games"))
+		Expect(c.Path()).NotTo(ContainElement("/opt/tools/bin"))
+
+		// The merged file is the new base: saving again changes nothing
+		merged, err = c.Save()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(merged).To(BeFalse())
+	})
+
+	It("should keep lines both sides added", func() {
+		Expect(os.WriteFile(rcPath, []byte(read()+"/home/me/.cargo/bin\n"), 0600)).To(Succeed())
+		c.AddPath("/home/me/.cargo/bin")
+		c.AddPath("/usr/games")
+
+		_, err := c.Save()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read()).To(HaveSuffix("scan appimage /opt/apps\n/home/me/.cargo/bin\n/usr/games\n"))
+	})
+
+	It("should refuse to save over a conflicting edit", func() {
+		edited := "# my tools\n/opt/tools/sbin\n/srv/bin\nscan appimage /opt/apps\n"
+		Expect(os.WriteFile(rcPath, []byte(edited), 0600)).To(Succeed())
+		c.RemovePath("/opt/tools/bin")
+
+		_, err := c.Save()
+		var conflict *ConflictError
+		Expect(errors.As(err, &conflict)).To(BeTrue())
+		Expect(conflict.Diff).To(Equal([]string{"-/opt/tools/sbin"}))
+		Expect(read()).To(Equal(edited))
+	})
+
+	It("should keep unsaved changes across a reload of an edited file", func() {
+		c.AddPath("/usr/games")
+		Expect(os.WriteFile(rcPath, []byte(read()+"/home/me/go/bin\n"), 0600)).To(Succeed())
+		Expect(c.loadRC()).To(Succeed())
+
+		Expect(c.Path()).To(ContainElements("/home/me/go/bin", "/usr/games"))
+		_, err := c.Save()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read()).To(HaveSuffix("/home/me/go/bin\n/usr/games\n"))
+	})
+})
+
+var _ = Describe("merge3", func() {
+	base := []string{"a", "b", "c"}
+
+	DescribeTable("merges line edits",
+		func(ours, theirs, merged []string) {
+			result, conflict := merge3(base, ours, theirs)
+			Expect(conflict).To(BeNil())
+			Expect(result).To(Equal(merged))
+		},
+		Entry("no changes", base, base, []string{"a", "b", "c"}),
+		Entry("only ours", []string{"a", "c"}, base, []string{"a", "c"}),
+		Entry("only theirs", base, []string{"a", "b", "x", "c"}, []string{"a", "b", "x", "c"}),
+		Entry("separate lines", []string{"b", "c"}, []string{"a", "b", "y"}, []string{"b", "y"}),
+		Entry("the same change", []string{"a", "x", "c"}, []string{"a", "x", "c"}, []string{"a", "x", "c"}),
+		Entry("both inserted", []string{"a", "b", "c", "x"}, []string{"a", "b", "c", "y", "x"}, []string{"a", "b", "c", "y", "x"}),
+		Entry("deleted next to inserted", []string{"a", "c"}, []string{"a", "x", "b", "c"}, []string{"a", "x", "c"}),
+		Entry("inserted next to deleted", []string{"a", "b", "y", "c"}, []string{"a", "c"}, []string{"a", "y", "c"}),
+	)
+
+	It("should report lines changed differently on both sides", func() {
+		result, conflict := merge3(base, []string{"a", "x", "c"}, []string{"a", "y", "c"})
+		Expect(result).To(BeNil())
+		Expect(conflict).To(Equal([]string{"-y", "+x"}))
+	})
+})
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Origin tells where the value of a setting comes from. A flag, set by the program the
+// daemon runs in, overrides the environment, which overrides the rc files, which override
+// the defaults.
+type Origin string
+
+const (
+	FromDefault Origin = "default"
+	FromRC      Origin = "rc"
+	FromEnv     Origin = "env"
+	FromFlag    Origin = "flag"
+)
+
+// Setting is a scalar setting as resolved, see Settings
+type Setting struct {
+	Name      string // like "list-limit"
+	Value     string // as it would be set in the environment
+	Origin    Origin
+	Sensitive bool // not to be shown as it is, like a secret
+}
+
+// SearchPath is a directory searched for executables, see SearchPaths
+type SearchPath struct {
+	Path   string
+	Origin Origin
+}
+
+// setting describes a scalar setting: the variables it's read from, the first one set
+// winning, and its value as resolved
+type setting struct {
+	name      string
+	keys      []string
+	value     func(c *config) string
+	sensitive bool
+}
+
+// settings lists the scalar settings in the order Settings returns them. Every variable of
+// env but PATH, reported by SearchPaths, has one.
+var settings = []setting{
+	{name: "socket", keys: []string{"ADE_INDEXD_SOCK"}, value: func(c *config) string { return c.UnixSocket() }},
+	{name: "system", keys: []string{"ADE_INDEXD_SYSTEM"}, value: func(c *config) string { return strconv.FormatBool(c.System()) }},
+	{name: "upstream", keys: []string{"ADE_INDEXD_UPSTREAM"}, value: func(c *config) string { return c.Upstream() }},
+	{name: "cache-dir", keys: []string{"ADE_INDEXD_CACHE_DIR"}, value: func(c *config) string {
+		dir, _ := c.CacheDir()
+		return dir
+	}},
+	{name: "terminal", keys: []string{"ADE_DEFAULT_TERM", "TERM"}, value: func(c *config) string { return c.Terminal() }},
+	{name: "workers", keys: []string{"ADE_INDEXD_WORKERS"}, value: func(c *config) string { return strconv.Itoa(c.Workers()) }},
+	{name: "max-entries", keys: []string{"ADE_INDEXD_MAX_ENTRIES"}, value: func(c *config) string { return strconv.Itoa(c.MaxEntries()) }},
+	{name: "max-filters", keys: []string{"ADE_INDEXD_MAX_FILTERS"}, value: func(c *config) string { return strconv.Itoa(c.MaxFilters()) }},
+	{name: "max-filter-bytes", keys: []string{"ADE_INDEXD_MAX_FILTER_BYTES"}, value: func(c *config) string { return strconv.Itoa(c.MaxFilterBytes()) }},
+	{name: "list-limit", keys: []string{"ADE_INDEXD_LIST_LIMIT"}, value: func(c *config) string { return strconv.Itoa(c.ListLimit()) }},
+	{name: "sort", keys: []string{"ADE_INDEXD_SORT"}, value: func(c *config) string { return c.SortOrder() }},
+	{name: "launch", keys: []string{"ADE_INDEXD_LAUNCH"}, value: func(c *config) string { return c.LaunchMode() }},
+	{name: "changed-binary", keys: []string{"ADE_INDEXD_CHANGED_BINARY"}, value: func(c *config) string { return c.ChangedBinary() }},
+	{name: "invalid-utf8", keys: []string{"ADE_INDEXD_INVALID_UTF8"}, value: func(c *config) string { return c.InvalidUTF8() }},
+	{name: "inspect-wrappers", keys: []string{"ADE_INDEXD_INSPECT_WRAPPERS"}, value: func(c *config) string { return strconv.FormatBool(c.InspectWrappers()) }},
+	{name: "transliterate", keys: []string{"ADE_INDEXD_TRANSLITERATE"}, value: func(c *config) string { return strconv.FormatBool(c.Transliterate()) }},
+	{name: "recent-files", keys: []string{"ADE_INDEXD_RECENT_FILES"}, value: func(c *config) string { return strconv.FormatBool(c.RecentFiles()) }},
+	{name: "log-sensitive", keys: []string{"ADE_INDEXD_LOG_SENSITIVE"}, value: func(c *config) string { return strconv.FormatBool(c.LogSensitive()) }},
+	{name: "exclude", keys: []string{"ADE_INDEXD_EXCLUDE"}, value: func(c *config) string { return strings.Join(c.Exclude(), ":") }},
+	{name: "prune", keys: []string{"ADE_INDEXD_PRUNE"}, value: func(c *config) string { return strings.Join(c.Prune(), ":") }},
+	{name: "autostart-dirs", keys: []string{"ADE_INDEXD_AUTOSTART_DIRS"}, value: func(c *config) string { return strings.Join(c.AutostartDirs(), ":") }},
+	{name: "appimage-dirs", keys: []string{"ADE_INDEXD_APPIMAGE_DIRS"}, value: func(c *config) string { return strings.Join(c.AppImageDirs(), ":") }},
+	{name: "companions", keys: []string{"ADE_INDEXD_COMPANIONS"}, value: func(c *config) string { return strings.Join(c.Companions(), ":") }},
+	{name: "run-self", keys: []string{"ADE_INDEXD_RUN_SELF"}, value: func(c *config) string { return strconv.FormatBool(c.RunSelf()) }},
+	{name: "run-debounce-ms", keys: []string{"ADE_INDEXD_RUN_DEBOUNCE_MS"}, value: func(c *config) string { return strconv.FormatInt(c.RunDebounce().Milliseconds(), 10) }},
+	{name: "slow-root", keys: []string{"ADE_INDEXD_SLOW_ROOT"}, value: func(c *config) string { return c.SlowRoot().String() }},
+	{name: "session-ttl", keys: []string{"ADE_INDEXD_SESSION_TTL"}, value: func(c *config) string { return c.SessionTTL().String() }},
+	{name: "event-window", keys: []string{"ADE_INDEXD_EVENT_WINDOW"}, value: func(c *config) string { return c.EventWindow().String() }},
+	{name: "event-interval", keys: []string{"ADE_INDEXD_EVENT_INTERVAL"}, value: func(c *config) string { return c.EventInterval().String() }},
+	{name: "heartbeat", keys: []string{"ADE_INDEXD_HEARTBEAT"}, value: func(c *config) string { return c.Heartbeat().String() }},
+	{name: "idle-timeout", keys: []string{"ADE_INDEXD_IDLE_TIMEOUT"}, value: func(c *config) string { return c.IdleTimeout().String() }},
+	{name: "idle-exit", keys: []string{"ADE_INDEXD_IDLE_EXIT"}, value: func(c *config) string { return c.IdleExit().String() }},
+	{name: "audit-max-age", keys: []string{"ADE_INDEXD_AUDIT_MAX_AGE"}, value: func(c *config) string { return c.AuditMaxAge().String() }},
+	{name: "audit-max-size", keys: []string{"ADE_INDEXD_AUDIT_MAX_SIZE"}, value: func(c *config) string { return strconv.FormatInt(c.AuditMaxSize(), 10) }},
+	{name: "app-log-max-age", keys: []string{"ADE_INDEXD_APP_LOG_MAX_AGE"}, value: func(c *config) string { return c.AppLogMaxAge().String() }},
+	{name: "file-mode", keys: []string{"ADE_INDEXD_FILE_MODE"}, value: func(c *config) string { return "0" + strconv.FormatUint(uint64(c.FileMode()), 8) }},
+	{name: "umask", keys: []string{"ADE_INDEXD_UMASK"}, value: func(c *config) string { return "0" + strconv.FormatUint(uint64(c.Umask()), 8) }},
+}
+
+// trackEnv records which of the variables of settings are set in the environment. The
+// values themselves are read by envconfig.
+func (c *config) trackEnv() {
+	keys := []string{"PATH"}
+	for _, s := range settings {
+		keys = append(keys, s.keys...)
+	}
+	for _, key := range keys {
+		// Set empty counts too, envconfig takes it over the default
+		if _, ok := os.LookupEnv(key); ok {
+			c.setOrigin(key, FromEnv)
+		}
+	}
+}
+
+// setOrigin records where the value of the variable key comes from
+func (c *config) setOrigin(key string, origin Origin) {
+	c.originsMu.Lock()
+	defer c.originsMu.Unlock()
+	if c.origins == nil {
+		c.origins = make(map[string]Origin)
+	}
+	c.origins[key] = origin
+}
+
+// origin returns where the value of the variable key comes from, the default unless it
+// was recorded otherwise
+func (c *config) origin(key string) Origin {
+	c.originsMu.RLock()
+	defer c.originsMu.RUnlock()
+	if origin, ok := c.origins[key]; ok {
+		return origin
+	}
+	return FromDefault
+}
+
+// Settings returns the scalar settings as resolved, defaults and fallbacks applied, each
+// with where its value comes from: that of the first of its variables not defaulted
+func (c *config) Settings() []Setting {
+	resolved := make([]Setting, 0, len(settings))
+	for _, s := range settings {
+		origin := FromDefault
+		for _, key := range s.keys {
+			if origin = c.origin(key); origin != FromDefault {
+				break
+			}
+		}
+		resolved = append(resolved, Setting{Name: s.name, Value: s.value(c), Origin: origin, Sensitive: s.sensitive})
+	}
+	return resolved
+}
+
+// SearchPaths returns the directories searched for executables like Path does, each with
+// where it comes from: PATH or the rc files. A directory in both comes from where it's
+// found first.
+func (c *config) SearchPaths() []SearchPath {
+	c.dynamic.RLock()
+	defer c.dynamic.RUnlock()
+
+	var paths []SearchPath
+	seen := make(map[string]bool)
+	add := func(path string, origin Origin) {
+		// Filter empty paths
+		if path == "" {
+			return
+		}
+		if key := filepath.Clean(path); !seen[key] {
+			seen[key] = true
+			paths = append(paths, SearchPath{Path: path, Origin: origin})
+		}
+	}
+	pathOrigin := c.origin("PATH")
+	for _, path := range strings.Split(c.static.Path, ":") {
+		add(path, pathOrigin)
+	}
+	for _, path := range c.dynamic.additionalPaths {
+		add(path, FromRC)
+	}
+	return paths
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"reflect"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Settings", func() {
+	// unset removes variables from the environment for the spec
+	unset := func(keys ...string) {
+		for _, key := range keys {
+			GinkgoT().Setenv(key, "")
+			Expect(os.Unsetenv(key)).To(Succeed())
+		}
+	}
+
+	// load reads a config from the environment and returns its settings by name
+	load := func(override func(c *config)) map[string]Setting {
+		c := &config{}
+		Expect(c.loadEnv()).To(Succeed())
+		if override != nil {
+			override(c)
+		}
+		byName := make(map[string]Setting)
+		for _, s := range c.Settings() {
+			byName[s.Name] = s
+		}
+		return byName
+	}
+
+	BeforeEach(func() {
+		for _, s := range settings {
+			unset(s.keys...)
+		}
+	})
+
+	It("should have a setting for every variable but PATH", func() {
+		keys := make(map[string]bool)
+		names := make(map[string]bool)
+		for _, s := range settings {
+			Expect(names).NotTo(HaveKey(s.name))
+			names[s.name] = true
+			for _, key := range s.keys {
+				keys[key] = true
+			}
+		}
+		fields := reflect.TypeFor[env]()
+		for i := range fields.NumField() {
+			if key := fields.Field(i).Tag.Get("envconfig"); key != "PATH" {
+				Expect(keys).To(HaveKey(key))
+			}
+		}
+	})
+
+	It("should tell defaults from nothing set", func() {
+		resolved := load(nil)
+		Expect(resolved["workers"]).To(Equal(Setting{Name: "workers", Value: "4", Origin: FromDefault}))
+		Expect(resolved["list-limit"]).To(Equal(Setting{Name: "list-limit", Value: "128", Origin: FromDefault}))
+		Expect(resolved["terminal"]).To(Equal(Setting{Name: "terminal", Value: "xterm", Origin: FromDefault}))
+		Expect(resolved["file-mode"].Value).To(Equal("0600"))
+		for name, s := range resolved {
+			Expect(s.Origin).To(Equal(FromDefault), name)
+		}
+	})
+
+	It("should take the environment over the defaults", func() {
+		GinkgoT().Setenv("ADE_INDEXD_WORKERS", "8")
+		GinkgoT().Setenv("ADE_INDEXD_HEARTBEAT", "1m")
+		resolved := load(nil)
+		Expect(resolved["workers"]).To(Equal(Setting{Name: "workers", Value: "8", Origin: FromEnv}))
+		Expect(resolved["heartbeat"]).To(Equal(Setting{Name: "heartbeat", Value: "1m0s", Origin: FromEnv}))
+		Expect(resolved["list-limit"].Origin).To(Equal(FromDefault))
+	})
+
+	It("should count a variable set empty as set", func() {
+		GinkgoT().Setenv("ADE_INDEXD_PRUNE", "")
+		resolved := load(nil)
+		Expect(resolved["prune"]).To(Equal(Setting{Name: "prune", Value: "", Origin: FromEnv}))
+	})
+
+	It("should take a flag over the default", func() {
+		dir := GinkgoT().TempDir()
+		resolved := load(func(c *config) { c.SetCacheDir(dir) })
+		Expect(resolved["cache-dir"]).To(Equal(Setting{Name: "cache-dir", Value: dir, Origin: FromFlag}))
+	})
+
+	It("should take a flag over the environment", func() {
+		GinkgoT().Setenv("ADE_INDEXD_CACHE_DIR", GinkgoT().TempDir())
+		Expect(load(nil)["cache-dir"].Origin).To(Equal(FromEnv))
+
+		dir := GinkgoT().TempDir()
+		resolved := load(func(c *config) { c.SetCacheDir(dir) })
+		Expect(resolved["cache-dir"]).To(Equal(Setting{Name: "cache-dir", Value: dir, Origin: FromFlag}))
+	})
+
+	It("should take the first variable set of a setting falling back to another", func() {
+		GinkgoT().Setenv("TERM", "foot")
+		Expect(load(nil)["terminal"]).To(Equal(Setting{Name: "terminal", Value: "foot", Origin: FromEnv}))
+
+		GinkgoT().Setenv("ADE_DEFAULT_TERM", "alacritty")
+		Expect(load(nil)["terminal"]).To(Equal(Setting{Name: "terminal", Value: "alacritty", Origin: FromEnv}))
+	})
+})
+
+var _ = Describe("SearchPaths", func() {
+	It("should tell the paths of PATH from those of the rc files", func() {
+		GinkgoT().Setenv("PATH", "/usr/bin:/bin")
+		c := &config{}
+		Expect(c.loadEnv()).To(Succeed())
+		c.dynamic.system = []string{"/opt/site/bin", "/usr/games"}
+		c.dynamic.setLines([]string{"/home/me/bin", "/usr/games", "/bin/"})
+
+		Expect(c.SearchPaths()).To(Equal([]SearchPath{
+			{Path: "/usr/bin", Origin: FromEnv},
+			{Path: "/bin", Origin: FromEnv},
+			{Path: "/opt/site/bin", Origin: FromRC},
+			{Path: "/usr/games", Origin: FromRC},
+			{Path: "/home/me/bin", Origin: FromRC},
+		}))
+		Expect(c.Path()).To(Equal([]string{"/usr/bin", "/bin", "/opt/site/bin", "/usr/games", "/home/me/bin"}))
+	})
+
+	It("should take the rc files alone without PATH", func() {
+		GinkgoT().Setenv("PATH", "")
+		Expect(os.Unsetenv("PATH")).To(Succeed())
+		c := &config{}
+		Expect(c.loadEnv()).To(Succeed())
+		c.dynamic.setLines([]string{"/home/me/bin"})
+
+		Expect(c.SearchPaths()).To(Equal([]SearchPath{{Path: "/home/me/bin", Origin: FromRC}}))
+	})
+})
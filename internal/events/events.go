@@ -0,0 +1,199 @@
+// Package events is the daemon's internal publish/subscribe bus. It carries signals between
+// components that must not import each other, config, indexer, runindex and server, so it
+// imports nothing of the project itself: the event types are declared here.
+//
+// Delivery:
+//   - Sync handlers run on the publishing goroutine, in subscription order, before Publish
+//     returns.
+//   - Async handlers run one at a time on the bus goroutine. Events are queued in the order
+//     they are published and every event reaches all its async handlers, in subscription
+//     order, before the next one is delivered. So an event published after another one
+//     returned, e.g. the index swap of a reindex triggered by a config change, is seen
+//     after it by every handler. Async handlers must not block: slow work goes to a
+//     goroutine of its own.
+//   - Close delivers what is already queued and then stops the bus. Events published after
+//     Close, including by handlers while it drains, are dropped.
+package events
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// ConfigChanged is published after the rc file was reloaded from disk
+type ConfigChanged struct {
+	Path string // the rc file
+}
+
+// IndexSwapped is published after the indexer replaced the index
+type IndexSwapped struct {
+	Generation uint64
+	Changes    int // entries added or removed
+}
+
+// RunRecorded is published after an entry was launched
+type RunRecorded struct {
+	Path string // the executable, as counted in the run index
+	PID  int
+}
+
+// Bus dispatches events to the handlers subscribed to their type. A nil *Bus drops
+// everything, so components work unwired.
+type Bus struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	subs   map[reflect.Type][]*handler
+	queue  []queued
+	closed bool
+	done   chan struct{}
+}
+
+type handler struct {
+	fn      func(any)
+	async   bool
+	removed atomic.Bool
+}
+
+// queued is an event waiting for its async handlers, as subscribed when it was published
+type queued struct {
+	event    any
+	handlers []*handler
+}
+
+// NewBus returns a bus with its dispatching goroutine running
+func NewBus() *Bus {
+	b := &Bus{
+		subs: make(map[reflect.Type][]*handler),
+		done: make(chan struct{}),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	go b.dispatch()
+	return b
+}
+
+// Subscribe calls fn for every event of type T, on the goroutine publishing it. It returns
+// a function removing the subscription.
+func Subscribe[T any](b *Bus, fn func(T)) (unsubscribe func()) {
+	return subscribe(b, fn, false)
+}
+
+// SubscribeAsync calls fn for every event of type T on the bus goroutine. It returns a
+// function removing the subscription; queued events are not delivered to it anymore.
+func SubscribeAsync[T any](b *Bus, fn func(T)) (unsubscribe func()) {
+	return subscribe(b, fn, true)
+}
+
+func subscribe[T any](b *Bus, fn func(T), async bool) func() {
+	if b == nil {
+		return func() {}
+	}
+	h := &handler{fn: func(ev any) { fn(ev.(T)) }, async: async}
+	typ := reflect.TypeFor[T]()
+
+	b.mu.Lock()
+	b.subs[typ] = append(b.subs[typ], h)
+	b.mu.Unlock()
+
+	return func() {
+		if h.removed.Swap(true) {
+			return
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.subs[typ] = removeHandler(b.subs[typ], h)
+	}
+}
+
+// removeHandler returns handlers without h, leaving the slice snapshots of Publish alone
+func removeHandler(handlers []*handler, h *handler) []*handler {
+	kept := make([]*handler, 0, len(handlers))
+	for _, other := range handlers {
+		if other != h {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+// Publish delivers ev to the handlers subscribed to its type. It returns false if the bus
+// is closed and the event was dropped.
+func Publish[T any](b *Bus, ev T) bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return false
+	}
+	var syncs, asyncs []*handler
+	for _, h := range b.subs[reflect.TypeFor[T]()] {
+		if h.async {
+			asyncs = append(asyncs, h)
+		} else {
+			syncs = append(syncs, h)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, h := range syncs {
+		if !h.removed.Load() {
+			h.fn(ev)
+		}
+	}
+
+	// Queued after the sync handlers returned, so whatever they publish in turn is
+	// delivered after it
+	if len(asyncs) > 0 {
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return false
+		}
+		b.queue = append(b.queue, queued{event: ev, handlers: asyncs})
+		b.cond.Signal()
+		b.mu.Unlock()
+	}
+	return true
+}
+
+// dispatch delivers queued events to their async handlers until the bus is closed and
+// drained
+func (b *Bus) dispatch() {
+	defer close(b.done)
+	for {
+		b.mu.Lock()
+		for len(b.queue) == 0 && !b.closed {
+			b.cond.Wait()
+		}
+		if len(b.queue) == 0 {
+			b.mu.Unlock()
+			return
+		}
+		next := b.queue[0]
+		b.queue[0] = queued{}
+		b.queue = b.queue[1:]
+		b.mu.Unlock()
+
+		for _, h := range next.handlers {
+			if !h.removed.Load() {
+				h.fn(next.event)
+			}
+		}
+	}
+}
+
+// Close stops accepting events and waits until the queued ones were delivered. It must
+// not be called from a handler. It is safe to call Close more than once.
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.closed = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	<-b.done
+}
@@ -0,0 +1,192 @@
+package events
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// recorder collects what handlers saw, from whatever goroutine they run on
+type recorder struct {
+	mu   sync.Mutex
+	seen []string
+}
+
+func (r *recorder) add(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen = append(r.seen, s)
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.seen...)
+}
+
+var _ = Describe("Bus", func() {
+	var (
+		bus *Bus
+		rec *recorder
+	)
+
+	BeforeEach(func() {
+		bus = NewBus()
+		rec = &recorder{}
+	})
+
+	AfterEach(func() {
+		bus.Close()
+	})
+
+	It("should deliver to sync handlers before Publish returns, in subscription order", func() {
+		Subscribe(bus, func(ev RunRecorded) { rec.add("first " + ev.Path) })
+		Subscribe(bus, func(ev RunRecorded) { rec.add("second " + ev.Path) })
+		Subscribe(bus, func(IndexSwapped) { rec.add("other type") })
+
+		Expect(Publish(bus, RunRecorded{Path: "/bin/true"})).To(BeTrue())
+		Expect(rec.get()).To(Equal([]string{"first /bin/true", "second /bin/true"}))
+	})
+
+	It("should deliver to async handlers in publish order", func() {
+		SubscribeAsync(bus, func(ev IndexSwapped) { rec.add("swap " + strconv.FormatUint(ev.Generation, 10)) })
+		SubscribeAsync(bus, func(ev RunRecorded) { rec.add("run " + ev.Path) })
+
+		Publish(bus, IndexSwapped{Generation: 1})
+		Publish(bus, RunRecorded{Path: "a"})
+		Publish(bus, IndexSwapped{Generation: 2})
+		Eventually(rec.get).Should(Equal([]string{"swap 1", "run a", "swap 2"}))
+	})
+
+	It("should stop delivering to removed handlers", func() {
+		release := make(chan struct{})
+		SubscribeAsync(bus, func(RunRecorded) { <-release })
+		unsubscribe := SubscribeAsync(bus, func(ev RunRecorded) { rec.add(ev.Path) })
+		unsubscribeSync := Subscribe(bus, func(ev RunRecorded) { rec.add("sync " + ev.Path) })
+
+		Publish(bus, RunRecorded{Path: "queued"})
+		unsubscribe()
+		unsubscribeSync()
+		unsubscribe()
+		Publish(bus, RunRecorded{Path: "later"})
+		close(release)
+		bus.Close()
+		Expect(rec.get()).To(Equal([]string{"sync queued"}))
+	})
+
+	It("should deliver a config change before the index swap of the reindex it triggers", func() {
+		// Like the daemon: a config change starts a reindex on its own goroutine, whose
+		// swap is published as soon as it's done
+		reindexed := make(chan struct{})
+		SubscribeAsync(bus, func(ConfigChanged) {
+			go func() {
+				Publish(bus, IndexSwapped{Generation: 2})
+				close(reindexed)
+			}()
+		})
+		Subscribe(bus, func(ConfigChanged) { rec.add("sync config") })
+		Subscribe(bus, func(IndexSwapped) { rec.add("sync swap") })
+		SubscribeAsync(bus, func(ConfigChanged) {
+			time.Sleep(10 * time.Millisecond) // gives the reindex time to finish first
+			rec.add("async config")
+		})
+		SubscribeAsync(bus, func(IndexSwapped) { rec.add("async swap") })
+
+		Publish(bus, ConfigChanged{Path: "indexd.rc"})
+		Eventually(reindexed).Should(BeClosed())
+		bus.Close()
+		Expect(rec.get()).To(Equal([]string{"sync config", "sync swap", "async config", "async swap"}))
+	})
+
+	It("should drain queued events on Close and drop later ones", func() {
+		SubscribeAsync(bus, func(ev IndexSwapped) {
+			time.Sleep(time.Millisecond)
+			rec.add(strconv.FormatUint(ev.Generation, 10))
+			// Queued until Close is called, dropped from then on
+			Publish(bus, RunRecorded{Path: "late"})
+		})
+		SubscribeAsync(bus, func(ev RunRecorded) { rec.add(ev.Path) })
+		for gen := uint64(1); gen <= 5; gen++ {
+			Expect(Publish(bus, IndexSwapped{Generation: gen})).To(BeTrue())
+		}
+
+		bus.Close()
+		seen := rec.get()
+		Expect(seen).To(HaveLen(5 + countOf(seen, "late")))
+		Expect(without(seen, "late")).To(Equal([]string{"1", "2", "3", "4", "5"}))
+
+		Expect(Publish(bus, IndexSwapped{Generation: 6})).To(BeFalse())
+		Subscribe(bus, func(IndexSwapped) { rec.add("after close") })
+		Expect(Publish(bus, IndexSwapped{Generation: 7})).To(BeFalse())
+		Expect(rec.get()).To(Equal(seen))
+		bus.Close()
+	})
+
+	It("should drop everything without a bus", func() {
+		var nilBus *Bus
+		unsubscribe := Subscribe(nilBus, func(RunRecorded) { rec.add("called") })
+		Expect(Publish(nilBus, RunRecorded{})).To(BeFalse())
+		unsubscribe()
+		nilBus.Close()
+		Expect(rec.get()).To(BeEmpty())
+	})
+
+	It("should import nothing of the project, so any package can use it", func() {
+		files, err := filepath.Glob("*.go")
+		Expect(err).NotTo(HaveOccurred())
+		module := moduleName()
+		for _, file := range files {
+			if strings.HasSuffix(file, "_test.go") {
+				continue
+			}
+			f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+			Expect(err).NotTo(HaveOccurred())
+			for _, imp := range f.Imports {
+				path, _ := strconv.Unquote(imp.Path.Value)
+				Expect(path).NotTo(HavePrefix(module), file)
+				Expect(strings.SplitN(path, "/", 2)[0]).NotTo(ContainSubstring("."), "%s imports %s, not the standard library", file, path)
+			}
+		}
+	})
+})
+
+// moduleName reads the module path from the go.mod of the repository
+func moduleName() string {
+	data, err := os.ReadFile(filepath.Join("..", "..", "go.mod"))
+	Expect(err).NotTo(HaveOccurred())
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, ok := strings.CutPrefix(line, "module "); ok {
+			return strings.TrimSpace(name)
+		}
+	}
+	Fail("no module line in go.mod")
+	return ""
+}
+
+func countOf(seen []string, s string) int {
+	n := 0
+	for _, v := range seen {
+		if v == s {
+			n++
+		}
+	}
+	return n
+}
+
+func without(seen []string, s string) []string {
+	var kept []string
+	for _, v := range seen {
+		if v != s {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
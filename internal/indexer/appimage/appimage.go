@@ -0,0 +1,114 @@
+package appimage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/indexer/fdlimit"
+)
+
+// Suffix is the file name suffix of AppImages
+const Suffix = ".AppImage"
+
+// AppImageInfo contains information about an AppImage file
+type AppImageInfo struct {
+	Name    string    // Application name derived from the file name
+	Path    string    // Full path to the AppImage
+	ModTime time.Time // Modification time of the file
+}
+
+// ScanPaths scans the given directories for executable AppImages
+func ScanPaths(paths []string, resultChan chan<- *AppImageInfo) error {
+	defer close(resultChan)
+
+	for _, path := range paths {
+		if err := scanPath(path, resultChan); err != nil {
+			// Continue scanning other paths even if one fails
+			continue
+		}
+	}
+	return nil
+}
+
+func scanPath(rootPath string, resultChan chan<- *AppImageInfo) error {
+	return fdlimit.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Skip directories we can't access
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		baseName := filepath.Base(path)
+		if !IsAppImage(baseName) || strings.HasPrefix(baseName, ".") {
+			return nil
+		}
+
+		// AppImages that aren't executable can't be launched
+		if info.Mode()&0111 == 0 {
+			return nil
+		}
+
+		resultChan <- &AppImageInfo{
+			Name:    NameFromFile(baseName),
+			Path:    path,
+			ModTime: info.ModTime(),
+		}
+
+		return nil
+	})
+}
+
+// IsAppImage reports whether the file name has the AppImage suffix, in any case
+func IsAppImage(name string) bool {
+	return len(name) > len(Suffix) && strings.EqualFold(name[len(name)-len(Suffix):], Suffix)
+}
+
+// architectures commonly found in AppImage file names
+var architectures = map[string]bool{
+	"x86_64":  true,
+	"amd64":   true,
+	"i386":    true,
+	"i686":    true,
+	"aarch64": true,
+	"arm64":   true,
+	"armhf":   true,
+}
+
+// NameFromFile derives the application name from an AppImage file name: the suffix is
+// removed along with trailing version and architecture parts, e.g.
+// "Obsidian-1.4.16-x86_64.AppImage" -> "Obsidian". Names that would end up empty are kept
+// as they are without the suffix.
+func NameFromFile(file string) string {
+	name := file
+	if IsAppImage(name) {
+		name = name[:len(name)-len(Suffix)]
+	}
+
+	parts := strings.Split(name, "-")
+	n := 0
+	for n < len(parts) {
+		part := parts[n]
+		if part == "" || architectures[strings.ToLower(part)] || isVersion(part) {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return name
+	}
+	return strings.Join(parts[:n], "-")
+}
+
+// isVersion reports whether a file name part looks like a version: "1.2.3", "v2", "2024.01"
+func isVersion(part string) bool {
+	part = strings.TrimPrefix(strings.TrimPrefix(part, "v"), "V")
+	return part != "" && part[0] >= '0' && part[0] <= '9'
+}
@@ -0,0 +1,13 @@
+package appimage
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAppImage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "AppImage Suite")
+}
@@ -0,0 +1,86 @@
+package appimage
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NameFromFile", func() {
+	DescribeTable("derives the application name",
+		func(file, name string) {
+			Expect(NameFromFile(file)).To(Equal(name))
+		},
+		Entry("plain", "Krita.AppImage", "Krita"),
+		Entry("version and arch", "Obsidian-1.4.16-x86_64.AppImage", "Obsidian"),
+		Entry("v-prefixed version", "Joplin-v2.13.AppImage", "Joplin"),
+		Entry("arch only", "Inkscape-aarch64.AppImage", "Inkscape"),
+		Entry("dashes in the name", "balena-etcher-1.18.11.AppImage", "balena-etcher"),
+		Entry("lowercase suffix", "tool.appimage", "tool"),
+		Entry("nothing but a version", "1.0-x86_64.AppImage", "1.0-x86_64"),
+	)
+})
+
+var _ = Describe("ScanPaths", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-appimage-test-*")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	write := func(name string, mode os.FileMode) string {
+		path := filepath.Join(tmpDir, name)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		// An ELF header is enough to look the part, the scanner doesn't read contents
+		Expect(os.WriteFile(path, []byte("\x7fELF\x02\x01\x01\x00AI\x02"), mode)).To(Succeed())
+		return path
+	}
+
+	scan := func(paths ...string) map[string]string {
+		found := make(chan *AppImageInfo, 10)
+		Expect(ScanPaths(paths, found)).To(Succeed())
+		result := make(map[string]string)
+		for info := range found {
+			result[info.Path] = info.Name
+		}
+		return result
+	}
+
+	It("should find executable AppImages only", func() {
+		krita := write("krita-5.2.2-x86_64.AppImage", 0755)
+		nested := write("tools/Obsidian-1.4.16.AppImage", 0755)
+		write("not-executable.AppImage", 0644)
+		write("script.sh", 0755)
+		write(".hidden.AppImage", 0755)
+
+		Expect(scan(tmpDir)).To(Equal(map[string]string{
+			krita:  "krita",
+			nested: "Obsidian",
+		}))
+	})
+
+	It("should report the modification time", func() {
+		app := write("App.AppImage", 0755)
+		mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		Expect(os.Chtimes(app, mtime, mtime)).To(Succeed())
+
+		found := make(chan *AppImageInfo, 10)
+		Expect(ScanPaths([]string{tmpDir}, found)).To(Succeed())
+		info := <-found
+		Expect(info.ModTime.Equal(mtime)).To(BeTrue(), info.ModTime.String())
+	})
+
+	It("should skip missing roots", func() {
+		app := write("App.AppImage", 0755)
+		Expect(scan(filepath.Join(tmpDir, "missing"), tmpDir)).To(Equal(map[string]string{app: "App"}))
+	})
+})
@@ -0,0 +1,93 @@
+package indexer
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// offendingRoots is the number of roots named when the index is capped
+const offendingRoots = 3
+
+// capIndex returns index cut down to max entries, keeping those of the earlier roots in
+// search order (see sortBySearchOrder), so what's left out doesn't depend on the order
+// scanners ran in. Entries cut under a root are taken off the count of its scanner in stats.
+// Returns index itself when nothing is cut, along with the number of entries cut, and of
+// those under no root per origin, like mirrored ones. max 0 or less keeps everything.
+func capIndex(index *Index, stats []SourceStats, max int) (*Index, int, map[string]int) {
+	if max <= 0 || index.Count() <= max {
+		return index, 0, nil
+	}
+
+	// The scanner of every root, by rank
+	var sourceOf []int
+	for i, source := range stats {
+		for range source.Roots {
+			sourceOf = append(sourceOf, i)
+		}
+	}
+
+	entries := index.GetAll()
+	ranks := sortBySearchOrder(entries, stats)
+	cut := entries[max:]
+	unrooted := make(map[string]int)
+	for _, entry := range cut {
+		if rank := ranks[entry]; rank < len(sourceOf) {
+			stats[sourceOf[rank]].Count--
+		} else {
+			unrooted[entry.Origin]++
+		}
+	}
+
+	kept := slices.SortedFunc(slices.Values(entries[:max]), func(a, b *Entry) int { return cmp.Compare(a.ID, b.ID) })
+	capped := NewIndex()
+	for _, entry := range kept {
+		capped.Add(entry)
+	}
+	return capped, len(cut), unrooted
+}
+
+// largestRoots returns the roots of stats under which the most entries were found, most
+// first and at most n of them
+func largestRoots(stats []SourceStats, n int) []RootStats {
+	var scans []RootStats
+	for _, source := range stats {
+		for _, scan := range source.Scans {
+			if scan.Entries > 0 {
+				scans = append(scans, scan)
+			}
+		}
+	}
+	slices.SortStableFunc(scans, func(a, b RootStats) int { return b.Entries - a.Entries })
+	return scans[:min(n, len(scans))]
+}
+
+// warnCapped logs that the index was capped at max entries with dropped left out, naming the
+// roots that likely shouldn't be indexed
+func warnCapped(max, dropped int, roots []RootStats) {
+	names := make([]string, 0, len(roots))
+	for _, root := range roots {
+		names = append(names, fmt.Sprintf("%s (%d)", root.Root, root.Entries))
+	}
+	log.Printf("[WARN] Index capped at %d entries, %d left out: exclude the roots holding the most, %s, or raise ADE_INDEXD_MAX_ENTRIES",
+		max, dropped, strings.Join(names, ", "))
+}
+
+// SetMaxEntries sets the number of entries beyond which runs leave out what they find from
+// the next run on, 0 for no limit. It defaults to ADE_INDEXD_MAX_ENTRIES.
+func (idx *Indexer) SetMaxEntries(max int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.maxEntries = max
+}
+
+// Truncated returns the number of entries the last completed run left out for exceeding
+// ADE_INDEXD_MAX_ENTRIES, 0 if none, along with the roots under which the most entries
+// were found
+func (idx *Indexer) Truncated() (int, []string) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.truncated, slices.Clone(idx.truncatedRoots)
+}
@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = ginkgo.Describe("entry cap", func() {
+	var (
+		small, big, other string
+		idx               *Indexer
+		logs              *gbytes.Buffer
+	)
+
+	fill := func(dir string, n int) {
+		gomega.Expect(os.MkdirAll(dir, 0755)).To(gomega.Succeed())
+		for i := range n {
+			gomega.Expect(os.WriteFile(filepath.Join(dir, fmt.Sprintf("tool%03d", i)), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		}
+	}
+
+	paths := func() []string {
+		var result []string
+		for _, entry := range idx.GetIndex().GetAll() {
+			result = append(result, entry.Path)
+		}
+		slices.Sort(result)
+		return result
+	}
+
+	ginkgo.BeforeEach(func() {
+		root := ginkgo.GinkgoT().TempDir()
+		small, big, other = filepath.Join(root, "small"), filepath.Join(root, "usr"), filepath.Join(root, "other")
+		fill(small, 5)
+		fill(filepath.Join(big, "bin"), 30)
+		fill(filepath.Join(big, "lib", "tools"), 20)
+		fill(other, 10)
+
+		idx = NewIndexer()
+		idx.sources = []source{{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }}}
+		idx.AddScanner(rootedScanner{Scanner: executableScanner{}, name: "more"}, other)
+		idx.SetMaxEntries(20)
+
+		logs = gbytes.NewBuffer()
+		log.SetOutput(logs)
+		ginkgo.DeferCleanup(func() { log.SetOutput(os.Stderr) })
+	})
+
+	ginkgo.It("should keep the entries of the earlier roots and name the largest ones", func() {
+		count, err := idx.Reindex(context.Background(), []string{small, big})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(count).To(gomega.Equal(20))
+
+		kept := paths()
+		// All of the first root, then the rest of the cap from the second in path order
+		gomega.Expect(kept).To(gomega.HaveLen(20))
+		gomega.Expect(kept).To(gomega.ContainElement(filepath.Join(small, "tool004")))
+		gomega.Expect(kept).To(gomega.ContainElement(filepath.Join(big, "bin", "tool014")))
+		gomega.Expect(kept).NotTo(gomega.ContainElement(filepath.Join(big, "bin", "tool015")))
+		gomega.Expect(kept).NotTo(gomega.ContainElement(filepath.Join(other, "tool000")))
+
+		truncated, roots := idx.Truncated()
+		gomega.Expect(truncated).To(gomega.Equal(45))
+		gomega.Expect(roots).To(gomega.Equal([]string{big, other, small}))
+		counts := make(map[string]int)
+		for _, source := range idx.Sources() {
+			counts[source.Name] = source.Count
+		}
+		gomega.Expect(counts).To(gomega.Equal(map[string]int{ExecutableScanner: 20, "more": 0}))
+		gomega.Expect(string(logs.Contents())).To(gomega.MatchRegexp(`\[WARN\] Index capped at 20 entries, 45 left out: exclude the roots holding the most, ` + regexp.QuoteMeta(big) + ` \(50\), `))
+
+		// The same entries every time, whichever scanner is done first
+		for range 5 {
+			_, err := idx.Reindex(context.Background(), []string{small, big})
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			gomega.Expect(paths()).To(gomega.Equal(kept))
+		}
+	})
+
+	ginkgo.It("should follow the order of the roots", func() {
+		_, err := idx.Reindex(context.Background(), []string{big, small})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		kept := paths()
+		gomega.Expect(kept).To(gomega.HaveLen(20))
+		for _, path := range kept {
+			gomega.Expect(path).To(gomega.HavePrefix(filepath.Join(big, "bin") + "/"))
+		}
+	})
+
+	ginkgo.It("should clear the truncation once everything fits", func() {
+		_, err := idx.Reindex(context.Background(), []string{small, big})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		idx.SetMaxEntries(0)
+		_, err = idx.Reindex(context.Background(), []string{small, big})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(paths()).To(gomega.HaveLen(65))
+		truncated, roots := idx.Truncated()
+		gomega.Expect(truncated).To(gomega.BeZero())
+		gomega.Expect(roots).To(gomega.BeEmpty())
+	})
+})
@@ -2,35 +2,71 @@ package desktop
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/indexer/fdlimit"
+	"github.com/0xADE/ade-ctld/internal/indexer/ignore"
 )
 
 // DesktopEntry represents a parsed .desktop file
 type DesktopEntry struct {
-	Name       string            // Default name
-	Names      map[string]string // Localized names (locale -> name)
-	Exec       string            // Exec command
-	Terminal   bool              // Whether to run in terminal
-	Categories []string          // Application categories
-	Path       string            // Path to .desktop file
+	Name         string            // Default name
+	Names        map[string]string // Localized names (locale -> name)
+	GenericName  string            // Generic name, e.g. "Web Browser"
+	GenericNames map[string]string // Localized generic names (locale -> name)
+	Exec         string            // Exec command
+	Terminal     bool              // Whether to run in terminal
+	Categories   []string          // Application categories
+	Keywords     []string          // Search keywords, default and localized ones
+	MimeTypes    []string          // MIME types handled, x-scheme-handler/<scheme> ones for URL schemes
+	Nice         *int              // X-ADE-Nice, niceness to launch with, nil when unset or not a number
+	Path         string            // Path to .desktop file
+	ID           string            // Desktop file id, derived from the path relative to the scanned root
+	ModTime      time.Time         // Modification time of the .desktop file
+	Autostart    bool              // Has X-GNOME-Autostart-* or X-KDE-autostart-* keys, meant for session startup
+	NoDisplay    bool              // NoDisplay=true, not to be shown in menus
+	Actions      []Action          // Desktop actions listed by Actions, in their order
+	Diagnostics  []string          // Values that weren't valid UTF-8 and what became of them
+}
+
+// Action is a desktop action, a [Desktop Action <key>] group like "New Private Window"
+type Action struct {
+	Key  string // Identifier of the action, as listed by the Actions key
+	Name string // Default name
+	Exec string // Exec command
 }
 
 // ScanDesktopFiles scans for .desktop files in standard locations
 func ScanDesktopFiles(resultChan chan<- *DesktopEntry) error {
-	defer close(resultChan)
+	return ScanPaths(DefaultPaths(), nil, resultChan)
+}
 
-	// Standard desktop file locations
-	paths := []string{
+// DefaultPaths returns the standard desktop file locations
+func DefaultPaths() []string {
+	return []string{
 		"/usr/share/applications",
 		"/usr/local/share/applications",
 		filepath.Join(os.Getenv("HOME"), ".local/share/applications"),
 	}
+}
+
+// ScanPaths scans for .desktop files in the given applications directories, leaving out what
+// filter skips
+func ScanPaths(paths []string, filter *ignore.Filter, resultChan chan<- *DesktopEntry) error {
+	defer close(resultChan)
 
 	for _, path := range paths {
-		if err := scanDesktopPath(path, resultChan); err != nil {
+		done := filter.Walking(path)
+		err := scanDesktopPath(path, filter, resultChan)
+		done()
+		if err != nil {
 			// Continue scanning other paths
 			continue
 		}
@@ -39,9 +75,12 @@ func ScanDesktopFiles(resultChan chan<- *DesktopEntry) error {
 	return nil
 }
 
-func scanDesktopPath(rootPath string, resultChan chan<- *DesktopEntry) error {
-	return filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+func scanDesktopPath(rootPath string, filter *ignore.Filter, resultChan chan<- *DesktopEntry) error {
+	return fdlimit.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				filter.Failed(rootPath)
+			}
 			if info != nil && info.IsDir() {
 				return filepath.SkipDir
 			}
@@ -49,24 +88,66 @@ func scanDesktopPath(rootPath string, resultChan chan<- *DesktopEntry) error {
 		}
 
 		if info.IsDir() {
+			if filter.Skip(rootPath, path, true) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
+		filter.Seen(rootPath)
+
 		if !strings.HasSuffix(path, ".desktop") {
 			return nil
 		}
 
-		entry, err := ParseDesktopFile(path)
+		if filter.Skip(rootPath, path, false) {
+			return nil
+		}
+
+		var entry *DesktopEntry
+		err = fdlimit.Do(func() (err error) {
+			entry, err = ParseDesktopFile(path)
+			return err
+		})
 		if err != nil {
 			// Skip invalid files
 			return nil
 		}
+		entry.ID = FileID(rootPath, path)
+		entry.ModTime = info.ModTime()
 
 		resultChan <- entry
 		return nil
 	})
 }
 
+// FileID returns the desktop file id of path found under the applications directory root:
+// the relative path with "/" replaced by "-", e.g. "kde4/konsole.desktop" gives
+// "kde4-konsole.desktop"
+func FileID(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.Base(path)
+	}
+	return strings.ReplaceAll(filepath.ToSlash(rel), "/", "-")
+}
+
+// ExecBinary returns the program an Exec value runs: its first argument, quotes removed and
+// environment references expanded. A leading "env VAR=value..." wrapper is skipped.
+func ExecBinary(exec string) string {
+	args := ExecArgs(exec, "")
+	if len(args) > 0 && filepath.Base(args[0]) == "env" {
+		args = args[1:]
+		for len(args) > 0 && strings.Contains(args[0], "=") {
+			args = args[1:]
+		}
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
 // ParseDesktopFile parses a single .desktop file
 func ParseDesktopFile(path string) (*DesktopEntry, error) {
 	file, err := os.Open(path)
@@ -76,13 +157,19 @@ func ParseDesktopFile(path string) (*DesktopEntry, error) {
 	defer file.Close()
 
 	entry := &DesktopEntry{
-		Path:  path,
-		Names: make(map[string]string),
+		Path:         path,
+		Names:        make(map[string]string),
+		GenericNames: make(map[string]string),
 	}
 
 	scanner := bufio.NewScanner(file)
 	var currentSection string
 	var inDesktopEntry bool
+	var listed []string
+	var encoding string
+	var keywords [][2]string // key and value of the Keywords lines, decoded once all is read
+	actions := make(map[string]*Action)
+	var action *Action // of the current [Desktop Action <key>] group
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -100,10 +187,15 @@ func ParseDesktopFile(path string) (*DesktopEntry, error) {
 			} else {
 				inDesktopEntry = false
 			}
+			action = nil
+			if key, ok := strings.CutPrefix(currentSection, "Desktop Action "); ok && actions[key] == nil {
+				action = &Action{Key: key}
+				actions[key] = action
+			}
 			continue
 		}
 
-		if !inDesktopEntry {
+		if !inDesktopEntry && action == nil {
 			continue
 		}
 
@@ -116,28 +208,53 @@ func ParseDesktopFile(path string) (*DesktopEntry, error) {
 		key := strings.TrimSpace(parts[0])
 		value := strings.TrimSpace(parts[1])
 
+		if action != nil {
+			switch key {
+			case "Name":
+				action.Name = value
+			case "Exec":
+				action.Exec = value
+			}
+			continue
+		}
+
 		switch key {
 		case "Name":
 			entry.Name = value
+		case "GenericName":
+			entry.GenericName = value
 		case "Exec":
 			entry.Exec = value
 		case "Terminal":
 			entry.Terminal = strings.ToLower(value) == "true"
+		case "NoDisplay":
+			entry.NoDisplay = strings.ToLower(value) == "true"
 		case "Categories":
-			// Categories are semicolon-separated
-			cats := strings.Split(value, ";")
-			entry.Categories = make([]string, 0, len(cats))
-			for _, cat := range cats {
-				cat = strings.TrimSpace(cat)
-				if cat != "" {
-					entry.Categories = append(entry.Categories, cat)
-				}
+			entry.Categories = splitList(value)
+		case "Keywords":
+			keywords = append(keywords, [2]string{key, value})
+		case "Encoding":
+			encoding = value
+		case "MimeType":
+			entry.MimeTypes = splitList(value)
+		case "Actions":
+			listed = splitList(value)
+		case "X-ADE-Nice":
+			if nice, err := strconv.Atoi(value); err == nil {
+				entry.Nice = &nice
 			}
 		default:
 			// Check for localized Name[locale]
 			if strings.HasPrefix(key, "Name[") && strings.HasSuffix(key, "]") {
 				locale := key[5 : len(key)-1]
 				entry.Names[locale] = value
+			} else if strings.HasPrefix(key, "GenericName[") && strings.HasSuffix(key, "]") {
+				locale := key[12 : len(key)-1]
+				entry.GenericNames[locale] = value
+			} else if strings.HasPrefix(key, "Keywords[") && strings.HasSuffix(key, "]") {
+				keywords = append(keywords, [2]string{key, value})
+			} else if strings.HasPrefix(key, "X-GNOME-Autostart") || strings.HasPrefix(key, "X-KDE-autostart") {
+				entry.Autostart = true
 			}
 		}
 	}
@@ -146,12 +263,45 @@ func ParseDesktopFile(path string) (*DesktopEntry, error) {
 		return nil, err
 	}
 
+	// Values of files predating UTF-8, like those with Encoding=Legacy-Mixed, are turned to
+	// UTF-8 once the Encoding key is known, wherever it was
+	dec := &decoder{charset: fileCharset(encoding)}
+	entry.Name, _ = dec.decode("Name", "", entry.Name, false)
+	entry.GenericName, _ = dec.decode("GenericName", "", entry.GenericName, false)
+	entry.Names = dec.localized("Name", entry.Names)
+	entry.GenericNames = dec.localized("GenericName", entry.GenericNames)
+	for _, kw := range keywords {
+		key, value := kw[0], kw[1]
+		locale := strings.TrimSuffix(strings.TrimPrefix(key, "Keywords["), "]")
+		_, charset := splitLocale(locale)
+		if value, ok := dec.decode(key, charset, value, key != "Keywords"); ok {
+			entry.Keywords = append(entry.Keywords, splitList(value)...)
+		}
+	}
+
+	// Actions without a group or without a command can't be launched
+	for _, key := range listed {
+		if action := actions[key]; action != nil && action.Exec != "" {
+			action.Name, _ = dec.decode("Name of action "+key, "", action.Name, false)
+			if action.Name == "" {
+				action.Name = key
+			}
+			entry.Actions = append(entry.Actions, *action)
+			actions[key] = nil
+		}
+	}
+	entry.Diagnostics = dec.diagnostics
+
 	// Validate required fields
-	if entry.Name == "" && entry.Exec == "" {
+	if entry.Name == "" && entry.GenericName == "" && entry.Exec == "" {
 		return nil, fmt.Errorf("missing required fields")
 	}
 
-	// Set default name if not set
+	// Set default name if not set: the generic name still means something to a human,
+	// the filename is the last resort
+	if entry.Name == "" && entry.GenericName != "" {
+		entry.Name = entry.GenericName
+	}
 	if entry.Name == "" {
 		// Use filename without extension
 		baseName := filepath.Base(path)
@@ -161,6 +311,19 @@ func ParseDesktopFile(path string) (*DesktopEntry, error) {
 	return entry, nil
 }
 
+// splitList splits a semicolon-separated list value, dropping empty items
+func splitList(value string) []string {
+	items := strings.Split(value, ";")
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 // GetLocalizedName returns the localized name for the given locale, or default name
 func (d *DesktopEntry) GetLocalizedName(locale string) string {
 	if locale == "" {
@@ -192,8 +355,8 @@ func (d *DesktopEntry) GetLocalizedName(locale string) string {
 	return d.Name
 }
 
-// ExpandExecCommand expands %-codes in Exec command
-func (d *DesktopEntry) ExpandExecCommand(filePath string) string {
+// ExpandExecCommand expands %-codes in Exec command, %c to the name translated for locale
+func (d *DesktopEntry) ExpandExecCommand(filePath, locale string) string {
 	exec := d.Exec
 
 	// Replace common field codes
@@ -202,7 +365,7 @@ func (d *DesktopEntry) ExpandExecCommand(filePath string) string {
 	exec = strings.ReplaceAll(exec, "%u", filePath)
 	exec = strings.ReplaceAll(exec, "%U", filePath)
 	exec = strings.ReplaceAll(exec, "%i", "")
-	exec = strings.ReplaceAll(exec, "%c", d.Name)
+	exec = strings.ReplaceAll(exec, "%c", d.GetLocalizedName(locale))
 	exec = strings.ReplaceAll(exec, "%k", d.Path)
 
 	// Remove % codes that we don't handle
@@ -211,6 +374,126 @@ func (d *DesktopEntry) ExpandExecCommand(filePath string) string {
 	return exec
 }
 
+// ExecFields are the values the field codes of an Exec value expand to
+type ExecFields struct {
+	File     string // %f, %F, %u and %U
+	Name     string // %c, the name translated for the current locale
+	Location string // %k, the path of the desktop file
+}
+
+// ExecArgs splits an Exec value into argv with file passed for the %f/%F/%u/%U field
+// codes. Quoted arguments are kept whole, so paths with spaces survive, and $VAR/${VAR}
+// references are expanded from the environment (undefined ones expand to nothing, "\$"
+// inside quotes is a literal "$"). When Exec has no file field code the file is appended
+// as the last argument; without a file, arguments consisting of a file code are dropped.
+func ExecArgs(exec, file string) []string {
+	return ExpandExecArgs(exec, ExecFields{File: file})
+}
+
+// ExpandExecArgs splits an Exec value into argv like ExecArgs, also expanding %c and %k
+// from fields. Like the file, a name with spaces stays one argument.
+func ExpandExecArgs(exec string, fields ExecFields) []string {
+	file := fields.File
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quoted  bool
+		hasFile bool
+	)
+	flush := func() {
+		if inArg {
+			args = append(args, arg.String())
+		}
+		arg.Reset()
+		inArg = false
+	}
+
+	for i := 0; i < len(exec); i++ {
+		c := exec[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(exec):
+			i++
+			arg.WriteByte(exec[i])
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (c == ' ' || c == '\t'):
+			flush()
+		case c == '$':
+			name, n := envReference(exec[i+1:])
+			if n == 0 {
+				arg.WriteByte(c)
+				inArg = true
+				break
+			}
+			i += n
+			// Like in the shell, an unquoted empty expansion doesn't make an argument
+			if value := os.Getenv(name); value != "" || quoted {
+				arg.WriteString(value)
+				inArg = true
+			}
+		case c == '%' && i+1 < len(exec):
+			i++
+			switch exec[i] {
+			case 'f', 'F', 'u', 'U':
+				hasFile = true
+				if file != "" {
+					arg.WriteString(file)
+					inArg = true
+				}
+			case 'c', 'k':
+				value := fields.Name
+				if exec[i] == 'k' {
+					value = fields.Location
+				}
+				if value != "" {
+					arg.WriteString(value)
+					inArg = true
+				}
+			case '%':
+				arg.WriteByte('%')
+				inArg = true
+			}
+			// Other field codes expand to nothing
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	flush()
+
+	if !hasFile && file != "" {
+		args = append(args, file)
+	}
+	return args
+}
+
+// envReference parses the variable name following a "$": NAME or {NAME}. It returns the
+// name and the number of bytes it spans, 0 if s doesn't start with a reference.
+func envReference(s string) (string, int) {
+	if strings.HasPrefix(s, "{") {
+		if end := strings.IndexByte(s, '}'); end > 1 && isEnvName(s[1:end]) {
+			return s[1:end], end + 1
+		}
+		return "", 0
+	}
+	n := 0
+	for n < len(s) && (s[n] == '_' || isAlpha(s[n]) || (n > 0 && s[n] >= '0' && s[n] <= '9')) {
+		n++
+	}
+	return s[:n], n
+}
+
+func isEnvName(s string) bool {
+	_, n := envReference(s)
+	return n == len(s)
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
 func removeFieldCodes(s string) string {
 	var result strings.Builder
 	i := 0
@@ -232,11 +515,32 @@ func removeFieldCodes(s string) string {
 	return result.String()
 }
 
+// IsAutostart reports whether entry starts with the session rather than being launched:
+// it's in one of the autostart directories dirs (like ~/.config/autostart) or has keys only
+// autostart files have
+func IsAutostart(entry *DesktopEntry, dirs []string) bool {
+	if entry.Autostart {
+		return true
+	}
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(dir, entry.Path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+	return false
+}
+
 // IsNoDisplay checks if the entry should be hidden (requires parsing NoDisplay key)
 func IsNoDisplay(path string) bool {
+	hidden, _ := NoDisplay(path)
+	return hidden
+}
+
+// NoDisplay is IsNoDisplay returning the error of reading the file
+func NoDisplay(path string) (bool, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		return false
+		return false, err
 	}
 	defer file.Close()
 
@@ -258,11 +562,11 @@ func IsNoDisplay(path string) bool {
 
 		if after, ok := strings.CutPrefix(line, "NoDisplay="); ok {
 			value := strings.TrimSpace(after)
-			return strings.ToLower(value) == "true"
+			return strings.ToLower(value) == "true", nil
 		}
 	}
 
-	return false
+	return false, scanner.Err()
 }
 
 // CleanExecCommand removes field codes and extra spaces from exec command
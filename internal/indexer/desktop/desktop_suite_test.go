@@ -0,0 +1,13 @@
+package desktop
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestDesktop(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Desktop Suite")
+}
@@ -0,0 +1,255 @@
+package desktop
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseDesktopFile", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-desktop-test-*")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	parse := func(name, content string) *DesktopEntry {
+		path := filepath.Join(tmpDir, name)
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		entry, err := ParseDesktopFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return entry
+	}
+
+	It("should prefer Name over GenericName", func() {
+		entry := parse("firefox.desktop", "[Desktop Entry]\nName=Firefox\nGenericName=Web Browser\nExec=firefox %u\n")
+		Expect(entry.Name).To(Equal("Firefox"))
+		Expect(entry.GenericName).To(Equal("Web Browser"))
+	})
+
+	It("should collect default and localized keywords", func() {
+		entry := parse("gimp.desktop", "[Desktop Entry]\nName=GIMP\nExec=gimp %U\nKeywords=photo;paint;\nKeywords[de]=Foto;Malen;\nCategories=Graphics;2DGraphics;\n")
+		Expect(entry.Keywords).To(Equal([]string{"photo", "paint", "Foto", "Malen"}))
+		Expect(entry.Categories).To(Equal([]string{"Graphics", "2DGraphics"}))
+	})
+
+	It("should fall back to GenericName when Name is missing", func() {
+		entry := parse("org.example.viewer.desktop", "[Desktop Entry]\nGenericName=Image Viewer\nGenericName[de]=Bildbetrachter\nExec=viewer %f\n")
+		Expect(entry.Name).To(Equal("Image Viewer"))
+		Expect(entry.GenericNames).To(HaveKeyWithValue("de", "Bildbetrachter"))
+	})
+
+	It("should fall back to the filename when both names are missing", func() {
+		entry := parse("org.example.viewer.desktop", "[Desktop Entry]\nExec=viewer %f\n")
+		Expect(entry.Name).To(Equal("org.example.viewer"))
+	})
+
+	It("should reject files without names and Exec", func() {
+		path := filepath.Join(tmpDir, "empty.desktop")
+		Expect(os.WriteFile(path, []byte("[Desktop Entry]\nType=Application\n"), 0644)).To(Succeed())
+		_, err := ParseDesktopFile(path)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read the niceness from X-ADE-Nice", func() {
+		entry := parse("build.desktop", "[Desktop Entry]\nName=Build\nExec=make\nX-ADE-Nice=10\n")
+		Expect(entry.Nice).To(HaveValue(Equal(10)))
+
+		entry = parse("other.desktop", "[Desktop Entry]\nName=Other\nExec=other\nX-ADE-Nice=low\n")
+		Expect(entry.Nice).To(BeNil())
+	})
+
+	It("should read NoDisplay of the desktop entry only", func() {
+		entry := parse("helper.desktop", "[Desktop Entry]\nName=Helper\nExec=helper\nNoDisplay=True\n")
+		Expect(entry.NoDisplay).To(BeTrue())
+
+		entry = parse("shown.desktop", "[Desktop Entry]\nName=Shown\nExec=shown\nActions=a;\n\n[Desktop Action a]\nExec=shown -a\nNoDisplay=true\n")
+		Expect(entry.NoDisplay).To(BeFalse())
+	})
+
+	It("should read Latin-1 values of legacy files as UTF-8", func() {
+		entry := parse("legacy.desktop", "[Desktop Entry]\nEncoding=Legacy-Mixed\nName=Cafe\nName[fr]=Caf\xe9\nName[de.ISO-8859-1]=Stra\xdfe\nGenericName[fr]=\xc9diteur\nKeywords[fr]=th\xe9;\nExec=cafe\n")
+		Expect(entry.Name).To(Equal("Cafe"))
+		Expect(entry.Names).To(Equal(map[string]string{"fr": "Café", "de": "Straße"}))
+		Expect(entry.GenericNames).To(HaveKeyWithValue("fr", "Éditeur"))
+		Expect(entry.Keywords).To(Equal([]string{"thé"}))
+		Expect(entry.Diagnostics).To(Equal([]string{
+			"Name[de.ISO-8859-1] isn't valid UTF-8, read as ISO-8859-1",
+			"Name[fr] isn't valid UTF-8, read as ISO-8859-1",
+			"GenericName[fr] isn't valid UTF-8, read as ISO-8859-1",
+			"Keywords[fr] isn't valid UTF-8, read as ISO-8859-1",
+		}))
+	})
+
+	It("should drop localized values in charsets it can't read, keeping the default name", func() {
+		entry := parse("wine.desktop", "[Desktop Entry]\nName[ru]=\xcf\xd2\xcf\xc7\nName=Gr\xfcn\nExec=wine prog.exe\nEncoding=KOI8-R\n")
+		Expect(entry.Name).To(Equal("Grün"))
+		Expect(entry.Names).To(BeEmpty())
+		Expect(entry.Diagnostics).To(Equal([]string{
+			"Name isn't valid UTF-8, read as ISO-8859-1",
+			"Name[ru] isn't valid UTF-8 and charset KOI8-R isn't supported, dropped",
+		}))
+	})
+
+	It("should leave valid UTF-8 files alone", func() {
+		entry := parse("utf8.desktop", "[Desktop Entry]\nEncoding=UTF-8\nName=Café\nName[fr]=Café\nExec=cafe\n")
+		Expect(entry.Names).To(HaveKeyWithValue("fr", "Café"))
+		Expect(entry.Diagnostics).To(BeEmpty())
+	})
+
+	It("should read the MIME types and URL schemes handled", func() {
+		entry := parse("mail.desktop", "[Desktop Entry]\nName=Mail\nExec=mail %u\nMimeType=message/rfc822;x-scheme-handler/mailto;\n")
+		Expect(entry.MimeTypes).To(Equal([]string{"message/rfc822", "x-scheme-handler/mailto"}))
+		Expect(ExecFieldCode(entry.Exec)).To(Equal(byte('u')))
+		Expect(ExecFieldCode("mail --compose")).To(BeZero())
+		Expect(ExecFieldCode("printf 100%% %F")).To(Equal(byte('F')))
+	})
+
+	It("should take schemes and URLs for the MIME type of their handlers", func() {
+		Expect(SchemeMime("mailto")).To(Equal("x-scheme-handler/mailto"))
+		Expect(SchemeMime("HTTPS://example.org/a/b")).To(Equal("x-scheme-handler/https"))
+		Expect(SchemeMime("Text/HTML")).To(Equal("text/html"))
+	})
+
+	It("should read the defaults of the mimeapps.list files in order", func() {
+		user, system := filepath.Join(tmpDir, "user.list"), filepath.Join(tmpDir, "system.list")
+		Expect(os.WriteFile(user, []byte("[Added Associations]\ntext/html=editor.desktop;\n\n[Default Applications]\ntext/html=firefox.desktop;chromium.desktop\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(system, []byte("[Default Applications]\nText/HTML=epiphany.desktop;\nimage/png=viewer.desktop\n"), 0644)).To(Succeed())
+		Expect(DefaultApps([]string{user, filepath.Join(tmpDir, "missing.list"), system}, "text/html")).To(Equal([]string{"firefox.desktop", "chromium.desktop", "epiphany.desktop"}))
+		Expect(DefaultApps([]string{user}, "image/png")).To(BeEmpty())
+	})
+
+	It("should look for mimeapps.list where XDG says", func() {
+		GinkgoT().Setenv("HOME", "/home/u")
+		GinkgoT().Setenv("XDG_CONFIG_HOME", "")
+		GinkgoT().Setenv("XDG_CONFIG_DIRS", "/etc/xdg/a:/etc/xdg/b")
+		GinkgoT().Setenv("XDG_DATA_HOME", "")
+		GinkgoT().Setenv("XDG_DATA_DIRS", "")
+		Expect(MimeAppsPaths()).To(Equal([]string{
+			"/home/u/.config/mimeapps.list",
+			"/etc/xdg/a/mimeapps.list",
+			"/etc/xdg/b/mimeapps.list",
+			"/home/u/.local/share/applications/mimeapps.list",
+			"/usr/local/share/applications/mimeapps.list",
+			"/usr/share/applications/mimeapps.list",
+		}))
+	})
+
+	It("should tell autostart files by their directory or keys", func() {
+		entry := parse("tray.desktop", "[Desktop Entry]\nName=Tray\nExec=tray\nX-GNOME-Autostart-Phase=Applications\n")
+		Expect(entry.Autostart).To(BeTrue())
+		Expect(IsAutostart(entry, nil)).To(BeTrue())
+
+		entry = parse("editor.desktop", "[Desktop Entry]\nName=Editor\nExec=editor\n")
+		Expect(entry.Autostart).To(BeFalse())
+		Expect(IsAutostart(entry, []string{"/etc/xdg/autostart"})).To(BeFalse())
+		Expect(IsAutostart(entry, []string{tmpDir})).To(BeTrue())
+		Expect(IsAutostart(entry, []string{tmpDir + "-other"})).To(BeFalse())
+	})
+
+	It("should read the listed actions that have a command", func() {
+		entry := parse("firefox.desktop", "[Desktop Entry]\nName=Firefox\nExec=firefox %u\nActions=new-window;new-private-window;gone;bare;\n\n"+
+			"[Desktop Action new-private-window]\nName=New Private Window\nExec=firefox --private-window %u\n\n"+
+			"[Desktop Action new-window]\nExec=firefox --new-window %u\n\n"+
+			"[Desktop Action bare]\nName=Bare\n\n"+
+			"[Desktop Action unlisted]\nName=Unlisted\nExec=firefox --unlisted\n")
+		Expect(entry.Exec).To(Equal("firefox %u"))
+		Expect(entry.Name).To(Equal("Firefox"))
+		Expect(entry.Actions).To(Equal([]Action{
+			{Key: "new-window", Name: "new-window", Exec: "firefox --new-window %u"},
+			{Key: "new-private-window", Name: "New Private Window", Exec: "firefox --private-window %u"},
+		}))
+	})
+
+	It("should not take GenericName from other sections", func() {
+		entry := parse("app.desktop", "[Desktop Entry]\nExec=app\n\n[Desktop Action new]\nGenericName=New Window\n")
+		Expect(entry.Name).To(Equal("app"))
+	})
+})
+
+var _ = Describe("ExecArgs", func() {
+	It("should substitute the file for its field code", func() {
+		Expect(ExecArgs("gedit %U", "/tmp/my notes.txt")).To(Equal([]string{"gedit", "/tmp/my notes.txt"}))
+		Expect(ExecArgs("viewer --open=%f -n", "/tmp/a.png")).To(Equal([]string{"viewer", "--open=/tmp/a.png", "-n"}))
+	})
+
+	It("should keep quoted arguments whole and drop other field codes", func() {
+		Expect(ExecArgs(`"/opt/My App/app" --title "a \"b\"" %i %c %f 100%%`, "/tmp/a")).
+			To(Equal([]string{"/opt/My App/app", "--title", `a "b"`, "/tmp/a", "100%"}))
+	})
+
+	It("should append the file when Exec has no field code for it", func() {
+		Expect(ExecArgs("/usr/bin/less", "/tmp/a.log")).To(Equal([]string{"/usr/bin/less", "/tmp/a.log"}))
+	})
+})
+
+var _ = Describe("Exec environment references", func() {
+	var oldHome string
+
+	BeforeEach(func() {
+		oldHome = os.Getenv("HOME")
+		os.Setenv("HOME", "/home/tester")
+		os.Setenv("ADE_TEST_PROFILE", "work")
+		os.Unsetenv("ADE_TEST_UNDEFINED")
+	})
+
+	AfterEach(func() {
+		os.Setenv("HOME", oldHome)
+		os.Unsetenv("ADE_TEST_PROFILE")
+	})
+
+	It("should expand $HOME", func() {
+		Expect(ExecArgs("$HOME/bin/app --config=$HOME/.apprc %f", "/tmp/a")).
+			To(Equal([]string{"/home/tester/bin/app", "--config=/home/tester/.apprc", "/tmp/a"}))
+	})
+
+	It("should expand the ${VAR} form next to other text", func() {
+		Expect(ExecArgs(`app --profile=${ADE_TEST_PROFILE}-1 "${HOME}/my files"`, "")).
+			To(Equal([]string{"app", "--profile=work-1", "/home/tester/my files"}))
+	})
+
+	It("should expand undefined variables to nothing", func() {
+		Expect(ExecArgs("app $ADE_TEST_UNDEFINED --x=${ADE_TEST_UNDEFINED}y", "")).To(Equal([]string{"app", "--x=y"}))
+		Expect(ExecArgs(`app "$ADE_TEST_UNDEFINED"`, "")).To(Equal([]string{"app", ""}))
+	})
+
+	It("should keep escaped and lone dollar signs", func() {
+		Expect(ExecArgs(`app "\$HOME" 5$ ${}`, "")).To(Equal([]string{"app", "$HOME", "5$", "${}"}))
+	})
+
+	It("should skip an env wrapper when looking up the binary", func() {
+		Expect(ExecBinary("env GDK_BACKEND=x11 LANG=C $HOME/bin/app %U")).To(Equal("/home/tester/bin/app"))
+		Expect(ExecBinary("/usr/bin/env FOO=1 flatpak run org.example.App")).To(Equal("flatpak"))
+		Expect(ExecArgs("env GDK_BACKEND=x11 app %U", "/tmp/a")).To(Equal([]string{"env", "GDK_BACKEND=x11", "app", "/tmp/a"}))
+	})
+})
+
+var _ = Describe("Field codes of the entry", func() {
+	entry := &DesktopEntry{
+		Name:  "Files",
+		Names: map[string]string{"de": "Dateien", "pt_BR": "Arquivos"},
+		Exec:  "app --title=%c --class %c %k",
+		Path:  "/usr/share/applications/files.desktop",
+	}
+
+	It("should expand %c to the localized name", func() {
+		fields := ExecFields{Name: entry.GetLocalizedName("de_DE"), Location: entry.Path}
+		Expect(ExpandExecArgs(entry.Exec, fields)).To(Equal([]string{
+			"app", "--title=Dateien", "--class", "Dateien", "/usr/share/applications/files.desktop",
+		}))
+		Expect(entry.ExpandExecCommand("", "pt_BR")).To(Equal("app --title=Arquivos --class Arquivos /usr/share/applications/files.desktop"))
+		Expect(entry.ExpandExecCommand("", "")).To(HavePrefix("app --title=Files "))
+	})
+
+	It("should keep a name with spaces in one argument", func() {
+		Expect(ExpandExecArgs("app --class %c", ExecFields{Name: "Web Browser"})).To(Equal([]string{"app", "--class", "Web Browser"}))
+	})
+})
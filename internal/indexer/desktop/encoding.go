@@ -0,0 +1,115 @@
+package desktop
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// latin1Charsets are the charsets read as ISO-8859-1, by the names normalizeCharset gives
+// them. ISO-8859-15 and Windows-1252 only differ from it in a few characters. Values that
+// aren't valid UTF-8 and come without a charset are presumed to be in it too.
+var latin1Charsets = map[string]bool{
+	"":            true,
+	"ascii":       true,
+	"usascii":     true,
+	"iso88591":    true,
+	"latin1":      true,
+	"iso885915":   true,
+	"latin9":      true,
+	"cp1252":      true,
+	"windows1252": true,
+}
+
+// normalizeCharset lowercases a charset name and drops its separators, e.g.
+// "ISO-8859-1" -> "iso88591"
+func normalizeCharset(charset string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(charset))
+}
+
+// splitLocale splits the charset off the locale of a localized key, which matching ignores,
+// e.g. "fr_FR.ISO-8859-1@euro" -> "fr_FR@euro", "ISO-8859-1"
+func splitLocale(locale string) (string, string) {
+	base, rest, ok := strings.Cut(locale, ".")
+	if !ok {
+		return locale, ""
+	}
+	charset, modifier, ok := strings.Cut(rest, "@")
+	if ok {
+		base += "@" + modifier
+	}
+	return base, charset
+}
+
+// fileCharset returns the charset an Encoding value declares for the values of a file,
+// empty for UTF-8 and for Legacy-Mixed, whose localized values are in the charset of their
+// locale
+func fileCharset(encoding string) string {
+	if strings.EqualFold(encoding, "UTF-8") || encoding == "Legacy-Mixed" {
+		return ""
+	}
+	return encoding
+}
+
+// decoder turns the values of a desktop file that aren't valid UTF-8 into UTF-8, noting what
+// it did for every one of them
+type decoder struct {
+	charset     string // declared by the Encoding key, see fileCharset
+	diagnostics []string
+}
+
+// decode returns value in UTF-8, read from charset when it isn't valid UTF-8. Only
+// Latin-1 is read: a localized value in another charset is dropped, ok is false. A default
+// value is read as Latin-1 whatever its charset, a name is better than none.
+func (d *decoder) decode(key, charset, value string, localized bool) (string, bool) {
+	if utf8.ValidString(value) {
+		return value, true
+	}
+	if charset == "" {
+		charset = d.charset
+	}
+	if localized && !latin1Charsets[normalizeCharset(charset)] {
+		d.note("%s isn't valid UTF-8 and charset %s isn't supported, dropped", key, charset)
+		return "", false
+	}
+	d.note("%s isn't valid UTF-8, read as ISO-8859-1", key)
+	return latin1(value), true
+}
+
+// localized decodes the values of the localized keys of name, keyed by locale, and drops the
+// charsets of their locales
+func (d *decoder) localized(name string, values map[string]string) map[string]string {
+	decoded := make(map[string]string, len(values))
+	for _, locale := range slices.Sorted(maps.Keys(values)) {
+		value := values[locale]
+		base, charset := splitLocale(locale)
+		value, ok := d.decode(name+"["+locale+"]", charset, value, true)
+		// The value without a charset wins over those with one
+		if _, taken := decoded[base]; !ok || taken && charset != "" {
+			continue
+		}
+		decoded[base] = value
+	}
+	return decoded
+}
+
+func (d *decoder) note(format string, args ...any) {
+	d.diagnostics = append(d.diagnostics, fmt.Sprintf(format, args...))
+}
+
+// latin1 converts ISO-8859-1 text to UTF-8, every byte being the code point of a character
+func latin1(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s) * 2)
+	for i := 0; i < len(s); i++ {
+		sb.WriteRune(rune(s[i]))
+	}
+	return sb.String()
+}
@@ -0,0 +1,103 @@
+package desktop
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SchemeMime returns the MIME type desktop files register a URL scheme handler under:
+// "mailto", "mailto:" and "mailto:someone@example.com" all give "x-scheme-handler/mailto".
+// A value holding a "/" is taken as a MIME type already. Both are lower cased, MIME types
+// and schemes don't depend on case.
+func SchemeMime(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if strings.Contains(value, "/") && !strings.Contains(value, ":") {
+		return value
+	}
+	scheme, _, _ := strings.Cut(value, ":")
+	return "x-scheme-handler/" + scheme
+}
+
+// MimeAppsPaths returns the mimeapps.list files, those taking precedence first, in the
+// order of the XDG MIME applications spec: in the user's and the system's configuration
+// directories, then in the applications directories of the user's and the system's data
+// directories. Desktop specific files are left out.
+func MimeAppsPaths() []string {
+	home := os.Getenv("HOME")
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(home, ".config")
+	}
+	configDirs := os.Getenv("XDG_CONFIG_DIRS")
+	if configDirs == "" {
+		configDirs = "/etc/xdg"
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local/share")
+	}
+	dataDirs := os.Getenv("XDG_DATA_DIRS")
+	if dataDirs == "" {
+		dataDirs = "/usr/local/share:/usr/share"
+	}
+
+	paths := []string{filepath.Join(configHome, "mimeapps.list")}
+	for _, dir := range filepath.SplitList(configDirs) {
+		paths = append(paths, filepath.Join(dir, "mimeapps.list"))
+	}
+	paths = append(paths, filepath.Join(dataHome, "applications", "mimeapps.list"))
+	for _, dir := range filepath.SplitList(dataDirs) {
+		paths = append(paths, filepath.Join(dir, "applications", "mimeapps.list"))
+	}
+	return paths
+}
+
+// DefaultApps returns the desktop file IDs the [Default Applications] groups of files give
+// for mime, in the order of files and then of the lists: the caller takes the first one it
+// has an entry for. Files that can't be read are skipped.
+func DefaultApps(files []string, mime string) []string {
+	var ids []string
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		var inDefaults bool
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+				inDefaults = line == "[Default Applications]"
+				continue
+			}
+			if !inDefaults {
+				continue
+			}
+			key, value, ok := strings.Cut(line, "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), mime) {
+				ids = append(ids, splitList(strings.TrimSpace(value))...)
+			}
+		}
+		file.Close()
+	}
+	return ids
+}
+
+// ExecFieldCode returns the field code exec takes files or URLs with: 'f' for a file, 'F'
+// for several, 'u' for a URL and 'U' for several, 0 when it takes none, like a handler
+// that can't be passed what it should open
+func ExecFieldCode(exec string) byte {
+	for i := 0; i+1 < len(exec); i++ {
+		if exec[i] != '%' {
+			continue
+		}
+		i++
+		switch exec[i] {
+		case 'f', 'F', 'u', 'U':
+			return exec[i]
+		}
+	}
+	return 0
+}
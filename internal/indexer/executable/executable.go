@@ -1,17 +1,26 @@
 package executable
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/indexer/fdlimit"
+	"github.com/0xADE/ade-ctld/internal/indexer/ignore"
 )
 
-// ScanPaths scans executable files in the given paths
-func ScanPaths(paths []string, resultChan chan<- *ExecutableInfo) error {
+// ScanPaths scans executable files in the given paths, leaving out what filter skips
+func ScanPaths(paths []string, filter *ignore.Filter, resultChan chan<- *ExecutableInfo) error {
 	defer close(resultChan)
 
 	for _, path := range paths {
-		if err := scanPath(path, resultChan); err != nil {
+		done := filter.Walking(path)
+		err := scanPath(path, filter, resultChan)
+		done()
+		if err != nil {
 			// Continue scanning other paths even if one fails
 			continue
 		}
@@ -21,13 +30,18 @@ func ScanPaths(paths []string, resultChan chan<- *ExecutableInfo) error {
 
 // ExecutableInfo contains information about an executable file
 type ExecutableInfo struct {
-	Name string // Executable name
-	Path string // Full path to executable
+	Name    string    // Executable name
+	Path    string    // Full path to executable
+	ModTime time.Time // Modification time of the file
 }
 
-func scanPath(rootPath string, resultChan chan<- *ExecutableInfo) error {
-	return filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+func scanPath(rootPath string, filter *ignore.Filter, resultChan chan<- *ExecutableInfo) error {
+	return fdlimit.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			// Missing roots, like PATH entries that don't exist, aren't errors
+			if !errors.Is(err, fs.ErrNotExist) {
+				filter.Failed(rootPath)
+			}
 			// Skip directories we can't access
 			if info != nil && info.IsDir() {
 				return filepath.SkipDir
@@ -35,11 +49,16 @@ func scanPath(rootPath string, resultChan chan<- *ExecutableInfo) error {
 			return nil
 		}
 
-		// Skip directories
+		// Skip directories, pruning ignored ones
 		if info.IsDir() {
+			if filter.Skip(rootPath, path, true) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
+		filter.Seen(rootPath)
+
 		// Check if file is executable
 		if !isExecutable(info) {
 			return nil
@@ -51,9 +70,14 @@ func scanPath(rootPath string, resultChan chan<- *ExecutableInfo) error {
 			return nil
 		}
 
+		if filter.Skip(rootPath, path, false) {
+			return nil
+		}
+
 		resultChan <- &ExecutableInfo{
-			Name: baseName,
-			Path: path,
+			Name:    baseName,
+			Path:    path,
+			ModTime: info.ModTime(),
 		}
 
 		return nil
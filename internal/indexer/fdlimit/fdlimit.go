@@ -0,0 +1,158 @@
+// Package fdlimit keeps scanners working when the process runs out of file descriptors.
+// Opening a file or reading a directory that fails with EMFILE or ENFILE is retried after a
+// pause, with fewer files open at once; what still can't be read is counted so the indexer
+// can report it instead of silently leaving entries out.
+package fdlimit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxOpen bounds the files opened through Do at the same time, across all scanners
+	maxOpen = 16
+	// retries is the number of times an operation is retried once descriptors run out
+	retries = 5
+)
+
+var (
+	slots = make(chan struct{}, maxOpen)
+
+	// backoff is the pause before the first retry, doubled for every following one
+	backoff = 20 * time.Millisecond
+
+	// walk walks a tree, overridden by tests to simulate failing directories
+	walk = filepath.Walk
+
+	exhausted  atomic.Int64
+	unreadable atomic.Int64
+)
+
+// IsExhausted reports whether err comes from running out of file descriptors, for the
+// process (EMFILE) or the whole system (ENFILE)
+func IsExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// Do runs op, which opens files and closes them before returning. An op failing for lack of
+// descriptors is retried after a growing pause, while other ops wait to start.
+// The error of the last attempt is returned; an op that never got its descriptor is
+// counted as unreadable.
+func Do(op func() error) error {
+	slots <- struct{}{}
+	err := op()
+	<-slots
+	if !IsExhausted(err) {
+		return err
+	}
+
+	pause := backoff
+	for range retries {
+		exhausted.Add(1)
+		// Holding every slot stops the other scanners from opening anything meanwhile
+		drained := drain()
+		time.Sleep(pause)
+		err = op()
+		release(drained)
+		if !IsExhausted(err) {
+			return err
+		}
+		pause *= 2
+	}
+	unreadable.Add(1)
+	return err
+}
+
+// drain takes the free slots and returns how many it got
+func drain() int {
+	n := 0
+	for {
+		select {
+		case slots <- struct{}{}:
+			n++
+		default:
+			return n
+		}
+	}
+}
+
+func release(n int) {
+	for range n {
+		<-slots
+	}
+}
+
+// Walk walks the tree at root like filepath.Walk. Directories that can't be read for lack
+// of descriptors are skipped and walked again once the walk is over, after a pause; those
+// still failing after the retries are passed to fn with the error and counted as unreadable.
+func Walk(root string, fn filepath.WalkFunc) error {
+	var failed []string
+	collect := func(last bool) filepath.WalkFunc {
+		return func(path string, info os.FileInfo, err error) error {
+			if IsExhausted(err) && !last {
+				failed = append(failed, path)
+				exhausted.Add(1)
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if IsExhausted(err) {
+				unreadable.Add(1)
+			}
+			return fn(path, info, err)
+		}
+	}
+
+	if err := walk(root, collect(false)); err != nil {
+		return err
+	}
+	pause := backoff
+	for attempt := 1; attempt <= retries && len(failed) > 0; attempt++ {
+		time.Sleep(pause)
+		pause *= 2
+		pending := failed
+		failed = nil
+		for _, path := range pending {
+			if err := walk(path, collect(attempt == retries)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Exhausted returns the number of times descriptors ran out so far
+func Exhausted() int64 {
+	return exhausted.Load()
+}
+
+// Unreadable returns the number of files and directories given up on so far for lack of
+// descriptors
+func Unreadable() int64 {
+	return unreadable.Load()
+}
+
+// Raise sets the soft limit of open files to the hard one and returns the resulting limit.
+// The Go runtime usually did it already at startup; child processes get the original limit
+// back either way.
+func Raise() (uint64, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, err
+	}
+	if limit.Cur < limit.Max {
+		raised := limit
+		raised.Cur = raised.Max
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised); err != nil {
+			return limit.Cur, err
+		}
+		limit = raised
+	}
+	return limit.Cur, nil
+}
@@ -0,0 +1,13 @@
+package fdlimit
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFdlimit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Fdlimit Suite")
+}
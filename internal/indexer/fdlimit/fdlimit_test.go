@@ -0,0 +1,140 @@
+package fdlimit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("fdlimit", func() {
+	BeforeEach(func() {
+		DeferCleanup(func(orig time.Duration) { backoff = orig }, backoff)
+		backoff = time.Millisecond
+	})
+
+	It("should recognize exhausted descriptors", func() {
+		Expect(IsExhausted(&fs.PathError{Op: "open", Path: "/x", Err: syscall.EMFILE})).To(BeTrue())
+		Expect(IsExhausted(fmt.Errorf("walk: %w", syscall.ENFILE))).To(BeTrue())
+		Expect(IsExhausted(os.ErrNotExist)).To(BeFalse())
+		Expect(IsExhausted(nil)).To(BeFalse())
+	})
+
+	Describe("Do", func() {
+		It("should retry an open failing for lack of descriptors", func() {
+			before, lost := Exhausted(), Unreadable()
+			calls := 0
+			err := Do(func() error {
+				calls++
+				if calls < 3 {
+					return &fs.PathError{Op: "open", Path: "/a.desktop", Err: syscall.EMFILE}
+				}
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(Equal(3))
+			Expect(Exhausted() - before).To(Equal(int64(2)))
+			Expect(Unreadable()).To(Equal(lost))
+		})
+
+		It("should give up after the retries and count the file", func() {
+			lost := Unreadable()
+			calls := 0
+			err := Do(func() error {
+				calls++
+				return syscall.ENFILE
+			})
+			Expect(err).To(MatchError(syscall.ENFILE))
+			Expect(calls).To(Equal(retries + 1))
+			Expect(Unreadable() - lost).To(Equal(int64(1)))
+		})
+
+		It("should not retry other errors", func() {
+			calls := 0
+			err := Do(func() error {
+				calls++
+				return os.ErrPermission
+			})
+			Expect(err).To(MatchError(os.ErrPermission))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	Describe("Walk", func() {
+		var root string
+
+		BeforeEach(func() {
+			root = GinkgoT().TempDir()
+			for _, rel := range []string{"a/one", "b/two", "b/c/three"} {
+				path := filepath.Join(root, rel)
+				Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+				Expect(os.WriteFile(path, nil, 0644)).To(Succeed())
+			}
+			DeferCleanup(func(orig func(string, filepath.WalkFunc) error) { walk = orig }, walk)
+		})
+
+		// failing makes reading dir fail for lack of descriptors in its first times walks
+		failing := func(dir string, times int) {
+			walk = func(root string, fn filepath.WalkFunc) error {
+				return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+					if path == dir && times > 0 {
+						times--
+						return fn(path, info, &fs.PathError{Op: "open", Path: path, Err: syscall.EMFILE})
+					}
+					return fn(path, info, err)
+				})
+			}
+		}
+
+		files := func() ([]string, []error) {
+			var (
+				found []string
+				errs  []error
+			)
+			Expect(Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					errs = append(errs, err)
+					return filepath.SkipDir
+				}
+				if !info.IsDir() {
+					rel, _ := filepath.Rel(root, path)
+					found = append(found, rel)
+				}
+				return nil
+			})).To(Succeed())
+			return found, errs
+		}
+
+		It("should walk directories again once descriptors are back", func() {
+			failing(filepath.Join(root, "b"), 2)
+			found, errs := files()
+			Expect(errs).To(BeEmpty())
+			Expect(found).To(ConsistOf("a/one", "b/two", "b/c/three"))
+		})
+
+		It("should pass directories still failing to fn and count them", func() {
+			lost := Unreadable()
+			failing(filepath.Join(root, "b"), retries+1)
+			found, errs := files()
+			Expect(found).To(ConsistOf("a/one"))
+			Expect(errs).To(HaveLen(1))
+			Expect(errors.Is(errs[0], syscall.EMFILE)).To(BeTrue())
+			Expect(Unreadable() - lost).To(Equal(int64(1)))
+		})
+	})
+
+	It("should leave the soft limit at most at the hard one", func() {
+		limit, err := Raise()
+		Expect(err).NotTo(HaveOccurred())
+		var rlimit syscall.Rlimit
+		Expect(syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit)).To(Succeed())
+		Expect(limit).To(Equal(rlimit.Cur))
+		Expect(rlimit.Cur).To(Equal(rlimit.Max))
+	})
+})
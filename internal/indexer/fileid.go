@@ -0,0 +1,39 @@
+package indexer
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// FileID identifies a file as it was when stat'ed: the same path with another FileID was
+// replaced or rewritten since, e.g. by a package upgrade
+type FileID struct {
+	Dev     uint64
+	Ino     uint64
+	Size    int64
+	ModTime time.Time
+}
+
+// StatFileID returns the identity of the file at path, following symlinks
+func StatFileID(path string) (FileID, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileID{}, err
+	}
+	id := FileID{Size: info.Size(), ModTime: info.ModTime()}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		id.Dev, id.Ino = uint64(stat.Dev), stat.Ino
+	}
+	return id, nil
+}
+
+// IsZero reports whether the identity is unknown
+func (id FileID) IsZero() bool {
+	return id == FileID{}
+}
+
+// Equal reports whether both identify the same file, unchanged
+func (id FileID) Equal(other FileID) bool {
+	return id.Dev == other.Dev && id.Ino == other.Ino && id.Size == other.Size && id.ModTime.Equal(other.ModTime)
+}
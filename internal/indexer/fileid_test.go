@@ -0,0 +1,46 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("ExecID", func() {
+	ginkgo.It("should capture the identity of the launched binary when scanning", func() {
+		dir := ginkgo.GinkgoT().TempDir()
+		tool := filepath.Join(dir, "tool")
+		gomega.Expect(os.WriteFile(tool, []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.Symlink(tool, filepath.Join(dir, "alias"))).To(gomega.Succeed())
+
+		idx := NewIndexer()
+		idx.sources = []source{{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }}}
+		_, err := idx.Reindex(context.Background(), []string{dir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		want, err := StatFileID(tool)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(want.Ino).NotTo(gomega.BeZero())
+		for _, key := range []string{"tool", "alias"} {
+			entry, ok := idx.GetIndex().GetByKey(key)
+			gomega.Expect(ok).To(gomega.BeTrue(), key)
+			gomega.Expect(entry.ExecID.Equal(want)).To(gomega.BeTrue(), key)
+		}
+
+		// Replaced by another file at the same path
+		gomega.Expect(os.Remove(tool)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(tool, []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		replaced, err := StatFileID(tool)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(replaced.Equal(want)).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should be zero for binaries that couldn't be resolved", func() {
+		_, err := StatFileID(filepath.Join(ginkgo.GinkgoT().TempDir(), "missing"))
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(FileID{}.IsZero()).To(gomega.BeTrue())
+	})
+})
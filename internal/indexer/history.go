@@ -0,0 +1,124 @@
+package indexer
+
+import (
+	"errors"
+	"sort"
+)
+
+// Bounds of the generation history kept for diffs
+const (
+	historyGenerations = 16      // generations kept, the current one included
+	historyMaxKeys     = 250_000 // entry keys kept over all generations
+)
+
+// ErrGenerationUnknown is returned by Diff for generations not in the history
+var ErrGenerationUnknown = errors.New("generation not in history")
+
+// snapshot is what the history keeps of an index generation: the name of every entry
+// path. Names share their memory with the entries.
+type snapshot struct {
+	generation uint64
+	names      map[string]string
+}
+
+// Change is an entry added, removed or renamed between two generations
+type Change struct {
+	Path    string
+	Name    string // Name in the later generation, the removed name for removals
+	OldName string // Name in the earlier generation, renames only
+}
+
+// IndexDiff lists what changed between two generations, each list sorted by path
+type IndexDiff struct {
+	From, To uint64
+	Added    []Change
+	Removed  []Change
+	Renamed  []Change
+}
+
+// newSnapshot records the entry keys of index
+func newSnapshot(generation uint64, index *Index) snapshot {
+	entries := index.GetAll()
+	names := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		names[entry.Path] = entry.Name
+	}
+	return snapshot{generation: generation, names: names}
+}
+
+// recordSnapshot adds a generation to the history and prunes the oldest ones beyond the
+// bounds. The latest generation is always kept. Must be called with idx.mu held.
+func (idx *Indexer) recordSnapshot(snap snapshot) {
+	idx.history = append(idx.history, snap)
+
+	keys := 0
+	for _, s := range idx.history {
+		keys += len(s.names)
+	}
+	drop := 0
+	for len(idx.history)-drop > 1 && (len(idx.history)-drop > idx.historyGenerations || keys > idx.historyMaxKeys) {
+		keys -= len(idx.history[drop].names)
+		drop++
+	}
+	if drop > 0 {
+		idx.history = append(idx.history[:0:0], idx.history[drop:]...)
+	}
+}
+
+// HistoryRange returns the oldest and the newest generation in the history
+func (idx *Indexer) HistoryRange() (oldest, newest uint64) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.history[0].generation, idx.history[len(idx.history)-1].generation
+}
+
+// Diff returns the entries added, removed and renamed from generation from to generation
+// to. Entries are keyed by path, a rename is a path whose name changed.
+func (idx *Indexer) Diff(from, to uint64) (*IndexDiff, error) {
+	idx.mu.RLock()
+	older, okFrom := idx.snapshot(from)
+	newer, okTo := idx.snapshot(to)
+	idx.mu.RUnlock()
+	if !okFrom || !okTo {
+		return nil, ErrGenerationUnknown
+	}
+
+	diff := DiffNames(older.names, newer.names)
+	diff.From, diff.To = from, to
+	return diff, nil
+}
+
+// DiffNames returns the entries added, removed and renamed from older to newer, both the
+// name of every entry path, like Diff does for generations. From and To are left zero.
+func DiffNames(older, newer map[string]string) *IndexDiff {
+	diff := &IndexDiff{}
+	for path, name := range newer {
+		oldName, ok := older[path]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, Change{Path: path, Name: name})
+		case oldName != name:
+			diff.Renamed = append(diff.Renamed, Change{Path: path, Name: name, OldName: oldName})
+		}
+	}
+	for path, name := range older {
+		if _, ok := newer[path]; !ok {
+			diff.Removed = append(diff.Removed, Change{Path: path, Name: name})
+		}
+	}
+
+	for _, changes := range [][]Change{diff.Added, diff.Removed, diff.Renamed} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	}
+	return diff
+}
+
+// snapshot finds a generation in the history. Must be called with idx.mu held.
+func (idx *Indexer) snapshot(generation uint64) (snapshot, bool) {
+	for _, s := range idx.history {
+		if s.generation == generation {
+			return s, true
+		}
+	}
+	return snapshot{}, false
+}
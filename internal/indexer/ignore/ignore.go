@@ -0,0 +1,307 @@
+// Package ignore decides which files scanners skip: global exclude patterns, directories
+// pruned by name and the ignore files found in scanned directories.
+//
+// An .adeignore file applies to the subtree of its directory. It holds one glob per line in
+// filepath.Match syntax, blank lines and lines starting with # are skipped. A pattern without
+// a slash matches names at any depth, one with a slash matches paths relative to the
+// directory of the ignore file, and a trailing slash restricts it to directories. A .hidden
+// file lists names of its own directory to skip, as file managers use it.
+package ignore
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the ignore files read in every scanned directory
+const (
+	AdeIgnore = ".adeignore"
+	Hidden    = ".hidden"
+)
+
+// IsIgnoreFile reports whether path names an ignore file
+func IsIgnoreFile(path string) bool {
+	name := filepath.Base(path)
+	return name == AdeIgnore || name == Hidden
+}
+
+// Filter holds the rules of a scan. It's safe for concurrent use by the walks of several roots.
+type Filter struct {
+	exclude []string // global patterns, applied everywhere
+	prune   []string // directories never walked
+
+	mu      sync.Mutex
+	dirs    map[string]*rules        // rules by directory, nil when it has no ignore files
+	found   []string                 // ignore files read
+	seen    map[string]int           // files walked by root
+	failed  map[string]int           // files and directories that couldn't be read, by root
+	walked  map[string]time.Duration // wall time of the walks, by root
+	ignored atomic.Int64
+	pruned  atomic.Int64
+}
+
+// NewFilter returns a filter applying the global exclude patterns along with the ignore files
+// of the scanned directories, and pruning the prune directories. Patterns without a slash
+// match names, others absolute paths. Prune entries without a slash match directory names,
+// others the end of the path below the root, e.g. ".local/share/Trash".
+func NewFilter(exclude, prune []string) *Filter {
+	return &Filter{exclude: exclude, prune: prune, dirs: make(map[string]*rules), seen: make(map[string]int), failed: make(map[string]int), walked: make(map[string]time.Duration)}
+}
+
+// Skip reports whether the file or directory at path under root is to be left out, counting
+// it if so. Only the ignore files of root and the directories below it apply. A nil filter
+// skips nothing.
+func (f *Filter) Skip(root, path string, isDir bool) bool {
+	if f == nil || path == root {
+		return false
+	}
+	if isDir && f.prunes(root, path) {
+		f.pruned.Add(1)
+		return true
+	}
+	if f.excluded(path) || f.ignoredBelow(root, path, isDir) {
+		f.ignored.Add(1)
+		return true
+	}
+	return false
+}
+
+// Seen records a file walked under root, whether it's skipped or not
+func (f *Filter) Seen(root string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seen[root]++
+}
+
+// SeenUnder returns the number of files walked under root so far
+func (f *Filter) SeenUnder(root string) int {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seen[root]
+}
+
+// Walking records the start of the walk of root, the returned func its end. Scanners
+// walk their roots in turn, so the time of a root is that of its own walk even when
+// several scanners run at once. Walks of the same root add up.
+func (f *Filter) Walking(root string) (done func()) {
+	if f == nil {
+		return func() {}
+	}
+	started := time.Now()
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.walked[root] += time.Since(started)
+	}
+}
+
+// WalkTime returns the time spent walking root so far
+func (f *Filter) WalkTime(root string) time.Duration {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.walked[root]
+}
+
+// Failed records a file or directory under root that couldn't be read
+func (f *Filter) Failed(root string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failed[root]++
+}
+
+// FailedUnder returns the number of files and directories under root that couldn't be read
+// so far
+func (f *Filter) FailedUnder(root string) int {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failed[root]
+}
+
+// Ignored returns the number of files and directories skipped so far, a directory counting once
+func (f *Filter) Ignored() int {
+	if f == nil {
+		return 0
+	}
+	return int(f.ignored.Load())
+}
+
+// Pruned returns the number of directories pruned so far
+func (f *Filter) Pruned() int {
+	if f == nil {
+		return 0
+	}
+	return int(f.pruned.Load())
+}
+
+// Files returns the ignore files read so far
+func (f *Filter) Files() []string {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.found...)
+}
+
+func (f *Filter) prunes(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, dir := range f.prune {
+		if strings.Contains(dir, "/") {
+			if rel == dir || strings.HasSuffix(rel, "/"+dir) {
+				return true
+			}
+		} else if filepath.Base(path) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) excluded(path string) bool {
+	for _, pattern := range f.exclude {
+		subject := path
+		if !strings.Contains(pattern, "/") {
+			subject = filepath.Base(path)
+		}
+		if ok, _ := filepath.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredBelow checks path against the ignore files of every directory from root down to
+// the one containing it
+func (f *Filter) ignoredBelow(root, path string, isDir bool) bool {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if r := f.rulesOf(dir); r != nil {
+			rel, err := filepath.Rel(dir, path)
+			if err == nil && r.match(rel, isDir) {
+				return true
+			}
+		}
+		if dir == root || dir == filepath.Dir(dir) {
+			return false
+		}
+	}
+}
+
+func (f *Filter) rulesOf(dir string) *rules {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r, ok := f.dirs[dir]; ok {
+		return r
+	}
+	r, files := loadRules(dir)
+	f.dirs[dir] = r
+	f.found = append(f.found, files...)
+	return r
+}
+
+// rules are the ignore files of one directory
+type rules struct {
+	patterns []string // .adeignore globs
+	hidden   []string // .hidden names
+}
+
+func loadRules(dir string) (*rules, []string) {
+	var (
+		r     rules
+		files []string
+	)
+	if lines, ok := readLines(filepath.Join(dir, AdeIgnore)); ok {
+		r.patterns = lines
+		files = append(files, filepath.Join(dir, AdeIgnore))
+	}
+	if lines, ok := readLines(filepath.Join(dir, Hidden)); ok {
+		r.hidden = lines
+		files = append(files, filepath.Join(dir, Hidden))
+	}
+	if files == nil {
+		return nil, nil
+	}
+	return &r, files
+}
+
+// readLines returns the lines of an ignore file without blank lines and comments
+func readLines(path string) ([]string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, true
+}
+
+// match checks a path relative to the directory of the rules
+func (r *rules) match(rel string, isDir bool) bool {
+	for _, name := range r.hidden {
+		if rel == name {
+			return true
+		}
+	}
+	for _, pattern := range r.patterns {
+		pattern, dirOnly := strings.CutSuffix(pattern, "/")
+		if dirOnly && !isDir {
+			continue
+		}
+		subject := rel
+		if strings.Contains(pattern, "/") {
+			pattern = strings.TrimPrefix(pattern, "/")
+		} else {
+			subject = filepath.Base(rel)
+		}
+		if ok, _ := filepath.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the filter scanners should apply
+func NewContext(ctx context.Context, f *Filter) context.Context {
+	return context.WithValue(ctx, contextKey{}, f)
+}
+
+// FromContext returns the filter of the context, nil if it has none
+func FromContext(ctx context.Context) *Filter {
+	f, _ := ctx.Value(contextKey{}).(*Filter)
+	return f
+}
@@ -0,0 +1,140 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	var root string
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+	})
+
+	write := func(rel, content string) string {
+		path := filepath.Join(root, rel)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+	path := func(rel string) string {
+		return filepath.Join(root, rel)
+	}
+
+	It("should skip nothing without rules", func() {
+		f := NewFilter(nil, nil)
+		Expect(f.Skip(root, path("tool"), false)).To(BeFalse())
+		Expect(f.Ignored()).To(BeZero())
+		Expect(f.Files()).To(BeEmpty())
+
+		var none *Filter
+		Expect(none.Skip(root, path("tool"), false)).To(BeFalse())
+	})
+
+	It("should match globs against names, skipping comments and blank lines", func() {
+		write(AdeIgnore, "# generated\n\n*-helper\n  wrap?  \n")
+		f := NewFilter(nil, nil)
+		Expect(f.Skip(root, path("build-helper"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("wrap1"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("wrap10"), false)).To(BeFalse())
+		Expect(f.Skip(root, path("# generated"), false)).To(BeFalse())
+		Expect(f.Skip(root, path("helper"), false)).To(BeFalse())
+		Expect(f.Ignored()).To(Equal(2))
+		Expect(f.Files()).To(ConsistOf(path(AdeIgnore)))
+	})
+
+	It("should apply ignore files to their subtree only", func() {
+		write(AdeIgnore, "*.sh\n")
+		write("sub/"+AdeIgnore, "tool\n")
+		f := NewFilter(nil, nil)
+
+		// Name patterns match at any depth below their directory
+		Expect(f.Skip(root, path("a.sh"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("sub/deeper/b.sh"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("sub/deeper/tool"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("tool"), false)).To(BeFalse())
+		Expect(f.Skip(root, path("other/tool"), false)).To(BeFalse())
+		Expect(f.Files()).To(ConsistOf(path(AdeIgnore), path("sub/"+AdeIgnore)))
+	})
+
+	It("should match patterns with a slash against relative paths", func() {
+		write(AdeIgnore, "libexec/*\n/top\n")
+		f := NewFilter(nil, nil)
+		Expect(f.Skip(root, path("libexec/x"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("bin/libexec/x"), false)).To(BeFalse())
+		Expect(f.Skip(root, path("top"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("sub/top"), false)).To(BeFalse())
+	})
+
+	It("should restrict patterns with a trailing slash to directories", func() {
+		write(AdeIgnore, "gen/\n")
+		f := NewFilter(nil, nil)
+		Expect(f.Skip(root, path("gen"), true)).To(BeTrue())
+		Expect(f.Skip(root, path("gen"), false)).To(BeFalse())
+	})
+
+	It("should skip the names listed in .hidden in their directory only", func() {
+		write(Hidden, "secret\n")
+		f := NewFilter(nil, nil)
+		Expect(f.Skip(root, path("secret"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("sub/secret"), false)).To(BeFalse())
+		Expect(f.Skip(root, path("secrets"), false)).To(BeFalse())
+	})
+
+	It("should not read ignore files above the root", func() {
+		write(AdeIgnore, "*\n")
+		Expect(NewFilter(nil, nil).Skip(path("bin"), path("bin/tool"), false)).To(BeFalse())
+		Expect(NewFilter(nil, nil).Skip(root, path("bin/tool"), false)).To(BeTrue())
+		// The root itself is never skipped
+		Expect(NewFilter(nil, nil).Skip(root, root, true)).To(BeFalse())
+	})
+
+	It("should apply global patterns along with ignore files", func() {
+		write("bin/"+AdeIgnore, "local-*\n")
+		f := NewFilter([]string{"*~", root + "/bin/old/*"}, nil)
+		Expect(f.Skip(root, path("bin/local-x"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("bin/tool~"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("tool~"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("bin/old/tool"), false)).To(BeTrue())
+		Expect(f.Skip(root, path("bin/tool"), false)).To(BeFalse())
+		Expect(f.Ignored()).To(Equal(4))
+	})
+
+	It("should prune directories by name or path ending", func() {
+		f := NewFilter(nil, []string{".git", "node_modules", ".local/share/Trash"})
+		Expect(f.Skip(root, path("src/.git"), true)).To(BeTrue())
+		Expect(f.Skip(root, path("web/node_modules"), true)).To(BeTrue())
+		Expect(f.Skip(root, path(".local/share/Trash"), true)).To(BeTrue())
+		Expect(f.Skip(root, path("x/.local/share/Trash"), true)).To(BeTrue())
+		Expect(f.Skip(root, path("share/Trash"), true)).To(BeFalse())
+		Expect(f.Skip(root, path("bin/.git"), false)).To(BeFalse())
+		Expect(f.Skip(path(".git"), path(".git"), true)).To(BeFalse())
+		Expect(f.Pruned()).To(Equal(4))
+		Expect(f.Ignored()).To(BeZero())
+	})
+
+	It("should add up the walks and failures of every root", func() {
+		f := NewFilter(nil, nil)
+		for range 2 {
+			done := f.Walking(root)
+			time.Sleep(10 * time.Millisecond)
+			done()
+		}
+		f.Failed(root)
+		Expect(f.WalkTime(root)).To(BeNumerically(">=", 20*time.Millisecond))
+		Expect(f.FailedUnder(root)).To(Equal(1))
+		Expect(f.WalkTime(path("bin"))).To(BeZero())
+		Expect(f.FailedUnder(path("bin"))).To(BeZero())
+
+		// A nil filter records nothing
+		var none *Filter
+		none.Walking(root)()
+		none.Failed(root)
+		Expect(none.WalkTime(root)).To(BeZero())
+	})
+})
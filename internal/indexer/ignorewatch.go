@@ -0,0 +1,132 @@
+package indexer
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/indexer/ignore"
+	"github.com/fsnotify/fsnotify"
+)
+
+// ignoreDebounce is how long ignore files have to stay untouched before the reindex they
+// trigger starts, so an editor saving in several steps causes one run
+var ignoreDebounce = 500 * time.Millisecond
+
+// ignoreWatcher watches the scanned roots and the directories of the ignore files found in
+// the last run
+type ignoreWatcher struct {
+	watcher  *fsnotify.Watcher
+	debounce time.Duration
+	mu       sync.Mutex
+	dirs     map[string]bool
+}
+
+// sync makes the watched directories those of dirs
+func (w *ignoreWatcher) sync(dirs []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for dir := range w.dirs {
+		if !slices.Contains(dirs, dir) {
+			w.watcher.Remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+	for _, dir := range dirs {
+		if w.dirs[dir] {
+			continue
+		}
+		// Roots that don't exist are fine, they are not watched until they do
+		if err := w.watcher.Add(dir); err == nil {
+			w.dirs[dir] = true
+		}
+	}
+}
+
+// WatchIgnoreFiles reindexes whenever an ignore file is created, edited or removed in a scanned
+// root or next to an ignore file found before. Since the index is rebuilt as a whole, the run
+// covers every root, over the search path of the last run. Watching stops when ctx is done.
+func (idx *Indexer) WatchIgnoreFiles(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w := &ignoreWatcher{watcher: watcher, debounce: ignoreDebounce, dirs: make(map[string]bool)}
+
+	idx.mu.Lock()
+	idx.ignoreWatcher = w
+	watched := idx.watched
+	idx.mu.Unlock()
+	w.sync(watched)
+
+	go idx.watchIgnoreFiles(ctx, w)
+	return nil
+}
+
+func (idx *Indexer) watchIgnoreFiles(ctx context.Context, w *ignoreWatcher) {
+	defer w.watcher.Close()
+
+	var (
+		timer *time.Timer
+		fire  <-chan time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !ignore.IsIgnoreFile(event.Name) || event.Op == fsnotify.Chmod {
+				continue
+			}
+			log.Printf("[DEBUG] Ignore file %s changed (%s)", event.Name, event.Op)
+			if timer == nil {
+				timer = time.NewTimer(w.debounce)
+			} else {
+				timer.Reset(w.debounce)
+			}
+			fire = timer.C
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("[WARN] Ignore file watcher: %v", err)
+		case <-fire:
+			fire = nil
+			idx.mu.RLock()
+			paths := idx.lastPaths
+			idx.mu.RUnlock()
+			req := ReindexRequest{Scope: FullScope(paths), Priority: PriorityBackground, Trigger: TriggerIgnore, Fresh: true}
+			go func() {
+				if _, err := idx.Schedule(ctx, req); err != nil && ctx.Err() == nil {
+					log.Printf("[ERROR] Reindex after ignore file change failed: %v", err)
+				}
+			}()
+		}
+	}
+}
+
+// watchedDirs returns the directories to watch for ignore file changes after a run: the roots
+// of every scanner and the directories the ignore files were found in
+func watchedDirs(stats []SourceStats, filters []*ignore.Filter) []string {
+	var dirs []string
+	for i := range stats {
+		for _, root := range stats[i].Roots {
+			dirs = append(dirs, filepath.Clean(root))
+		}
+		for _, file := range filters[i].Files() {
+			dirs = append(dirs, filepath.Dir(file))
+		}
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
@@ -0,0 +1,114 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/indexer/ignore"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Ignore files", func() {
+	var (
+		idx     *Indexer
+		binDir  string
+		appsDir string
+	)
+
+	write := func(path, content string, perm os.FileMode) {
+		gomega.Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(path, []byte(content), perm)).To(gomega.Succeed())
+	}
+
+	names := func() []string {
+		var result []string
+		for _, entry := range idx.GetIndex().GetAll() {
+			result = append(result, entry.Name)
+		}
+		return result
+	}
+
+	stats := func(name string) SourceStats {
+		for _, source := range idx.Sources() {
+			if source.Name == name {
+				return source
+			}
+		}
+		return SourceStats{}
+	}
+
+	ginkgo.BeforeEach(func() {
+		tmpDir := ginkgo.GinkgoT().TempDir()
+		binDir = filepath.Join(tmpDir, "bin")
+		appsDir = filepath.Join(tmpDir, "applications")
+		for _, name := range []string{"tool", "gen-helper", "nested/other", "nested/deep/skipped", "generated/a", "generated/b"} {
+			write(filepath.Join(binDir, name), "#!/bin/sh\n", 0755)
+		}
+		write(filepath.Join(binDir, ignore.AdeIgnore), "# wrappers\n*-helper\ngenerated/\n", 0644)
+		write(filepath.Join(binDir, "nested", ignore.AdeIgnore), "deep/*\n", 0644)
+
+		desktopFile := "[Desktop Entry]\nType=Application\nName=%s\nExec=true\n"
+		write(filepath.Join(appsDir, "shown.desktop"), fmt.Sprintf(desktopFile, "Shown"), 0644)
+		write(filepath.Join(appsDir, "hidden.desktop"), fmt.Sprintf(desktopFile, "Hidden"), 0644)
+		write(filepath.Join(appsDir, ignore.Hidden), "hidden.desktop\n", 0644)
+
+		idx = NewIndexer()
+		idx.AddScanner(rootedScanner{Scanner: desktopScanner{}, name: "apps"}, appsDir)
+	})
+
+	ginkgo.It("should leave ignored files out in both scanners and count them", func() {
+		_, err := idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Expect(names()).To(gomega.ContainElements("tool", "other", "Shown"))
+		gomega.Expect(names()).NotTo(gomega.ContainElements("gen-helper", "skipped", "a", "b", "Hidden"))
+		// gen-helper, generated/ and nested/deep/skipped
+		gomega.Expect(stats(ExecutableScanner).Ignored).To(gomega.Equal(3))
+		gomega.Expect(stats("apps").Ignored).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("should reindex when an ignore file changes", func() {
+		defer func(orig time.Duration) { ignoreDebounce = orig }(ignoreDebounce)
+		ignoreDebounce = 10 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		gomega.Expect(idx.WatchIgnoreFiles(ctx)).To(gomega.Succeed())
+		_, err := idx.Reindex(ctx, []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(names()).To(gomega.ContainElement("tool"))
+
+		// Edited in a subdirectory
+		write(filepath.Join(binDir, "nested", ignore.AdeIgnore), "other\n", 0644)
+		gomega.Eventually(names).Should(gomega.ContainElement("skipped"))
+		gomega.Expect(names()).NotTo(gomega.ContainElement("other"))
+
+		// Created at a root
+		write(filepath.Join(appsDir, ignore.AdeIgnore), "shown.desktop\n", 0644)
+		gomega.Eventually(names).ShouldNot(gomega.ContainElement("Shown"))
+
+		// Removed
+		gomega.Expect(os.Remove(filepath.Join(binDir, ignore.AdeIgnore))).To(gomega.Succeed())
+		gomega.Eventually(names).Should(gomega.ContainElements("gen-helper", "a", "b"))
+		gomega.Expect(stats(ExecutableScanner).Roots).To(gomega.HaveExactElements(binDir))
+	})
+
+	ginkgo.It("should leave changes to other files alone", func() {
+		defer func(orig time.Duration) { ignoreDebounce = orig }(ignoreDebounce)
+		ignoreDebounce = 10 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		gomega.Expect(idx.WatchIgnoreFiles(ctx)).To(gomega.Succeed())
+		_, err := idx.Reindex(ctx, []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		write(filepath.Join(binDir, "new-tool"), "#!/bin/sh\n", 0755)
+		gomega.Consistently(idx.Generation, 100*time.Millisecond).Should(gomega.Equal(uint64(1)))
+	})
+})
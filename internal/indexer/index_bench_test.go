@@ -0,0 +1,114 @@
+package indexer
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The mixed benchmarks grow the index as they add entries, run them with a fixed count:
+//
+//	go test -run '^$' -bench Mixed -benchtime 20000x -cpu 1,4 ./internal/indexer
+
+// benchEntries is the size of a large index, like one holding a full texlive and many
+// global node packages
+const benchEntries = 60000
+
+func benchIndex(n int) *Index {
+	index := NewIndex()
+	for i := range n {
+		index.Add(&Entry{Name: fmt.Sprintf("tool%d", i), Path: fmt.Sprintf("/usr/bin/tool%d", i)})
+	}
+	assignKeys(index, []SourceStats{{Name: ExecutableScanner, Roots: []string{"/usr/bin"}}})
+	return index
+}
+
+// mixedLoad keeps listers walking the whole index and a writer adding bursts of entries
+// until the returned function is called
+func mixedLoad(index *Index, listers int) (stop func()) {
+	var (
+		done atomic.Bool
+		wg   sync.WaitGroup
+	)
+	for range listers {
+		wg.Go(func() {
+			for !done.Load() {
+				_ = len(index.GetAll())
+			}
+		})
+	}
+	wg.Go(func() {
+		for i := 0; !done.Load(); i++ {
+			for j := range 100 {
+				index.Add(&Entry{Name: "new", Path: fmt.Sprintf("/opt/bin/new%d-%d", i, j)})
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	return func() {
+		done.Store(true)
+		wg.Wait()
+	}
+}
+
+// BenchmarkIndexGetMixed measures Get, the lookup of run, while lists and add bursts hit
+// the index, reporting the 99th percentile latency as well
+func BenchmarkIndexGetMixed(b *testing.B) {
+	index := benchIndex(benchEntries)
+	stop := mixedLoad(index, 2)
+	defer stop()
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+	)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var local []time.Duration
+		id := int64(1)
+		for pb.Next() {
+			start := time.Now()
+			if _, ok := index.Get(id); !ok {
+				b.Error("entry missing")
+			}
+			local = append(local, time.Since(start))
+			id = id%benchEntries + 1
+		}
+		mu.Lock()
+		latencies = append(latencies, local...)
+		mu.Unlock()
+	})
+	b.StopTimer()
+
+	slices.Sort(latencies)
+	if len(latencies) > 0 {
+		b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+	}
+}
+
+// BenchmarkIndexAddMixed measures Add while lists walk the index
+func BenchmarkIndexAddMixed(b *testing.B) {
+	index := benchIndex(benchEntries)
+	stop := mixedLoad(index, 2)
+	defer stop()
+
+	b.ResetTimer()
+	for i := range b.N {
+		index.Add(&Entry{Name: "bench", Path: fmt.Sprintf("/opt/bench/tool%d", i)})
+	}
+}
+
+// BenchmarkIndexGetAllMixed measures a list walking the whole index during add bursts
+func BenchmarkIndexGetAllMixed(b *testing.B) {
+	index := benchIndex(benchEntries)
+	stop := mixedLoad(index, 0)
+	defer stop()
+
+	b.ResetTimer()
+	for range b.N {
+		_ = index.GetAll()
+	}
+}
@@ -1,167 +1,642 @@
 package indexer
 
 import (
+	"cmp"
 	"context"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/0xADE/ade-ctld/internal/config"
-	"github.com/0xADE/ade-ctld/internal/indexer/desktop"
-	"github.com/0xADE/ade-ctld/internal/indexer/executable"
+	"github.com/0xADE/ade-ctld/internal/events"
+	"github.com/0xADE/ade-ctld/internal/indexer/fdlimit"
+	"github.com/0xADE/ade-ctld/internal/indexer/ignore"
+	"github.com/0xADE/ade-ctld/internal/translit"
 )
 
 // Indexer coordinates indexing of executables and desktop files
 type Indexer struct {
 	index      *Index
-	running    bool
-	mu         sync.RWMutex
-	indexCtx   context.Context
-	indexCancel context.CancelFunc
-	indexWg    sync.WaitGroup
+	sources    []source
+	stats      []SourceStats
+	generation uint64
+	history    []snapshot // oldest generation first
+	lastPaths  []string   // search path of the last completed run
+	watched    []string   // directories holding the roots and ignore files of the last run
+	unreadable int        // files and directories the last run gave up on for lack of descriptors
+	indexedAt  time.Time  // start of the last completed run
+
+	historyGenerations int
+	historyMaxKeys     int
+	inspectWrappers    bool                       // resolve trivial wrapper scripts to their target
+	transliterate      bool                       // spell names of other scripts in Latin for matching
+	split              Split                      // share of the default roots walked, see SetSplit
+	slowRoot           time.Duration              // walks of a root taking longer are logged, 0 never
+	renameRules        func() []config.RenameRule // read at the start of every run
+	maxEntries         int                        // entries beyond are left out, 0 keeps all
+	workers            int                        // per-entry work of every scanner, see SetWorkers
+	executable         string                     // the daemon's own binary, see SetExecutable
+	companions         []string                   // names of the binaries next to it tagged along
+	truncated          int                        // entries the last run left out for maxEntries
+	truncatedRoots     []string                   // roots with the most entries when truncated
+	bus                *events.Bus                // told about every index swap
+	ignoreWatcher      *ignoreWatcher
+	current            *reindexJob // run under way, see Schedule
+	pending            *reindexJob // follow-up queued behind it
+	mu                 sync.RWMutex
 }
 
 // NewIndexer creates a new indexer instance
 func NewIndexer() *Indexer {
+	index := NewIndex()
 	return &Indexer{
-		index: NewIndex(),
+		index:              index,
+		sources:            registeredSources(),
+		history:            []snapshot{newSnapshot(0, index)},
+		historyGenerations: historyGenerations,
+		historyMaxKeys:     historyMaxKeys,
+		inspectWrappers:    config.Get().InspectWrappers(),
+		transliterate:      config.Get().Transliterate(),
+		slowRoot:           config.Get().SlowRoot(),
+		renameRules:        config.Get().RenameRules,
+		maxEntries:         config.Get().MaxEntries(),
+		workers:            config.Get().Workers(),
+		executable:         runningExecutable(),
+		companions:         config.Get().Companions(),
 	}
 }
 
 // Start begins the indexing process using configured paths
 func (idx *Indexer) Start(ctx context.Context) error {
-	cfg := config.Get()
-	paths := cfg.Path()
-	return idx.runIndexing(ctx, paths)
+	_, err := idx.Schedule(ctx, ReindexRequest{Scope: FullScope(nil), Priority: PriorityClient, Trigger: TriggerStartup})
+	return err
 }
 
-// Reindex reindexes executables in the provided paths, or all registered paths if none provided
-// Returns the total number of indexed executables
+// Reindex reindexes executables in the provided paths, or all registered paths if none provided,
+// on behalf of a client. Returns the total number of indexed executables
 func (idx *Indexer) Reindex(ctx context.Context, paths []string) (int, error) {
-	var indexingPaths []string
-	if len(paths) > 0 {
-		indexingPaths = paths
-	} else {
-		cfg := config.Get()
-		indexingPaths = cfg.Path()
-	}
+	return idx.Schedule(ctx, ReindexRequest{Scope: FullScope(paths), Priority: PriorityClient, Trigger: TriggerCommand})
+}
 
-	err := idx.runIndexing(ctx, indexingPaths)
-	if err != nil {
-		return 0, err
+// ReindexMerge rescans executables in the provided directories only and merges them into
+// the current index: entries under those directories are replaced, everything else found by
+// the last runs is kept as is. Nothing is rescanned if no directory is provided.
+// Returns the total number of indexed entries
+func (idx *Indexer) ReindexMerge(ctx context.Context, dirs []string) (int, error) {
+	if len(dirs) == 0 {
+		return idx.GetIndex().Count(), nil
 	}
+	return idx.Schedule(ctx, ReindexRequest{Scope: RootsScope(dirs...), Priority: PriorityClient, Trigger: TriggerCommand})
+}
 
+// runIndexing performs the actual indexing work, run by the scheduler one at a time. The
+// freshly built index replaces the current one only when the run completes without being
+// cancelled. With merge only the executable scanner runs, over paths alone, and the entries
+// of the current index outside paths are carried over.
+func (idx *Indexer) runIndexing(indexCtx context.Context, paths []string, merge bool) error {
 	idx.mu.RLock()
-	count := idx.index.Count()
+	sources := slices.Clone(idx.sources)
+	opts := scanOptions{inspectWrappers: idx.inspectWrappers, transliterate: idx.transliterate, renames: idx.renameRules(), maxEntries: idx.maxEntries, workers: idx.workers}
+	executable, companions := idx.executable, idx.companions
+	current, last := idx.index, idx.stats
+	slowRoot := idx.slowRoot
 	idx.mu.RUnlock()
 
-	return count, nil
-}
+	// Stat'ed on every run, an upgrade replaces the binary
+	opts.selfTools = selfTools(executable, companions)
+	started := time.Now()
+	index := NewIndex()
+	stats := make([]SourceStats, len(sources))
+	filters := make([]*ignore.Filter, len(sources))
+	capped := make([]int, len(sources)) // entries found past the cap, not added
+	lost := fdlimit.Unreadable()
+	var (
+		truncated      int
+		truncatedRoots []string
+	)
+	if indexCtx.Err() == nil {
+		exclude, prune := config.Get().Exclude(), config.Get().Prune()
+		dropped := 0
+		if merge {
+			dropped = keepOutside(index, current, paths)
+		}
+		var wg sync.WaitGroup
+		for i, src := range sources {
+			filters[i] = ignore.NewFilter(exclude, prune)
+			roots := paths
+			switch {
+			case !merge:
+				roots = sourceRoots(src, paths, idx.split)
+				stats[i] = SourceStats{Name: src.scanner.Name(), Roots: roots}
+			case src.scanner.Name() != ExecutableScanner:
+				// Other scanners don't walk the search path, their entries were all kept
+				stats[i] = lastStats(last, src.scanner.Name())
+				continue
+			default:
+				stats[i] = lastStats(last, src.scanner.Name())
+				stats[i].Roots = mergeRoots(stats[i].Roots, paths)
+				stats[i].Count = max(stats[i].Count-dropped, 0)
+				// Those of the roots rescanned are replaced
+				stats[i].Scans = slices.DeleteFunc(slices.Clone(stats[i].Scans), func(scan RootStats) bool {
+					return slices.Contains(paths, scan.Root)
+				})
+			}
+			wg.Go(func() {
+				scanCtx := ignore.NewContext(indexCtx, filters[i])
+				var (
+					count, invalid int
+					found          map[string]int
+				)
+				count, invalid, capped[i], found = idx.scanSource(scanCtx, index, src.scanner, roots, opts)
+				stats[i].Scans = append(stats[i].Scans, rootStats(roots, found, filters[i])...)
+				stats[i].Count += count
+				stats[i].InvalidUTF8 = invalid
+				stats[i].Ignored = filters[i].Ignored()
+				stats[i].Pruned = filters[i].Pruned()
+			})
+		}
+		wg.Wait()
+		var shadowed map[string]int
+		index, shadowed = dropShadowed(index)
+		for i, src := range sources {
+			if mirror, ok := src.scanner.(Mirror); ok {
+				stats[i].Count -= shadowed[mirror.Origin()]
+			}
+		}
+		// Scanners stop adding at the cap on their own, the index of all of them is cut
+		// down in search order
+		var unrooted map[string]int
+		index, truncated, unrooted = capIndex(index, stats, opts.maxEntries)
+		for i, src := range sources {
+			if mirror, ok := src.scanner.(Mirror); ok {
+				stats[i].Count -= unrooted[mirror.Origin()]
+			}
+			truncated += capped[i]
+		}
+		if truncated > 0 {
+			largest := largestRoots(stats, offendingRoots)
+			for _, root := range largest {
+				truncatedRoots = append(truncatedRoots, root.Root)
+			}
+			warnCapped(opts.maxEntries, truncated, largest)
+		}
+		warnRoots(stats, filters)
+		warnSlowRoots(stats, slowRoot)
+		index = renumber(index, stats)
+		assignKeys(index, stats)
+		carryRevisions(index, current)
+	}
+
+	var snap snapshot
+	if indexCtx.Err() == nil {
+		snap = newSnapshot(0, index)
+	}
+	// The count is process wide, but runs never overlap
+	unreadable := int(fdlimit.Unreadable() - lost)
+	if unreadable > 0 {
+		log.Printf("[WARN] Too many open files: %d files and directories left out of the index, raise the open file limit (ulimit -n) and reindex", unreadable)
+	}
 
-// runIndexing performs the actual indexing work
-func (idx *Indexer) runIndexing(ctx context.Context, paths []string) error {
 	idx.mu.Lock()
-	// Cancel previous indexing if running
-	if idx.running && idx.indexCancel != nil {
-		idx.indexCancel()
-		idx.indexWg.Wait()
+	var (
+		swapped    bool
+		generation uint64
+		changes    int
+	)
+	if indexCtx.Err() == nil {
+		changes = countChanges(idx.index, index)
+		watched := watchedDirs(stats, filters)
+		if merge {
+			// The ignore files of the scanners left out were not read again
+			paths = mergeRoots(idx.lastPaths, paths)
+			watched = slices.Compact(slices.Sorted(slices.Values(append(watched, idx.watched...))))
+		}
+		idx.index = index
+		idx.stats = stats
+		idx.lastPaths = paths
+		idx.watched = watched
+		idx.unreadable = unreadable
+		idx.truncated, idx.truncatedRoots = truncated, truncatedRoots
+		idx.indexedAt = started
+		idx.generation++
+		generation = idx.generation
+		snap.generation = generation
+		idx.recordSnapshot(snap)
+		swapped = true
 	}
+	bus := idx.bus
+	watcher, watched := idx.ignoreWatcher, idx.watched
+	idx.mu.Unlock()
 
-	// Create new context for this indexing run
-	indexCtx, cancel := context.WithCancel(ctx)
-	idx.indexCtx = indexCtx
-	idx.indexCancel = cancel
-	idx.running = true
+	if swapped && watcher != nil {
+		watcher.sync(watched)
+	}
+	if swapped {
+		events.Publish(bus, events.IndexSwapped{Generation: generation, Changes: changes})
+	}
 
-	// Clear existing index
-	idx.index = NewIndex()
-	idx.mu.Unlock()
+	return indexCtx.Err()
+}
 
-	// Create channels for results
-	execChan := make(chan *executable.ExecutableInfo, 100)
-	desktopChan := make(chan *desktop.DesktopEntry, 100)
+// SetBus makes the indexer publish events.IndexSwapped on bus after every index swap, with
+// the new generation and the number of entries added or removed
+func (idx *Indexer) SetBus(bus *events.Bus) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.bus = bus
+}
 
-	idx.indexWg = sync.WaitGroup{}
+// largeRoot is the number of files walked under a root beyond which it's likely not meant
+// to be scanned, e.g. a projects directory
+var largeRoot = 100000
 
-	// Start executable scanning
-	idx.indexWg.Add(1)
-	go func() {
-		defer idx.indexWg.Done()
-		if err := executable.ScanPaths(paths, execChan); err != nil {
-			// Log error but continue
-			return
+// warnRoots logs roots that look added by mistake: the home directory itself, dragging in
+// everything users keep there, and roots holding more than largeRoot files
+func warnRoots(stats []SourceStats, filters []*ignore.Filter) {
+	home, _ := os.UserHomeDir()
+	warned := make(map[string]bool)
+	for i, source := range stats {
+		for _, root := range source.Roots {
+			root = filepath.Clean(root)
+			if warned[root] {
+				continue
+			}
+			switch seen := filters[i].SeenUnder(root); {
+			case home != "" && root == filepath.Clean(home):
+				log.Printf("[WARN] Scan root %s is the home directory: %d files walked, %d directories pruned; index the directories holding applications instead", root, seen, source.Pruned)
+			case seen > largeRoot:
+				log.Printf("[WARN] Scan root %s holds %d files, more than %d: make sure it's meant to be indexed", root, seen, largeRoot)
+			default:
+				continue
+			}
+			warned[root] = true
 		}
-	}()
+	}
+}
 
-	// Start desktop file scanning
-	idx.indexWg.Add(1)
-	go func() {
-		defer idx.indexWg.Done()
-		if err := desktop.ScanDesktopFiles(desktopChan); err != nil {
-			// Log error but continue
-			return
+// warnSlowRoots logs roots whose walk took longer than slow, like a stale network mount
+// holding up every run
+func warnSlowRoots(stats []SourceStats, slow time.Duration) {
+	if slow <= 0 {
+		return
+	}
+	for _, source := range stats {
+		for _, scan := range source.Scans {
+			if scan.Duration > slow {
+				log.Printf("[WARN] Scan root %s of %s took %v, more than %v: remove it from the rc file or exclude it if it's a slow or stale mount",
+					scan.Root, source.Name, scan.Duration.Round(time.Millisecond), slow)
+			}
 		}
-	}()
+	}
+}
 
-	// Process results
-	idx.indexWg.Add(1)
-	go func() {
-		defer idx.indexWg.Done()
-		idx.processResults(indexCtx, execChan, desktopChan)
-	}()
+// rootStats returns the stats of the walks of roots recorded by filter, along with the
+// entries found under every root
+func rootStats(roots []string, found map[string]int, filter *ignore.Filter) []RootStats {
+	var scans []RootStats
+	for _, root := range roots {
+		// A root listed twice is walked twice, both walks add up
+		if slices.ContainsFunc(scans, func(scan RootStats) bool { return scan.Root == root }) {
+			continue
+		}
+		scans = append(scans, RootStats{
+			Root:     root,
+			Duration: filter.WalkTime(root),
+			Files:    filter.SeenUnder(root),
+			Entries:  found[root],
+			Errors:   filter.FailedUnder(root),
+		})
+	}
+	return scans
+}
 
-	// Wait for all scanning to complete
-	idx.indexWg.Wait()
+// Unreadable returns the number of files and directories the last run couldn't read because
+// the process ran out of file descriptors, even after retrying
+func (idx *Indexer) Unreadable() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.unreadable
+}
+
+// Generation returns the number of times the index has been rebuilt
+func (idx *Indexer) Generation() uint64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.generation
+}
 
+// countChanges returns the number of paths present in only one of the indexes
+func countChanges(old, updated *Index) int {
+	paths := make(map[string]int)
+	for _, entry := range old.GetAll() {
+		paths[entry.Path]++
+	}
+	changes := 0
+	for _, entry := range updated.GetAll() {
+		if paths[entry.Path] > 0 {
+			paths[entry.Path]--
+		} else {
+			changes++
+		}
+	}
+	for _, left := range paths {
+		changes += left
+	}
+	return changes
+}
+
+// Split is the share of the default roots of the built-in scanners an indexer walks, when
+// the daemon runs split in a system instance for all users and an instance per user
+type Split int
+
+const (
+	SplitNone   Split = iota // all of them
+	SplitSystem              // those outside the home directory
+	SplitUser                // those in the home directory, the system instance indexes the rest
+)
+
+// SetSplit sets the share of the default roots of the built-in scanners walked from the
+// next run on. Roots configured with "scan" rc lines are walked in any case.
+func (idx *Indexer) SetSplit(split Split) {
 	idx.mu.Lock()
-	idx.running = false
-	idx.mu.Unlock()
+	defer idx.mu.Unlock()
+	idx.split = split
+}
 
-	return nil
-}
-
-func (idx *Indexer) processResults(ctx context.Context, execChan <-chan *executable.ExecutableInfo, desktopChan <-chan *desktop.DesktopEntry) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case exec, ok := <-execChan:
-			if !ok {
-				execChan = nil
-			} else {
-				entry := &Entry{
-					Name:      exec.Name,
-					Path:      exec.Path,
-					Exec:      exec.Path,
-					Terminal:  false,
-					IsDesktop: false,
-				}
-				idx.index.Add(entry)
-			}
-		case desk, ok := <-desktopChan:
-			if !ok {
-				desktopChan = nil
-			} else {
-				// Skip NoDisplay entries
-				if desktop.IsNoDisplay(desk.Path) {
+// sourceRoots returns the roots a scanner walks in a run over the search path paths
+func sourceRoots(src source, paths []string, split Split) []string {
+	roots := src.roots
+	if src.defaultRoots != nil {
+		roots = splitRoots(src.defaultRoots(paths), split)
+	}
+	return append(slices.Clip(roots), config.Get().ScanRoots(src.scanner.Name())...)
+}
+
+// splitRoots returns the share of roots walked with split
+func splitRoots(roots []string, split Split) []string {
+	home, err := os.UserHomeDir()
+	if split == SplitNone || err != nil {
+		return roots
+	}
+	home = filepath.Clean(home)
+	var kept []string
+	for _, root := range roots {
+		if underAny(filepath.Clean(root), []string{home}) == (split == SplitUser) {
+			kept = append(kept, root)
+		}
+	}
+	return kept
+}
+
+// scanOptions are the optional passes over the entries found by scanners
+type scanOptions struct {
+	inspectWrappers bool                // key entries running a trivial wrapper script on the binary it execs
+	transliterate   bool                // fill Entry.Translit
+	renames         []config.RenameRule // display names of executables found here
+	maxEntries      int                 // entries a scanner adds at most, 0 no limit
+	workers         int                 // goroutines preparing and adding entries, per scanner
+	selfTools       []FileID            // the daemon's binary and its companions, tagged SelfTool
+}
+
+// scanSource runs a scanner and adds what it finds to index, returning the number of
+// entries added and found under every root. Past opts.maxEntries entries are counted as
+// capped instead of added, so the first ones in the scanner's walk order are kept: entries
+// are counted here in the order the scanner sends them, then prepared and added by
+// opts.workers goroutines. After the context is cancelled results are still drained (and
+// dropped) so the scanner never blocks.
+func (idx *Indexer) scanSource(ctx context.Context, index *Index, scanner Scanner, roots []string, opts scanOptions) (count, invalid, capped int, found map[string]int) {
+	var origin string
+	if mirror, ok := scanner.(Mirror); ok {
+		origin = mirror.Origin()
+	}
+	workers := max(opts.workers, 1)
+	found = make(map[string]int)
+	out := make(chan *Entry, workers*perWorker)
+	go func() {
+		defer close(out)
+		if err := scanner.Scan(withWorkers(ctx, workers), roots, out); err != nil && ctx.Err() == nil {
+			log.Printf("[WARN] Scanner %s failed: %v", scanner.Name(), err)
+		}
+	}()
+
+	// The order entries are added in depends on the workers: IDs, keys, shadowing and the
+	// cap of the whole index are settled after the run, in search order
+	prepare := make(chan *Entry, workers*perWorker)
+	var (
+		wg       sync.WaitGroup
+		replaced atomic.Int64
+	)
+	for range workers {
+		wg.Go(func() {
+			for entry := range prepare {
+				if ctx.Err() != nil {
 					continue
 				}
-
-				entry := &Entry{
-					Name:       desk.Name,
-					Names:      desk.Names,
-					Path:       desk.Path,
-					Exec:       desk.Exec,
-					Terminal:   desk.Terminal,
-					Categories: desk.Categories,
-					IsDesktop:  true,
+				if prepareEntry(entry, origin, opts) {
+					replaced.Add(1)
 				}
-				idx.index.Add(entry)
+				index.Add(entry)
 			}
+		})
+	}
+
+	for entry := range out {
+		if ctx.Err() != nil {
+			continue
 		}
+		if root := rootOf(entry.Path, roots); root != "" {
+			found[root]++
+		}
+		if opts.maxEntries > 0 && count >= opts.maxEntries {
+			capped++
+			continue
+		}
+		prepare <- entry
+		count++
+	}
+	close(prepare)
+	wg.Wait()
+	return count, int(replaced.Load()), capped, found
+}
 
-		if execChan == nil && desktopChan == nil {
-			break
+// prepareEntry readies entry, found by a scanner of the given origin, to be added: invalid
+// UTF-8 in its names is replaced, reporting whether there was any. With opts.inspectWrappers
+// entries running a trivial wrapper script are keyed on the binary it execs, keeping their
+// own name. Entries launching the daemon itself or a companion of it are tagged SelfTool.
+// Executables found here are named by the first of opts.renames matching them, the
+// name they had is kept in RawName.
+func prepareEntry(entry *Entry, origin string, opts scanOptions) bool {
+	entry.Origin = origin
+	invalid := sanitizeEntry(entry)
+	if invalid {
+		log.Printf("[DEBUG] Replaced invalid UTF-8 in the names of %s", entry.Path)
+	}
+	// Mirrored entries were renamed by their own daemon
+	if !entry.IsDesktop && origin == "" {
+		if name, ok := config.Rename(opts.renames, entry.Name, entry.Path); ok && name != entry.Name {
+			entry.RawName, entry.Name = entry.Name, name
 		}
 	}
+	// The wrapper, not its target, is what gets launched
+	if entry.ResolvedExec != "" {
+		entry.ExecID, _ = StatFileID(entry.ResolvedExec)
+		entry.SelfTool = isSelfTool(opts.selfTools, entry.ExecID)
+	}
+	if opts.inspectWrappers && entry.ResolvedExec != "" {
+		if target := WrapperTarget(entry.ResolvedExec); target != "" {
+			entry.ResolvedExec = target
+		}
+	}
+	if opts.transliterate {
+		entry.Translit = transliterateNames(entry)
+	}
+	return invalid
+}
+
+// rootOf returns the innermost of roots path is under, "" if none
+func rootOf(path string, roots []string) string {
+	var innermost string
+	for _, root := range roots {
+		if len(root) > len(innermost) && underAny(path, []string{root}) {
+			innermost = root
+		}
+	}
+	return innermost
+}
+
+// transliterateNames returns the Latin spellings of the names of entry that are written in
+// other scripts, without repeats
+func transliterateNames(entry *Entry) []string {
+	var spelled []string
+	add := func(name string) {
+		if latin, ok := translit.Latin(name); ok && !slices.Contains(spelled, latin) {
+			spelled = append(spelled, latin)
+		}
+	}
+	add(entry.Name)
+	for _, locale := range slices.Sorted(maps.Keys(entry.Names)) {
+		add(entry.Names[locale])
+	}
+	return spelled
+}
+
+// Sources returns the scanners of the indexer with the roots they walked and the number of
+// entries they found in the last completed run. Scanners added since then have no roots yet.
+func (idx *Indexer) Sources() []SourceStats {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	result := make([]SourceStats, 0, len(idx.sources))
+	for _, src := range idx.sources {
+		result = append(result, lastStats(idx.stats, src.scanner.Name()))
+	}
+	return result
+}
+
+// lastStats returns the stats of the named scanner among stats, empty ones if it didn't run
+func lastStats(stats []SourceStats, name string) SourceStats {
+	for _, last := range stats {
+		if last.Name == name {
+			return last
+		}
+	}
+	return SourceStats{Name: name}
+}
+
+// mergeRoots returns roots followed by the added ones it doesn't hold yet. roots is left
+// untouched.
+func mergeRoots(roots, added []string) []string {
+	merged := slices.Clone(roots)
+	for _, root := range added {
+		if !slices.ContainsFunc(merged, func(r string) bool { return filepath.Clean(r) == filepath.Clean(root) }) {
+			merged = append(merged, root)
+		}
+	}
+	return merged
+}
+
+// keepOutside adds copies of the entries of current that aren't under roots to index, in ID
+// order, and returns the number of entries left out. Mirrored entries are all kept, no
+// directory scan finds them again.
+func keepOutside(index, current *Index, roots []string) int {
+	entries := current.GetAll()
+	slices.SortFunc(entries, func(a, b *Entry) int { return cmp.Compare(a.ID, b.ID) })
+	dropped := 0
+	for _, entry := range entries {
+		if entry.Origin == "" && underAny(entry.Path, roots) {
+			dropped++
+			continue
+		}
+		kept := *entry
+		index.Add(&kept)
+	}
+	return dropped
+}
+
+// dropShadowed returns index without the mirrored entries sharing their path or desktop file
+// ID with an entry found here, which stands for them: a user's desktop file overrides the
+// system one of the same ID. Returns index itself when nothing is dropped, along with the
+// number of entries dropped per origin.
+func dropShadowed(index *Index) (*Index, map[string]int) {
+	entries := index.GetAll()
+	paths := make(map[string]bool)
+	desktopIDs := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Origin != "" {
+			continue
+		}
+		paths[entry.Path] = true
+		if entry.DesktopID != "" {
+			desktopIDs[entry.DesktopID] = true
+		}
+	}
+	shadowed := func(entry *Entry) bool {
+		return entry.Origin != "" && (paths[entry.Path] || entry.DesktopID != "" && desktopIDs[entry.DesktopID])
+	}
+	if !slices.ContainsFunc(entries, shadowed) {
+		return index, nil
+	}
+
+	slices.SortFunc(entries, func(a, b *Entry) int { return cmp.Compare(a.ID, b.ID) })
+	kept := NewIndex()
+	dropped := make(map[string]int)
+	for _, entry := range entries {
+		if shadowed(entry) {
+			dropped[entry.Origin]++
+			continue
+		}
+		kept.Add(entry)
+	}
+	for origin, n := range dropped {
+		log.Printf("[DEBUG] Left out %d entries mirrored from %s, entries found here stand for them", n, origin)
+	}
+	return kept, dropped
+}
+
+// ResolveExec resolves a program name or path to the absolute path of the binary it runs,
+// looking bare names up in PATH and following symlinks. Returns "" if it can't be resolved.
+func ResolveExec(program string) string {
+	if program == "" {
+		return ""
+	}
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return ""
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return ""
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+	return resolved
 }
 
 // GetIndex returns the index instance
@@ -171,20 +646,33 @@ func (idx *Indexer) GetIndex() *Index {
 	return idx.index
 }
 
+// GetIndexGeneration returns the index instance with its generation, read together so
+// they match while a reindex swaps them
+func (idx *Indexer) GetIndexGeneration() (*Index, uint64) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.index, idx.generation
+}
+
 // IsRunning returns whether indexing is currently running
 func (idx *Indexer) IsRunning() bool {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
-	return idx.running
+	return idx.current != nil
 }
 
-// Stop stops the indexing process
+// Stop stops the indexing process: the queued follow-up is dropped and the current run
+// cancelled, waiting for it to finish. Callers waiting for them fail with context.Canceled.
 func (idx *Indexer) Stop() {
 	idx.mu.Lock()
-	defer idx.mu.Unlock()
-	if idx.running && idx.indexCancel != nil {
-		idx.indexCancel()
+	idx.dropPending(context.Canceled)
+	current := idx.current
+	if current != nil {
+		current.cancel()
+	}
+	idx.mu.Unlock()
+
+	if current != nil {
+		<-current.done
 	}
-	idx.running = false
-	idx.indexWg.Wait()
 }
@@ -2,11 +2,18 @@ package indexer
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+
+	"github.com/0xADE/ade-ctld/internal/events"
+	"github.com/0xADE/ade-ctld/internal/indexer/desktop"
 
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 )
 
 var _ = ginkgo.Describe("Reindex", func() {
@@ -103,3 +110,501 @@ var _ = ginkgo.Describe("Reindex", func() {
 	})
 })
 
+
+var _ = ginkgo.Describe("Resolved executables", func() {
+	var (
+		idx     *Indexer
+		tmpDir  string
+		binDir  string
+		oldHome string
+	)
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-ctld-test-*")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		tmpDir, err = filepath.EvalSymlinks(tmpDir)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		binDir = filepath.Join(tmpDir, "bin")
+		gomega.Expect(os.MkdirAll(binDir, 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(binDir, "real-tool"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.Symlink("real-tool", filepath.Join(binDir, "tool-link"))).To(gomega.Succeed())
+
+		// Desktop files are looked up under $HOME/.local/share/applications
+		oldHome = os.Getenv("HOME")
+		os.Setenv("HOME", tmpDir)
+		appsDir := filepath.Join(tmpDir, ".local", "share", "applications", "sub")
+		gomega.Expect(os.MkdirAll(appsDir, 0755)).To(gomega.Succeed())
+		desktopFile := "[Desktop Entry]\nName=Tool Viewer\nExec=\"" + filepath.Join(binDir, "tool-link") + "\" %f\n"
+		gomega.Expect(os.WriteFile(filepath.Join(appsDir, "viewer.desktop"), []byte(desktopFile), 0644)).To(gomega.Succeed())
+
+		idx = NewIndexer()
+		_, err = idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	})
+
+	ginkgo.AfterEach(func() {
+		os.Setenv("HOME", oldHome)
+		os.RemoveAll(tmpDir)
+	})
+
+	find := func(name string) *Entry {
+		for _, entry := range idx.GetIndex().GetAll() {
+			if entry.Name == name {
+				return entry
+			}
+		}
+		return nil
+	}
+
+	ginkgo.It("should resolve executables to themselves", func() {
+		entry := find("real-tool")
+		gomega.Expect(entry).NotTo(gomega.BeNil())
+		gomega.Expect(entry.ResolvedExec).To(gomega.Equal(filepath.Join(binDir, "real-tool")))
+		gomega.Expect(entry.DesktopID).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should resolve symlinked executables to their target", func() {
+		entry := find("tool-link")
+		gomega.Expect(entry).NotTo(gomega.BeNil())
+		gomega.Expect(entry.ResolvedExec).To(gomega.Equal(filepath.Join(binDir, "real-tool")))
+	})
+
+	ginkgo.It("should resolve the quoted Exec program of desktop entries", func() {
+		entry := find("Tool Viewer")
+		gomega.Expect(entry).NotTo(gomega.BeNil())
+		gomega.Expect(entry.ResolvedExec).To(gomega.Equal(filepath.Join(binDir, "real-tool")))
+	})
+
+	ginkgo.It("should record the desktop file id relative to the applications dir", func() {
+		entry := find("Tool Viewer")
+		gomega.Expect(entry).NotTo(gomega.BeNil())
+		gomega.Expect(entry.DesktopID).To(gomega.Equal("sub-viewer.desktop"))
+	})
+
+	ginkgo.It("should leave unresolvable programs empty", func() {
+		gomega.Expect(ResolveExec("surely-not-an-installed-program")).To(gomega.BeEmpty())
+		gomega.Expect(ResolveExec("")).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should extract the program from Exec values", func() {
+		gomega.Expect(desktop.ExecBinary("firefox %u")).To(gomega.Equal("firefox"))
+		gomega.Expect(desktop.ExecBinary(`"/opt/My App/app" --flag`)).To(gomega.Equal("/opt/My App/app"))
+		gomega.Expect(desktop.ExecBinary("  ")).To(gomega.BeEmpty())
+	})
+})
+
+var _ = ginkgo.Describe("Generations", func() {
+	var (
+		idx    *Indexer
+		tmpDir string
+	)
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-ctld-test-*")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(os.WriteFile(filepath.Join(tmpDir, "one"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		idx = NewIndexer()
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	ginkgo.It("should report every swap with the number of changed paths", func() {
+		type update struct {
+			generation uint64
+			changes    int
+		}
+		var updates []update
+		bus := events.NewBus()
+		defer bus.Close()
+		idx.SetBus(bus)
+		events.Subscribe(bus, func(ev events.IndexSwapped) {
+			updates = append(updates, update{ev.Generation, ev.Changes})
+		})
+		gomega.Expect(idx.Generation()).To(gomega.BeZero())
+
+		_, err := idx.Reindex(context.Background(), []string{tmpDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		// Desktop files of the machine are indexed too, so only the second run is exact
+		gomega.Expect(updates).To(gomega.HaveLen(1))
+		gomega.Expect(updates[0].generation).To(gomega.Equal(uint64(1)))
+		gomega.Expect(updates[0].changes).To(gomega.BeNumerically(">=", 1))
+
+		gomega.Expect(os.WriteFile(filepath.Join(tmpDir, "two"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.Remove(filepath.Join(tmpDir, "one"))).To(gomega.Succeed())
+		_, err = idx.Reindex(context.Background(), []string{tmpDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(updates).To(gomega.HaveLen(2))
+		gomega.Expect(updates[1]).To(gomega.Equal(update{generation: 2, changes: 2}))
+		gomega.Expect(idx.Generation()).To(gomega.Equal(uint64(2)))
+	})
+})
+
+// staticScanner sends an entry named after every root it's given
+type staticScanner struct {
+	name string
+}
+
+func (s staticScanner) Name() string { return s.name }
+
+func (s staticScanner) Scan(ctx context.Context, roots []string, out chan<- *Entry) error {
+	for _, root := range roots {
+		out <- &Entry{Name: filepath.Base(root), Path: root, Exec: root}
+	}
+	return nil
+}
+
+var _ = ginkgo.Describe("Scanners", func() {
+	var (
+		idx    *Indexer
+		tmpDir string
+	)
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-ctld-scanner-test-*")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		idx = NewIndexer()
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	ginkgo.It("should include the built-in scanners", func() {
+		var names []string
+		for _, source := range idx.Sources() {
+			names = append(names, source.Name)
+		}
+		gomega.Expect(names).To(gomega.Equal([]string{ExecutableScanner, DesktopScanner, AppImageScanner}))
+	})
+
+	ginkgo.It("should index entries of added scanners and count them per scanner", func() {
+		idx.AddScanner(staticScanner{name: "webapps"}, "/srv/webapps/mail", "/srv/webapps/chat")
+
+		binDir := filepath.Join(tmpDir, "bin")
+		gomega.Expect(os.MkdirAll(binDir, 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(binDir, "tool"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+
+		_, err := idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		names := make(map[string]bool)
+		for _, entry := range idx.GetIndex().GetAll() {
+			names[entry.Name] = true
+		}
+		gomega.Expect(names).To(gomega.HaveKey("mail"))
+		gomega.Expect(names).To(gomega.HaveKey("chat"))
+		gomega.Expect(names).To(gomega.HaveKey("tool"))
+
+		sources := idx.Sources()
+		gomega.Expect(sources).To(gomega.HaveLen(4))
+		gomega.Expect(sources[0].Name).To(gomega.Equal(ExecutableScanner))
+		gomega.Expect(sources[0].Roots).To(gomega.HaveExactElements(binDir))
+		gomega.Expect(sources[0].Count).To(gomega.Equal(1))
+		gomega.Expect(sources[3]).To(gomega.Equal(SourceStats{
+			Name:  "webapps",
+			Roots: []string{"/srv/webapps/mail", "/srv/webapps/chat"},
+			Count: 2,
+			// Walking nothing, it has no time and files to report
+			Scans: []RootStats{{Root: "/srv/webapps/mail", Entries: 1}, {Root: "/srv/webapps/chat", Entries: 1}},
+		}))
+	})
+
+	ginkgo.It("should index AppImages given to the appimage scanner", func() {
+		idx.AddScanner(rootedScanner{Scanner: appImageScanner{}, name: "apps"}, tmpDir)
+		app := filepath.Join(tmpDir, "Krita-5.2.2-x86_64.AppImage")
+		gomega.Expect(os.WriteFile(app, []byte("\x7fELF"), 0755)).To(gomega.Succeed())
+
+		_, err := idx.Reindex(context.Background(), []string{filepath.Join(tmpDir, "empty")})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		var found []*Entry
+		for _, entry := range idx.GetIndex().GetAll() {
+			if entry.Path == app {
+				found = append(found, entry)
+			}
+		}
+		gomega.Expect(found).To(gomega.HaveLen(1))
+		gomega.Expect(found[0].Name).To(gomega.Equal("Krita"))
+		gomega.Expect(found[0].Exec).To(gomega.Equal(app))
+		gomega.Expect(found[0].IsDesktop).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should refuse a second scanner with a taken name", func() {
+		gomega.Expect(func() { idx.AddScanner(staticScanner{name: DesktopScanner}) }).To(gomega.Panic())
+	})
+
+	ginkgo.It("should run only the scanners added after removing them", func() {
+		idx.RemoveScanners()
+		idx.AddScanner(staticScanner{name: DesktopScanner}, "/srv/webapps/mail")
+
+		binDir := filepath.Join(tmpDir, "bin")
+		gomega.Expect(os.MkdirAll(binDir, 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(binDir, "tool"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+
+		_, err := idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		var paths []string
+		for _, entry := range idx.GetIndex().GetAll() {
+			paths = append(paths, entry.Path)
+		}
+		gomega.Expect(paths).To(gomega.Equal([]string{"/srv/webapps/mail"}))
+	})
+})
+
+// rootedScanner renames a scanner, so a built-in one can be added again with test roots
+type rootedScanner struct {
+	Scanner
+	name string
+}
+
+func (s rootedScanner) Name() string { return s.name }
+
+// namedScanner sends an entry for every path of names, under its current name
+type namedScanner struct {
+	names map[string]string
+}
+
+func (s namedScanner) Name() string { return "named" }
+
+func (s namedScanner) Scan(ctx context.Context, roots []string, out chan<- *Entry) error {
+	for path, name := range s.names {
+		out <- &Entry{Name: name, Path: path, Exec: path}
+	}
+	return nil
+}
+
+var _ = ginkgo.Describe("History", func() {
+	var (
+		idx     *Indexer
+		names   map[string]string
+		emptyPath []string
+	)
+
+	reindex := func() {
+		_, err := idx.Reindex(context.Background(), emptyPath)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	ginkgo.BeforeEach(func() {
+		emptyPath = []string{filepath.Join(ginkgo.GinkgoT().TempDir(), "empty")}
+		names = map[string]string{"/srv/apps/mail": "Mail", "/srv/apps/chat": "Chat"}
+		idx = NewIndexer()
+		idx.AddScanner(namedScanner{names: names})
+	})
+
+	ginkgo.It("should list added, removed and renamed paths between generations", func() {
+		reindex()
+		names["/srv/apps/notes"] = "Notes"
+		delete(names, "/srv/apps/chat")
+		names["/srv/apps/mail"] = "Webmail"
+		reindex()
+
+		diff, err := idx.Diff(1, 2)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(diff.Added).To(gomega.Equal([]Change{{Path: "/srv/apps/notes", Name: "Notes"}}))
+		gomega.Expect(diff.Removed).To(gomega.Equal([]Change{{Path: "/srv/apps/chat", Name: "Chat"}}))
+		gomega.Expect(diff.Renamed).To(gomega.Equal([]Change{{Path: "/srv/apps/mail", Name: "Webmail", OldName: "Mail"}}))
+
+		diff, err = idx.Diff(2, 1)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(diff.Added).To(gomega.Equal([]Change{{Path: "/srv/apps/chat", Name: "Chat"}}))
+		gomega.Expect(diff.Renamed).To(gomega.Equal([]Change{{Path: "/srv/apps/mail", Name: "Mail", OldName: "Webmail"}}))
+	})
+
+	ginkgo.It("should diff reindexes of a subset of the search path", func() {
+		dirA := ginkgo.GinkgoT().TempDir()
+		dirB := ginkgo.GinkgoT().TempDir()
+		gomega.Expect(os.WriteFile(filepath.Join(dirA, "alpha"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(dirB, "beta"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+
+		_, err := idx.Reindex(context.Background(), []string{dirA, dirB})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		_, err = idx.Reindex(context.Background(), []string{dirB})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		diff, err := idx.Diff(1, 2)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(diff.Added).To(gomega.BeEmpty())
+		gomega.Expect(diff.Renamed).To(gomega.BeEmpty())
+		gomega.Expect(diff.Removed).To(gomega.Equal([]Change{{Path: filepath.Join(dirA, "alpha"), Name: "alpha"}}))
+	})
+
+	ginkgo.It("should keep a bounded number of generations", func() {
+		idx.historyGenerations = 3
+		for range 5 {
+			reindex()
+		}
+
+		oldest, newest := idx.HistoryRange()
+		gomega.Expect(oldest).To(gomega.Equal(uint64(3)))
+		gomega.Expect(newest).To(gomega.Equal(uint64(5)))
+		_, err := idx.Diff(2, 5)
+		gomega.Expect(err).To(gomega.MatchError(ErrGenerationUnknown))
+		_, err = idx.Diff(3, 5)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should drop old generations beyond the key budget but keep the latest", func() {
+		reindex()
+		perGeneration := idx.GetIndex().Count()
+		idx.historyMaxKeys = 2 * perGeneration
+		reindex()
+		reindex()
+
+		oldest, newest := idx.HistoryRange()
+		gomega.Expect(oldest).To(gomega.Equal(uint64(2)))
+		gomega.Expect(newest).To(gomega.Equal(uint64(3)))
+
+		idx.historyMaxKeys = 1
+		reindex()
+		oldest, newest = idx.HistoryRange()
+		gomega.Expect(oldest).To(gomega.Equal(uint64(4)))
+		gomega.Expect(newest).To(gomega.Equal(uint64(4)))
+	})
+
+	ginkgo.It("should start with the empty generation 0", func() {
+		reindex()
+		diff, err := idx.Diff(0, 1)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(diff.Removed).To(gomega.BeEmpty())
+		gomega.Expect(diff.Added).To(gomega.ContainElement(Change{Path: "/srv/apps/mail", Name: "Mail"}))
+	})
+})
+
+var _ = ginkgo.Describe("Pruned directories", func() {
+	var (
+		home    string
+		logs    *gbytes.Buffer
+		oldHome string
+	)
+
+	write := func(rel, content string, perm os.FileMode) {
+		path := filepath.Join(home, rel)
+		gomega.Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(path, []byte(content), perm)).To(gomega.Succeed())
+	}
+
+	names := func(idx *Indexer) []string {
+		var result []string
+		for _, entry := range idx.GetIndex().GetAll() {
+			result = append(result, entry.Name)
+		}
+		return result
+	}
+
+	ginkgo.BeforeEach(func() {
+		home = ginkgo.GinkgoT().TempDir()
+		oldHome = os.Getenv("HOME")
+		os.Setenv("HOME", home)
+
+		desktopFile := "[Desktop Entry]\nType=Application\nName=%s\nExec=true\n"
+		for _, dir := range []string{".git/hooks", "web/node_modules/.bin", ".cache/tool", ".local/share/Trash/files", "src/__pycache__", "bin"} {
+			write(filepath.Join(dir, "tool-"+filepath.Base(dir)), "#!/bin/sh\n", 0755)
+			write(filepath.Join(dir, filepath.Base(dir)+".desktop"), fmt.Sprintf(desktopFile, "App "+filepath.Base(dir)), 0644)
+		}
+
+		logs = gbytes.NewBuffer()
+		log.SetOutput(logs)
+	})
+
+	ginkgo.AfterEach(func() {
+		log.SetOutput(os.Stderr)
+		os.Setenv("HOME", oldHome)
+	})
+
+	ginkgo.It("should prune trash, cache and VCS directories of a home root in both scanners", func() {
+		idx := NewIndexer()
+		idx.AddScanner(rootedScanner{Scanner: desktopScanner{}, name: "apps"}, home)
+		_, err := idx.Reindex(context.Background(), []string{home})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Expect(names(idx)).To(gomega.ContainElements("tool-bin", "App bin"))
+		gomega.Expect(names(idx)).NotTo(gomega.ContainElements("tool-hooks", "tool-.bin", "tool-tool", "tool-files", "tool-__pycache__"))
+		gomega.Expect(names(idx)).NotTo(gomega.ContainElements("App hooks", "App .bin", "App tool", "App files", "App __pycache__"))
+		for _, source := range idx.Sources() {
+			switch source.Name {
+			case ExecutableScanner, "apps":
+				// .git, node_modules, .cache, .local/share/Trash and __pycache__
+				gomega.Expect(source.Pruned).To(gomega.Equal(5))
+			}
+		}
+		gomega.Expect(string(logs.Contents())).To(gomega.MatchRegexp(`\[WARN\] Scan root ` + regexp.QuoteMeta(home) + ` is the home directory`))
+	})
+
+	ginkgo.It("should leave out autostart desktop files", func() {
+		write(".config/autostart/agent.desktop", "[Desktop Entry]\nType=Application\nName=Agent\nExec=agent\n", 0644)
+		write("apps/tray.desktop", "[Desktop Entry]\nType=Application\nName=Tray\nExec=tray\nX-GNOME-Autostart-enabled=true\n", 0644)
+		write("apps/editor.desktop", "[Desktop Entry]\nType=Application\nName=Editor\nExec=editor\n", 0644)
+
+		idx := NewIndexer()
+		idx.AddScanner(rootedScanner{Scanner: desktopScanner{}, name: "apps"}, filepath.Join(home, ".config"), filepath.Join(home, "apps"))
+		_, err := idx.Reindex(context.Background(), []string{filepath.Join(home, "bin")})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Expect(names(idx)).To(gomega.ContainElement("Editor"))
+		gomega.Expect(names(idx)).NotTo(gomega.ContainElements("Agent", "Tray"))
+	})
+
+	ginkgo.It("should warn about roots holding too many files", func() {
+		defer func(orig int) { largeRoot = orig }(largeRoot)
+		largeRoot = 1
+
+		idx := NewIndexer()
+		root := filepath.Join(home, "bin")
+		_, err := idx.Reindex(context.Background(), []string{root})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(string(logs.Contents())).NotTo(gomega.ContainSubstring("is the home directory"))
+		gomega.Expect(string(logs.Contents())).To(gomega.MatchRegexp(`\[WARN\] Scan root ` + regexp.QuoteMeta(root) + ` holds \d+ files, more than 1`))
+	})
+})
+
+var _ = ginkgo.Describe("sanitizeEntry", func() {
+	ginkgo.It("should replace invalid UTF-8 in names but keep paths", func() {
+		entry := &Entry{
+			Name:         "caf\xe9",
+			Names:        map[string]string{"de\xff": "Kaff\xc3"},
+			GenericName:  "Browser",
+			GenericNames: map[string]string{"fr": "Navigateur"},
+			Categories:   []string{"Network", "We\xedb"},
+			Keywords:     []string{"\xed\xa0\x80web"},
+			Path:         "/opt/caf\xe9/run",
+			Exec:         "/opt/caf\xe9/run",
+		}
+		gomega.Expect(sanitizeEntry(entry)).To(gomega.BeTrue())
+		gomega.Expect(entry.Name).To(gomega.Equal("caf\ufffd"))
+		gomega.Expect(entry.Names).To(gomega.Equal(map[string]string{"de\ufffd": "Kaff\ufffd"}))
+		gomega.Expect(entry.Categories).To(gomega.Equal([]string{"Network", "We\ufffdb"}))
+		gomega.Expect(entry.Keywords).To(gomega.Equal([]string{"\ufffdweb"}))
+		gomega.Expect(entry.Path).To(gomega.Equal("/opt/caf\xe9/run"))
+		gomega.Expect(entry.Exec).To(gomega.Equal("/opt/caf\xe9/run"))
+
+		gomega.Expect(sanitizeEntry(entry)).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should count the entries replaced per scanner", func() {
+		idx := NewIndexer()
+		idx.AddScanner(namedScanner{names: map[string]string{"/srv/apps/a": "ok", "/srv/apps/b": "bad\xc0\xaf", "/srv/apps/c": "\xf0\x9f"}})
+		_, err := idx.Reindex(context.Background(), []string{filepath.Join(ginkgo.GinkgoT().TempDir(), "empty")})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		for _, source := range idx.Sources() {
+			if source.Name == "named" {
+				gomega.Expect(source.InvalidUTF8).To(gomega.Equal(2))
+			} else {
+				gomega.Expect(source.InvalidUTF8).To(gomega.BeZero())
+			}
+		}
+		var names []string
+		for _, entry := range idx.GetIndex().GetAll() {
+			names = append(names, entry.Name)
+		}
+		gomega.Expect(names).To(gomega.ContainElements("ok", "bad\ufffd", "\ufffd"))
+	})
+})
@@ -0,0 +1,92 @@
+package indexer
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// baseKey is the key an entry gets unless another one claims it first: the desktop file id of
+// desktop entries, the file name of everything else
+func baseKey(entry *Entry) string {
+	if entry.DesktopID != "" {
+		return entry.DesktopID
+	}
+	return filepath.Base(entry.Path)
+}
+
+// assignKeys gives every entry of index its key. Keys don't depend on the order scanners
+// send entries in: entries sharing a base key are ranked by the root they were found under,
+// in the order the scanners and their roots are searched (so the executable found first in
+// PATH wins), then by path. The first keeps the base key, the others get "<base>@<dir>" with
+// the directory they're in. A file reported twice by different scanners gets a "#<n>" suffix
+// the second time.
+func assignKeys(index *Index, stats []SourceStats) {
+	entries := index.GetAll()
+	sortBySearchOrder(entries, stats)
+
+	keys := make(map[string]int64, len(entries))
+	for _, entry := range entries {
+		key := baseKey(entry)
+		if _, taken := keys[key]; taken {
+			key += "@" + filepath.Dir(entry.Path)
+		}
+		for n := 2; ; n++ {
+			if _, taken := keys[key]; !taken {
+				break
+			}
+			key = fmt.Sprintf("%s@%s#%d", baseKey(entry), filepath.Dir(entry.Path), n)
+		}
+		entry.Key = key
+		keys[key] = entry.ID
+	}
+	index.setKeys(keys)
+}
+
+// renumber returns the entries of index in a new index, added in search order (see
+// sortBySearchOrder), so their IDs don't depend on the order scanners and their workers
+// were done in
+func renumber(index *Index, stats []SourceStats) *Index {
+	entries := index.GetAll()
+	sortBySearchOrder(entries, stats)
+	renumbered := NewIndex()
+	for _, entry := range entries {
+		renumbered.Add(entry)
+	}
+	return renumbered
+}
+
+// sortBySearchOrder sorts entries by the root they were found under, in the order the
+// scanners of stats and their roots are searched, then by path. Entries under none of the
+// roots come last. Returns the rank of every entry: the position of its root among all
+// roots of stats, their number if none.
+func sortBySearchOrder(entries []*Entry, stats []SourceStats) map[*Entry]int {
+	var roots []string
+	for _, source := range stats {
+		roots = append(roots, source.Roots...)
+	}
+	rank := func(entry *Entry) int {
+		for i, root := range roots {
+			if entry.Path == root || strings.HasPrefix(entry.Path, strings.TrimSuffix(root, "/")+"/") {
+				return i
+			}
+		}
+		return len(roots)
+	}
+
+	ranks := make(map[*Entry]int, len(entries))
+	for _, entry := range entries {
+		ranks[entry] = rank(entry)
+	}
+	slices.SortFunc(entries, func(a, b *Entry) int {
+		if ranks[a] != ranks[b] {
+			return ranks[a] - ranks[b]
+		}
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return int(a.ID - b.ID)
+	})
+	return ranks
+}
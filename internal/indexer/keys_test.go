@@ -0,0 +1,79 @@
+package indexer
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Entry keys", func() {
+	stats := []SourceStats{
+		{Name: ExecutableScanner, Roots: []string{"/usr/local/bin", "/usr/bin"}},
+		{Name: DesktopScanner, Roots: []string{"/home/u/.local/share/applications", "/usr/share/applications"}},
+	}
+
+	keys := func(entries ...*Entry) map[string]string {
+		index := NewIndex()
+		for _, entry := range entries {
+			index.Add(entry)
+		}
+		assignKeys(index, stats)
+		result := make(map[string]string)
+		for _, entry := range index.GetAll() {
+			result[entry.Path] = entry.Key
+			found, ok := index.GetByKey(entry.Key)
+			gomega.Expect(ok).To(gomega.BeTrue())
+			gomega.Expect(found).To(gomega.BeIdenticalTo(entry))
+		}
+		return result
+	}
+
+	ginkgo.It("should key desktop entries by file id and executables by name", func() {
+		gomega.Expect(keys(
+			&Entry{Path: "/usr/share/applications/kde4/konsole.desktop", DesktopID: "kde4-konsole.desktop", IsDesktop: true},
+			&Entry{Path: "/usr/bin/vim"},
+		)).To(gomega.Equal(map[string]string{
+			"/usr/share/applications/kde4/konsole.desktop": "kde4-konsole.desktop",
+			"/usr/bin/vim": "vim",
+		}))
+	})
+
+	ginkgo.It("should give the bare key to the entry found first in search order", func() {
+		// Added in the opposite order, as concurrent scanners may
+		gomega.Expect(keys(
+			&Entry{Path: "/usr/bin/vim"},
+			&Entry{Path: "/usr/local/bin/vim"},
+			&Entry{Path: "/usr/share/applications/firefox.desktop", DesktopID: "firefox.desktop"},
+			&Entry{Path: "/home/u/.local/share/applications/firefox.desktop", DesktopID: "firefox.desktop"},
+		)).To(gomega.Equal(map[string]string{
+			"/usr/local/bin/vim": "vim",
+			"/usr/bin/vim":       "vim@/usr/bin",
+			"/home/u/.local/share/applications/firefox.desktop": "firefox.desktop",
+			"/usr/share/applications/firefox.desktop":           "firefox.desktop@/usr/share/applications",
+		}))
+	})
+
+	ginkgo.It("should rank entries outside the roots last, by path", func() {
+		gomega.Expect(keys(
+			&Entry{Path: "/opt/b/tool"},
+			&Entry{Path: "/opt/a/tool"},
+			&Entry{Path: "/usr/bin/tool"},
+		)).To(gomega.Equal(map[string]string{
+			"/usr/bin/tool": "tool",
+			"/opt/a/tool":   "tool@/opt/a",
+			"/opt/b/tool":   "tool@/opt/b",
+		}))
+	})
+
+	ginkgo.It("should keep keys unique for a file found twice", func() {
+		index := NewIndex()
+		for range 3 {
+			index.Add(&Entry{Path: "/usr/bin/tool"})
+		}
+		assignKeys(index, stats)
+		var result []string
+		for _, entry := range index.GetAll() {
+			result = append(result, entry.Key)
+		}
+		gomega.Expect(result).To(gomega.ConsistOf("tool", "tool@/usr/bin", "tool@/usr/bin#2"))
+	})
+})
@@ -0,0 +1,85 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("ReindexMerge", func() {
+	var (
+		pathDir, rcDir, appsDir string
+		idx                     *Indexer
+	)
+
+	names := func() []string {
+		var names []string
+		for _, entry := range idx.GetIndex().GetAll() {
+			names = append(names, entry.Name)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	ginkgo.BeforeEach(func() {
+		tmpDir := ginkgo.GinkgoT().TempDir()
+		pathDir = filepath.Join(tmpDir, "path")
+		rcDir = filepath.Join(tmpDir, "rc")
+		appsDir = filepath.Join(tmpDir, "apps")
+		for _, file := range []string{filepath.Join(pathDir, "pathtool"), filepath.Join(rcDir, "rctool")} {
+			gomega.Expect(os.MkdirAll(filepath.Dir(file), 0755)).To(gomega.Succeed())
+			gomega.Expect(os.WriteFile(file, []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		}
+
+		idx = NewIndexer()
+		idx.sources = []source{
+			{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }},
+			{scanner: staticScanner{name: "static"}, roots: []string{appsDir}},
+		}
+		_, err := idx.Reindex(context.Background(), []string{pathDir, rcDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(names()).To(gomega.Equal([]string{"apps", "pathtool", "rctool"}))
+	})
+
+	ginkgo.It("should keep the PATH entries and rescan only the given directories", func() {
+		// Neither change is noticed unless PATH is walked again
+		gomega.Expect(os.Remove(filepath.Join(pathDir, "pathtool"))).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(pathDir, "unseen"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.Remove(filepath.Join(rcDir, "rctool"))).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(rcDir, "newtool"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+
+		count, err := idx.ReindexMerge(context.Background(), []string{rcDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(count).To(gomega.Equal(3))
+		gomega.Expect(names()).To(gomega.Equal([]string{"apps", "newtool", "pathtool"}))
+		gomega.Expect(idx.Generation()).To(gomega.Equal(uint64(2)))
+
+		entry, ok := idx.GetIndex().GetByKey("pathtool")
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(entry.Path).To(gomega.Equal(filepath.Join(pathDir, "pathtool")))
+		_, ok = idx.GetIndex().GetByKey("newtool")
+		gomega.Expect(ok).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should keep the roots and counts of the last run", func() {
+		_, err := idx.ReindexMerge(context.Background(), []string{rcDir, filepath.Join(rcDir, "..", "extra")})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		sources := idx.Sources()
+		gomega.Expect(sources[0].Roots).To(gomega.Equal([]string{pathDir, rcDir, filepath.Join(filepath.Dir(rcDir), "extra")}))
+		gomega.Expect(sources[0].Count).To(gomega.Equal(2))
+		gomega.Expect(sources[1].Roots).To(gomega.Equal([]string{appsDir}))
+		gomega.Expect(sources[1].Count).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("should leave the index alone without directories", func() {
+		count, err := idx.ReindexMerge(context.Background(), nil)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(count).To(gomega.Equal(3))
+		gomega.Expect(idx.Generation()).To(gomega.Equal(uint64(1)))
+	})
+})
@@ -0,0 +1,135 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+// mirrorScanner sends copies of the entries of another daemon
+type mirrorScanner struct {
+	entries []Entry
+}
+
+func (mirrorScanner) Name() string   { return "mirror" }
+func (mirrorScanner) Origin() string { return "upstream" }
+
+func (s mirrorScanner) Scan(ctx context.Context, roots []string, out chan<- *Entry) error {
+	for _, entry := range s.entries {
+		out <- &entry
+	}
+	return nil
+}
+
+var _ = ginkgo.Describe("Mirrors", func() {
+	var (
+		idx    *Indexer
+		binDir string
+	)
+
+	byPath := func() map[string]*Entry {
+		entries := make(map[string]*Entry)
+		for _, entry := range idx.GetIndex().GetAll() {
+			entries[entry.Path] = entry
+		}
+		return entries
+	}
+
+	ginkgo.BeforeEach(func() {
+		binDir = ginkgo.GinkgoT().TempDir()
+		for _, name := range []string{"tool", "local"} {
+			gomega.Expect(os.WriteFile(filepath.Join(binDir, name), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		}
+
+		idx = NewIndexer()
+		idx.sources = []source{
+			{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }},
+			{scanner: staticScanner{name: "apps"}, roots: []string{"/home/user/.local/share/applications/firefox.desktop"}},
+			{scanner: mirrorScanner{entries: []Entry{
+				{Name: "tool", Path: filepath.Join(binDir, "tool"), Exec: filepath.Join(binDir, "tool")},
+				{Name: "Firefox", Path: "/usr/share/applications/firefox.desktop", Exec: "firefox", IsDesktop: true, DesktopID: "firefox.desktop"},
+				{Name: "Terminal", Path: "/usr/share/applications/terminal.desktop", Exec: "terminal", IsDesktop: true, DesktopID: "terminal.desktop"},
+				{Name: "local", Path: "/opt/upstream/bin/local", Exec: "/opt/upstream/bin/local"},
+			}}},
+		}
+	})
+
+	// The local desktop file is only known by path to staticScanner: give it the ID
+	withDesktopID := func() {
+		idx.sources[1].scanner = desktopIDScanner{staticScanner{name: "apps"}}
+	}
+
+	ginkgo.It("should tag mirrored entries and leave out those found here", func() {
+		withDesktopID()
+		_, err := idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		entries := byPath()
+		gomega.Expect(entries).To(gomega.HaveLen(5))
+		gomega.Expect(entries[filepath.Join(binDir, "tool")].Origin).To(gomega.BeEmpty())
+		gomega.Expect(entries["/home/user/.local/share/applications/firefox.desktop"].Origin).To(gomega.BeEmpty())
+		gomega.Expect(entries).NotTo(gomega.HaveKey("/usr/share/applications/firefox.desktop"))
+		gomega.Expect(entries["/usr/share/applications/terminal.desktop"].Origin).To(gomega.Equal("upstream"))
+
+		// Local entries keep the base keys
+		gomega.Expect(entries[filepath.Join(binDir, "local")].Key).To(gomega.Equal("local"))
+		gomega.Expect(entries["/opt/upstream/bin/local"].Key).To(gomega.Equal("local@/opt/upstream/bin"))
+
+		// Dense ids after the mirrored entries left out
+		for id := int64(1); id <= 5; id++ {
+			_, ok := idx.GetIndex().Get(id)
+			gomega.Expect(ok).To(gomega.BeTrue())
+		}
+		gomega.Expect(idx.Sources()[2].Count).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("should keep mirrored entries when merging a rescan", func() {
+		_, err := idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(os.Remove(filepath.Join(binDir, "tool"))).To(gomega.Succeed())
+
+		_, err = idx.ReindexMerge(context.Background(), []string{binDir, "/opt/upstream/bin"})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		entries := byPath()
+		gomega.Expect(entries).To(gomega.HaveKey("/opt/upstream/bin/local"))
+		// Once the local file is gone the mirrored one is kept, the next full run brings it back
+		gomega.Expect(entries).NotTo(gomega.HaveKey(filepath.Join(binDir, "tool")))
+		gomega.Expect(idx.GetIndex().Count()).To(gomega.Equal(5))
+	})
+})
+
+// desktopIDScanner gives the entries of a scanner the desktop file ID of their path
+type desktopIDScanner struct {
+	staticScanner
+}
+
+func (s desktopIDScanner) Scan(ctx context.Context, roots []string, out chan<- *Entry) error {
+	entries := make(chan *Entry, len(roots))
+	s.staticScanner.Scan(ctx, roots, entries)
+	close(entries)
+	for entry := range entries {
+		entry.IsDesktop = true
+		entry.DesktopID = filepath.Base(entry.Path)
+		out <- entry
+	}
+	return nil
+}
+
+var _ = ginkgo.DescribeTable("splitRoots",
+	func(split Split, expected []string) {
+		home, err := os.UserHomeDir()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		roots := []string{"/usr/bin", filepath.Join(home, "bin"), "/usr/share/applications", filepath.Join(home, ".local/share/applications")}
+		for i, root := range expected {
+			expected[i] = os.Expand(root, func(string) string { return home })
+		}
+		gomega.Expect(splitRoots(roots, split)).To(gomega.Equal(expected))
+	},
+	ginkgo.Entry("everything", SplitNone, []string{"/usr/bin", "$HOME/bin", "/usr/share/applications", "$HOME/.local/share/applications"}),
+	ginkgo.Entry("the system share", SplitSystem, []string{"/usr/bin", "/usr/share/applications"}),
+	ginkgo.Entry("the user share", SplitUser, []string{"$HOME/bin", "$HOME/.local/share/applications"}),
+)
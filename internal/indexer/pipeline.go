@@ -0,0 +1,76 @@
+package indexer
+
+import (
+	"context"
+	"sync"
+)
+
+// perWorker is the number of entries buffered per worker between the stages of a run: the
+// walk of a scanner, its per-entry work, and that of scanSource. Enough to keep the workers
+// busy while the walk stalls on a slow directory, and the walk going while they stall on a
+// slow file.
+const perWorker = 64
+
+type workersKey struct{}
+
+// withWorkers returns ctx telling the built-in scanners to do their per-entry work with n
+// workers
+func withWorkers(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, workersKey{}, n)
+}
+
+// workersOf returns the number of workers ctx asks for, at least 1
+func workersOf(ctx context.Context) int {
+	n, _ := ctx.Value(workersKey{}).(int)
+	return max(n, 1)
+}
+
+// convert makes entries of what a walk sends to in with workers goroutines, sending those
+// fn doesn't return nil for to out in the order they were found, whichever worker is done
+// first, so scanSource caps them in walk order. What's found after ctx is done is drained,
+// so the walk never blocks. Returns once in is closed.
+func convert[T any](ctx context.Context, workers int, in <-chan T, out chan<- *Entry, fn func(T) *Entry) {
+	type job struct {
+		found  T
+		result chan *Entry
+	}
+	jobs := make(chan job, workers*perWorker)
+	// Results in walk order, waited for one by one
+	pending := make(chan chan *Entry, workers*perWorker)
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		for found := range in {
+			if ctx.Err() != nil {
+				continue
+			}
+			j := job{found: found, result: make(chan *Entry, 1)}
+			jobs <- j
+			pending <- j.result
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for j := range jobs {
+				j.result <- fn(j.found)
+			}
+		})
+	}
+	for result := range pending {
+		if entry := <-result; entry != nil {
+			out <- entry
+		}
+	}
+	wg.Wait()
+}
+
+// SetWorkers sets the number of workers every scanner of the next runs does its per-entry
+// work with, like reading desktop files and resolving binaries, 1 or less for none besides
+// the walk. It defaults to ADE_INDEXD_WORKERS.
+func (idx *Indexer) SetWorkers(n int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.workers = max(n, 1)
+}
@@ -0,0 +1,76 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Compare the wall time of a reindex with one worker and with more:
+//
+//	go test -run '^$' -bench Reindex -benchtime 10x ./internal/indexer
+
+// benchTreeFiles is the number of files of the generated tree, half executables, half
+// desktop files
+const benchTreeFiles = 20000
+
+// benchTree writes benchTreeFiles files under dir, in 10 bin and 10 applications
+// directories, and returns the roots of both
+func benchTree(b *testing.B, dir string) (bins, apps []string) {
+	b.Helper()
+	for d := range 10 {
+		bin := filepath.Join(dir, fmt.Sprintf("bin%d", d))
+		app := filepath.Join(dir, fmt.Sprintf("apps%d", d))
+		for _, root := range []string{bin, app} {
+			if err := os.MkdirAll(root, 0755); err != nil {
+				b.Fatal(err)
+			}
+		}
+		bins, apps = append(bins, bin), append(apps, app)
+		for i := range benchTreeFiles / 20 {
+			name := fmt.Sprintf("tool%d", i)
+			if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\nexec /bin/true \"$@\"\n"), 0755); err != nil {
+				b.Fatal(err)
+			}
+			desktop := fmt.Sprintf("[Desktop Entry]\nName=Tool %d\nName[de]=Werkzeug %d\nExec=%s %%f\nCategories=Utility;\nKeywords=tool;bench;\n", i, i, name)
+			if err := os.WriteFile(filepath.Join(app, name+".desktop"), []byte(desktop), 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return bins, apps
+}
+
+// BenchmarkReindexTree measures full reindexes of a generated tree of 20k files, wrappers
+// inspected, with one worker per scanner, the default of ADE_INDEXD_WORKERS and more. The
+// per-entry work is mostly system calls, resolving binaries and reading wrapper scripts,
+// which the workers overlap given several CPUs or a cold cache.
+func BenchmarkReindexTree(b *testing.B) {
+	bins, apps := benchTree(b, b.TempDir())
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			idx := NewIndexer()
+			idx.sources = []source{
+				{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }},
+				{scanner: desktopScanner{}, roots: apps},
+			}
+			idx.inspectWrappers = true
+			idx.SetWorkers(workers)
+			idx.SetMaxEntries(0)
+
+			b.ResetTimer()
+			for range b.N {
+				count, err := idx.Reindex(context.Background(), bins)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if count != benchTreeFiles {
+					b.Fatalf("indexed %d entries, want %d", count, benchTreeFiles)
+				}
+			}
+		})
+	}
+}
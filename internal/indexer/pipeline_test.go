@@ -0,0 +1,93 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("scan pipeline", func() {
+	var (
+		binDirs []string
+		appDirs []string
+	)
+
+	// writeFile writes content to path, creating its directory
+	writeFile := func(path, content string, mode os.FileMode) {
+		gomega.Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(path, []byte(content), mode)).To(gomega.Succeed())
+	}
+
+	ginkgo.BeforeEach(func() {
+		tmpDir := ginkgo.GinkgoT().TempDir()
+		binDirs = []string{filepath.Join(tmpDir, "local", "bin"), filepath.Join(tmpDir, "bin")}
+		appDirs = []string{filepath.Join(tmpDir, "home", "applications"), filepath.Join(tmpDir, "share", "applications")}
+		for _, dir := range binDirs {
+			for i := range 50 {
+				writeFile(filepath.Join(dir, fmt.Sprintf("tool%d", i)), "#!/bin/sh\n", 0755)
+			}
+		}
+		for _, dir := range appDirs {
+			for i := range 50 {
+				writeFile(filepath.Join(dir, fmt.Sprintf("app%d.desktop", i)), fmt.Sprintf("[Desktop Entry]\nName=App %d\nExec=app%d\n", i, i), 0644)
+			}
+		}
+		writeFile(filepath.Join(appDirs[1], "hidden.desktop"), "[Desktop Entry]\nName=Hidden\nExec=hidden\nNoDisplay=true\n", 0644)
+	})
+
+	// index runs a full reindex with the given number of workers and returns the path of
+	// every key
+	index := func(workers int) map[string]string {
+		idx := NewIndexer()
+		idx.sources = []source{
+			{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }},
+			{scanner: desktopScanner{}, roots: appDirs},
+		}
+		idx.SetWorkers(workers)
+		_, err := idx.Reindex(context.Background(), binDirs)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		paths := make(map[string]string)
+		for _, entry := range idx.GetIndex().GetAll() {
+			paths[entry.Key] = entry.Path
+		}
+		return paths
+	}
+
+	ginkgo.It("should settle keys in search order whatever the number of workers", func() {
+		serial := index(1)
+		gomega.Expect(serial).To(gomega.HaveLen(200))
+		// The first of PATH and the user's desktop files win
+		gomega.Expect(serial).To(gomega.HaveKeyWithValue("tool7", filepath.Join(binDirs[0], "tool7")))
+		gomega.Expect(serial).To(gomega.HaveKeyWithValue("tool7@"+binDirs[1], filepath.Join(binDirs[1], "tool7")))
+		gomega.Expect(serial).To(gomega.HaveKeyWithValue("app7.desktop", filepath.Join(appDirs[0], "app7.desktop")))
+		gomega.Expect(serial).NotTo(gomega.HaveKey("hidden.desktop"))
+
+		for _, workers := range []int{2, 8, 8} {
+			gomega.Expect(index(workers)).To(gomega.Equal(serial), "%d workers", workers)
+		}
+	})
+
+	ginkgo.It("should cap every scanner in the order it walks, whatever the number of workers", func() {
+		capped := func(workers int) []string {
+			idx := NewIndexer()
+			idx.sources = []source{{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }}}
+			idx.SetWorkers(workers)
+			idx.SetMaxEntries(60)
+			_, err := idx.Reindex(context.Background(), binDirs)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			var paths []string
+			for _, entry := range idx.GetIndex().GetAll() {
+				paths = append(paths, entry.Path)
+			}
+			return paths
+		}
+		serial := capped(1)
+		gomega.Expect(serial).To(gomega.HaveLen(60))
+		gomega.Expect(capped(8)).To(gomega.ConsistOf(serial))
+	})
+})
@@ -0,0 +1,86 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+)
+
+// Preview is what a reindex would change, estimated from modification times without
+// scanning
+type Preview struct {
+	Sources      []SourceStats // Scanners with the roots they would walk, counts left zero
+	Modified     int           // Indexed files changed since the last run
+	Removed      int           // Indexed files gone, or outside the roots walked
+	ChangedRoots []string      // Roots changed since the last run or not walked by it, where files may have been added
+}
+
+// Preview estimates what Reindex(paths) would change. Files added are only noticed through
+// the roots holding them, so a new file deeper down a changed directory goes unnoticed
+// until the reindex.
+func (idx *Indexer) Preview(paths []string) Preview {
+	if len(paths) == 0 {
+		paths = config.Get().Path()
+	}
+
+	idx.mu.RLock()
+	sources := slices.Clone(idx.sources)
+	split := idx.split
+	last := idx.stats
+	indexedAt := idx.indexedAt
+	index := idx.index
+	idx.mu.RUnlock()
+
+	var (
+		preview Preview
+		roots   []string
+		walked  = make(map[string]bool)
+	)
+	for _, source := range last {
+		for _, root := range source.Roots {
+			walked[filepath.Clean(root)] = true
+		}
+	}
+	for _, src := range sources {
+		stats := SourceStats{Name: src.scanner.Name(), Roots: sourceRoots(src, paths, split)}
+		preview.Sources = append(preview.Sources, stats)
+		for _, root := range stats.Roots {
+			root = filepath.Clean(root)
+			if slices.Contains(roots, root) {
+				continue
+			}
+			roots = append(roots, root)
+			info, err := os.Stat(root)
+			if err != nil {
+				continue
+			}
+			if !walked[root] || info.ModTime().After(indexedAt) {
+				preview.ChangedRoots = append(preview.ChangedRoots, root)
+			}
+		}
+	}
+
+	for _, entry := range index.GetAll() {
+		info, err := os.Stat(entry.Path)
+		switch {
+		case err != nil || !underAny(entry.Path, roots):
+			preview.Removed++
+		case info.ModTime().After(indexedAt):
+			preview.Modified++
+		}
+	}
+	return preview
+}
+
+// underAny reports whether path is one of roots or below one of them
+func underAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
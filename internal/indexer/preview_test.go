@@ -0,0 +1,56 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Preview", func() {
+	var (
+		dir string
+		idx *Indexer
+	)
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "ade-preview-test-*")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(os.WriteFile(filepath.Join(dir, "tool"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		idx = NewIndexer()
+		idx.sources = []source{{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }}}
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	ginkgo.It("should see a root never walked as changed", func() {
+		preview := idx.Preview([]string{dir})
+		gomega.Expect(preview.Sources).To(gomega.HaveLen(1))
+		gomega.Expect(preview.Sources[0].Roots).To(gomega.ContainElement(dir))
+		gomega.Expect(preview.ChangedRoots).To(gomega.Equal([]string{dir}))
+	})
+
+	ginkgo.It("should compare with the last run without changing the index", func() {
+		_, err := idx.Reindex(context.Background(), []string{dir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		preview := idx.Preview([]string{dir})
+		gomega.Expect(preview.Modified + preview.Removed).To(gomega.BeZero())
+		gomega.Expect(preview.ChangedRoots).To(gomega.BeEmpty())
+
+		future := time.Now().Add(time.Hour)
+		gomega.Expect(os.Chtimes(filepath.Join(dir, "tool"), future, future)).To(gomega.Succeed())
+		gomega.Expect(idx.Preview([]string{dir}).Modified).To(gomega.Equal(1))
+
+		gomega.Expect(os.Remove(filepath.Join(dir, "tool"))).To(gomega.Succeed())
+		preview = idx.Preview([]string{dir})
+		gomega.Expect(preview.Removed).To(gomega.Equal(1))
+		gomega.Expect(preview.ChangedRoots).To(gomega.Equal([]string{dir}))
+		gomega.Expect(idx.GetIndex().Count()).To(gomega.Equal(1))
+	})
+})
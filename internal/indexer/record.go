@@ -0,0 +1,73 @@
+package indexer
+
+import (
+	"time"
+)
+
+// Record is an entry as exported by export-index, one JSON object per line. The field names
+// are part of the format: files written by one version are read by the next, so fields are
+// added but never renamed. What's derived when indexing, like the transliterated names and
+// the identity of the binary, is left out.
+type Record struct {
+	ID           int64             `json:"id"`
+	Key          string            `json:"key"`
+	Revision     uint64            `json:"revision"`
+	Name         string            `json:"name"`
+	RawName      string            `json:"raw-name,omitempty"`
+	Names        map[string]string `json:"names,omitempty"`
+	GenericName  string            `json:"generic-name,omitempty"`
+	GenericNames map[string]string `json:"generic-names,omitempty"`
+	Path         string            `json:"path"`
+	Exec         string            `json:"exec"`
+	ResolvedExec string            `json:"resolved-exec,omitempty"`
+	DesktopID    string            `json:"desktop-id,omitempty"`
+	Desktop      bool              `json:"desktop"`
+	Terminal     bool              `json:"terminal"`
+	SelfTool     bool              `json:"self-tool"`
+	Nice         *int              `json:"nice,omitempty"`
+	Categories   []string          `json:"categories,omitempty"`
+	Keywords     []string          `json:"keywords,omitempty"`
+	Actions      []RecordAction    `json:"actions,omitempty"`
+	MimeTypes    []string          `json:"mime-types,omitempty"`
+	ModTime      time.Time         `json:"mod-time"`
+	Origin       string            `json:"origin,omitempty"`
+}
+
+// RecordAction is a desktop action of a Record
+type RecordAction struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	Exec string `json:"exec"`
+}
+
+// NewRecord returns the record of entry, its modification time in UTC. Maps and slices are
+// shared with the entry.
+func NewRecord(entry *Entry) Record {
+	record := Record{
+		ID:           entry.ID,
+		Key:          entry.Key,
+		Revision:     entry.Revision,
+		Name:         entry.Name,
+		RawName:      entry.RawName,
+		Names:        entry.Names,
+		GenericName:  entry.GenericName,
+		GenericNames: entry.GenericNames,
+		Path:         entry.Path,
+		Exec:         entry.Exec,
+		ResolvedExec: entry.ResolvedExec,
+		DesktopID:    entry.DesktopID,
+		Desktop:      entry.IsDesktop,
+		Terminal:     entry.Terminal,
+		SelfTool:     entry.SelfTool,
+		Nice:         entry.Nice,
+		Categories:   entry.Categories,
+		Keywords:     entry.Keywords,
+		MimeTypes:    entry.MimeTypes,
+		ModTime:      entry.ModTime.UTC(),
+		Origin:       entry.Origin,
+	}
+	for _, action := range entry.Actions {
+		record.Actions = append(record.Actions, RecordAction{Key: action.Key, Name: action.Name, Exec: action.Exec})
+	}
+	return record
+}
@@ -0,0 +1,86 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("rename rules", func() {
+	var (
+		first, second string
+		idx           *Indexer
+		rules         []config.RenameRule
+	)
+
+	ginkgo.BeforeEach(func() {
+		root := ginkgo.GinkgoT().TempDir()
+		first, second = filepath.Join(root, "bin"), filepath.Join(root, "local")
+		for _, dir := range []string{first, second} {
+			gomega.Expect(os.Mkdir(dir, 0755)).To(gomega.Succeed())
+			for _, name := range []string{"python3.12", "tool"} {
+				gomega.Expect(os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+			}
+		}
+		rules = nil
+		for _, line := range []string{"^python([0-9.]+)$ Python $1", "^python.*$ Snake", "/local/tool$ Local tool"} {
+			rule, err := config.ParseRenameRule(line)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			rules = append(rules, rule)
+		}
+		idx = NewIndexer()
+		idx.renameRules = func() []config.RenameRule { return rules }
+		idx.sources = []source{{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }}}
+	})
+
+	byPath := func() map[string]*Entry {
+		entries := make(map[string]*Entry)
+		for _, entry := range idx.GetIndex().GetAll() {
+			entries[entry.Path] = entry
+		}
+		return entries
+	}
+
+	ginkgo.It("should name executables by the first matching rule and keep their keys", func() {
+		_, err := idx.Reindex(context.Background(), []string{first, second})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		entries := byPath()
+		for _, dir := range []string{first, second} {
+			python := entries[filepath.Join(dir, "python3.12")]
+			gomega.Expect(python.Name).To(gomega.Equal("Python 3.12"))
+			gomega.Expect(python.RawName).To(gomega.Equal("python3.12"))
+		}
+		// Keys still come from the file name, the first in the search path wins
+		gomega.Expect(entries[filepath.Join(first, "python3.12")].Key).To(gomega.Equal("python3.12"))
+		gomega.Expect(entries[filepath.Join(second, "python3.12")].Key).To(gomega.Equal("python3.12@" + second))
+
+		// Rules on the path tell the copies apart
+		gomega.Expect(entries[filepath.Join(first, "tool")].Name).To(gomega.Equal("tool"))
+		gomega.Expect(entries[filepath.Join(first, "tool")].RawName).To(gomega.BeEmpty())
+		gomega.Expect(entries[filepath.Join(second, "tool")].Name).To(gomega.Equal("Local tool"))
+		gomega.Expect(entries[filepath.Join(second, "tool")].Key).To(gomega.Equal("tool@" + second))
+	})
+
+	ginkgo.It("should read the rules again on every run", func() {
+		_, err := idx.Reindex(context.Background(), []string{first})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(byPath()[filepath.Join(first, "python3.12")].Name).To(gomega.Equal("Python 3.12"))
+
+		rules = rules[1:]
+		_, err = idx.Reindex(context.Background(), []string{first})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(byPath()[filepath.Join(first, "python3.12")].Name).To(gomega.Equal("Snake"))
+
+		rules = nil
+		_, err = idx.Reindex(context.Background(), []string{first})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		python := byPath()[filepath.Join(first, "python3.12")]
+		gomega.Expect(python.Name).To(gomega.Equal("python3.12"))
+		gomega.Expect(python.RawName).To(gomega.BeEmpty())
+	})
+})
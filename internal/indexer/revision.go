@@ -0,0 +1,57 @@
+package indexer
+
+import (
+	"reflect"
+	"time"
+)
+
+// sameContent reports whether a and b describe an entry alike: in every field but the ID,
+// the revision and the modification times of the file and the binary, which a touch alone
+// changes
+func sameContent(a, b *Entry) bool {
+	x, y := *a, *b
+	x.ID, y.ID = 0, 0
+	x.Revision, y.Revision = 0, 0
+	x.ModTime, y.ModTime = time.Time{}, time.Time{}
+	x.ExecID.ModTime, y.ExecID.ModTime = time.Time{}, time.Time{}
+	return reflect.DeepEqual(x, y)
+}
+
+// nextRevision returns the revision of entry, a new version of prev: that of prev when the
+// content is the same, otherwise next, the revision of the run
+func nextRevision(prev, entry *Entry, next uint64) uint64 {
+	if sameContent(prev, entry) {
+		return max(prev.Revision, 1)
+	}
+	return next
+}
+
+// MaxRevision returns the revision of the index, the highest one an entry ever got in it or
+// in the indexes it replaced: when it didn't change, no entry that was there already did.
+// It never goes down, not even when the entry at it is removed.
+func (idx *Index) MaxRevision() uint64 {
+	return idx.view.Load().revision
+}
+
+// carryRevisions gives the entries of index, just built by a run, their revisions from
+// current, the index it replaces. Entries are matched by key: new ones get revision 1,
+// the others keep theirs or, if they changed, all get the revision following that of
+// current, so any change moves the revision of the index.
+func carryRevisions(index, current *Index) {
+	top := current.MaxRevision()
+	next := max(top, 1) + 1
+	for _, entry := range index.GetAll() {
+		if prev, ok := current.GetByKey(entry.Key); ok {
+			entry.Revision = nextRevision(prev, entry, next)
+		} else {
+			entry.Revision = 1
+		}
+		top = max(top, entry.Revision)
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	view := *index.view.Load()
+	view.revision = top
+	index.view.Store(&view)
+}
@@ -0,0 +1,112 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("revisions", func() {
+	var (
+		appsDir, binDir string
+		idx             *Indexer
+	)
+
+	writeApp := func(name, content string) {
+		gomega.Expect(os.WriteFile(filepath.Join(appsDir, name+".desktop"), []byte(content), 0644)).To(gomega.Succeed())
+	}
+	reindex := func() {
+		_, err := idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+	revision := func(key string) uint64 {
+		entry, ok := idx.GetIndex().GetByKey(key)
+		gomega.Expect(ok).To(gomega.BeTrue(), key)
+		return entry.Revision
+	}
+
+	ginkgo.BeforeEach(func() {
+		tmpDir := ginkgo.GinkgoT().TempDir()
+		appsDir, binDir = filepath.Join(tmpDir, "apps"), filepath.Join(tmpDir, "bin")
+		gomega.Expect(os.Mkdir(appsDir, 0755)).To(gomega.Succeed())
+		gomega.Expect(os.Mkdir(binDir, 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(binDir, "tool"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		writeApp("editor", "[Desktop Entry]\nName=Editor\nExec=editor %f\nCategories=Utility;\n")
+		writeApp("viewer", "[Desktop Entry]\nName=Viewer\nExec=viewer\n")
+
+		idx = NewIndexer()
+		idx.sources = []source{
+			{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }},
+			{scanner: desktopScanner{}, roots: []string{appsDir}},
+		}
+		reindex()
+	})
+
+	ginkgo.It("should start new entries at revision 1", func() {
+		gomega.Expect(revision("editor.desktop")).To(gomega.Equal(uint64(1)))
+		gomega.Expect(revision("tool")).To(gomega.Equal(uint64(1)))
+		gomega.Expect(idx.GetIndex().MaxRevision()).To(gomega.Equal(uint64(1)))
+	})
+
+	ginkgo.It("should bump only the entries that changed", func() {
+		writeApp("editor", "[Desktop Entry]\nName=Editor\nExec=editor --new %f\nCategories=Utility;\n")
+		reindex()
+		gomega.Expect(revision("editor.desktop")).To(gomega.Equal(uint64(2)))
+		gomega.Expect(revision("viewer.desktop")).To(gomega.Equal(uint64(1)))
+		gomega.Expect(idx.GetIndex().MaxRevision()).To(gomega.Equal(uint64(2)))
+
+		writeApp("editor", "[Desktop Entry]\nName=Editor\nExec=editor --new %f\nCategories=Utility;Development;\n")
+		reindex()
+		gomega.Expect(revision("editor.desktop")).To(gomega.Equal(uint64(3)))
+		gomega.Expect(idx.GetIndex().MaxRevision()).To(gomega.Equal(uint64(3)))
+	})
+
+	ginkgo.It("should not bump entries rewritten or touched alike, nor for additions", func() {
+		writeApp("viewer", "[Desktop Entry]\nName=Viewer\nExec=viewer\n")
+		later := time.Now().Add(time.Hour)
+		gomega.Expect(os.Chtimes(filepath.Join(binDir, "tool"), later, later)).To(gomega.Succeed())
+		writeApp("player", "[Desktop Entry]\nName=Player\nExec=player\n")
+		reindex()
+
+		generation := idx.Generation()
+		gomega.Expect(generation).To(gomega.Equal(uint64(2)))
+		gomega.Expect(revision("viewer.desktop")).To(gomega.Equal(uint64(1)))
+		gomega.Expect(revision("tool")).To(gomega.Equal(uint64(1)))
+		gomega.Expect(revision("player.desktop")).To(gomega.Equal(uint64(1)))
+		gomega.Expect(idx.GetIndex().MaxRevision()).To(gomega.Equal(uint64(1)))
+	})
+
+	ginkgo.It("should move the revision of the index whichever entry changed", func() {
+		writeApp("editor", "[Desktop Entry]\nName=Editor\nExec=editor --new %f\nCategories=Utility;\n")
+		reindex()
+		writeApp("editor", "[Desktop Entry]\nName=Editor\nExec=editor --new %f\nCategories=Utility;Development;\n")
+		reindex()
+		gomega.Expect(revision("editor.desktop")).To(gomega.Equal(uint64(3)))
+		gomega.Expect(revision("viewer.desktop")).To(gomega.Equal(uint64(1)))
+
+		// The editor holds the revision of the index while the viewer changes
+		writeApp("viewer", "[Desktop Entry]\nName=Viewer\nExec=viewer --fullscreen\n")
+		reindex()
+		gomega.Expect(revision("viewer.desktop")).To(gomega.Equal(uint64(4)))
+		gomega.Expect(revision("editor.desktop")).To(gomega.Equal(uint64(3)))
+		gomega.Expect(idx.GetIndex().MaxRevision()).To(gomega.Equal(uint64(4)))
+
+		// Removing the entry at it leaves it alone
+		gomega.Expect(os.Remove(filepath.Join(appsDir, "viewer.desktop"))).To(gomega.Succeed())
+		reindex()
+		gomega.Expect(idx.GetIndex().MaxRevision()).To(gomega.Equal(uint64(4)))
+	})
+
+	ginkgo.It("should carry revisions through merged rescans", func() {
+		writeApp("editor", "[Desktop Entry]\nName=Editor Pro\nExec=editor %f\nCategories=Utility;\n")
+		reindex()
+		_, err := idx.ReindexMerge(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(revision("editor.desktop")).To(gomega.Equal(uint64(2)))
+		gomega.Expect(revision("tool")).To(gomega.Equal(uint64(1)))
+	})
+})
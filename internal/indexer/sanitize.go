@@ -0,0 +1,49 @@
+package indexer
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// sanitizeEntry replaces invalid UTF-8 in the names, categories, keywords and action names
+// of entry with U+FFFD, so filters and searches match on valid text. Path and Exec are left
+// as they are, they have to reach the file system unchanged. Reports whether anything was
+// replaced.
+func sanitizeEntry(entry *Entry) bool {
+	replaced := false
+	fix := func(s string) string {
+		if utf8.ValidString(s) {
+			return s
+		}
+		replaced = true
+		return strings.ToValidUTF8(s, string(utf8.RuneError))
+	}
+	fixMap := func(m map[string]string) map[string]string {
+		for key, value := range m {
+			if utf8.ValidString(key) && utf8.ValidString(value) {
+				continue
+			}
+			fixed := make(map[string]string, len(m))
+			for key, value := range m {
+				fixed[fix(key)] = fix(value)
+			}
+			return fixed
+		}
+		return m
+	}
+
+	entry.Name = fix(entry.Name)
+	entry.Names = fixMap(entry.Names)
+	entry.GenericName = fix(entry.GenericName)
+	entry.GenericNames = fixMap(entry.GenericNames)
+	for i := range entry.Categories {
+		entry.Categories[i] = fix(entry.Categories[i])
+	}
+	for i := range entry.Keywords {
+		entry.Keywords[i] = fix(entry.Keywords[i])
+	}
+	for i := range entry.Actions {
+		entry.Actions[i].Name = fix(entry.Actions[i].Name)
+	}
+	return replaced
+}
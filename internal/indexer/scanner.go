@@ -0,0 +1,215 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/indexer/appimage"
+	"github.com/0xADE/ade-ctld/internal/indexer/desktop"
+	"github.com/0xADE/ade-ctld/internal/indexer/executable"
+	"github.com/0xADE/ade-ctld/internal/indexer/ignore"
+	"github.com/0xADE/ade-ctld/internal/redact"
+)
+
+// Scanner is a source of index entries. Scan walks roots and sends every application it
+// finds to out. It must not close out and should stop sending once ctx is done. Scanners
+// walking directories should leave out what the filter of ignore.FromContext(ctx) skips.
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, roots []string, out chan<- *Entry) error
+}
+
+// Mirror is a Scanner copying the entries of another daemon instead of walking directories.
+// Its entries are tagged with Origin and give way to those found here: a mirrored entry with
+// the path or desktop file ID of another entry is left out of the index.
+type Mirror interface {
+	Scanner
+	Origin() string
+}
+
+// SourceStats describes a scanner of the indexer and what it found in the last run
+type SourceStats struct {
+	Name    string   // Scanner name
+	Roots   []string // Roots scanned
+	Count   int      // Entries indexed
+	Ignored int      // Files and directories skipped by exclude patterns and ignore files
+	Pruned  int      // Directories pruned by name, like .git or node_modules
+
+	InvalidUTF8 int // Entries with invalid UTF-8 in their names, replaced with U+FFFD
+
+	Scans []RootStats // Roots, in order, with what their walks took and found
+}
+
+// RootStats describes the walk of one root of a scanner in the last run. Scanners report
+// the time and files of their walks through the filter of ignore.FromContext; those that
+// don't walk directories leave them zero.
+type RootStats struct {
+	Root     string
+	Duration time.Duration // Wall time of the walk
+	Files    int           // Files walked
+	Entries  int           // Entries found under the root, those left out past ADE_INDEXD_MAX_ENTRIES included
+	Errors   int           // Files and directories that couldn't be read
+}
+
+// Names of the built-in scanners
+const (
+	ExecutableScanner = "executable"
+	DesktopScanner    = "desktop"
+	AppImageScanner   = "appimage"
+)
+
+// source is a scanner along with the roots it always scans. Built-in scanners compute
+// theirs per run from the search path instead. Roots configured for the scanner are added
+// on every run.
+type source struct {
+	scanner      Scanner
+	roots        []string
+	defaultRoots func(searchPath []string) []string
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = []source{
+		{scanner: executableScanner{}, defaultRoots: func(searchPath []string) []string { return searchPath }},
+		{scanner: desktopScanner{}, defaultRoots: func([]string) []string { return desktop.DefaultPaths() }},
+		{scanner: appImageScanner{}, defaultRoots: func([]string) []string { return config.Get().AppImageDirs() }},
+	}
+)
+
+// RegisterScanner adds a scanner to the indexers created afterwards, scanning roots on
+// every run. Embedders call it from init. It panics if a scanner with the same name is
+// already registered.
+func RegisterScanner(s Scanner, roots ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = addSource(registry, s, roots)
+}
+
+// AddScanner adds a scanner to this indexer only. It panics if a scanner with the same
+// name is already present.
+func (idx *Indexer) AddScanner(s Scanner, roots ...string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.sources = addSource(idx.sources, s, roots)
+}
+
+// RemoveScanners removes every scanner of this indexer, the registered ones included, so
+// that it runs only those added afterwards, e.g. to index fixtures without the machine's
+// search path
+func (idx *Indexer) RemoveScanners() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.sources = nil
+}
+
+func addSource(sources []source, s Scanner, roots []string) []source {
+	for _, src := range sources {
+		if src.scanner.Name() == s.Name() {
+			panic(fmt.Sprintf("indexer: scanner %q registered twice", s.Name()))
+		}
+	}
+	return append(slices.Clip(sources), source{scanner: s, roots: slices.Clone(roots)})
+}
+
+// registeredSources returns a copy of the registered scanners
+func registeredSources() []source {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return slices.Clone(registry)
+}
+
+// executableScanner indexes executable files
+type executableScanner struct{}
+
+func (executableScanner) Name() string { return ExecutableScanner }
+
+func (executableScanner) Scan(ctx context.Context, roots []string, out chan<- *Entry) error {
+	workers := workersOf(ctx)
+	found := make(chan *executable.ExecutableInfo, workers*perWorker)
+	go executable.ScanPaths(roots, ignore.FromContext(ctx), found)
+
+	convert(ctx, workers, found, out, func(exec *executable.ExecutableInfo) *Entry {
+		return &Entry{
+			Name:         exec.Name,
+			Path:         exec.Path,
+			Exec:         exec.Path,
+			ResolvedExec: ResolveExec(exec.Path),
+			ModTime:      exec.ModTime,
+		}
+	})
+	return ctx.Err()
+}
+
+// desktopScanner indexes .desktop files, skipping NoDisplay and autostart entries
+type desktopScanner struct{}
+
+func (desktopScanner) Name() string { return DesktopScanner }
+
+func (desktopScanner) Scan(ctx context.Context, roots []string, out chan<- *Entry) error {
+	autostart := config.Get().AutostartDirs()
+	workers := workersOf(ctx)
+	found := make(chan *desktop.DesktopEntry, workers*perWorker)
+	go desktop.ScanPaths(roots, ignore.FromContext(ctx), found)
+
+	convert(ctx, workers, found, out, func(desk *desktop.DesktopEntry) *Entry {
+		for _, diagnostic := range desk.Diagnostics {
+			log.Printf("[WARN] Desktop file %s: %s", redact.String(desk.Path), diagnostic)
+		}
+		// Hidden from menus, or started with the session rather than from a launcher
+		if desk.NoDisplay || desktop.IsAutostart(desk, autostart) {
+			return nil
+		}
+		return &Entry{
+			Name:         desk.Name,
+			Names:        desk.Names,
+			GenericName:  desk.GenericName,
+			GenericNames: desk.GenericNames,
+			Path:         desk.Path,
+			Exec:         desk.Exec,
+			ResolvedExec: ResolveExec(desktop.ExecBinary(desk.Exec)),
+			DesktopID:    desk.ID,
+			Terminal:     desk.Terminal,
+			Nice:         desk.Nice,
+			Categories:   desk.Categories,
+			Keywords:     desk.Keywords,
+			Actions:      desk.Actions,
+			MimeTypes:    desk.MimeTypes,
+			IsDesktop:    true,
+			ModTime:      desk.ModTime,
+		}
+	})
+	return ctx.Err()
+}
+
+// appImageScanner indexes executable AppImages, named after their file
+type appImageScanner struct{}
+
+func (appImageScanner) Name() string { return AppImageScanner }
+
+func (appImageScanner) Scan(ctx context.Context, roots []string, out chan<- *Entry) error {
+	// Roots are walked one by one, timing each
+	filter := ignore.FromContext(ctx)
+	workers := workersOf(ctx)
+	for _, root := range roots {
+		done := filter.Walking(root)
+		found := make(chan *appimage.AppImageInfo, workers*perWorker)
+		go appimage.ScanPaths([]string{root}, found)
+
+		convert(ctx, workers, found, out, func(app *appimage.AppImageInfo) *Entry {
+			return &Entry{
+				Name:         app.Name,
+				Path:         app.Path,
+				Exec:         app.Path,
+				ResolvedExec: ResolveExec(app.Path),
+				ModTime:      app.ModTime,
+			}
+		})
+		done()
+	}
+	return ctx.Err()
+}
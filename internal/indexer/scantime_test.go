@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/indexer/ignore"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+// delayScanner walks every root for the delay set for it, finding one file there, or none
+// it can read for the failing roots
+type delayScanner struct {
+	name    string
+	delays  map[string]time.Duration
+	failing map[string]bool
+}
+
+func (s delayScanner) Name() string { return s.name }
+
+func (s delayScanner) Scan(ctx context.Context, roots []string, out chan<- *Entry) error {
+	filter := ignore.FromContext(ctx)
+	for _, root := range roots {
+		done := filter.Walking(root)
+		time.Sleep(s.delays[root])
+		if s.failing[root] {
+			filter.Failed(root)
+		} else {
+			filter.Seen(root)
+			out <- &Entry{Name: s.name, Path: filepath.Join(root, s.name), Exec: s.name}
+		}
+		done()
+	}
+	return nil
+}
+
+var _ = ginkgo.Describe("scan timing", func() {
+	var (
+		idx  *Indexer
+		logs *gbytes.Buffer
+	)
+
+	ginkgo.BeforeEach(func() {
+		idx = NewIndexer()
+		idx.sources = []source{
+			{scanner: delayScanner{
+				name:    "rc",
+				delays:  map[string]time.Duration{"/mnt/nfs/bin": 300 * time.Millisecond},
+				failing: map[string]bool{"/mnt/gone/bin": true},
+			}, roots: []string{"/mnt/nfs/bin", "/opt/tools/bin", "/mnt/gone/bin"}},
+			{scanner: delayScanner{
+				name:   "apps",
+				delays: map[string]time.Duration{"/usr/share/apps": 150 * time.Millisecond},
+			}, roots: []string{"/usr/share/apps"}},
+		}
+		idx.slowRoot = 200 * time.Millisecond
+
+		logs = gbytes.NewBuffer()
+		log.SetOutput(logs)
+		ginkgo.DeferCleanup(func() { log.SetOutput(os.Stderr) })
+	})
+
+	ginkgo.It("should attribute the time of scanners running at once to their roots", func() {
+		_, err := idx.Reindex(context.Background(), nil)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		sources := idx.Sources()
+		rc := sources[0].Scans
+		gomega.Expect(rc).To(gomega.HaveLen(3))
+		gomega.Expect(rc[0].Root).To(gomega.Equal("/mnt/nfs/bin"))
+		gomega.Expect(rc[0].Duration).To(gomega.BeNumerically(">=", 300*time.Millisecond))
+		gomega.Expect(rc[1].Duration).To(gomega.BeNumerically("<", 150*time.Millisecond))
+		gomega.Expect(rc[0]).To(gomega.HaveField("Files", 1))
+		gomega.Expect(rc[0]).To(gomega.HaveField("Entries", 1))
+		gomega.Expect(rc[2]).To(gomega.HaveField("Errors", 1))
+		gomega.Expect(rc[2]).To(gomega.HaveField("Entries", 0))
+
+		apps := sources[1].Scans
+		gomega.Expect(apps).To(gomega.HaveLen(1))
+		gomega.Expect(apps[0].Duration).To(gomega.BeNumerically(">=", 150*time.Millisecond))
+		gomega.Expect(apps[0].Duration).To(gomega.BeNumerically("<", 300*time.Millisecond))
+	})
+
+	ginkgo.It("should warn about roots slower than the threshold only", func() {
+		_, err := idx.Reindex(context.Background(), nil)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Expect(string(logs.Contents())).To(gomega.MatchRegexp(`\[WARN\] Scan root /mnt/nfs/bin of rc took \S+, more than 200ms: remove it`))
+		gomega.Expect(string(logs.Contents())).NotTo(gomega.MatchRegexp(`Scan root (/opt/tools/bin|/usr/share/apps) .*took`))
+
+		idx.slowRoot = 0
+		logs.Clear()
+		_, err = idx.Reindex(context.Background(), nil)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(string(logs.Contents())).NotTo(gomega.ContainSubstring(" took "))
+	})
+})
@@ -0,0 +1,281 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+)
+
+// Reindexes are asked for by the daemon starting, clients, rc file reloads and ignore file
+// changes. They go through a scheduler instead of cancelling each other: at most one run
+// executes, with at most one follow-up queued behind it into which every request the run
+// doesn't cover is merged. A request is covered by a run whose scope holds its own, and
+// waits for that run instead of starting another. A request of higher priority than the
+// run under way preempts it when its follow-up redoes all of that run's work anyway.
+
+// Priority orders reindex requests
+type Priority int
+
+const (
+	PriorityBackground Priority = iota // watchers and reloads, nobody waits for the result
+	PriorityClient                     // a client or the daemon start waits for the result
+)
+
+func (p Priority) String() string {
+	if p == PriorityClient {
+		return "client"
+	}
+	return "background"
+}
+
+// Triggers of reindex requests, reported in the queue state
+const (
+	TriggerStartup  = "startup"  // the daemon starting
+	TriggerCommand  = "command"  // the reindex and clearcache commands
+	TriggerList     = "list"     // a list before the index was ever built
+	TriggerConfig   = "config"   // an rc file reload
+	TriggerIgnore   = "ignore"   // an ignore file change
+	TriggerUpstream = "upstream" // a change of the index of a Mirror's daemon
+)
+
+// Scope is what a reindex rescans: with Full the whole index is rebuilt over the search
+// path Paths, otherwise the executables under the directories Paths are rescanned and
+// merged into the current index. A single path is a scope with one directory.
+type Scope struct {
+	Full  bool
+	Paths []string
+}
+
+// FullScope returns the scope of a rebuild over paths, or over the configured search path
+// if none are given
+func FullScope(paths []string) Scope {
+	if len(paths) == 0 {
+		paths = config.Get().Path()
+	}
+	return Scope{Full: true, Paths: slices.Clone(paths)}
+}
+
+// RootsScope returns the scope of a rescan of the executables under dirs
+func RootsScope(dirs ...string) Scope {
+	roots := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		roots = append(roots, filepath.Clean(dir))
+	}
+	return Scope{Paths: roots}
+}
+
+// Covers reports whether a run over s leaves nothing of other to rescan: a rebuild covers
+// rebuilds and rescans below its search path, a rescan only rescans below its directories.
+// Scanners walk their roots recursively.
+func (s Scope) Covers(other Scope) bool {
+	if other.Full && !s.Full {
+		return false
+	}
+	for _, path := range other.Paths {
+		if !underAny(filepath.Clean(path), s.Paths) {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge returns the narrowest scope covering both s and other. A rebuild merged with a
+// rescan takes the rescanned directories into its search path, like the rescan would have
+// added them to the index.
+func (s Scope) Merge(other Scope) Scope {
+	merged := Scope{Full: s.Full || other.Full, Paths: slices.Clone(s.Paths)}
+	for _, path := range other.Paths {
+		if !underAny(filepath.Clean(path), merged.Paths) {
+			merged.Paths = append(merged.Paths, path)
+		}
+	}
+	if merged.Full {
+		return merged
+	}
+	// Directories below another one are rescanned with it
+	roots := slices.Clone(merged.Paths)
+	return Scope{Paths: slices.DeleteFunc(merged.Paths, func(path string) bool {
+		return slices.ContainsFunc(roots, func(root string) bool {
+			return root != path && underAny(path, []string{root})
+		})
+	})}
+}
+
+// ReindexRequest asks the scheduler for a run
+type ReindexRequest struct {
+	Scope    Scope
+	Priority Priority
+	Trigger  string // one of the Trigger constants
+	// Fresh requests follow a change of the settings or ignore files, which a run already
+	// scanning may have read before; they are never covered by the run under way
+	Fresh bool
+}
+
+// QueuedRun describes a run of the scheduler
+type QueuedRun struct {
+	Scope    Scope
+	Priority Priority
+	Triggers []string  // triggers of the requests merged into the run, without repeats
+	Queued   time.Time // when the first of them came in
+	Started  time.Time // zero while pending
+	Waiting  int       // callers waiting for the run
+}
+
+// reindexJob is a run of the scheduler with the requests merged into it
+type reindexJob struct {
+	QueuedRun
+	cancel context.CancelFunc // set once started
+	done   chan struct{}      // closed when finished
+	err    error
+	next   *reindexJob // the follow-up that took the requests over after a preemption
+}
+
+// Schedule queues a reindex and waits for the run covering it. Returns the total number of
+// indexed entries. A run is cancelled when all callers waiting for it gave up.
+func (idx *Indexer) Schedule(ctx context.Context, req ReindexRequest) (int, error) {
+	idx.mu.Lock()
+	job := idx.enqueue(req)
+	job.Waiting++
+	idx.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() { idx.leave(job) })
+	defer stop()
+	for {
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		idx.mu.RLock()
+		next, err := job.next, job.err
+		idx.mu.RUnlock()
+		if next == nil {
+			if err != nil {
+				return 0, err
+			}
+			return idx.GetIndex().Count(), nil
+		}
+		job = next
+	}
+}
+
+// enqueue adds req to the run covering it, the run under way or the follow-up, and starts
+// the follow-up if nothing runs. Called with idx.mu held.
+func (idx *Indexer) enqueue(req ReindexRequest) *reindexJob {
+	if cur := idx.current; cur != nil && cur.next == nil && !req.Fresh && cur.Scope.Covers(req.Scope) {
+		cur.Triggers = addTrigger(cur.Triggers, req.Trigger)
+		return cur
+	}
+
+	pending := idx.pending
+	if pending == nil {
+		pending = &reindexJob{
+			QueuedRun: QueuedRun{Scope: req.Scope, Priority: req.Priority, Queued: time.Now()},
+			done:      make(chan struct{}),
+		}
+		idx.pending = pending
+	} else {
+		pending.Scope = pending.Scope.Merge(req.Scope)
+		pending.Priority = max(pending.Priority, req.Priority)
+	}
+	pending.Triggers = addTrigger(pending.Triggers, req.Trigger)
+
+	cur := idx.current
+	switch {
+	case cur == nil:
+		idx.startNext()
+	case cur.next == nil && pending.Priority > cur.Priority && pending.Scope.Covers(cur.Scope):
+		// Everything the run under way does is done again by the follow-up, which can't wait
+		cur.cancel()
+		cur.next = pending
+		pending.Waiting += cur.Waiting
+		cur.Waiting = 0
+	}
+	return pending
+}
+
+// startNext starts the follow-up, if any. Called with idx.mu held.
+func (idx *Indexer) startNext() {
+	job := idx.pending
+	idx.pending = nil
+	idx.current = job
+	if job == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job.cancel = cancel
+	job.Started = time.Now()
+	go func() {
+		err := idx.runIndexing(ctx, job.Scope.Paths, !job.Scope.Full)
+		cancel()
+
+		idx.mu.Lock()
+		defer idx.mu.Unlock()
+		job.err = err
+		close(job.done)
+		idx.startNext()
+	}()
+}
+
+// leave drops a caller that gave up waiting for job, or for the follow-up that took it
+// over, cancelling the run once nobody waits for it
+func (idx *Indexer) leave(job *reindexJob) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for job.next != nil {
+		job = job.next
+	}
+	select {
+	case <-job.done:
+		return
+	default:
+	}
+	job.Waiting--
+	if job.Waiting > 0 {
+		return
+	}
+	switch job {
+	case idx.current:
+		job.cancel()
+	case idx.pending:
+		idx.dropPending(context.Canceled)
+	}
+}
+
+// dropPending fails the follow-up with err. Called with idx.mu held.
+func (idx *Indexer) dropPending(err error) {
+	if idx.pending == nil {
+		return
+	}
+	idx.pending.err = err
+	close(idx.pending.done)
+	idx.pending = nil
+}
+
+// ReindexQueue returns the run under way and the follow-up queued behind it, nil if none
+func (idx *Indexer) ReindexQueue() (running, pending *QueuedRun) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.current.state(), idx.pending.state()
+}
+
+// state returns a copy of the description of job, nil for no job
+func (job *reindexJob) state() *QueuedRun {
+	if job == nil {
+		return nil
+	}
+	run := job.QueuedRun
+	run.Scope.Paths = slices.Clone(run.Scope.Paths)
+	run.Triggers = slices.Clone(run.Triggers)
+	return &run
+}
+
+func addTrigger(triggers []string, trigger string) []string {
+	if trigger == "" || slices.Contains(triggers, trigger) {
+		return triggers
+	}
+	return append(triggers, trigger)
+}
@@ -0,0 +1,274 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Scope", func() {
+	full := func(paths ...string) Scope { return Scope{Full: true, Paths: paths} }
+
+	ginkgo.DescribeTable("Covers",
+		func(s, other Scope, covers bool) {
+			gomega.Expect(s.Covers(other)).To(gomega.Equal(covers))
+		},
+		ginkgo.Entry("the same rebuild", full("/a", "/b"), full("/b", "/a"), true),
+		ginkgo.Entry("a rebuild of a subset", full("/a", "/b"), full("/a"), true),
+		ginkgo.Entry("a rebuild of a superset", full("/a"), full("/a", "/b"), false),
+		ginkgo.Entry("a rescan below the search path", full("/a"), RootsScope("/a/sub"), true),
+		ginkgo.Entry("a rescan next to the search path", full("/a"), RootsScope("/ab"), false),
+		ginkgo.Entry("a rebuild from a rescan", RootsScope("/a"), full("/a"), false),
+		ginkgo.Entry("a nested rescan", RootsScope("/a"), RootsScope("/a/sub", "/a"), true),
+		ginkgo.Entry("a disjoint rescan", RootsScope("/a"), RootsScope("/b"), false),
+	)
+
+	ginkgo.DescribeTable("Merge",
+		func(s, other, merged Scope) {
+			gomega.Expect(s.Merge(other)).To(gomega.Equal(merged))
+			gomega.Expect(merged.Covers(s)).To(gomega.BeTrue())
+			gomega.Expect(merged.Covers(other)).To(gomega.BeTrue())
+		},
+		ginkgo.Entry("a subset", RootsScope("/a", "/b"), RootsScope("/a/sub"), RootsScope("/a", "/b")),
+		ginkgo.Entry("a superset", RootsScope("/a/sub", "/b"), RootsScope("/a"), RootsScope("/b", "/a")),
+		ginkgo.Entry("disjoint scopes", RootsScope("/a"), RootsScope("/b"), RootsScope("/a", "/b")),
+		ginkgo.Entry("rebuilds", full("/a"), full("/b", "/a/sub"), full("/a", "/b")),
+		ginkgo.Entry("a rescan into a rebuild", RootsScope("/c"), full("/a"), full("/c", "/a")),
+		ginkgo.Entry("a rebuild into a rescan", full("/a"), RootsScope("/a/sub", "/c"), full("/a", "/c")),
+	)
+})
+
+var _ = ginkgo.Describe("Schedule", func() {
+	var (
+		idx  *Indexer
+		gate *gateScanner
+	)
+
+	ginkgo.BeforeEach(func() {
+		gate = &gateScanner{started: make(chan []string, 10), release: make(chan struct{})}
+		idx = NewIndexer()
+		idx.sources = []source{{scanner: gate, defaultRoots: func(paths []string) []string { return paths }}}
+	})
+
+	// schedule runs req in the background and returns where its error is sent
+	schedule := func(ctx context.Context, req ReindexRequest) <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			_, err := idx.Schedule(ctx, req)
+			result <- err
+		}()
+		return result
+	}
+	client := func(scope Scope, trigger string) ReindexRequest {
+		return ReindexRequest{Scope: scope, Priority: PriorityClient, Trigger: trigger}
+	}
+	background := func(scope Scope, trigger string) ReindexRequest {
+		return ReindexRequest{Scope: scope, Priority: PriorityBackground, Trigger: trigger, Fresh: true}
+	}
+	queue := func() (*QueuedRun, *QueuedRun) { return idx.ReindexQueue() }
+	pending := func() *QueuedRun {
+		_, pending := idx.ReindexQueue()
+		return pending
+	}
+	waiting := func() int {
+		running, _ := idx.ReindexQueue()
+		if running == nil {
+			return 0
+		}
+		return running.Waiting
+	}
+
+	ginkgo.It("should let the run under way absorb the requests it covers", func() {
+		first := schedule(context.Background(), client(FullScope([]string{"/a", "/b"}), TriggerStartup))
+		gomega.Eventually(gate.started).Should(gomega.Receive(gomega.Equal([]string{"/a", "/b"})))
+
+		narrower := []<-chan error{
+			schedule(context.Background(), client(FullScope([]string{"/a"}), TriggerCommand)),
+			schedule(context.Background(), client(RootsScope("/b/sub"), TriggerCommand)),
+			schedule(context.Background(), client(FullScope([]string{"/b", "/a"}), TriggerList)),
+		}
+		gomega.Eventually(waiting).Should(gomega.Equal(4))
+		running, pending := queue()
+		gomega.Expect(pending).To(gomega.BeNil())
+		gomega.Expect(running.Triggers).To(gomega.ConsistOf(TriggerStartup, TriggerCommand, TriggerList))
+
+		close(gate.release)
+		for _, result := range append(narrower, first) {
+			gomega.Eventually(result).Should(gomega.Receive(gomega.BeNil()))
+		}
+		gomega.Expect(gate.started).To(gomega.BeEmpty())
+		gomega.Expect(idx.Generation()).To(gomega.Equal(uint64(1)))
+		gomega.Expect(idx.IsRunning()).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should merge the requests left over into one follow-up", func() {
+		first := schedule(context.Background(), client(FullScope([]string{"/a"}), TriggerStartup))
+		gomega.Eventually(gate.started).Should(gomega.Receive())
+
+		second := schedule(context.Background(), client(RootsScope("/b"), TriggerCommand))
+		gomega.Eventually(pending).ShouldNot(gomega.BeNil())
+		third := schedule(context.Background(), client(RootsScope("/c"), TriggerCommand))
+		gomega.Eventually(func() int { return pending().Waiting }).Should(gomega.Equal(2))
+		// Settings may have changed after the run read them
+		fourth := schedule(context.Background(), background(RootsScope("/a/sub"), TriggerIgnore))
+		gomega.Eventually(func() int { return pending().Waiting }).Should(gomega.Equal(3))
+		gomega.Expect(pending().Scope).To(gomega.Equal(RootsScope("/b", "/c", "/a/sub")))
+		gomega.Expect(pending().Priority).To(gomega.Equal(PriorityClient))
+		gomega.Expect(pending().Triggers).To(gomega.Equal([]string{TriggerCommand, TriggerIgnore}))
+
+		gate.release <- struct{}{}
+		gomega.Eventually(first).Should(gomega.Receive(gomega.BeNil()))
+		gomega.Eventually(gate.started).Should(gomega.Receive(gomega.Equal([]string{"/b", "/c", "/a/sub"})))
+		gomega.Expect(pending()).To(gomega.BeNil())
+
+		gate.release <- struct{}{}
+		for _, result := range []<-chan error{second, third, fourth} {
+			gomega.Eventually(result).Should(gomega.Receive(gomega.BeNil()))
+		}
+		gomega.Expect(idx.Generation()).To(gomega.Equal(uint64(2)))
+	})
+
+	ginkgo.It("should let a client preempt a background run its follow-up redoes", func() {
+		first := schedule(context.Background(), background(FullScope([]string{"/a"}), TriggerConfig))
+		gomega.Eventually(gate.started).Should(gomega.Receive())
+
+		second := schedule(context.Background(), client(FullScope([]string{"/a", "/b"}), TriggerCommand))
+		// The follow-up starts without the background run being released
+		gomega.Eventually(gate.started).Should(gomega.Receive(gomega.Equal([]string{"/a", "/b"})))
+		running, pending := queue()
+		gomega.Expect(pending).To(gomega.BeNil())
+		gomega.Expect(running.Priority).To(gomega.Equal(PriorityClient))
+		gomega.Expect(running.Waiting).To(gomega.Equal(2))
+
+		gate.release <- struct{}{}
+		gomega.Eventually(first).Should(gomega.Receive(gomega.BeNil()))
+		gomega.Eventually(second).Should(gomega.Receive(gomega.BeNil()))
+		gomega.Expect(idx.Generation()).To(gomega.Equal(uint64(1)))
+	})
+
+	ginkgo.It("should not preempt a run for a request that doesn't redo it", func() {
+		// Lower priority
+		first := schedule(context.Background(), client(FullScope([]string{"/a"}), TriggerCommand))
+		gomega.Eventually(gate.started).Should(gomega.Receive())
+		second := schedule(context.Background(), background(FullScope([]string{"/a", "/b"}), TriggerConfig))
+		gomega.Eventually(pending).ShouldNot(gomega.BeNil())
+
+		// Higher priority, but narrower than the background run
+		gate.release <- struct{}{}
+		gomega.Eventually(first).Should(gomega.Receive(gomega.BeNil()))
+		gomega.Eventually(gate.started).Should(gomega.Receive(gomega.Equal([]string{"/a", "/b"})))
+		third := schedule(context.Background(), client(RootsScope("/c"), TriggerCommand))
+		gomega.Eventually(pending).ShouldNot(gomega.BeNil())
+		gomega.Consistently(gate.started).ShouldNot(gomega.Receive())
+
+		gate.release <- struct{}{}
+		gomega.Eventually(second).Should(gomega.Receive(gomega.BeNil()))
+		gate.release <- struct{}{}
+		gomega.Eventually(third).Should(gomega.Receive(gomega.BeNil()))
+		gomega.Expect(idx.Generation()).To(gomega.Equal(uint64(3)))
+	})
+
+	ginkgo.It("should cancel a run once nobody waits for it", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		first := schedule(ctx, client(FullScope([]string{"/a"}), TriggerCommand))
+		gomega.Eventually(gate.started).Should(gomega.Receive())
+		second := schedule(ctx, client(RootsScope("/b"), TriggerCommand))
+		gomega.Eventually(pending).ShouldNot(gomega.BeNil())
+
+		cancel()
+		gomega.Eventually(first).Should(gomega.Receive(gomega.MatchError(context.Canceled)))
+		gomega.Eventually(second).Should(gomega.Receive(gomega.MatchError(context.Canceled)))
+		gomega.Eventually(idx.IsRunning).Should(gomega.BeFalse())
+		gomega.Expect(idx.Generation()).To(gomega.BeZero())
+	})
+
+	ginkgo.It("should fail the runs dropped by Stop", func() {
+		first := schedule(context.Background(), client(FullScope([]string{"/a"}), TriggerCommand))
+		gomega.Eventually(gate.started).Should(gomega.Receive())
+		second := schedule(context.Background(), client(RootsScope("/b"), TriggerCommand))
+		gomega.Eventually(pending).ShouldNot(gomega.BeNil())
+
+		idx.Stop()
+		gomega.Eventually(first).Should(gomega.Receive(gomega.MatchError(context.Canceled)))
+		gomega.Eventually(second).Should(gomega.Receive(gomega.MatchError(context.Canceled)))
+		gomega.Expect(idx.IsRunning()).To(gomega.BeFalse())
+	})
+})
+
+var _ = ginkgo.Describe("Concurrent reindex triggers", func() {
+	ginkgo.It("should end with the index of the broadest request", func() {
+		tmpDir := ginkgo.GinkgoT().TempDir()
+		var dirs []string
+		for _, dir := range []string{"a", "b", "a/sub"} {
+			dirs = append(dirs, filepath.Join(tmpDir, dir))
+			gomega.Expect(os.MkdirAll(dirs[len(dirs)-1], 0755)).To(gomega.Succeed())
+			tool := filepath.Join(dirs[len(dirs)-1], filepath.Base(dir)+"-tool")
+			gomega.Expect(os.WriteFile(tool, []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		}
+		idx := NewIndexer()
+		idx.sources = idx.sources[:1]
+		all := FullScope(dirs[:2])
+
+		requests := []ReindexRequest{
+			{Scope: all, Priority: PriorityClient, Trigger: TriggerStartup},
+			{Scope: all, Priority: PriorityBackground, Trigger: TriggerConfig, Fresh: true},
+			{Scope: RootsScope(dirs[2]), Priority: PriorityClient, Trigger: TriggerCommand},
+			{Scope: FullScope(dirs[:1]), Priority: PriorityClient, Trigger: TriggerList},
+			{Scope: all, Priority: PriorityBackground, Trigger: TriggerIgnore, Fresh: true},
+			{Scope: RootsScope(dirs[1]), Priority: PriorityClient, Trigger: TriggerCommand},
+		}
+		// The first request of the round sets the search path, the rest may merge into it
+		_, err := idx.Schedule(context.Background(), requests[0])
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 5*len(requests))
+		for i := range 5 * len(requests) {
+			wg.Go(func() {
+				_, err := idx.Schedule(context.Background(), requests[i%len(requests)])
+				errs <- err
+			})
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		}
+
+		running, pending := idx.ReindexQueue()
+		gomega.Expect(running).To(gomega.BeNil())
+		gomega.Expect(pending).To(gomega.BeNil())
+		gomega.Expect(idx.Generation()).To(gomega.BeNumerically("<=", 1+5*len(requests)))
+		var names []string
+		for _, entry := range idx.GetIndex().GetAll() {
+			names = append(names, entry.Name)
+		}
+		slices.Sort(names)
+		// Requests merged last may leave the search path narrower, never the tools of a out
+		gomega.Expect(names).To(gomega.ContainElements("a-tool", "sub-tool"), fmt.Sprint(names))
+	})
+})
+
+// gateScanner reports the roots of every scan and blocks it until released or cancelled
+type gateScanner struct {
+	started chan []string
+	release chan struct{}
+}
+
+func (s *gateScanner) Name() string { return ExecutableScanner }
+
+func (s *gateScanner) Scan(ctx context.Context, roots []string, out chan<- *Entry) error {
+	s.started <- roots
+	select {
+	case <-s.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
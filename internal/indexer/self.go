@@ -0,0 +1,74 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// SameFile reports whether both identify the same file, whatever its name and whether it
+// changed since: a symlink, a hard link or a renamed binary is the file it leads to
+func (id FileID) SameFile(other FileID) bool {
+	return id.Ino != 0 && id.Dev == other.Dev && id.Ino == other.Ino
+}
+
+// selfTools returns the identities of the daemon's own binary and of the companions, the
+// binaries of those names next to it, like ade-exe-cli installed along with the daemon.
+// Files that can't be found are left out.
+func selfTools(executable string, companions []string) []FileID {
+	if executable == "" {
+		return nil
+	}
+	var ids []FileID
+	if id, err := StatFileID(executable); err == nil {
+		ids = append(ids, id)
+	}
+	// Next to the binary as installed, not to the symlink it may be run through
+	dirs := []string{filepath.Dir(executable)}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil && filepath.Dir(resolved) != dirs[0] {
+		dirs = append(dirs, filepath.Dir(resolved))
+	}
+	for _, dir := range dirs {
+		for _, name := range companions {
+			if id, err := StatFileID(filepath.Join(dir, name)); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// isSelfTool reports whether id is one of tools
+func isSelfTool(tools []FileID, id FileID) bool {
+	return slices.ContainsFunc(tools, id.SameFile)
+}
+
+// SetExecutable sets the binary the indexer takes for the daemon's own, see IsDaemon. It
+// defaults to the running one; tests stand a copy in. Entries are tagged on the next run.
+func (idx *Indexer) SetExecutable(path string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.executable = path
+}
+
+// IsDaemon reports whether id is the daemon's own binary, looked up again so a binary
+// upgraded since the last run is recognized
+func (idx *Indexer) IsDaemon(id FileID) bool {
+	idx.mu.RLock()
+	executable := idx.executable
+	idx.mu.RUnlock()
+	if executable == "" {
+		return false
+	}
+	self, err := StatFileID(executable)
+	return err == nil && self.SameFile(id)
+}
+
+// runningExecutable returns the path of the running binary, "" if it can't be told
+func runningExecutable() string {
+	path, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,91 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("self tools", func() {
+	var (
+		idx              *Indexer
+		binDir, appsDir  string
+		daemon, copyPath string
+	)
+
+	ginkgo.BeforeEach(func() {
+		// A copy of the test binary stands in for the daemon
+		self, err := os.Executable()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		data, err := os.ReadFile(self)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		tmpDir := ginkgo.GinkgoT().TempDir()
+		installDir := filepath.Join(tmpDir, "libexec")
+		binDir, appsDir = filepath.Join(tmpDir, "bin"), filepath.Join(tmpDir, "apps")
+		for _, dir := range []string{installDir, binDir, appsDir} {
+			gomega.Expect(os.Mkdir(dir, 0755)).To(gomega.Succeed())
+		}
+		daemon = filepath.Join(installDir, "ade-exe-ctld")
+		gomega.Expect(os.WriteFile(daemon, data, 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(installDir, "ade-exe-cli"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+
+		gomega.Expect(os.Symlink(daemon, filepath.Join(binDir, "ade-exe-ctld"))).To(gomega.Succeed())
+		gomega.Expect(os.Link(daemon, filepath.Join(binDir, "indexd"))).To(gomega.Succeed())
+		gomega.Expect(os.Symlink(filepath.Join(installDir, "ade-exe-cli"), filepath.Join(binDir, "ade-exe-cli"))).To(gomega.Succeed())
+		// A copy is another file
+		copyPath = filepath.Join(binDir, "indexd-copy")
+		gomega.Expect(os.WriteFile(copyPath, data, 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(binDir, "tool"), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(appsDir, "indexd.desktop"), []byte("[Desktop Entry]\nName=Index daemon\nExec="+filepath.Join(binDir, "ade-exe-ctld")+"\n"), 0644)).To(gomega.Succeed())
+
+		idx = NewIndexer()
+		idx.sources = []source{
+			{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }},
+			{scanner: desktopScanner{}, roots: []string{appsDir}},
+		}
+		// Through the symlink, like a daemon started from PATH
+		idx.SetExecutable(filepath.Join(binDir, "ade-exe-ctld"))
+		idx.companions = []string{"ade-exe-cli", "ade-exe-client"}
+	})
+
+	selfTool := func(key string) bool {
+		entry, ok := idx.GetIndex().GetByKey(key)
+		gomega.Expect(ok).To(gomega.BeTrue(), key)
+		return entry.SelfTool
+	}
+
+	ginkgo.It("should tag the daemon under any name and its companions", func() {
+		_, err := idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Expect(selfTool("ade-exe-ctld")).To(gomega.BeTrue())
+		gomega.Expect(selfTool("indexd")).To(gomega.BeTrue())
+		gomega.Expect(selfTool("ade-exe-cli")).To(gomega.BeTrue())
+		gomega.Expect(selfTool("indexd.desktop")).To(gomega.BeTrue())
+		gomega.Expect(selfTool("indexd-copy")).To(gomega.BeFalse())
+		gomega.Expect(selfTool("tool")).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should tell the daemon from its companions", func() {
+		id, err := StatFileID(filepath.Join(binDir, "indexd"))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(idx.IsDaemon(id)).To(gomega.BeTrue())
+
+		for _, path := range []string{filepath.Join(binDir, "ade-exe-cli"), copyPath} {
+			id, err := StatFileID(path)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			gomega.Expect(idx.IsDaemon(id)).To(gomega.BeFalse(), path)
+		}
+	})
+
+	ginkgo.It("should tag nothing without companions nor a known binary", func() {
+		idx.SetExecutable("")
+		_, err := idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(selfTool("ade-exe-ctld")).To(gomega.BeFalse())
+		gomega.Expect(selfTool("ade-exe-cli")).To(gomega.BeFalse())
+	})
+})
@@ -0,0 +1,52 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("transliteration", func() {
+	var (
+		dir string
+		idx *Indexer
+	)
+
+	ginkgo.BeforeEach(func() {
+		dir = ginkgo.GinkgoT().TempDir()
+		for _, name := range []string{"терминал", "firefox"} {
+			gomega.Expect(os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755)).To(gomega.Succeed())
+		}
+		idx = NewIndexer()
+		idx.sources = []source{{scanner: executableScanner{}, defaultRoots: func(paths []string) []string { return paths }}}
+	})
+
+	translits := func() map[string][]string {
+		result := make(map[string][]string)
+		for _, entry := range idx.GetIndex().GetAll() {
+			result[entry.Name] = entry.Translit
+		}
+		return result
+	}
+
+	ginkgo.It("should leave names alone by default", func() {
+		_, err := idx.Reindex(context.Background(), []string{dir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(translits()).To(gomega.Equal(map[string][]string{"терминал": nil, "firefox": nil}))
+	})
+
+	ginkgo.It("should spell names of other scripts in Latin when enabled", func() {
+		idx.transliterate = true
+		_, err := idx.Reindex(context.Background(), []string{dir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(translits()).To(gomega.Equal(map[string][]string{"терминал": {"terminal"}, "firefox": nil}))
+	})
+
+	ginkgo.It("should spell localized names once each", func() {
+		entry := &Entry{Name: "Files", Names: map[string]string{"ru": "Файлы", "uk": "Файли", "be": "Файлы", "ja": "ファイル"}}
+		gomega.Expect(transliterateNames(entry)).To(gomega.Equal([]string{"fayly", "fairu", "fayli"}))
+	})
+})
@@ -2,70 +2,132 @@ package indexer
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/indexer/desktop"
 )
 
 // Entry represents a single indexed application entry
 type Entry struct {
-	ID         int64             // Unique identifier
-	Name       string            // Default name (English or fallback)
-	Names      map[string]string // Localized names (locale -> name)
-	Path       string            // Path to executable or .desktop file
-	Exec       string            // Command to execute
-	Terminal   bool              // Whether to run in terminal
-	Categories []string          // Application categories
-	IsDesktop  bool              // Whether this is from a .desktop file
+	ID           int64             // Unique identifier
+	Key          string            // Stable identifier across reindexes and machines, see assignKeys
+	Revision     uint64            // Bumped whenever a reindex or Update changes the entry, 1 when first indexed
+	Name         string            // Default name (English or fallback)
+	RawName      string            // Name before a rename rule of the rc files changed it, empty if none did
+	Names        map[string]string // Localized names (locale -> name)
+	GenericName  string            // Default generic name, e.g. "Web Browser", desktop entries only
+	GenericNames map[string]string // Localized generic names (locale -> name), desktop entries only
+	Path         string            // Path to executable or .desktop file
+	Exec         string            // Command to execute
+	ResolvedExec string            // Symlink-resolved absolute path of the launched binary, empty if unresolvable
+	ExecID       FileID            // Identity of the launched binary when indexed, zero if unresolvable
+	Translit     []string          // Names of other scripts spelled in Latin, lowercase, see ADE_INDEXD_TRANSLITERATE
+	DesktopID    string            // Desktop file id (e.g. "org.gnome.Terminal.desktop"), empty for executables
+	Terminal     bool              // Whether to run in terminal
+	Nice         *int              // Niceness to launch with (X-ADE-Nice), nil to keep the daemon's
+	Categories   []string          // Application categories
+	Keywords     []string          // Search keywords, desktop entries only
+	Actions      []desktop.Action  // Desktop actions, like "New Private Window", desktop entries only
+	MimeTypes    []string          // MIME types and x-scheme-handler/<scheme> URL schemes handled, desktop entries only
+	IsDesktop    bool              // Whether this is from a .desktop file
+	SelfTool     bool              // Whether it launches the daemon's own binary or a companion of it, see ADE_INDEXD_COMPANIONS
+	ModTime      time.Time         // Modification time of Path when indexed
+	Origin       string            // Daemon the entry is mirrored from (see Mirror), empty if found here
 }
 
-// Index stores all indexed entries with thread-safe access
+// Index stores all indexed entries. Readers never lock: they load an immutable view of the
+// index, which writers replace atomically one at a time. Entries are kept in fixed size
+// chunks shared between views, so adding one copies neither the entries nor the chunks.
 type Index struct {
-	mu      sync.RWMutex
-	entries map[int64]*Entry
-	nextID  int64
+	mu   sync.Mutex // serializes writers
+	view atomic.Pointer[indexView]
+}
+
+// indexChunk is the number of entries per chunk
+const indexChunk = 1024
+
+// indexView is the index as readers see it. Nothing it reaches is modified once it's
+// published: a writer only fills chunk slots past count, then publishes a new view.
+type indexView struct {
+	chunks   [][]*Entry // entry id n is chunks[(n-1)/indexChunk][(n-1)%indexChunk]
+	count    int
+	keys     map[string]int64
+	revision uint64 // highest revision of the entries
 }
 
 // NewIndex creates a new empty index
 func NewIndex() *Index {
-	return &Index{
-		entries: make(map[int64]*Entry),
-		nextID:  1,
-	}
+	idx := &Index{}
+	idx.view.Store(&indexView{keys: make(map[string]int64)})
+	return idx
 }
 
-// Add adds a new entry to the index and returns its ID
+// Add adds a new entry to the index and returns its ID. Entries without a revision get the
+// first.
 func (idx *Index) Add(entry *Entry) int64 {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	entry.ID = idx.nextID
-	idx.nextID++
-	idx.entries[entry.ID] = entry
+	view := *idx.view.Load()
+	i := view.count
+	if i%indexChunk == 0 {
+		// Earlier views don't see past their own length, so the backing array can be shared
+		view.chunks = append(view.chunks, make([]*Entry, indexChunk))
+	}
+	entry.ID = int64(i + 1)
+	entry.Revision = max(entry.Revision, 1)
+	view.chunks[i/indexChunk][i%indexChunk] = entry
+	view.count = i + 1
+	view.revision = max(view.revision, entry.Revision)
+	idx.view.Store(&view)
 	return entry.ID
 }
 
+// setKeys replaces the key to ID map of the index. keys must not be modified afterwards.
+func (idx *Index) setKeys(keys map[string]int64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	view := *idx.view.Load()
+	view.keys = keys
+	idx.view.Store(&view)
+}
+
+// get returns the entry with the given id in the view
+func (v *indexView) get(id int64) (*Entry, bool) {
+	if id < 1 || id > int64(v.count) {
+		return nil, false
+	}
+	return v.chunks[(id-1)/indexChunk][(id-1)%indexChunk], true
+}
+
 // Get retrieves an entry by ID
 func (idx *Index) Get(id int64) (*Entry, bool) {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
+	return idx.view.Load().get(id)
+}
 
-	entry, ok := idx.entries[id]
-	return entry, ok
+// GetByKey retrieves an entry by its key
+func (idx *Index) GetByKey(key string) (*Entry, bool) {
+	view := idx.view.Load()
+	id, ok := view.keys[key]
+	if !ok {
+		return nil, false
+	}
+	return view.get(id)
 }
 
-// GetAll returns all entries (for filtering)
+// GetAll returns all entries (for filtering), in ID order
 func (idx *Index) GetAll() []*Entry {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
-
-	result := make([]*Entry, 0, len(idx.entries))
-	for _, entry := range idx.entries {
-		result = append(result, entry)
+	view := idx.view.Load()
+	result := make([]*Entry, 0, view.count)
+	for _, chunk := range view.chunks {
+		result = append(result, chunk[:min(len(chunk), view.count-len(result))]...)
 	}
 	return result
 }
 
 // Count returns the number of entries in the index
 func (idx *Index) Count() int {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
-	return len(idx.entries)
+	return idx.view.Load().count
 }
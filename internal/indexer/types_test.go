@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Index", func() {
+	ginkgo.It("should number entries from 1 across chunks", func() {
+		index := NewIndex()
+		for i := range 2*indexChunk + 3 {
+			gomega.Expect(index.Add(&Entry{Name: fmt.Sprint(i)})).To(gomega.Equal(int64(i + 1)))
+		}
+		gomega.Expect(index.Count()).To(gomega.Equal(2*indexChunk + 3))
+
+		entry, ok := index.Get(indexChunk + 1)
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(entry.Name).To(gomega.Equal(fmt.Sprint(indexChunk)))
+		_, ok = index.Get(0)
+		gomega.Expect(ok).To(gomega.BeFalse())
+		_, ok = index.Get(2*indexChunk + 4)
+		gomega.Expect(ok).To(gomega.BeFalse())
+
+		all := index.GetAll()
+		gomega.Expect(all).To(gomega.HaveLen(2*indexChunk + 3))
+		for i, entry := range all {
+			gomega.Expect(entry.ID).To(gomega.Equal(int64(i + 1)))
+		}
+	})
+
+	ginkgo.It("should give readers a consistent view while entries are added", func() {
+		index := NewIndex()
+		for i := range 3000 {
+			index.Add(&Entry{Name: fmt.Sprint(i), Path: fmt.Sprintf("/usr/bin/tool%d", i)})
+		}
+		assignKeys(index, nil)
+
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			for i := range 3000 {
+				index.Add(&Entry{Name: "new", Path: fmt.Sprintf("/opt/bin/new%d", i)})
+			}
+		})
+		wg.Go(func() {
+			for range 20 {
+				assignKeys(index, nil)
+			}
+		})
+		for range 4 {
+			wg.Go(func() {
+				defer ginkgo.GinkgoRecover()
+				for range 50 {
+					count := index.Count()
+					all := index.GetAll()
+					gomega.Expect(len(all)).To(gomega.BeNumerically(">=", count))
+					for i, entry := range all {
+						gomega.Expect(entry.ID).To(gomega.Equal(int64(i + 1)))
+					}
+				}
+			})
+		}
+		for range 4 {
+			wg.Go(func() {
+				defer ginkgo.GinkgoRecover()
+				for i := range 3000 {
+					entry, ok := index.Get(int64(i + 1))
+					gomega.Expect(ok).To(gomega.BeTrue())
+					gomega.Expect(entry.Name).To(gomega.Equal(fmt.Sprint(i)))
+					_, ok = index.GetByKey(fmt.Sprintf("tool%d", i))
+					gomega.Expect(ok).To(gomega.BeTrue())
+				}
+			})
+		}
+		wg.Wait()
+
+		gomega.Expect(index.Count()).To(gomega.Equal(6000))
+	})
+})
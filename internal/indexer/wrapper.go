@@ -0,0 +1,125 @@
+package indexer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// wrapperReadLimit is the most read of a file to tell if it's a trivial wrapper, longer
+// scripts aren't
+const wrapperReadLimit = 4096
+
+// shells whose scripts are inspected for an exec line
+var shells = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"dash": true,
+	"ksh":  true,
+	"mksh": true,
+	"zsh":  true,
+}
+
+// WrapperTarget returns the symlink-resolved binary a trivial wrapper script runs, or "" if
+// path isn't one. A trivial wrapper is a shell script whose only command is
+// `exec /absolute/path "$@"`; comments and blank lines are allowed around it. The target
+// isn't inspected in turn.
+func WrapperTarget(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, wrapperReadLimit+1))
+	if err != nil || len(data) > wrapperReadLimit || !bytes.HasPrefix(data, []byte("#!")) {
+		return ""
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if !isShellShebang(lines[0][2:]) {
+		return ""
+	}
+
+	var command string
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if command != "" {
+			return ""
+		}
+		command = line
+	}
+
+	target := execLineTarget(command)
+	if target == "" {
+		return ""
+	}
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return ""
+	}
+	info, err := os.Stat(resolved)
+	if err != nil || !info.Mode().IsRegular() || info.Mode()&0111 == 0 {
+		return ""
+	}
+	if self, err := filepath.EvalSymlinks(path); err == nil && self == resolved {
+		return ""
+	}
+	return resolved
+}
+
+// isShellShebang reports whether the interpreter line of a script (without "#!") runs a
+// shell, directly or through env, as in "/bin/sh -e" or "/usr/bin/env -S bash -eu"
+func isShellShebang(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		interpreter = ""
+		for _, field := range fields[1:] {
+			// Options like -S and -i, and variable assignments
+			if strings.HasPrefix(field, "-") || strings.Contains(field, "=") {
+				continue
+			}
+			interpreter = filepath.Base(field)
+			break
+		}
+	}
+	return shells[interpreter]
+}
+
+// execLineTarget returns the program of an `exec <program> "$@"` line, or "" for any other
+// line. The program has to be an absolute path without expansions, quoted or not.
+func execLineTarget(line string) string {
+	rest, ok := strings.CutPrefix(line, "exec ")
+	if !ok {
+		return ""
+	}
+	rest = strings.TrimSpace(rest)
+	for _, args := range []string{`"$@"`, `"${@}"`} {
+		if program, ok := strings.CutSuffix(rest, args); ok {
+			return programPath(strings.TrimSpace(program))
+		}
+	}
+	return ""
+}
+
+// programPath unquotes a literal absolute path, "" if it's anything else
+func programPath(word string) string {
+	if len(word) >= 2 && (word[0] == '"' || word[0] == '\'') && word[len(word)-1] == word[0] {
+		word = word[1 : len(word)-1]
+	} else if strings.ContainsAny(word, " \t") {
+		return ""
+	}
+	if word == "" || strings.ContainsAny(word, "\"'`$\\;&|<>(){}*?[]~") || !filepath.IsAbs(word) {
+		return ""
+	}
+	return word
+}
@@ -0,0 +1,165 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("WrapperTarget", func() {
+	var (
+		tmpDir string
+		target string
+	)
+
+	ginkgo.BeforeEach(func() {
+		tmpDir = ginkgo.GinkgoT().TempDir()
+		target = filepath.Join(tmpDir, "real-tool")
+		gomega.Expect(os.WriteFile(target, []byte("\x7fELF"), 0755)).To(gomega.Succeed())
+	})
+
+	script := func(content string) string {
+		path := filepath.Join(tmpDir, "wrapper")
+		gomega.Expect(os.WriteFile(path, []byte(strings.ReplaceAll(content, "TARGET", target)), 0755)).To(gomega.Succeed())
+		return path
+	}
+
+	ginkgo.DescribeTable("should follow trivial wrappers",
+		func(content string) {
+			gomega.Expect(WrapperTarget(script(content))).To(gomega.Equal(target))
+		},
+		ginkgo.Entry("sh", "#!/bin/sh\nexec TARGET \"$@\"\n"),
+		ginkgo.Entry("bash with options", "#!/bin/bash -e\nexec TARGET \"$@\"\n"),
+		ginkgo.Entry("env", "#!/usr/bin/env sh\nexec TARGET \"$@\"\n"),
+		ginkgo.Entry("env -S", "#!/usr/bin/env -S bash -eu\nexec TARGET \"$@\"\n"),
+		ginkgo.Entry("env with assignments", "#!/usr/bin/env LC_ALL=C dash\nexec TARGET \"$@\"\n"),
+		ginkgo.Entry("comments and blank lines", "#!/bin/sh\n# Generated by the packager\n\nexec TARGET \"$@\"\n# end\n"),
+		ginkgo.Entry("double quoted target", "#!/bin/sh\nexec \"TARGET\" \"$@\"\n"),
+		ginkgo.Entry("single quoted target", "#!/bin/sh\nexec 'TARGET' \"$@\"\n"),
+		ginkgo.Entry("braced arguments", "#!/bin/sh\nexec TARGET \"${@}\"\n"),
+		ginkgo.Entry("CRLF line endings", "#!/bin/sh\r\nexec TARGET \"$@\"\r\n"),
+		ginkgo.Entry("no trailing newline", "#!/bin/sh\nexec TARGET \"$@\""),
+	)
+
+	ginkgo.DescribeTable("should not follow anything else",
+		func(content string) {
+			gomega.Expect(WrapperTarget(script(content))).To(gomega.BeEmpty())
+		},
+		ginkgo.Entry("no shebang", "exec TARGET \"$@\"\n"),
+		ginkgo.Entry("empty script", "#!/bin/sh\n"),
+		ginkgo.Entry("empty shebang", "#!\nexec TARGET \"$@\"\n"),
+		ginkgo.Entry("python script", "#!/usr/bin/python3\nimport sys\nsys.exit(0)\n"),
+		ginkgo.Entry("python through env -S", "#!/usr/bin/env -S python3 -u\nexec TARGET \"$@\"\n"),
+		ginkgo.Entry("env without interpreter", "#!/usr/bin/env -S\nexec TARGET \"$@\"\n"),
+		ginkgo.Entry("more commands", "#!/bin/sh\nexport FOO=1\nexec TARGET \"$@\"\n"),
+		ginkgo.Entry("no exec", "#!/bin/sh\nTARGET \"$@\"\n"),
+		ginkgo.Entry("arguments dropped", "#!/bin/sh\nexec TARGET\n"),
+		ginkgo.Entry("unquoted arguments", "#!/bin/sh\nexec TARGET $@\n"),
+		ginkgo.Entry("extra arguments", "#!/bin/sh\nexec TARGET --verbose \"$@\"\n"),
+		ginkgo.Entry("relative target", "#!/bin/sh\nexec ./real-tool \"$@\"\n"),
+		ginkgo.Entry("bare name target", "#!/bin/sh\nexec real-tool \"$@\"\n"),
+		ginkgo.Entry("variable target", "#!/bin/sh\nexec \"$HOME/real-tool\" \"$@\"\n"),
+		ginkgo.Entry("dirname target", "#!/bin/sh\nexec \"$(dirname \"$0\")/real-tool\" \"$@\"\n"),
+		ginkgo.Entry("tilde target", "#!/bin/sh\nexec ~/real-tool \"$@\"\n"),
+		ginkgo.Entry("unbalanced quotes", "#!/bin/sh\nexec \"TARGET \"$@\"\n"),
+		ginkgo.Entry("missing target", "#!/bin/sh\nexec /nonexistent/tool \"$@\"\n"),
+		ginkgo.Entry("directory target", "#!/bin/sh\nexec /tmp \"$@\"\n"),
+		ginkgo.Entry("chained commands", "#!/bin/sh\nexec TARGET \"$@\"; echo done\n"),
+	)
+
+	ginkgo.It("should not follow a target that isn't executable", func() {
+		gomega.Expect(os.Chmod(target, 0644)).To(gomega.Succeed())
+		gomega.Expect(WrapperTarget(script("#!/bin/sh\nexec TARGET \"$@\"\n"))).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should resolve symlinked targets but not inspect them", func() {
+		next := filepath.Join(tmpDir, "next")
+		gomega.Expect(os.WriteFile(next, []byte("#!/bin/sh\nexec "+target+" \"$@\"\n"), 0755)).To(gomega.Succeed())
+		link := filepath.Join(tmpDir, "link")
+		gomega.Expect(os.Symlink(next, link)).To(gomega.Succeed())
+
+		wrapper := filepath.Join(tmpDir, "wrapper")
+		gomega.Expect(os.WriteFile(wrapper, []byte("#!/bin/sh\nexec "+link+" \"$@\"\n"), 0755)).To(gomega.Succeed())
+		gomega.Expect(WrapperTarget(wrapper)).To(gomega.Equal(next))
+	})
+
+	ginkgo.It("should not follow a wrapper execing itself", func() {
+		wrapper := filepath.Join(tmpDir, "wrapper")
+		gomega.Expect(os.WriteFile(wrapper, []byte("#!/bin/sh\nexec "+wrapper+" \"$@\"\n"), 0755)).To(gomega.Succeed())
+		gomega.Expect(WrapperTarget(wrapper)).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should skip long scripts and unreadable paths", func() {
+		content := "#!/bin/sh\n" + strings.Repeat("# padding\n", wrapperReadLimit/10) + "exec TARGET \"$@\"\n"
+		gomega.Expect(WrapperTarget(script(content))).To(gomega.BeEmpty())
+		gomega.Expect(WrapperTarget(filepath.Join(tmpDir, "missing"))).To(gomega.BeEmpty())
+		gomega.Expect(WrapperTarget(tmpDir)).To(gomega.BeEmpty())
+		gomega.Expect(WrapperTarget(target)).To(gomega.BeEmpty())
+	})
+})
+
+var _ = ginkgo.Describe("Wrapper inspection", func() {
+	var (
+		idx    *Indexer
+		binDir string
+		python string
+	)
+
+	ginkgo.BeforeEach(func() {
+		tmpDir := ginkgo.GinkgoT().TempDir()
+		binDir = filepath.Join(tmpDir, "bin")
+		libDir := filepath.Join(tmpDir, "lib")
+		gomega.Expect(os.MkdirAll(binDir, 0755)).To(gomega.Succeed())
+		gomega.Expect(os.MkdirAll(libDir, 0755)).To(gomega.Succeed())
+
+		// A packaged tool behind a wrapper, and two scripts of the same interpreter
+		tool := filepath.Join(libDir, "tool")
+		gomega.Expect(os.WriteFile(tool, []byte("\x7fELF"), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(binDir, "tool"), []byte("#!/bin/sh\nexec "+tool+" \"$@\"\n"), 0755)).To(gomega.Succeed())
+		gomega.Expect(os.Symlink(tool, filepath.Join(binDir, "tool-link"))).To(gomega.Succeed())
+		python = filepath.Join(libDir, "python3")
+		gomega.Expect(os.WriteFile(python, []byte("\x7fELF"), 0755)).To(gomega.Succeed())
+		for _, name := range []string{"pip", "pip3.12"} {
+			gomega.Expect(os.WriteFile(filepath.Join(binDir, name), []byte("#!"+python+"\nimport pip\n"), 0755)).To(gomega.Succeed())
+		}
+
+		idx = NewIndexer()
+	})
+
+	resolved := func() map[string]string {
+		result := make(map[string]string)
+		for _, entry := range idx.GetIndex().GetAll() {
+			if filepath.Dir(entry.Path) == binDir {
+				result[entry.Name] = entry.ResolvedExec
+			}
+		}
+		return result
+	}
+
+	ginkgo.It("should key wrappers on their own path by default", func() {
+		idx.inspectWrappers = false
+		_, err := idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		execs := resolved()
+		gomega.Expect(execs["tool"]).To(gomega.Equal(filepath.Join(binDir, "tool")))
+		gomega.Expect(execs["tool-link"]).To(gomega.Equal(filepath.Join(filepath.Dir(python), "tool")))
+	})
+
+	ginkgo.It("should key trivial wrappers on their target when enabled", func() {
+		idx.inspectWrappers = true
+		_, err := idx.Reindex(context.Background(), []string{binDir})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		execs := resolved()
+		gomega.Expect(execs).To(gomega.HaveLen(4))
+		gomega.Expect(execs["tool"]).To(gomega.Equal(execs["tool-link"]))
+		// Scripts sharing an interpreter stay apart
+		gomega.Expect(execs["pip"]).To(gomega.Equal(filepath.Join(binDir, "pip")))
+		gomega.Expect(execs["pip3.12"]).To(gomega.Equal(filepath.Join(binDir, "pip3.12")))
+	})
+})
@@ -0,0 +1,304 @@
+package launcher
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Mode selects how entries are started
+type Mode string
+
+const (
+	ModeExec    Mode = "exec"    // plain fork/exec, children stay in the daemon's cgroup
+	ModeSystemd Mode = "systemd" // transient scope via systemd-run, falls back to exec if unavailable
+	ModeAuto    Mode = "auto"    // systemd when a user instance is reachable, exec otherwise
+)
+
+// ErrNotFound is returned when a pid wasn't started by this launcher or has already exited
+var ErrNotFound = errors.New("process not found")
+
+// DefaultUmask is the file mode creation mask children get unless configured otherwise
+const DefaultUmask = 0o022
+
+// umaskMu serializes starts: the umask is process wide, so it's swapped around Start
+var umaskMu sync.Mutex
+
+// Process describes a running child started by the launcher
+type Process struct {
+	PID     int       // Process id (also the process group id)
+	EntryID int64     // Index id of the launched entry
+	Name    string    // Display name of the launched entry
+	Unit    string    // Transient systemd scope unit, empty for plain exec
+	Started time.Time // Launch time
+}
+
+// Launcher starts entries and keeps track of the processes it started until they exit
+type Launcher struct {
+	mode       Mode
+	systemdRun string
+	systemctl  string
+	available  func() bool
+	umask      int
+
+	mu    sync.Mutex
+	procs map[int]*Process
+}
+
+// ParseMode parses a launch mode name, an empty string means auto
+func ParseMode(s string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(s))) {
+	case "", ModeAuto:
+		return ModeAuto, nil
+	case ModeExec:
+		return ModeExec, nil
+	case ModeSystemd:
+		return ModeSystemd, nil
+	}
+	return "", fmt.Errorf("unknown launch mode %q (expected exec, systemd or auto)", s)
+}
+
+// New creates a launcher for the given mode
+func New(mode Mode) *Launcher {
+	l := &Launcher{
+		mode:       mode,
+		systemdRun: "systemd-run",
+		systemctl:  "systemctl",
+		umask:      DefaultUmask,
+		procs:      make(map[int]*Process),
+	}
+	l.available = func() bool { return systemdAvailable(l.systemdRun) }
+	return l
+}
+
+// SetUmask sets the file mode creation mask of started children
+func (l *Launcher) SetUmask(mask int) {
+	l.umask = mask & 0o777
+}
+
+// Niceness range accepted by setpriority
+const (
+	MinNice = -20
+	MaxNice = 19
+)
+
+// Options tune a single start
+type Options struct {
+	Nice *int // Niceness of the started process group, nil keeps the daemon's
+}
+
+// Start launches argv for the entry under the configured backend. The name is used for
+// the scope unit name.
+func (l *Launcher) Start(entryID int64, name string, argv []string) (*Process, error) {
+	return l.StartWith(entryID, name, argv, Options{})
+}
+
+// StartWith is Start with per-launch options. A niceness the platform or the daemon's
+// privileges don't allow is logged and otherwise ignored.
+func (l *Launcher) StartWith(entryID int64, name string, argv []string, opts Options) (*Process, error) {
+	if len(argv) == 0 || argv[0] == "" {
+		return nil, errors.New("empty command")
+	}
+	if opts.Nice != nil && (*opts.Nice < MinNice || *opts.Nice > MaxNice) {
+		return nil, fmt.Errorf("nice %d out of range %d..%d", *opts.Nice, MinNice, MaxNice)
+	}
+
+	var unit string
+	args := argv
+	if l.useSystemd() {
+		unit = UnitName(name)
+		args = l.scopeCommand(unit, argv)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	// Detach the process from the parent session to prevent terminal blocking
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	// The child gets stdin/stdout/stderr (/dev/null) and nothing else
+	cmd.ExtraFiles = nil
+	if err := l.start(cmd); err != nil {
+		return nil, err
+	}
+
+	if opts.Nice != nil {
+		if err := setNice(cmd.Process.Pid, *opts.Nice); err != nil {
+			log.Printf("[WARN] Failed to set nice %d for process %d: %v", *opts.Nice, cmd.Process.Pid, err)
+		}
+	}
+
+	proc := &Process{
+		PID:     cmd.Process.Pid,
+		EntryID: entryID,
+		Name:    name,
+		Unit:    unit,
+		Started: time.Now(),
+	}
+	l.mu.Lock()
+	l.procs[proc.PID] = proc
+	l.mu.Unlock()
+
+	// Reap the child when it exits so it doesn't linger as a zombie
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("[DEBUG] Process %d exited: %v", proc.PID, err)
+		}
+		l.mu.Lock()
+		delete(l.procs, proc.PID)
+		l.mu.Unlock()
+	}()
+
+	return proc, nil
+}
+
+// start runs cmd with the configured umask and without inheriting any descriptors besides
+// the standard ones
+func (l *Launcher) start(cmd *exec.Cmd) error {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+
+	closeOnExec()
+	old := syscall.Umask(l.umask)
+	defer syscall.Umask(old)
+	return cmd.Start()
+}
+
+// closeOnExec marks every open descriptor above stderr close-on-exec. Files and sockets
+// opened through Go already are, this catches descriptors inherited by the daemon itself.
+func closeOnExec() {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		log.Printf("[WARN] Failed to list open descriptors: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if fd, err := strconv.Atoi(entry.Name()); err == nil && fd > 2 {
+			syscall.CloseOnExec(fd)
+		}
+	}
+}
+
+// Get returns a running process started by this launcher
+func (l *Launcher) Get(pid int) (*Process, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	proc, ok := l.procs[pid]
+	return proc, ok
+}
+
+// Running returns the processes started by this launcher that haven't exited yet, oldest
+// first
+func (l *Launcher) Running() []*Process {
+	l.mu.Lock()
+	procs := make([]*Process, 0, len(l.procs))
+	for _, proc := range l.procs {
+		procs = append(procs, proc)
+	}
+	l.mu.Unlock()
+
+	slices.SortFunc(procs, func(a, b *Process) int {
+		if c := a.Started.Compare(b.Started); c != 0 {
+			return c
+		}
+		return a.PID - b.PID
+	})
+	return procs
+}
+
+// Kill stops a process started by this launcher. Processes running in a systemd scope are
+// stopped through the scope so helpers they spawned go away too, the rest get SIGTERM sent
+// to their process group.
+func (l *Launcher) Kill(pid int) (*Process, error) {
+	l.mu.Lock()
+	proc, ok := l.procs[pid]
+	l.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if proc.Unit != "" {
+		out, err := exec.Command(l.systemctl, "--user", "stop", proc.Unit).CombinedOutput()
+		if err != nil {
+			return proc, fmt.Errorf("failed to stop %s: %w: %s", proc.Unit, err, strings.TrimSpace(string(out)))
+		}
+		return proc, nil
+	}
+
+	if err := syscall.Kill(-proc.PID, syscall.SIGTERM); err != nil {
+		return proc, fmt.Errorf("failed to signal process group %d: %w", proc.PID, err)
+	}
+	return proc, nil
+}
+
+func (l *Launcher) useSystemd() bool {
+	switch l.mode {
+	case ModeSystemd:
+		if l.available() {
+			return true
+		}
+		log.Printf("[WARN] systemd launch requested but no user systemd instance is reachable, using exec")
+		return false
+	case ModeAuto:
+		return l.available()
+	}
+	return false
+}
+
+// scopeCommand wraps argv into a systemd-run invocation placing it in its own scope
+func (l *Launcher) scopeCommand(unit string, argv []string) []string {
+	args := []string{
+		l.systemdRun,
+		"--user",
+		"--scope",
+		"--slice=app.slice",
+		"--unit=" + unit,
+		"--",
+	}
+	return append(args, argv...)
+}
+
+// UnitName builds a unique scope unit name for an entry: app-ade-<name>-<rand>.scope
+func UnitName(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_':
+			sb.WriteRune(r)
+		default:
+			// "-" separates unit name components, so it's escaped along with everything else
+			sb.WriteByte('_')
+		}
+	}
+	escaped := strings.Trim(sb.String(), "_")
+	if escaped == "" {
+		escaped = "app"
+	}
+
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	return fmt.Sprintf("app-ade-%s-%s.scope", escaped, hex.EncodeToString(buf))
+}
+
+// systemdAvailable reports whether systemd-run exists and a user manager is listening
+func systemdAvailable(systemdRun string) bool {
+	if _, err := exec.LookPath(systemdRun); err != nil {
+		return false
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(runtimeDir, "systemd", "private"))
+	return err == nil
+}
@@ -0,0 +1,276 @@
+package launcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseMode", func() {
+	It("should default to auto", func() {
+		Expect(ParseMode("")).To(Equal(ModeAuto))
+	})
+
+	It("should accept known modes case-insensitively", func() {
+		Expect(ParseMode("exec")).To(Equal(ModeExec))
+		Expect(ParseMode("Systemd")).To(Equal(ModeSystemd))
+		Expect(ParseMode(" auto ")).To(Equal(ModeAuto))
+	})
+
+	It("should reject unknown modes", func() {
+		_, err := ParseMode("docker")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("UnitName", func() {
+	It("should build an app scope name with a random suffix", func() {
+		Expect(UnitName("firefox")).To(MatchRegexp(`^app-ade-firefox-[0-9a-f]{8}\.scope$`))
+	})
+
+	It("should escape characters that are not valid in unit names", func() {
+		Expect(UnitName("Web-Browser (Beta)")).To(MatchRegexp(`^app-ade-web_browser__beta-[0-9a-f]{8}\.scope$`))
+	})
+
+	It("should fall back to a generic name", func() {
+		Expect(UnitName("Ж")).To(MatchRegexp(`^app-ade-app-[0-9a-f]{8}\.scope$`))
+	})
+
+	It("should not repeat", func() {
+		Expect(UnitName("x")).NotTo(Equal(UnitName("x")))
+	})
+})
+
+var _ = Describe("Launcher", func() {
+	var (
+		stubDir    string
+		runLog     string
+		stopLog    string
+		l          *Launcher
+		systemdUp  bool
+		startedPid int
+	)
+
+	BeforeEach(func() {
+		var err error
+		stubDir, err = os.MkdirTemp("", "ade-launcher-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		runLog = filepath.Join(stubDir, "systemd-run.log")
+		stopLog = filepath.Join(stubDir, "systemctl.log")
+
+		// Fake systemd-run: record the arguments and exec what follows "--"
+		runStub := fmt.Sprintf("#!/bin/sh\nprintf '%%s\\n' \"$@\" > %s\nwhile [ \"$1\" != \"--\" ]; do shift; done\nshift\nexec \"$@\"\n", runLog)
+		Expect(os.WriteFile(filepath.Join(stubDir, "systemd-run"), []byte(runStub), 0755)).To(Succeed())
+		stopStub := fmt.Sprintf("#!/bin/sh\nprintf '%%s\\n' \"$@\" > %s\n", stopLog)
+		Expect(os.WriteFile(filepath.Join(stubDir, "systemctl"), []byte(stopStub), 0755)).To(Succeed())
+
+		systemdUp = true
+		startedPid = 0
+	})
+
+	JustBeforeEach(func() {
+		l.systemdRun = filepath.Join(stubDir, "systemd-run")
+		l.systemctl = filepath.Join(stubDir, "systemctl")
+		l.available = func() bool { return systemdUp }
+	})
+
+	AfterEach(func() {
+		if startedPid > 0 {
+			syscall.Kill(-startedPid, syscall.SIGKILL)
+		}
+		os.RemoveAll(stubDir)
+	})
+
+	readLines := func(path string) func() []string {
+		return func() []string {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			return strings.Split(strings.TrimSpace(string(data)), "\n")
+		}
+	}
+
+	Context("in auto mode with a reachable user instance", func() {
+		BeforeEach(func() {
+			l = New(ModeAuto)
+		})
+
+		It("should start the command inside a transient scope", func() {
+			proc, err := l.Start(1, "Stub App", []string{"/bin/sh", "-c", "exit 0"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proc.Unit).To(MatchRegexp(`^app-ade-stub_app-[0-9a-f]{8}\.scope$`))
+
+			Eventually(readLines(runLog)).Should(Equal([]string{
+				"--user", "--scope", "--slice=app.slice", "--unit=" + proc.Unit, "--", "/bin/sh", "-c", "exit 0",
+			}))
+		})
+
+		It("should stop the scope on kill", func() {
+			proc, err := l.Start(1, "sleeper", []string{"sleep", "5"})
+			Expect(err).NotTo(HaveOccurred())
+			startedPid = proc.PID
+
+			killed, err := l.Kill(proc.PID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(killed.Unit).To(Equal(proc.Unit))
+			Expect(readLines(stopLog)()).To(Equal([]string{"--user", "stop", proc.Unit}))
+		})
+	})
+
+	Context("in auto mode without systemd", func() {
+		BeforeEach(func() {
+			l = New(ModeAuto)
+			systemdUp = false
+		})
+
+		It("should fall back to plain exec", func() {
+			proc, err := l.Start(1, "plain", []string{"/bin/sh", "-c", "exit 0"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proc.Unit).To(BeEmpty())
+			Consistently(runLog, 100*time.Millisecond).ShouldNot(BeAnExistingFile())
+		})
+
+		It("should signal the process group on kill", func() {
+			proc, err := l.Start(1, "sleeper", []string{"sleep", "5"})
+			Expect(err).NotTo(HaveOccurred())
+			startedPid = proc.PID
+
+			_, err = l.Kill(proc.PID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stopLog).NotTo(BeAnExistingFile())
+
+			// The reaper drops the process from the table once it exits
+			Eventually(func() error {
+				_, err := l.Kill(proc.PID)
+				return err
+			}).Should(MatchError(ErrNotFound))
+		})
+	})
+
+	Context("in systemd mode without systemd", func() {
+		BeforeEach(func() {
+			l = New(ModeSystemd)
+			systemdUp = false
+		})
+
+		It("should fall back to plain exec", func() {
+			proc, err := l.Start(1, "plain", []string{"/bin/sh", "-c", "exit 0"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proc.Unit).To(BeEmpty())
+		})
+	})
+
+	Context("in exec mode", func() {
+		BeforeEach(func() {
+			l = New(ModeExec)
+		})
+
+		It("should never use systemd-run", func() {
+			proc, err := l.Start(1, "plain", []string{"/bin/sh", "-c", "exit 0"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proc.Unit).To(BeEmpty())
+			Consistently(runLog, 100*time.Millisecond).ShouldNot(BeAnExistingFile())
+		})
+
+		It("should reject an empty command", func() {
+			_, err := l.Start(1, "empty", nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should track running processes by pid", func() {
+			proc, err := l.Start(42, "sleeper", []string{"sleep", "5"})
+			Expect(err).NotTo(HaveOccurred())
+			startedPid = proc.PID
+
+			found, ok := l.Get(proc.PID)
+			Expect(ok).To(BeTrue())
+			Expect(found.EntryID).To(Equal(int64(42)))
+			Expect(found.Name).To(Equal("sleeper"))
+		})
+
+		It("should list running children until they exit", func() {
+			sleeper, err := l.Start(1, "sleeper", []string{"sleep", "5"})
+			Expect(err).NotTo(HaveOccurred())
+			startedPid = sleeper.PID
+			quick, err := l.Start(2, "quick", []string{"/bin/sh", "-c", "exit 0"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(l.Running).Should(Equal([]*Process{sleeper}))
+			Expect(quick.PID).NotTo(Equal(sleeper.PID))
+		})
+
+		It("should start children with only the standard descriptors and the configured umask", func() {
+			// A descriptor the daemon could have inherited without close-on-exec
+			leaked, err := syscall.Open(filepath.Join(stubDir, "leaked"), syscall.O_CREAT|syscall.O_RDWR, 0600)
+			Expect(err).NotTo(HaveOccurred())
+			defer syscall.Close(leaked)
+
+			oldMask := syscall.Umask(0o077)
+			defer syscall.Umask(oldMask)
+
+			out := filepath.Join(stubDir, "fds")
+			// ls runs in its own process, so it lists the shell's descriptors without its own
+			script := `exec > "$1.tmp"; ls /proc/$$/fd; umask; mv "$1.tmp" "$1"`
+			l.SetUmask(0o022)
+			_, err = l.Start(1, "fds", []string{"/bin/sh", "-c", script, "sh", out})
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(out).Should(BeAnExistingFile())
+			Expect(readLines(out)()).To(Equal([]string{"0", "1", "2", "0022"}))
+
+			// The daemon's own umask is back once the child started
+			Expect(syscall.Umask(0o077)).To(Equal(0o077))
+		})
+
+		It("should start the process group with the requested niceness", func() {
+			if runtime.GOOS != "linux" {
+				Skip("niceness is only applied on Linux")
+			}
+			nice := 10
+			proc, err := l.StartWith(1, "sleeper", []string{"sleep", "5"}, Options{Nice: &nice})
+			Expect(err).NotTo(HaveOccurred())
+			startedPid = proc.PID
+
+			// Field 19 of /proc/<pid>/stat, the 17th after the parenthesized command name
+			Eventually(func() string {
+				data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", proc.PID))
+				if err != nil {
+					return ""
+				}
+				fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+				return fields[16]
+			}).Should(Equal("10"))
+		})
+
+		It("should reject a niceness out of range", func() {
+			nice := 20
+			_, err := l.StartWith(1, "sleeper", []string{"sleep", "5"}, Options{Nice: &nice})
+			Expect(err).To(MatchError(ContainSubstring("out of range")))
+		})
+
+		It("should not kill processes it didn't start", func() {
+			_, err := l.Kill(os.Getpid())
+			Expect(err).To(MatchError(ErrNotFound))
+		})
+	})
+})
+
+var _ = Describe("Launcher with a real user systemd", func() {
+	It("should run an entry in a scope", func() {
+		if !systemdAvailable("systemd-run") {
+			Skip("no user systemd instance reachable")
+		}
+		l := New(ModeSystemd)
+		proc, err := l.Start(1, "ade-launcher-test", []string{"true"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proc.Unit).NotTo(BeEmpty())
+	})
+})
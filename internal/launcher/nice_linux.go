@@ -0,0 +1,11 @@
+//go:build linux
+
+package launcher
+
+import "syscall"
+
+// setNice sets the niceness of the process group led by pid, so children the process
+// already forked get it too
+func setNice(pid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PGRP, pid, nice)
+}
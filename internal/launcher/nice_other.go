@@ -0,0 +1,9 @@
+//go:build !linux
+
+package launcher
+
+// setNice is a no-op where setpriority isn't wired up, the process keeps the daemon's
+// niceness
+func setNice(pid, nice int) error {
+	return nil
+}
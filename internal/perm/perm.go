@@ -0,0 +1,54 @@
+// Package perm is the permission policy of what the daemon keeps on disk: the run index
+// database and the cache directory holding it, the rc files it creates, and the socket of a
+// user daemon and its directory. Files get the mode of ADE_INDEXD_FILE_MODE, directories
+// the same with the search bit of every class that can read. The owner always reads and
+// writes, and nothing is ever writable by group or others.
+package perm
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+// DefaultFile is the mode of files unless configured otherwise
+const DefaultFile os.FileMode = 0o600
+
+var file atomic.Uint32
+
+func init() {
+	file.Store(uint32(DefaultFile))
+}
+
+// Set sets the mode of files, and so that of directories. Bits granting execution, or
+// writing to group or others, are dropped; reading and writing by the owner are added.
+func Set(mode os.FileMode) {
+	file.Store(uint32(mode&0o644 | 0o600))
+}
+
+// File returns the mode files are created with
+func File() os.FileMode {
+	return os.FileMode(file.Load())
+}
+
+// Dir returns the mode directories are created with
+func Dir() os.FileMode {
+	mode := File()
+	// Every class that can read a file can list and enter its directory
+	return mode | (mode&0o444)>>2
+}
+
+// MkdirAll creates the directory path and its missing parents with the mode of directories,
+// and sets that of path itself if it already exists: parents may be shared, like the cache
+// directory of the user, path is the daemon's own.
+func MkdirAll(path string) error {
+	if err := os.MkdirAll(path, Dir()); err != nil {
+		return err
+	}
+	return os.Chmod(path, Dir())
+}
+
+// Apply sets the mode of the file at path, whatever the umask made it or an earlier
+// version created it with
+func Apply(path string) error {
+	return os.Chmod(path, File())
+}
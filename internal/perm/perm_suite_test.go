@@ -0,0 +1,13 @@
+package perm
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPerm(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Perm Suite")
+}
@@ -0,0 +1,57 @@
+package perm
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("policy", func() {
+	AfterEach(func() {
+		Set(DefaultFile)
+	})
+
+	mode := func(path string) os.FileMode {
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		return info.Mode().Perm()
+	}
+
+	It("should keep files and directories private by default", func() {
+		Expect(File()).To(Equal(os.FileMode(0o600)))
+		Expect(Dir()).To(Equal(os.FileMode(0o700)))
+	})
+
+	It("should derive the mode of directories from that of files", func() {
+		Set(0o640)
+		Expect(File()).To(Equal(os.FileMode(0o640)))
+		Expect(Dir()).To(Equal(os.FileMode(0o750)))
+		Set(0o644)
+		Expect(Dir()).To(Equal(os.FileMode(0o755)))
+	})
+
+	It("should never let group or others write nor drop the owner", func() {
+		Set(0o777)
+		Expect(File()).To(Equal(os.FileMode(0o644)))
+		Set(0o040)
+		Expect(File()).To(Equal(os.FileMode(0o640)))
+	})
+
+	It("should create and tighten what it's given", func() {
+		Set(0o640)
+		dir := filepath.Join(GinkgoT().TempDir(), "cache", "ade")
+		Expect(MkdirAll(dir)).To(Succeed())
+		Expect(mode(dir)).To(Equal(os.FileMode(0o750)))
+
+		Set(DefaultFile)
+		Expect(MkdirAll(dir)).To(Succeed())
+		Expect(mode(dir)).To(Equal(os.FileMode(0o700)))
+
+		file := filepath.Join(dir, "db")
+		Expect(os.WriteFile(file, nil, 0o644)).To(Succeed())
+		Expect(Apply(file)).To(Succeed())
+		Expect(mode(file)).To(Equal(os.FileMode(0o600)))
+	})
+})
@@ -0,0 +1,311 @@
+// Package recent records opened files in the freedesktop recently used store
+// (recently-used.xbel), so "recent" menus of other applications show files launched
+// through the daemon.
+package recent
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	fileName = "recently-used.xbel"
+
+	nsBookmark = "http://www.freedesktop.org/standards/desktop-bookmarks"
+	nsMime     = "http://www.freedesktop.org/standards/shared-mime-info"
+	// Metadata owned by anybody else is kept as is.
+	metadataOwner = "http://freedesktop.org"
+
+	// Timestamp format used by GLib's bookmark file implementation.
+	timeFormat = "2006-01-02T15:04:05.000000Z"
+)
+
+// Item is one file opened by an application.
+type Item struct {
+	File string    // Path of the opened file
+	App  string    // Application name
+	Exec string    // Command line registered for the application, %u stands for the file
+	Time time.Time // When the file was opened
+}
+
+// DefaultPath returns $XDG_DATA_HOME/recently-used.xbel, falling back to ~/.local/share.
+func DefaultPath() (string, error) {
+	if dataHome := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dataHome) {
+		return filepath.Join(dataHome, fileName), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "share", fileName), nil
+}
+
+// Add records item in the store at path, creating the store if it doesn't exist. A file
+// that's already listed gets its timestamps bumped and the application's count increased.
+func Add(path string, item Item) error {
+	abs, err := filepath.Abs(item.File)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", item.File, err)
+	}
+	href := (&url.URL{Scheme: "file", Path: abs}).String()
+
+	doc, err := Load(path)
+	if err != nil {
+		return err
+	}
+
+	stamp := item.Time.UTC().Format(timeFormat)
+	bm := doc.find(href)
+	if bm == nil {
+		doc.Bookmarks = append(doc.Bookmarks, Bookmark{Href: href, Added: stamp})
+		bm = &doc.Bookmarks[len(doc.Bookmarks)-1]
+	}
+	bm.Modified = stamp
+	bm.Visited = stamp
+
+	meta := bm.metadata()
+	if meta.MimeType == nil {
+		meta.MimeType = &mimeType{Type: mimeTypeOf(abs)}
+	}
+	app := meta.application(item.App)
+	if app == nil {
+		meta.Applications = append(meta.Applications, Application{Name: item.App, Exec: "'" + item.Exec + "'"})
+		app = &meta.Applications[len(meta.Applications)-1]
+	}
+	app.Modified = stamp
+	app.Count++
+
+	return save(path, doc)
+}
+
+// Load reads the store at path. A missing store is an empty one.
+func Load(path string) (*XBEL, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &XBEL{Version: "1.0"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var doc XBEL
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &doc, nil
+}
+
+// save writes doc next to path and renames it into place, so readers never see a
+// half-written store.
+func save(path string, doc *XBEL) error {
+	var buf bytes.Buffer
+	doc.write(&buf)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+fileName+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+func mimeTypeOf(path string) string {
+	if typ := mime.TypeByExtension(filepath.Ext(path)); typ != "" {
+		// Parameters such as charset don't belong in the store
+		typ, _, _ = strings.Cut(typ, ";")
+		return typ
+	}
+	return "application/octet-stream"
+}
+
+// XBEL is the recently used store document.
+type XBEL struct {
+	Version   string     `xml:"version,attr"`
+	Bookmarks []Bookmark `xml:"bookmark"`
+}
+
+// Bookmark returns the bookmark of an absolute file path, nil if it's not listed.
+func (x *XBEL) Bookmark(path string) *Bookmark {
+	return x.find((&url.URL{Scheme: "file", Path: path}).String())
+}
+
+func (x *XBEL) find(href string) *Bookmark {
+	for i := range x.Bookmarks {
+		if x.Bookmarks[i].Href == href {
+			return &x.Bookmarks[i]
+		}
+	}
+	return nil
+}
+
+// Bookmark is one file listed in the store.
+type Bookmark struct {
+	Href     string     `xml:"href,attr"`
+	Added    string     `xml:"added,attr,omitempty"`
+	Modified string     `xml:"modified,attr,omitempty"`
+	Visited  string     `xml:"visited,attr,omitempty"`
+	Title    string     `xml:"title,omitempty"`
+	Desc     string     `xml:"desc,omitempty"`
+	Metadata []Metadata `xml:"info>metadata"`
+}
+
+// metadata returns the freedesktop metadata block, adding it if missing.
+func (b *Bookmark) metadata() *Metadata {
+	for i := range b.Metadata {
+		if b.Metadata[i].Owner == metadataOwner {
+			return &b.Metadata[i]
+		}
+	}
+	b.Metadata = append(b.Metadata, Metadata{Owner: metadataOwner})
+	return &b.Metadata[len(b.Metadata)-1]
+}
+
+// Application returns the registration of the named application, nil if there's none.
+func (b *Bookmark) Application(name string) *Application {
+	for i := range b.Metadata {
+		if b.Metadata[i].Owner == metadataOwner {
+			return b.Metadata[i].application(name)
+		}
+	}
+	return nil
+}
+
+// Metadata holds the parsed freedesktop fields. Blocks of other owners are only kept
+// as raw XML.
+type Metadata struct {
+	Owner        string        `xml:"owner,attr"`
+	MimeType     *mimeType     `xml:"http://www.freedesktop.org/standards/shared-mime-info mime-type"`
+	Groups       []string      `xml:"http://www.freedesktop.org/standards/desktop-bookmarks groups>group"`
+	Applications []Application `xml:"http://www.freedesktop.org/standards/desktop-bookmarks applications>application"`
+	Private      *struct{}     `xml:"http://www.freedesktop.org/standards/desktop-bookmarks private"`
+	Icon         *icon         `xml:"http://www.freedesktop.org/standards/desktop-bookmarks icon"`
+	Raw          string        `xml:",innerxml"`
+}
+
+func (m *Metadata) application(name string) *Application {
+	for i := range m.Applications {
+		if m.Applications[i].Name == name {
+			return &m.Applications[i]
+		}
+	}
+	return nil
+}
+
+type mimeType struct {
+	Type string `xml:"type,attr"`
+}
+
+type icon struct {
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// Application is an application that opened a bookmarked file.
+type Application struct {
+	Name     string `xml:"name,attr"`
+	Exec     string `xml:"exec,attr"`
+	Modified string `xml:"modified,attr"`
+	Count    int    `xml:"count,attr"`
+}
+
+// write serializes the document. encoding/xml can't emit prefixed namespaces, so the
+// markup is written by hand with every value escaped.
+func (x *XBEL) write(w io.Writer) {
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(w, "<xbel version=\"%s\"\n      xmlns:bookmark=\"%s\"\n      xmlns:mime=\"%s\"\n>\n",
+		escape(x.Version), nsBookmark, nsMime)
+
+	for _, b := range x.Bookmarks {
+		fmt.Fprintf(w, "  <bookmark href=\"%s\"", escape(b.Href))
+		for _, attr := range [][2]string{{"added", b.Added}, {"modified", b.Modified}, {"visited", b.Visited}} {
+			if attr[1] != "" {
+				fmt.Fprintf(w, " %s=\"%s\"", attr[0], escape(attr[1]))
+			}
+		}
+		fmt.Fprintf(w, ">\n")
+		if b.Title != "" {
+			fmt.Fprintf(w, "    <title>%s</title>\n", escape(b.Title))
+		}
+		if b.Desc != "" {
+			fmt.Fprintf(w, "    <desc>%s</desc>\n", escape(b.Desc))
+		}
+		if len(b.Metadata) > 0 {
+			fmt.Fprintf(w, "    <info>\n")
+			for _, m := range b.Metadata {
+				m.write(w)
+			}
+			fmt.Fprintf(w, "    </info>\n")
+		}
+		fmt.Fprintf(w, "  </bookmark>\n")
+	}
+	fmt.Fprintf(w, "</xbel>\n")
+}
+
+func (m *Metadata) write(w io.Writer) {
+	fmt.Fprintf(w, "      <metadata owner=\"%s\">", escape(m.Owner))
+	if m.Owner != metadataOwner {
+		fmt.Fprintf(w, "%s</metadata>\n", m.Raw)
+		return
+	}
+	fmt.Fprintf(w, "\n")
+
+	if m.MimeType != nil {
+		fmt.Fprintf(w, "        <mime:mime-type type=\"%s\"/>\n", escape(m.MimeType.Type))
+	}
+	if len(m.Groups) > 0 {
+		fmt.Fprintf(w, "        <bookmark:groups>\n")
+		for _, g := range m.Groups {
+			fmt.Fprintf(w, "          <bookmark:group>%s</bookmark:group>\n", escape(g))
+		}
+		fmt.Fprintf(w, "        </bookmark:groups>\n")
+	}
+	if len(m.Applications) > 0 {
+		fmt.Fprintf(w, "        <bookmark:applications>\n")
+		for _, a := range m.Applications {
+			fmt.Fprintf(w, "          <bookmark:application name=\"%s\" exec=\"%s\" modified=\"%s\" count=\"%d\"/>\n",
+				escape(a.Name), escape(a.Exec), escape(a.Modified), a.Count)
+		}
+		fmt.Fprintf(w, "        </bookmark:applications>\n")
+	}
+	if m.Private != nil {
+		fmt.Fprintf(w, "        <bookmark:private/>\n")
+	}
+	if m.Icon != nil {
+		fmt.Fprintf(w, "        <bookmark:icon href=\"%s\"", escape(m.Icon.Href))
+		if m.Icon.Type != "" {
+			fmt.Fprintf(w, " type=\"%s\"", escape(m.Icon.Type))
+		}
+		fmt.Fprintf(w, "/>\n")
+	}
+	fmt.Fprintf(w, "      </metadata>\n")
+}
+
+// escape escapes s for use in text and attribute values.
+func escape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
@@ -0,0 +1,13 @@
+package recent
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRecent(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Recent Suite")
+}
@@ -0,0 +1,123 @@
+package recent
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Add", func() {
+	var (
+		tmpDir string
+		store  string
+		opened time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-recent-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		store = filepath.Join(tmpDir, "share", "recently-used.xbel")
+		opened = time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("should write an entry that reads back", func() {
+		file := filepath.Join(tmpDir, "notes & <draft>.txt")
+		Expect(Add(store, Item{File: file, App: "gedit", Exec: "gedit %u", Time: opened})).To(Succeed())
+
+		doc, err := Load(store)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(doc.Bookmarks).To(HaveLen(1))
+
+		bm := doc.Bookmark(file)
+		Expect(bm).NotTo(BeNil())
+		Expect(bm.Href).To(Equal("file://" + filepath.ToSlash(tmpDir) + "/notes%20&%20%3Cdraft%3E.txt"))
+		Expect(bm.Added).To(Equal("2024-05-17T10:30:00.000000Z"))
+		Expect(bm.Visited).To(Equal(bm.Added))
+		Expect(bm.Metadata).To(HaveLen(1))
+		Expect(bm.Metadata[0].MimeType.Type).To(Equal("text/plain"))
+
+		app := bm.Application("gedit")
+		Expect(app).NotTo(BeNil())
+		Expect(app.Exec).To(Equal("'gedit %u'"))
+		Expect(app.Count).To(Equal(1))
+	})
+
+	It("should bump an entry opened again", func() {
+		file := filepath.Join(tmpDir, "photo.png")
+		Expect(Add(store, Item{File: file, App: "gimp", Exec: "gimp %U", Time: opened})).To(Succeed())
+		Expect(Add(store, Item{File: file, App: "gimp", Exec: "gimp %U", Time: opened.Add(time.Hour)})).To(Succeed())
+		Expect(Add(store, Item{File: file, App: "eog", Exec: "eog %U", Time: opened.Add(2 * time.Hour)})).To(Succeed())
+
+		doc, err := Load(store)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(doc.Bookmarks).To(HaveLen(1))
+		bm := doc.Bookmark(file)
+		Expect(bm.Added).To(Equal("2024-05-17T10:30:00.000000Z"))
+		Expect(bm.Modified).To(Equal("2024-05-17T12:30:00.000000Z"))
+		Expect(bm.Application("gimp").Count).To(Equal(2))
+		Expect(bm.Application("gimp").Modified).To(Equal("2024-05-17T11:30:00.000000Z"))
+		Expect(bm.Application("eog").Count).To(Equal(1))
+	})
+
+	It("should keep entries written by other applications", func() {
+		Expect(os.MkdirAll(filepath.Dir(store), 0700)).To(Succeed())
+		existing := `<?xml version="1.0" encoding="UTF-8"?>
+<xbel version="1.0"
+      xmlns:bookmark="http://www.freedesktop.org/standards/desktop-bookmarks"
+      xmlns:mime="http://www.freedesktop.org/standards/shared-mime-info"
+>
+  <bookmark href="file:///home/user/report.pdf" added="2024-01-02T03:04:05.123456Z" modified="2024-01-02T03:04:05.123456Z" visited="2024-01-02T03:04:05.123456Z">
+    <title>Q1 report</title>
+    <info>
+      <metadata owner="http://freedesktop.org">
+        <mime:mime-type type="application/pdf"/>
+        <bookmark:groups>
+          <bookmark:group>Documents</bookmark:group>
+        </bookmark:groups>
+        <bookmark:applications>
+          <bookmark:application name="evince" exec="&apos;evince %u&apos;" modified="2024-01-02T03:04:05.123456Z" count="3"/>
+        </bookmark:applications>
+        <bookmark:private/>
+      </metadata>
+    </info>
+  </bookmark>
+</xbel>
+`
+		Expect(os.WriteFile(store, []byte(existing), 0600)).To(Succeed())
+
+		Expect(Add(store, Item{File: filepath.Join(tmpDir, "a.txt"), App: "vim", Exec: "vim %f", Time: opened})).To(Succeed())
+
+		doc, err := Load(store)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(doc.Bookmarks).To(HaveLen(2))
+
+		bm := doc.Bookmark("/home/user/report.pdf")
+		Expect(bm).NotTo(BeNil())
+		Expect(bm.Title).To(Equal("Q1 report"))
+		Expect(bm.Added).To(Equal("2024-01-02T03:04:05.123456Z"))
+		meta := bm.Metadata[0]
+		Expect(meta.MimeType.Type).To(Equal("application/pdf"))
+		Expect(meta.Groups).To(Equal([]string{"Documents"}))
+		Expect(meta.Private).NotTo(BeNil())
+		Expect(bm.Application("evince").Exec).To(Equal("'evince %u'"))
+		Expect(bm.Application("evince").Count).To(Equal(3))
+	})
+
+	It("should refuse to overwrite a store it can't parse", func() {
+		Expect(os.MkdirAll(filepath.Dir(store), 0700)).To(Succeed())
+		Expect(os.WriteFile(store, []byte("<xbel><bookmark"), 0600)).To(Succeed())
+
+		Expect(Add(store, Item{File: "/tmp/a.txt", App: "vim", Exec: "vim %f", Time: opened})).NotTo(Succeed())
+		data, err := os.ReadFile(store)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("<xbel><bookmark"))
+	})
+})
@@ -0,0 +1,72 @@
+// Package redact keeps what users search for and launch out of the daemon's log. Strings
+// from clients, entry names and command lines are logged as their length and a short hash
+// unless sensitive logging is enabled. Equal strings get equal hashes, so log lines can
+// still be matched up, but the hash key is drawn anew on every start.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	sensitive atomic.Bool
+	key       = newKey()
+)
+
+func newKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// SetSensitive turns logging of the strings themselves on or off
+func SetSensitive(on bool) {
+	sensitive.Store(on)
+}
+
+// Sensitive reports whether strings are logged as they are
+func Sensitive() bool {
+	return sensitive.Load()
+}
+
+// String returns s as it may be logged: s itself with sensitive logging, otherwise
+// "<n bytes #hash>"
+func String(s string) string {
+	if sensitive.Load() {
+		return s
+	}
+	return redacted(s)
+}
+
+// Quote is String for values logged quoted, like %q does
+func Quote(s string) string {
+	if sensitive.Load() {
+		return strconv.Quote(s)
+	}
+	return redacted(s)
+}
+
+// Strings returns values as they may be logged, formatted like %v does: "[a b]"
+func Strings(values []string) string {
+	if sensitive.Load() {
+		return fmt.Sprint(values)
+	}
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = redacted(value)
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func redacted(s string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return fmt.Sprintf("<%d bytes #%s>", len(s), hex.EncodeToString(mac.Sum(nil)[:4]))
+}
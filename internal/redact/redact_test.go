@@ -0,0 +1,45 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("redaction", func() {
+	AfterEach(func() {
+		SetSensitive(false)
+	})
+
+	It("should log the length and a hash by default", func() {
+		Expect(Sensitive()).To(BeFalse())
+		Expect(String("firefox")).To(MatchRegexp(`^<7 bytes #[0-9a-f]{8}>$`))
+		Expect(Quote("firefox")).To(Equal(String("firefox")))
+		Expect(String("")).To(MatchRegexp(`^<0 bytes #[0-9a-f]{8}>$`))
+	})
+
+	It("should hash equal strings alike and different ones apart", func() {
+		Expect(String("firefox")).To(Equal(String("firefox")))
+		Expect(String("firefox")).NotTo(Equal(String("firefoy")))
+	})
+
+	It("should redact every value of a list", func() {
+		Expect(Strings([]string{"fi", "fox"})).To(Equal("[" + String("fi") + " " + String("fox") + "]"))
+		Expect(Strings(nil)).To(Equal("[]"))
+	})
+
+	It("should not be a plain digest of the string", func() {
+		// A plain digest of a short query could be looked up in a table
+		sum := sha256.Sum256([]byte("firefox"))
+		Expect(String("firefox")).NotTo(ContainSubstring("#" + hex.EncodeToString(sum[:4])))
+	})
+
+	It("should log the strings themselves when sensitive logging is on", func() {
+		SetSensitive(true)
+		Expect(String("firefox")).To(Equal("firefox"))
+		Expect(Quote("fire fox")).To(Equal(`"fire fox"`))
+		Expect(Strings([]string{"fi", "fox"})).To(Equal("[fi fox]"))
+	})
+})
@@ -0,0 +1,170 @@
+// Package retention ages out and size-caps the log files the daemon keeps in its cache
+// directory: the audit log segments and the per-application launch logs. It only ever
+// removes regular files below the log directory it is given.
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Subdirectories of the log directory
+const (
+	AuditDir = "audit" // audit log segments, the newest one being written to
+	AppsDir  = "apps"  // one log per application, rewritten when it's launched
+)
+
+// Policy tells which files of a directory are kept
+type Policy struct {
+	MaxAge     time.Duration // files not written to for longer are removed, 0 keeps them
+	MaxSize    int64         // the oldest files are removed until the rest fits, 0 means no cap
+	KeepNewest bool          // the most recently written file is never removed
+}
+
+// File is a log file due for removal
+type File struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Result is what a collection removed, or would remove
+type Result struct {
+	Removed []File
+	Freed   int64 // bytes
+}
+
+func (r *Result) add(files []File) {
+	for _, file := range files {
+		r.Removed = append(r.Removed, file)
+		r.Freed += file.Size
+	}
+}
+
+// Dir returns the daemon's log directory, next to the run index in the cache directory
+// cacheDir
+func Dir(cacheDir string) string {
+	return filepath.Join(cacheDir, "ade", "logs")
+}
+
+// Plan returns the files of the audit and apps subdirectories of root the policies would
+// remove, oldest first. Missing subdirectories have nothing to remove, and one that can't
+// be read doesn't keep the other from being planned.
+func Plan(root string, audit, apps Policy, now time.Time) (Result, error) {
+	var result Result
+	var errs []error
+	for _, sub := range []struct {
+		name   string
+		policy Policy
+	}{{AuditDir, audit}, {AppsDir, apps}} {
+		files, err := plan(root, sub.name, sub.policy, now)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		result.add(files)
+	}
+	return result, errors.Join(errs...)
+}
+
+// Collect removes the files Plan returns. Files vanishing meanwhile are skipped; the
+// result has what was actually removed.
+func Collect(root string, audit, apps Policy, now time.Time) (Result, error) {
+	planned, err := Plan(root, audit, apps, now)
+
+	var result Result
+	errs := []error{err}
+	for _, file := range planned.Removed {
+		// Checked again right before removal, the tree may have changed since planning
+		if err := contained(root, file.Path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.Remove(file.Path); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		result.add([]File{file})
+	}
+	return result, errors.Join(errs...)
+}
+
+// plan lists the regular files directly in root/name and picks those policy removes
+func plan(root, name string, policy Policy, now time.Time) ([]File, error) {
+	dir := filepath.Join(root, name)
+	if err := contained(root, dir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var files []File
+	for _, entry := range entries {
+		// Symlinks and directories are left alone, they could lead out of root
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, File{Path: filepath.Join(dir, entry.Name()), Size: info.Size(), ModTime: info.ModTime()})
+	}
+
+	// Oldest first, so removing a prefix keeps the newest files
+	slices.SortFunc(files, func(a, b File) int {
+		if c := a.ModTime.Compare(b.ModTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	candidates := files
+	if policy.KeepNewest && len(candidates) > 0 {
+		candidates = candidates[:len(candidates)-1]
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	n := 0
+	for _, file := range candidates {
+		expired := policy.MaxAge > 0 && now.Sub(file.ModTime) > policy.MaxAge
+		oversized := policy.MaxSize > 0 && total > policy.MaxSize
+		if !expired && !oversized {
+			break
+		}
+		total -= file.Size
+		n++
+	}
+	return candidates[:n], nil
+}
+
+// contained returns an error unless path, symlinks resolved, is below root
+func contained(root, path string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside of the log directory %s", path, root)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Collect", func() {
+	var (
+		root string
+		now  time.Time
+	)
+
+	BeforeEach(func() {
+		root = filepath.Join(GinkgoT().TempDir(), "logs")
+		now = time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	})
+
+	// write creates a log file of size bytes last written days ago
+	write := func(rel string, size int, days int) string {
+		path := filepath.Join(root, rel)
+		Expect(os.MkdirAll(filepath.Dir(path), 0750)).To(Succeed())
+		Expect(os.WriteFile(path, make([]byte, size), 0600)).To(Succeed())
+		mtime := now.Add(-time.Duration(days) * 24 * time.Hour)
+		Expect(os.Chtimes(path, mtime, mtime)).To(Succeed())
+		return path
+	}
+
+	removed := func(result Result) []string {
+		var paths []string
+		for _, file := range result.Removed {
+			paths = append(paths, file.Path)
+		}
+		return paths
+	}
+
+	It("should remove app logs of entries not launched within the max age", func() {
+		stale := write("apps/firefox.log", 100, 20)
+		older := write("apps/gimp.log", 50, 40)
+		fresh := write("apps/foot.log", 10, 1)
+
+		result, err := Collect(root, Policy{}, Policy{MaxAge: 14 * 24 * time.Hour}, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed(result)).To(Equal([]string{older, stale}))
+		Expect(result.Freed).To(Equal(int64(150)))
+		Expect(fresh).To(BeAnExistingFile())
+		Expect(stale).NotTo(BeAnExistingFile())
+	})
+
+	It("should trim audit segments to the max size oldest first, keeping the active one", func() {
+		oldest := write("audit/audit-1.log", 400, 3)
+		middle := write("audit/audit-2.log", 400, 2)
+		newest := write("audit/audit-3.log", 400, 1)
+		active := write("audit/audit.log", 900, 0)
+
+		result, err := Collect(root, Policy{MaxSize: 1000, KeepNewest: true}, Policy{}, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed(result)).To(Equal([]string{oldest, middle, newest}))
+		Expect(active).To(BeAnExistingFile())
+
+		// Over the cap on its own, the active segment still stays
+		result, err = Collect(root, Policy{MaxSize: 100, KeepNewest: true}, Policy{}, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Removed).To(BeEmpty())
+	})
+
+	It("should apply the max age and size of the audit log together", func() {
+		expired := write("audit/audit-1.log", 10, 40)
+		big := write("audit/audit-2.log", 800, 5)
+		kept := write("audit/audit-3.log", 300, 4)
+		write("audit/audit.log", 300, 0)
+
+		result, err := Collect(root, Policy{MaxAge: 30 * 24 * time.Hour, MaxSize: 700, KeepNewest: true}, Policy{}, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed(result)).To(Equal([]string{expired, big}))
+		Expect(kept).To(BeAnExistingFile())
+	})
+
+	It("should remove nothing without limits", func() {
+		write("audit/audit-1.log", 10, 400)
+		write("apps/old.log", 10, 400)
+
+		result, err := Collect(root, Policy{}, Policy{}, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Removed).To(BeEmpty())
+	})
+
+	It("should not need the log directory to exist", func() {
+		result, err := Collect(root, Policy{MaxAge: time.Hour}, Policy{MaxAge: time.Hour}, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Removed).To(BeEmpty())
+	})
+
+	It("should never follow symlinks out of the log directory", func() {
+		outside := filepath.Join(filepath.Dir(root), "outside")
+		Expect(os.MkdirAll(outside, 0750)).To(Succeed())
+		victim := filepath.Join(outside, "precious.log")
+		Expect(os.WriteFile(victim, []byte("keep me"), 0600)).To(Succeed())
+		mtime := now.Add(-365 * 24 * time.Hour)
+		Expect(os.Chtimes(victim, mtime, mtime)).To(Succeed())
+
+		// A symlinked file among the app logs and a symlinked audit directory
+		write("apps/real.log", 10, 100)
+		Expect(os.Symlink(victim, filepath.Join(root, "apps", "link.log"))).To(Succeed())
+		Expect(os.Symlink(outside, filepath.Join(root, AuditDir))).To(Succeed())
+
+		policy := Policy{MaxAge: 24 * time.Hour}
+		result, err := Collect(root, policy, policy, now)
+		Expect(err).To(MatchError(ContainSubstring("outside of the log directory")))
+		Expect(removed(result)).To(Equal([]string{filepath.Join(root, "apps", "real.log")}))
+		Expect(victim).To(BeAnExistingFile())
+	})
+})
+
+var _ = Describe("Plan", func() {
+	It("should leave the files in place", func() {
+		root := GinkgoT().TempDir()
+		path := filepath.Join(root, AppsDir, "app.log")
+		Expect(os.MkdirAll(filepath.Dir(path), 0750)).To(Succeed())
+		Expect(os.WriteFile(path, []byte("log"), 0600)).To(Succeed())
+		old := time.Now().Add(-48 * time.Hour)
+		Expect(os.Chtimes(path, old, old)).To(Succeed())
+
+		result, err := Plan(root, Policy{}, Policy{MaxAge: time.Hour}, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Freed).To(Equal(int64(3)))
+		Expect(path).To(BeAnExistingFile())
+	})
+})
@@ -1,19 +1,40 @@
 package runindex
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
+	"github.com/0xADE/ade-ctld/internal/perm"
+	"github.com/0xADE/ade-ctld/internal/redact"
 	"go.etcd.io/bbolt"
 )
 
 const (
-	dbFile        = "exe-ctld.run-index"
-	bucketName    = "run_index"
-	dbPermissions = 0600
+	dbFile     = "exe-ctld.run-index"
+	bucketName = "runs"
+	pinsBucket = "pins"
+	metaBucket = "meta"
+
+	// Bucket of the raw run counts of schema version 0
+	legacyRunsBucket = "run_index"
+)
+
+// Session snapshots live in the meta bucket under this key prefix.
+const sessionPrefix = "session:"
+
+var (
+	// ErrSessionNotFound is returned for tokens that were never persisted or were pruned.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionExpired is returned for tokens whose TTL has passed.
+	ErrSessionExpired = errors.New("session expired")
 )
 
 // RunIndex manages the run frequency index using bbolt DB.
@@ -24,13 +45,16 @@ type RunIndex struct {
 // For testing purposes - allow overriding the user cache directory
 var userCacheDirFunc = os.UserCacheDir
 
+// For testing purposes - allow overriding the clock stamping runs
+var timeNow = time.Now
+
 // NewRunIndex creates or opens the bbolt database for the run index.
 func NewRunIndex() (*RunIndex, error) {
 	return NewRunIndexWithCacheDir("")
 }
 
-// NewRunIndexWithCacheDir creates or opens the bbolt database for the run index with a specific cache directory.
-// This is primarily for testing purposes.
+// NewRunIndexWithCacheDir creates or opens the bbolt database for the run index with a specific cache directory,
+// e.g. the one of config.CacheDir. An empty cacheDir is the user cache directory.
 func NewRunIndexWithCacheDir(cacheDir string) (*RunIndex, error) {
 	var err error
 	if cacheDir == "" {
@@ -42,23 +66,34 @@ func NewRunIndexWithCacheDir(cacheDir string) (*RunIndex, error) {
 
 	// Create ade directory in cache if it doesn't exist
 	adeCacheDir := filepath.Join(cacheDir, "ade")
-	if err := os.MkdirAll(adeCacheDir, 0750); err != nil {
+	if err := perm.MkdirAll(adeCacheDir); err != nil {
 		return nil, fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
 	dbPath := filepath.Join(adeCacheDir, dbFile)
 
 	// Open the bbolt database
-	db, err := bbolt.Open(dbPath, dbPermissions, &bbolt.Options{Timeout: 1 * time.Second})
+	db, err := bbolt.Open(dbPath, perm.File(), &bbolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	// A database created by an earlier version, or with another policy, is brought in line
+	if err := perm.Apply(dbPath); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set database permissions: %w", err)
+	}
 
-	// Create the bucket if it doesn't exist
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Create the buckets if they don't exist
 	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
-		if err != nil {
-			return fmt.Errorf("failed to create bucket: %w", err)
+		for _, name := range []string{bucketName, pinsBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return fmt.Errorf("failed to create bucket %s: %w", name, err)
+			}
 		}
 		return nil
 	})
@@ -76,24 +111,53 @@ func (ri *RunIndex) Increment(path string) error {
 		if b == nil {
 			return fmt.Errorf("bucket %s not found", bucketName)
 		}
+		return addRuns(b, path, 1, timeNow().UnixNano())
+	})
+}
 
-		// Get current count
-		val := b.Get([]byte(path))
-		var count uint64
-		if val != nil {
-			count = binary.BigEndian.Uint64(val)
-		}
-
-		// Increment count
-		count++
+// IncrementBy adds delta runs to the count of path, like runs imported from another
+// launcher. Their time isn't known, the time of the last run is left as it was.
+func (ri *RunIndex) IncrementBy(path string, delta uint64) error {
+	return ri.ImportCounts(map[string]uint64{path: delta})
+}
 
-		// Put new count
-		buf := make([]byte, 8)
-		binary.BigEndian.PutUint64(buf, count)
-		return b.Put([]byte(path), buf)
+// ImportCounts adds the run counts of counts, runs by path, in one transaction: either all
+// of them are added or, on error, none. Like IncrementBy it leaves the times of the last
+// runs alone.
+func (ri *RunIndex) ImportCounts(counts map[string]uint64) error {
+	return ri.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketName)
+		}
+		// bbolt writes keys in order fastest
+		for _, path := range slices.Sorted(maps.Keys(counts)) {
+			if err := addRuns(b, path, counts[path], 0); err != nil {
+				return fmt.Errorf("import runs of %s: %w", redact.String(path), err)
+			}
+		}
+		return nil
 	})
 }
 
+// addRuns adds n runs to the count of path in b, the last of them at lastRun in Unix
+// nanoseconds, 0 if unknown. Counts stop at the largest value rather than wrap around.
+func addRuns(b *bbolt.Bucket, path string, n uint64, lastRun int64) error {
+	if n == 0 {
+		return nil
+	}
+	record, _ := decodeRun(b.Get([]byte(path)))
+	if record.Count > math.MaxUint64-n {
+		record.Count = math.MaxUint64
+	} else {
+		record.Count += n
+	}
+	if lastRun != 0 {
+		record.LastRun = lastRun
+	}
+	return b.Put([]byte(path), encodeRun(record))
+}
+
 // GetFrequencies retrieves the run frequencies for a list of paths.
 func (ri *RunIndex) GetFrequencies(paths []string) map[string]uint64 {
 	frequencies := make(map[string]uint64)
@@ -104,18 +168,193 @@ func (ri *RunIndex) GetFrequencies(paths []string) map[string]uint64 {
 		}
 
 		for _, path := range paths {
-			val := b.Get([]byte(path))
-			if val != nil {
-				frequencies[path] = binary.BigEndian.Uint64(val)
-			} else {
-				frequencies[path] = 0
-			}
+			record, _ := decodeRun(b.Get([]byte(path)))
+			frequencies[path] = record.Count
 		}
 		return nil
 	})
 	return frequencies
 }
 
+// PathCount is a tracked path with its run count.
+type PathCount struct {
+	Path  string
+	Count uint64
+}
+
+// Stats summarizes the run counts.
+type Stats struct {
+	Tracked   int         // Number of paths with a run count
+	TotalRuns uint64      // Sum of all run counts
+	Top       []PathCount // Most run paths, most runs first
+}
+
+// Stats walks the run counts with a cursor and returns the aggregates and the top most
+// run paths. Paths with equal counts are ordered by path.
+func (ri *RunIndex) Stats(top int) (Stats, error) {
+	var stats Stats
+	err := ri.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketName)
+		}
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			record, ok := decodeRun(v)
+			if !ok {
+				continue
+			}
+			count := record.Count
+			stats.Tracked++
+			stats.TotalRuns += count
+
+			// Keys come sorted, so an equal count never displaces an earlier path
+			pos := len(stats.Top)
+			for pos > 0 && stats.Top[pos-1].Count < count {
+				pos--
+			}
+			if pos >= top {
+				continue
+			}
+			if len(stats.Top) < top {
+				stats.Top = append(stats.Top, PathCount{})
+			}
+			copy(stats.Top[pos+1:], stats.Top[pos:])
+			stats.Top[pos] = PathCount{Path: string(k), Count: count}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// ClearRuns forgets all run counts and returns how many paths had one. Pins and sessions
+// are kept.
+func (ri *RunIndex) ClearRuns() (int, error) {
+	var cleared int
+	err := ri.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketName)
+		}
+		cleared = b.Stats().KeyN
+		if err := tx.DeleteBucket([]byte(bucketName)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket([]byte(bucketName))
+		return err
+	})
+	return cleared, err
+}
+
+// SetPinned pins or unpins the given path.
+func (ri *RunIndex) SetPinned(path string, pinned bool) error {
+	return ri.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(pinsBucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", pinsBucket)
+		}
+		if !pinned {
+			return b.Delete([]byte(path))
+		}
+		return b.Put([]byte(path), []byte{1})
+	})
+}
+
+// GetPinned returns the set of pinned paths.
+func (ri *RunIndex) GetPinned() map[string]bool {
+	pinned := make(map[string]bool)
+	ri.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(pinsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			pinned[string(k)] = true
+			return nil
+		})
+	})
+	return pinned
+}
+
+// SaveSession stores a session snapshot that can be loaded until expires.
+func (ri *RunIndex) SaveSession(token string, state []byte, expires time.Time) error {
+	return ri.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(metaBucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", metaBucket)
+		}
+		// Value layout: expiry as big-endian unix nanoseconds, then the state
+		val := make([]byte, 8, 8+len(state))
+		binary.BigEndian.PutUint64(val, uint64(expires.UnixNano()))
+		val = append(val, state...)
+		return b.Put([]byte(sessionPrefix+token), val)
+	})
+}
+
+// LoadSession returns the snapshot stored for token. Expired snapshots are deleted.
+func (ri *RunIndex) LoadSession(token string, now time.Time) ([]byte, error) {
+	var state []byte
+	expired := false
+	err := ri.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(metaBucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", metaBucket)
+		}
+		key := []byte(sessionPrefix + token)
+		val := b.Get(key)
+		if len(val) < 8 {
+			return ErrSessionNotFound
+		}
+		if sessionExpired(val, now) {
+			// Returning an error here would roll the delete back
+			expired = true
+			return b.Delete(key)
+		}
+		state = append([]byte(nil), val[8:]...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, ErrSessionExpired
+	}
+	return state, nil
+}
+
+// PruneSessions deletes expired session snapshots and returns how many were removed.
+func (ri *RunIndex) PruneSessions(now time.Time) (int, error) {
+	removed := 0
+	err := ri.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(metaBucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", metaBucket)
+		}
+		var expired [][]byte
+		c := b.Cursor()
+		prefix := []byte(sessionPrefix)
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if len(v) < 8 || sessionExpired(v, now) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		// Deleting while iterating a cursor skips keys, so collect first
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	return removed, err
+}
+
+func sessionExpired(val []byte, now time.Time) bool {
+	return now.UnixNano() >= int64(binary.BigEndian.Uint64(val[:8]))
+}
+
 // Close closes the database connection.
 func (ri *RunIndex) Close() error {
 	if ri.db != nil {
@@ -1,8 +1,13 @@
 package runindex
 
 import (
+	"math"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/perm"
+	"go.etcd.io/bbolt"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -59,6 +64,28 @@ var _ = Describe("RunIndex", func() {
 				dbPath := filepath.Join(testCacheDir, "ade", "exe-ctld.run-index")
 				Expect(dbPath).To(BeAnExistingFile())
 			})
+
+			It("should create the directory and the database with the configured mode", func() {
+				mode := func(path string) os.FileMode {
+					info, err := os.Stat(path)
+					Expect(err).NotTo(HaveOccurred())
+					return info.Mode().Perm()
+				}
+				adeCacheDir := filepath.Join(testCacheDir, "ade")
+				dbPath := filepath.Join(adeCacheDir, "exe-ctld.run-index")
+				Expect(mode(adeCacheDir)).To(Equal(os.FileMode(0700)))
+				Expect(mode(dbPath)).To(Equal(os.FileMode(0600)))
+
+				// Reopening brings files of another policy in line
+				Expect(ri.Close()).To(Succeed())
+				DeferCleanup(perm.Set, perm.DefaultFile)
+				perm.Set(0640)
+				var err error
+				ri, err = NewRunIndexWithCacheDir(testCacheDir)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mode(adeCacheDir)).To(Equal(os.FileMode(0750)))
+				Expect(mode(dbPath)).To(Equal(os.FileMode(0640)))
+			})
 		})
 	})
 
@@ -116,6 +143,73 @@ var _ = Describe("RunIndex", func() {
 		})
 	})
 
+	Describe("IncrementBy", func() {
+		It("should accumulate onto the count of a path", func() {
+			path := "/usr/bin/vim"
+			Expect(ri.Increment(path)).To(Succeed())
+			Expect(ri.IncrementBy(path, 10)).To(Succeed())
+			Expect(ri.IncrementBy(path, 0)).To(Succeed())
+			Expect(ri.IncrementBy(path, 5)).To(Succeed())
+			Expect(ri.GetFrequencies([]string{path})[path]).To(Equal(uint64(16)))
+		})
+
+		It("should stop at the largest count rather than wrap around", func() {
+			path := "/usr/bin/vim"
+			Expect(ri.IncrementBy(path, math.MaxUint64-1)).To(Succeed())
+			Expect(ri.IncrementBy(path, 3)).To(Succeed())
+			Expect(ri.GetFrequencies([]string{path})[path]).To(Equal(uint64(math.MaxUint64)))
+		})
+
+		It("should keep the time of the last run, imported runs have none", func() {
+			defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+			timeNow = func() time.Time { return time.Unix(1700000000, 0) }
+			Expect(ri.Increment("/usr/bin/vim")).To(Succeed())
+			Expect(ri.IncrementBy("/usr/bin/vim", 4)).To(Succeed())
+			Expect(ri.IncrementBy("/usr/bin/emacs", 4)).To(Succeed())
+
+			Expect(ri.db.View(func(tx *bbolt.Tx) error {
+				runs := tx.Bucket([]byte(bucketName))
+				vim, _ := decodeRun(runs.Get([]byte("/usr/bin/vim")))
+				Expect(vim).To(Equal(runRecord{Count: 5, LastRun: time.Unix(1700000000, 0).UnixNano()}))
+				emacs, _ := decodeRun(runs.Get([]byte("/usr/bin/emacs")))
+				Expect(emacs).To(Equal(runRecord{Count: 4}))
+				return nil
+			})).To(Succeed())
+		})
+	})
+
+	Describe("ImportCounts", func() {
+		It("should add the counts of every path", func() {
+			Expect(ri.Increment("/usr/bin/vim")).To(Succeed())
+			Expect(ri.ImportCounts(map[string]uint64{
+				"/usr/bin/vim":     41,
+				"/usr/bin/firefox": 7,
+				"/usr/bin/unused":  0,
+			})).To(Succeed())
+
+			paths := []string{"/usr/bin/vim", "/usr/bin/firefox", "/usr/bin/unused"}
+			Expect(ri.GetFrequencies(paths)).To(Equal(map[string]uint64{
+				"/usr/bin/vim":     42,
+				"/usr/bin/firefox": 7,
+				"/usr/bin/unused":  0,
+			}))
+			stats, err := ri.Stats(10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Tracked).To(Equal(2))
+			Expect(stats.TotalRuns).To(Equal(uint64(49)))
+		})
+
+		It("should add none of the counts when one can't be", func() {
+			err := ri.ImportCounts(map[string]uint64{"/usr/bin/vim": 3, "": 1})
+			Expect(err).To(HaveOccurred())
+			Expect(ri.GetFrequencies([]string{"/usr/bin/vim"})["/usr/bin/vim"]).To(BeZero())
+		})
+
+		It("should accept an empty import", func() {
+			Expect(ri.ImportCounts(nil)).To(Succeed())
+		})
+	})
+
 	Describe("GetFrequencies", func() {
 		It("should return zero for paths that have not been incremented", func() {
 			paths := []string{"/path/one", "/path/two", "/path/three"}
@@ -165,6 +259,150 @@ var _ = Describe("RunIndex", func() {
 		})
 	})
 
+	Describe("Pins", func() {
+		It("should have no pins initially", func() {
+			Expect(ri.GetPinned()).To(BeEmpty())
+		})
+
+		It("should pin and unpin paths", func() {
+			Expect(ri.SetPinned("/usr/bin/foo", true)).To(Succeed())
+			Expect(ri.SetPinned("/usr/bin/bar", true)).To(Succeed())
+			Expect(ri.GetPinned()).To(Equal(map[string]bool{"/usr/bin/foo": true, "/usr/bin/bar": true}))
+
+			Expect(ri.SetPinned("/usr/bin/foo", false)).To(Succeed())
+			Expect(ri.GetPinned()).To(Equal(map[string]bool{"/usr/bin/bar": true}))
+		})
+
+		It("should tolerate unpinning a path that isn't pinned", func() {
+			Expect(ri.SetPinned("/not/pinned", false)).To(Succeed())
+		})
+
+		It("should persist pins across reopen", func() {
+			Expect(ri.SetPinned("/usr/bin/foo", true)).To(Succeed())
+			Expect(ri.Close()).To(Succeed())
+
+			var err error
+			ri, err = NewRunIndexWithCacheDir(testCacheDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ri.GetPinned()).To(HaveKey("/usr/bin/foo"))
+		})
+	})
+
+	Describe("Stats", func() {
+		It("should report zeroes for an empty index", func() {
+			stats, err := ri.Stats(5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Tracked).To(BeZero())
+			Expect(stats.TotalRuns).To(BeZero())
+			Expect(stats.Top).To(BeEmpty())
+		})
+
+		It("should aggregate counts and return the top paths", func() {
+			counts := map[string]int{"/usr/bin/vim": 5, "/usr/bin/firefox": 3, "/usr/bin/htop": 3, "/usr/bin/less": 1}
+			for path, n := range counts {
+				for range n {
+					Expect(ri.Increment(path)).To(Succeed())
+				}
+			}
+
+			stats, err := ri.Stats(3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Tracked).To(Equal(4))
+			Expect(stats.TotalRuns).To(Equal(uint64(12)))
+			Expect(stats.Top).To(Equal([]PathCount{
+				{Path: "/usr/bin/vim", Count: 5},
+				{Path: "/usr/bin/firefox", Count: 3},
+				{Path: "/usr/bin/htop", Count: 3},
+			}))
+
+			stats, err = ri.Stats(10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Top).To(HaveLen(4))
+			Expect(stats.Top[3]).To(Equal(PathCount{Path: "/usr/bin/less", Count: 1}))
+		})
+	})
+
+	Describe("ClearRuns", func() {
+		It("should forget run counts but keep pins and sessions", func() {
+			Expect(ri.Increment("/usr/bin/vim")).To(Succeed())
+			Expect(ri.Increment("/usr/bin/vim")).To(Succeed())
+			Expect(ri.Increment("/usr/bin/htop")).To(Succeed())
+			Expect(ri.SetPinned("/usr/bin/vim", true)).To(Succeed())
+			expires := time.Now().Add(time.Hour)
+			Expect(ri.SaveSession("abc", []byte(`{}`), expires)).To(Succeed())
+
+			cleared, err := ri.ClearRuns()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cleared).To(Equal(2))
+
+			Expect(ri.GetFrequencies([]string{"/usr/bin/vim", "/usr/bin/htop"})).To(HaveEach(BeZero()))
+			Expect(ri.GetPinned()).To(HaveKey("/usr/bin/vim"))
+			_, err = ri.LoadSession("abc", time.Now())
+			Expect(err).NotTo(HaveOccurred())
+
+			// Counting starts over
+			Expect(ri.Increment("/usr/bin/vim")).To(Succeed())
+			Expect(ri.GetFrequencies([]string{"/usr/bin/vim"})).To(HaveKeyWithValue("/usr/bin/vim", uint64(1)))
+		})
+	})
+
+	Describe("Sessions", func() {
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		})
+
+		It("should load a saved snapshot before it expires", func() {
+			Expect(ri.SaveSession("abc", []byte(`{"lang":"ru"}`), now.Add(time.Hour))).To(Succeed())
+
+			state, err := ri.LoadSession("abc", now.Add(30*time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(state)).To(Equal(`{"lang":"ru"}`))
+		})
+
+		It("should report unknown tokens", func() {
+			_, err := ri.LoadSession("missing", now)
+			Expect(err).To(MatchError(ErrSessionNotFound))
+		})
+
+		It("should report and delete expired snapshots", func() {
+			Expect(ri.SaveSession("abc", []byte("{}"), now.Add(time.Hour))).To(Succeed())
+
+			_, err := ri.LoadSession("abc", now.Add(time.Hour))
+			Expect(err).To(MatchError(ErrSessionExpired))
+
+			_, err = ri.LoadSession("abc", now)
+			Expect(err).To(MatchError(ErrSessionNotFound))
+		})
+
+		It("should prune only expired snapshots", func() {
+			Expect(ri.SaveSession("old", []byte("{}"), now.Add(-time.Minute))).To(Succeed())
+			Expect(ri.SaveSession("older", []byte("{}"), now.Add(-time.Hour))).To(Succeed())
+			Expect(ri.SaveSession("fresh", []byte("{}"), now.Add(time.Hour))).To(Succeed())
+
+			removed, err := ri.PruneSessions(now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(2))
+
+			_, err = ri.LoadSession("fresh", now)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = ri.LoadSession("old", now.Add(-2*time.Hour))
+			Expect(err).To(MatchError(ErrSessionNotFound))
+		})
+
+		It("should persist snapshots across reopen", func() {
+			Expect(ri.SaveSession("abc", []byte("{}"), now.Add(time.Hour))).To(Succeed())
+			Expect(ri.Close()).To(Succeed())
+
+			var err error
+			ri, err = NewRunIndexWithCacheDir(testCacheDir)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = ri.LoadSession("abc", now)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
 	Describe("Close", func() {
 		It("should close the database successfully", func() {
 			// Close the current instance
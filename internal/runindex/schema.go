@@ -0,0 +1,138 @@
+package runindex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// schemaKey holds the schema version in the meta bucket, as a big-endian uint64. Databases
+// without it are version 0.
+const schemaKey = "schema-version"
+
+// ErrSchemaTooNew is returned when opening a database written by a newer version, which this
+// one could only corrupt.
+var ErrSchemaTooNew = errors.New("run index schema is newer than supported")
+
+// migration upgrades a database from the previous schema version to version. It runs in
+// the same transaction that records the new version, so an interrupted migration leaves the
+// database as it was. It must also be harmless to run again on its own output.
+type migration struct {
+	version     uint64
+	description string
+	migrate     func(tx *bbolt.Tx) error
+}
+
+// migrations in the order they apply; the last one sets the current schema version.
+var migrations = []migration{
+	{1, "store run counts as records with the time of the last run", migrateRunRecords},
+}
+
+// schemaVersion returns the schema version of the database.
+func schemaVersion(tx *bbolt.Tx) uint64 {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return 0
+	}
+	val := b.Get([]byte(schemaKey))
+	if len(val) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(val)
+}
+
+func setSchemaVersion(tx *bbolt.Tx, version uint64) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, version)
+	return b.Put([]byte(schemaKey), val)
+}
+
+// migrate brings the database to the latest schema, one transaction per migration.
+func migrate(db *bbolt.DB) error {
+	latest := migrations[len(migrations)-1].version
+
+	var version uint64
+	db.View(func(tx *bbolt.Tx) error {
+		version = schemaVersion(tx)
+		return nil
+	})
+	if version > latest {
+		return fmt.Errorf("%w: %s has version %d, this build supports up to %d", ErrSchemaTooNew, db.Path(), version, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		err := db.Update(func(tx *bbolt.Tx) error {
+			if err := m.migrate(tx); err != nil {
+				return err
+			}
+			// Last, so the version never claims a migration that didn't happen
+			return setSchemaVersion(tx, m.version)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate run index to version %d (%s): %w", m.version, m.description, err)
+		}
+		version = m.version
+	}
+	return nil
+}
+
+// migrateRunRecords copies the raw uint64 run counts of version 0 into records in a bucket
+// of their own, then drops the old bucket.
+func migrateRunRecords(tx *bbolt.Tx) error {
+	runs, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+	if err != nil {
+		return err
+	}
+	legacy := tx.Bucket([]byte(legacyRunsBucket))
+	if legacy == nil {
+		return nil
+	}
+
+	err = legacy.ForEach(func(k, v []byte) error {
+		if len(v) != 8 {
+			// Not a count, nothing to keep
+			return nil
+		}
+		return runs.Put(k, encodeRun(runRecord{Count: binary.BigEndian.Uint64(v)}))
+	})
+	if err != nil {
+		return err
+	}
+	return tx.DeleteBucket([]byte(legacyRunsBucket))
+}
+
+// runRecord is what is stored per path in the runs bucket.
+type runRecord struct {
+	Count   uint64 // Number of runs
+	LastRun int64  // Unix nanoseconds of the last run, 0 if unknown
+}
+
+// runRecordSize is the size of an encoded record. Later versions may append fields, so
+// longer values are accepted.
+const runRecordSize = 16
+
+func encodeRun(r runRecord) []byte {
+	val := make([]byte, runRecordSize)
+	binary.BigEndian.PutUint64(val[0:8], r.Count)
+	binary.BigEndian.PutUint64(val[8:16], uint64(r.LastRun))
+	return val
+}
+
+func decodeRun(val []byte) (runRecord, bool) {
+	if len(val) < runRecordSize {
+		return runRecord{}, false
+	}
+	return runRecord{
+		Count:   binary.BigEndian.Uint64(val[0:8]),
+		LastRun: int64(binary.BigEndian.Uint64(val[8:16])),
+	}, true
+}
@@ -0,0 +1,225 @@
+package runindex
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/perm"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.etcd.io/bbolt"
+)
+
+// writeFixture creates the run index database of cacheDir as laid out by fill
+func writeFixture(cacheDir string, fill func(tx *bbolt.Tx) error) {
+	Expect(os.MkdirAll(filepath.Join(cacheDir, "ade"), 0750)).To(Succeed())
+	db, err := bbolt.Open(filepath.Join(cacheDir, "ade", dbFile), perm.File(), nil)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(db.Update(fill)).To(Succeed())
+	Expect(db.Close()).To(Succeed())
+}
+
+func rawCount(n uint64) []byte {
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, n)
+	return val
+}
+
+func putAll(tx *bbolt.Tx, bucket string, values map[string][]byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fixtures of every historical layout
+var (
+	// Version 0 as first released: raw counts only
+	fixtureV0Counts = func(tx *bbolt.Tx) error {
+		return putAll(tx, legacyRunsBucket, map[string][]byte{
+			"/usr/bin/vim":  rawCount(5),
+			"/usr/bin/htop": rawCount(2),
+			"/usr/bin/junk": []byte("garbage"),
+		})
+	}
+	// Version 0 with pins and sessions, still without a schema version
+	fixtureV0Meta = func(tx *bbolt.Tx) error {
+		if err := fixtureV0Counts(tx); err != nil {
+			return err
+		}
+		if err := putAll(tx, pinsBucket, map[string][]byte{"/usr/bin/htop": {1}}); err != nil {
+			return err
+		}
+		session := append(rawCount(uint64(time.Now().Add(time.Hour).UnixNano())), `{"lang":"de"}`...)
+		return putAll(tx, metaBucket, map[string][]byte{sessionPrefix + "abc": session})
+	}
+	// Version 1: run records
+	fixtureV1 = func(tx *bbolt.Tx) error {
+		if err := putAll(tx, bucketName, map[string][]byte{
+			"/usr/bin/vim":  encodeRun(runRecord{Count: 5, LastRun: 1700000000}),
+			"/usr/bin/htop": encodeRun(runRecord{Count: 2}),
+		}); err != nil {
+			return err
+		}
+		if err := putAll(tx, pinsBucket, map[string][]byte{"/usr/bin/htop": {1}}); err != nil {
+			return err
+		}
+		return setSchemaVersion(tx, 1)
+	}
+)
+
+var _ = Describe("Schema", func() {
+	var cacheDir string
+
+	BeforeEach(func() {
+		cacheDir = GinkgoT().TempDir()
+	})
+
+	latest := func() uint64 {
+		return migrations[len(migrations)-1].version
+	}
+
+	open := func() *RunIndex {
+		ri, err := NewRunIndexWithCacheDir(cacheDir)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ri.Close)
+		return ri
+	}
+
+	versionOf := func(ri *RunIndex) uint64 {
+		var version uint64
+		ri.db.View(func(tx *bbolt.Tx) error {
+			version = schemaVersion(tx)
+			return nil
+		})
+		return version
+	}
+
+	It("should create new databases at the latest version", func() {
+		ri := open()
+		Expect(versionOf(ri)).To(Equal(latest()))
+		Expect(ri.Increment("/usr/bin/vim")).To(Succeed())
+		Expect(ri.GetFrequencies([]string{"/usr/bin/vim"})).To(HaveKeyWithValue("/usr/bin/vim", uint64(1)))
+	})
+
+	DescribeTable("should bring historical databases to the latest version",
+		func(fixture func(tx *bbolt.Tx) error, pins int) {
+			writeFixture(cacheDir, fixture)
+			ri := open()
+			Expect(versionOf(ri)).To(Equal(latest()))
+
+			Expect(ri.GetFrequencies([]string{"/usr/bin/vim", "/usr/bin/htop", "/usr/bin/junk"})).To(Equal(map[string]uint64{
+				"/usr/bin/vim":  5,
+				"/usr/bin/htop": 2,
+				"/usr/bin/junk": 0,
+			}))
+			stats, err := ri.Stats(5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Tracked).To(Equal(2))
+			Expect(ri.GetPinned()).To(HaveLen(pins))
+
+			Expect(ri.Increment("/usr/bin/htop")).To(Succeed())
+			Expect(ri.GetFrequencies([]string{"/usr/bin/htop"})).To(HaveKeyWithValue("/usr/bin/htop", uint64(3)))
+			ri.db.View(func(tx *bbolt.Tx) error {
+				Expect(tx.Bucket([]byte(legacyRunsBucket))).To(BeNil())
+				return nil
+			})
+		},
+		Entry("version 0 with counts only", fixtureV0Counts, 0),
+		Entry("version 0 with pins and sessions", fixtureV0Meta, 1),
+		Entry("version 1", fixtureV1, 1),
+	)
+
+	It("should keep sessions of version 0 databases", func() {
+		writeFixture(cacheDir, fixtureV0Meta)
+		state, err := open().LoadSession("abc", time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(state)).To(Equal(`{"lang":"de"}`))
+	})
+
+	It("should stamp runs with their time", func() {
+		defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+		timeNow = func() time.Time { return time.Unix(1700000000, 0) }
+
+		ri := open()
+		Expect(ri.Increment("/usr/bin/vim")).To(Succeed())
+		ri.db.View(func(tx *bbolt.Tx) error {
+			record, ok := decodeRun(tx.Bucket([]byte(bucketName)).Get([]byte("/usr/bin/vim")))
+			Expect(ok).To(BeTrue())
+			Expect(record).To(Equal(runRecord{Count: 1, LastRun: time.Unix(1700000000, 0).UnixNano()}))
+			return nil
+		})
+	})
+
+	It("should refuse databases of a newer version untouched", func() {
+		writeFixture(cacheDir, func(tx *bbolt.Tx) error {
+			if err := fixtureV1(tx); err != nil {
+				return err
+			}
+			return setSchemaVersion(tx, latest()+1)
+		})
+		dbPath := filepath.Join(cacheDir, "ade", dbFile)
+		before, err := os.ReadFile(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = NewRunIndexWithCacheDir(cacheDir)
+		Expect(err).To(MatchError(ErrSchemaTooNew))
+		Expect(err.Error()).To(ContainSubstring(dbPath))
+
+		after, err := os.ReadFile(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).To(Equal(before))
+	})
+
+	It("should leave the database as it was when a migration fails", func() {
+		writeFixture(cacheDir, fixtureV0Counts)
+		defer func(orig []migration) { migrations = orig }(migrations)
+		migrations = append(migrations[:len(migrations):len(migrations)], migration{
+			version:     latest() + 1,
+			description: "fail halfway",
+			migrate: func(tx *bbolt.Tx) error {
+				if err := tx.DeleteBucket([]byte(bucketName)); err != nil {
+					return err
+				}
+				return errors.New("interrupted")
+			},
+		})
+
+		_, err := NewRunIndexWithCacheDir(cacheDir)
+		Expect(err).To(MatchError(ContainSubstring("fail halfway")))
+
+		// The earlier migrations stay applied, the failed one left no trace
+		migrations = migrations[:len(migrations)-1]
+		ri := open()
+		Expect(versionOf(ri)).To(Equal(latest()))
+		Expect(ri.GetFrequencies([]string{"/usr/bin/vim"})).To(HaveKeyWithValue("/usr/bin/vim", uint64(5)))
+	})
+
+	It("should run migrations again harmlessly", func() {
+		writeFixture(cacheDir, fixtureV0Counts)
+		db, err := bbolt.Open(filepath.Join(cacheDir, "ade", dbFile), perm.File(), nil)
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		for _, m := range migrations {
+			Expect(db.Update(m.migrate)).To(Succeed())
+			Expect(db.Update(m.migrate)).To(Succeed())
+		}
+		db.View(func(tx *bbolt.Tx) error {
+			record, ok := decodeRun(tx.Bucket([]byte(bucketName)).Get([]byte("/usr/bin/vim")))
+			Expect(ok).To(BeTrue())
+			Expect(record.Count).To(Equal(uint64(5)))
+			return nil
+		})
+	})
+})
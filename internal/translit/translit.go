@@ -0,0 +1,208 @@
+// Package translit spells names of other scripts with Latin letters, so they can be typed
+// on a Latin keyboard: Cyrillic, Greek and the Japanese kana (Hepburn romaji). Han
+// characters are left as they are, reading them takes a dictionary. The output is
+// lowercase and meant for matching, not display.
+package translit
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Latin returns s lowercased with the letters of the supported scripts spelled in Latin,
+// and whether any was. Everything else is kept.
+func Latin(s string) (string, bool) {
+	s = strings.ToLower(s)
+	var (
+		sb      strings.Builder
+		changed bool
+		double  bool // a small tsu doubles the consonant of the next kana
+	)
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r < utf8.RuneSelf {
+			sb.WriteByte(byte(r))
+			i += size
+			double = false
+			continue
+		}
+
+		if latin, n := kana(s[i:]); n > 0 {
+			changed = true
+			switch {
+			case latin == sokuon:
+				double = true
+			case latin == choon:
+				// The long vowel mark repeats the vowel before it
+				if vowel := lastVowel(sb.String()); vowel != 0 {
+					sb.WriteByte(vowel)
+				}
+			default:
+				if double {
+					sb.WriteString(doubled(latin))
+				}
+				sb.WriteString(latin)
+			}
+			if latin != sokuon {
+				double = false
+			}
+			i += n
+			continue
+		}
+		double = false
+
+		if latin, n := greekPair(s[i:]); n > 0 {
+			sb.WriteString(latin)
+			changed = true
+			i += n
+			continue
+		}
+		if latin, ok := letters[r]; ok {
+			sb.WriteString(latin)
+			changed = true
+		} else {
+			sb.WriteRune(r)
+		}
+		i += size
+	}
+	return sb.String(), changed
+}
+
+// Markers of kana that aren't spelled themselves
+const (
+	sokuon = "\x00tsu" // small tsu
+	choon  = "\x00-"   // long vowel mark
+)
+
+// kana returns the romaji of the kana syllable s starts with and its length in bytes,
+// 0 if it's not kana. Katakana are read as the hiragana they correspond to.
+func kana(s string) (string, int) {
+	r, size := utf8.DecodeRuneInString(s)
+	h, ok := hiragana(r)
+	if !ok {
+		return "", 0
+	}
+	// A small ya, yu, yo or vowel after it makes one syllable of two kana
+	if next, nextSize := utf8.DecodeRuneInString(s[size:]); nextSize > 0 {
+		if small, ok := hiragana(next); ok {
+			if latin, ok := kanaPairs[string([]rune{h, small})]; ok {
+				return latin, size + nextSize
+			}
+		}
+	}
+	if latin, ok := kanaSyllables[h]; ok {
+		return latin, size
+	}
+	return "", 0
+}
+
+// hiragana maps katakana to hiragana, the tables only have the latter
+func hiragana(r rune) (rune, bool) {
+	switch {
+	case r == 'ー':
+		return r, true
+	case r >= 'ぁ' && r <= 'ゖ':
+		return r, true
+	case r >= 'ァ' && r <= 'ヶ':
+		return r - ('ァ' - 'ぁ'), true
+	}
+	return 0, false
+}
+
+// doubled returns the consonant a small tsu adds before syllable, t before ch in Hepburn
+func doubled(syllable string) string {
+	if strings.HasPrefix(syllable, "ch") {
+		return "t"
+	}
+	if c := syllable[0]; !strings.ContainsRune("aiueon", rune(c)) {
+		return string(c)
+	}
+	return ""
+}
+
+func lastVowel(s string) byte {
+	if s == "" {
+		return 0
+	}
+	if c := s[len(s)-1]; strings.IndexByte("aeiou", c) >= 0 {
+		return c
+	}
+	return 0
+}
+
+// greekPair returns the Latin spelling of a Greek diphthong s starts with and its length
+// in bytes, 0 if there's none
+func greekPair(s string) (string, int) {
+	for pair, latin := range greekPairs {
+		if strings.HasPrefix(s, pair) {
+			return latin, len(pair)
+		}
+	}
+	return "", 0
+}
+
+var greekPairs = map[string]string{
+	"ου": "ou", "ού": "ou",
+	"αυ": "av", "αύ": "av",
+	"ευ": "ev", "εύ": "ev",
+}
+
+// letters spells single letters of the alphabetic scripts
+var letters = map[rune]string{
+	// Cyrillic: Russian, with the letters of Ukrainian and Belarusian
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+
+	// Greek, accented vowels like plain ones
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th",
+	'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p",
+	'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps",
+	'ω': "o", 'ά': "a", 'έ': "e", 'ή': "i", 'ί': "i", 'ό': "o", 'ύ': "y", 'ώ': "o",
+	'ϊ': "i", 'ϋ': "y", 'ΐ': "i", 'ΰ': "y",
+}
+
+// kanaSyllables spells single hiragana in Hepburn romaji
+var kanaSyllables = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo", 'ゎ': "wa", 'ゕ': "ka", 'ゖ': "ke",
+	'っ': sokuon, 'ー': choon,
+}
+
+// kanaPairs spells the syllables written with a small kana after an i-row one (yōon), and
+// those of loanwords written with a small vowel
+var kanaPairs = func() map[string]string {
+	pairs := map[string]string{
+		"ふぁ": "fa", "ふぃ": "fi", "ふぇ": "fe", "ふぉ": "fo",
+		"てぃ": "ti", "でぃ": "di", "とぅ": "tu", "どぅ": "du",
+		"うぃ": "wi", "うぇ": "we", "うぉ": "wo",
+		"ゔぁ": "va", "ゔぃ": "vi", "ゔぇ": "ve", "ゔぉ": "vo",
+		"しぇ": "she", "じぇ": "je", "ちぇ": "che",
+	}
+	for kana, consonant := range map[rune]string{
+		'き': "ky", 'ぎ': "gy", 'し': "sh", 'じ': "j", 'ち': "ch", 'ぢ': "j", 'に': "ny",
+		'ひ': "hy", 'び': "by", 'ぴ': "py", 'み': "my", 'り': "ry",
+	} {
+		for small, vowel := range map[rune]string{'ゃ': "a", 'ゅ': "u", 'ょ': "o"} {
+			pairs[string([]rune{kana, small})] = consonant + vowel
+		}
+	}
+	return pairs
+}()
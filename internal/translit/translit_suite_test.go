@@ -0,0 +1,13 @@
+package translit
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestTranslit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Translit Suite")
+}
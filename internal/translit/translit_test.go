@@ -0,0 +1,36 @@
+package translit
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Latin", func() {
+	DescribeTable("should spell names in Latin letters",
+		func(name, want string) {
+			got, changed := Latin(name)
+			Expect(got).To(Equal(want))
+			Expect(changed).To(BeTrue())
+		},
+		Entry("Russian", "Терминал", "terminal"),
+		Entry("Russian with soft sign and digraphs", "Текстовый редактор Щука", "tekstovyy redaktor shchuka"),
+		Entry("Ukrainian", "Їжак і Ґанок", "yizhak i ganok"),
+		Entry("Greek", "Αριθμομηχανή", "arithmomichani"),
+		Entry("Greek diphthongs", "Ευρώ Ουρανός", "evro ouranos"),
+		Entry("hiragana", "ひらがな", "hiragana"),
+		Entry("katakana with a long vowel", "ターミナル", "taaminaru"),
+		Entry("yōon", "きょうと", "kyouto"),
+		Entry("small tsu", "ちょっと まって", "chotto matte"),
+		Entry("small tsu before ch", "マッチ", "matchi"),
+		Entry("loanword syllables", "ファイル", "fairu"),
+		Entry("mixed with Latin", "GIMP ファイル 2", "gimp fairu 2"),
+	)
+
+	It("should leave Latin and unsupported scripts alone", func() {
+		for name, want := range map[string]string{"Firefox": "firefox", "": "", "文字": "文字", "Ñandú": "ñandú", "LibreOffice 日本語": "libreoffice 日本語"} {
+			got, changed := Latin(name)
+			Expect(changed).To(BeFalse(), name)
+			Expect(got).To(Equal(want), name)
+		}
+	})
+})
@@ -2,10 +2,12 @@ package parser
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // ValueType represents the type of a value on the stack
@@ -25,6 +27,61 @@ type Value struct {
 	Bool bool
 }
 
+// ErrSyntax is returned (wrapped) by ParseCommand when the input can't be parsed.
+// Any other error means the underlying reader failed and the stream is unusable.
+var ErrSyntax = errors.New("parse error")
+
+// ErrInvalidUTF8 is returned (wrapped in an EncodingError) by ParseCommand for a string value
+// that isn't valid UTF-8 when the parser rejects those
+var ErrInvalidUTF8 = errors.New("invalid UTF-8")
+
+// EncodingError reports the first string value of a command that isn't valid UTF-8. The
+// whole command has been read, so the stream stays usable.
+type EncodingError struct {
+	Command string // command the value was pushed for
+	Arg     int    // position of the value among the arguments, from 1
+	Offset  int    // byte offset of the first invalid byte in the value
+}
+
+func (e *EncodingError) Error() string {
+	return fmt.Sprintf("argument %d has invalid UTF-8 at byte %d", e.Arg, e.Offset)
+}
+
+func (e *EncodingError) Unwrap() error { return ErrInvalidUTF8 }
+
+// UTF8Policy selects what happens to string values that aren't valid UTF-8
+type UTF8Policy int
+
+const (
+	RejectInvalidUTF8  UTF8Policy = iota // the command fails with an EncodingError
+	ReplaceInvalidUTF8                   // invalid bytes are replaced with U+FFFD
+)
+
+// ParseUTF8Policy parses a policy name: reject or replace, an empty string means reject
+func ParseUTF8Policy(s string) (UTF8Policy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "reject":
+		return RejectInvalidUTF8, nil
+	case "replace":
+		return ReplaceInvalidUTF8, nil
+	default:
+		return 0, fmt.Errorf("unknown invalid UTF-8 policy %q, expected reject or replace", s)
+	}
+}
+
+// InvalidUTF8Offset returns the byte offset of the first invalid UTF-8 sequence in s, or -1
+// if s is valid
+func InvalidUTF8Offset(s string) int {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+	return -1
+}
+
 // Command represents a parsed command
 type Command struct {
 	Name string
@@ -33,9 +90,10 @@ type Command struct {
 
 // Parser parses Forth-style commands
 type Parser struct {
-	reader  *bufio.Reader
-	header  string
-	version string
+	reader     *bufio.Reader
+	header     string
+	version    string
+	utf8Policy UTF8Policy
 }
 
 // NewParser creates a new parser
@@ -60,9 +118,16 @@ func NewParser(reader io.Reader) (*Parser, error) {
 	return p, nil
 }
 
+// SetUTF8Policy sets what happens to string values that aren't valid UTF-8, by default
+// they are rejected
+func (p *Parser) SetUTF8Policy(policy UTF8Policy) {
+	p.utf8Policy = policy
+}
+
 // ParseCommand parses the next command from input
 func (p *Parser) ParseCommand() (*Command, error) {
 	stack := make([]Value, 0)
+	var encErr *EncodingError
 
 	for {
 		line, err := p.reader.ReadString('\n')
@@ -91,6 +156,10 @@ func (p *Parser) ParseCommand() (*Command, error) {
 
 		// Check if it's a command
 		if cmd := parseCommand(line); cmd != "" {
+			if encErr != nil {
+				encErr.Command = cmd
+				return nil, encErr
+			}
 			// Return command with current stack
 			return &Command{
 				Name: cmd,
@@ -101,7 +170,17 @@ func (p *Parser) ParseCommand() (*Command, error) {
 		// Otherwise, parse as value and push to stack
 		value, err := parseValue(line)
 		if err != nil {
-			return nil, fmt.Errorf("parse error: %v", err)
+			return nil, fmt.Errorf("%w: %v", ErrSyntax, err)
+		}
+		if value.Type == TypeString {
+			// Invalid values are reported once the command they belong to is read
+			if offset := InvalidUTF8Offset(value.Str); offset >= 0 {
+				if p.utf8Policy == ReplaceInvalidUTF8 {
+					value.Str = strings.ToValidUTF8(value.Str, string(utf8.RuneError))
+				} else if encErr == nil {
+					encErr = &EncodingError{Arg: len(stack) + 1, Offset: offset}
+				}
+			}
 		}
 		stack = append(stack, value)
 	}
@@ -118,14 +197,43 @@ func parseCommand(line string) string {
 		"filter-name",
 		"+filter-name",
 		"+filter-cat",
+		"-filter-cat",
 		"+filter-path",
 		"0filters",
 		"list",
 		"run",
 		"lang",
+		"sort",
 		"saveconf",
 		"list-next",
 		"reindex",
+		"kill",
+		"pin",
+		"unpin",
+		"info",
+		"which",
+		"broken",
+		"handler",
+		"lang-list",
+		"session",
+		"subscribe",
+		"unsubscribe",
+		"stats",
+		"runstats",
+		"sources",
+		"reindex-status",
+		"search",
+		"diff",
+		"export-index",
+		"capabilities",
+		"config",
+		"clearcache",
+		"categories",
+		"get-many",
+		"gc",
+		"nop",
+		"cancel",
+		"bye",
 	}
 
 	for _, cmd := range commands {
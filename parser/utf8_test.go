@@ -0,0 +1,133 @@
+package parser
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// parseAll parses every command of the stream after the header with policy, returning
+// them and the errors in order
+func parseAll(input string, policy UTF8Policy) ([]*Command, []error) {
+	p, err := NewParser(strings.NewReader("TXT01" + input))
+	Expect(err).NotTo(HaveOccurred())
+	p.SetUTF8Policy(policy)
+
+	var (
+		cmds []*Command
+		errs []error
+	)
+	for {
+		cmd, err := p.ParseCommand()
+		if err == io.EOF {
+			return cmds, errs
+		}
+		cmds = append(cmds, cmd)
+		errs = append(errs, err)
+	}
+}
+
+var _ = Describe("invalid UTF-8", func() {
+	DescribeTable("InvalidUTF8Offset",
+		func(s string, offset int) {
+			Expect(InvalidUTF8Offset(s)).To(Equal(offset))
+		},
+		Entry("ASCII", "firefox", -1),
+		Entry("multibyte", "Feuerfüchs 火狐 🦊", -1),
+		Entry("empty", "", -1),
+		Entry("overlong slash", "a\xc0\xafb", 1),
+		Entry("overlong NUL", "\xe0\x80\x80", 0),
+		Entry("lone high surrogate", "ab\xed\xa0\x80", 2),
+		Entry("lone low surrogate", "\xed\xbf\xbf", 0),
+		Entry("truncated tail", "€\xe2\x82", 3),
+		Entry("truncated emoji", "\xf0\x9f\xa6", 0),
+		Entry("stray continuation byte", "ü\x80", 2),
+		Entry("beyond U+10FFFF", "\xf4\x90\x80\x80", 0),
+	)
+
+	DescribeTable("rejects the command by default, naming argument and offset",
+		func(value string, offset int) {
+			cmds, errs := parseAll("\n\"ok\n\""+value+"\nfilter-name\n", RejectInvalidUTF8)
+			Expect(cmds).To(HaveLen(1))
+			Expect(cmds[0]).To(BeNil())
+
+			var encErr *EncodingError
+			Expect(errors.As(errs[0], &encErr)).To(BeTrue())
+			Expect(*encErr).To(Equal(EncodingError{Command: "filter-name", Arg: 2, Offset: offset}))
+			Expect(errs[0]).To(MatchError(ErrInvalidUTF8))
+			Expect(errors.Is(errs[0], ErrSyntax)).To(BeFalse())
+		},
+		Entry("overlong sequence at the start", "\xc0\xaffox", 0),
+		Entry("overlong sequence in the middle", "fi\xc1\xbfox", 2),
+		Entry("lone surrogate at the end", "fox\xed\xa0\x80", 3),
+		Entry("truncated two byte tail", "fü\xc3", 3),
+		Entry("truncated three byte tail", "fox\xe2\x82", 3),
+		Entry("truncated four byte tail", "\xf0\x9f\x98", 0),
+	)
+
+	It("should read the rest of a rejected command and go on with the next one", func() {
+		cmds, errs := parseAll("\"\xff\n\"\xfe\n42\nfilter-name\n\"firefox\nfilter-name\n", RejectInvalidUTF8)
+		Expect(errs).To(HaveLen(2))
+		Expect(errs[0]).To(MatchError("argument 1 has invalid UTF-8 at byte 0"))
+		Expect(errs[1]).NotTo(HaveOccurred())
+		Expect(cmds[1]).To(Equal(&Command{Name: "filter-name", Args: []Value{{Type: TypeString, Str: "firefox"}}}))
+	})
+
+	It("should replace invalid bytes with U+FFFD when asked to", func() {
+		cmds, errs := parseAll("\"fi\xc0\xafre\xed\xa0\x80fox\xe2\x82\nfilter-name\n", ReplaceInvalidUTF8)
+		Expect(errs[0]).NotTo(HaveOccurred())
+		Expect(cmds[0].Args[0].Str).To(Equal("fi�re�fox�"))
+	})
+
+	It("should parse policy names", func() {
+		Expect(ParseUTF8Policy("")).To(Equal(RejectInvalidUTF8))
+		Expect(ParseUTF8Policy("Replace")).To(Equal(ReplaceInvalidUTF8))
+		_, err := ParseUTF8Policy("drop")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// FuzzParseCommand checks that any input either fails or yields valid UTF-8 strings, and
+// that replacing never fails on encoding
+func FuzzParseCommand(f *testing.F) {
+	for _, seed := range []string{
+		"\"firefox\nfilter-name\n",
+		"\"\xc0\xaf\nlist\n",
+		"\"a\xed\xa0\x80\n42\nrun\n",
+		"\"\xe2\x82\n\"\xf0\x9f\x98\n+filter-cat\n",
+		"\xff\nlist\n",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		for _, policy := range []UTF8Policy{RejectInvalidUTF8, ReplaceInvalidUTF8} {
+			p, err := NewParser(strings.NewReader("TXT01" + input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.SetUTF8Policy(policy)
+			for {
+				cmd, err := p.ParseCommand()
+				if err == io.EOF {
+					break
+				}
+				if errors.Is(err, ErrInvalidUTF8) && policy == ReplaceInvalidUTF8 {
+					t.Fatalf("replace policy rejected a command: %v", err)
+				}
+				if err != nil {
+					continue
+				}
+				for _, arg := range cmd.Args {
+					if arg.Type == TypeString && !utf8.ValidString(arg.Str) {
+						t.Fatalf("invalid UTF-8 in argument %q of %s", arg.Str, cmd.Name)
+					}
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,171 @@
+// Package response builds replies of the cmdlist protocol: an attrs block of "key: value"
+// lines, an optional body and the "\n\n\n" terminator, prefixed with the protocol header.
+package response
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Header starts every reply
+const Header = "TXT01"
+
+// Status is the numeric code reported in the status attribute
+type Status int
+
+const (
+	StatusOK             Status = 0  // Command succeeded
+	StatusInternal       Status = 1  // Server side failure (exec, storage, indexing)
+	StatusBadArgument    Status = 2  // Missing or mistyped argument
+	StatusNotFound       Status = 3  // Referenced entry or process doesn't exist
+	StatusUnknownCommand Status = 4  // Command not recognized
+	StatusParseError     Status = 5  // Request couldn't be parsed
+	StatusConflict       Status = 6  // State changed elsewhere since it was read
+	StatusStale          Status = 7  // Entry changed on disk since it was indexed
+	StatusUnavailable    Status = 8  // Entry is gone from disk since it was indexed, or the command isn't served here
+	StatusLimit          Status = 9  // A configured limit would be exceeded
+	StatusCancelled      Status = 10 // Stopped by a cancel of the client
+)
+
+// MaxArgLen is the number of runes an echoed argument is truncated to
+const MaxArgLen = 64
+
+// Leading are the attributes every reply starts with, in this order, those set: the tag of
+// the request, the command answered, the entry it's about, the error and the status. The
+// attributes of the command follow.
+var Leading = []string{"req", "cmd", "error-cmd", "idx", "error", "status"}
+
+// Response is a single reply. The Leading attributes come first, the others are written in
+// the order they were first set.
+type Response struct {
+	keys    []string
+	values  map[string]string
+	body    []string
+	hasBody bool
+}
+
+// New creates an empty response
+func New() *Response {
+	return &Response{values: make(map[string]string)}
+}
+
+// OK creates the reply of a command that succeeded
+func OK(cmd string) *Response {
+	return New().Set("cmd", cmd).Set("status", strconv.Itoa(int(StatusOK)))
+}
+
+// Error creates an error reply for the command
+func Error(cmd string, status Status, errType, desc string) *Response {
+	return New().
+		Set("error-cmd", cmd).
+		Set("error", errType).
+		Set("status", strconv.Itoa(int(status))).
+		Set("desc", desc)
+}
+
+// Set sets an attribute, replacing the value but keeping the position of an existing one
+func (r *Response) Set(key, value string) *Response {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = value
+	return r
+}
+
+// Setf sets an attribute to a formatted value, like Set
+func (r *Response) Setf(key, format string, args ...any) *Response {
+	return r.Set(key, fmt.Sprintf(format, args...))
+}
+
+// SetBool sets an attribute to t or f, like Set
+func (r *Response) SetBool(key string, value bool) *Response {
+	if value {
+		return r.Set(key, "t")
+	}
+	return r.Set(key, "f")
+}
+
+// Get returns an attribute value
+func (r *Response) Get(key string) (string, bool) {
+	value, ok := r.values[key]
+	return value, ok
+}
+
+// Body appends lines to the body, a response with an empty body still gets the body: marker
+func (r *Response) Body(lines ...string) *Response {
+	r.hasBody = true
+	r.body = append(r.body, lines...)
+	return r
+}
+
+// String returns the reply without the protocol header. Invalid UTF-8 is replaced with
+// U+FFFD, so the reply is always valid UTF-8.
+func (r *Response) String() string {
+	var sb strings.Builder
+	r.writeHead(&sb)
+	for _, line := range r.body {
+		sb.WriteString(line + "\n")
+	}
+	sb.WriteString("\n\n")
+	return strings.ToValidUTF8(sb.String(), string(utf8.RuneError))
+}
+
+// Head returns the reply up to its body, without the protocol header: the attrs block and
+// the body marker of a response with a body. A body too large to be built first is written
+// after it, its lines followed by "\n\n" to end the reply.
+func (r *Response) Head() string {
+	var sb strings.Builder
+	r.writeHead(&sb)
+	return strings.ToValidUTF8(sb.String(), string(utf8.RuneError))
+}
+
+// writeHead writes the attrs block, in the Leading order, and the body marker
+func (r *Response) writeHead(sb *strings.Builder) {
+	for _, key := range Leading {
+		if _, ok := r.values[key]; ok {
+			r.writeAttr(sb, key)
+		}
+	}
+	for _, key := range r.keys {
+		if !slices.Contains(Leading, key) {
+			r.writeAttr(sb, key)
+		}
+	}
+	if r.hasBody {
+		sb.WriteString("\nbody:\n")
+	}
+}
+
+func (r *Response) writeAttr(sb *strings.Builder, key string) {
+	// A line break inside a value would end the attrs block early
+	value := strings.ReplaceAll(r.values[key], "\n", " ")
+	sb.WriteString(key + ": " + value + "\n")
+}
+
+// WriteTo writes the header and the reply to w
+func (r *Response) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, Header+r.String())
+	return int64(n), err
+}
+
+// Sanitize makes client supplied text safe to echo: control characters are dropped and
+// text longer than MaxArgLen runes is truncated with "..."
+func Sanitize(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	if utf8.RuneCountInString(s) <= MaxArgLen {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:MaxArgLen]) + "..."
+}
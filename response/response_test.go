@@ -0,0 +1,97 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Response", func() {
+	It("should write attributes in the order they were set", func() {
+		resp := New().Set("cmd", "run").Set("status", "0").Set("pid", "42")
+		Expect(resp.String()).To(Equal("cmd: run\nstatus: 0\npid: 42\n\n\n"))
+	})
+
+	It("should write the leading attributes first, in their order", func() {
+		resp := New().Set("pid", "42").Set("status", "0").Set("idx", "7").Set("cmd", "run").Set("req", "a1")
+		Expect(resp.String()).To(Equal("req: a1\ncmd: run\nidx: 7\nstatus: 0\npid: 42\n\n\n"))
+	})
+
+	It("should format values", func() {
+		resp := New().Setf("len", "%d", 3).SetBool("pinned", true).SetBool("changed", false)
+		Expect(resp.String()).To(Equal("len: 3\npinned: t\nchanged: f\n\n\n"))
+	})
+
+	It("should keep the position of replaced attributes", func() {
+		resp := New().Set("cmd", "run").Set("status", "1").Set("pid", "42").Set("status", "0")
+		Expect(resp.String()).To(Equal("cmd: run\nstatus: 0\npid: 42\n\n\n"))
+
+		value, ok := resp.Get("status")
+		Expect(ok).To(BeTrue())
+		Expect(value).To(Equal("0"))
+	})
+
+	It("should write the body after the attrs block", func() {
+		resp := New().Set("len", "2").Body("1 Firefox", "2 Files")
+		Expect(resp.String()).To(Equal("len: 2\n\nbody:\n1 Firefox\n2 Files\n\n\n"))
+	})
+
+	It("should keep the body marker for an empty body", func() {
+		Expect(New().Set("len", "0").Body().String()).To(Equal("len: 0\n\nbody:\n\n\n"))
+	})
+
+	It("should not let a value break the attrs block", func() {
+		resp := New().Set("desc", "line one\nline two")
+		Expect(resp.String()).To(Equal("desc: line one line two\n\n\n"))
+	})
+
+	It("should replace invalid UTF-8 in attributes and body", func() {
+		resp := New().Set("path", "/opt/b\xe9b\xe9/run").Body("7 caf\xc3", "8 ok")
+		Expect(resp.String()).To(Equal("path: /opt/b\ufffdb\ufffd/run\n\nbody:\n7 caf\ufffd\n8 ok\n\n\n"))
+	})
+
+	It("should give the head of the reply for a body written after it", func() {
+		resp := New().Set("cmd", "export-index").Set("status", "0").Body("ignored")
+		Expect(resp.Head()).To(Equal("cmd: export-index\nstatus: 0\n\nbody:\n"))
+		Expect(New().Set("cmd", "nop").Head()).To(Equal("cmd: nop\n"))
+	})
+
+	It("should prefix the header when written", func() {
+		var buf bytes.Buffer
+		n, err := New().Set("cmd", "lang").WriteTo(&buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buf.String()).To(Equal("TXT01cmd: lang\n\n\n"))
+		Expect(n).To(Equal(int64(buf.Len())))
+	})
+
+	It("should build replies of commands that succeeded", func() {
+		Expect(OK("nop").String()).To(Equal("cmd: nop\nstatus: 0\n\n\n"))
+	})
+
+	It("should build error replies with the status code", func() {
+		resp := Error("run", StatusNotFound, "index not found", "no such entry")
+		Expect(resp.String()).To(Equal("error-cmd: run\nerror: index not found\nstatus: 3\ndesc: no such entry\n\n\n"))
+	})
+})
+
+var _ = Describe("Sanitize", func() {
+	It("should keep printable text", func() {
+		Expect(Sanitize("Файлы: ~/bin")).To(Equal("Файлы: ~/bin"))
+	})
+
+	It("should drop control characters", func() {
+		Expect(Sanitize("a\x1b[0mb\tc\r\x7f")).To(Equal("a[0mbc"))
+	})
+
+	It("should drop invalid UTF-8", func() {
+		Expect(Sanitize("a\xffb")).To(Equal("ab"))
+	})
+
+	It("should truncate long text by runes", func() {
+		long := strings.Repeat("я", MaxArgLen+10)
+		Expect(Sanitize(long)).To(Equal(strings.Repeat("я", MaxArgLen) + "..."))
+		Expect(Sanitize(strings.Repeat("x", MaxArgLen))).To(Equal(strings.Repeat("x", MaxArgLen)))
+	})
+})
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/0xADE/ade-ctld/internal/events"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+)
+
+// listen subscribes the server to the internal events: index swaps go out to the clients
+// that subscribed and prune expired sessions, rc file reloads start a reindex
+func (s *Server) listen(bus *events.Bus) {
+	s.bus = bus
+
+	// The hub coalesces and never blocks, it can run on the indexer's goroutine
+	events.Subscribe(bus, func(ev events.IndexSwapped) {
+		s.hub.Publish(ev.Generation, ev.Changes)
+	})
+	events.SubscribeAsync(bus, func(events.IndexSwapped) {
+		if s.runIndex == nil {
+			return
+		}
+		if _, err := s.runIndex.PruneSessions(timeNow()); err != nil {
+			log.Printf("[WARN] Failed to prune expired sessions: %v", err)
+		}
+	})
+	events.SubscribeAsync(bus, func(events.ConfigChanged) {
+		// Handlers must not hold the bus up for a whole reindex
+		go s.reindexForConfig()
+	})
+}
+
+// reindexForConfig rebuilds the index after the rc file changed, as its paths and scanner
+// roots may have
+func (s *Server) reindexForConfig() {
+	log.Printf("[DEBUG] Reindexing after the rc file changed")
+	req := indexer.ReindexRequest{Scope: indexer.FullScope(nil), Priority: indexer.PriorityBackground, Trigger: indexer.TriggerConfig, Fresh: true}
+	if _, err := s.indexer.Schedule(context.Background(), req); err != nil {
+		log.Printf("[ERROR] Reindex after the rc file changed failed: %v", err)
+	}
+}
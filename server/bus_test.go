@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/0xADE/ade-ctld/internal/events"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("internal events", func() {
+	var srv *session
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		srv.listen(events.NewBus())
+		srv.indexer.SetBus(srv.bus)
+	})
+
+	AfterEach(func() {
+		for _, proc := range srv.launcher.Running() {
+			srv.launcher.Kill(proc.PID)
+		}
+		srv.hub.Close()
+		srv.bus.Close()
+		srv.runIndex.Close()
+	})
+
+	It("should reindex when the rc file changed", func() {
+		swapped := make(chan events.IndexSwapped, 1)
+		events.SubscribeAsync(srv.bus, func(ev events.IndexSwapped) { swapped <- ev })
+
+		events.Publish(srv.bus, events.ConfigChanged{Path: "indexd.rc"})
+		Eventually(swapped, "10s").Should(Receive(HaveField("Generation", uint64(1))))
+		Expect(srv.indexer.Generation()).To(Equal(uint64(1)))
+	})
+
+	It("should publish the runs it counted", func() {
+		entry := &indexer.Entry{Name: "sleeper", Exec: "sleep", Path: "/usr/bin/sleep"}
+		srv.indexer.GetIndex().Add(entry)
+		recorded := make(chan events.RunRecorded, 1)
+		events.Subscribe(srv.bus, func(ev events.RunRecorded) { recorded <- ev })
+
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(`"file: 5`, itoa(entry.ID), "run"))
+		Expect(buf.String()).To(ContainSubstring("status: 0\n"))
+		Expect(recorded).To(Receive(And(HaveField("Path", "/usr/bin/sleep"), HaveField("PID", BeNumerically(">", 0)))))
+	})
+})
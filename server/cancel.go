@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// maxQueued is the number of commands of a connection read ahead of the one under way.
+// Reading waits once that many are queued, a cancel sent behind them is seen late.
+const maxQueued = 64
+
+// commandQueue holds the commands of a connection read but not answered yet, in the order
+// they came. They run one at a time in that order, so replies keep it, while the connection
+// goes on being read: a cancel is seen while the command it stops is under way.
+type commandQueue struct {
+	mu      sync.Mutex
+	pending []*queuedCommand // the first one is under way
+	ready   chan *queuedCommand
+}
+
+// queuedCommand is a command of the queue, run under a context of its own
+type queuedCommand struct {
+	id     string // request id, empty for untagged commands
+	ctx    context.Context
+	cancel context.CancelFunc
+	run    func(ctx context.Context)
+}
+
+func newCommandQueue() *commandQueue {
+	return &commandQueue{ready: make(chan *queuedCommand, maxQueued)}
+}
+
+// push queues run for the command tagged id, waiting while the queue is full
+func (q *commandQueue) push(id string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	qc := &queuedCommand{id: id, ctx: ctx, cancel: cancel, run: run}
+	q.mu.Lock()
+	q.pending = append(q.pending, qc)
+	q.mu.Unlock()
+	q.ready <- qc
+}
+
+// serve runs the queued commands until the queue is closed and emptied
+func (q *commandQueue) serve() {
+	for qc := range q.ready {
+		qc.run(qc.ctx)
+		qc.cancel()
+		q.mu.Lock()
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+	}
+}
+
+// close ends serve once the commands queued so far are answered
+func (q *commandQueue) close() {
+	close(q.ready)
+}
+
+// cancel stops the commands tagged id, queued or under way, or the one under way when id is
+// empty
+func (q *commandQueue) cancel(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, qc := range q.pending {
+		if id == "" && i == 0 || id != "" && qc.id == id {
+			qc.cancel()
+		}
+	}
+}
+
+// cancelAll stops every command, there's nobody left to answer
+func (q *commandQueue) cancelAll() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, qc := range q.pending {
+		qc.cancel()
+	}
+}
+
+// busy tells whether a command is queued or under way
+func (q *commandQueue) busy() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending) > 0
+}
+
+// cancelTarget returns the request id an untagged cancel command stops, empty for the
+// command under way. ok is false when the arguments aren't those of cancel, handleCancel
+// reports it.
+func cancelTarget(cmd *parser.Command) (id string, ok bool) {
+	switch {
+	case len(cmd.Args) == 0:
+		return "", true
+	case len(cmd.Args) == 1 && cmd.Args[0].Type == parser.TypeString && cmd.Args[0].Str != "":
+		return cmd.Args[0].Str, true
+	}
+	return "", false
+}
+
+// handleCancel answers a cancel, which took effect as soon as it was read, before the
+// commands queued ahead of it ran: see handleConnection
+func (s *session) handleCancel(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling cancel command")
+
+	if _, ok := cancelTarget(cmd); !ok {
+		s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", "cancel command takes at most the request id of the command to cancel")
+		return
+	}
+	s.writeResponse(conn, response.OK("cancel"))
+}
+
+// cmdContext returns the context of the command under way, done once it's cancelled
+func (s *session) cmdContext() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// cancelledResponse is the reply of a command stopped by cancel, the handler adds what it
+// did before
+func cancelledResponse(cmd *parser.Command) *response.Response {
+	return response.Error(cmd.Name, response.StatusCancelled, "cancelled", cmd.Name+" command was cancelled").SetBool("cancelled", true)
+}
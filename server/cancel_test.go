@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("cancel", func() {
+	const tools = 20000
+
+	var (
+		srv        *session
+		clientConn net.Conn
+		reader     *bufio.Reader
+	)
+
+	send := func(req string) {
+		_, err := clientConn.Write([]byte(req))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		srv = goldenServer(GinkgoT().TempDir())
+		for i := range tools {
+			srv.indexer.GetIndex().Add(&indexer.Entry{
+				Key:  "tool-" + itoa(int64(i)),
+				Name: "tool " + itoa(int64(i)),
+				Path: "/opt/tools/bin/tool-" + itoa(int64(i)),
+				Exec: "/opt/tools/bin/tool-" + itoa(int64(i)),
+			})
+		}
+
+		var serverConn net.Conn
+		clientConn, serverConn = net.Pipe()
+		srv.connWg.Add(1)
+		go srv.handleConnection(serverConn)
+		send("TXT01")
+		reader = bufio.NewReader(clientConn)
+	})
+
+	AfterEach(func() {
+		clientConn.Close()
+		srv.connWg.Wait()
+		srv.runIndex.Close()
+	})
+
+	// readExport reads an export-index reply up to its body and returns the number of
+	// records of the body
+	readExport := func() int {
+		head := readHead(reader)
+		Expect(head).To(ContainSubstring("cmd: export-index\nstatus: 0\n"))
+		records := 0
+		for {
+			line, err := reader.ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			if line == "\n" {
+				break
+			}
+			records++
+		}
+		line, err := reader.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		Expect(line).To(Equal("\n"))
+		return records
+	}
+
+	It("should stop a streamed export and keep the connection usable", func() {
+		send("export-index\n")
+		head := readHead(reader)
+		Expect(head).To(ContainSubstring("len: " + itoa(tools+4) + "\n"))
+		_, err := reader.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+
+		start := time.Now()
+		send("cancel\n")
+		records := 1
+		for {
+			line, err := reader.ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			if line == "\n" {
+				break
+			}
+			records++
+		}
+		_, err = reader.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(BeNumerically("<", tools+4))
+
+		cancelled := readFrame(reader)
+		Expect(cancelled).To(HavePrefix("error-cmd: export-index\nerror: cancelled\nstatus: 10\n"))
+		Expect(cancelled).To(ContainSubstring("cancelled: t\nsent: " + itoa(int64(records)) + "\n"))
+		Expect(readFrame(reader)).To(Equal("cmd: cancel\nstatus: 0\n\n\n"))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+		send("list\n")
+		Expect(readFrame(reader)).To(ContainSubstring("list-len: 128\n"))
+	})
+
+	It("should stop a queued command by its request id", func() {
+		// The export holds the queue until it's read
+		send("export-index\n\"req: a\nlist\n\"req: b\nlist\n\"a\ncancel\n")
+
+		Expect(readExport()).To(Equal(tools + 4))
+		Expect(readFrame(reader)).To(Equal("req: a\nerror-cmd: list\nerror: cancelled\nstatus: 10\ndesc: list command was cancelled\ncancelled: t\n\n\n"))
+		Expect(readFrame(reader)).To(HavePrefix("req: b\nlen: " + itoa(tools+4) + "\nlist-len: 128\n"))
+		Expect(readFrame(reader)).To(Equal("cmd: cancel\nstatus: 0\n\n\n"))
+	})
+
+	It("should leave the next commands alone", func() {
+		send("cancel\nlist\n")
+
+		Expect(readFrame(reader)).To(Equal("cmd: cancel\nstatus: 0\n\n\n"))
+		Expect(readFrame(reader)).To(ContainSubstring("list-len: 128\n"))
+	})
+})
+
+// readHead reads a reply with a body up to the body marker
+func readHead(reader *bufio.Reader) string {
+	var sb strings.Builder
+	for !strings.HasSuffix(sb.String(), "\nbody:\n") {
+		line, err := reader.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
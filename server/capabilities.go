@@ -0,0 +1,92 @@
+package server
+
+import (
+	"log"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// protocols lists the protocol headers the server accepts
+var protocols = []string{"TXT01"}
+
+// feature is an optional feature clients can ask for by name, advertised only when enabled
+// reports it's available
+type feature struct {
+	name    string
+	enabled func(s *Server) bool
+}
+
+// features are derived from the command registry and the configuration, so they can't be
+// advertised without being served
+var features = []feature{
+	{"freq", withRunIndex(hasCommand("runstats"))},    // lists ordered by run frequency, run statistics
+	{"events", hasCommand("subscribe")},               // index change notifications
+	{"sessions", withRunIndex(hasCommand("session"))}, // persisted filters and language
+	{"search", hasCommand("search")},                  // ranked one-shot search
+	{"diff", hasCommand("diff")},                      // index generation history
+	{"exclude-cat", hasCommand("-filter-cat")},        // category exclusion
+	{"categories", hasCommand("categories")},          // category list with localized names
+	{"dry-run", func(*Server) bool { return true }},   // "opt: dry-run" previews of mutating commands
+	{"sort", func(*Server) bool { return true }},      // "sort: option of list and list-next
+	{"cursor", func(*Server) bool { return true }},    // list-next resuming from a cursor
+	{"req-id", func(*Server) bool { return true }},    // "req: <id> echoed in the reply
+	{"actions", func(*Server) bool { return true }},   // "action: <key> of run, desktop actions
+	{"revisions", func(*Server) bool { return true }}, // "opt: revisions of list and list-next
+	{"runs", func(*Server) bool { return true }},      // "opt: runs of list and list-next
+	{"heartbeat", func(*Server) bool { return config.Get().Heartbeat() > 0 }},
+	{"recent-files", func(*Server) bool { return config.Get().RecentFiles() }},
+	{"wrappers", func(s *Server) bool { return s.inspectWrappers }},
+	{"system", func(s *Server) bool { return s.system }},            // read-only daemon shared by all users
+	{"upstream", func(s *Server) bool { return s.upstream != nil }}, // mirrors the index of the system daemon
+}
+
+// withRunIndex disables a feature stored in the run index while it's unavailable
+func withRunIndex(enabled func(*Server) bool) func(*Server) bool {
+	return func(s *Server) bool {
+		return s.runIndex != nil && enabled(s)
+	}
+}
+
+func hasCommand(name string) func(*Server) bool {
+	return func(*Server) bool {
+		_, ok := commands[name]
+		return ok
+	}
+}
+
+// handleCapabilities tells clients what this daemon supports, so they don't have to probe
+// for unknown commands
+func (s *Server) handleCapabilities(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling capabilities command")
+
+	var enabled []string
+	for _, f := range features {
+		if f.enabled(s) {
+			enabled = append(enabled, f.name)
+		}
+	}
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	resp := response.OK("capabilities").
+		Set("protocols", strings.Join(protocols, " ")).
+		Set("features", strings.Join(enabled, " ")).
+		Setf("list-limit", "%d", config.Get().ListLimit()).
+		Setf("len", "%d", len(names)).
+		Body(names...)
+	s.writeResponse(conn, resp)
+}
+
+// handleNop acknowledges the request and does nothing else, for clients checking the
+// connection or keeping it alive. Arguments are ignored, so it also carries comments.
+func (s *Server) handleNop(conn net.Conn, _ *parser.Command) {
+	s.writeResponse(conn, response.OK("nop"))
+}
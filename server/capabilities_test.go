@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("handleCapabilities", func() {
+	var srv *session
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+	})
+
+	AfterEach(func() {
+		if srv.runIndex != nil {
+			srv.runIndex.Close()
+		}
+	})
+
+	capabilities := func() (string, []string) {
+		var buf bytes.Buffer
+		srv.handleCapabilities(&mockConn{writeBuf: &buf}, &parser.Command{Name: "capabilities"})
+		response := buf.String()
+		_, body, _ := strings.Cut(response, "body:\n")
+		return response, strings.Fields(body)
+	}
+
+	It("should advertise the protocol, features and limits", func() {
+		response, names := capabilities()
+		Expect(response).To(HavePrefix("TXT01cmd: capabilities\nstatus: 0\nprotocols: TXT01\n"))
+		Expect(attrLine(response, "list-limit")).NotTo(BeEmpty())
+		Expect(attrLine(response, "len")).To(Equal(itoa(int64(len(names)))))
+
+		advertised := strings.Fields(attrLine(response, "features"))
+		Expect(advertised).To(ContainElements("freq", "events", "sessions"))
+		Expect(advertised).NotTo(ContainElement("wrappers"))
+
+		srv.runIndex.Close()
+		srv.runIndex = nil
+		response, _ = capabilities()
+		advertised = strings.Fields(attrLine(response, "features"))
+		Expect(advertised).NotTo(ContainElement("freq"))
+		Expect(advertised).NotTo(ContainElement("sessions"))
+
+		srv.inspectWrappers = true
+		response, _ = capabilities()
+		Expect(strings.Fields(attrLine(response, "features"))).To(ContainElement("wrappers"))
+	})
+
+	It("should only advertise commands that parse and dispatch", func() {
+		_, names := capabilities()
+		Expect(names).To(HaveLen(len(commands)))
+		Expect(names).To(ContainElement("capabilities"))
+
+		for _, name := range names {
+			p, err := parser.NewParser(strings.NewReader("TXT01" + name + "\n"))
+			Expect(err).NotTo(HaveOccurred())
+			cmd, err := p.ParseCommand()
+			Expect(err).NotTo(HaveOccurred(), name)
+			Expect(cmd.Name).To(Equal(name), "parser doesn't know %s", name)
+
+			c, ok := commands[cmd.Name]
+			Expect(ok).To(BeTrue(), name)
+			Expect(c.handle).NotTo(BeNil(), name)
+		}
+	})
+
+	It("should drop a feature along with its command", func() {
+		response, _ := capabilities()
+		Expect(strings.Fields(attrLine(response, "features"))).To(ContainElement("diff"))
+
+		saved := commands["diff"]
+		delete(commands, "diff")
+		defer func() { commands["diff"] = saved }()
+
+		response, names := capabilities()
+		Expect(names).NotTo(ContainElement("diff"))
+		Expect(strings.Fields(attrLine(response, "features"))).NotTo(ContainElement("diff"))
+	})
+})
+
+var _ = Describe("handleNop", func() {
+	It("should succeed without touching the filters or the index", func() {
+		srv := newTestServer(GinkgoT().TempDir())
+		DeferCleanup(srv.runIndex.Close)
+		srv.indexer.GetIndex().Add(&indexer.Entry{Name: "firefox", Path: "/usr/bin/firefox"})
+		srv.executeCommand(&mockConn{}, parseRequest(`"fire`, "filter-name"))
+		nameFilters := srv.filters.nameFilters
+		generation := srv.indexer.Generation()
+
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(`"keep-alive`, "nop"))
+		Expect(buf.String()).To(Equal("TXT01cmd: nop\nstatus: 0\n\n\n"))
+		Expect(srv.filters.nameFilters).To(Equal(nameFilters))
+		Expect(srv.indexer.Generation()).To(Equal(generation))
+		Expect(srv.indexer.GetIndex().Count()).To(Equal(1))
+	})
+})
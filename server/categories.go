@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// categoryNames maps the freedesktop main categories to display names, per language. English
+// is the fallback for languages without a table, categories missing from it are shown raw.
+var categoryNames = map[string]map[string]string{
+	"en": {
+		"AudioVideo":  "Multimedia",
+		"Audio":       "Audio",
+		"Video":       "Video",
+		"Development": "Development",
+		"Education":   "Education",
+		"Game":        "Games",
+		"Graphics":    "Graphics",
+		"Network":     "Internet",
+		"Office":      "Office",
+		"Science":     "Science",
+		"Settings":    "Settings",
+		"System":      "System",
+		"Utility":     "Accessories",
+	},
+	"de": {
+		"AudioVideo":  "Multimedia",
+		"Audio":       "Audio",
+		"Video":       "Video",
+		"Development": "Entwicklung",
+		"Education":   "Bildung",
+		"Game":        "Spiele",
+		"Graphics":    "Grafik",
+		"Network":     "Internet",
+		"Office":      "Büro",
+		"Science":     "Wissenschaft",
+		"Settings":    "Einstellungen",
+		"System":      "System",
+		"Utility":     "Zubehör",
+	},
+	"fr": {
+		"AudioVideo":  "Multimédia",
+		"Audio":       "Audio",
+		"Video":       "Vidéo",
+		"Development": "Développement",
+		"Education":   "Éducation",
+		"Game":        "Jeux",
+		"Graphics":    "Graphisme",
+		"Network":     "Internet",
+		"Office":      "Bureautique",
+		"Science":     "Science",
+		"Settings":    "Paramètres",
+		"System":      "Système",
+		"Utility":     "Accessoires",
+	},
+	"es": {
+		"AudioVideo":  "Multimedia",
+		"Audio":       "Audio",
+		"Video":       "Vídeo",
+		"Development": "Desarrollo",
+		"Education":   "Educación",
+		"Game":        "Juegos",
+		"Graphics":    "Gráficos",
+		"Network":     "Internet",
+		"Office":      "Oficina",
+		"Science":     "Ciencia",
+		"Settings":    "Configuración",
+		"System":      "Sistema",
+		"Utility":     "Accesorios",
+	},
+	"it": {
+		"AudioVideo":  "Multimedia",
+		"Audio":       "Audio",
+		"Video":       "Video",
+		"Development": "Programmazione",
+		"Education":   "Istruzione",
+		"Game":        "Giochi",
+		"Graphics":    "Grafica",
+		"Network":     "Internet",
+		"Office":      "Ufficio",
+		"Science":     "Scienza",
+		"Settings":    "Impostazioni",
+		"System":      "Sistema",
+		"Utility":     "Accessori",
+	},
+	"pt": {
+		"AudioVideo":  "Multimídia",
+		"Audio":       "Áudio",
+		"Video":       "Vídeo",
+		"Development": "Desenvolvimento",
+		"Education":   "Educação",
+		"Game":        "Jogos",
+		"Graphics":    "Gráficos",
+		"Network":     "Internet",
+		"Office":      "Escritório",
+		"Science":     "Ciência",
+		"Settings":    "Configurações",
+		"System":      "Sistema",
+		"Utility":     "Acessórios",
+	},
+	"ru": {
+		"AudioVideo":  "Мультимедиа",
+		"Audio":       "Аудио",
+		"Video":       "Видео",
+		"Development": "Разработка",
+		"Education":   "Образование",
+		"Game":        "Игры",
+		"Graphics":    "Графика",
+		"Network":     "Интернет",
+		"Office":      "Офис",
+		"Science":     "Наука",
+		"Settings":    "Настройки",
+		"System":      "Система",
+		"Utility":     "Стандартные",
+	},
+}
+
+// localizedCategory returns the display name of a category in lang, which may be a full
+// locale like de_DE.UTF-8: the table of the exact locale wins over the one of its language
+func localizedCategory(category, lang string) string {
+	lang, _, _ = strings.Cut(lang, ".")
+	lang, _, _ = strings.Cut(lang, "@")
+	language, _, _ := strings.Cut(lang, "_")
+	for _, locale := range []string{lang, language, "en"} {
+		if name, ok := categoryNames[locale][category]; ok {
+			return name
+		}
+	}
+	return category
+}
+
+// handleCategories lists the categories of the indexed applications with their entry counts
+// and display names in the language of the session. Filters take the raw names.
+func (s *session) handleCategories(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling categories command")
+
+	counts := make(map[string]int)
+	for _, entry := range s.indexer.GetIndex().GetAll() {
+		for _, category := range entry.Categories {
+			if category != "" {
+				counts[category]++
+			}
+		}
+	}
+	categories := make([]string, 0, len(counts))
+	for category := range counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	resp := response.OK("categories").Set("lang", s.lang).Setf("len", "%d", len(categories)).Body()
+	for _, category := range categories {
+		resp.Body(fmt.Sprintf("%s %d %s", category, counts[category], localizedCategory(category, s.lang)))
+	}
+	s.writeResponse(conn, resp)
+}
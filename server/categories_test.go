@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("handleCategories", func() {
+	var (
+		srv     *session
+		builder int64
+	)
+
+	BeforeEach(func() {
+		srv = (&Server{indexer: indexer.NewIndexer()}).newSession()
+		index := srv.indexer.GetIndex()
+		builder = index.Add(&indexer.Entry{Name: "Builder", Categories: []string{"Development", "GNOME"}})
+		index.Add(&indexer.Entry{Name: "Emacs", Categories: []string{"Development", "TextEditor", "Utility"}})
+		index.Add(&indexer.Entry{Name: "htop"})
+	})
+
+	categories := func() (string, []string) {
+		var buf bytes.Buffer
+		srv.handleCategories(&mockConn{writeBuf: &buf}, &parser.Command{Name: "categories"})
+		response := buf.String()
+		_, body, _ := strings.Cut(response, "body:\n")
+		return response, strings.Split(strings.TrimSpace(body), "\n")
+	}
+
+	It("should list categories with English display names by default", func() {
+		response, lines := categories()
+		Expect(response).To(HavePrefix("TXT01cmd: categories\nstatus: 0\nlang: en\nlen: 4\n"))
+		Expect(lines).To(Equal([]string{
+			"Development 2 Development",
+			"GNOME 1 GNOME",
+			"TextEditor 1 TextEditor",
+			"Utility 1 Accessories",
+		}))
+	})
+
+	It("should show display names in the current language", func() {
+		srv.lang = "de"
+		response, lines := categories()
+		Expect(attrLine(response, "lang")).To(Equal("de"))
+		Expect(lines).To(ContainElements("Development 2 Entwicklung", "Utility 1 Zubehör", "GNOME 1 GNOME"))
+	})
+
+	It("should keep filtering on the raw category", func() {
+		srv.lang = "de"
+		localized := FilterExpr{Values: []string{"Entwicklung"}, Op: orOp}
+		raw := FilterExpr{Values: []string{"Development"}, Op: orOp}
+		for _, entry := range srv.indexer.GetIndex().GetAll() {
+			Expect(srv.matchesCatFilter(entry, localized)).To(BeFalse())
+		}
+		entry, _ := srv.indexer.GetIndex().Get(builder)
+		Expect(srv.matchesCatFilter(entry, raw)).To(BeTrue())
+	})
+
+	DescribeTable("localizedCategory",
+		func(category, lang, name string) {
+			Expect(localizedCategory(category, lang)).To(Equal(name))
+		},
+		Entry("language", "Development", "de", "Entwicklung"),
+		Entry("full locale", "Development", "de_AT.UTF-8@euro", "Entwicklung"),
+		Entry("non-Latin script", "Game", "ru_RU", "Игры"),
+		Entry("language without a table", "Game", "sv", "Games"),
+		Entry("unknown category", "TextEditor", "fr", "TextEditor"),
+	)
+})
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"net"
+
+	"github.com/0xADE/ade-ctld/parser"
+)
+
+// command describes a protocol command: its usage line (arguments in the order they are
+// pushed, then the command name) and the handler serving it. Commands changing state beyond
+// the connection are mutating; "opt: dry-run" runs their dryRun handler instead, which
+// reports what would change and must not change anything. Mutating commands without one
+// refuse dry-runs. Stateful commands change the filters, language or order of the session of
+// the connection; like mutating ones, the system daemon refuses them: user daemons mirror
+// its whole index and filter it themselves. Handlers get the session of the connection.
+type command struct {
+	usage    string
+	handle   func(s *session, conn net.Conn, cmd *parser.Command)
+	mutating bool
+	stateful bool
+	dryRun   func(s *session, conn net.Conn, cmd *parser.Command)
+}
+
+// commands is the registry of commands served by the daemon. It's filled in init because
+// handlers look their usage up here when reporting errors.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"filter-name": {
+			usage:    `<name:str>... [and|or|not] filter-name`,
+			handle:   (*session).handleFilterNameReplace,
+			stateful: true,
+		},
+		"+filter-name": {
+			usage:    `["opt: allow-empty] <name:str>... [and|or|not] +filter-name`,
+			handle:   (*session).handleAddFilterName,
+			stateful: true,
+		},
+		"+filter-cat": {
+			usage:    `["opt: allow-empty] <category:str>... [and|or|not] +filter-cat`,
+			handle:   (*session).handleFilterCat,
+			stateful: true,
+		},
+		"-filter-cat": {
+			usage:    `<category:str>... -filter-cat`,
+			handle:   (*session).handleExcludeCat,
+			stateful: true,
+		},
+		"+filter-path": {
+			usage:    `["opt: allow-empty] <path:str>... [and|or|not] +filter-path`,
+			handle:   (*session).handleFilterPath,
+			stateful: true,
+		},
+		"0filters": {
+			usage:    `0filters`,
+			handle:   func(s *session, conn net.Conn, _ *parser.Command) { s.handleResetFilters(conn) },
+			stateful: true,
+		},
+		"list": {
+			usage:  `["sort: freq|name|mtime] ["opt: revisions] ["opt: runs] ["opt: self-tools] list`,
+			handle: (*session).handleList,
+		},
+		"list-next": {
+			usage:  `["sort: freq|name|mtime] ["opt: revisions] ["opt: runs] ["opt: self-tools] <offset:int> [limit:int] list-next`,
+			handle: (*session).handleListNext,
+		},
+		"search": {
+			usage:  `["top: <n>] <query:str> [limit:int] search`,
+			handle: (*session).handleSearch,
+		},
+		"run": {
+			usage:    `["opt: terminal] ["opt: nice=<n>] ["action: <key>] ["file: <path>] ["args: <command line>] <id:int>|<key:str> run`,
+			handle:   (*session).handleRun,
+			mutating: true,
+			dryRun:   (*session).dryRunRun,
+		},
+		"lang": {
+			usage:    `<locale:str> lang`,
+			handle:   (*session).handleLang,
+			stateful: true,
+		},
+		"sort": {
+			usage:    `<order:str> sort`,
+			handle:   (*session).handleSort,
+			stateful: true,
+		},
+		"reindex": {
+			usage:    `["opt: rc | [path:str]...] reindex`,
+			handle:   (*session).handleReindex,
+			mutating: true,
+			dryRun:   (*session).dryRunReindex,
+		},
+		"categories": {
+			usage:  `categories`,
+			handle: (*session).handleCategories,
+		},
+		"clearcache": {
+			usage:    `"index|"runs|"all clearcache`,
+			handle:   (*session).handleClearCache,
+			mutating: true,
+			dryRun:   (*session).dryRunClearCache,
+		},
+		"kill": {
+			usage:    `<pid:int> kill`,
+			handle:   (*session).handleKill,
+			mutating: true,
+			dryRun:   (*session).dryRunKill,
+		},
+		"pin": {
+			usage:    `<id:int>|<key:str> pin`,
+			handle:   func(s *session, conn net.Conn, cmd *parser.Command) { s.handlePin(conn, cmd, true) },
+			mutating: true,
+			dryRun:   func(s *session, conn net.Conn, cmd *parser.Command) { s.dryRunPin(conn, cmd, true) },
+		},
+		"unpin": {
+			usage:    `<id:int>|<key:str> unpin`,
+			handle:   func(s *session, conn net.Conn, cmd *parser.Command) { s.handlePin(conn, cmd, false) },
+			mutating: true,
+			dryRun:   func(s *session, conn net.Conn, cmd *parser.Command) { s.dryRunPin(conn, cmd, false) },
+		},
+		"info": {
+			usage:  `<id:int>|<key:str> info`,
+			handle: (*session).handleInfo,
+		},
+		"get-many": {
+			usage:  `<id:int>|<key:str>... get-many`,
+			handle: (*session).handleGetMany,
+		},
+		"lang-list": {
+			usage:  `<id:int>|<key:str> lang-list`,
+			handle: (*session).handleLangList,
+		},
+		"gc": {
+			usage:    `gc`,
+			handle:   (*session).handleGC,
+			mutating: true,
+			dryRun:   (*session).dryRunGC,
+		},
+		"session": {
+			usage:    `"persist|"resume [token:str] session`,
+			handle:   (*session).handleSession,
+			mutating: true,
+		},
+		"subscribe": {
+			usage:  `subscribe`,
+			handle: (*session).handleSubscribe,
+		},
+		"unsubscribe": {
+			usage:  `unsubscribe`,
+			handle: (*session).handleUnsubscribe,
+		},
+		"stats": {
+			usage:  `["children] stats`,
+			handle: (*session).handleStats,
+		},
+		"runstats": {
+			usage:  `[top:int] runstats`,
+			handle: (*session).handleRunStats,
+		},
+		"diff": {
+			usage:  `[gen-a:int] [gen-b:int] diff`,
+			handle: (*session).handleDiff,
+		},
+		"export-index": {
+			usage:  `export-index`,
+			handle: (*session).handleExportIndex,
+		},
+		"saveconf": {
+			usage:    `saveconf`,
+			handle:   (*session).handleSaveConf,
+			mutating: true,
+			dryRun:   (*session).dryRunSaveConf,
+		},
+		"config": {
+			usage:  `["opt: origin] config`,
+			handle: (*session).handleConfig,
+		},
+		"capabilities": {
+			usage:  `capabilities`,
+			handle: (*session).handleCapabilities,
+		},
+		"nop": {
+			usage:  `[<any>...] nop`,
+			handle: (*session).handleNop,
+		},
+		"sources": {
+			usage:  `["opt: roots] sources`,
+			handle: (*session).handleSources,
+		},
+		"reindex-status": {
+			usage:  `reindex-status`,
+			handle: (*session).handleReindexStatus,
+		},
+		"which": {
+			usage:  `<path:str>|<pid:int> which`,
+			handle: (*session).handleWhich,
+		},
+		"broken": {
+			usage:  `broken`,
+			handle: (*session).handleBroken,
+		},
+		"handler": {
+			usage:  `<scheme:str>|<mime:str> handler`,
+			handle: (*session).handleHandler,
+		},
+		"cancel": {
+			usage:  `[id:str] cancel`,
+			handle: (*session).handleCancel,
+		},
+		"bye": {
+			usage:  `bye`,
+			handle: (*session).handleBye,
+		},
+	}
+}
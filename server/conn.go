@@ -0,0 +1,418 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/perm"
+	"github.com/0xADE/ade-ctld/internal/redact"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// ErrUnsafeSocketDir is returned when the socket directory could be controlled by another user
+var ErrUnsafeSocketDir = errors.New("unsafe socket directory")
+
+// Accept retry delays used when the listener reports an error while the server is running
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// syncConn serializes writes to a connection, so events pushed to subscribers don't
+// interleave with command replies. With an idle timeout, reads fail once nothing was read
+// or written for that long; replies, events and heartbeats all count as activity.
+type syncConn struct {
+	net.Conn
+	mu   sync.Mutex
+	idle time.Duration
+}
+
+func (c *syncConn) Read(b []byte) (int, error) {
+	c.touch()
+	return c.Conn.Read(b)
+}
+
+func (c *syncConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.Conn.Write(b)
+	c.touch()
+	return n, err
+}
+
+// hold locks the connection for a reply written in several writes, made through w: events
+// wait until release is called
+func (c *syncConn) hold() (w io.Writer, release func()) {
+	c.mu.Lock()
+	return heldConn{c}, c.mu.Unlock
+}
+
+// heldConn writes to a syncConn whose lock is held, see hold
+type heldConn struct {
+	c *syncConn
+}
+
+func (h heldConn) Write(b []byte) (int, error) {
+	n, err := h.c.Conn.Write(b)
+	h.c.touch()
+	return n, err
+}
+
+// touch pushes the idle deadline back
+func (c *syncConn) touch() {
+	if c.idle > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.idle))
+	}
+}
+
+// listenSocket creates the socket directory if needed and binds the socket, replacing a
+// stale one. A shared socket, the system daemon's, can be connected to by every user.
+func listenSocket(socketPath string, shared bool) (net.Listener, error) {
+	socketDir := filepath.Dir(socketPath)
+	// Existing dirs are left alone, the socket may have been put in any dir of the user
+	dirMode := perm.Dir()
+	if shared {
+		dirMode = 0755
+	}
+	if err := os.MkdirAll(socketDir, dirMode); err != nil {
+		return nil, socketError("failed to create socket dir", socketDir, err)
+	}
+	// The default dir lives in /tmp, where anyone could have created it before us
+	if err := checkSocketDir(socketDir, os.Getuid()); err != nil {
+		return nil, err
+	}
+
+	// Remove existing socket if it exists
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, socketError("failed to bind socket", socketPath, err)
+	}
+	// The system daemon is reached by every user, that of a user by those the policy lets in
+	mode := perm.File()
+	if shared {
+		mode = 0666
+	}
+	if err := os.Chmod(socketPath, mode); err != nil {
+		listener.Close()
+		return nil, socketError("failed to set socket permissions", socketPath, err)
+	}
+	return listener, nil
+}
+
+// socketError wraps a socket setup error with what failed and, for the usual causes, what
+// to do about it
+func socketError(action, path string, err error) error {
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return fmt.Errorf("%s %s: %w (another daemon may be running)", action, path, err)
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return fmt.Errorf("%s %s: %w (check permissions of %s)", action, path, err, filepath.Dir(path))
+	default:
+		return fmt.Errorf("%s %s: %w", action, path, err)
+	}
+}
+
+// checkSocketDir refuses socket directories that other users could tamper with: the dir
+// must be a real directory owned by uid and not writable by group or others
+func checkSocketDir(dir string, uid int) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrUnsafeSocketDir, dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != uid {
+		return fmt.Errorf("%w: %s is owned by uid %d, expected %d", ErrUnsafeSocketDir, dir, stat.Uid, uid)
+	}
+	if perm := info.Mode().Perm(); perm&0022 != 0 {
+		return fmt.Errorf("%w: %s has mode %04o, it must not be writable by group or others", ErrUnsafeSocketDir, dir, perm)
+	}
+	return nil
+}
+
+// Start starts the server. It returns when the context is cancelled or Stop is called.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	// Accept blocks, so the listener has to be closed to notice cancellation
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-done:
+		}
+	}()
+
+	go s.collectLogsLoop(done)
+	if s.upstream != nil {
+		go s.followUpstream(done)
+	}
+	if s.idleExit > 0 {
+		go s.exitWhenIdle(done)
+	}
+
+	var delay time.Duration
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			s.mu.RLock()
+			running := s.running
+			s.mu.RUnlock()
+			if !running {
+				return nil
+			}
+
+			// Errors like EMFILE are transient: back off instead of spinning
+			if delay == 0 {
+				delay = minAcceptDelay
+			} else {
+				delay = min(delay*2, maxAcceptDelay)
+			}
+			log.Printf("[WARN] Accept failed: %v; retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		if !s.trackConn(conn) {
+			conn.Close()
+			return nil
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// Stop stops the server, closes all client connections and waits for their handlers to return.
+// It is safe to call Stop more than once.
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	s.running = false
+	err := s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	if s.hub != nil {
+		s.hub.Close()
+	}
+	s.connWg.Wait()
+	// Handlers still queued may need the run index
+	s.bus.Close()
+
+	if s.runIndex != nil {
+		if closeErr := s.runIndex.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// trackConn registers an accepted connection so Stop can close it.
+// Returns false if the server is already stopped.
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.connWg.Add(1)
+	s.touchActive()
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.touchActive()
+	s.connWg.Done()
+}
+
+func (s *Server) handleConnection(rawConn net.Conn) {
+	defer s.untrackConn(rawConn)
+	defer rawConn.Close()
+
+	conn := &syncConn{Conn: rawConn, idle: s.idle}
+	if s.hub != nil {
+		defer s.hub.Unsubscribe(conn)
+	}
+
+	log.Printf("[DEBUG] New connection accepted")
+	sess := s.newSession()
+
+	// Errors not tied to a command are reported on behalf of the parser
+	parserCommand := &parser.Command{Name: "parser"}
+
+	p, err := parser.NewParser(conn)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create parser: %v", err)
+		s.writeError(conn, parserCommand, response.StatusParseError, "invalid header", err.Error())
+		return
+	}
+	p.SetUTF8Policy(s.utf8Policy)
+
+	// Commands run in the order they came while the connection goes on being read, so a
+	// cancel reaches the command under way. Errors are queued too, to keep replies in order.
+	queue := newCommandQueue()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		queue.serve()
+	}()
+	defer func() {
+		queue.close()
+		<-served
+	}()
+
+	for {
+		cmd, err := p.ParseCommand()
+		if err == io.EOF {
+			log.Printf("[DEBUG] Connection closed by client")
+			break
+		}
+		if err != nil {
+			// Only syntax errors leave the stream usable, anything else is a dead connection
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				// A command taking longer than the idle timeout keeps the client waiting
+				if queue.busy() {
+					continue
+				}
+				log.Printf("[DEBUG] Closing idle connection")
+				break
+			}
+			// The whole command was read, it's refused like one with a bad argument
+			var encErr *parser.EncodingError
+			if errors.As(err, &encErr) {
+				log.Printf("[ERROR] Command %s rejected: %v", encErr.Command, err)
+				queue.push("", func(context.Context) {
+					s.writeError(conn, &parser.Command{Name: encErr.Command}, response.StatusBadArgument, "invalid utf-8", err.Error())
+				})
+				continue
+			}
+			if !errors.Is(err, parser.ErrSyntax) {
+				log.Printf("[DEBUG] Connection read failed: %v", err)
+				queue.cancelAll()
+				break
+			}
+			log.Printf("[ERROR] Parse error: %s", redact.String(err.Error()))
+			queue.push("", func(context.Context) {
+				s.writeError(conn, parserCommand, response.StatusParseError, "parse error", err.Error())
+			})
+			continue
+		}
+
+		id, rest, _ := takeRequestID(cmd)
+		if rest.Name == "cancel" {
+			if target, ok := cancelTarget(rest); ok {
+				log.Printf("[DEBUG] Cancelling command %s", redact.String(target))
+				queue.cancel(target)
+			}
+		}
+		queue.push(id, func(ctx context.Context) {
+			log.Printf("[DEBUG] Executing command: %s with %d args", cmd.Name, len(cmd.Args))
+			sess.ctx = ctx
+			sess.executeCommand(conn, cmd)
+		})
+		if isBye(rest) {
+			// Anything sent after it is left unread, the connection closes once it's answered
+			log.Printf("[DEBUG] Connection closed by bye")
+			break
+		}
+	}
+}
+
+// isBye tells whether an untagged command is a bye closing the connection, one with
+// arguments is refused instead
+func isBye(cmd *parser.Command) bool {
+	if rest, ok := takeDryRun(cmd); ok {
+		cmd = rest
+	}
+	return cmd.Name == "bye" && len(cmd.Args) == 0
+}
+
+// handleBye acknowledges a bye, the connection reading it closes once it's answered: see
+// handleConnection
+func (s *Server) handleBye(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling bye command")
+
+	if !isBye(cmd) {
+		s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", "bye command takes no arguments")
+		return
+	}
+	s.writeResponse(conn, response.OK("bye"))
+}
+
+func (s *session) executeCommand(conn net.Conn, cmd *parser.Command) {
+	s.touchActive()
+	if id, rest, ok := takeRequestID(cmd); ok {
+		if id == "" {
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid request id", "request id must not be empty")
+			return
+		}
+		conn, cmd = &requestConn{Conn: conn, id: id}, rest
+	}
+	if s.cmdContext().Err() != nil {
+		// Cancelled before its turn came
+		s.writeResponse(conn, cancelledResponse(cmd))
+		return
+	}
+
+	c, ok := commands[cmd.Name]
+	if !ok {
+		s.writeError(conn, cmd, response.StatusUnknownCommand, "unknown command", "Command not recognized")
+		return
+	}
+	if s.system && (c.mutating || c.stateful) {
+		// Every user shares the system daemon, their own daemons run and filter
+		s.writeError(conn, cmd, response.StatusUnavailable, "read-only", cmd.Name+" command isn't served by the system daemon, send it to the daemon of your session")
+		return
+	}
+
+	if rest, ok := takeDryRun(cmd); ok {
+		switch {
+		case c.dryRun != nil:
+			c.dryRun(s, conn, rest)
+			return
+		case c.mutating:
+			s.writeError(conn, cmd, response.StatusBadArgument, "dry-run unsupported", cmd.Name+" command can't be previewed")
+			return
+		}
+		log.Printf("[WARN] Ignoring %s of %s command, it changes nothing", dryRunOpt, cmd.Name)
+		cmd = rest
+	}
+	c.handle(s, conn, cmd)
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"time"
+	"unicode/utf8"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("handleConnection encoding", func() {
+	var (
+		srv        *session
+		clientConn net.Conn
+		reader     *bufio.Reader
+		id         int64
+	)
+
+	// connect starts serving a connection with the given policy for invalid UTF-8
+	connect := func(policy parser.UTF8Policy) {
+		srv.utf8Policy = policy
+		var serverConn net.Conn
+		clientConn, serverConn = net.Pipe()
+		srv.connWg.Add(1)
+		go srv.handleConnection(serverConn)
+
+		_, err := clientConn.Write([]byte("TXT01"))
+		Expect(err).NotTo(HaveOccurred())
+		reader = bufio.NewReader(clientConn)
+	}
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		id = srv.indexer.GetIndex().Add(&indexer.Entry{Name: "café", Path: "/opt/caf\xe9/run", Exec: "/opt/caf\xe9/run"})
+	})
+
+	AfterEach(func() {
+		clientConn.Close()
+		srv.connWg.Wait()
+		srv.runIndex.Close()
+	})
+
+	send := func(req string) string {
+		_, err := clientConn.Write([]byte(req))
+		Expect(err).NotTo(HaveOccurred())
+		return readFrame(reader)
+	}
+
+	It("should refuse a command with invalid UTF-8 and serve the next one", func() {
+		connect(parser.RejectInvalidUTF8)
+
+		reply := send("\"ok\n\"caf\xc3\nfilter-name\n")
+		Expect(reply).To(ContainSubstring("error-cmd: filter-name\nerror: invalid utf-8\nstatus: 2\ndesc: argument 2 has invalid UTF-8 at byte 3\n"))
+		Expect(utf8.ValidString(reply)).To(BeTrue())
+
+		Expect(send("\"café\nfilter-name\n")).To(ContainSubstring("status: 0\n"))
+		Expect(send("list\n")).To(ContainSubstring("len: 1\n"))
+	})
+
+	It("should replace invalid UTF-8 when configured to", func() {
+		connect(parser.ReplaceInvalidUTF8)
+
+		Expect(send("\"caf\xc3\nfilter-name\n")).To(ContainSubstring("status: 0\n"))
+		Expect(send("list\n")).To(ContainSubstring("len: 0\n"))
+	})
+
+	It("should only send valid UTF-8", func() {
+		connect(parser.RejectInvalidUTF8)
+
+		reply := send(itoa(id) + "\ninfo\n")
+		Expect(reply).To(ContainSubstring("path: /opt/caf�/run\n"))
+		Expect(utf8.ValidString(reply)).To(BeTrue())
+
+		reply = send("\xff\xfe\nlist\n")
+		Expect(reply).To(ContainSubstring("status: 5\n"))
+		Expect(utf8.ValidString(reply)).To(BeTrue())
+	})
+})
+
+var _ = Describe("handleConnection sessions", func() {
+	var (
+		srv   *session
+		conns []net.Conn
+	)
+
+	BeforeEach(func() {
+		srv = goldenServer(GinkgoT().TempDir())
+		conns = nil
+	})
+
+	AfterEach(func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		srv.connWg.Wait()
+		srv.runIndex.Close()
+	})
+
+	// connect starts serving a connection and returns a function sending requests on it
+	connect := func() func(req string) string {
+		clientConn, serverConn := net.Pipe()
+		conns = append(conns, clientConn)
+		srv.connWg.Add(1)
+		go srv.handleConnection(serverConn)
+
+		_, err := clientConn.Write([]byte("TXT01"))
+		Expect(err).NotTo(HaveOccurred())
+		reader := bufio.NewReader(clientConn)
+		return func(req string) string {
+			_, err := clientConn.Write([]byte(req))
+			Expect(err).NotTo(HaveOccurred())
+			return readFrame(reader)
+		}
+	}
+
+	It("should keep the filters, language and order of a connection to it", func() {
+		first, second := connect(), connect()
+
+		Expect(first("\"fire\nfilter-name\n")).To(ContainSubstring("status: 0\n"))
+		Expect(first("\"de\nlang\n")).To(ContainSubstring("status: 0\n"))
+		Expect(first("\"name\nsort\n")).To(ContainSubstring("status: 0\n"))
+		Expect(first("list\n")).To(HaveSuffix("sort: name\n\nbody:\n1 Firefox Webbrowser\n\n\n"))
+
+		Expect(second("list\n")).To(HaveSuffix("sort: freq\n\nbody:\n1 Firefox\n4 missing\n2 Terminal\n3 true\n\n\n"))
+		Expect(second("stats\n")).To(ContainSubstring("filters: 0\n"))
+		Expect(first("stats\n")).To(ContainSubstring("filters: 1\n"))
+	})
+
+	It("should start every connection afresh", func() {
+		first := connect()
+		Expect(first("\"fire\nfilter-name\n")).To(ContainSubstring("status: 0\n"))
+
+		Expect(connect()("list\n")).To(ContainSubstring("len: 4\n"))
+	})
+})
+
+var _ = Describe("handleConnection bye", func() {
+	var (
+		srv        *session
+		clientConn net.Conn
+		reader     *bufio.Reader
+		closed     chan struct{}
+	)
+
+	BeforeEach(func() {
+		srv = goldenServer(GinkgoT().TempDir())
+		var serverConn net.Conn
+		clientConn, serverConn = net.Pipe()
+		closed = make(chan struct{})
+		srv.connWg.Add(1)
+		go func() {
+			defer close(closed)
+			srv.handleConnection(serverConn)
+		}()
+		_, err := clientConn.Write([]byte("TXT01"))
+		Expect(err).NotTo(HaveOccurred())
+		reader = bufio.NewReader(clientConn)
+	})
+
+	AfterEach(func() {
+		clientConn.Close()
+		Eventually(closed).Should(BeClosed())
+		srv.runIndex.Close()
+	})
+
+	It("should answer the commands before it, then close the connection", func() {
+		_, err := clientConn.Write([]byte("nop\nbye\nnop\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(readFrame(reader)).To(Equal("cmd: nop\nstatus: 0\n\n\n"))
+		Expect(readFrame(reader)).To(Equal("cmd: bye\nstatus: 0\n\n\n"))
+		_, err = reader.ReadByte()
+		Expect(err).To(MatchError(io.EOF))
+		Eventually(closed).Should(BeClosed())
+	})
+
+	It("should keep the connection open when refusing arguments", func() {
+		_, err := clientConn.Write([]byte("\"now\nbye\nnop\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(readFrame(reader)).To(ContainSubstring("error: invalid argument\nstatus: 2\n"))
+		Expect(readFrame(reader)).To(Equal("cmd: nop\nstatus: 0\n\n\n"))
+		Consistently(closed, 50*time.Millisecond).ShouldNot(BeClosed())
+	})
+})
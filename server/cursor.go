@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// A cursor resumes a list after the last entry of a page: it records the index generation,
+// the order, the page size and the sort key of that entry. Cursors are opaque to clients;
+// they are signed with a key made anew on every start, so a cursor that was tampered with,
+// or comes from an earlier run of the daemon, reads as invalid.
+const (
+	cursorVersion = 1   // bumped when the layout changes, older cursors are then invalid
+	cursorMACLen  = 16  // bytes of the HMAC-SHA256 kept
+	maxCursorLen  = 128 // longest cursor accepted, those made are well below
+)
+
+// errInvalidCursor is returned for cursors that weren't made by this daemon run
+var errInvalidCursor = errors.New("invalid cursor")
+
+var cursorKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("can't make cursor key: %v", err))
+	}
+	return key
+}()
+
+// cursorOrders numbers the sort orders in cursors
+var cursorOrders = []string{sortFreq, sortName, sortMtime}
+
+// listCursor is the position after the last entry of a page
+type listCursor struct {
+	Generation uint64
+	Order      string
+	Limit      int
+
+	// Sort key of the last entry, see sortKey
+	Pinned bool
+	Rank   int64
+	ID     int64
+}
+
+// newCursor returns the cursor resuming after the entry with the given key
+func newCursor(generation uint64, order string, limit int, last sortKey) listCursor {
+	return listCursor{
+		Generation: generation,
+		Order:      order,
+		Limit:      limit,
+		Pinned:     last.pinned,
+		Rank:       last.rank,
+		ID:         last.id,
+	}
+}
+
+func (c listCursor) String() string {
+	buf := []byte{cursorVersion}
+	buf = binary.AppendUvarint(buf, c.Generation)
+	buf = append(buf, byte(slices.Index(cursorOrders, c.Order)))
+	buf = binary.AppendUvarint(buf, uint64(c.Limit))
+	if c.Pinned {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendVarint(buf, c.Rank)
+	buf = binary.AppendVarint(buf, c.ID)
+	buf = append(buf, cursorMAC(buf)...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// parseCursor decodes a cursor made by String, checking its signature
+func parseCursor(s string) (listCursor, error) {
+	var c listCursor
+	if len(s) > maxCursorLen {
+		return c, fmt.Errorf("%w: longer than %d bytes", errInvalidCursor, maxCursorLen)
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(buf) <= cursorMACLen {
+		return c, fmt.Errorf("%w: malformed", errInvalidCursor)
+	}
+	payload, mac := buf[:len(buf)-cursorMACLen], buf[len(buf)-cursorMACLen:]
+	if !hmac.Equal(mac, cursorMAC(payload)) {
+		return c, fmt.Errorf("%w: bad signature", errInvalidCursor)
+	}
+
+	r := bytes.NewReader(payload)
+	version, _ := r.ReadByte()
+	if version != cursorVersion {
+		return c, fmt.Errorf("%w: version %d", errInvalidCursor, version)
+	}
+	// Signed by us, so well formed unless the layout changed without a version bump
+	c.Generation, _ = binary.ReadUvarint(r)
+	order, _ := r.ReadByte()
+	limit, _ := binary.ReadUvarint(r)
+	pinned, _ := r.ReadByte()
+	c.Rank, _ = binary.ReadVarint(r)
+	c.ID, err = binary.ReadVarint(r)
+	if err != nil || r.Len() > 0 || int(order) >= len(cursorOrders) {
+		return listCursor{}, fmt.Errorf("%w: malformed", errInvalidCursor)
+	}
+	c.Order = cursorOrders[order]
+	c.Limit = int(limit)
+	c.Pinned = pinned == 1
+	return c, nil
+}
+
+func cursorMAC(payload []byte) []byte {
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(payload)
+	return mac.Sum(nil)[:cursorMACLen]
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/launcher"
+)
+
+// runDebounce remembers launches for a window, so that a run repeated within it, like the
+// two a double-click fires, gets the first launch back instead of starting another instance.
+// Runs are the same when they launch the same entry with the same command line. A nil
+// runDebounce debounces nothing, like a 0 window.
+type runDebounce struct {
+	window time.Duration // 0 disables it
+	mu     sync.Mutex
+	runs   map[string]*debouncedRun
+}
+
+// debouncedRun is a launch of the window, proc is set once it's over
+type debouncedRun struct {
+	at   time.Time
+	done chan struct{} // closed once the launch is over
+	proc *launcher.Process
+}
+
+func newRunDebounce(window time.Duration) *runDebounce {
+	return &runDebounce{window: window, runs: make(map[string]*debouncedRun)}
+}
+
+// runKey identifies the runs debounced together
+func runKey(req *runRequest) string {
+	return strconv.FormatInt(req.entry.ID, 10) + "\x00" + strings.Join(req.argv, "\x00")
+}
+
+// begin returns the process of the same run launched within the window, waiting for its
+// launch to be over. Without one the run is recorded and returned instead: the caller
+// launches it and reports how that went with finish. Both are nil when the window is 0 or
+// the run repeats one that couldn't be launched.
+func (d *runDebounce) begin(key string) (*launcher.Process, *debouncedRun) {
+	if d == nil || d.window <= 0 {
+		return nil, nil
+	}
+	now := timeNow()
+	d.mu.Lock()
+	for k, run := range d.runs {
+		if now.Sub(run.at) >= d.window {
+			delete(d.runs, k)
+		}
+	}
+	run, ok := d.runs[key]
+	if !ok {
+		run = &debouncedRun{at: now, done: make(chan struct{})}
+		d.runs[key] = run
+		d.mu.Unlock()
+		return nil, run
+	}
+	d.mu.Unlock()
+
+	<-run.done
+	return run.proc, nil
+}
+
+// finish records the process of a run begin returned, nil if it couldn't be launched. A
+// failed launch is forgotten, the next run is launched again.
+func (d *runDebounce) finish(key string, run *debouncedRun, proc *launcher.Process) {
+	if run == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	run.proc = proc
+	if proc == nil && d.runs[key] == run {
+		delete(d.runs, key)
+	}
+	close(run.done)
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// dryRunOpt, pushed before the other arguments, previews a mutating command
+const dryRunOpt = "opt: dry-run"
+
+// takeDryRun reports whether cmd starts with dryRunOpt and returns the command without it
+func takeDryRun(cmd *parser.Command) (*parser.Command, bool) {
+	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeString || cmd.Args[0].Str != dryRunOpt {
+		return cmd, false
+	}
+	return &parser.Command{Name: cmd.Name, Args: cmd.Args[1:]}, true
+}
+
+// dryRunRun tells what run would launch: the entry, the command line in the body and the
+// niceness
+func (s *session) dryRunRun(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling run command dry-run")
+
+	req := s.prepareRun(conn, cmd)
+	if req == nil {
+		return
+	}
+
+	resp := response.OK("run").Setf("idx", "%d", req.entry.ID).SetBool("dry-run", true)
+	if req.nice != nil {
+		resp.Setf("nice", "%d", *req.nice)
+	}
+	if req.warn != "" {
+		resp.Set("warn", req.warn)
+	}
+	resp.Setf("len", "%d", len(req.argv)).Body()
+	for _, arg := range req.argv {
+		resp.Body(bodyLine(arg))
+	}
+	s.writeResponse(conn, resp)
+}
+
+// dryRunKill tells whether kill would find the process
+func (s *Server) dryRunKill(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling kill command dry-run")
+
+	pid, ok := s.killPid(conn, cmd)
+	if !ok {
+		return
+	}
+	proc, ok := s.launcher.Get(pid)
+	if !ok {
+		s.writeError(conn, cmd, response.StatusNotFound, "process not found", fmt.Sprintf("pid %d is not a running process launched by the daemon", pid))
+		return
+	}
+
+	resp := response.OK("kill").SetBool("dry-run", true).Setf("pid", "%d", pid)
+	if proc.Unit != "" {
+		resp.Set("unit", proc.Unit)
+	}
+	s.writeResponse(conn, resp)
+}
+
+// dryRunPin tells the pin state the entry would get and whether that's a change
+func (s *Server) dryRunPin(conn net.Conn, cmd *parser.Command, pinned bool) {
+	cmdName := "unpin"
+	if pinned {
+		cmdName = "pin"
+	}
+	log.Printf("[DEBUG] Handling %s command dry-run", cmdName)
+
+	entry := s.pinEntry(conn, cmd, cmdName)
+	if entry == nil {
+		return
+	}
+	changed := isPinned(s.pins(), entry) != pinned
+
+	resp := response.OK(cmdName).Setf("idx", "%d", entry.ID).SetBool("dry-run", true).
+		SetBool("pinned", pinned).SetBool("changed", changed)
+	s.writeResponse(conn, resp)
+}
+
+// dryRunClearCache tells how many run counts would be dropped and what rebuilding the index
+// would change
+func (s *Server) dryRunClearCache(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling clearcache command dry-run")
+
+	target, ok := s.clearCacheTarget(conn, cmd)
+	if !ok {
+		return
+	}
+
+	resp := response.OK("clearcache").SetBool("dry-run", true).Set("cleared", target)
+	if target == clearRuns || target == clearAll {
+		stats, err := s.runIndex.Stats(0)
+		if err != nil {
+			log.Printf("[ERROR] Failed to read run counts: %v", err)
+			s.writeError(conn, cmd, response.StatusInternal, "storage failed", err.Error())
+			return
+		}
+		resp.Setf("runs-removed", "%d", stats.Tracked)
+	}
+	if target == clearIndex || target == clearAll {
+		setPreview(resp, s.indexer.Preview(nil))
+	}
+	s.writeResponse(conn, resp)
+}
+
+// dryRunReindex lists the roots a reindex would walk and estimates the entries it would
+// change from modification times, without scanning
+func (s *Server) dryRunReindex(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling reindex command dry-run")
+
+	paths, rc, ok := s.reindexPaths(conn, cmd)
+	if !ok {
+		return
+	}
+	// The preview compares against a full run, which a merge isn't
+	if rc {
+		s.writeError(conn, cmd, response.StatusBadArgument, "dry-run unsupported", rcOpt+" reindex can't be previewed")
+		return
+	}
+	preview := s.indexer.Preview(paths)
+
+	var lines []string
+	for _, source := range preview.Sources {
+		for _, root := range source.Roots {
+			line := source.Name + " " + bodyLine(root)
+			if slices.Contains(preview.ChangedRoots, filepath.Clean(root)) {
+				line += " changed"
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	resp := response.OK("reindex").SetBool("dry-run", true)
+	setPreview(resp, preview)
+	resp.Setf("len", "%d", len(lines)).Body(lines...)
+	s.writeResponse(conn, resp)
+}
+
+// setPreview sets the estimate of a reindex preview as reply attributes
+func setPreview(resp *response.Response, preview indexer.Preview) {
+	resp.Setf("modified", "%d", preview.Modified).
+		Setf("removed", "%d", preview.Removed).
+		Setf("changed-roots", "%d", len(preview.ChangedRoots))
+}
+
+// dryRunSaveConf returns the rc file saveconf would write in the body
+func (s *Server) dryRunSaveConf(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling saveconf command dry-run")
+
+	content, merged, err := config.Get().Preview()
+	var conflict *config.ConflictError
+	if errors.As(err, &conflict) {
+		s.writeResponse(conn, errorResponse(cmd, response.StatusConflict, "conflict", err.Error()).Body(conflict.Diff...))
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to render config: %v", err)
+		s.writeError(conn, cmd, response.StatusInternal, "save failed", err.Error())
+		return
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		lines = nil
+	}
+	resp := response.OK("saveconf").SetBool("dry-run", true).SetBool("merged", merged).Setf("len", "%d", len(lines)).Body()
+	for _, line := range lines {
+		resp.Body(bodyLine(line))
+	}
+	s.writeResponse(conn, resp)
+}
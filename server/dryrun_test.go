@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/runindex"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dry-run", func() {
+	var (
+		srv      *session
+		cacheDir string
+		binDir   string
+		toolID   int64
+		sleeper  int
+	)
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(cacheDir)
+
+		binDir = filepath.Join(cacheDir, "bin")
+		Expect(os.MkdirAll(binDir, 0755)).To(Succeed())
+		for _, name := range []string{"tool", "gone"} {
+			Expect(os.WriteFile(filepath.Join(binDir, name), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		}
+		_, err = srv.indexer.Reindex(context.Background(), []string{binDir})
+		Expect(err).NotTo(HaveOccurred())
+		tool, ok := srv.indexer.GetIndex().GetByKey("tool")
+		Expect(ok).To(BeTrue())
+		toolID = tool.ID
+		Expect(srv.runIndex.Increment(tool.Path)).To(Succeed())
+
+		proc, err := srv.launcher.Start(toolID, "sleeper", []string{"sleep", "5"})
+		Expect(err).NotTo(HaveOccurred())
+		sleeper = proc.PID
+	})
+
+	AfterEach(func() {
+		srv.launcher.Kill(sleeper)
+		srv.runIndex.Close()
+		os.RemoveAll(cacheDir)
+	})
+
+	execute := func(name string, args ...parser.Value) string {
+		var buf bytes.Buffer
+		args = append([]parser.Value{{Type: parser.TypeString, Str: dryRunOpt}}, args...)
+		srv.executeCommand(&mockConn{writeBuf: &buf}, &parser.Command{Name: name, Args: args})
+		return buf.String()
+	}
+
+	// state is what mutating commands could change
+	type state struct {
+		generation uint64
+		entries    int
+		runs       runindex.Stats
+		pins       map[string]bool
+		running    bool
+	}
+	snapshot := func() state {
+		runs, err := srv.runIndex.Stats(10)
+		Expect(err).NotTo(HaveOccurred())
+		_, running := srv.launcher.Get(sleeper)
+		return state{
+			generation: srv.indexer.Generation(),
+			entries:    srv.indexer.GetIndex().Count(),
+			runs:       runs,
+			pins:       srv.runIndex.GetPinned(),
+			running:    running,
+		}
+	}
+
+	It("should have a dry-run handler for every mutating command but session", func() {
+		for name, c := range commands {
+			if c.mutating && name != "session" {
+				Expect(c.dryRun).NotTo(BeNil(), name)
+			}
+			if !c.mutating {
+				Expect(c.dryRun).To(BeNil(), name)
+			}
+		}
+	})
+
+	It("should change nothing", func() {
+		id := parser.Value{Type: parser.TypeInt, Int: toolID}
+		calls := map[string][]parser.Value{
+			"run":        {id},
+			"reindex":    {{Type: parser.TypeString, Str: binDir}},
+			"clearcache": {{Type: parser.TypeString, Str: "all"}},
+			"kill":       {{Type: parser.TypeInt, Int: int64(sleeper)}},
+			"pin":        {id},
+			"unpin":      {id},
+			"saveconf":   nil,
+			"gc":         nil,
+		}
+		for name, c := range commands {
+			if c.dryRun != nil {
+				Expect(calls).To(HaveKey(name), "dry-run of %s isn't covered", name)
+			}
+		}
+
+		before := snapshot()
+		for name, args := range calls {
+			reply := execute(name, args...)
+			Expect(reply).To(ContainSubstring("cmd: "+name+"\n"), reply)
+			Expect(reply).To(ContainSubstring("dry-run: t\n"))
+			Expect(snapshot()).To(Equal(before), name)
+		}
+		Consistently(snapshot, 200*time.Millisecond).Should(Equal(before))
+	})
+
+	It("should refuse a dry-run of a mutating command without a dry-run handler", func() {
+		reply := execute("session", parser.Value{Type: parser.TypeString, Str: "persist"})
+		Expect(reply).To(ContainSubstring("error: dry-run unsupported\n"))
+		Expect(reply).To(ContainSubstring("status: 2\n"))
+	})
+
+	It("should ignore the option of other commands", func() {
+		reply := execute("info", parser.Value{Type: parser.TypeInt, Int: toolID})
+		Expect(reply).To(ContainSubstring("cmd: info\n"))
+		Expect(reply).NotTo(ContainSubstring("dry-run"))
+	})
+
+	It("should show the command line run would launch", func() {
+		reply := execute("run",
+			parser.Value{Type: parser.TypeString, Str: "opt: nice=5"},
+			parser.Value{Type: parser.TypeString, Str: "file: /tmp/notes.txt"},
+			parser.Value{Type: parser.TypeString, Str: "tool"},
+		)
+		Expect(reply).To(ContainSubstring("cmd: run\nidx: " + itoa(toolID) + "\nstatus: 0\ndry-run: t\nnice: 5\nlen: 2\n"))
+		Expect(reply).To(HaveSuffix("body:\n" + filepath.Join(binDir, "tool") + "\n/tmp/notes.txt\n\n\n"))
+	})
+
+	It("should tell whether kill would find the process", func() {
+		Expect(execute("kill", parser.Value{Type: parser.TypeInt, Int: int64(sleeper)})).
+			To(ContainSubstring("status: 0\ndry-run: t\npid: " + itoa(int64(sleeper)) + "\n"))
+		Expect(execute("kill", parser.Value{Type: parser.TypeInt, Int: int64(os.Getpid())})).
+			To(ContainSubstring("error: process not found\n"))
+	})
+
+	It("should echo the would-be pin state", func() {
+		id := parser.Value{Type: parser.TypeInt, Int: toolID}
+		Expect(execute("pin", id)).To(ContainSubstring("dry-run: t\npinned: t\nchanged: t\n"))
+		Expect(execute("unpin", id)).To(ContainSubstring("dry-run: t\npinned: f\nchanged: f\n"))
+	})
+
+	It("should count the run counts clearcache would drop", func() {
+		Expect(execute("clearcache", parser.Value{Type: parser.TypeString, Str: "runs"})).
+			To(ContainSubstring("cleared: runs\nruns-removed: 1\n"))
+		Expect(execute("clearcache", parser.Value{Type: parser.TypeString, Str: "everything"})).
+			To(ContainSubstring("error: invalid target\n"))
+	})
+
+	It("should estimate what reindex would change", func() {
+		future := time.Now().Add(time.Hour)
+		Expect(os.Chtimes(filepath.Join(binDir, "tool"), future, future)).To(Succeed())
+		Expect(os.Remove(filepath.Join(binDir, "gone"))).To(Succeed())
+		Expect(os.Chtimes(binDir, future, future)).To(Succeed())
+
+		reply := execute("reindex", parser.Value{Type: parser.TypeString, Str: binDir})
+		Expect(reply).To(ContainSubstring("dry-run: t\nmodified: 1\nremoved: 1\n"))
+		Expect(reply).To(ContainSubstring("\nexecutable " + binDir + " changed\n"))
+	})
+
+	It("should count entries outside the roots asked for as removed", func() {
+		otherDir := filepath.Join(cacheDir, "other")
+		Expect(os.MkdirAll(otherDir, 0755)).To(Succeed())
+
+		reply := execute("reindex", parser.Value{Type: parser.TypeString, Str: otherDir})
+		Expect(reply).To(ContainSubstring("removed: 2\n"))
+		Expect(reply).To(ContainSubstring("\nexecutable " + otherDir + " changed\n"))
+	})
+
+	It("should return the file saveconf would write", func() {
+		reply := execute("saveconf")
+		Expect(reply).To(MatchRegexp(`cmd: saveconf\nstatus: 0\ndry-run: t\nmerged: [tf]\nlen: \d+\n\nbody:\n`))
+	})
+})
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+)
+
+// Filters stores the filter settings of a session
+type Filters struct {
+	mu          sync.RWMutex
+	nameFilters []FilterExpr
+	catFilters  []FilterExpr
+	pathFilters []FilterExpr
+	excludeCats []string // categories removed after the other filters matched
+}
+
+// FilterExpr represents a filter expression
+type FilterExpr struct {
+	Values []string
+	Op     string // orOp, andOp, notOp
+}
+
+// Approximate memory taken by filters besides the bytes of their strings, see filterBytes:
+// an expression with the header of its slice of values, and the header of a string
+const (
+	filterExprOverhead  = 48
+	filterValueOverhead = 16
+)
+
+// snapshot returns the filters as a session state, without the language. The caller holds mu.
+func (f *Filters) snapshot() sessionState {
+	return sessionState{
+		NameFilters: f.nameFilters,
+		CatFilters:  f.catFilters,
+		PathFilters: f.pathFilters,
+		ExcludeCats: f.excludeCats,
+	}
+}
+
+// filterCount returns the number of filter expressions and excluded categories of state
+func (state sessionState) filterCount() int {
+	return len(state.NameFilters) + len(state.CatFilters) + len(state.PathFilters) + len(state.ExcludeCats)
+}
+
+// filterBytes returns the approximate memory taken by the filters of state
+func (state sessionState) filterBytes() int {
+	size := 0
+	for _, family := range [][]FilterExpr{state.NameFilters, state.CatFilters, state.PathFilters} {
+		for _, expr := range family {
+			size += filterExprOverhead
+			for _, value := range expr.Values {
+				size += filterValueOverhead + len(value)
+			}
+		}
+	}
+	for _, cat := range state.ExcludeCats {
+		size += filterValueOverhead + len(cat)
+	}
+	return size
+}
+
+// filterLimit returns why the filters of state can't be set, "" when they're within
+// ADE_INDEXD_MAX_FILTERS and ADE_INDEXD_MAX_FILTER_BYTES
+func (s *Server) filterLimit(state sessionState) string {
+	if s.maxFilters > 0 {
+		for _, family := range []struct {
+			name  string
+			count int
+		}{
+			{"name filters", len(state.NameFilters)},
+			{"category filters", len(state.CatFilters)},
+			{"path filters", len(state.PathFilters)},
+			{"excluded categories", len(state.ExcludeCats)},
+		} {
+			if family.count > s.maxFilters {
+				return fmt.Sprintf("too many %s, at most %d are kept; 0filters clears them", family.name, s.maxFilters)
+			}
+		}
+	}
+	if size := state.filterBytes(); s.maxFilterBytes > 0 && size > s.maxFilterBytes {
+		return fmt.Sprintf("filters would take about %d bytes, at most %d are kept; 0filters clears them", size, s.maxFilterBytes)
+	}
+	return ""
+}
+
+func (s *session) filterEntries(entries []*indexer.Entry) []*indexer.Entry {
+	var result []*indexer.Entry
+
+	for _, entry := range entries {
+		if s.matchesFilters(entry) {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+// matchesFilters tells whether entry passes the filters of the session: those of every kind
+// set, ANDed together, then the excluded categories
+func (s *session) matchesFilters(entry *indexer.Entry) bool {
+	if !matchesKind(s.filters.nameFilters, func(filter FilterExpr) bool { return s.matchesNameFilter(entry, filter) }) {
+		return false
+	}
+	if !matchesKind(s.filters.catFilters, func(filter FilterExpr) bool { return s.matchesCatFilter(entry, filter) }) {
+		return false
+	}
+	if !matchesKind(s.filters.pathFilters, func(filter FilterExpr) bool { return s.matchesPathFilter(entry, filter) }) {
+		return false
+	}
+
+	// Excluded categories win over everything included above
+	if len(s.filters.excludeCats) > 0 && s.matchesCatFilter(entry, FilterExpr{Values: s.filters.excludeCats}) {
+		return false
+	}
+
+	return true
+}
+
+// matchesKind tells whether an entry passes the filters of one kind, match telling whether
+// it passes a single one: one of the filters without not has to match, if any is set, and
+// every not filter has to, which none of its values matching does
+func matchesKind(filters []FilterExpr, match func(FilterExpr) bool) bool {
+	included, positive := false, false
+	for _, filter := range filters {
+		if filter.Op == notOp {
+			if !match(filter) {
+				return false
+			}
+			continue
+		}
+		positive = true
+		included = included || match(filter)
+	}
+	return included || !positive
+}
+
+func (s *Server) matchesNameFilter(entry *indexer.Entry, filter FilterExpr) bool {
+	// Collect all searchable names (direct name + localized names)
+	searchNames := []string{strings.ToLower(entry.Name)}
+	for _, name := range entry.Names {
+		searchNames = append(searchNames, strings.ToLower(name))
+	}
+	// Only set with transliteration enabled, already lowercase
+	searchNames = append(searchNames, entry.Translit...)
+	// Renamed executables are still found by the name of their file
+	if entry.RawName != "" {
+		searchNames = append(searchNames, strings.ToLower(entry.RawName))
+	}
+
+	// Check matches for each value
+	matches := make([]bool, len(filter.Values))
+	for i, value := range filter.Values {
+		valueLower := strings.ToLower(value)
+		for _, searchName := range searchNames {
+			if strings.Contains(searchName, valueLower) {
+				matches[i] = true
+				break
+			}
+		}
+	}
+
+	// Apply operation logic
+	switch filter.Op {
+	case orOp:
+		// OR: return true if ANY value matches
+		for _, match := range matches {
+			if match {
+				return true
+			}
+		}
+		return false
+	case andOp:
+		// AND: return true if ALL values match
+		for _, match := range matches {
+			if !match {
+				return false
+			}
+		}
+		return len(matches) > 0
+	case notOp:
+		// NOT: return true if NONE of the values match
+		for _, match := range matches {
+			if match {
+				return false
+			}
+		}
+		return true
+	default:
+		// Default to OR behavior
+		for _, match := range matches {
+			if match {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// matchesCatFilter tells whether entry is in one of the categories of filter, in none of
+// them for a not filter
+func (s *Server) matchesCatFilter(entry *indexer.Entry, filter FilterExpr) bool {
+	for _, cat := range entry.Categories {
+		for _, filterCat := range filter.Values {
+			if strings.EqualFold(cat, filterCat) {
+				return filter.Op != notOp
+			}
+		}
+	}
+	return filter.Op == notOp
+}
+
+// matchesPathFilter tells whether the path of entry holds one of the values of filter, none
+// of them for a not filter
+func (s *Server) matchesPathFilter(entry *indexer.Entry, filter FilterExpr) bool {
+	for _, filterPath := range filter.Values {
+		if strings.Contains(entry.Path, filterPath) {
+			return filter.Op != notOp
+		}
+	}
+	return filter.Op == notOp
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/retention"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// gcInterval is how often the log directory is collected on its own, and the least time
+// between two collections triggered by launches
+const gcInterval = time.Hour
+
+// logPolicies returns the retention policies of the audit log and the application logs
+func logPolicies() (audit, apps retention.Policy) {
+	cfg := config.Get()
+	audit = retention.Policy{MaxAge: cfg.AuditMaxAge(), MaxSize: cfg.AuditMaxSize(), KeepNewest: true}
+	apps = retention.Policy{MaxAge: cfg.AppLogMaxAge()}
+	return audit, apps
+}
+
+// collectLogs removes the log files aged out or over the size cap
+func (s *Server) collectLogs() (retention.Result, error) {
+	s.lastGC.Store(timeNow().UnixNano())
+	audit, apps := logPolicies()
+	result, err := retention.Collect(s.logDir, audit, apps, timeNow())
+	if len(result.Removed) > 0 {
+		log.Printf("[DEBUG] Removed %d log files, freed %d bytes", len(result.Removed), result.Freed)
+	}
+	return result, err
+}
+
+// collectLogsSoon collects the log directory in the background unless that was done within
+// gcInterval or is under way
+func (s *Server) collectLogsSoon() {
+	if s.logDir == "" || timeNow().Sub(time.Unix(0, s.lastGC.Load())) < gcInterval {
+		return
+	}
+	if !s.collecting.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.collecting.Store(false)
+		if _, err := s.collectLogs(); err != nil {
+			log.Printf("[WARN] Log collection failed: %v", err)
+		}
+	}()
+}
+
+// collectLogsLoop collects the log directory every gcInterval until done is closed
+func (s *Server) collectLogsLoop(done <-chan struct{}) {
+	if s.logDir == "" {
+		return
+	}
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.collectLogsSoon()
+		}
+	}
+}
+
+// handleGC collects the log directory right away and reports what was removed
+func (s *Server) handleGC(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling gc command")
+
+	if !s.collecting.CompareAndSwap(false, true) {
+		s.writeError(conn, cmd, response.StatusConflict, "gc running", "log collection is already under way")
+		return
+	}
+	defer s.collecting.Store(false)
+
+	result, err := s.collectLogs()
+	if err != nil {
+		log.Printf("[ERROR] Log collection failed: %v", err)
+		s.writeError(conn, cmd, response.StatusInternal, "gc failed", err.Error())
+		return
+	}
+	s.writeResponse(conn, response.OK("gc").Setf("removed", "%d", len(result.Removed)).Setf("freed", "%d", result.Freed))
+}
+
+// dryRunGC tells how many log files gc would remove and the bytes that would free
+func (s *Server) dryRunGC(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling gc command dry-run")
+
+	audit, apps := logPolicies()
+	result, err := retention.Plan(s.logDir, audit, apps, timeNow())
+	if err != nil {
+		log.Printf("[ERROR] Log collection planning failed: %v", err)
+		s.writeError(conn, cmd, response.StatusInternal, "gc failed", err.Error())
+		return
+	}
+	s.writeResponse(conn, response.OK("gc").SetBool("dry-run", true).Setf("removed", "%d", len(result.Removed)).Setf("freed", "%d", result.Freed))
+}
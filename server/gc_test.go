@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("gc", func() {
+	var (
+		srv      *session
+		stale    string
+		fresh    string
+		segments []string
+	)
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		srv.logDir = filepath.Join(GinkgoT().TempDir(), "logs")
+
+		write := func(rel string, size int, age time.Duration) string {
+			path := filepath.Join(srv.logDir, rel)
+			Expect(os.MkdirAll(filepath.Dir(path), 0750)).To(Succeed())
+			Expect(os.WriteFile(path, make([]byte, size), 0600)).To(Succeed())
+			mtime := time.Now().Add(-age)
+			Expect(os.Chtimes(path, mtime, mtime)).To(Succeed())
+			return path
+		}
+		day := 24 * time.Hour
+		stale = write("apps/firefox.log", 100, 30*day)
+		fresh = write("apps/foot.log", 10, day)
+		segments = []string{
+			write("audit/audit-1.log", 1000, 60*day),
+			write("audit/audit.log", 10, 0),
+		}
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+	})
+
+	send := func(lines ...string) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+		return buf.String()
+	}
+
+	It("should remove aged out logs and report the freed bytes", func() {
+		Expect(send("gc")).To(Equal("TXT01cmd: gc\nstatus: 0\nremoved: 2\nfreed: 1100\n\n\n"))
+		Expect(stale).NotTo(BeAnExistingFile())
+		Expect(segments[0]).NotTo(BeAnExistingFile())
+		Expect(fresh).To(BeAnExistingFile())
+		Expect(segments[1]).To(BeAnExistingFile())
+
+		Expect(send("gc")).To(ContainSubstring("removed: 0\nfreed: 0\n"))
+	})
+
+	It("should only tell what it would remove on a dry-run", func() {
+		Expect(send(`"opt: dry-run`, "gc")).To(Equal("TXT01cmd: gc\nstatus: 0\ndry-run: t\nremoved: 2\nfreed: 1100\n\n\n"))
+		Expect(stale).To(BeAnExistingFile())
+		Expect(segments[0]).To(BeAnExistingFile())
+	})
+
+	It("should refuse to run twice at once", func() {
+		srv.collecting.Store(true)
+		Expect(send("gc")).To(ContainSubstring("error: gc running\n"))
+		Expect(stale).To(BeAnExistingFile())
+	})
+
+	It("should collect after launches at most once per interval", func() {
+		srv.collectLogsSoon()
+		Eventually(stale).ShouldNot(BeAnExistingFile())
+		Eventually(srv.collecting.Load).Should(BeFalse())
+
+		again := filepath.Join(srv.logDir, "apps", "gimp.log")
+		Expect(os.WriteFile(again, nil, 0600)).To(Succeed())
+		old := time.Now().Add(-30 * 24 * time.Hour)
+		Expect(os.Chtimes(again, old, old)).To(Succeed())
+
+		srv.collectLogsSoon()
+		Consistently(again, 100*time.Millisecond).Should(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// handleGetMany describes several entries in one reply, e.g. the visible page of a list, in
+// the order asked. Ids and keys matching nothing are listed in the missing attribute.
+func (s *session) handleGetMany(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling get-many command")
+
+	if len(cmd.Args) == 0 {
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing id", "get-many command requires at least one id or key")
+		return
+	}
+	if limit := config.Get().ListLimit(); limit > 0 && len(cmd.Args) > limit {
+		s.writeError(conn, cmd, response.StatusBadArgument, "too many ids", fmt.Sprintf("get-many takes at most %d ids", limit))
+		return
+	}
+	for _, arg := range cmd.Args {
+		if !isEntryRef(arg) {
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid id", "get-many arguments must be ids or keys")
+			return
+		}
+	}
+
+	pins := s.pins()
+	seen := make(map[int64]bool)
+	var (
+		lines   []string
+		missing []string
+		found   int
+	)
+	for _, arg := range cmd.Args {
+		entry, ok := s.lookupEntry(arg)
+		if !ok {
+			if arg.Type == parser.TypeString {
+				missing = append(missing, strconv.Quote(response.Sanitize(arg.Str)))
+			} else {
+				missing = append(missing, strconv.FormatInt(arg.Int, 10))
+			}
+			continue
+		}
+		if seen[entry.ID] {
+			continue
+		}
+		seen[entry.ID] = true
+		found++
+		lines = append(lines, strings.Join(s.detailFields(entry, isPinned(pins, entry)), "\t"))
+	}
+
+	resp := response.OK("get-many").Setf("len", "%d", found)
+	if len(missing) > 0 {
+		resp.Set("missing", strings.Join(missing, " "))
+	}
+	s.writeResponse(conn, resp.Body(lines...))
+}
+
+// detailFields returns what info tells about an entry, in the same order: id, key, name,
+// path, exec, terminal, desktop, categories and pinned. Tabs and line breaks in values are
+// replaced with spaces so the fields can be joined with tabs.
+func (s *session) detailFields(entry *indexer.Entry, pinned bool) []string {
+	fields := []string{
+		strconv.FormatInt(entry.ID, 10),
+		entry.Key,
+		s.localizedName(entry),
+		entry.Path,
+		entry.Exec,
+		boolAttr(entry.Terminal),
+		boolAttr(entry.IsDesktop),
+		strings.Join(entry.Categories, ";"),
+		boolAttr(pinned),
+	}
+	for i, field := range fields {
+		fields[i] = bodyLine(field)
+	}
+	return fields
+}
+
+// bodyLine replaces tabs, line breaks and other control characters with spaces, so text
+// fits in one line of a reply body
+func bodyLine(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
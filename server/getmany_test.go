@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("handleGetMany", func() {
+	var (
+		srv     *session
+		tmpDir  string
+		firefox int64
+		vim     int64
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(tmpDir)
+
+		index := srv.indexer.GetIndex()
+		firefox = index.Add(&indexer.Entry{
+			Name:       "Firefox",
+			Names:      map[string]string{"de": "Feuerfuchs"},
+			Path:       "/usr/share/applications/firefox.desktop",
+			Exec:       "firefox %u",
+			Categories: []string{"Network", "WebBrowser"},
+			IsDesktop:  true,
+		})
+		vim = index.Add(&indexer.Entry{Name: "vim\tedit", Path: "/usr/bin/vim", Exec: "/usr/bin/vim", Terminal: true})
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	getMany := func(args ...parser.Value) (string, []string) {
+		var buf bytes.Buffer
+		srv.handleGetMany(&mockConn{writeBuf: &buf}, &parser.Command{Name: "get-many", Args: args})
+		response := buf.String()
+		_, body, _ := strings.Cut(response, "body:\n")
+		if body = strings.TrimSuffix(strings.TrimSuffix(body, "\n\n"), "\n"); body == "" {
+			return response, nil
+		}
+		return response, strings.Split(body, "\n")
+	}
+	id := func(n int64) parser.Value {
+		return parser.Value{Type: parser.TypeInt, Int: n}
+	}
+
+	It("should describe present ids in order and list missing ones", func() {
+		srv.lang = "de"
+		response, lines := getMany(id(vim), id(404), id(firefox), id(vim), parser.Value{Type: parser.TypeString, Str: "nothing"})
+		Expect(response).To(HavePrefix("TXT01cmd: get-many\nstatus: 0\nlen: 2\nmissing: 404 \"nothing\"\n\nbody:\n"))
+		Expect(lines).To(Equal([]string{
+			itoa(vim) + "\t\tvim edit\t/usr/bin/vim\t/usr/bin/vim\tt\tf\t\tf",
+			itoa(firefox) + "\t\tFeuerfuchs\t/usr/share/applications/firefox.desktop\tfirefox %u\tf\tt\tNetwork;WebBrowser\tf",
+		}))
+	})
+
+	It("should reply with an empty body when nothing is found", func() {
+		response, lines := getMany(id(404))
+		Expect(response).To(ContainSubstring("len: 0\nmissing: 404\n"))
+		Expect(lines).To(BeEmpty())
+	})
+
+	It("should require ids", func() {
+		response, _ := getMany()
+		Expect(response).To(ContainSubstring("status: 2\n"))
+		response, _ = getMany(parser.Value{Type: parser.TypeBool, Bool: true})
+		Expect(response).To(ContainSubstring("error: invalid id\n"))
+	})
+})
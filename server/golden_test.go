@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// The golden tests pin the exact bytes of the replies. testdata/golden/<name>.req holds
+// what a client sends on one connection, header included, and <name>.golden what the
+// server answers, so clients in other languages can be tested against the same files.
+// After an intended change of the replies, rewrite the golden files with
+//
+//	go test ./server -update
+//
+// and review their diff.
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of testdata/golden")
+
+const goldenDir = "testdata/golden"
+
+// goldenPID stands for the pids of launched processes, the only value that can't be fixed
+var goldenPID = regexp.MustCompile(`(?m)^pid: \d+$`)
+
+var _ = Describe("golden replies", func() {
+	var (
+		srv *session
+		dir string
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		srv = goldenServer(dir)
+		// No mimeapps.list of the machine running the tests picks handlers
+		for _, env := range []string{"XDG_CONFIG_HOME", "XDG_CONFIG_DIRS", "XDG_DATA_HOME", "XDG_DATA_DIRS"} {
+			GinkgoT().Setenv(env, dir)
+		}
+		DeferCleanup(srv.runIndex.Close)
+
+		oldNow, oldRand, oldKey := timeNow, randRead, cursorKey
+		timeNow = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+		randRead = func(b []byte) (int, error) {
+			for i := range b {
+				b[i] = byte(i)
+			}
+			return len(b), nil
+		}
+		cursorKey = []byte("golden")
+		DeferCleanup(func() {
+			timeNow, randRead, cursorKey = oldNow, oldRand, oldKey
+		})
+	})
+
+	// replay runs the requests of a .req file on one connection and returns the replies
+	replay := func(requests []byte) []byte {
+		var out bytes.Buffer
+		conn := &mockConn{readBuf: bytes.NewBuffer(requests), writeBuf: &out}
+		Expect(srv.trackConn(conn)).To(BeTrue())
+		srv.handleConnection(conn)
+
+		replies := bytes.ReplaceAll(out.Bytes(), []byte(dir), []byte("$TMP"))
+		return goldenPID.ReplaceAllLiteral(replies, []byte("pid: $PID"))
+	}
+
+	scripts, err := filepath.Glob(filepath.Join(goldenDir, "*.req"))
+	if err != nil || len(scripts) == 0 {
+		panic("no golden requests in " + goldenDir)
+	}
+	for _, script := range scripts {
+		name := strings.TrimSuffix(filepath.Base(script), ".req")
+		It("should answer "+name+" byte for byte", func() {
+			requests, err := os.ReadFile(script)
+			Expect(err).NotTo(HaveOccurred())
+			replies := replay(requests)
+
+			golden := filepath.Join(goldenDir, name+".golden")
+			if *updateGolden {
+				Expect(os.WriteFile(golden, replies, 0644)).To(Succeed())
+			}
+			expected, err := os.ReadFile(golden)
+			Expect(err).NotTo(HaveOccurred(), "run go test ./server -update to create it")
+			Expect(string(replies)).To(Equal(string(expected)))
+		})
+	}
+
+	It("should send every command of the registry", func() {
+		sent := make(map[string]bool)
+		for _, script := range scripts {
+			requests, err := os.ReadFile(script)
+			Expect(err).NotTo(HaveOccurred())
+			p, err := parser.NewParser(bytes.NewReader(requests))
+			if err != nil {
+				continue // scripts of invalid headers
+			}
+			for {
+				cmd, err := p.ParseCommand()
+				if err == io.EOF {
+					break
+				}
+				var encErr *parser.EncodingError
+				if errors.As(err, &encErr) {
+					sent[encErr.Command] = true
+				}
+				if cmd != nil {
+					sent[cmd.Name] = true
+				}
+			}
+		}
+
+		var missing []string
+		for name := range commands {
+			if !sent[name] {
+				missing = append(missing, name)
+			}
+		}
+		slices.Sort(missing)
+		Expect(missing).To(BeEmpty(), "commands without golden requests")
+	})
+})
+
+// goldenServer returns the session of a server with the fixed index the golden requests are run against
+func goldenServer(cacheDir string) *session {
+	srv := newTestServer(cacheDir)
+	modTime := time.Date(2024, 4, 1, 9, 30, 0, 0, time.UTC)
+	for _, entry := range []*indexer.Entry{
+		{
+			Key:          "firefox.desktop",
+			Name:         "Firefox",
+			Names:        map[string]string{"de": "Firefox Webbrowser"},
+			GenericName:  "Web Browser",
+			Path:         "/usr/share/applications/firefox.desktop",
+			Exec:         "firefox %u",
+			DesktopID:    "firefox.desktop",
+			Categories:   []string{"Network", "WebBrowser"},
+			Keywords:     []string{"internet", "web"},
+			MimeTypes:    []string{"text/html", "x-scheme-handler/http", "x-scheme-handler/https"},
+			IsDesktop:    true,
+			ModTime:      modTime,
+			ResolvedExec: "/opt/golden/bin/firefox",
+		},
+		{
+			Key:        "terminal.desktop",
+			Name:       "Terminal",
+			Names:      map[string]string{"ru": "Терминал"},
+			Path:       "/usr/share/applications/terminal.desktop",
+			Exec:       "terminal",
+			DesktopID:  "terminal.desktop",
+			Categories: []string{"System", "TerminalEmulator"},
+			IsDesktop:  true,
+			ModTime:    modTime.Add(time.Hour),
+		},
+		{
+			Key:          "true",
+			Name:         "true",
+			Path:         "/usr/bin/true",
+			Exec:         "/usr/bin/true",
+			ResolvedExec: "/usr/bin/true",
+			ModTime:      modTime.Add(-time.Hour),
+		},
+		{
+			Key:     "missing",
+			Name:    "missing",
+			Path:    "/opt/golden/bin/missing",
+			Exec:    "/opt/golden/bin/missing",
+			ModTime: modTime,
+		},
+	} {
+		srv.indexer.GetIndex().Add(entry)
+	}
+	return srv
+}
@@ -0,0 +1,452 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/redact"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// Targets of clearcache
+const (
+	clearIndex = "index"
+	clearRuns  = "runs"
+	clearAll   = "all"
+)
+
+// clearCacheTarget returns the target argument of a clearcache command, writing the error to
+// conn if it's missing or unknown
+func (s *Server) clearCacheTarget(conn net.Conn, cmd *parser.Command) (string, bool) {
+	if len(cmd.Args) != 1 || cmd.Args[0].Type != parser.TypeString {
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing target", "clearcache command requires one target: index, runs or all")
+		return "", false
+	}
+	target := cmd.Args[0].Str
+	if target != clearIndex && target != clearRuns && target != clearAll {
+		s.writeError(conn, cmd, response.StatusBadArgument, "invalid target", fmt.Sprintf("unknown clearcache target %q, expected index, runs or all", target))
+		return "", false
+	}
+	if target != clearIndex && !s.requireRunIndex(conn, cmd) {
+		return "", false
+	}
+	return target, true
+}
+
+// handleClearCache drops the run counts, rebuilds the index from scratch, or both. The
+// target is required and has to be spelled out, so a slip doesn't clear everything.
+func (s *session) handleClearCache(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling clearcache command")
+
+	target, ok := s.clearCacheTarget(conn, cmd)
+	if !ok {
+		return
+	}
+
+	resp := response.OK("clearcache").Set("cleared", target)
+	cancelled := cancelledResponse(cmd)
+
+	if target == clearRuns || target == clearAll {
+		removed, err := s.runIndex.ClearRuns()
+		if err != nil {
+			log.Printf("[ERROR] Failed to clear run counts: %v", err)
+			s.writeError(conn, cmd, response.StatusInternal, "storage failed", err.Error())
+			return
+		}
+		log.Printf("[DEBUG] Cleared run counts of %d paths", removed)
+		resp.Setf("runs-removed", "%d", removed)
+		cancelled.Set("cleared", clearRuns).Setf("runs-removed", "%d", removed)
+	}
+
+	if target == clearIndex || target == clearAll {
+		count, err := s.indexer.Reindex(s.cmdContext(), nil)
+		if errors.Is(err, context.Canceled) {
+			// The run counts cleared before stay cleared
+			s.writeResponse(conn, cancelled)
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] Reindex failed: %v", err)
+			s.writeError(conn, cmd, response.StatusInternal, "indexing failed", err.Error())
+			return
+		}
+		resp.Setf("indexed", "%d", count)
+	}
+
+	s.writeResponse(conn, resp)
+}
+
+// rcOpt makes reindex rescan only the paths of the rc file, keeping the rest of the index
+const rcOpt = "opt: rc"
+
+// reindexPaths returns the paths of a reindex command, expanded and absolute, writing the
+// error to conn if an argument isn't a path. The second result tells the paths are those of
+// the rc file, to be merged into the index.
+func (s *Server) reindexPaths(conn net.Conn, cmd *parser.Command) ([]string, bool, bool) {
+	if len(cmd.Args) > 0 && cmd.Args[0].Type == parser.TypeString && cmd.Args[0].Str == rcOpt {
+		if len(cmd.Args) > 1 {
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", rcOpt+" takes the paths of the rc file, it accepts no paths")
+			return nil, false, false
+		}
+		return config.Get().RCPaths(), true, true
+	}
+
+	// Collect string arguments as paths
+	var paths []string
+	for _, arg := range cmd.Args {
+		if arg.Type != parser.TypeString {
+			log.Printf("[ERROR] reindex command received non-string argument")
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", "reindex command accepts only string path arguments")
+			return nil, false, false
+		}
+		paths = append(paths, arg.Str)
+	}
+
+	// Expand paths (handle ~ and convert to absolute)
+	expandedPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		expanded := s.expandPath(path)
+		absPath, err := filepath.Abs(expanded)
+		if err != nil {
+			log.Printf("[WARN] Failed to resolve absolute path for %s: %v", redact.String(path), err)
+			// Use expanded path even if absolute resolution fails
+			expandedPaths = append(expandedPaths, expanded)
+		} else {
+			expandedPaths = append(expandedPaths, absPath)
+		}
+	}
+	return expandedPaths, false, true
+}
+
+func (s *session) handleReindex(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling reindex command")
+
+	expandedPaths, rc, ok := s.reindexPaths(conn, cmd)
+	if !ok {
+		return
+	}
+
+	// Perform reindexing (blocking call)
+	ctx := s.cmdContext()
+	var (
+		count int
+		err   error
+	)
+	if rc {
+		log.Printf("[DEBUG] Reindexing rc paths: %s", redact.Strings(expandedPaths))
+		count, err = s.indexer.ReindexMerge(ctx, expandedPaths)
+	} else {
+		log.Printf("[DEBUG] Reindexing paths: %s", redact.Strings(expandedPaths))
+		count, err = s.indexer.Reindex(ctx, expandedPaths)
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Printf("[DEBUG] Reindex cancelled")
+		s.writeResponse(conn, cancelledResponse(cmd))
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Reindex failed: %v", err)
+		s.writeError(conn, cmd, response.StatusInternal, "indexing failed", err.Error())
+		return
+	}
+
+	log.Printf("[DEBUG] Reindex completed, indexed %d entries", count)
+
+	// Send success response with the count of every scanner
+	sources := s.indexer.Sources()
+	ignored, pruned, invalid := 0, 0, 0
+	for _, source := range sources {
+		ignored += source.Ignored
+		pruned += source.Pruned
+		invalid += source.InvalidUTF8
+	}
+	resp := response.OK("reindex").
+		Setf("indexed", "%d", count).
+		Setf("ignored", "%d", ignored).
+		Setf("pruned", "%d", pruned)
+	if rc {
+		resp.Setf("rc-paths", "%d", len(expandedPaths))
+	}
+	if unreadable := s.indexer.Unreadable(); unreadable > 0 {
+		resp.Setf("unreadable", "%d", unreadable)
+	}
+	if invalid > 0 {
+		resp.Setf("invalid-utf8", "%d", invalid)
+	}
+	if truncated, largest := s.indexer.Truncated(); truncated > 0 {
+		resp.SetBool("index-truncated", true).
+			Setf("truncated", "%d", truncated).
+			Set("truncated-roots", strings.Join(largest, ":"))
+	}
+	roots := rootLines(sources)
+	resp.Setf("len", "%d", len(roots))
+	for _, source := range sources {
+		resp.Setf("indexed-"+source.Name, "%d", source.Count)
+		if source.Ignored > 0 {
+			resp.Setf("ignored-"+source.Name, "%d", source.Ignored)
+		}
+		if source.Pruned > 0 {
+			resp.Setf("pruned-"+source.Name, "%d", source.Pruned)
+		}
+		if source.InvalidUTF8 > 0 {
+			resp.Setf("invalid-utf8-"+source.Name, "%d", source.InvalidUTF8)
+		}
+	}
+	s.writeResponse(conn, resp.Body(roots...))
+}
+
+// setTruncatedAttr sets the attribute lists and stats carry while the index lacks entries
+// left out for exceeding ADE_INDEXD_MAX_ENTRIES
+func (s *Server) setTruncatedAttr(resp *response.Response) {
+	if truncated, _ := s.indexer.Truncated(); truncated > 0 {
+		resp.SetBool("index-truncated", true)
+	}
+}
+
+// rootLines formats the walks of the roots of sources in the last run, a
+// `<scanner> <ms> <files> <entries> <errors> <root>` line each
+func rootLines(sources []indexer.SourceStats) []string {
+	var lines []string
+	for _, source := range sources {
+		for _, scan := range source.Scans {
+			lines = append(lines, fmt.Sprintf("%s %d %d %d %d %s", source.Name, scan.Duration.Milliseconds(), scan.Files, scan.Entries, scan.Errors, bodyLine(scan.Root)))
+		}
+	}
+	return lines
+}
+
+// handleDiff lists the entries added, removed and renamed between two index generations,
+// by default between the previous and the current one
+func (s *Server) handleDiff(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling diff command")
+
+	if len(cmd.Args) > 2 {
+		s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", "diff command accepts at most two generations")
+		return
+	}
+	var generations []uint64
+	for _, arg := range cmd.Args {
+		if arg.Type != parser.TypeInt || arg.Int < 0 {
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid generation", "generations must be non-negative integers")
+			return
+		}
+		generations = append(generations, uint64(arg.Int))
+	}
+
+	to := s.indexer.Generation()
+	from := to
+	if to > 0 {
+		from = to - 1
+	}
+	switch len(generations) {
+	case 1:
+		from = generations[0]
+	case 2:
+		from, to = generations[0], generations[1]
+	}
+
+	diff, err := s.indexer.Diff(from, to)
+	if err != nil {
+		oldest, newest := s.indexer.HistoryRange()
+		s.writeError(conn, cmd, response.StatusNotFound, "generation not found",
+			fmt.Sprintf("history has generations %d to %d", oldest, newest))
+		return
+	}
+
+	resp := response.OK("diff").
+		Setf("from", "%d", diff.From).
+		Setf("to", "%d", diff.To).
+		Setf("added", "%d", len(diff.Added)).
+		Setf("removed", "%d", len(diff.Removed)).
+		Setf("renamed", "%d", len(diff.Renamed)).
+		Setf("len", "%d", len(diff.Added)+len(diff.Removed)+len(diff.Renamed)).
+		Body()
+	for _, change := range diff.Added {
+		resp.Body(fmt.Sprintf("+ %s %s", change.Path, change.Name))
+	}
+	for _, change := range diff.Removed {
+		resp.Body(fmt.Sprintf("- %s %s", change.Path, change.Name))
+	}
+	for _, change := range diff.Renamed {
+		resp.Body(fmt.Sprintf("~ %s %s -> %s", change.Path, change.OldName, change.Name))
+	}
+
+	s.writeResponse(conn, resp)
+}
+
+// handleExportIndex streams the whole index, filters left aside, as JSON lines of
+// indexer.Record in ID order
+func (s *session) handleExportIndex(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling export-index command")
+
+	if len(cmd.Args) > 0 {
+		s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", "export-index command takes no arguments")
+		return
+	}
+
+	idx, generation := s.indexer.GetIndexGeneration()
+	entries := idx.GetAll()
+	resp := response.OK("export-index").
+		Setf("generation", "%d", generation).
+		Setf("revision", "%d", idx.MaxRevision()).
+		Setf("len", "%d", len(entries)).
+		Body()
+
+	ctx := s.cmdContext()
+	sent := 0
+	s.writeStream(conn, resp, func(w *bufio.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				break
+			}
+			// Encode ends every record with a line break
+			if err := enc.Encode(indexer.NewRecord(entry)); err != nil {
+				return err
+			}
+			sent++
+		}
+		return nil
+	})
+	if sent < len(entries) && ctx.Err() != nil {
+		// The body ended short of len, the client is told why
+		log.Printf("[DEBUG] Export cancelled after %d entries", sent)
+		s.writeResponse(conn, cancelledResponse(cmd).Setf("sent", "%d", sent))
+	}
+}
+
+// handleSaveConf writes the rc file, merging edits made on disk since it was loaded
+func (s *Server) handleSaveConf(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling saveconf command")
+
+	merged, err := config.Get().Save()
+	var conflict *config.ConflictError
+	if errors.As(err, &conflict) {
+		log.Printf("[ERROR] Writing error response: cmd=%s, type=conflict, desc=%v", cmd.Name, err)
+		s.writeResponse(conn, errorResponse(cmd, response.StatusConflict, "conflict", err.Error()).Body(conflict.Diff...))
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to save config: %v", err)
+		s.writeError(conn, cmd, response.StatusInternal, "save failed", err.Error())
+		return
+	}
+
+	s.writeResponse(conn, response.OK("saveconf").SetBool("merged", merged))
+}
+
+// rootsOpt makes sources list the walks of the roots instead of the scanners
+const rootsOpt = "opt: roots"
+
+// handleSources lists the scanners feeding the index with their entry counts and roots, or
+// with "opt: roots" how long walking every root took
+func (s *Server) handleSources(conn net.Conn, cmd *parser.Command) {
+	sources := s.indexer.Sources()
+
+	if len(cmd.Args) > 0 {
+		if len(cmd.Args) > 1 || cmd.Args[0].Type != parser.TypeString || cmd.Args[0].Str != rootsOpt {
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", `sources command accepts only "opt: roots"`)
+			return
+		}
+		roots := rootLines(sources)
+		s.writeResponse(conn, response.OK("sources").Setf("len", "%d", len(roots)).Body(roots...))
+		return
+	}
+
+	resp := response.OK("sources").Setf("len", "%d", len(sources)).Body()
+	for _, source := range sources {
+		line := fmt.Sprintf("%s %d", source.Name, source.Count)
+		if len(source.Roots) > 0 {
+			line += " " + strings.Join(source.Roots, ":")
+		}
+		resp.Body(line)
+	}
+	s.writeResponse(conn, resp)
+}
+
+// originOpt asks config where every value comes from
+const originOpt = "opt: origin"
+
+// handleConfig returns the configuration the daemon runs with, as resolved: the scalar
+// settings as attrs and the directories searched for executables in the body
+func (s *Server) handleConfig(conn net.Conn, cmd *parser.Command) {
+	withOrigin := false
+	if len(cmd.Args) > 0 {
+		if len(cmd.Args) > 1 || cmd.Args[0].Type != parser.TypeString || cmd.Args[0].Str != originOpt {
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", `config command accepts only "opt: origin"`)
+			return
+		}
+		withOrigin = true
+	}
+
+	// Values come from the environment, the builder keeps line breaks out of the attrs
+	resp := response.OK("config")
+	for _, setting := range config.Get().Settings() {
+		resp.Set(setting.Name, settingValue(setting))
+		if withOrigin {
+			resp.Set("origin-"+setting.Name, string(setting.Origin))
+		}
+	}
+	paths := config.Get().SearchPaths()
+	lines := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if withOrigin {
+			lines = append(lines, fmt.Sprintf("%s %s", path.Origin, path.Path))
+		} else {
+			lines = append(lines, path.Path)
+		}
+	}
+	resp.Setf("len", "%d", len(lines)).Body(lines...)
+	s.writeResponse(conn, resp)
+}
+
+// settingValue returns the value of setting as config shows it: a sensitive one is masked
+// like strings in the log, unless ADE_INDEXD_LOG_SENSITIVE is set
+func settingValue(setting config.Setting) string {
+	if setting.Sensitive {
+		return redact.String(setting.Value)
+	}
+	return setting.Value
+}
+
+// handleReindexStatus shows the reindex under way and the follow-up queued behind it
+func (s *Server) handleReindexStatus(conn net.Conn, cmd *parser.Command) {
+	running, pending := s.indexer.ReindexQueue()
+
+	var lines []string
+	for _, run := range []struct {
+		state string
+		run   *indexer.QueuedRun
+	}{{"running", running}, {"pending", pending}} {
+		if run.run == nil {
+			continue
+		}
+		scope := "roots"
+		if run.run.Scope.Full {
+			scope = "full"
+		}
+		line := fmt.Sprintf("%s %s %s %d %s", run.state, scope, run.run.Priority, run.run.Waiting, strings.Join(run.run.Triggers, ","))
+		if len(run.run.Scope.Paths) > 0 {
+			line += " " + strings.Join(run.run.Scope.Paths, ":")
+		}
+		lines = append(lines, line)
+	}
+
+	resp := response.OK("reindex-status").
+		SetBool("running", running != nil).
+		SetBool("pending", pending != nil).
+		Setf("len", "%d", len(lines)).
+		Body(lines...)
+	s.writeResponse(conn, resp)
+}
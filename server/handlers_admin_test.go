@@ -0,0 +1,339 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/indexer/desktop"
+	"github.com/0xADE/ade-ctld/internal/redact"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("reindex with the rc paths", func() {
+	var (
+		srv      *session
+		cacheDir string
+		pathDir  string
+		rcDir    string
+	)
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(cacheDir)
+
+		pathDir = filepath.Join(cacheDir, "path")
+		rcDir = filepath.Join(cacheDir, "rc")
+		for _, file := range []string{filepath.Join(pathDir, "pathtool"), filepath.Join(rcDir, "rctool")} {
+			Expect(os.MkdirAll(filepath.Dir(file), 0755)).To(Succeed())
+			Expect(os.WriteFile(file, []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		}
+		_, err = srv.indexer.Reindex(context.Background(), []string{pathDir, rcDir})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Get().AddPath(rcDir)).To(BeTrue())
+	})
+
+	AfterEach(func() {
+		config.Get().RemovePath(rcDir)
+		srv.runIndex.Close()
+		os.RemoveAll(cacheDir)
+	})
+
+	send := func(lines ...string) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+		return buf.String()
+	}
+
+	It("should rescan the rc paths and keep the PATH entries", func() {
+		Expect(os.Remove(filepath.Join(pathDir, "pathtool"))).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(rcDir, "newtool"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+		reply := send(`"`+rcOpt, "reindex")
+		Expect(reply).To(ContainSubstring("cmd: reindex\nstatus: 0\n"))
+		Expect(reply).To(ContainSubstring("rc-paths: 1\n"))
+		Expect(reply).To(ContainSubstring("indexed-executable: 3\n"))
+		for _, key := range []string{"pathtool", "rctool", "newtool"} {
+			_, ok := srv.indexer.GetIndex().GetByKey(key)
+			Expect(ok).To(BeTrue(), key)
+		}
+	})
+
+	It("should refuse paths along with the rc option", func() {
+		reply := send(`"`+rcOpt, `"`+pathDir, "reindex")
+		Expect(reply).To(ContainSubstring("status: 2\n"))
+		Expect(srv.indexer.Generation()).To(Equal(uint64(1)))
+	})
+
+	It("should refuse to preview an rc reindex", func() {
+		reply := send(`"`+dryRunOpt, `"`+rcOpt, "reindex")
+		Expect(reply).To(ContainSubstring("error: dry-run unsupported\n"))
+	})
+})
+
+var _ = Describe("capped index", func() {
+	It("should say the index is truncated and name the roots holding the most", func() {
+		srv := newTestServer(GinkgoT().TempDir())
+		DeferCleanup(srv.runIndex.Close)
+		dir := GinkgoT().TempDir()
+		for i := range 30 {
+			Expect(os.WriteFile(filepath.Join(dir, "tool"+itoa(int64(i))), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		}
+		srv.indexer.SetMaxEntries(10)
+
+		send := func(lines ...string) string {
+			var buf bytes.Buffer
+			srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+			return buf.String()
+		}
+		reply := send(`"`+dir, "reindex")
+		Expect(reply).To(ContainSubstring("indexed: 10\n"))
+		Expect(reply).To(ContainSubstring("index-truncated: t\n"))
+		Expect(reply).To(MatchRegexp(`\ntruncated: [1-9][0-9]*\n`))
+		Expect(reply).To(ContainSubstring("truncated-roots: " + dir))
+		Expect(send("list")).To(ContainSubstring("index-truncated: t\n"))
+		Expect(send("stats")).To(ContainSubstring("index-truncated: t\n"))
+
+		srv.indexer.SetMaxEntries(0)
+		reply = send(`"`+dir, "reindex")
+		Expect(reply).NotTo(ContainSubstring("truncated"))
+		Expect(send("list")).NotTo(ContainSubstring("index-truncated"))
+	})
+})
+
+var _ = Describe("reindex-status", func() {
+	It("should show the run under way and the follow-up queued behind it", func() {
+		cacheDir := GinkgoT().TempDir()
+		srv := newTestServer(cacheDir)
+		DeferCleanup(srv.runIndex.Close)
+		gate := &gateScanner{started: make(chan struct{}, 2), release: make(chan struct{})}
+		srv.indexer.AddScanner(gate)
+
+		send := func(lines ...string) string {
+			var buf bytes.Buffer
+			srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+			return buf.String()
+		}
+		reindex := func(lines ...string) <-chan string {
+			reply := make(chan string, 1)
+			go func() { reply <- send(lines...) }()
+			return reply
+		}
+
+		first := reindex(`"`+cacheDir, "reindex")
+		Eventually(gate.started).Should(Receive())
+		second := reindex(`"/opt/other`, "reindex")
+		Eventually(func() string { return send("reindex-status") }).Should(ContainSubstring("pending: t\n"))
+
+		Expect(send("reindex-status")).To(Equal("TXT01cmd: reindex-status\nstatus: 0\nrunning: t\npending: t\nlen: 2\n\nbody:\n" +
+			"running full client 1 command " + cacheDir + "\n" +
+			"pending full client 1 command /opt/other\n\n\n"))
+
+		close(gate.release)
+		Eventually(first).Should(Receive(ContainSubstring("status: 0\n")))
+		Eventually(second).Should(Receive(ContainSubstring("status: 0\n")))
+		Expect(send("reindex-status")).To(Equal("TXT01cmd: reindex-status\nstatus: 0\nrunning: f\npending: f\nlen: 0\n\nbody:\n\n\n"))
+	})
+})
+
+var _ = Describe("config", func() {
+	var (
+		srv   *session
+		rcDir string
+	)
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		DeferCleanup(srv.runIndex.Close)
+		rcDir = GinkgoT().TempDir()
+		Expect(config.Get().AddPath(rcDir)).To(BeTrue())
+		DeferCleanup(config.Get().RemovePath, rcDir)
+	})
+
+	send := func(lines ...string) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+		return buf.String()
+	}
+
+	It("should return the settings as attrs and the search paths in the body", func() {
+		reply := send("config")
+		Expect(reply).To(HavePrefix("TXT01cmd: config\nstatus: 0\n"))
+		Expect(attrLine(reply, "list-limit")).To(Equal(itoa(int64(config.Get().ListLimit()))))
+		Expect(attrLine(reply, "socket")).To(Equal(config.Get().UnixSocket()))
+		Expect(attrLine(reply, "len")).To(Equal(itoa(int64(len(config.Get().Path())))))
+		Expect(reply).To(HaveSuffix("\nbody:\n" + strings.Join(config.Get().Path(), "\n") + "\n\n\n"))
+		Expect(reply).NotTo(ContainSubstring("origin-"))
+	})
+
+	It("should tell where every value comes from with opt: origin", func() {
+		reply := send(`"`+originOpt, "config")
+		for _, setting := range config.Get().Settings() {
+			Expect(attrLine(reply, "origin-"+setting.Name)).To(Equal(string(setting.Origin)), setting.Name)
+		}
+		Expect(reply).To(ContainSubstring("\nrc " + rcDir + "\n"))
+	})
+
+	It("should refuse other arguments", func() {
+		reply := send(`"opt: roots`, "config")
+		Expect(reply).To(ContainSubstring("status: 2\n"))
+		Expect(reply).To(ContainSubstring("hint: [\"opt: origin] config\n"))
+	})
+
+	It("should mask sensitive values unless sensitive logging is on", func() {
+		DeferCleanup(redact.SetSensitive, redact.Sensitive())
+		setting := config.Setting{Name: "token", Value: "s3cret", Sensitive: true}
+		redact.SetSensitive(false)
+		Expect(settingValue(setting)).NotTo(ContainSubstring("s3cret"))
+		Expect(settingValue(config.Setting{Name: "sort", Value: "name"})).To(Equal("name"))
+		redact.SetSensitive(true)
+		Expect(settingValue(setting)).To(Equal("s3cret"))
+	})
+})
+
+// gateScanner finds nothing, it blocks every scan until released
+type gateScanner struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *gateScanner) Name() string { return "gate" }
+
+func (s *gateScanner) Scan(ctx context.Context, roots []string, out chan<- *indexer.Entry) error {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+var _ = Describe("export-index", func() {
+	var srv *session
+
+	BeforeEach(func() {
+		srv = goldenServer(GinkgoT().TempDir())
+		DeferCleanup(srv.runIndex.Close)
+		nice := 5
+		srv.indexer.GetIndex().Add(&indexer.Entry{
+			Key:          "editor.desktop",
+			Name:         "Editor",
+			RawName:      "gedit",
+			Names:        map[string]string{"fr": "Éditeur <texte>"},
+			GenericName:  "Text Editor",
+			GenericNames: map[string]string{"fr": "Éditeur de texte"},
+			Path:         "/usr/share/applications/editor.desktop",
+			Exec:         "gedit %U",
+			DesktopID:    "editor.desktop",
+			Terminal:     true,
+			SelfTool:     true,
+			Nice:         &nice,
+			Categories:   []string{"Utility", "TextEditor"},
+			Actions:      []desktop.Action{{Key: "new-window", Name: "New Window", Exec: "gedit --new-window"}},
+			IsDesktop:    true,
+			ModTime:      time.Date(2024, 4, 1, 11, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+			Origin:       "/run/ade/indexd.sock",
+		})
+	})
+
+	// records parses the JSON lines of an export-index reply
+	records := func(reply string) []indexer.Record {
+		_, body, ok := strings.Cut(reply, "\nbody:\n")
+		Expect(ok).To(BeTrue())
+		var records []indexer.Record
+		for _, line := range strings.Split(strings.TrimSuffix(body, "\n\n\n"), "\n") {
+			var record indexer.Record
+			Expect(json.Unmarshal([]byte(line), &record)).To(Succeed(), line)
+			records = append(records, record)
+		}
+		return records
+	}
+
+	It("should export every entry and read back as the index has it", func() {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest("export-index"))
+		reply := buf.String()
+		Expect(reply).To(HavePrefix("TXT01cmd: export-index\nstatus: 0\ngeneration: 0\nrevision: 1\nlen: 5\n\nbody:\n"))
+
+		entries := srv.indexer.GetIndex().GetAll()
+		exported := records(reply)
+		Expect(exported).To(HaveLen(len(entries)))
+		for i, entry := range entries {
+			Expect(exported[i]).To(Equal(indexer.NewRecord(entry)), entry.Path)
+		}
+		Expect(exported[4].Names).To(HaveKeyWithValue("fr", "Éditeur <texte>"))
+		Expect(exported[4].Actions).To(Equal([]indexer.RecordAction{{Key: "new-window", Name: "New Window", Exec: "gedit --new-window"}}))
+		Expect(*exported[4].Nice).To(Equal(5))
+		Expect(exported[4].ModTime).To(BeTemporally("==", entries[4].ModTime))
+		Expect(reply).To(ContainSubstring(`"names":{"fr":"Éditeur <texte>"}`))
+	})
+
+	It("should refuse arguments", func() {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest("1", "export-index"))
+		Expect(buf.String()).To(ContainSubstring("status: 2\n"))
+		Expect(buf.String()).To(ContainSubstring("hint: export-index\n"))
+	})
+
+	It("should stream large indexes in several writes without letting events in between", func() {
+		for i := range 1000 {
+			srv.indexer.GetIndex().Add(&indexer.Entry{
+				Key:  "tool-" + itoa(int64(i)),
+				Name: "tool " + itoa(int64(i)),
+				Path: "/opt/tools/bin/tool-" + itoa(int64(i)),
+				Exec: "/opt/tools/bin/tool-" + itoa(int64(i)),
+			})
+		}
+		var buf bytes.Buffer
+		conn := &gatedConn{mockConn: mockConn{writeBuf: &buf}, started: make(chan struct{}), release: make(chan struct{})}
+		sc := &syncConn{Conn: conn}
+
+		exported := make(chan struct{})
+		go func() {
+			defer close(exported)
+			srv.handleExportIndex(sc, &parser.Command{Name: "export-index"})
+		}()
+		Eventually(conn.started).Should(BeClosed())
+		event := make(chan struct{})
+		go func() {
+			defer close(event)
+			sc.Write([]byte("TXT01event: index-updated\n\n\n"))
+		}()
+		Consistently(event, 50*time.Millisecond).ShouldNot(BeClosed())
+
+		close(conn.release)
+		Eventually(exported).Should(BeClosed())
+		Eventually(event).Should(BeClosed())
+		Expect(conn.writes).To(BeNumerically(">", 2))
+		reply, ok := strings.CutSuffix(buf.String(), "TXT01event: index-updated\n\n\n")
+		Expect(ok).To(BeTrue())
+		Expect(records(reply)).To(HaveLen(1005))
+	})
+})
+
+// gatedConn holds its first write until release is closed
+type gatedConn struct {
+	mockConn
+	writes  int
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *gatedConn) Write(b []byte) (int, error) {
+	if c.writes++; c.writes == 1 {
+		close(c.started)
+		<-c.release
+	}
+	return c.mockConn.Write(b)
+}
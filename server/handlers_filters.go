@@ -0,0 +1,266 @@
+package server
+
+import (
+	"log"
+	"net"
+	"slices"
+
+	"github.com/0xADE/ade-ctld/internal/redact"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// allowEmptyOpt lets the +filter commands go without values, as a no-op, for clients
+// probing with them
+const allowEmptyOpt = "opt: allow-empty"
+
+// takeAllowEmpty returns whether args start with "opt: allow-empty", and the args after it
+func takeAllowEmpty(args []parser.Value) (bool, []parser.Value) {
+	if len(args) > 0 && args[0].Type == parser.TypeString && args[0].Str == allowEmptyOpt {
+		return true, args[1:]
+	}
+	return false, args
+}
+
+// refuseEmpty refuses a +filter command left without values, operators alone adding
+// nothing, unless allowed. It reports whether it did.
+func (s *Server) refuseEmpty(conn net.Conn, cmd *parser.Command, values []string, allowed bool) bool {
+	if len(values) > 0 || allowed {
+		return false
+	}
+	log.Printf("[ERROR] %s command without values", cmd.Name)
+	s.writeError(conn, cmd, response.StatusBadArgument, "no values",
+		cmd.Name+` command needs at least one string value, "`+allowEmptyOpt+` makes it a no-op`)
+	return true
+}
+
+// filterReply is the reply of a filter command, with the number of filters of the family
+// it changed now set, like "name-filters: 2"
+func filterReply(cmd, family string, count int) *response.Response {
+	return response.OK(cmd).Setf(family, "%d", count)
+}
+
+func (s *session) handleFilterNameReplace(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling filter-name command")
+	s.filters.mu.Lock()
+	defer s.filters.mu.Unlock()
+
+	expr := FilterExpr{Values: []string{}, Op: andOp}
+	for _, arg := range cmd.Args {
+		switch arg.Type {
+		case parser.TypeInt:
+			log.Printf("[ERROR] filter-name command received integer argument")
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", "filter-name command accepts only string values and boolean operators")
+			return
+		case parser.TypeString:
+			expr.Values = append(expr.Values, arg.Str)
+		case parser.TypeBool:
+			// Check the original string to distinguish between and/not
+			if arg.Str == notOp {
+				expr.Op = notOp
+			} else if arg.Str == orOp || arg.Bool {
+				expr.Op = orOp
+			} else {
+				expr.Op = andOp
+			}
+		}
+	}
+
+	if len(expr.Values) > 0 {
+		next := s.filters.snapshot()
+		next.NameFilters = []FilterExpr{expr}
+		if desc := s.filterLimit(next); desc != "" {
+			log.Printf("[WARN] filter-name refused, filter limits reached")
+			s.writeError(conn, cmd, response.StatusLimit, "limit", desc)
+			return
+		}
+		s.filters.nameFilters = next.NameFilters
+		log.Printf("[DEBUG] Replaced name filters with: %s (op: %s)", redact.Strings(expr.Values), expr.Op)
+	} else {
+		s.filters.nameFilters = []FilterExpr{}
+		log.Printf("[DEBUG] Cleared name filters")
+	}
+
+	// Send success response (returns filter-name as per spec)
+	s.writeResponse(conn, filterReply("filter-name", "name-filters", len(s.filters.nameFilters)))
+}
+
+func (s *session) handleAddFilterName(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling +filter-name command")
+	s.filters.mu.Lock()
+	defer s.filters.mu.Unlock()
+
+	allowEmpty, args := takeAllowEmpty(cmd.Args)
+	expr := FilterExpr{Values: []string{}, Op: orOp}
+	for _, arg := range args {
+		switch arg.Type {
+		case parser.TypeInt:
+			log.Printf("[ERROR] +filter-name command received integer argument")
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", "+filter-name command accepts only string values and boolean operators")
+			return
+		case parser.TypeString:
+			expr.Values = append(expr.Values, arg.Str)
+		case parser.TypeBool:
+			// Check the original string to distinguish between and/not
+			if arg.Str == notOp {
+				expr.Op = notOp
+			} else if arg.Str == "or" || arg.Bool {
+				expr.Op = orOp
+			} else {
+				expr.Op = andOp
+			}
+		}
+	}
+
+	if s.refuseEmpty(conn, cmd, expr.Values, allowEmpty) {
+		return
+	}
+	if len(expr.Values) > 0 {
+		next := s.filters.snapshot()
+		next.NameFilters = append(slices.Clip(next.NameFilters), expr)
+		if desc := s.filterLimit(next); desc != "" {
+			log.Printf("[WARN] +filter-name refused, filter limits reached")
+			s.writeError(conn, cmd, response.StatusLimit, "limit", desc)
+			return
+		}
+		s.filters.nameFilters = next.NameFilters
+		log.Printf("[DEBUG] Added name filter: %s (op: %s)", redact.Strings(expr.Values), expr.Op)
+	}
+
+	// Send success response
+	s.writeResponse(conn, filterReply("+filter-name", "name-filters", len(s.filters.nameFilters)))
+}
+
+func (s *session) handleFilterCat(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling filter-cat command")
+	s.filters.mu.Lock()
+	defer s.filters.mu.Unlock()
+
+	allowEmpty, args := takeAllowEmpty(cmd.Args)
+	expr := FilterExpr{Values: []string{}, Op: andOp}
+	for _, arg := range args {
+		switch arg.Type {
+		case parser.TypeInt:
+			log.Printf("[ERROR] +filter-cat command received integer argument")
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", "+filter-cat command accepts only string values and boolean operators")
+			return
+		case parser.TypeString:
+			expr.Values = append(expr.Values, arg.Str)
+		case parser.TypeBool:
+			if arg.Str == notOp {
+				expr.Op = notOp
+			} else if arg.Bool {
+				expr.Op = orOp
+			} else {
+				expr.Op = andOp
+			}
+		}
+	}
+
+	if s.refuseEmpty(conn, cmd, expr.Values, allowEmpty) {
+		return
+	}
+	if len(expr.Values) > 0 {
+		next := s.filters.snapshot()
+		next.CatFilters = append(slices.Clip(next.CatFilters), expr)
+		if desc := s.filterLimit(next); desc != "" {
+			log.Printf("[WARN] +filter-cat refused, filter limits reached")
+			s.writeError(conn, cmd, response.StatusLimit, "limit", desc)
+			return
+		}
+		s.filters.catFilters = next.CatFilters
+		log.Printf("[DEBUG] Added cat filter: %s (op: %s)", redact.Strings(expr.Values), expr.Op)
+	}
+
+	// Send success response
+	s.writeResponse(conn, filterReply("+filter-cat", "cat-filters", len(s.filters.catFilters)))
+}
+
+// handleExcludeCat hides entries in any of the given categories, whatever the other
+// filters match
+func (s *session) handleExcludeCat(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling -filter-cat command")
+
+	var cats []string
+	for _, arg := range cmd.Args {
+		if arg.Type != parser.TypeString {
+			log.Printf("[ERROR] -filter-cat command received non-string argument")
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", "-filter-cat command accepts only category strings")
+			return
+		}
+		cats = append(cats, arg.Str)
+	}
+
+	s.filters.mu.Lock()
+	next := s.filters.snapshot()
+	next.ExcludeCats = append(slices.Clip(next.ExcludeCats), cats...)
+	if desc := s.filterLimit(next); desc != "" {
+		s.filters.mu.Unlock()
+		log.Printf("[WARN] -filter-cat refused, filter limits reached")
+		s.writeError(conn, cmd, response.StatusLimit, "limit", desc)
+		return
+	}
+	s.filters.excludeCats = next.ExcludeCats
+	s.filters.mu.Unlock()
+	log.Printf("[DEBUG] Excluded categories: %s", redact.Strings(cats))
+
+	s.writeResponse(conn, filterReply("-filter-cat", "exclude-cats", len(next.ExcludeCats)))
+}
+
+func (s *session) handleFilterPath(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling filter-path command")
+	s.filters.mu.Lock()
+	defer s.filters.mu.Unlock()
+
+	allowEmpty, args := takeAllowEmpty(cmd.Args)
+	expr := FilterExpr{Values: []string{}, Op: orOp}
+	for _, arg := range args {
+		switch arg.Type {
+		case parser.TypeInt:
+			log.Printf("[ERROR] +filter-path command received integer argument")
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", "+filter-path command accepts only string values and boolean operators")
+			return
+		case parser.TypeString:
+			expr.Values = append(expr.Values, arg.Str)
+		case parser.TypeBool:
+			if arg.Str == notOp {
+				expr.Op = notOp
+			} else if arg.Bool {
+				expr.Op = orOp
+			} else {
+				expr.Op = andOp
+			}
+		}
+	}
+
+	if s.refuseEmpty(conn, cmd, expr.Values, allowEmpty) {
+		return
+	}
+	if len(expr.Values) > 0 {
+		next := s.filters.snapshot()
+		next.PathFilters = append(slices.Clip(next.PathFilters), expr)
+		if desc := s.filterLimit(next); desc != "" {
+			log.Printf("[WARN] +filter-path refused, filter limits reached")
+			s.writeError(conn, cmd, response.StatusLimit, "limit", desc)
+			return
+		}
+		s.filters.pathFilters = next.PathFilters
+		log.Printf("[DEBUG] Added path filter: %s (op: %s)", redact.Strings(expr.Values), expr.Op)
+	}
+
+	// Send success response
+	s.writeResponse(conn, filterReply("+filter-path", "path-filters", len(s.filters.pathFilters)))
+}
+
+func (s *session) handleResetFilters(conn net.Conn) {
+	log.Printf("[DEBUG] Resetting all filters")
+	s.filters.mu.Lock()
+	defer s.filters.mu.Unlock()
+	s.filters.nameFilters = []FilterExpr{}
+	s.filters.catFilters = []FilterExpr{}
+	s.filters.pathFilters = []FilterExpr{}
+	s.filters.excludeCats = nil
+
+	// Send success response
+	s.writeResponse(conn, response.OK("0filters"))
+}
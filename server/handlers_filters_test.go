@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("filter handlers", func() {
+	var srv *session
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		index := srv.indexer.GetIndex()
+		for _, entry := range []*indexer.Entry{
+			{Name: "Firefox", Names: map[string]string{"de": "Feuerfuchs"}, Path: "/usr/share/applications/firefox.desktop", Categories: []string{"Network", "WebBrowser"}},
+			{Name: "Files", Path: "/usr/share/applications/files.desktop", Categories: []string{"System", "FileManager"}},
+			{Name: "firejail", Path: "/usr/bin/firejail", Categories: []string{"System"}},
+			{Name: "Solitaire", Path: "/usr/games/sol", Categories: []string{"Game", "CardGame"}},
+		} {
+			index.Add(entry)
+		}
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+	})
+
+	// send runs a request as the client writes it, e.g. send(`"fire`, "filter-name")
+	send := func(lines ...string) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+		return buf.String()
+	}
+
+	names := func() []string {
+		_, body, _ := strings.Cut(send("list"), "body:\n")
+		var names []string
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			if _, name, ok := strings.Cut(line, " "); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	It("should match names and localized names case-insensitively", func() {
+		Expect(send(`"FIRE`, "filter-name")).To(Equal("TXT01cmd: filter-name\nstatus: 0\nname-filters: 1\n\n\n"))
+		Expect(names()).To(ConsistOf("Firefox", "firejail"))
+
+		send(`"fuchs`, "filter-name")
+		Expect(names()).To(ConsistOf("Firefox"))
+	})
+
+	It("should combine the terms of filter-name with and, or and not", func() {
+		send(`"fi`, `"fox`, "filter-name")
+		Expect(names()).To(ConsistOf("Firefox"))
+
+		send(`"fox`, `"sol`, "or", "filter-name")
+		Expect(names()).To(ConsistOf("Firefox", "Solitaire"))
+
+		send(`"fi`, "not", "filter-name")
+		Expect(names()).To(ConsistOf("Solitaire"))
+	})
+
+	It("should replace the name filter with filter-name and add to it with +filter-name", func() {
+		send(`"fox`, "filter-name")
+		send(`"sol`, "+filter-name")
+		Expect(names()).To(ConsistOf("Firefox", "Solitaire"))
+
+		send(`"jail`, "filter-name")
+		Expect(names()).To(ConsistOf("firejail"))
+	})
+
+	It("should clear the name filter with an empty filter-name", func() {
+		send(`"fox`, "filter-name")
+		send("filter-name")
+		Expect(names()).To(HaveLen(4))
+	})
+
+	It("should require every filter kind to match", func() {
+		send(`"fi`, "filter-name")
+		send(`"System`, "+filter-cat")
+		Expect(names()).To(ConsistOf("Files", "firejail"))
+
+		send(`"/usr/bin/`, "+filter-path")
+		Expect(names()).To(ConsistOf("firejail"))
+	})
+
+	It("should match any category of +filter-cat and any path of +filter-path", func() {
+		send(`"game`, `"webbrowser`, "+filter-cat")
+		Expect(names()).To(ConsistOf("Firefox", "Solitaire"))
+
+		send(`"/usr/games/`, `"/usr/bin/`, "+filter-path")
+		Expect(names()).To(ConsistOf("Solitaire"))
+	})
+
+	It("should exclude categories whatever the other filters include", func() {
+		send(`"System`, `"Game`, "+filter-cat")
+		send(`"FileManager`, "-filter-cat")
+		Expect(names()).To(ConsistOf("firejail", "Solitaire"))
+	})
+
+	DescribeTable("not filters, excluding what any of their values matches",
+		func(requests [][]string, expected []string) {
+			srv.indexer.GetIndex().Add(&indexer.Entry{Name: "Minesweeper", Path: "/home/u/.wine/drive_c/mines.exe", Categories: []string{"Game", "Wine"}})
+			for _, request := range requests {
+				Expect(send(request...)).To(ContainSubstring("status: 0\n"))
+			}
+			Expect(names()).To(ConsistOf(expected))
+		},
+		Entry("include Games but not Wine",
+			[][]string{{`"Game`, "+filter-cat"}, {`"Wine`, "not", "+filter-cat"}}, []string{"Solitaire"}),
+		Entry("a category alone",
+			[][]string{{`"Game`, "not", "+filter-cat"}}, []string{"Firefox", "Files", "firejail"}),
+		Entry("every not filter of a kind",
+			[][]string{{`"Game`, "not", "+filter-cat"}, {`"Network`, "not", "+filter-cat"}}, []string{"Files", "firejail"}),
+		Entry("a name with another name",
+			[][]string{{`"fi`, "+filter-name"}, {`"jail`, "not", "+filter-name"}}, []string{"Firefox", "Files"}),
+		Entry("a name with a category",
+			[][]string{{`"mine`, "not", "+filter-name"}, {`"Game`, "+filter-cat"}}, []string{"Solitaire"}),
+		Entry("a path with another path",
+			[][]string{{`"/usr/`, "+filter-path"}, {`"/usr/bin/`, "not", "+filter-path"}}, []string{"Firefox", "Files", "Solitaire"}),
+		Entry("a path with a category",
+			[][]string{{`"/.wine/`, `"/usr/games/`, "not", "+filter-path"}, {`"Game`, `"System`, "or", "+filter-cat"}}, []string{"Files", "firejail"}),
+	)
+
+	It("should tell how many filters of the kind changed are set", func() {
+		Expect(attrLine(send(`"fire`, "+filter-name"), "name-filters")).To(Equal("1"))
+		Expect(attrLine(send(`"files`, `"sol`, "+filter-name"), "name-filters")).To(Equal("2"))
+		Expect(attrLine(send(`"System`, "+filter-cat"), "cat-filters")).To(Equal("1"))
+		Expect(attrLine(send(`"/usr/bin`, "+filter-path"), "path-filters")).To(Equal("1"))
+		Expect(attrLine(send(`"Game`, `"Network`, "-filter-cat"), "exclude-cats")).To(Equal("2"))
+		Expect(attrLine(send(`"fire`, "filter-name"), "name-filters")).To(Equal("1"))
+	})
+
+	It("should refuse +filter commands without values, operators alone adding nothing", func() {
+		send(`"fire`, "+filter-name")
+		for _, request := range [][]string{
+			{"+filter-name"},
+			{"or", "+filter-name"},
+			{"not", "and", "+filter-name"},
+			{"t", "+filter-cat"},
+			{"f", "+filter-path"},
+		} {
+			reply := send(request...)
+			Expect(reply).To(HavePrefix("TXT01error-cmd: " + request[len(request)-1] + "\nerror: no values\nstatus: 2\n"))
+			Expect(reply).To(ContainSubstring(`"opt: allow-empty`))
+		}
+		Expect(names()).To(ConsistOf("Firefox", "firejail"))
+	})
+
+	It("should let +filter commands go without values as a no-op with opt: allow-empty", func() {
+		send(`"fire`, "+filter-name")
+		Expect(send(`"opt: allow-empty`, "or", "+filter-name")).To(Equal("TXT01cmd: +filter-name\nstatus: 0\nname-filters: 1\n\n\n"))
+		Expect(send(`"opt: allow-empty`, "+filter-cat")).To(Equal("TXT01cmd: +filter-cat\nstatus: 0\ncat-filters: 0\n\n\n"))
+		Expect(names()).To(ConsistOf("Firefox", "firejail"))
+
+		// The option isn't taken for a value, those after it are
+		Expect(attrLine(send(`"opt: allow-empty`, `"/usr/bin`, "+filter-path"), "path-filters")).To(Equal("1"))
+		Expect(names()).To(ConsistOf("firejail"))
+	})
+
+	It("should reset every filter with 0filters", func() {
+		send(`"fox`, "filter-name")
+		send(`"Network`, "+filter-cat")
+		send(`"/usr/`, "+filter-path")
+		send(`"Network`, "-filter-cat")
+		Expect(names()).To(BeEmpty())
+
+		Expect(send("0filters")).To(Equal("TXT01cmd: 0filters\nstatus: 0\n\n\n"))
+		Expect(names()).To(HaveLen(4))
+	})
+
+	Context("with limits", func() {
+		BeforeEach(func() {
+			srv.maxFilters = 2
+		})
+
+		It("should refuse filters beyond the limit of each kind and keep those set", func() {
+			for _, req := range [][]string{
+				{`"fire`, "+filter-name"},
+				{`"System`, "+filter-cat"},
+				{`"/usr/`, "+filter-path"},
+				{`"Game`, "-filter-cat"},
+			} {
+				Expect(send(req...)).To(ContainSubstring("status: 0\n"), req[1])
+				Expect(send(req...)).To(ContainSubstring("status: 0\n"), req[1])
+				reply := send(req...)
+				Expect(reply).To(HavePrefix("TXT01error-cmd: "+req[1]+"\nerror: limit\nstatus: 9\n"), req[1])
+				Expect(reply).To(ContainSubstring("at most 2"))
+			}
+			Expect(srv.filters.nameFilters).To(HaveLen(2))
+			Expect(srv.filters.catFilters).To(HaveLen(2))
+			Expect(srv.filters.pathFilters).To(HaveLen(2))
+			Expect(srv.filters.excludeCats).To(HaveLen(2))
+			Expect(names()).To(ConsistOf("firejail"))
+
+			// Replacing the name filter doesn't add to it
+			Expect(send(`"jail`, "filter-name")).To(ContainSubstring("status: 0\n"))
+			Expect(srv.filters.nameFilters).To(HaveLen(1))
+
+			Expect(send("0filters")).To(ContainSubstring("status: 0\n"))
+			Expect(send(`"sol`, "+filter-name")).To(ContainSubstring("status: 0\n"))
+			Expect(names()).To(ConsistOf("Solitaire"))
+		})
+
+		It("should refuse excluded categories past the limit all at once", func() {
+			reply := send(`"Game`, `"System`, `"Network`, "-filter-cat")
+			Expect(reply).To(ContainSubstring("error: limit\n"))
+			Expect(srv.filters.excludeCats).To(BeEmpty())
+			Expect(names()).To(HaveLen(4))
+		})
+
+		It("should refuse filters taking more memory than allowed", func() {
+			srv.maxFilters = 0
+			srv.maxFilterBytes = 256
+			Expect(send(`"fire`, "filter-name")).To(ContainSubstring("status: 0\n"))
+
+			long := `"` + strings.Repeat("x", 256)
+			reply := send(long, "+filter-name")
+			Expect(reply).To(ContainSubstring("error: limit\n"))
+			Expect(reply).To(ContainSubstring("at most 256"))
+			Expect(send(long, "filter-name")).To(ContainSubstring("error: limit\n"))
+			Expect(send(long, "+filter-path")).To(ContainSubstring("error: limit\n"))
+			Expect(names()).To(ConsistOf("Firefox", "firejail"))
+
+			for i := 0; ; i++ {
+				reply := send(`"fi`, "+filter-name")
+				if strings.Contains(reply, "error: limit\n") {
+					Expect(i).To(BeNumerically(">", 0))
+					break
+				}
+				Expect(i).To(BeNumerically("<", 10))
+			}
+			Expect(srv.filters.snapshot().filterBytes()).To(BeNumerically("<=", 256))
+			Expect(names()).To(ConsistOf("Firefox", "Files", "firejail"))
+		})
+	})
+
+	It("should reject integer arguments", func() {
+		for _, name := range []string{"filter-name", "+filter-name", "+filter-cat", "-filter-cat", "+filter-path"} {
+			reply := send("42", name)
+			Expect(reply).To(HavePrefix("TXT01error-cmd: "+name+"\n"), name)
+			Expect(reply).To(ContainSubstring("status: 2\n"))
+		}
+	})
+})
+
+// parseRequest parses a request as the client writes it, one argument or the command name per
+// line
+func parseRequest(lines ...string) *parser.Command {
+	p, err := parser.NewParser(strings.NewReader("TXT01" + strings.Join(lines, "\n") + "\n"))
+	Expect(err).NotTo(HaveOccurred())
+	cmd, err := p.ParseCommand()
+	Expect(err).NotTo(HaveOccurred())
+	return cmd
+}
@@ -0,0 +1,830 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/indexer/desktop"
+	"github.com/0xADE/ade-ctld/internal/redact"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+func (s *session) handleList(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling list command")
+
+	opts, _, ok := s.listOpts(conn, cmd)
+	if !ok {
+		return
+	}
+	order := opts.order
+
+	idx, generation := s.indexer.GetIndexGeneration()
+	allEntries := idx.GetAll()
+
+	s.filters.mu.RLock()
+	filtered := s.filterEntries(allEntries)
+	s.filters.mu.RUnlock()
+	filtered = withoutSelfTools(filtered, opts)
+
+	keys := s.sortEntries(filtered, order)
+
+	log.Printf("[DEBUG] Found %d entries after filtering (total: %d)", len(filtered), len(allEntries))
+
+	cfg := config.Get()
+	limit := cfg.ListLimit()
+	fullLen := len(filtered)
+	// A limit of 0 lists everything
+	shown := fullLen
+	if limit > 0 {
+		shown = min(fullLen, limit)
+	}
+
+	resp := response.New().
+		Setf("len", "%d", fullLen).
+		Setf("list-len", "%d", shown).
+		// Entries in the index before filtering, for "X of Y" displays
+		Setf("total", "%d", len(allEntries)).
+		Setf("revision", "%d", idx.MaxRevision()).
+		// The order applied, the configured one unless the request asked for another
+		Set("sort", s.appliedOrder(order))
+	s.setUpstreamAttr(resp)
+	s.setTruncatedAttr(resp)
+	if len(allEntries) == 0 && s.ensureIndexing() {
+		// Nothing to show yet, the client should ask again once indexing is done
+		resp.SetBool("indexing", true).SetBool("partial", true)
+	}
+
+	// Apply limit if needed
+	if shown < fullLen {
+		// truncated is the plain flag of a capped body, limited gives the limit
+		resp.SetBool("truncated", true).
+			Setf("limited", "%d", limit).
+			Set("offset", "0").
+			Setf("pages", "%d", pageCount(fullLen, limit)).
+			Setf("list-next", "%d %d", limit, limit)
+		last := keys[filtered[limit-1].ID]
+		resp.Set("cursor", newCursor(generation, order, limit, last).String())
+	}
+
+	resp.Body()
+	runs := s.listRuns(filtered[:shown], opts)
+	for _, entry := range filtered[:shown] {
+		resp.Body(s.listLine(entry, opts, runs))
+	}
+
+	s.writeResponse(conn, resp)
+	log.Printf("[DEBUG] List response sent")
+}
+
+// ensureIndexing starts a reindex when the index was never built, e.g. when list comes in
+// right after a cold start, and reports whether indexing is under way. An index that is
+// empty after a completed run stays so until the next reindex.
+func (s *Server) ensureIndexing() bool {
+	if s.indexer.IsRunning() {
+		return true
+	}
+	if s.indexer.Generation() > 0 {
+		return false
+	}
+	if s.autoIndexing.CompareAndSwap(false, true) {
+		log.Printf("[DEBUG] Index was never built, starting indexing")
+		go func() {
+			defer s.autoIndexing.Store(false)
+			req := indexer.ReindexRequest{Scope: indexer.FullScope(nil), Priority: indexer.PriorityClient, Trigger: indexer.TriggerList}
+			if _, err := s.indexer.Schedule(context.Background(), req); err != nil {
+				log.Printf("[ERROR] Reindex failed: %v", err)
+			}
+		}()
+	}
+	return true
+}
+
+func (s *session) handleListNext(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling list-next command")
+
+	opts, args, ok := s.listOpts(conn, cmd)
+	if !ok {
+		return
+	}
+	order := opts.order
+
+	if len(args) > 0 && args[0].Type == parser.TypeString {
+		s.listFromCursor(conn, cmd, opts, args)
+		return
+	}
+	if len(args) == 0 || args[0].Type != parser.TypeInt {
+		log.Printf("[ERROR] list-next command missing offset parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing offset", "list-next command requires an offset parameter")
+		return
+	}
+
+	offset := int(args[0].Int)
+	if offset < 0 {
+		log.Printf("[ERROR] list-next command invalid offset: %d", offset)
+		s.writeError(conn, cmd, response.StatusBadArgument, "invalid offset", "offset must be non-negative")
+		return
+	}
+
+	cfg := config.Get()
+	limitSize := cfg.ListLimit()
+
+	// Check if limit_size is provided as second argument
+	if len(args) >= 2 && args[1].Type == parser.TypeInt {
+		if args[1].Int > 0 {
+			limitSize = int(args[1].Int)
+		}
+	}
+
+	idx, generation := s.indexer.GetIndexGeneration()
+	allEntries := idx.GetAll()
+
+	s.filters.mu.RLock()
+	filtered := s.filterEntries(allEntries)
+	s.filters.mu.RUnlock()
+	filtered = withoutSelfTools(filtered, opts)
+
+	// Pages are cut from the order list returns
+	keys := s.sortEntries(filtered, order)
+
+	shown := s.writePage(conn, listPage{
+		entries:    filtered,
+		keys:       keys,
+		total:      len(allEntries),
+		revision:   idx.MaxRevision(),
+		offset:     offset,
+		limit:      limitSize,
+		opts:       opts,
+		generation: generation,
+	})
+	log.Printf("[DEBUG] list-next response sent (offset: %d, limit: %d, shown: %d)", offset, limitSize, shown)
+}
+
+// listFromCursor answers list-next with a cursor: the page after the entry the cursor was
+// made for. A cursor from another index generation, or not made by this daemon run, gets
+// cursor-invalid: t and the first page instead, in the order asked for by the request.
+func (s *session) listFromCursor(conn net.Conn, cmd *parser.Command, opts listOptions, args []parser.Value) {
+	order := opts.order
+	c, err := parseCursor(args[0].Str)
+	if err != nil && len(args[0].Str) > maxCursorLen {
+		log.Printf("[ERROR] list-next command with %v", err)
+		s.writeError(conn, cmd, response.StatusBadArgument, "invalid cursor", err.Error())
+		return
+	}
+
+	limit := config.Get().ListLimit()
+	if err == nil {
+		order = c.Order
+		if c.Limit > 0 {
+			limit = c.Limit
+		}
+	}
+	if len(args) >= 2 && args[1].Type == parser.TypeInt && args[1].Int > 0 {
+		limit = int(args[1].Int)
+	}
+
+	idx, generation := s.indexer.GetIndexGeneration()
+	allEntries := idx.GetAll()
+
+	s.filters.mu.RLock()
+	filtered := s.filterEntries(allEntries)
+	s.filters.mu.RUnlock()
+	filtered = withoutSelfTools(filtered, opts)
+
+	keys := s.sortEntries(filtered, order)
+
+	offset := 0
+	if err == nil && c.Generation != generation {
+		err = fmt.Errorf("%w: index generation %d, now %d", errInvalidCursor, c.Generation, generation)
+	}
+	if err == nil {
+		if last, ok := idx.Get(c.ID); ok {
+			// The name is that of the entry, rank and pinning as they were on the page
+			after := s.sortKeys([]*indexer.Entry{last}, order)[last.ID]
+			after.pinned, after.rank = c.Pinned, c.Rank
+			offset = sort.Search(len(filtered), func(i int) bool {
+				return compareSortKeys(keys[filtered[i].ID], after) > 0
+			})
+		} else {
+			err = fmt.Errorf("%w: entry %d is gone", errInvalidCursor, c.ID)
+		}
+	}
+	if err != nil {
+		log.Printf("[DEBUG] Restarting list, %v", err)
+	}
+
+	opts.order = order
+	shown := s.writePage(conn, listPage{
+		entries:    filtered,
+		keys:       keys,
+		total:      len(allEntries),
+		revision:   idx.MaxRevision(),
+		offset:     offset,
+		limit:      limit,
+		opts:       opts,
+		generation: generation,
+		restarted:  err != nil,
+	})
+	log.Printf("[DEBUG] list-next response sent (cursor, offset: %d, limit: %d, shown: %d)", offset, limit, shown)
+}
+
+// listPage is a page of a sorted list, as list-next replies with it
+type listPage struct {
+	entries    []*indexer.Entry // the whole list, sorted
+	keys       map[int64]sortKey
+	total      int    // entries in the index before filtering
+	revision   uint64 // highest revision of the index
+	offset     int
+	limit      int
+	opts       listOptions
+	generation uint64
+	restarted  bool // the cursor of the request was invalid, this is the first page
+}
+
+// writePage writes the entries of page from its offset and returns how many were written.
+// With more entries left the reply has both the offset and the cursor of the next page. An
+// offset past the end, e.g. of a list that shrank since the previous page, gives an empty
+// page rather than an error.
+func (s *session) writePage(conn net.Conn, page listPage) int {
+	fullLen := len(page.entries)
+	start := min(page.offset, fullLen)
+	end := fullLen
+	if page.limit > 0 {
+		end = min(start+page.limit, fullLen)
+	}
+
+	resp := response.New().
+		Setf("len", "%d", fullLen).
+		Setf("list-len", "%d", end-start).
+		Setf("total", "%d", page.total).
+		Setf("revision", "%d", page.revision).
+		Set("sort", s.appliedOrder(page.opts.order))
+	s.setUpstreamAttr(resp)
+	s.setTruncatedAttr(resp)
+	if page.restarted {
+		resp.SetBool("cursor-invalid", true)
+	}
+	resp.Setf("limited", "%d", page.limit).
+		Setf("offset", "%d", page.offset).
+		Setf("pages", "%d", pageCount(fullLen, page.limit))
+
+	// If there are more entries, add list-next header
+	if end < fullLen {
+		resp.Setf("list-next", "%d %d", end, page.limit)
+		last := page.keys[page.entries[end-1].ID]
+		resp.Set("cursor", newCursor(page.generation, page.opts.order, page.limit, last).String())
+	}
+
+	resp.Body()
+	runs := s.listRuns(page.entries[start:end], page.opts)
+	for _, entry := range page.entries[start:end] {
+		resp.Body(s.listLine(entry, page.opts, runs))
+	}
+
+	s.writeResponse(conn, resp)
+	return end - start
+}
+
+// pageCount returns the number of pages of limit entries a list of n entries takes, a
+// single one without a limit
+func pageCount(n, limit int) int {
+	if limit <= 0 {
+		return min(n, 1)
+	}
+	return (n + limit - 1) / limit
+}
+
+func (s *session) handleInfo(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling info command")
+
+	if len(cmd.Args) == 0 || !isEntryRef(cmd.Args[0]) {
+		log.Printf("[ERROR] Info command missing id parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing id", "info command requires an id or key parameter")
+		return
+	}
+
+	entry, ok := s.lookupEntry(cmd.Args[0])
+	if !ok {
+		log.Printf("[ERROR] Entry %s not found", entryRef(cmd.Args[0]))
+		s.writeError(conn, cmd, response.StatusNotFound, "index not found", "Can't describe application, requested index not found.")
+		return
+	}
+
+	pinned := isPinned(s.pins(), entry)
+
+	resp := response.OK("info").
+		Setf("idx", "%d", entry.ID).
+		Set("key", entry.Key).
+		Setf("revision", "%d", entry.Revision).
+		Set("name", s.localizedName(entry))
+	if entry.RawName != "" {
+		resp.Set("raw-name", entry.RawName)
+	}
+	resp.Set("path", entry.Path).
+		Set("exec", entry.Exec).
+		SetBool("terminal", entry.Terminal).
+		SetBool("desktop", entry.IsDesktop).
+		Set("categories", strings.Join(entry.Categories, ";"))
+	if len(entry.Actions) > 0 {
+		keys := make([]string, len(entry.Actions))
+		for i, action := range entry.Actions {
+			keys[i] = action.Key
+		}
+		resp.Set("actions", strings.Join(keys, ";"))
+	}
+	resp.SetBool("pinned", pinned)
+	if entry.Origin != "" {
+		resp.Set("origin", entry.Origin)
+	}
+	if entry.SelfTool {
+		resp.SetBool("self-tool", true)
+	}
+	s.writeResponse(conn, resp)
+}
+
+func (s *Server) handleLangList(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling lang-list command")
+
+	if len(cmd.Args) == 0 || !isEntryRef(cmd.Args[0]) {
+		log.Printf("[ERROR] Lang-list command missing id parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing id", "lang-list command requires an id or key parameter")
+		return
+	}
+
+	entry, ok := s.lookupEntry(cmd.Args[0])
+	if !ok {
+		log.Printf("[ERROR] Entry %s not found", entryRef(cmd.Args[0]))
+		s.writeError(conn, cmd, response.StatusNotFound, "not found", "Can't list languages, requested index not found.")
+		return
+	}
+	id := entry.ID
+
+	locales := entryLocales(entry)
+
+	resp := response.OK("lang-list").Setf("idx", "%d", id).Setf("len", "%d", len(locales)).Body(locales...)
+	s.writeResponse(conn, resp)
+}
+
+func (s *session) handleWhich(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling which command")
+
+	if len(cmd.Args) == 0 || (cmd.Args[0].Type != parser.TypeString && cmd.Args[0].Type != parser.TypeInt) {
+		log.Printf("[ERROR] Which command missing path or pid parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing parameter", "which command requires a path or pid parameter")
+		return
+	}
+
+	idx := s.indexer.GetIndex()
+	var entry *indexer.Entry
+	var target string
+
+	if cmd.Args[0].Type == parser.TypeInt {
+		pid := int(cmd.Args[0].Int)
+		// Launches made by the daemon itself are known even if the binary is a wrapper script
+		if proc, ok := s.launcher.Get(pid); ok {
+			entry, _ = idx.Get(proc.EntryID)
+		}
+		if entry == nil {
+			exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+			if err != nil {
+				log.Printf("[ERROR] Can't read executable of pid %d: %v", pid, err)
+				s.writeError(conn, cmd, response.StatusNotFound, "not found", fmt.Sprintf("no accessible process with pid %d", pid))
+				return
+			}
+			target = canonicalPath(exe)
+		}
+	} else {
+		path := s.expandPath(cmd.Args[0].Str)
+		if !strings.Contains(path, "/") {
+			// Bare program name: resolve like the shell would
+			path = indexer.ResolveExec(path)
+		}
+		if path != "" {
+			target = canonicalPath(path)
+			if s.inspectWrappers {
+				if wrapped := indexer.WrapperTarget(target); wrapped != "" {
+					target = wrapped
+				}
+			}
+		}
+	}
+
+	if entry == nil && target != "" {
+		entry = findByExecutable(idx.GetAll(), target)
+	}
+	if entry == nil {
+		log.Printf("[DEBUG] No entry found for %s", redact.String(target))
+		s.writeError(conn, cmd, response.StatusNotFound, "not found", "No indexed application matches the requested path or pid.")
+		return
+	}
+
+	resp := response.OK("which").Setf("idx", "%d", entry.ID).Set("name", s.localizedName(entry))
+	if entry.RawName != "" {
+		resp.Set("raw-name", entry.RawName)
+	}
+	if entry.DesktopID != "" {
+		resp.Set("desktop-id", entry.DesktopID)
+	}
+	s.writeResponse(conn, resp)
+}
+
+// handleHandler answers which entry opens a URL scheme or a MIME type: the default of the
+// mimeapps.list files if the index holds it, else the first entry registered for it
+func (s *session) handleHandler(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling handler command")
+
+	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeString || strings.TrimSpace(cmd.Args[0].Str) == "" {
+		log.Printf("[ERROR] Handler command missing scheme or MIME type parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing parameter", "handler command requires a URL scheme or MIME type parameter")
+		return
+	}
+	mime := desktop.SchemeMime(cmd.Args[0].Str)
+
+	entries := s.indexer.GetIndex().GetAll()
+	slices.SortFunc(entries, func(a, b *indexer.Entry) int { return cmp.Compare(a.ID, b.ID) })
+	entry, isDefault := findHandler(entries, mime, desktop.DefaultApps(desktop.MimeAppsPaths(), mime))
+	if entry == nil {
+		log.Printf("[DEBUG] No entry handles %s", mime)
+		s.writeError(conn, cmd, response.StatusNotFound, "not found", fmt.Sprintf("No indexed application handles %s.", mime))
+		return
+	}
+
+	resp := response.OK("handler").
+		Setf("idx", "%d", entry.ID).
+		Set("mime", mime).
+		Set("name", s.localizedName(entry)).
+		Set("desktop-id", entry.DesktopID).
+		SetBool("default", isDefault).
+		Set("takes", execTakes(entry.Exec))
+	s.writeResponse(conn, resp)
+}
+
+// findHandler returns the entry to open mime with among entries, in ID order: that of the
+// first of the desktop file IDs of defaults found, else the first desktop entry listing mime.
+// IDs follow the search order, so the user's desktop files come before the system's. Reports
+// whether the entry is a default.
+func findHandler(entries []*indexer.Entry, mime string, defaults []string) (*indexer.Entry, bool) {
+	for _, id := range defaults {
+		for _, entry := range entries {
+			if entry.IsDesktop && entry.DesktopID == id {
+				return entry, true
+			}
+		}
+	}
+	for _, entry := range entries {
+		if entry.IsDesktop && slices.ContainsFunc(entry.MimeTypes, func(m string) bool { return strings.EqualFold(m, mime) }) {
+			return entry, false
+		}
+	}
+	return nil, false
+}
+
+// execTakes tells what the field codes of exec let a handler be passed: "url", "urls",
+// "file", "files" or "none"
+func execTakes(exec string) string {
+	switch desktop.ExecFieldCode(exec) {
+	case 'u':
+		return "url"
+	case 'U':
+		return "urls"
+	case 'f':
+		return "file"
+	case 'F':
+		return "files"
+	}
+	return "none"
+}
+
+// Reasons an entry can't be launched, see brokenExec
+const (
+	brokenNoExec        = "no-exec"        // the entry names no program
+	brokenMissing       = "missing"        // the program is neither a file nor found in PATH
+	brokenNotExecutable = "not-executable" // the program is a file, but can't be executed
+)
+
+// handleBroken lists the entries of the current filter set whose program can't be launched
+// as things are now: the binary was uninstalled, a desktop file names one that never was,
+// or it lost its executable bit. It changes nothing.
+func (s *session) handleBroken(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling broken command")
+
+	s.filters.mu.RLock()
+	entries := s.filterEntries(s.indexer.GetIndex().GetAll())
+	s.filters.mu.RUnlock()
+	slices.SortFunc(entries, func(a, b *indexer.Entry) int { return cmp.Compare(a.ID, b.ID) })
+
+	var lines []string
+	for _, entry := range entries {
+		program, reason := brokenExec(entry)
+		if reason == "" {
+			continue
+		}
+		if program == "" {
+			program = "-"
+		}
+		lines = append(lines, fmt.Sprintf("%d %s %s %s", entry.ID, reason, program, s.localizedName(entry)))
+	}
+	log.Printf("[DEBUG] Found %d broken entries of %d", len(lines), len(entries))
+
+	resp := response.OK("broken").Setf("checked", "%d", len(entries)).Setf("len", "%d", len(lines)).Body(lines...)
+	s.writeResponse(conn, resp)
+}
+
+// brokenExec returns the program entry launches and why it can't be, "" if it can. The
+// program is looked up again like run does, the binary resolved at indexing may be gone.
+func brokenExec(entry *indexer.Entry) (program, reason string) {
+	program = entry.Exec
+	if entry.IsDesktop {
+		program = desktop.ExecBinary(entry.Exec)
+	}
+	switch {
+	case program == "":
+		return "", brokenNoExec
+	case indexer.ResolveExec(program) != "":
+		return program, ""
+	}
+	// Bare names are searched in PATH, where files that aren't executable are skipped
+	if !strings.Contains(program, "/") {
+		return program, brokenMissing
+	}
+	if _, err := os.Stat(program); err != nil {
+		return program, brokenMissing
+	}
+	return program, brokenNotExecutable
+}
+
+// findByExecutable returns the entry launching the resolved path target (for executables
+// that is their own resolved path). Desktop entries are preferred over bare executables
+// as they carry more metadata; ties are broken by the lowest id so the answer is stable.
+func findByExecutable(entries []*indexer.Entry, target string) *indexer.Entry {
+	var found *indexer.Entry
+	for _, entry := range entries {
+		if entry.ResolvedExec == "" || entry.ResolvedExec != target {
+			continue
+		}
+		if found == nil ||
+			(entry.IsDesktop && !found.IsDesktop) ||
+			(entry.IsDesktop == found.IsDesktop && entry.ID < found.ID) {
+			found = entry
+		}
+	}
+	return found
+}
+
+func (s *session) handleLang(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling lang command")
+	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeString {
+		log.Printf("[WARN] Lang command missing string parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing parameter", "lang command requires a string parameter")
+		return
+	}
+	s.lang = cmd.Args[0].Str
+	log.Printf("[DEBUG] Language set to: %s", redact.String(s.lang))
+
+	// Send success response
+	s.writeResponse(conn, response.OK("lang").Set("lang", s.lang))
+}
+
+func (s *session) handleSort(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling sort command")
+	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeString {
+		log.Printf("[WARN] Sort command missing string parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing parameter", "sort command requires a string parameter")
+		return
+	}
+	order, err := parseSortOrder(cmd.Args[0].Str)
+	if err != nil {
+		log.Printf("[ERROR] Sort command with %s", redact.String(err.Error()))
+		s.writeError(conn, cmd, response.StatusBadArgument, "invalid sort", err.Error())
+		return
+	}
+	s.sortOrder = order
+	log.Printf("[DEBUG] Sort order set to: %s", order)
+
+	s.writeResponse(conn, response.OK("sort").Set("sort", s.appliedOrder(order)))
+}
+
+// Orders lists can be sorted in
+const (
+	sortFreq  = "freq"  // run frequency, most run first
+	sortName  = "name"  // localized name, case-insensitive
+	sortMtime = "mtime" // modification time of the file, newest first
+)
+
+// sortOpt is the prefix of the list and list-next option overriding the default order
+const sortOpt = "sort: "
+
+// parseSortOrder parses a sort order name, an empty string means freq
+func parseSortOrder(s string) (string, error) {
+	switch order := strings.ToLower(strings.TrimSpace(s)); order {
+	case "":
+		return sortFreq, nil
+	case sortFreq, sortName, sortMtime:
+		return order, nil
+	default:
+		return "", fmt.Errorf("unknown sort order %q, expected freq, name or mtime", s)
+	}
+}
+
+// revisionsOpt is the list and list-next option adding the revision of every entry to its line
+const revisionsOpt = "opt: revisions"
+
+// runsOpt is the list and list-next option adding the run count of every entry to its line
+const runsOpt = "opt: runs"
+
+// selfToolsOpt is the list and list-next option keeping the entries of the daemon's own
+// binary and its companions, see indexer.Entry.SelfTool
+const selfToolsOpt = "opt: self-tools"
+
+// listOptions are the options of list and list-next
+type listOptions struct {
+	order     string
+	revisions bool // lines are "<id> <revision> <name>"
+	runs      bool // lines have the run count before the name
+	selfTools bool // entries tagged SelfTool are listed
+}
+
+// listOpts returns the options given by the leading "sort: <order>", "opt: revisions",
+// "opt: runs" and "opt: self-tools" arguments, in any order, with the default order when
+// none is asked for, and the remaining arguments. Errors are written to conn.
+func (s *session) listOpts(conn net.Conn, cmd *parser.Command) (listOptions, []parser.Value, bool) {
+	opts := listOptions{order: s.sortOrder}
+	args := cmd.Args
+	for len(args) > 0 && args[0].Type == parser.TypeString {
+		if args[0].Str == revisionsOpt {
+			opts.revisions = true
+		} else if args[0].Str == runsOpt {
+			opts.runs = true
+		} else if args[0].Str == selfToolsOpt {
+			opts.selfTools = true
+		} else if value, ok := strings.CutPrefix(args[0].Str, sortOpt); ok {
+			order, err := parseSortOrder(value)
+			if err != nil {
+				log.Printf("[ERROR] %s command with %s", cmd.Name, redact.String(err.Error()))
+				s.writeError(conn, cmd, response.StatusBadArgument, "invalid sort", err.Error())
+				return listOptions{}, nil, false
+			}
+			opts.order = order
+		} else {
+			break
+		}
+		args = args[1:]
+	}
+	return opts, args, true
+}
+
+// withoutSelfTools drops the entries tagged SelfTool from the filtered entries of a list,
+// unless opts keeps them: launching the daemon from its own list is rarely meant
+func withoutSelfTools(filtered []*indexer.Entry, opts listOptions) []*indexer.Entry {
+	if opts.selfTools {
+		return filtered
+	}
+	return slices.DeleteFunc(filtered, func(entry *indexer.Entry) bool { return entry.SelfTool })
+}
+
+// listLine returns the body line of entry in a list, runs are the run counts of listRuns
+func (s *session) listLine(entry *indexer.Entry, opts listOptions, runs map[string]uint64) string {
+	line := strconv.FormatInt(entry.ID, 10)
+	if opts.revisions {
+		line += " " + strconv.FormatUint(entry.Revision, 10)
+	}
+	if opts.runs {
+		line += " " + strconv.FormatUint(runs[entry.Path], 10)
+	}
+	return line + " " + s.localizedName(entry)
+}
+
+// listRuns returns the run counts of the entries of a page by path when opts asks for them.
+// Without a run index no entry was ever run.
+func (s *Server) listRuns(entries []*indexer.Entry, opts listOptions) map[string]uint64 {
+	if !opts.runs || s.runIndex == nil {
+		return nil
+	}
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		paths[i] = entry.Path
+	}
+	return s.runIndex.GetFrequencies(paths)
+}
+
+// sortKey is what entries are sorted by: pinned entries go first, then those of higher rank,
+// then by name and last by ID. The rank is the run count or the modification time,
+// depending on the order, and stays zero when sorting by name. Every order breaks ties the
+// same way, so entries never used keep their places from one list to the next. Pins come
+// before the rank, not after it: a pinned entry is on top however rarely it's run.
+type sortKey struct {
+	pinned bool
+	rank   int64
+	name   string // collation key of the localized name, see nameCollator
+	id     int64
+}
+
+// nameCollator returns the collator of the names of lists in the language of the
+// connection, ignoring case. Names sort alphabetically in that language, e.g. accented
+// letters next to the letters they're based on rather than after z.
+func (s *session) nameCollator() *collate.Collator {
+	return collate.New(language.Make(s.lang), collate.IgnoreCase)
+}
+
+// compareSortKeys returns a negative number when a goes before b
+func compareSortKeys(a, b sortKey) int {
+	if a.pinned != b.pinned {
+		if a.pinned {
+			return -1
+		}
+		return 1
+	}
+	return cmp.Or(
+		cmp.Compare(b.rank, a.rank),
+		strings.Compare(a.name, b.name),
+		cmp.Compare(a.id, b.id),
+	)
+}
+
+// sortEntries sorts entries in the given order, by run frequency if it's empty, and returns
+// their sort keys by ID. Pinned entries go before all others whatever the order, and ties
+// are broken by name, then by ID. Without a run index there are no frequencies, those lists
+// are sorted by name instead.
+func (s *session) sortEntries(entries []*indexer.Entry, order string) map[int64]sortKey {
+	keys := s.sortKeys(entries, order)
+	sort.Slice(entries, func(i, j int) bool {
+		return compareSortKeys(keys[entries[i].ID], keys[entries[j].ID]) < 0
+	})
+	return keys
+}
+
+// appliedOrder returns the order sortEntries sorts in when asked for order: freq when it's
+// empty, and name instead of freq without a run index
+func (s *Server) appliedOrder(order string) string {
+	switch {
+	case s.runIndex == nil && order != sortMtime:
+		return sortName
+	case order == "":
+		return sortFreq
+	}
+	return order
+}
+
+// sortKeys returns the sort keys of entries by ID
+func (s *session) sortKeys(entries []*indexer.Entry, order string) map[int64]sortKey {
+	order = s.appliedOrder(order)
+
+	keys := make(map[int64]sortKey, len(entries))
+	pins := s.pins()
+	collator := s.nameCollator()
+	var buf collate.Buffer
+	for _, entry := range entries {
+		keys[entry.ID] = sortKey{
+			pinned: len(pins) > 0 && isPinned(pins, entry),
+			name:   string(collator.KeyFromString(&buf, s.localizedName(entry))),
+			id:     entry.ID,
+		}
+		buf.Reset()
+	}
+
+	switch order {
+	case sortName:
+		// The name is all there is
+	case sortMtime:
+		for _, entry := range entries {
+			key := keys[entry.ID]
+			key.rank = mtimeRank(entry.ModTime) // Newest first
+			keys[entry.ID] = key
+		}
+	default:
+		// Collect all paths for batch frequency lookup
+		paths := make([]string, len(entries))
+		for i, entry := range entries {
+			paths[i] = entry.Path
+		}
+		frequencies := s.runIndex.GetFrequencies(paths)
+		for _, entry := range entries {
+			key := keys[entry.ID]
+			key.rank = int64(frequencies[entry.Path]) // Higher frequency first
+			keys[entry.ID] = key
+		}
+	}
+	return keys
+}
+
+// mtimeRank returns the rank of a modification time, files without one are the oldest
+func mtimeRank(t time.Time) int64 {
+	if t.IsZero() {
+		return math.MinInt64
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,540 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("list handlers", func() {
+	var (
+		srv   *session
+		files int64
+	)
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		index := srv.indexer.GetIndex()
+		files = index.Add(&indexer.Entry{
+			Name:  "Files",
+			Names: map[string]string{"de": "Dateien", "pt_BR": "Arquivos"},
+			Path:  "/usr/share/applications/files.desktop",
+		})
+		for i := range 4 {
+			index.Add(&indexer.Entry{Name: fmt.Sprintf("tool%d", i), Path: fmt.Sprintf("/usr/bin/tool%d", i)})
+		}
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+	})
+
+	send := func(lines ...string) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+		return buf.String()
+	}
+
+	bodyOf := func(reply string) []string {
+		_, body, ok := strings.Cut(reply, "body:\n")
+		Expect(ok).To(BeTrue(), reply)
+		return strings.Split(strings.TrimSuffix(body, "\n\n\n"), "\n")
+	}
+
+	Context("list", func() {
+		It("should return every entry with the total count", func() {
+			reply := send("list")
+			Expect(reply).To(HavePrefix("TXT01len: 5\nlist-len: 5\ntotal: 5\nrevision: 1\nsort: freq\n\nbody:\n"))
+			Expect(bodyOf(reply)).To(HaveLen(5))
+			Expect(bodyOf(reply)).To(ContainElement(itoa(files) + " Files"))
+		})
+
+		It("should name entries in the language set, falling back to the default name", func() {
+			Expect(send(`"de`, "lang")).To(Equal("TXT01cmd: lang\nstatus: 0\nlang: de\n\n\n"))
+			Expect(bodyOf(send("list"))).To(ContainElement(itoa(files) + " Dateien"))
+
+			send(`"pt_BR`, "lang")
+			Expect(bodyOf(send("list"))).To(ContainElement(itoa(files) + " Arquivos"))
+
+			send(`"fr`, "lang")
+			Expect(bodyOf(send("list"))).To(ContainElement(itoa(files) + " Files"))
+		})
+
+		It("should tell the order applied", func() {
+			Expect(attrLine(send(`"sort: mtime`, "list"), "sort")).To(Equal("mtime"))
+			Expect(attrLine(send(`"sort: NAME`, "0", "1", "list-next"), "sort")).To(Equal("name"))
+			cursor := attrLine(send(`"sort: mtime`, "0", "1", "list-next"), "cursor")
+			// A cursor keeps the order it was made for
+			Expect(attrLine(send(`"`+cursor, "list-next"), "sort")).To(Equal("mtime"))
+
+			// Without run counts, lists asked to be sorted by frequency are sorted by name
+			runIndex := srv.runIndex
+			srv.runIndex = nil
+			byFreq, byMtime := attrLine(send("list"), "sort"), attrLine(send(`"sort: mtime`, "list"), "sort")
+			srv.runIndex = runIndex
+			Expect(byFreq).To(Equal("name"))
+			Expect(byMtime).To(Equal("mtime"))
+		})
+
+		It("should cap the body to the list limit and tell how many pages there are", func() {
+			limit := config.Get().ListLimit()
+			config.Get().SetListLimit(3)
+			DeferCleanup(config.Get().SetListLimit, limit)
+			for i := range 6 {
+				srv.indexer.GetIndex().Add(&indexer.Entry{Name: fmt.Sprintf("extra%d", i), Path: fmt.Sprintf("/usr/bin/extra%d", i)})
+			}
+
+			reply := send("list")
+			Expect(bodyOf(reply)).To(HaveLen(3))
+			Expect(reply).To(HavePrefix("TXT01len: 11\nlist-len: 3\ntotal: 11\n"))
+			Expect(reply).To(ContainSubstring("truncated: t\nlimited: 3\noffset: 0\npages: 4\nlist-next: 3 3\n"))
+
+			reply = send("9", "list-next")
+			Expect(bodyOf(reply)).To(HaveLen(2))
+			Expect(attrLine(reply, "list-len")).To(Equal("2"))
+			Expect(attrLine(reply, "pages")).To(Equal("4"))
+		})
+
+		It("should list everything with a list limit of 0", func() {
+			limit := config.Get().ListLimit()
+			config.Get().SetListLimit(0)
+			DeferCleanup(config.Get().SetListLimit, limit)
+			for i := range 200 {
+				srv.indexer.GetIndex().Add(&indexer.Entry{Name: fmt.Sprintf("extra%03d", i), Path: fmt.Sprintf("/usr/bin/extra%03d", i)})
+			}
+
+			reply := send("list")
+			Expect(bodyOf(reply)).To(HaveLen(205))
+			Expect(reply).To(HavePrefix("TXT01len: 205\nlist-len: 205\ntotal: 205\n"))
+			Expect(reply).NotTo(ContainSubstring("limited:"))
+			Expect(reply).NotTo(ContainSubstring("truncated:"))
+			Expect(attrLine(reply, "cursor")).To(BeEmpty())
+
+			reply = send("200", "list-next")
+			Expect(bodyOf(reply)).To(HaveLen(5))
+			Expect(reply).To(ContainSubstring("list-len: 5\n"))
+			Expect(reply).To(ContainSubstring("limited: 0\noffset: 200\npages: 1\n\nbody:\n"))
+		})
+
+		It("should say when the index was never built", func() {
+			srv = newTestServer(GinkgoT().TempDir())
+			srv.autoIndexing.Store(true) // as if the cold start reindex was running already
+			Expect(send("list")).To(HavePrefix("TXT01len: 0\nlist-len: 0\ntotal: 0\nrevision: 0\nsort: freq\nindexing: t\npartial: t\n"))
+		})
+	})
+
+	Context("list-next", func() {
+		It("should page through the entries", func() {
+			reply := send("0", "2", "list-next")
+			Expect(reply).To(HavePrefix("TXT01len: 5\nlist-len: 2\ntotal: 5\nrevision: 1\nsort: freq\nlimited: 2\noffset: 0\npages: 3\nlist-next: 2 2\ncursor: "))
+			Expect(bodyOf(reply)).To(HaveLen(2))
+
+			reply = send("4", "2", "list-next")
+			Expect(reply).To(HavePrefix("TXT01len: 5\nlist-len: 1\ntotal: 5\nrevision: 1\nsort: freq\nlimited: 2\noffset: 4\npages: 3\n\nbody:\n"))
+			Expect(bodyOf(reply)).To(HaveLen(1))
+		})
+
+		It("should use the list limit without a limit", func() {
+			Expect(send("1", "list-next")).To(ContainSubstring(fmt.Sprintf("limited: %d\n", config.Get().ListLimit())))
+		})
+
+		It("should give the filtered and the total count alike to list", func() {
+			send(`"tool`, "filter-name")
+			Expect(send("list")).To(HavePrefix("TXT01len: 4\nlist-len: 4\ntotal: 5\nrevision: 1\nsort: freq\n"))
+			Expect(send("1", "2", "list-next")).To(HavePrefix("TXT01len: 4\nlist-len: 2\ntotal: 5\nrevision: 1\nsort: freq\nlimited: 2\noffset: 1\n"))
+		})
+
+		It("should add the revision of every entry with opt: revisions, before or after sort", func() {
+			// Changed once since it was first indexed
+			manager := srv.indexer.GetIndex().Add(&indexer.Entry{Name: "File Manager", Path: "/usr/share/applications/manager.desktop", Revision: 2})
+
+			reply := send(`"opt: revisions`, "list")
+			Expect(reply).To(HavePrefix("TXT01len: 6\nlist-len: 6\ntotal: 6\nrevision: 2\nsort: freq\n"))
+			Expect(bodyOf(reply)).To(ContainElement(itoa(manager) + " 2 File Manager"))
+			Expect(bodyOf(reply)).To(ContainElement(MatchRegexp(`^\d+ 1 tool0$`)))
+
+			reply = send(`"sort: name`, `"opt: revisions`, "0", "1", "list-next")
+			Expect(bodyOf(reply)).To(Equal([]string{itoa(manager) + " 2 File Manager"}))
+			cursor := attrLine(reply, "cursor")
+			reply = send(`"opt: revisions`, `"`+cursor, "list-next")
+			Expect(bodyOf(reply)).To(Equal([]string{itoa(files) + " 1 Files"}))
+			// Without the option, lines stay as they were
+			Expect(bodyOf(send(`"`+cursor, "list-next"))).To(Equal([]string{itoa(files) + " Files"}))
+		})
+
+		It("should reject a missing or negative offset", func() {
+			Expect(send("list-next")).To(ContainSubstring("error: missing offset\n"))
+			Expect(send("-1", "list-next")).To(ContainSubstring("error: invalid offset\n"))
+		})
+
+		It("should answer an offset past the end with an empty page", func() {
+			for _, offset := range []string{"5", "9"} {
+				Expect(send(offset, "2", "list-next")).To(Equal("TXT01len: 5\nlist-len: 0\ntotal: 5\nrevision: 1\nsort: freq\n" +
+					"limited: 2\noffset: " + offset + "\npages: 3\n\nbody:\n\n\n"))
+			}
+		})
+
+		It("should count the pages at the limit", func() {
+			Expect(attrLine(send("0", "5", "list-next"), "pages")).To(Equal("1"))
+			Expect(attrLine(send("0", "4", "list-next"), "pages")).To(Equal("2"))
+			Expect(attrLine(send("0", "1", "list-next"), "pages")).To(Equal("5"))
+			send(`"nothing matches`, "filter-name")
+			Expect(attrLine(send("0", "2", "list-next"), "pages")).To(Equal("0"))
+		})
+	})
+
+	Context("sort", func() {
+		var ids map[string]int64
+
+		BeforeEach(func() {
+			srv = newTestServer(GinkgoT().TempDir())
+			ids = make(map[string]int64)
+			base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+			for i, name := range []string{"beta", "Alpha", "gamma"} {
+				ids[name] = srv.indexer.GetIndex().Add(&indexer.Entry{
+					Name:    name,
+					Path:    "/usr/bin/" + name,
+					ModTime: base.Add(time.Duration(i) * time.Hour),
+				})
+			}
+			for range 3 {
+				Expect(srv.runIndex.Increment("/usr/bin/beta")).To(Succeed())
+			}
+			Expect(srv.runIndex.Increment("/usr/bin/Alpha")).To(Succeed())
+		})
+
+		listed := func(reply string) []string {
+			var names []string
+			for _, line := range bodyOf(reply) {
+				names = append(names, strings.Fields(line)[1])
+			}
+			return names
+		}
+
+		It("should sort by run frequency by default", func() {
+			Expect(listed(send("list"))).To(Equal([]string{"beta", "Alpha", "gamma"}))
+		})
+
+		It("should use the configured order without a sort option", func() {
+			srv.sortOrder = sortName
+			Expect(listed(send("list"))).To(Equal([]string{"Alpha", "beta", "gamma"}))
+			Expect(listed(send("0", "2", "list-next"))).To(Equal([]string{"Alpha", "beta"}))
+			Expect(listed(send("2", "2", "list-next"))).To(Equal([]string{"gamma"}))
+
+			srv.sortOrder = sortMtime
+			Expect(listed(send("list"))).To(Equal([]string{"gamma", "Alpha", "beta"}))
+		})
+
+		It("should let the request override the configured order", func() {
+			srv.sortOrder = sortName
+			Expect(listed(send(`"sort: freq`, "list"))).To(Equal([]string{"beta", "Alpha", "gamma"}))
+			Expect(listed(send(`"sort: mtime`, "1", "1", "list-next"))).To(Equal([]string{"Alpha"}))
+		})
+
+		It("should keep pinned entries first in every order", func() {
+			Expect(srv.runIndex.SetPinned("/usr/bin/gamma", true)).To(Succeed())
+			for _, order := range []string{sortFreq, sortName, sortMtime} {
+				Expect(listed(send(`"sort: `+order, "list"))[0]).To(Equal("gamma"), order)
+			}
+		})
+
+		It("should break ties by name, then by ID, the same on every call", func() {
+			// Added in an order unlike the names, two of them named alike
+			for _, name := range []string{"zeta", "Delta", "epsilon", "delta"} {
+				ids[name] = srv.indexer.GetIndex().Add(&indexer.Entry{Name: name, Path: "/opt/bin/" + name})
+			}
+			expected := []string{"beta", "Alpha", "Delta", "delta", "epsilon", "gamma", "zeta"}
+			for range 5 {
+				Expect(listed(send("list"))).To(Equal(expected))
+			}
+			Expect(bodyOf(send("list"))[2:4]).To(Equal([]string{itoa(ids["Delta"]) + " Delta", itoa(ids["delta"]) + " delta"}))
+		})
+
+		It("should sort names alphabetically beyond ASCII", func() {
+			for _, name := range []string{"Zebra", "Éditeur", "Ångström", "echo", "Œuvre"} {
+				srv.indexer.GetIndex().Add(&indexer.Entry{Name: name, Path: "/opt/bin/" + name})
+			}
+			Expect(listed(send(`"sort: name`, "list"))).To(Equal([]string{
+				"Alpha", "Ångström", "beta", "echo", "Éditeur", "gamma", "Œuvre", "Zebra",
+			}))
+		})
+
+		It("should reject an unknown order", func() {
+			reply := send(`"sort: random`, "list")
+			Expect(reply).To(ContainSubstring("error: invalid sort\n"))
+			Expect(reply).To(ContainSubstring("status: 2\n"))
+		})
+
+		It("should set the order of later lists with the sort command", func() {
+			Expect(send(`"name`, "sort")).To(Equal("TXT01cmd: sort\nstatus: 0\nsort: name\n\n\n"))
+			Expect(listed(send("list"))).To(Equal([]string{"Alpha", "beta", "gamma"}))
+			Expect(listed(send(`"sort: freq`, "list"))).To(Equal([]string{"beta", "Alpha", "gamma"}))
+
+			Expect(send(`"freq`, "sort")).To(ContainSubstring("sort: freq\n"))
+			Expect(listed(send("list"))).To(Equal([]string{"beta", "Alpha", "gamma"}))
+		})
+
+		It("should keep the order on a missing or unknown one", func() {
+			srv.sortOrder = sortMtime
+			Expect(send("sort")).To(ContainSubstring("error: missing parameter\n"))
+			reply := send(`"random`, "sort")
+			Expect(reply).To(ContainSubstring("error: invalid sort\n"))
+			Expect(reply).To(ContainSubstring("status: 2\n"))
+			Expect(srv.sortOrder).To(Equal(sortMtime))
+		})
+
+		It("should give the run count of every entry with opt: runs", func() {
+			Expect(bodyOf(send(`"opt: runs`, "list"))).To(Equal([]string{
+				itoa(ids["beta"]) + " 3 beta",
+				itoa(ids["Alpha"]) + " 1 Alpha",
+				itoa(ids["gamma"]) + " 0 gamma",
+			}))
+			Expect(bodyOf(send(`"opt: runs`, `"opt: revisions`, `"sort: name`, "0", "1", "list-next"))).To(Equal([]string{
+				itoa(ids["Alpha"]) + " 1 1 Alpha",
+			}))
+		})
+	})
+
+	Context("cursors", func() {
+		names := func(reply string) []string {
+			var names []string
+			for _, line := range bodyOf(reply) {
+				names = append(names, strings.Fields(line)[1])
+			}
+			return names
+		}
+
+		It("should resume after the last entry where offsets drift", func() {
+			first := send(`"sort: name`, "0", "2", "list-next")
+			Expect(names(first)).To(Equal([]string{"Files", "tool0"}))
+			cursor := attrLine(first, "cursor")
+			Expect(cursor).NotTo(BeEmpty())
+
+			// Sorts before the page boundary, shifting the entries after it
+			srv.indexer.GetIndex().Add(&indexer.Entry{Name: "Editor", Path: "/usr/bin/editor"})
+
+			Expect(names(send(`"sort: name`, "2", "2", "list-next"))).To(Equal([]string{"tool0", "tool1"}))
+
+			// The cursor carries the order and the page size
+			reply := send(`"`+cursor, "list-next")
+			Expect(reply).NotTo(ContainSubstring("cursor-invalid"))
+			Expect(reply).To(ContainSubstring("offset: 3\npages: 3\nlist-next: 5 2\n"))
+			Expect(names(reply)).To(Equal([]string{"tool1", "tool2"}))
+
+			reply = send(`"`+attrLine(reply, "cursor"), "list-next")
+			Expect(names(reply)).To(Equal([]string{"tool3"}))
+			Expect(attrLine(reply, "cursor")).To(BeEmpty())
+		})
+
+		It("should hand out cursors with list and take a page size", func() {
+			for i := range config.Get().ListLimit() {
+				srv.indexer.GetIndex().Add(&indexer.Entry{Name: fmt.Sprintf("extra%03d", i), Path: fmt.Sprintf("/usr/bin/extra%03d", i)})
+			}
+			first := send(`"sort: name`, "list")
+			cursor := attrLine(first, "cursor")
+			Expect(cursor).NotTo(BeEmpty())
+
+			reply := send(`"`+cursor, "3", "list-next")
+			Expect(reply).To(ContainSubstring(fmt.Sprintf("limited: 3\noffset: %d\n", config.Get().ListLimit())))
+			Expect(names(reply)).To(Equal([]string{"Files", "tool0", "tool1"}))
+		})
+
+		It("should restart from the first page once the index was rebuilt", func() {
+			cursor := attrLine(send(`"sort: name`, "0", "2", "list-next"), "cursor")
+
+			dir := GinkgoT().TempDir()
+			for _, name := range []string{"aardvark", "badger", "cheetah"} {
+				Expect(os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+			}
+			_, err := srv.indexer.Reindex(context.Background(), []string{dir})
+			Expect(err).NotTo(HaveOccurred())
+
+			reply := send(`"`+cursor, "list-next")
+			Expect(reply).To(ContainSubstring("cursor-invalid: t\nlimited: 2\noffset: 0\n"))
+			Expect(bodyOf(reply)).To(Equal(bodyOf(send(`"sort: name`, "0", "2", "list-next"))))
+		})
+
+		It("should treat tampered cursors as invalid and refuse overlong ones", func() {
+			cursor := attrLine(send(`"sort: name`, "0", "2", "list-next"), "cursor")
+			tampered := []byte(cursor)
+			tampered[2] ^= 1
+
+			for _, bad := range []string{string(tampered), cursor[:len(cursor)-1], "not a cursor", ""} {
+				reply := send(`"`+bad, "list-next")
+				Expect(reply).To(ContainSubstring("cursor-invalid: t\n"), bad)
+				Expect(reply).To(ContainSubstring("offset: 0\n"), bad)
+			}
+
+			reply := send(`"`+strings.Repeat("A", maxCursorLen+1), "list-next")
+			Expect(reply).To(ContainSubstring("error: invalid cursor\n"))
+			Expect(reply).To(ContainSubstring("status: 2\n"))
+		})
+
+		It("should round trip cursors and reject those of another version", func() {
+			c := listCursor{Generation: 7, Order: sortMtime, Limit: 20, Pinned: true, Rank: -5, ID: 1 << 40}
+			Expect(parseCursor(c.String())).To(Equal(c))
+
+			buf, err := base64.RawURLEncoding.DecodeString(c.String())
+			Expect(err).NotTo(HaveOccurred())
+			payload := buf[:len(buf)-cursorMACLen]
+			payload[0] = cursorVersion + 1
+			_, err = parseCursor(base64.RawURLEncoding.EncodeToString(append(payload, cursorMAC(payload)...)))
+			Expect(err).To(MatchError(ContainSubstring("version")))
+		})
+	})
+
+	Context("parseSortOrder", func() {
+		It("should accept the orders in any case, an empty one meaning freq", func() {
+			Expect(parseSortOrder("")).To(Equal(sortFreq))
+			Expect(parseSortOrder(" Name ")).To(Equal(sortName))
+			Expect(parseSortOrder("mtime")).To(Equal(sortMtime))
+			_, err := parseSortOrder("size")
+			Expect(err).To(MatchError(ContainSubstring(`unknown sort order "size"`)))
+		})
+	})
+
+	Context("lang", func() {
+		It("should require a language string", func() {
+			reply := send("lang")
+			Expect(reply).To(ContainSubstring("error: missing parameter\n"))
+			Expect(reply).To(ContainSubstring("status: 2\n"))
+			Expect(send("7", "lang")).To(ContainSubstring("error: missing parameter\n"))
+			Expect(srv.lang).To(Equal("en"))
+		})
+	})
+
+	Context("info", func() {
+		It("should describe an entry by id or key", func() {
+			reply := send(itoa(files), "info")
+			Expect(reply).To(ContainSubstring("cmd: info\nidx: " + itoa(files) + "\n"))
+			Expect(reply).To(ContainSubstring("path: /usr/share/applications/files.desktop\n"))
+		})
+
+		It("should report unknown and missing ids", func() {
+			Expect(send("999", "info")).To(ContainSubstring("status: 3\n"))
+			Expect(send("info")).To(ContainSubstring("status: 2\n"))
+		})
+	})
+
+	Context("broken", func() {
+		It("should list the entries whose program can't be launched", func() {
+			dir := GinkgoT().TempDir()
+			tool, plain := filepath.Join(dir, "tool"), filepath.Join(dir, "plain")
+			Expect(os.WriteFile(tool, []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+			Expect(os.WriteFile(plain, []byte("data\n"), 0644)).To(Succeed())
+
+			srv = newTestServer(GinkgoT().TempDir())
+			index := srv.indexer.GetIndex()
+			index.Add(&indexer.Entry{Name: "Tool", Path: tool, Exec: tool})
+			gone := index.Add(&indexer.Entry{Name: "Gone", Path: dir + "/gone", Exec: dir + "/gone"})
+			notExec := index.Add(&indexer.Entry{Name: "Plain", Path: plain, Exec: plain})
+			uninstalled := index.Add(&indexer.Entry{
+				Name:      "Viewer",
+				Path:      "/usr/share/applications/viewer.desktop",
+				Exec:      `"ade-test-uninstalled-viewer" %f`,
+				IsDesktop: true,
+			})
+			empty := index.Add(&indexer.Entry{Name: "Empty", Path: "/usr/share/applications/empty.desktop", IsDesktop: true})
+
+			reply := send("broken")
+			Expect(reply).To(HavePrefix("TXT01cmd: broken\nstatus: 0\nchecked: 5\nlen: 4\n"))
+			Expect(bodyOf(reply)).To(Equal([]string{
+				itoa(gone) + " missing " + dir + "/gone Gone",
+				itoa(notExec) + " not-executable " + plain + " Plain",
+				itoa(uninstalled) + " missing ade-test-uninstalled-viewer Viewer",
+				itoa(empty) + " no-exec - Empty",
+			}))
+
+			// Only the entries of the filter set are checked
+			send(`"`+dir, "+filter-path")
+			Expect(send("broken")).To(HavePrefix("TXT01cmd: broken\nstatus: 0\nchecked: 3\nlen: 2\n"))
+		})
+	})
+
+	Context("handler", func() {
+		var configHome, dataDir string
+
+		BeforeEach(func() {
+			dir := GinkgoT().TempDir()
+			configHome, dataDir = filepath.Join(dir, "config"), filepath.Join(dir, "data")
+			GinkgoT().Setenv("XDG_CONFIG_HOME", configHome)
+			GinkgoT().Setenv("XDG_CONFIG_DIRS", filepath.Join(dir, "xdg"))
+			GinkgoT().Setenv("XDG_DATA_HOME", filepath.Join(dir, "local"))
+			GinkgoT().Setenv("XDG_DATA_DIRS", dataDir)
+		})
+
+		writeList := func(path, content string) {
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		}
+		mailer := func(name, id, exec string) int64 {
+			return srv.indexer.GetIndex().Add(&indexer.Entry{
+				Name:      name,
+				Path:      "/usr/share/applications/" + id,
+				Exec:      exec,
+				DesktopID: id,
+				MimeTypes: []string{"text/calendar", "x-scheme-handler/mailto"},
+				IsDesktop: true,
+			})
+		}
+
+		It("should answer the first entry registered for a scheme without a default", func() {
+			mail := mailer("Mail", "mail.desktop", "mail %u")
+			mailer("Other Mail", "other-mail.desktop", "other-mail")
+
+			for _, scheme := range []string{"mailto", "mailto:", "MAILTO:someone@example.com"} {
+				reply := send(`"`+scheme, "handler")
+				Expect(reply).To(HavePrefix("TXT01cmd: handler\nidx: "+itoa(mail)+"\nstatus: 0\n"), scheme)
+				Expect(reply).To(ContainSubstring("mime: x-scheme-handler/mailto\nname: Mail\ndesktop-id: mail.desktop\ndefault: f\ntakes: url\n"))
+			}
+			Expect(send(`"Text/Calendar`, "handler")).To(ContainSubstring("idx: " + itoa(mail) + "\n"))
+		})
+
+		It("should prefer the default of the mimeapps.list files, the user's first", func() {
+			mailer("Mail", "mail.desktop", "mail %u")
+			other := mailer("Other Mail", "other-mail.desktop", "other-mail")
+			third := mailer("Third Mail", "third-mail.desktop", "third-mail %F")
+
+			writeList(filepath.Join(dataDir, "applications", "mimeapps.list"), "[Default Applications]\nx-scheme-handler/mailto=third-mail.desktop\n")
+			reply := send(`"mailto`, "handler")
+			Expect(reply).To(ContainSubstring("idx: " + itoa(third) + "\n"))
+			Expect(reply).To(ContainSubstring("default: t\ntakes: files\n"))
+
+			// Defaults the index doesn't hold are passed over
+			writeList(filepath.Join(configHome, "mimeapps.list"), "[Added Associations]\nx-scheme-handler/mailto=mail.desktop;\n\n"+
+				"[Default Applications]\nx-scheme-handler/mailto=gone.desktop;other-mail.desktop;\n")
+			reply = send(`"mailto`, "handler")
+			Expect(reply).To(ContainSubstring("idx: " + itoa(other) + "\n"))
+			Expect(reply).To(ContainSubstring("default: t\ntakes: none\n"))
+		})
+
+		It("should report schemes nothing handles", func() {
+			Expect(send(`"irc`, "handler")).To(ContainSubstring("error: not found\n"))
+			Expect(send("1", "handler")).To(ContainSubstring("error: missing parameter\n"))
+		})
+	})
+
+	Context("lang-list", func() {
+		It("should list the languages of an entry", func() {
+			reply := send(itoa(files), "lang-list")
+			Expect(reply).To(ContainSubstring("cmd: lang-list\nidx: " + itoa(files) + "\nstatus: 0\n"))
+			Expect(bodyOf(reply)).To(ContainElements(ContainSubstring("de"), ContainSubstring("pt_BR")))
+		})
+
+		It("should report unknown and missing ids", func() {
+			Expect(send("999", "lang-list")).To(ContainSubstring("error: not found\n"))
+			Expect(send("lang-list")).To(ContainSubstring("error: missing id\n"))
+		})
+	})
+})
@@ -0,0 +1,402 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/execline"
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/events"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/indexer/desktop"
+	"github.com/0xADE/ade-ctld/internal/launcher"
+	"github.com/0xADE/ade-ctld/internal/recent"
+	"github.com/0xADE/ade-ctld/internal/redact"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// runRequest is a run command resolved to the entry and the command line to launch
+type runRequest struct {
+	entry *indexer.Entry
+	argv  []string
+	file  string
+	nice  *int
+	warn  string // reported along with the launch, e.g. a binary changed since indexing
+}
+
+// Policies for binaries changed since they were indexed, see ADE_INDEXD_CHANGED_BINARY
+const (
+	changedWarn   = "warn"
+	changedRefuse = "refuse"
+)
+
+// parseChangedBinary parses the changed binary policy, it returns whether to refuse them
+func parseChangedBinary(s string) (bool, error) {
+	switch policy := strings.ToLower(strings.TrimSpace(s)); policy {
+	case "", changedWarn:
+		return false, nil
+	case changedRefuse:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown policy %q, expected warn or refuse", s)
+	}
+}
+
+// checkExec looks the binary of the entry up again right before it's launched: a package
+// upgrade may have removed or replaced it since it was indexed, and a different file may
+// now be at its path, and the daemon's own binary is refused. It returns the warning to
+// reply with, or writes the error to conn and returns false. Entries whose binary couldn't
+// be resolved when indexed aren't checked.
+func (s *Server) checkExec(conn net.Conn, cmd *parser.Command, entry *indexer.Entry) (string, bool) {
+	if entry.ExecID.IsZero() {
+		return "", true
+	}
+
+	program := entry.Exec
+	if entry.IsDesktop {
+		program = desktop.ExecBinary(entry.Exec)
+	}
+	// Resolved to "" when it's gone, which doesn't stat either
+	resolved := indexer.ResolveExec(program)
+	id, err := indexer.StatFileID(resolved)
+	if err != nil {
+		log.Printf("[ERROR] Binary %s of entry %d is gone: %v", redact.String(program), entry.ID, err)
+		s.writeError(conn, cmd, response.StatusUnavailable, "unavailable", "Can't run application, its binary was removed since indexing.")
+		return "", false
+	}
+	// Another daemon would fight this one over the socket
+	if !s.runSelf && s.indexer.IsDaemon(id) {
+		log.Printf("[ERROR] Refusing to run %s of entry %d, the daemon's own binary", redact.String(resolved), entry.ID)
+		s.writeError(conn, cmd, response.StatusUnavailable, "self launch", "Can't run the daemon's own binary, it would start another daemon; set ADE_INDEXD_RUN_SELF=true to allow it.")
+		return "", false
+	}
+	if id.Equal(entry.ExecID) {
+		return "", true
+	}
+
+	if s.refuseChanged {
+		log.Printf("[ERROR] Refusing to run %s of entry %d, changed since indexing", redact.String(resolved), entry.ID)
+		s.writeError(conn, cmd, response.StatusStale, "stale entry", "Can't run application, its binary changed since indexing; reindex to launch it.")
+		return "", false
+	}
+	log.Printf("[WARN] Running %s of entry %d, changed since indexing", redact.String(resolved), entry.ID)
+	return "binary changed since indexing", true
+}
+
+// prepareRun parses the options and the id or key of a run command and builds the command
+// line, without launching anything. Errors are written to conn and nil is returned then.
+func (s *session) prepareRun(conn net.Conn, cmd *parser.Command) *runRequest {
+	var (
+		forceTerminal bool
+		file          string
+		nice          *int
+		extra         []string
+		actionKey     string
+	)
+
+	// Options ("opt: terminal", "opt: nice=<n>", "file: <path>", "args: <command line>",
+	// "action: <key>") come before the id or key
+	args := cmd.Args
+	for len(args) > 0 && args[0].Type == parser.TypeString {
+		if args[0].Str == "opt: terminal" {
+			forceTerminal = true
+		} else if value, ok := strings.CutPrefix(args[0].Str, "opt: nice="); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < launcher.MinNice || n > launcher.MaxNice {
+				log.Printf("[ERROR] Run command with invalid nice %s", redact.Quote(value))
+				s.writeError(conn, cmd, response.StatusBadArgument, "invalid nice",
+					fmt.Sprintf("nice must be an integer from %d to %d", launcher.MinNice, launcher.MaxNice))
+				return nil
+			}
+			nice = &n
+		} else if path, ok := strings.CutPrefix(args[0].Str, "file: "); ok && path != "" {
+			file = path
+		} else if line, ok := strings.CutPrefix(args[0].Str, "args: "); ok {
+			split, err := execline.Split(line)
+			if err != nil {
+				log.Printf("[ERROR] Run command with invalid args %s: %v", redact.Quote(line), err)
+				s.writeError(conn, cmd, response.StatusBadArgument, "invalid args", err.Error())
+				return nil
+			}
+			extra = append(extra, split...)
+		} else if key, ok := strings.CutPrefix(args[0].Str, "action: "); ok && key != "" {
+			actionKey = key
+		} else {
+			break
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 || !isEntryRef(args[0]) {
+		if len(args) < len(cmd.Args) {
+			log.Printf("[ERROR] Run command missing id parameter after options")
+			s.writeError(conn, cmd, response.StatusBadArgument, "missing id", "run command requires an id or key parameter after its options")
+			return nil
+		}
+		log.Printf("[ERROR] Run command missing id parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing id", "run command requires an id or key parameter")
+		return nil
+	}
+	log.Printf("[DEBUG] Running application %s, forceTerminal: %v", entryRef(args[0]), forceTerminal)
+
+	entry, ok := s.lookupEntry(args[0])
+	if !ok {
+		log.Printf("[ERROR] Entry %s not found", entryRef(args[0]))
+		s.writeError(conn, cmd, response.StatusNotFound, "index not found", "Can't run application, requested index not found.")
+		return nil
+	}
+
+	log.Printf("[DEBUG] Found entry: %s, exec: %s, terminal: %v", redact.String(entry.Name), redact.String(entry.Exec), entry.Terminal)
+
+	exec := entry.Exec
+	if actionKey != "" {
+		i := slices.IndexFunc(entry.Actions, func(action desktop.Action) bool { return action.Key == actionKey })
+		if i < 0 {
+			log.Printf("[ERROR] Entry %d has no action %s", entry.ID, redact.Quote(actionKey))
+			s.writeError(conn, cmd, response.StatusNotFound, "action not found", "Can't run application, it has no such action.")
+			return nil
+		}
+		exec = entry.Actions[i].Exec
+	}
+
+	warn, ok := s.checkExec(conn, cmd, entry)
+	if !ok {
+		return nil
+	}
+
+	// Execute the command
+	// Desktop Exec values are command lines with field codes and $VAR references
+	argv := []string{exec}
+	if entry.IsDesktop {
+		argv = desktop.ExpandExecArgs(exec, desktop.ExecFields{
+			File:     file,
+			Name:     s.execName(entry),
+			Location: entry.Path,
+		})
+	} else if file != "" {
+		argv = append(argv, file)
+	}
+	argv = append(argv, extra...)
+	if forceTerminal || entry.Terminal {
+		cfg := config.Get()
+		term := cfg.Terminal()
+		argv = append([]string{term, "--hold", "-e"}, argv...)
+		log.Printf("[DEBUG] Executing in terminal: %s -e %s", term, redact.Strings(argv[3:]))
+	} else {
+		log.Printf("[DEBUG] Executing: %s", redact.Strings(argv))
+	}
+
+	// The option wins over the entry's X-ADE-Nice, which isn't range checked when indexed
+	if nice == nil && entry.Nice != nil {
+		if *entry.Nice < launcher.MinNice || *entry.Nice > launcher.MaxNice {
+			log.Printf("[WARN] Ignoring X-ADE-Nice=%d of %s, out of range", *entry.Nice, redact.String(entry.Path))
+		} else {
+			nice = entry.Nice
+		}
+	}
+
+	return &runRequest{entry: entry, argv: argv, file: file, nice: nice, warn: warn}
+}
+
+func (s *session) handleRun(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling run command")
+
+	req := s.prepareRun(conn, cmd)
+	if req == nil {
+		return
+	}
+	entry, file := req.entry, req.file
+
+	// A run repeated within ADE_INDEXD_RUN_DEBOUNCE_MS gets the first launch back
+	key := runKey(req)
+	first, pending := s.debounce.begin(key)
+	if first != nil {
+		log.Printf("[DEBUG] Run of entry %d debounced, launched with PID %d", entry.ID, first.PID)
+		resp := response.OK("run").Setf("idx", "%d", entry.ID).Setf("pid", "%d", first.PID)
+		if first.Unit != "" {
+			resp.Set("unit", first.Unit)
+		}
+		s.writeResponse(conn, resp.SetBool("debounced", true))
+		return
+	}
+
+	proc, err := s.launcher.StartWith(entry.ID, entry.Name, req.argv, launcher.Options{Nice: req.nice})
+	if err != nil {
+		s.debounce.finish(key, pending, nil)
+		log.Printf("[ERROR] Failed to start command: %v", err)
+		s.writeError(conn, cmd, response.StatusInternal, "execution failed", err.Error())
+		return
+	}
+	s.debounce.finish(key, pending, proc)
+
+	pid := proc.PID
+	log.Printf("[DEBUG] Command started successfully with PID: %d", pid)
+
+	// Update run frequency after successful execution
+	if s.runIndex != nil {
+		if err := s.runIndex.Increment(entry.Path); err != nil {
+			log.Printf("[WARN] Failed to update run frequency for %s: %v", redact.String(entry.Path), err)
+		} else {
+			events.Publish(s.bus, events.RunRecorded{Path: entry.Path, PID: pid})
+		}
+	}
+
+	if file != "" && config.Get().RecentFiles() {
+		s.addRecentFile(entry, file)
+	}
+	s.collectLogsSoon()
+
+	resp := response.OK("run").Setf("idx", "%d", entry.ID).Setf("pid", "%d", pid)
+	if proc.Unit != "" {
+		resp.Set("unit", proc.Unit)
+	}
+	if req.warn != "" {
+		resp.Set("warn", req.warn)
+	}
+	s.writeResponse(conn, resp)
+	log.Printf("[DEBUG] Run response sent")
+}
+
+// addRecentFile records the file an entry was launched with in the recently used store
+func (s *Server) addRecentFile(entry *indexer.Entry, file string) {
+	store, err := recent.DefaultPath()
+	if err != nil {
+		log.Printf("[WARN] Failed to locate recently used store: %v", err)
+		return
+	}
+	item := recent.Item{
+		File: file,
+		App:  filepath.Base(desktop.ExecBinary(entry.Exec)),
+		Exec: entry.Exec,
+		Time: timeNow(),
+	}
+	if !entry.IsDesktop {
+		item.Exec = entry.Exec + " %f"
+	}
+	if err := recent.Add(store, item); err != nil {
+		log.Printf("[WARN] Failed to record %s as recently used: %v", redact.String(file), err)
+	}
+}
+
+// killPid returns the pid argument of a kill command, writing the error to conn if it's
+// missing
+func (s *Server) killPid(conn net.Conn, cmd *parser.Command) (int, bool) {
+	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeInt {
+		log.Printf("[ERROR] Kill command missing pid parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing pid", "kill command requires a pid parameter")
+		return 0, false
+	}
+	return int(cmd.Args[0].Int), true
+}
+
+func (s *Server) handleKill(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling kill command")
+	pid, ok := s.killPid(conn, cmd)
+	if !ok {
+		return
+	}
+
+	proc, err := s.launcher.Kill(pid)
+	if errors.Is(err, launcher.ErrNotFound) {
+		log.Printf("[ERROR] Process %d was not launched by the daemon", pid)
+		s.writeError(conn, cmd, response.StatusNotFound, "process not found", fmt.Sprintf("pid %d is not a running process launched by the daemon", pid))
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to kill process %d: %v", pid, err)
+		s.writeError(conn, cmd, response.StatusInternal, "kill failed", err.Error())
+		return
+	}
+
+	resp := response.OK("kill").Setf("pid", "%d", pid)
+	if proc.Unit != "" {
+		resp.Set("unit", proc.Unit)
+	}
+	s.writeResponse(conn, resp)
+}
+
+// pinEntry returns the entry a pin or unpin command refers to, writing the error to conn
+// and returning nil if there's none
+func (s *Server) pinEntry(conn net.Conn, cmd *parser.Command, cmdName string) *indexer.Entry {
+	if !s.requireRunIndex(conn, cmd) {
+		return nil
+	}
+	if len(cmd.Args) == 0 || !isEntryRef(cmd.Args[0]) {
+		log.Printf("[ERROR] %s command missing id parameter", cmdName)
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing id", cmdName+" command requires an id or key parameter")
+		return nil
+	}
+
+	entry, ok := s.lookupEntry(cmd.Args[0])
+	if !ok {
+		log.Printf("[ERROR] Entry %s not found", entryRef(cmd.Args[0]))
+		s.writeError(conn, cmd, response.StatusNotFound, "index not found", "Can't "+cmdName+" application, requested index not found.")
+		return nil
+	}
+	return entry
+}
+
+func (s *Server) handlePin(conn net.Conn, cmd *parser.Command, pinned bool) {
+	cmdName := "unpin"
+	if pinned {
+		cmdName = "pin"
+	}
+	log.Printf("[DEBUG] Handling %s command", cmdName)
+
+	entry := s.pinEntry(conn, cmd, cmdName)
+	if entry == nil {
+		return
+	}
+
+	if err := s.setPinned(entry, pinned); err != nil {
+		log.Printf("[ERROR] Failed to %s %s: %v", cmdName, redact.String(entry.Path), err)
+		s.writeError(conn, cmd, response.StatusInternal, "storage failed", err.Error())
+		return
+	}
+
+	s.writeResponse(conn, response.OK(cmdName).Setf("idx", "%d", entry.ID))
+}
+
+// runStatsTop is the number of paths runstats lists when no count is given
+const runStatsTop = 10
+
+func (s *Server) handleRunStats(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling runstats command")
+	if !s.requireRunIndex(conn, cmd) {
+		return
+	}
+
+	top := runStatsTop
+	if len(cmd.Args) > 0 {
+		if cmd.Args[0].Type != parser.TypeInt || cmd.Args[0].Int < 0 {
+			log.Printf("[ERROR] Runstats command has invalid top parameter")
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid top", "runstats top must be a non-negative integer")
+			return
+		}
+		top = int(cmd.Args[0].Int)
+	}
+
+	stats, err := s.runIndex.Stats(top)
+	if err != nil {
+		log.Printf("[ERROR] Failed to read run stats: %v", err)
+		s.writeError(conn, cmd, response.StatusInternal, "storage failed", err.Error())
+		return
+	}
+
+	resp := response.OK("runstats").
+		Setf("tracked", "%d", stats.Tracked).
+		Setf("total-runs", "%d", stats.TotalRuns).
+		Setf("len", "%d", len(stats.Top)).
+		Body()
+	for _, pc := range stats.Top {
+		resp.Body(fmt.Sprintf("%d %s", pc.Count, pc.Path))
+	}
+
+	s.writeResponse(conn, resp)
+}
@@ -0,0 +1,386 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/indexer/desktop"
+	"github.com/0xADE/ade-ctld/internal/launcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("run handlers", func() {
+	var (
+		srv     *session
+		sleeper *indexer.Entry
+	)
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		sleeper = &indexer.Entry{Name: "sleeper", Exec: "sleep", Path: "/usr/bin/sleep"}
+		srv.indexer.GetIndex().Add(sleeper)
+	})
+
+	AfterEach(func() {
+		for _, proc := range srv.launcher.Running() {
+			srv.launcher.Kill(proc.PID)
+		}
+		srv.runIndex.Close()
+	})
+
+	send := func(lines ...string) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+		return buf.String()
+	}
+
+	Context("run", func() {
+		It("should require an id or key", func() {
+			reply := send("run")
+			Expect(reply).To(ContainSubstring("error: missing id\n"))
+			Expect(reply).To(ContainSubstring("desc: run command requires an id or key parameter\n"))
+			Expect(reply).To(ContainSubstring("status: 2\n"))
+		})
+
+		It("should require an id or key after the options", func() {
+			reply := send(`"opt: terminal`, `"file: /tmp/x`, "run")
+			Expect(reply).To(ContainSubstring("error: missing id\n"))
+			Expect(reply).To(ContainSubstring("after its options"))
+		})
+
+		It("should report unknown ids and keys", func() {
+			for _, ref := range []string{"999", `"nope`} {
+				reply := send(ref, "run")
+				Expect(reply).To(ContainSubstring("error: index not found\n"), ref)
+				Expect(reply).To(ContainSubstring("status: 3\n"), ref)
+			}
+		})
+
+		It("should refuse an invalid nice before looking the entry up", func() {
+			reply := send(`"opt: nice=high`, "999", "run")
+			Expect(reply).To(ContainSubstring("error: invalid nice\n"))
+		})
+
+		It("should append the split args after the file", func() {
+			viewer := &indexer.Entry{Name: "viewer", Exec: `viewer "--title=%c" %f`, Path: "/usr/share/applications/viewer.desktop", IsDesktop: true}
+			srv.indexer.GetIndex().Add(viewer)
+
+			reply := send(`"opt: dry-run`, `"file: /tmp/a b.png`, `"args: --zoom "2 x" ""`, itoa(viewer.ID), "run")
+			Expect(reply).To(ContainSubstring("len: 6\n"))
+			Expect(reply).To(HaveSuffix("body:\nviewer\n--title=viewer\n/tmp/a b.png\n--zoom\n2 x\n\n\n\n"))
+
+			reply = send(`"opt: dry-run`, `"args: -v`, `"args: "-n 1"`, `"file: /tmp/x`, itoa(sleeper.ID), "run")
+			Expect(reply).To(HaveSuffix("body:\nsleep\n/tmp/x\n-v\n-n 1\n\n\n"))
+		})
+
+		It("should launch the command of the action asked for", func() {
+			browser := &indexer.Entry{Name: "browser", Exec: "browser %u", Path: "/usr/share/applications/browser.desktop", IsDesktop: true,
+				Actions: []desktop.Action{{Key: "new-private-window", Name: "New Private Window", Exec: "browser --private-window %u"}}}
+			srv.indexer.GetIndex().Add(browser)
+
+			reply := send(`"opt: dry-run`, `"action: new-private-window`, `"file: https://example.org`, itoa(browser.ID), "run")
+			Expect(reply).To(HaveSuffix("body:\nbrowser\n--private-window\nhttps://example.org\n\n\n"))
+			Expect(send(itoa(browser.ID), "info")).To(ContainSubstring("actions: new-private-window\n"))
+
+			reply = send(`"action: new-window`, itoa(browser.ID), "run")
+			Expect(reply).To(ContainSubstring("error: action not found\nstatus: 3\n"))
+			reply = send(`"action: new-window`, itoa(sleeper.ID), "run")
+			Expect(reply).To(ContainSubstring("error: action not found\n"))
+			Expect(srv.launcher.Running()).To(BeEmpty())
+		})
+
+		It("should refuse args it can't split", func() {
+			reply := send(`"args: "a b`, itoa(sleeper.ID), "run")
+			Expect(reply).To(ContainSubstring("error: invalid args\nstatus: 2\ndesc: unterminated quote in command line\n"))
+			Expect(srv.launcher.Running()).To(BeEmpty())
+		})
+
+		It("should launch the entry and count the run", func() {
+			reply := send(`"file: 5`, itoa(sleeper.ID), "run")
+			Expect(reply).To(MatchRegexp(`^TXT01cmd: run\nidx: %d\nstatus: 0\npid: \d+\n`, sleeper.ID))
+			Expect(srv.launcher.Running()).To(HaveLen(1))
+			Expect(srv.runIndex.GetFrequencies([]string{sleeper.Path})).To(HaveKeyWithValue(sleeper.Path, uint64(1)))
+		})
+
+		It("should launch once for runs repeated within the debounce window", func() {
+			srv.debounce = newRunDebounce(time.Minute)
+			first := send(`"file: 5`, itoa(sleeper.ID), "run")
+			pid := attrLine(first, "pid")
+			Expect(pid).NotTo(BeEmpty())
+			Expect(first).NotTo(ContainSubstring("debounced"))
+
+			second := send(`"file: 5`, itoa(sleeper.ID), "run")
+			Expect(second).To(Equal(fmt.Sprintf("TXT01cmd: run\nidx: %d\nstatus: 0\npid: %s\ndebounced: t\n\n\n", sleeper.ID, pid)))
+			Expect(srv.launcher.Running()).To(HaveLen(1))
+			Expect(srv.runIndex.GetFrequencies([]string{sleeper.Path})).To(HaveKeyWithValue(sleeper.Path, uint64(1)))
+
+			// Another command line is another run
+			Expect(send(`"file: 6`, itoa(sleeper.ID), "run")).NotTo(ContainSubstring("debounced"))
+			Expect(srv.launcher.Running()).To(HaveLen(2))
+		})
+
+		It("should have a run racing the first one wait for its launch", func() {
+			debounce := newRunDebounce(time.Minute)
+			first, pending := debounce.begin("1")
+			Expect(first).To(BeNil())
+			Expect(pending).NotTo(BeNil())
+
+			got := make(chan *launcher.Process)
+			go func() { proc, _ := debounce.begin("1"); got <- proc }()
+			Consistently(got, 50*time.Millisecond).ShouldNot(Receive())
+			proc := &launcher.Process{PID: 42}
+			debounce.finish("1", pending, proc)
+			Eventually(got).Should(Receive(BeIdenticalTo(proc)))
+
+			// A failed launch is forgotten
+			_, pending = debounce.begin("2")
+			debounce.finish("2", pending, nil)
+			first, pending = debounce.begin("2")
+			Expect(first).To(BeNil())
+			Expect(pending).NotTo(BeNil())
+		})
+
+		It("should launch again once the debounce window is over", func() {
+			now := time.Now()
+			timeNow = func() time.Time { return now }
+			DeferCleanup(func() { timeNow = time.Now })
+			srv.debounce = newRunDebounce(500 * time.Millisecond)
+
+			send(`"file: 5`, itoa(sleeper.ID), "run")
+			now = now.Add(500 * time.Millisecond)
+			Expect(send(`"file: 5`, itoa(sleeper.ID), "run")).NotTo(ContainSubstring("debounced"))
+			Expect(srv.launcher.Running()).To(HaveLen(2))
+		})
+	})
+
+	Context("kill", func() {
+		It("should require a pid", func() {
+			Expect(send("kill")).To(ContainSubstring("error: missing pid\n"))
+			Expect(send(`"sleeper`, "kill")).To(ContainSubstring("error: missing pid\n"))
+		})
+
+		It("should only kill processes launched by the daemon", func() {
+			reply := send(itoa(int64(os.Getpid())), "kill")
+			Expect(reply).To(ContainSubstring("error: process not found\n"))
+			Expect(reply).To(ContainSubstring("status: 3\n"))
+		})
+
+		It("should kill a launched process", func() {
+			proc, err := srv.launcher.Start(sleeper.ID, sleeper.Name, []string{"sleep", "5"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(send(itoa(int64(proc.PID)), "kill")).To(HavePrefix("TXT01cmd: kill\nstatus: 0\npid: " + itoa(int64(proc.PID)) + "\n"))
+			Eventually(srv.launcher.Running).Should(BeEmpty())
+		})
+	})
+
+	Context("addRecentFile", func() {
+		It("should record the file with the entry's command line", func() {
+			dataHome := GinkgoT().TempDir()
+			GinkgoT().Setenv("XDG_DATA_HOME", dataHome)
+
+			srv.addRecentFile(sleeper, "/tmp/notes.txt")
+
+			data, err := os.ReadFile(filepath.Join(dataHome, "recently-used.xbel"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("file:///tmp/notes.txt"))
+			Expect(string(data)).To(ContainSubstring(`name="sleep"`))
+			Expect(string(data)).To(ContainSubstring(`exec="&#39;sleep %f&#39;"`))
+		})
+	})
+})
+
+var _ = Describe("without a run index", func() {
+	var srv *session
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		srv.runIndex.Close()
+		srv.runIndex = nil
+		for _, name := range []string{"beta", "Alpha", "gamma"} {
+			srv.indexer.GetIndex().Add(&indexer.Entry{Name: name, Exec: "sleep", Path: "/usr/bin/sleep"})
+		}
+	})
+
+	AfterEach(func() {
+		for _, proc := range srv.launcher.Running() {
+			srv.launcher.Kill(proc.PID)
+		}
+	})
+
+	send := func(lines ...string) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+		return buf.String()
+	}
+
+	It("should list by name instead of frequency", func() {
+		reply := send("list")
+		Expect(reply).To(HavePrefix("TXT01len: 3\nlist-len: 3\ntotal: 3\n"))
+		Expect(reply).To(HaveSuffix("body:\n2 Alpha\n1 beta\n3 gamma\n\n\n"))
+	})
+
+	It("should run entries without counting the run", func() {
+		reply := send(`"file: 5`, "1", "run")
+		Expect(reply).To(MatchRegexp(`^TXT01cmd: run\nidx: 1\nstatus: 0\npid: \d+\n`))
+		Expect(srv.launcher.Running()).To(HaveLen(1))
+	})
+
+	It("should refuse the commands kept in the run index", func() {
+		for _, lines := range [][]string{{"1", "pin"}, {"1", "unpin"}, {"runstats"}, {`"persist`, "session"}, {`"runs`, "clearcache"}} {
+			reply := send(lines...)
+			Expect(reply).To(ContainSubstring("error: storage unavailable\nstatus: 1\n"), lines[len(lines)-1])
+		}
+		Expect(send("1", "info")).To(ContainSubstring("pinned: f\n"))
+	})
+})
+
+var _ = Describe("run of a binary changed since indexing", func() {
+	var (
+		srv    *session
+		script string
+		entry  *indexer.Entry
+	)
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		script = filepath.Join(GinkgoT().TempDir(), "tool")
+		Expect(os.WriteFile(script, []byte("#!/bin/sh\nsleep 5\n"), 0755)).To(Succeed())
+		id, err := indexer.StatFileID(script)
+		Expect(err).NotTo(HaveOccurred())
+		entry = &indexer.Entry{Name: "tool", Exec: script, Path: script, ResolvedExec: script, ExecID: id}
+		srv.indexer.GetIndex().Add(entry)
+	})
+
+	AfterEach(func() {
+		for _, proc := range srv.launcher.Running() {
+			srv.launcher.Kill(proc.PID)
+		}
+		srv.runIndex.Close()
+	})
+
+	send := func(lines ...string) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+		return buf.String()
+	}
+
+	// replace puts another file at the path of the script, like a package upgrade does
+	replace := func() {
+		next := script + ".new"
+		Expect(os.WriteFile(next, []byte("#!/bin/sh\nexec sleep 5\n"), 0755)).To(Succeed())
+		Expect(os.Rename(next, script)).To(Succeed())
+	}
+
+	It("should run an unchanged binary without a warning", func() {
+		reply := send(itoa(entry.ID), "run")
+		Expect(reply).To(ContainSubstring("status: 0\n"))
+		Expect(reply).NotTo(ContainSubstring("warn:"))
+	})
+
+	It("should warn about a replaced binary and launch it anyway", func() {
+		replace()
+		reply := send(itoa(entry.ID), "run")
+		Expect(reply).To(MatchRegexp(`^TXT01cmd: run\nidx: %d\nstatus: 0\npid: \d+\nwarn: binary changed since indexing\n`, entry.ID))
+		Expect(srv.launcher.Running()).To(HaveLen(1))
+
+		Expect(send(`"opt: dry-run`, itoa(entry.ID), "run")).To(ContainSubstring("warn: binary changed since indexing\n"))
+	})
+
+	It("should refuse a replaced binary with the refuse policy", func() {
+		srv.refuseChanged = true
+		replace()
+		reply := send(itoa(entry.ID), "run")
+		Expect(reply).To(ContainSubstring("error: stale entry\nstatus: 7\n"))
+		Expect(srv.launcher.Running()).To(BeEmpty())
+	})
+
+	It("should report a removed binary as unavailable", func() {
+		Expect(os.Remove(script)).To(Succeed())
+		reply := send(itoa(entry.ID), "run")
+		Expect(reply).To(ContainSubstring("error: unavailable\nstatus: 8\n"))
+		Expect(srv.runIndex.GetFrequencies([]string{script})[script]).To(BeZero())
+	})
+
+	It("should parse the policy", func() {
+		for value, refuse := range map[string]bool{"": false, "warn": false, " Refuse ": true} {
+			got, err := parseChangedBinary(value)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(refuse), value)
+		}
+		_, err := parseChangedBinary("ignore")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("self tools", func() {
+	var (
+		srv    *session
+		binDir string
+	)
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		DeferCleanup(srv.runIndex.Close)
+
+		// A copy of the test binary stands in for the daemon, run through a symlink and
+		// installed under another name as a hard link
+		self, err := os.Executable()
+		Expect(err).NotTo(HaveOccurred())
+		data, err := os.ReadFile(self)
+		Expect(err).NotTo(HaveOccurred())
+		installDir := GinkgoT().TempDir()
+		daemon := filepath.Join(installDir, "ade-exe-ctld")
+		Expect(os.WriteFile(daemon, data, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(installDir, "ade-exe-cli"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		srv.indexer.SetExecutable(daemon)
+
+		binDir = GinkgoT().TempDir()
+		Expect(os.Symlink(daemon, filepath.Join(binDir, "ade-exe-ctld"))).To(Succeed())
+		Expect(os.Link(daemon, filepath.Join(binDir, "indexd-old"))).To(Succeed())
+		Expect(os.Symlink(filepath.Join(installDir, "ade-exe-cli"), filepath.Join(binDir, "ade-exe-cli"))).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(binDir, "tool"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		_, err = srv.indexer.Reindex(context.Background(), []string{binDir})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	send := func(lines ...string) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+		return buf.String()
+	}
+
+	It("should leave them out of lists unless asked for", func() {
+		for _, key := range []string{"ade-exe-ctld", "indexd-old", "ade-exe-cli"} {
+			entry, ok := srv.indexer.GetIndex().GetByKey(key)
+			Expect(ok).To(BeTrue(), key)
+			Expect(send(`"`+key, "info")).To(ContainSubstring("self-tool: t\n"))
+			Expect(send("list")).NotTo(ContainSubstring(fmt.Sprintf("\n%d %s\n", entry.ID, key)))
+			Expect(send(`"opt: self-tools`, "list")).To(ContainSubstring(fmt.Sprintf("\n%d %s\n", entry.ID, key)))
+			Expect(send(`"opt: self-tools`, "0", "list-next")).To(ContainSubstring(fmt.Sprintf("\n%d %s\n", entry.ID, key)))
+		}
+		Expect(send("list")).To(ContainSubstring(" tool\n"))
+		Expect(send(`"tool`, "info")).NotTo(ContainSubstring("self-tool"))
+	})
+
+	It("should refuse to run the daemon unless allowed", func() {
+		for _, key := range []string{"ade-exe-ctld", "indexd-old"} {
+			reply := send(`"`+key, "run")
+			Expect(reply).To(ContainSubstring("error: self launch\nstatus: 8\n"), key)
+			Expect(reply).To(ContainSubstring("ADE_INDEXD_RUN_SELF"))
+		}
+		Expect(srv.launcher.Running()).To(BeEmpty())
+		// Companions are tagged, not refused
+		Expect(send(`"opt: dry-run`, `"ade-exe-cli`, "run")).To(ContainSubstring("status: 0\n"))
+
+		srv.runSelf = true
+		Expect(send(`"opt: dry-run`, `"ade-exe-ctld`, "run")).To(ContainSubstring("status: 0\n"))
+	})
+})
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// eventQueueSize bounds the events waiting to be written to a single subscriber
+const eventQueueSize = 16
+
+// event is a notification pushed to subscribed connections
+type event struct {
+	name       string // index-updated or resync
+	generation uint64
+	changes    int
+}
+
+func (e event) String() string {
+	frame := response.New().Set("event", e.name).Setf("generation", "%d", e.generation)
+	if e.name != "resync" {
+		frame.Setf("changes", "%d", e.changes)
+	}
+	return frame.String()
+}
+
+// hubStats counts what happened to published changes
+type hubStats struct {
+	Published  uint64 // index changes reported to the hub
+	Notified   uint64 // coalesced notifications fanned out to subscribers
+	Sent       uint64 // events written to subscribers
+	Dropped    uint64 // events dropped because a subscriber queue was full
+	Resyncs    uint64 // resync events sent instead of dropped ones
+	Heartbeats uint64 // heartbeats written to idle subscribers
+}
+
+// hub fans index changes out to subscribers. Changes arriving within window collapse into
+// one notification, and each subscriber gets at most one event per interval. Subscribers
+// that got nothing for a heartbeat period are sent a heartbeat, so the connection isn't
+// considered idle.
+type hub struct {
+	window    time.Duration
+	interval  time.Duration
+	heartbeat time.Duration // 0 disables heartbeats
+	queueSize int
+
+	mu      sync.Mutex
+	subs    map[io.Writer]*subscriber
+	pending *event
+	timer   *time.Timer
+	latest  uint64 // generation of the last notification
+	closed  bool
+	stats   hubStats
+}
+
+// subscriber owns the goroutine writing events to one connection
+type subscriber struct {
+	w      io.Writer
+	queue  chan event
+	resync bool // set by the hub when an event was dropped, guarded by hub.mu
+	done   chan struct{}
+	exited chan struct{}
+}
+
+func newHub(window, interval, heartbeat time.Duration, queueSize int) *hub {
+	return &hub{
+		window:    window,
+		interval:  interval,
+		heartbeat: heartbeat,
+		queueSize: queueSize,
+		subs:      make(map[io.Writer]*subscriber),
+	}
+}
+
+// Publish reports a new index generation. It never blocks on subscribers.
+func (h *hub) Publish(generation uint64, changes int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+
+	h.stats.Published++
+	if h.pending == nil {
+		h.pending = &event{name: "index-updated"}
+		h.timer = time.AfterFunc(h.window, h.flush)
+	}
+	h.pending.generation = generation
+	h.pending.changes += changes
+}
+
+// flush sends the coalesced notification to every subscriber queue
+func (h *hub) flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pending == nil || h.closed {
+		return
+	}
+	ev := *h.pending
+	h.pending = nil
+	h.timer = nil
+	h.latest = ev.generation
+
+	h.stats.Notified++
+	for _, sub := range h.subs {
+		select {
+		case sub.queue <- ev:
+		default:
+			// The subscriber can't keep up: it'll be told to refetch everything instead
+			h.stats.Dropped++
+			sub.resync = true
+		}
+	}
+}
+
+// Subscribe starts delivering events to w. Subscribing twice is a no-op.
+func (h *hub) Subscribe(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	if _, ok := h.subs[w]; ok {
+		return
+	}
+
+	sub := &subscriber{
+		w:      w,
+		queue:  make(chan event, h.queueSize),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	h.subs[w] = sub
+	go h.deliver(sub)
+}
+
+// Unsubscribe stops delivering events to w and waits for its writer to exit
+func (h *hub) Unsubscribe(w io.Writer) {
+	h.mu.Lock()
+	sub, ok := h.subs[w]
+	delete(h.subs, w)
+	h.mu.Unlock()
+
+	if ok {
+		close(sub.done)
+		<-sub.exited
+	}
+}
+
+// Close drops pending notifications and stops all subscribers
+func (h *hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	h.pending = nil
+	subs := h.subs
+	h.subs = make(map[io.Writer]*subscriber)
+	h.mu.Unlock()
+
+	for _, sub := range subs {
+		close(sub.done)
+		<-sub.exited
+	}
+}
+
+// Stats returns the counters and the number of subscribers
+func (h *hub) Stats() (hubStats, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats, len(h.subs)
+}
+
+// deliver writes queued events to the subscriber, at most one per interval. Events queued
+// while waiting are merged into one. A heartbeat is written whenever the subscriber got
+// nothing for the heartbeat period.
+func (h *hub) deliver(sub *subscriber) {
+	defer close(sub.exited)
+
+	var (
+		timer *time.Timer
+		beat  <-chan time.Time
+	)
+	if h.heartbeat > 0 {
+		timer = time.NewTimer(h.heartbeat)
+		defer timer.Stop()
+		beat = timer.C
+	}
+
+	for {
+		var ev event
+		select {
+		case <-sub.done:
+			return
+		case <-beat:
+			h.mu.Lock()
+			h.stats.Heartbeats++
+			h.mu.Unlock()
+			h.write(sub, response.New().Setf("heartbeat", "%d", timeNow().Unix()).String())
+			timer.Reset(h.heartbeat)
+			continue
+		case ev = <-sub.queue:
+		}
+
+	merge:
+		for {
+			select {
+			case next := <-sub.queue:
+				ev.generation = next.generation
+				ev.changes += next.changes
+			default:
+				break merge
+			}
+		}
+
+		h.mu.Lock()
+		if sub.resync {
+			sub.resync = false
+			ev = event{name: "resync", generation: h.latest}
+			h.stats.Resyncs++
+		}
+		h.stats.Sent++
+		h.mu.Unlock()
+
+		h.write(sub, ev.String())
+		if timer != nil {
+			timer.Reset(h.heartbeat)
+		}
+
+		// Rate cap: the next event waits for the interval to pass
+		select {
+		case <-sub.done:
+			return
+		case <-time.After(h.interval):
+		}
+	}
+}
+
+// write sends one frame to the subscriber
+func (h *hub) write(sub *subscriber, frame string) {
+	if _, err := io.WriteString(sub.w, response.Header+frame); err != nil {
+		log.Printf("[DEBUG] Failed to deliver event: %v", err)
+	}
+}
+
+func (s *Server) handleSubscribe(conn net.Conn, _ *parser.Command) {
+	log.Printf("[DEBUG] Handling subscribe command")
+	// The reply goes out first so it can't be preceded by an event
+	s.writeResponse(conn, response.OK("subscribe").Setf("generation", "%d", s.indexer.Generation()))
+	s.hub.Subscribe(baseConn(conn))
+}
+
+func (s *Server) handleUnsubscribe(conn net.Conn, _ *parser.Command) {
+	log.Printf("[DEBUG] Handling unsubscribe command")
+	s.hub.Unsubscribe(baseConn(conn))
+	s.writeResponse(conn, response.OK("unsubscribe"))
+}
+
+func (s *session) handleStats(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling stats command")
+
+	// "children lists the running processes launched by the daemon in the body
+	children := len(cmd.Args) == 1 && cmd.Args[0].Type == parser.TypeString && cmd.Args[0].Str == "children"
+	if len(cmd.Args) > 0 && !children {
+		s.writeError(conn, cmd, response.StatusBadArgument, "invalid argument", `stats command accepts only "children`)
+		return
+	}
+
+	stats, subscribers := s.hub.Stats()
+	running := s.launcher.Running()
+
+	resp := response.OK("stats").Setf("generation", "%d", s.indexer.Generation())
+	s.setTruncatedAttr(resp)
+	resp.Setf("subscribers", "%d", subscribers).
+		Setf("events-published", "%d", stats.Published).
+		Setf("events-notified", "%d", stats.Notified).
+		Setf("events-sent", "%d", stats.Sent).
+		Setf("events-dropped", "%d", stats.Dropped).
+		Setf("events-resync", "%d", stats.Resyncs).
+		Setf("heartbeats-sent", "%d", stats.Heartbeats)
+	s.filters.mu.RLock()
+	filters := s.filters.snapshot()
+	s.filters.mu.RUnlock()
+	resp.Setf("filters", "%d", filters.filterCount()).
+		Setf("filter-bytes", "%d", filters.filterBytes()).
+		Setf("running-children", "%d", len(running))
+	if !children {
+		s.writeResponse(conn, resp)
+		return
+	}
+
+	resp.Setf("len", "%d", len(running)).Body()
+	for _, proc := range running {
+		resp.Body(fmt.Sprintf("%d %s", proc.PID, bodyLine(proc.Name)))
+	}
+	s.writeResponse(conn, resp)
+}
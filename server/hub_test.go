@@ -0,0 +1,359 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/events"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// eventRecorder is a subscriber connection collecting the events written to it
+type eventRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *eventRecorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(b)
+}
+
+// events returns the recorded events without the protocol header
+func (r *eventRecorder) events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []string
+	for _, frame := range strings.Split(r.buf.String(), "TXT01") {
+		if frame != "" {
+			events = append(events, frame)
+		}
+	}
+	return events
+}
+
+var _ = Describe("hub", func() {
+	var (
+		h   *hub
+		rec *eventRecorder
+	)
+
+	AfterEach(func() {
+		h.Close()
+	})
+
+	It("should coalesce a burst of changes into one event", func() {
+		h = newHub(50*time.Millisecond, 10*time.Millisecond, 0, eventQueueSize)
+		rec = &eventRecorder{}
+		h.Subscribe(rec)
+
+		for generation := uint64(1); generation <= 500; generation++ {
+			h.Publish(generation, 1)
+		}
+
+		Eventually(rec.events).Should(HaveLen(1))
+		Consistently(rec.events, 200*time.Millisecond).Should(HaveLen(1))
+		Expect(rec.events()[0]).To(Equal("event: index-updated\ngeneration: 500\nchanges: 500\n\n\n"))
+
+		stats, subscribers := h.Stats()
+		Expect(subscribers).To(Equal(1))
+		Expect(stats.Published).To(Equal(uint64(500)))
+		Expect(stats.Notified).To(Equal(uint64(1)))
+		Expect(stats.Sent).To(Equal(uint64(1)))
+		Expect(stats.Dropped).To(BeZero())
+	})
+
+	It("should not send events faster than the interval", func() {
+		h = newHub(5*time.Millisecond, 300*time.Millisecond, 0, eventQueueSize)
+		rec = &eventRecorder{}
+		h.Subscribe(rec)
+
+		h.Publish(1, 2)
+		Eventually(rec.events).Should(HaveLen(1))
+		start := time.Now()
+
+		// Separate notifications, but they pile up behind the rate cap and merge
+		for generation := uint64(2); generation <= 4; generation++ {
+			time.Sleep(20 * time.Millisecond)
+			h.Publish(generation, 1)
+		}
+
+		Eventually(rec.events, time.Second).Should(HaveLen(2))
+		Expect(time.Since(start)).To(BeNumerically(">=", 250*time.Millisecond))
+		Expect(rec.events()[1]).To(Equal("event: index-updated\ngeneration: 4\nchanges: 3\n\n\n"))
+	})
+
+	It("should tell a subscriber that can't keep up to resync instead of dropping it", func() {
+		h = newHub(5*time.Millisecond, 300*time.Millisecond, 0, 1)
+		rec = &eventRecorder{}
+		h.Subscribe(rec)
+
+		h.Publish(1, 1)
+		Eventually(rec.events).Should(HaveLen(1))
+
+		// While the subscriber waits out the interval its one-slot queue overflows
+		for generation := uint64(2); generation <= 4; generation++ {
+			h.Publish(generation, 1)
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		Eventually(rec.events, time.Second).Should(HaveLen(2))
+		Expect(rec.events()[1]).To(Equal("event: resync\ngeneration: 4\n\n\n"))
+
+		stats, subscribers := h.Stats()
+		Expect(subscribers).To(Equal(1))
+		Expect(stats.Notified).To(Equal(uint64(4)))
+		Expect(stats.Dropped).To(Equal(uint64(2)))
+		Expect(stats.Resyncs).To(Equal(uint64(1)))
+	})
+
+	It("should stop delivering after unsubscribe", func() {
+		h = newHub(5*time.Millisecond, 5*time.Millisecond, 0, eventQueueSize)
+		rec = &eventRecorder{}
+		h.Subscribe(rec)
+		h.Unsubscribe(rec)
+
+		h.Publish(1, 1)
+		Consistently(rec.events, 100*time.Millisecond).Should(BeEmpty())
+		_, subscribers := h.Stats()
+		Expect(subscribers).To(BeZero())
+	})
+})
+
+var _ = Describe("subscribe", func() {
+	var (
+		srv        *session
+		tmpDir     string
+		clientConn net.Conn
+		reader     *bufio.Reader
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(tmpDir)
+		// Wide enough for the reindexes below to land in one window
+		srv.hub = newHub(500*time.Millisecond, 10*time.Millisecond, 0, eventQueueSize)
+		bus := events.NewBus()
+		srv.listen(bus)
+		srv.indexer.SetBus(bus)
+
+		var serverConn net.Conn
+		clientConn, serverConn = net.Pipe()
+		srv.connWg.Add(1)
+		go srv.handleConnection(serverConn)
+
+		_, err = clientConn.Write([]byte("TXT01"))
+		Expect(err).NotTo(HaveOccurred())
+		reader = bufio.NewReader(clientConn)
+	})
+
+	AfterEach(func() {
+		clientConn.Close()
+		srv.connWg.Wait()
+		srv.hub.Close()
+		srv.bus.Close()
+		srv.runIndex.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	send := func(req string) string {
+		_, err := clientConn.Write([]byte(req))
+		Expect(err).NotTo(HaveOccurred())
+		return readFrame(reader)
+	}
+
+	It("should push one event carrying the generation of a reindex burst", func() {
+		binDir := filepath.Join(tmpDir, "bin")
+		Expect(os.MkdirAll(binDir, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(binDir, "tool"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+		Expect(send("subscribe\n")).To(Equal("cmd: subscribe\nstatus: 0\ngeneration: 0\n\n\n"))
+
+		for range 3 {
+			_, err := srv.indexer.Reindex(context.Background(), []string{binDir})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(srv.indexer.Generation()).To(Equal(uint64(3)))
+
+		event := readFrame(reader)
+		Expect(event).To(HavePrefix("event: index-updated\ngeneration: 3\n"))
+
+		stats := send("stats\n")
+		Expect(stats).To(ContainSubstring("generation: 3\n"))
+		Expect(stats).To(ContainSubstring("subscribers: 1\n"))
+		Expect(stats).To(ContainSubstring("events-published: 3\n"))
+		Expect(stats).To(ContainSubstring("events-sent: 1\n"))
+
+		Expect(send("unsubscribe\n")).To(Equal("cmd: unsubscribe\nstatus: 0\n\n\n"))
+		Expect(send("stats\n")).To(ContainSubstring("subscribers: 0\n"))
+	})
+
+	It("should drop the subscription when the connection closes", func() {
+		send("subscribe\n")
+		clientConn.Close()
+		srv.connWg.Wait()
+
+		_, subscribers := srv.hub.Stats()
+		Expect(subscribers).To(BeZero())
+	})
+})
+
+var _ = Describe("stats", func() {
+	var (
+		srv    *session
+		tmpDir string
+		pid    int
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(tmpDir)
+		pid = 0
+	})
+
+	AfterEach(func() {
+		if pid > 0 {
+			srv.launcher.Kill(pid)
+		}
+		srv.runIndex.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	stats := func(args ...parser.Value) string {
+		var buf bytes.Buffer
+		srv.handleStats(&mockConn{writeBuf: &buf}, &parser.Command{Name: "stats", Args: args})
+		return buf.String()
+	}
+	children := parser.Value{Type: parser.TypeString, Str: "children"}
+
+	It("should list running children and not the finished ones", func() {
+		Expect(stats()).To(ContainSubstring("running-children: 0\n"))
+
+		proc, err := srv.launcher.Start(1, "Text Editor", []string{"sleep", "5"})
+		Expect(err).NotTo(HaveOccurred())
+		pid = proc.PID
+		_, err = srv.launcher.Start(2, "quick", []string{"/bin/sh", "-c", "exit 0"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() string { return stats(children) }).Should(HaveSuffix(
+			"running-children: 1\nlen: 1\n\nbody:\n" + strconv.Itoa(pid) + " Text Editor\n\n\n"))
+		Expect(stats()).To(HaveSuffix("running-children: 1\n\n\n"))
+	})
+
+	It("should report the filters set and the memory they take", func() {
+		Expect(stats()).To(ContainSubstring("filters: 0\nfilter-bytes: 0\n"))
+
+		srv.filters.nameFilters = []FilterExpr{{Values: []string{"fire", "fox"}, Op: orOp}}
+		srv.filters.excludeCats = []string{"Game"}
+		size := filterExprOverhead + 2*filterValueOverhead + len("firefox") + filterValueOverhead + len("Game")
+		Expect(stats()).To(ContainSubstring("filters: 2\nfilter-bytes: " + strconv.Itoa(size) + "\n"))
+	})
+
+	It("should reject other arguments", func() {
+		Expect(stats(parser.Value{Type: parser.TypeString, Str: "all"})).To(ContainSubstring("error: invalid argument\n"))
+	})
+})
+
+var _ = Describe("idle timeout", func() {
+	var (
+		srv        *session
+		tmpDir     string
+		clientConn net.Conn
+		reader     *bufio.Reader
+		done       chan struct{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(tmpDir)
+		srv.idle = 150 * time.Millisecond
+		srv.hub = newHub(5*time.Millisecond, 5*time.Millisecond, 40*time.Millisecond, eventQueueSize)
+
+		var serverConn net.Conn
+		clientConn, serverConn = net.Pipe()
+		srv.connWg.Add(1)
+		done = make(chan struct{})
+		go func(srv *session, closed chan struct{}) {
+			srv.handleConnection(serverConn)
+			close(closed)
+		}(srv, done)
+
+		_, err = clientConn.Write([]byte("TXT01"))
+		Expect(err).NotTo(HaveOccurred())
+		reader = bufio.NewReader(clientConn)
+	})
+
+	AfterEach(func() {
+		clientConn.Close()
+		srv.connWg.Wait()
+		srv.hub.Close()
+		srv.runIndex.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("should keep a subscriber alive with heartbeats", func() {
+		_, err := clientConn.Write([]byte("subscribe\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readFrame(reader)).To(HavePrefix("cmd: subscribe\nstatus: 0\n"))
+
+		deadline := time.Now().Add(3 * srv.idle)
+		for time.Now().Before(deadline) {
+			Expect(readFrame(reader)).To(MatchRegexp(`^heartbeat: \d+\n\n\n$`))
+		}
+		Expect(done).NotTo(BeClosed())
+
+		_, err = clientConn.Write([]byte("stats\n"))
+		Expect(err).NotTo(HaveOccurred())
+		stats := readFrame(reader)
+		for strings.HasPrefix(stats, "heartbeat:") {
+			stats = readFrame(reader)
+		}
+		Expect(stats).To(ContainSubstring("subscribers: 1\n"))
+		Expect(stats).To(MatchRegexp(`heartbeats-sent: [1-9]\d*\n`))
+	})
+
+	It("should close a connection that stays idle", func() {
+		_, err := clientConn.Write([]byte("stats\n"))
+		Expect(err).NotTo(HaveOccurred())
+		readFrame(reader)
+
+		Eventually(done, time.Second).Should(BeClosed())
+		_, err = reader.ReadByte()
+		Expect(err).To(MatchError(io.EOF))
+	})
+})
+
+// readFrame reads one reply and returns it without the protocol header
+func readFrame(reader *bufio.Reader) string {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(reader, header)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(string(header)).To(Equal("TXT01"))
+
+	var sb strings.Builder
+	for !strings.HasSuffix(sb.String(), "\n\n\n") {
+		line, err := reader.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+// touchActive records client activity, which postpones the idle exit
+func (s *Server) touchActive() {
+	s.lastActive.Store(timeNow().UnixNano())
+}
+
+// Idle returns a channel closed once the server has had no client connected and ran no
+// command for ADE_INDEXD_IDLE_EXIT. The daemon then shuts down like on SIGTERM, to be
+// started again on demand. It is never closed with idle exit disabled.
+func (s *Server) Idle() <-chan struct{} {
+	if s.idleExit <= 0 {
+		return nil
+	}
+	return s.idleDone
+}
+
+// exitWhenIdle closes idleDone once the server was idle for idleExit, or returns when done
+// is closed. Open connections keep the server busy, subscribers waiting for events too.
+func (s *Server) exitWhenIdle(done <-chan struct{}) {
+	s.touchActive()
+	timer := time.NewTimer(s.idleExit)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		s.mu.RLock()
+		conns := len(s.conns)
+		s.mu.RUnlock()
+		idleFor := timeNow().Sub(time.Unix(0, s.lastActive.Load()))
+		if conns == 0 && idleFor >= s.idleExit {
+			log.Printf("[DEBUG] Idle for %v, shutting down", idleFor.Round(time.Millisecond))
+			close(s.idleDone)
+			return
+		}
+
+		wait := s.idleExit - idleFor
+		if conns > 0 || wait <= 0 {
+			wait = s.idleExit
+		}
+		timer.Reset(wait)
+	}
+}
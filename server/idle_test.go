@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("idle exit", func() {
+	const window = 100 * time.Millisecond
+
+	var (
+		srv        *session
+		socketPath string
+		serverErr  chan error
+	)
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		srv = newTestServer(dir)
+		srv.idleExit = window
+		srv.idleDone = make(chan struct{})
+		socketPath = filepath.Join(dir, "indexd")
+		listener, err := net.Listen("unix", socketPath)
+		Expect(err).NotTo(HaveOccurred())
+		srv.listener = listener
+
+		serverErr = make(chan error, 1)
+		go func() {
+			serverErr <- srv.Start(context.Background())
+		}()
+	})
+
+	AfterEach(func() {
+		Expect(srv.Stop()).To(Succeed())
+		Eventually(serverErr).Should(Receive(BeNil()))
+	})
+
+	// command sends a command on a new connection and waits for its reply
+	command := func(name string) {
+		conn, err := net.Dial("unix", socketPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		_, err = conn.Write([]byte("TXT01" + name + "\n"))
+		Expect(err).NotTo(HaveOccurred())
+		buf := make([]byte, 256)
+		_, err = conn.Read(buf)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("should signal shutdown once nothing happened for the window", func() {
+		started := time.Now()
+		Eventually(srv.Idle(), 2*time.Second).Should(BeClosed())
+		Expect(time.Since(started)).To(BeNumerically(">=", window))
+		// The daemon then stops like on SIGTERM, see AfterEach
+	})
+
+	It("should wait while commands keep coming", func() {
+		deadline := time.Now().Add(3 * window)
+		for time.Now().Before(deadline) {
+			command("capabilities")
+			Expect(srv.Idle()).NotTo(BeClosed())
+			time.Sleep(window / 4)
+		}
+		Eventually(srv.Idle(), 2*time.Second).Should(BeClosed())
+	})
+
+	It("should wait while a client stays connected", func() {
+		conn, err := net.Dial("unix", socketPath)
+		Expect(err).NotTo(HaveOccurred())
+		Consistently(srv.Idle(), 3*window).ShouldNot(BeClosed())
+
+		conn.Close()
+		Eventually(srv.Idle(), 2*time.Second).Should(BeClosed())
+	})
+
+	It("should never signal with idle exit disabled", func() {
+		var disabled Server
+		Expect(disabled.Idle()).To(BeNil())
+	})
+})
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// User data that must only reach log.Printf through the redact package: fields holding
+// client arguments and entry details, and the variables handlers keep them in
+var (
+	sensitiveFields = []string{"Args", "Str", "Values", "Exec", "Names"}
+	sensitiveEntry  = []string{"Name", "Path"}
+	sensitiveVars   = []string{"argv", "file", "path", "paths", "expandedPaths", "cats", "value", "query", "target", "desc"}
+)
+
+// rawLogArgs returns the arguments of log.Printf calls in file using user data without
+// redaction, as "<line>: <expr>". Lengths are fine, and so is entryRef, which redacts keys.
+func rawLogArgs(fset *token.FileSet, file *ast.File) []string {
+	var found []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || types.ExprString(call.Fun) != "log.Printf" {
+			return true
+		}
+		for _, arg := range call.Args[1:] {
+			ast.Inspect(arg, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.CallExpr:
+					fun := types.ExprString(n.Fun)
+					return fun != "len" && fun != "entryRef" && !strings.HasPrefix(fun, "redact.")
+				case *ast.SelectorExpr:
+					if slices.Contains(sensitiveFields, n.Sel.Name) ||
+						(types.ExprString(n.X) == "entry" && slices.Contains(sensitiveEntry, n.Sel.Name)) {
+						found = append(found, fmt.Sprintf("%d: %s", fset.Position(n.Pos()).Line, types.ExprString(n)))
+						return false
+					}
+				case *ast.Ident:
+					if slices.Contains(sensitiveVars, n.Name) {
+						found = append(found, fmt.Sprintf("%d: %s", fset.Position(n.Pos()).Line, n.Name))
+					}
+				}
+				return true
+			})
+		}
+		return true
+	})
+	return found
+}
+
+var _ = Describe("logging of user data", func() {
+	It("should redact client strings and command lines in every handler", func() {
+		files, err := filepath.Glob("*.go")
+		Expect(err).NotTo(HaveOccurred())
+		fset := token.NewFileSet()
+		var found []string
+		for _, name := range files {
+			if strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			src, err := os.ReadFile(name)
+			Expect(err).NotTo(HaveOccurred())
+			file, err := parser.ParseFile(fset, name, src, 0)
+			Expect(err).NotTo(HaveOccurred())
+			for _, raw := range rawLogArgs(fset, file) {
+				found = append(found, name+":"+raw)
+			}
+		}
+		Expect(found).To(BeEmpty(), "log these through the redact package")
+	})
+
+	It("should catch raw and accept redacted use", func() {
+		src := `package server
+
+func f() {
+	log.Printf("%v %s %s %d", cmd.Args, entry.Name, redact.String(entry.Exec), len(argv))
+	log.Printf("%v", argv)
+}`
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "f.go", src, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rawLogArgs(fset, file)).To(Equal([]string{"4: cmd.Args", "4: entry.Name", "5: argv"}))
+	})
+})
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/parser"
+)
+
+// reqOpt, pushed before the other arguments, tags a request with an id of the client's
+// choice, echoed in the reply so it can be matched with the request
+const reqOpt = "req:"
+
+// requestConn is the connection of a tagged request: replies written to it carry the tag
+type requestConn struct {
+	net.Conn
+	id string
+}
+
+// takeRequestID returns the request id cmd starts with and the command without it. ok is
+// false when cmd isn't tagged.
+func takeRequestID(cmd *parser.Command) (id string, rest *parser.Command, ok bool) {
+	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeString || !strings.HasPrefix(cmd.Args[0].Str, reqOpt) {
+		return "", cmd, false
+	}
+	id = strings.TrimSpace(strings.TrimPrefix(cmd.Args[0].Str, reqOpt))
+	return id, &parser.Command{Name: cmd.Name, Args: cmd.Args[1:]}, true
+}
+
+// baseConn returns the client connection behind conn, the one events are delivered to
+func baseConn(conn net.Conn) net.Conn {
+	if rc, ok := conn.(*requestConn); ok {
+		return rc.Conn
+	}
+	return conn
+}
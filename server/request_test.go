@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("request ids", func() {
+	var srv *session
+
+	BeforeEach(func() {
+		srv = newTestServer(GinkgoT().TempDir())
+		srv.indexer.GetIndex().Add(&indexer.Entry{Name: "Files", Path: "/usr/bin/files"})
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+	})
+
+	send := func(conn *mockConn, lines ...string) string {
+		srv.executeCommand(conn, parseRequest(lines...))
+		return conn.writeBuf.String()
+	}
+
+	It("should echo the id of a tagged request first", func() {
+		reply := send(&mockConn{writeBuf: &bytes.Buffer{}}, `"req: a-17`, "list")
+		Expect(reply).To(HavePrefix("TXT01req: a-17\nlen: 1\n"))
+	})
+
+	It("should leave untagged replies alone", func() {
+		Expect(send(&mockConn{writeBuf: &bytes.Buffer{}}, "list")).To(HavePrefix("TXT01len: 1\n"))
+	})
+
+	It("should tag error replies and combine with the other options", func() {
+		reply := send(&mockConn{writeBuf: &bytes.Buffer{}}, `"req: 3`, "-1", "list-next")
+		Expect(reply).To(HavePrefix("TXT01req: 3\n"))
+		Expect(reply).To(ContainSubstring("error: invalid offset\n"))
+
+		reply = send(&mockConn{writeBuf: &bytes.Buffer{}}, `"req: 4`, `"`+dryRunOpt, "1", "pin")
+		Expect(reply).To(HavePrefix("TXT01req: 4\ncmd: pin\n"))
+		Expect(reply).To(ContainSubstring("dry-run: t\n"))
+
+		reply = send(&mockConn{writeBuf: &bytes.Buffer{}}, `"req: 5`, "lang")
+		Expect(reply).To(HavePrefix("TXT01req: 5\nerror-cmd: lang\n"))
+	})
+
+	It("should refuse an empty id", func() {
+		reply := send(&mockConn{writeBuf: &bytes.Buffer{}}, `"req: `, "list")
+		Expect(reply).To(ContainSubstring("error: invalid request id\n"))
+	})
+
+	It("should subscribe the connection of a tagged request", func() {
+		var buf bytes.Buffer
+		conn := &mockConn{writeBuf: &buf}
+		Expect(send(conn, `"req: s`, "subscribe")).To(HavePrefix("TXT01req: s\ncmd: subscribe\n"))
+		_, subscribers := srv.hub.Stats()
+		Expect(subscribers).To(Equal(1))
+
+		srv.hub.Unsubscribe(conn)
+		_, subscribers = srv.hub.Stats()
+		Expect(subscribers).To(BeZero(), "the connection itself should be subscribed")
+	})
+})
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/redact"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+func boolAttr(v bool) string {
+	if v {
+		return "t"
+	}
+	return "f"
+}
+
+// writeResponse writes a reply. Tagged requests get their tag back as the first attribute.
+func (s *Server) writeResponse(conn net.Conn, resp *response.Response) {
+	if rc, ok := conn.(*requestConn); ok {
+		resp.Set("req", rc.id)
+	}
+	// One write per reply, so a subscriber event can't get in between header and attrs
+	n, err := resp.WriteTo(conn)
+	if err != nil {
+		log.Printf("[ERROR] Failed to write response: %v", err)
+		return
+	}
+	log.Printf("[DEBUG] Response written successfully: %d bytes", n)
+}
+
+// streamChunk is the size of the writes of a streamed reply
+const streamChunk = 64 << 10
+
+// writeStream writes a reply whose body is too large to be built first, like that of
+// export-index: the head of resp, up to the body marker, then what body writes, then the
+// end of the reply. The body is written as it comes, in writes of streamChunk bytes, and
+// must be made of lines of valid UTF-8. Events wait until the whole reply is written.
+func (s *Server) writeStream(conn net.Conn, resp *response.Response, body func(w *bufio.Writer) error) {
+	if rc, ok := conn.(*requestConn); ok {
+		resp.Set("req", rc.id)
+	}
+	var w io.Writer = conn
+	if sc, ok := baseConn(conn).(*syncConn); ok {
+		held, release := sc.hold()
+		defer release()
+		w = held
+	}
+
+	buf := bufio.NewWriterSize(w, streamChunk)
+	buf.WriteString(response.Header + resp.Head())
+	err := body(buf)
+	if err == nil {
+		buf.WriteString("\n\n")
+		err = buf.Flush()
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to write response: %v", err)
+		return
+	}
+	log.Printf("[DEBUG] Streamed response written successfully")
+}
+
+// writeError reports a failed command. The parsed arguments are echoed back and the usage
+// line of known commands is added as a hint, so clients can see what the server received.
+func (s *Server) writeError(conn net.Conn, cmd *parser.Command, status response.Status, errType, desc string) {
+	// Descriptions may quote what the client sent
+	log.Printf("[ERROR] Writing error response: cmd=%s, type=%s, desc=%s", cmd.Name, errType, redact.String(desc))
+	s.writeResponse(conn, errorResponse(cmd, status, errType, desc))
+}
+
+// errorResponse builds the reply writeError sends, for errors that carry a body
+func errorResponse(cmd *parser.Command, status response.Status, errType, desc string) *response.Response {
+	resp := response.Error(cmd.Name, status, errType, desc)
+	if len(cmd.Args) > 0 {
+		resp.Set("args", argsEcho(cmd.Args))
+	}
+	if c, ok := commands[cmd.Name]; ok {
+		resp.Set("hint", c.usage)
+	}
+	return resp
+}
+
+// argsEcho formats arguments as a type-tagged list, e.g. `str:"firefox" int:42 op:or`
+func argsEcho(args []parser.Value) string {
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch {
+		case arg.Type == parser.TypeString:
+			parts = append(parts, "str:"+strconv.Quote(response.Sanitize(arg.Str)))
+		case arg.Type == parser.TypeInt:
+			parts = append(parts, "int:"+strconv.FormatInt(arg.Int, 10))
+		case arg.Type == parser.TypeBool && arg.Str != "":
+			parts = append(parts, "op:"+arg.Str)
+		default:
+			parts = append(parts, "bool:"+boolAttr(arg.Bool))
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/redact"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// Match scores of a query word, the best one found in an entry counts
+const (
+	scoreNameWordPrefix = 3 // a word of a name starts with it
+	scoreName           = 2 // contained in a name
+	scoreKeyword        = 1 // contained in a generic name or keyword
+)
+
+// Bonuses for names matching the whole query
+const (
+	scoreExactName  = 10
+	scoreNamePrefix = 5
+)
+
+// topOpt is the search option keeping only the best-ranked matches, e.g. for an autocomplete
+// dropdown
+const topOpt = "top: "
+
+// handleSearch lists the entries matching a query in one go. The standing filters are
+// neither applied nor changed.
+func (s *session) handleSearch(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling search command")
+
+	// The "top: <n> option comes before the query
+	top := 0
+	args := cmd.Args
+	if len(args) > 0 && args[0].Type == parser.TypeString {
+		if value, ok := strings.CutPrefix(args[0].Str, topOpt); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || n <= 0 {
+				log.Printf("[ERROR] search command with invalid top %s", redact.Quote(value))
+				s.writeError(conn, cmd, response.StatusBadArgument, "invalid top", "top must be a positive integer")
+				return
+			}
+			top = n
+			args = args[1:]
+		}
+	}
+
+	if len(args) == 0 || args[0].Type != parser.TypeString {
+		log.Printf("[ERROR] search command missing query")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing query", "search command requires a query string")
+		return
+	}
+	query := strings.ToLower(strings.TrimSpace(args[0].Str))
+	if query == "" {
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing query", "search query is empty")
+		return
+	}
+
+	limit := config.Get().ListLimit()
+	if len(args) >= 2 {
+		if args[1].Type != parser.TypeInt || args[1].Int <= 0 {
+			s.writeError(conn, cmd, response.StatusBadArgument, "invalid limit", "limit must be a positive integer")
+			return
+		}
+		limit = int(args[1].Int)
+	}
+
+	words := strings.Fields(query)
+	scores := make(map[int64]int)
+	var matches []*indexer.Entry
+	for _, entry := range s.indexer.GetIndex().GetAll() {
+		if score := searchScore(entry, query, words); score > 0 {
+			scores[entry.ID] = score
+			matches = append(matches, entry)
+		}
+	}
+
+	// Best matches first, equally good ones in list order
+	s.sortEntries(matches, s.sortOrder)
+	sort.SliceStable(matches, func(i, j int) bool {
+		return scores[matches[i].ID] > scores[matches[j].ID]
+	})
+
+	fullLen := len(matches)
+	resp := response.OK("search").Setf("len", "%d", fullLen)
+	// The weak matches past the top ones aren't wanted at all, unlike those past the limit
+	if top > 0 && len(matches) > top {
+		matches = matches[:top]
+		resp.Setf("top", "%d", top)
+	}
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+		resp.Setf("limited", "%d", limit)
+	}
+	s.setUpstreamAttr(resp)
+
+	resp.Body()
+	for _, entry := range matches {
+		resp.Body(fmt.Sprintf("%d %s", entry.ID, s.localizedName(entry)))
+	}
+
+	s.writeResponse(conn, resp)
+	log.Printf("[DEBUG] search response sent (matches: %d, shown: %d)", fullLen, len(matches))
+}
+
+// searchScore rates how well an entry matches a lowercased query. Every word of the query
+// has to be found in a name, generic name or keyword of the entry, 0 means no match.
+func searchScore(entry *indexer.Entry, query string, words []string) int {
+	names := []string{strings.ToLower(entry.Name)}
+	for _, name := range entry.Names {
+		names = append(names, strings.ToLower(name))
+	}
+	names = append(names, entry.Translit...)
+	// Renamed executables are still found by the name of their file
+	if entry.RawName != "" {
+		names = append(names, strings.ToLower(entry.RawName))
+	}
+	var keywords []string
+	if entry.GenericName != "" {
+		keywords = append(keywords, strings.ToLower(entry.GenericName))
+	}
+	for _, name := range entry.GenericNames {
+		keywords = append(keywords, strings.ToLower(name))
+	}
+	for _, keyword := range entry.Keywords {
+		keywords = append(keywords, strings.ToLower(keyword))
+	}
+
+	total := 0
+	for _, word := range words {
+		best := 0
+		for _, name := range names {
+			switch {
+			case hasWordPrefix(name, word):
+				best = max(best, scoreNameWordPrefix)
+			case strings.Contains(name, word):
+				best = max(best, scoreName)
+			}
+		}
+		if best == 0 {
+			for _, keyword := range keywords {
+				if strings.Contains(keyword, word) {
+					best = scoreKeyword
+					break
+				}
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+
+	for _, name := range names {
+		if name == query {
+			return total + scoreExactName
+		}
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, query) {
+			return total + scoreNamePrefix
+		}
+	}
+	return total
+}
+
+// hasWordPrefix reports whether a word of s starts with prefix
+func hasWordPrefix(s, prefix string) bool {
+	for word := range strings.FieldsFuncSeq(s, isWordSeparator) {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordSeparator(r rune) bool {
+	return r == ' ' || r == '-' || r == '_' || r == '.'
+}
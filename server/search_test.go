@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("handleSearch", func() {
+	var (
+		srv      *session
+		cacheDir string
+		ids      map[string]int64
+	)
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(cacheDir)
+
+		index := srv.indexer.GetIndex()
+		ids = make(map[string]int64)
+		for _, entry := range []*indexer.Entry{
+			{Name: "Firefox", Path: "/usr/share/applications/firefox.desktop", GenericName: "Web Browser", Keywords: []string{"Internet", "WWW"}, IsDesktop: true},
+			{Name: "firefox", Path: "/usr/bin/firefox"},
+			{Name: "GNU Image Manipulation Program", Path: "/usr/share/applications/gimp.desktop", Keywords: []string{"photo", "paint"}, IsDesktop: true},
+			{Name: "Files", Names: map[string]string{"de": "Dateien"}, Path: "/usr/share/applications/nautilus.desktop", Keywords: []string{"folder", "browser"}, IsDesktop: true},
+			{Name: "ffprobe", Path: "/usr/bin/ffprobe"},
+			{Name: "Терминал", Path: "/usr/share/applications/terminal.desktop", Translit: []string{"terminal"}, IsDesktop: true},
+		} {
+			ids[entry.Path] = index.Add(entry)
+		}
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+		os.RemoveAll(cacheDir)
+	})
+
+	search := func(args ...parser.Value) string {
+		var buf bytes.Buffer
+		srv.handleSearch(&mockConn{writeBuf: &buf}, &parser.Command{Name: "search", Args: args})
+		return buf.String()
+	}
+	query := func(q string) parser.Value {
+		return parser.Value{Type: parser.TypeString, Str: q}
+	}
+	bodyIDs := func(response string) []int64 {
+		_, body, _ := strings.Cut(response, "body:\n")
+		var result []int64
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			if line != "" {
+				id, err := strconv.ParseInt(strings.Fields(line)[0], 10, 64)
+				Expect(err).NotTo(HaveOccurred())
+				result = append(result, id)
+			}
+		}
+		return result
+	}
+
+	It("should rank name matches ahead of keyword matches", func() {
+		response := search(query("browser"))
+		Expect(response).To(HavePrefix("TXT01cmd: search\nstatus: 0\nlen: 2\n"))
+		// "Web Browser" and "browser" are both keywords, equally ranked in list order
+		Expect(bodyIDs(response)).To(ConsistOf(ids["/usr/share/applications/firefox.desktop"], ids["/usr/share/applications/nautilus.desktop"]))
+
+		response = search(query("fire"))
+		Expect(bodyIDs(response)).To(Equal([]int64{ids["/usr/share/applications/firefox.desktop"], ids["/usr/bin/firefox"]}))
+
+		Expect(bodyIDs(search(query("image prog")))).To(Equal([]int64{ids["/usr/share/applications/gimp.desktop"]}))
+		Expect(bodyIDs(search(query("dateien")))).To(Equal([]int64{ids["/usr/share/applications/nautilus.desktop"]}))
+		Expect(search(query("image browser"))).To(ContainSubstring("len: 0\n"))
+	})
+
+	It("should put exact name matches first", func() {
+		response := search(query("ff"))
+		Expect(bodyIDs(response)[0]).To(Equal(ids["/usr/bin/ffprobe"]))
+
+		response = search(query("files"))
+		Expect(bodyIDs(response)[0]).To(Equal(ids["/usr/share/applications/nautilus.desktop"]))
+	})
+
+	It("should limit the results", func() {
+		response := search(query("f"), parser.Value{Type: parser.TypeInt, Int: 2})
+		Expect(response).To(ContainSubstring("len: 4\n"))
+		Expect(response).To(ContainSubstring("limited: 2\n"))
+		Expect(bodyIDs(response)).To(HaveLen(2))
+	})
+
+	It("should keep the best matches when limited", func() {
+		index := srv.indexer.GetIndex()
+		best := []int64{
+			index.Add(&indexer.Entry{Name: "term", Path: "/usr/bin/term"}),
+			ids["/usr/share/applications/terminal.desktop"],
+			index.Add(&indexer.Entry{Name: "Term Tools", Path: "/usr/share/applications/termtools.desktop", IsDesktop: true}),
+			index.Add(&indexer.Entry{Name: "GNOME Terminal", Path: "/usr/share/applications/gnome-terminal.desktop", IsDesktop: true}),
+			index.Add(&indexer.Entry{Name: "xterm", Path: "/usr/bin/xterm"}),
+		}
+		for _, name := range []string{"urxvt", "kitty"} {
+			index.Add(&indexer.Entry{Name: name, Path: "/usr/share/applications/" + name + ".desktop", Keywords: []string{"terminal"}, IsDesktop: true})
+		}
+
+		response := search(query("term"), parser.Value{Type: parser.TypeInt, Int: 5})
+		Expect(response).To(ContainSubstring("len: 7\nlimited: 5\n"))
+		found := bodyIDs(response)
+		Expect(found).To(ConsistOf(best))
+		Expect(found[0]).To(Equal(best[0]))
+		Expect(found[3:]).To(Equal(best[3:]))
+	})
+
+	It("should keep the top matches only", func() {
+		index := srv.indexer.GetIndex()
+		best := []int64{
+			index.Add(&indexer.Entry{Name: "term", Path: "/usr/bin/term"}),
+			ids["/usr/share/applications/terminal.desktop"],
+			index.Add(&indexer.Entry{Name: "Term Tools", Path: "/usr/share/applications/termtools.desktop", IsDesktop: true}),
+			index.Add(&indexer.Entry{Name: "GNOME Terminal", Path: "/usr/share/applications/gnome-terminal.desktop", IsDesktop: true}),
+			index.Add(&indexer.Entry{Name: "xterm", Path: "/usr/bin/xterm"}),
+		}
+		for _, name := range []string{"urxvt", "kitty"} {
+			index.Add(&indexer.Entry{Name: name, Path: "/usr/share/applications/" + name + ".desktop", Keywords: []string{"terminal"}, IsDesktop: true})
+		}
+
+		response := search(query("top: 5"), query("term"))
+		Expect(response).To(ContainSubstring("len: 7\ntop: 5\n"))
+		Expect(response).NotTo(ContainSubstring("limited:"))
+		found := bodyIDs(response)
+		Expect(found).To(ConsistOf(best))
+		Expect(found[0]).To(Equal(best[0]))
+		Expect(found[3:]).To(Equal(best[3:]))
+
+		// The limit applies to the top matches
+		response = search(query("top: 5"), query("term"), parser.Value{Type: parser.TypeInt, Int: 2})
+		Expect(response).To(ContainSubstring("len: 7\ntop: 5\nlimited: 2\n"))
+		Expect(bodyIDs(response)).To(HaveLen(2))
+
+		Expect(search(query("top: 10"), query("term"))).NotTo(ContainSubstring("top:"))
+		for _, top := range []string{"top: 0", "top: -1", "top: many"} {
+			Expect(search(query(top), query("term"))).To(ContainSubstring("error: invalid top\nstatus: 2\n"), top)
+		}
+	})
+
+	It("should leave standing filters alone", func() {
+		srv.handleFilterNameReplace(&mockConn{}, &parser.Command{Name: "filter-name", Args: []parser.Value{query("gnu")}})
+		srv.handleFilterCat(&mockConn{}, &parser.Command{Name: "+filter-cat", Args: []parser.Value{query("Graphics")}})
+		nameFilters := srv.filters.nameFilters
+		catFilters := srv.filters.catFilters
+
+		Expect(bodyIDs(search(query("fire")))).To(HaveLen(2))
+
+		Expect(srv.filters.nameFilters).To(Equal(nameFilters))
+		Expect(srv.filters.catFilters).To(Equal(catFilters))
+		var buf bytes.Buffer
+		srv.handleList(&mockConn{writeBuf: &buf}, &parser.Command{Name: "list"})
+		Expect(buf.String()).To(HavePrefix("TXT01len: 0\n"))
+	})
+
+	It("should match names of other scripts by their Latin spelling", func() {
+		terminal := ids["/usr/share/applications/terminal.desktop"]
+		Expect(bodyIDs(search(query("Term")))).To(Equal([]int64{terminal}))
+		Expect(bodyIDs(search(query("терм")))).To(Equal([]int64{terminal}))
+
+		srv.handleFilterNameReplace(&mockConn{}, &parser.Command{Name: "filter-name", Args: []parser.Value{query("MINAL")}})
+		var buf bytes.Buffer
+		srv.handleList(&mockConn{writeBuf: &buf}, &parser.Command{Name: "list"})
+		Expect(buf.String()).To(ContainSubstring("\nbody:\n" + itoa(terminal) + " Терминал\n"))
+	})
+
+	It("should match renamed executables by the name of their file", func() {
+		index := srv.indexer.GetIndex()
+		python := index.Add(&indexer.Entry{Name: "Python 3.12", RawName: "python3.12", Path: "/usr/bin/python3.12"})
+		index.Add(&indexer.Entry{Name: "python3.12-config", Path: "/usr/bin/python3.12-config"})
+		Expect(bodyIDs(search(query("python3.12")))[0]).To(Equal(python))
+
+		srv.handleFilterNameReplace(&mockConn{}, &parser.Command{Name: "filter-name", Args: []parser.Value{query("3.12")}})
+		var buf bytes.Buffer
+		srv.handleList(&mockConn{writeBuf: &buf}, &parser.Command{Name: "list"})
+		Expect(buf.String()).To(ContainSubstring("\n" + itoa(python) + " Python 3.12\n"))
+
+		buf.Reset()
+		srv.handleInfo(&mockConn{writeBuf: &buf}, &parser.Command{Name: "info", Args: []parser.Value{{Type: parser.TypeInt, Int: python}}})
+		Expect(buf.String()).To(ContainSubstring("name: Python 3.12\nraw-name: python3.12\n"))
+	})
+
+	It("should reject a missing query and a bad limit", func() {
+		Expect(search()).To(ContainSubstring("error: missing query\n"))
+		Expect(search(query("  "))).To(ContainSubstring("error: missing query\n"))
+		Expect(search(query("fire"), parser.Value{Type: parser.TypeInt, Int: 0})).To(ContainSubstring("error: invalid limit\n"))
+	})
+})
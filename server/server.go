@@ -1,23 +1,29 @@
 package server
 
 import (
-	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
+	"time"
 
 	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/events"
 	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/indexer/desktop"
+	"github.com/0xADE/ade-ctld/internal/launcher"
+	"github.com/0xADE/ade-ctld/internal/redact"
+	"github.com/0xADE/ade-ctld/internal/retention"
 	"github.com/0xADE/ade-ctld/internal/runindex"
 	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
 )
 
 const (
@@ -26,29 +32,58 @@ const (
 	notOp = "not"
 )
 
+// For testing purposes - allow overriding the clock and the source of session tokens, so
+// replies can be compared byte for byte
+var (
+	timeNow  = time.Now
+	randRead = rand.Read
+)
+
+// Scanner is a source of index entries, see RegisterScanner
+type Scanner = indexer.Scanner
+
+// IndexEntry is an application entry sent by a Scanner
+type IndexEntry = indexer.Entry
+
+// RegisterScanner adds a source of entries to the indexers created afterwards, scanning
+// roots on every run besides the roots configured with "scan <name> <root>" rc lines.
+// Embedders call it from init. It panics if the scanner name is taken.
+func RegisterScanner(s Scanner, roots ...string) {
+	indexer.RegisterScanner(s, roots...)
+}
+
 // Server handles Unix socket connections and command execution
 type Server struct {
 	listener net.Listener
 	indexer  *indexer.Indexer
 	runIndex *runindex.RunIndex
+	launcher *launcher.Launcher
 	running  bool
+	stopped  bool
 	mu       sync.RWMutex
-	filters  *Filters
-	lang     string
-}
-
-// Filters stores current filter settings
-type Filters struct {
-	mu          sync.RWMutex
-	nameFilters []FilterExpr
-	catFilters  []FilterExpr
-	pathFilters []FilterExpr
-}
-
-// FilterExpr represents a filter expression
-type FilterExpr struct {
-	Values []string
-	Op     string // orOp, andOp, notOp
+	conns    map[net.Conn]struct{}
+	connWg   sync.WaitGroup
+	hub      *hub
+	bus      *events.Bus   // internal events, bridged to hub for clients
+	idle     time.Duration // connection idle timeout, 0 disables it
+	idleExit time.Duration // shut down after this long without clients, 0 disables it
+	idleDone chan struct{} // closed once idle for idleExit, see Idle
+
+	inspectWrappers bool              // which resolves trivial wrapper scripts to their target
+	autoIndexing    atomic.Bool       // a reindex started by list on a never built index is running
+	sortOrder       string            // order of the lists of new sessions, see parseSortOrder
+	logDir          string            // audit and application logs, kept in check by gc
+	lastGC          atomic.Int64      // unix nanoseconds of the last log collection
+	collecting      atomic.Bool       // a log collection is under way
+	utf8Policy      parser.UTF8Policy // what the parser does with invalid UTF-8 in strings
+	lastActive      atomic.Int64      // unix nanoseconds of the last connection or command
+	refuseChanged   bool              // run refuses binaries changed since they were indexed
+	runSelf         bool              // run launches the daemon's own binary, see ADE_INDEXD_RUN_SELF
+	system          bool              // serves all users read-only, see upstream.go
+	maxFilters      int               // filter expressions of each kind a client may set, 0 for no limit
+	maxFilterBytes  int               // approximate memory all filters may take, 0 for no limit
+	debounce        *runDebounce      // runs repeated within ADE_INDEXD_RUN_DEBOUNCE_MS
+	upstream        *upstream         // system daemon mirrored, nil if everything is indexed here
 }
 
 // NewServer creates a new server instance
@@ -56,521 +91,195 @@ func NewServer(idx *indexer.Indexer) (*Server, error) {
 	cfg := config.Get()
 	socketPath := cfg.UnixSocket()
 
-	// Create directory if needed
-	socketDir := filepath.Dir(socketPath)
-	if err := os.MkdirAll(socketDir, 0750); err != nil {
-		return nil, err
-	}
-
-	// Remove existing socket if it exists
-	os.Remove(socketPath)
-
-	listener, err := net.Listen("unix", socketPath)
+	launchMode, err := launcher.ParseMode(cfg.LaunchMode())
 	if err != nil {
 		return nil, err
 	}
-
-	// Initialize run index
-	runIdx, err := runindex.NewRunIndex()
+	sortOrder, err := parseSortOrder(cfg.SortOrder())
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize run index: %w", err)
+		return nil, fmt.Errorf("ADE_INDEXD_SORT: %w", err)
 	}
-
-	return &Server{
-		listener: listener,
-		indexer:  idx,
-		runIndex: runIdx,
-		filters:  &Filters{},
-		lang:     "en",
-	}, nil
-}
-
-// Start starts the server
-func (s *Server) Start(ctx context.Context) error {
-	s.mu.Lock()
-	s.running = true
-	s.mu.Unlock()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		conn, err := s.listener.Accept()
-		if err != nil {
-			s.mu.RLock()
-			running := s.running
-			s.mu.RUnlock()
-			if !running {
-				return nil
-			}
-			continue
-		}
-
-		go s.handleConnection(conn)
+	utf8Policy, err := parser.ParseUTF8Policy(cfg.InvalidUTF8())
+	if err != nil {
+		return nil, fmt.Errorf("ADE_INDEXD_INVALID_UTF8: %w", err)
 	}
-}
-
-// Stop stops the server
-func (s *Server) Stop() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.running = false
-	return s.listener.Close()
-}
-
-func (s *Server) handleConnection(conn net.Conn) {
-	defer conn.Close()
-
-	log.Printf("[DEBUG] New connection accepted")
-
-	p, err := parser.NewParser(conn)
+	refuseChanged, err := parseChangedBinary(cfg.ChangedBinary())
 	if err != nil {
-		log.Printf("[ERROR] Failed to create parser: %v", err)
-		s.writeError(conn, "parser", "invalid header", err.Error())
-		return
+		return nil, fmt.Errorf("ADE_INDEXD_CHANGED_BINARY: %w", err)
 	}
 
-	for {
-		cmd, err := p.ParseCommand()
-		if err == io.EOF {
-			log.Printf("[DEBUG] Connection closed by client")
-			break
-		}
-		if err != nil {
-			log.Printf("[ERROR] Parse error: %v", err)
-			s.writeError(conn, "parser", "parse error", err.Error())
-			continue
-		}
-
-		log.Printf("[DEBUG] Executing command: %s with %d args", cmd.Name, len(cmd.Args))
-		s.executeCommand(conn, cmd)
+	if cfg.System() && cfg.Upstream() != "" {
+		return nil, errors.New("ADE_INDEXD_UPSTREAM: the system daemon (ADE_INDEXD_SYSTEM) has no upstream")
 	}
-}
 
-func (s *Server) executeCommand(conn net.Conn, cmd *parser.Command) {
-	switch cmd.Name {
-	case "filter-name":
-		s.handleFilterNameReplace(conn, cmd)
-	case "+filter-name":
-		s.handleAddFilterName(conn, cmd)
-	case "+filter-cat":
-		s.handleFilterCat(conn, cmd)
-	case "+filter-path":
-		s.handleFilterPath(conn, cmd)
-	case "0filters":
-		s.handleResetFilters(conn)
-	case "list":
-		s.handleList(conn)
-	case "list-next":
-		s.handleListNext(conn, cmd)
-	case "run":
-		s.handleRun(conn, cmd)
-	case "lang":
-		s.handleLang(conn, cmd)
-	case "reindex":
-		s.handleReindex(conn, cmd)
-	default:
-		s.writeError(conn, cmd.Name, "unknown command", "Command not recognized")
+	// The run index and the logs are kept in the cache directory
+	cacheDir, err := cfg.CacheDir()
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (s *Server) handleFilterNameReplace(conn net.Conn, cmd *parser.Command) {
-	log.Printf("[DEBUG] Handling filter-name command")
-	s.filters.mu.Lock()
-	defer s.filters.mu.Unlock()
-
-	expr := FilterExpr{Values: []string{}, Op: andOp}
-	for _, arg := range cmd.Args {
-		switch arg.Type {
-		case parser.TypeString:
-			expr.Values = append(expr.Values, arg.Str)
-		case parser.TypeBool:
-			// Check the original string to distinguish between and/not
-			if arg.Str == notOp {
-				expr.Op = notOp
-			} else if arg.Str == orOp || arg.Bool {
-				expr.Op = orOp
-			} else {
-				expr.Op = andOp
-			}
-		}
+	listener, err := listenSocket(socketPath, cfg.System())
+	if err != nil {
+		return nil, err
 	}
 
-	if len(expr.Values) > 0 {
-		s.filters.nameFilters = []FilterExpr{expr}
-		log.Printf("[DEBUG] Replaced name filters with: %v (op: %s)", expr.Values, expr.Op)
-	} else {
-		s.filters.nameFilters = []FilterExpr{}
-		log.Printf("[DEBUG] Cleared name filters")
+	// Initialize run index. Without it, e.g. with the database locked by another daemon or
+	// on a read-only home, lists and runs are still served, only frequencies aren't tracked.
+	// The system daemon runs nothing, run counts and pins are kept by the users' daemons.
+	var runIdx *runindex.RunIndex
+	if !cfg.System() {
+		runIdx, err = runindex.NewRunIndexWithCacheDir(cacheDir)
 	}
-
-	// Send success response (returns filter-name as per spec)
-	attrs := "cmd: filter-name\nstatus: 0\n\n\n"
-	s.writeResponse(conn, attrs)
-}
-
-func (s *Server) handleAddFilterName(conn net.Conn, cmd *parser.Command) {
-	log.Printf("[DEBUG] Handling +filter-name command")
-	s.filters.mu.Lock()
-	defer s.filters.mu.Unlock()
-
-	expr := FilterExpr{Values: []string{}, Op: orOp}
-	for _, arg := range cmd.Args {
-		switch arg.Type {
-		case parser.TypeString:
-			expr.Values = append(expr.Values, arg.Str)
-		case parser.TypeBool:
-			// Check the original string to distinguish between and/not
-			if arg.Str == notOp {
-				expr.Op = notOp
-			} else if arg.Str == "or" || arg.Bool {
-				expr.Op = orOp
-			} else {
-				expr.Op = andOp
-			}
+	if err != nil {
+		log.Printf("[WARN] Failed to initialize run index, run counts, pins and sessions are disabled: %v", err)
+		runIdx = nil
+	} else if runIdx != nil {
+		if _, err := runIdx.PruneSessions(timeNow()); err != nil {
+			log.Printf("[WARN] Failed to prune expired sessions: %v", err)
 		}
 	}
 
-	if len(expr.Values) > 0 {
-		s.filters.nameFilters = append(s.filters.nameFilters, expr)
-		log.Printf("[DEBUG] Added name filter: %v (op: %s)", expr.Values, expr.Op)
-	}
-
-	// Send success response
-	attrs := "cmd: +filter-name\nstatus: 0\n\n\n"
-	s.writeResponse(conn, attrs)
-}
-
-func (s *Server) handleFilterCat(conn net.Conn, cmd *parser.Command) {
-	log.Printf("[DEBUG] Handling filter-cat command")
-	s.filters.mu.Lock()
-	defer s.filters.mu.Unlock()
-
-	expr := FilterExpr{Values: []string{}, Op: andOp}
-	for _, arg := range cmd.Args {
-		switch arg.Type {
-		case parser.TypeString:
-			expr.Values = append(expr.Values, arg.Str)
-		case parser.TypeBool:
-			if arg.Bool {
-				expr.Op = orOp
-			} else {
-				expr.Op = andOp
-			}
-		}
-	}
+	redact.SetSensitive(cfg.LogSensitive())
 
-	if len(expr.Values) > 0 {
-		s.filters.catFilters = append(s.filters.catFilters, expr)
-		log.Printf("[DEBUG] Added cat filter: %v (op: %s)", expr.Values, expr.Op)
-	}
+	launch := launcher.New(launchMode)
+	launch.SetUmask(cfg.Umask())
 
-	// Send success response
-	attrs := "cmd: +filter-cat\nstatus: 0\n\n\n"
-	s.writeResponse(conn, attrs)
+	s := &Server{
+		listener: listener,
+		indexer:  idx,
+		runIndex: runIdx,
+		launcher: launch,
+		hub:      newHub(cfg.EventWindow(), cfg.EventInterval(), cfg.Heartbeat(), eventQueueSize),
+		idle:     cfg.IdleTimeout(),
+		idleExit: cfg.IdleExit(),
+		idleDone: make(chan struct{}),
+
+		inspectWrappers: cfg.InspectWrappers(),
+		sortOrder:       sortOrder,
+		logDir:          retention.Dir(cacheDir),
+		utf8Policy:      utf8Policy,
+		refuseChanged:   refuseChanged,
+		runSelf:         cfg.RunSelf(),
+		maxFilters:      cfg.MaxFilters(),
+		maxFilterBytes:  cfg.MaxFilterBytes(),
+		debounce:        newRunDebounce(cfg.RunDebounce()),
+		system:          cfg.System(),
+	}
+	if s.system {
+		idx.SetSplit(indexer.SplitSystem)
+	}
+	if socket := cfg.Upstream(); socket != "" {
+		s.setUpstream(socket)
+	}
+
+	bus := events.NewBus()
+	s.listen(bus)
+	idx.SetBus(bus)
+	cfg.SetBus(bus)
+	return s, nil
 }
 
-func (s *Server) handleFilterPath(conn net.Conn, cmd *parser.Command) {
-	log.Printf("[DEBUG] Handling filter-path command")
-	s.filters.mu.Lock()
-	defer s.filters.mu.Unlock()
-
-	expr := FilterExpr{Values: []string{}, Op: orOp}
-	for _, arg := range cmd.Args {
-		switch arg.Type {
-		case parser.TypeString:
-			expr.Values = append(expr.Values, arg.Str)
-		case parser.TypeBool:
-			if arg.Bool {
-				expr.Op = orOp
-			} else {
-				expr.Op = andOp
-			}
+// localizedName returns the entry name for the language of the session
+func (s *session) localizedName(entry *indexer.Entry) string {
+	if s.lang != "" && entry.Names != nil {
+		if locName, ok := entry.Names[s.lang]; ok {
+			return locName
 		}
 	}
-
-	if len(expr.Values) > 0 {
-		s.filters.pathFilters = append(s.filters.pathFilters, expr)
-		log.Printf("[DEBUG] Added path filter: %v (op: %s)", expr.Values, expr.Op)
-	}
-
-	// Send success response
-	attrs := "cmd: +filter-path\nstatus: 0\n\n\n"
-	s.writeResponse(conn, attrs)
+	return entry.Name
 }
 
-func (s *Server) handleResetFilters(conn net.Conn) {
-	log.Printf("[DEBUG] Resetting all filters")
-	s.filters.mu.Lock()
-	defer s.filters.mu.Unlock()
-	s.filters.nameFilters = []FilterExpr{}
-	s.filters.catFilters = []FilterExpr{}
-	s.filters.pathFilters = []FilterExpr{}
-
-	// Send success response
-	attrs := "cmd: 0filters\nstatus: 0\n\n\n"
-	s.writeResponse(conn, attrs)
+// execName returns the entry name for %c in Exec: translated for the language of the session,
+// falling back from a full locale like de_DE to its language
+func (s *session) execName(entry *indexer.Entry) string {
+	d := desktop.DesktopEntry{Name: entry.Name, Names: entry.Names}
+	return d.GetLocalizedName(s.lang)
 }
 
-func (s *Server) handleList(conn net.Conn) {
-	log.Printf("[DEBUG] Handling list command")
-
-	idx := s.indexer.GetIndex()
-	allEntries := idx.GetAll()
-
-	s.filters.mu.RLock()
-	filtered := s.filterEntries(allEntries)
-	s.filters.mu.RUnlock()
-
-	// Sort by run frequency (most frequent first)
-	s.sortByRunFrequency(filtered)
-
-	log.Printf("[DEBUG] Found %d entries after filtering (total: %d)", len(filtered), len(allEntries))
-
-	cfg := config.Get()
-	limit := cfg.ListLimit()
-	fullLen := len(filtered)
-
-	attrs := strings.Builder{}
-	attrs.WriteString(fmt.Sprintf("len: %d\n", fullLen))
-
-	// Apply limit if needed
-	var entriesToShow []*indexer.Entry
-	if len(filtered) > limit {
-		entriesToShow = filtered[:limit]
-		attrs.WriteString(fmt.Sprintf("limited: %d\n", limit))
-		attrs.WriteString("offset: 0\n")
-		attrs.WriteString(fmt.Sprintf("list-next: %d %d\n", limit, limit))
-	} else {
-		entriesToShow = filtered
+// entryLocales returns the sorted set of locales the entry has localized strings for
+func entryLocales(entry *indexer.Entry) []string {
+	seen := make(map[string]bool)
+	for locale := range entry.Names {
+		seen[locale] = true
 	}
-
-	attrs.WriteString("\nbody:\n")
-
-	body := strings.Builder{}
-	for _, entry := range entriesToShow {
-		name := entry.Name
-		if s.lang != "" && entry.Names != nil {
-			if locName, ok := entry.Names[s.lang]; ok {
-				name = locName
-			}
-		}
-		body.WriteString(fmt.Sprintf("%d %s\n", entry.ID, name))
-	}
-
-	s.writeResponse(conn, attrs.String()+body.String()+"\n\n")
-	log.Printf("[DEBUG] List response sent")
-}
-
-func (s *Server) handleListNext(conn net.Conn, cmd *parser.Command) {
-	log.Printf("[DEBUG] Handling list-next command")
-
-	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeInt {
-		log.Printf("[ERROR] list-next command missing offset parameter")
-		s.writeError(conn, "list-next", "missing offset", "list-next command requires an offset parameter")
-		return
-	}
-
-	offset := int(cmd.Args[0].Int)
-	if offset < 0 {
-		log.Printf("[ERROR] list-next command invalid offset: %d", offset)
-		s.writeError(conn, "list-next", "invalid offset", "offset must be non-negative")
-		return
-	}
-
-	cfg := config.Get()
-	limitSize := cfg.ListLimit()
-
-	// Check if limit_size is provided as second argument
-	if len(cmd.Args) >= 2 && cmd.Args[1].Type == parser.TypeInt {
-		if cmd.Args[1].Int > 0 {
-			limitSize = int(cmd.Args[1].Int)
-		}
-	}
-
-	idx := s.indexer.GetIndex()
-	allEntries := idx.GetAll()
-
-	s.filters.mu.RLock()
-	filtered := s.filterEntries(allEntries)
-	s.filters.mu.RUnlock()
-
-	fullLen := len(filtered)
-
-	if offset >= fullLen {
-		log.Printf("[ERROR] list-next offset %d out of bounds (total: %d)", offset, fullLen)
-		s.writeError(conn, "list-next", "offset out of bounds", fmt.Sprintf("offset %d exceeds total entries %d", offset, fullLen))
-		return
-	}
-
-	end := offset + limitSize
-	if end > fullLen {
-		end = fullLen
-	}
-
-	entriesToShow := filtered[offset:end]
-
-	attrs := strings.Builder{}
-	attrs.WriteString(fmt.Sprintf("len: %d\n", fullLen))
-	attrs.WriteString(fmt.Sprintf("limited: %d\n", limitSize))
-	attrs.WriteString(fmt.Sprintf("offset: %d\n", offset))
-
-	// If there are more entries, add list-next header
-	if end < fullLen {
-		attrs.WriteString(fmt.Sprintf("list-next: %d %d\n", end, limitSize))
+	for locale := range entry.GenericNames {
+		seen[locale] = true
 	}
 
-	attrs.WriteString("\nbody:\n")
-
-	body := strings.Builder{}
-	for _, entry := range entriesToShow {
-		name := entry.Name
-		if s.lang != "" && entry.Names != nil {
-			if locName, ok := entry.Names[s.lang]; ok {
-				name = locName
-			}
-		}
-		body.WriteString(fmt.Sprintf("%d %s\n", entry.ID, name))
+	locales := make([]string, 0, len(seen))
+	for locale := range seen {
+		locales = append(locales, locale)
 	}
-
-	s.writeResponse(conn, attrs.String()+body.String()+"\n\n")
-	log.Printf("[DEBUG] list-next response sent (offset: %d, limit: %d, shown: %d)", offset, limitSize, len(entriesToShow))
+	sort.Strings(locales)
+	return locales
 }
 
-func (s *Server) handleRun(conn net.Conn, cmd *parser.Command) {
-	log.Printf("[DEBUG] Handling run command")
-
-	var id int64
-	forceTerminal := false
-
-	// Check for optional "opt: terminal" argument
-	if len(cmd.Args) > 0 && cmd.Args[0].Type == parser.TypeString && cmd.Args[0].Str == "opt: terminal" {
-		forceTerminal = true
-		if len(cmd.Args) < 2 || cmd.Args[1].Type != parser.TypeInt {
-			log.Printf("[ERROR] Run command missing id parameter after opt: terminal")
-			s.writeError(conn, "run", "missing id", "run command requires an id parameter after opt: terminal")
-			return
-		}
-		id = cmd.Args[1].Int
-	} else if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeInt {
-		log.Printf("[ERROR] Run command missing id parameter")
-		s.writeError(conn, "run", "missing id", "run command requires an id parameter")
-		return
-	} else {
-		id = cmd.Args[0].Int
-	}
-
-	log.Printf("[DEBUG] Running application with id: %d, forceTerminal: %v", id, forceTerminal)
-
-	idx := s.indexer.GetIndex()
-	entry, ok := idx.Get(id)
-	if !ok {
-		log.Printf("[ERROR] Index %d not found", id)
-		s.writeError(conn, "run", "index not found", "Can't run application, requested index not found.")
-		return
-	}
-
-	log.Printf("[DEBUG] Found entry: %s, exec: %s, terminal: %v", entry.Name, entry.Exec, entry.Terminal)
-
-	// Execute the command
-	var execCmd *exec.Cmd
-	if forceTerminal || entry.Terminal {
-		cfg := config.Get()
-		term := cfg.Terminal()
-		execCmd = exec.Command(term, "--hold", "-e", entry.Exec)
-		log.Printf("[DEBUG] Executing in terminal: %s -e %s", term, entry.Exec)
-	} else {
-		execCmd = exec.Command(entry.Exec)
-		log.Printf("[DEBUG] Executing: %v", entry.Exec)
-	}
+// isEntryRef reports whether v can name an entry: by id, or by key as a string
+func isEntryRef(v parser.Value) bool {
+	return v.Type == parser.TypeInt || v.Type == parser.TypeString
+}
 
-	// Detach the process from the parent session to prevent terminal blocking
-	execCmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
+// entryRef formats an entry reference for logs
+func entryRef(v parser.Value) string {
+	if v.Type == parser.TypeString {
+		return "key " + redact.Quote(v.Str)
 	}
+	return fmt.Sprintf("index %d", v.Int)
+}
 
-	err := execCmd.Start()
-	if err != nil {
-		log.Printf("[ERROR] Failed to start command: %v", err)
-		s.writeError(conn, "run", "execution failed", err.Error())
-		return
+// lookupEntry returns the entry v names by id or key
+func (s *Server) lookupEntry(v parser.Value) (*indexer.Entry, bool) {
+	index := s.indexer.GetIndex()
+	if v.Type == parser.TypeString {
+		return index.GetByKey(v.Str)
 	}
+	return index.Get(v.Int)
+}
 
-	pid := execCmd.Process.Pid
-	log.Printf("[DEBUG] Command started successfully with PID: %d", pid)
-
-	// Update run frequency after successful execution
-	if err := s.runIndex.Increment(entry.Path); err != nil {
-		log.Printf("[WARN] Failed to update run frequency for %s: %v", entry.Path, err)
+// requireRunIndex writes an error to conn and returns false if the run index couldn't be
+// opened, for commands that are meaningless without it
+func (s *Server) requireRunIndex(conn net.Conn, cmd *parser.Command) bool {
+	if s.runIndex != nil {
+		return true
 	}
-
-	attrs := fmt.Sprintf("cmd: run\nidx: %d\nstatus: 0\npid: %d\n\n\n", id, pid)
-	s.writeResponse(conn, attrs)
-	log.Printf("[DEBUG] Run response sent")
+	log.Printf("[ERROR] %s command needs the run index, which is unavailable", cmd.Name)
+	s.writeError(conn, cmd, response.StatusInternal, "storage unavailable", "run index couldn't be opened, run counts, pins and sessions are disabled")
+	return false
 }
 
-func (s *Server) handleLang(conn net.Conn, cmd *parser.Command) {
-	log.Printf("[DEBUG] Handling lang command")
-	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeString {
-		log.Printf("[WARN] Lang command missing string parameter")
-		s.writeError(conn, "lang", "missing parameter", "lang command requires a string parameter")
-		return
+// pins returns the pinned keys and paths, none without a run index
+func (s *Server) pins() map[string]bool {
+	if s.runIndex == nil {
+		return nil
 	}
-	s.lang = cmd.Args[0].Str
-	log.Printf("[DEBUG] Language set to: %s", s.lang)
-
-	// Send success response
-	attrs := fmt.Sprintf("cmd: lang\nstatus: 0\nlang: %s\n\n\n", s.lang)
-	s.writeResponse(conn, attrs)
+	return s.runIndex.GetPinned()
 }
 
-func (s *Server) handleReindex(conn net.Conn, cmd *parser.Command) {
-	log.Printf("[DEBUG] Handling reindex command")
-
-	// Collect string arguments as paths
-	var paths []string
-	for _, arg := range cmd.Args {
-		if arg.Type != parser.TypeString {
-			log.Printf("[ERROR] reindex command received non-string argument")
-			s.writeError(conn, "reindex", "invalid argument", "reindex command accepts only string path arguments")
-			return
-		}
-		paths = append(paths, arg.Str)
+// setPinned pins an entry by key, so the pin survives the file moving, e.g. from /usr to
+// /usr/local. Unpinning also drops the pin of its canonical path, how pins used to be stored.
+func (s *Server) setPinned(entry *indexer.Entry, pinned bool) error {
+	if entry.Key == "" {
+		return s.runIndex.SetPinned(canonicalPath(entry.Path), pinned)
 	}
-
-	// Expand paths (handle ~ and convert to absolute)
-	expandedPaths := make([]string, 0, len(paths))
-	for _, path := range paths {
-		expanded := s.expandPath(path)
-		absPath, err := filepath.Abs(expanded)
-		if err != nil {
-			log.Printf("[WARN] Failed to resolve absolute path for %s: %v", path, err)
-			// Use expanded path even if absolute resolution fails
-			expandedPaths = append(expandedPaths, expanded)
-		} else {
-			expandedPaths = append(expandedPaths, absPath)
+	if !pinned {
+		if err := s.runIndex.SetPinned(canonicalPath(entry.Path), false); err != nil {
+			return err
 		}
 	}
+	return s.runIndex.SetPinned(entry.Key, pinned)
+}
 
-	log.Printf("[DEBUG] Reindexing paths: %v", expandedPaths)
+// isPinned reports whether entry is pinned by key or by path
+func isPinned(pins map[string]bool, entry *indexer.Entry) bool {
+	return (entry.Key != "" && pins[entry.Key]) || pins[canonicalPath(entry.Path)]
+}
 
-	// Perform reindexing (blocking call)
-	ctx := context.Background()
-	count, err := s.indexer.Reindex(ctx, expandedPaths)
-	if err != nil {
-		log.Printf("[ERROR] Reindex failed: %v", err)
-		s.writeError(conn, "reindex", "indexing failed", err.Error())
-		return
+// canonicalPath resolves symlinks so a path keeps identifying the same file across reindexes
+func canonicalPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
 	}
-
-	log.Printf("[DEBUG] Reindex completed, indexed %d entries", count)
-
-	// Send success response
-	attrs := fmt.Sprintf("cmd: reindex\nstatus: 0\nindexed: %d\n\n\n", count)
-	s.writeResponse(conn, attrs)
+	return filepath.Clean(path)
 }
 
 func (s *Server) expandPath(path string) string {
@@ -583,189 +292,3 @@ func (s *Server) expandPath(path string) string {
 	}
 	return path
 }
-
-func (s *Server) filterEntries(entries []*indexer.Entry) []*indexer.Entry {
-	var result []*indexer.Entry
-
-	for _, entry := range entries {
-		if s.matchesFilters(entry) {
-			result = append(result, entry)
-		}
-	}
-
-	return result
-}
-
-func (s *Server) matchesFilters(entry *indexer.Entry) bool {
-	// Check name filters
-	if len(s.filters.nameFilters) > 0 {
-		matched := false
-		for _, filter := range s.filters.nameFilters {
-			if s.matchesNameFilter(entry, filter) {
-				matched = true
-				break
-			}
-		}
-		if !matched {
-			return false
-		}
-	}
-
-	// Check category filters
-	if len(s.filters.catFilters) > 0 {
-		matched := false
-		for _, filter := range s.filters.catFilters {
-			if s.matchesCatFilter(entry, filter) {
-				matched = true
-				break
-			}
-		}
-		if !matched {
-			return false
-		}
-	}
-
-	// Check path filters
-	if len(s.filters.pathFilters) > 0 {
-		matched := false
-		for _, filter := range s.filters.pathFilters {
-			if s.matchesPathFilter(entry, filter) {
-				matched = true
-				break
-			}
-		}
-		if !matched {
-			return false
-		}
-	}
-
-	return true
-}
-
-func (s *Server) matchesNameFilter(entry *indexer.Entry, filter FilterExpr) bool {
-	// Collect all searchable names (direct name + localized names)
-	searchNames := []string{strings.ToLower(entry.Name)}
-	for _, name := range entry.Names {
-		searchNames = append(searchNames, strings.ToLower(name))
-	}
-
-	// Check matches for each value
-	matches := make([]bool, len(filter.Values))
-	for i, value := range filter.Values {
-		valueLower := strings.ToLower(value)
-		for _, searchName := range searchNames {
-			if strings.Contains(searchName, valueLower) {
-				matches[i] = true
-				break
-			}
-		}
-	}
-
-	// Apply operation logic
-	switch filter.Op {
-	case orOp:
-		// OR: return true if ANY value matches
-		for _, match := range matches {
-			if match {
-				return true
-			}
-		}
-		return false
-	case andOp:
-		// AND: return true if ALL values match
-		for _, match := range matches {
-			if !match {
-				return false
-			}
-		}
-		return len(matches) > 0
-	case notOp:
-		// NOT: return true if NONE of the values match
-		for _, match := range matches {
-			if match {
-				return false
-			}
-		}
-		return true
-	default:
-		// Default to OR behavior
-		for _, match := range matches {
-			if match {
-				return true
-			}
-		}
-		return false
-	}
-}
-
-func (s *Server) matchesCatFilter(entry *indexer.Entry, filter FilterExpr) bool {
-	for _, cat := range entry.Categories {
-		for _, filterCat := range filter.Values {
-			if strings.EqualFold(cat, filterCat) {
-				return true
-			}
-		}
-	}
-	return false
-}
-
-func (s *Server) matchesPathFilter(entry *indexer.Entry, filter FilterExpr) bool {
-	for _, filterPath := range filter.Values {
-		if strings.Contains(entry.Path, filterPath) {
-			return true
-		}
-	}
-	return false
-}
-
-// writeResponse writes a response with TXT01 header
-// Response string should already contain \n\n at the end to mark end of response
-func (s *Server) writeResponse(conn net.Conn, response string) {
-	log.Printf("[DEBUG] Writing response (length: %d bytes)", len(response))
-	header := []byte("TXT01")
-	n, err := conn.Write(header)
-	if err != nil {
-		log.Printf("[ERROR] Failed to write header: %v", err)
-		return
-	}
-	if n != len(header) {
-		log.Printf("[ERROR] Partial header write: %d/%d bytes", n, len(header))
-		return
-	}
-
-	n, err = conn.Write([]byte(response))
-	if err != nil {
-		log.Printf("[ERROR] Failed to write response body: %v", err)
-		return
-	}
-	log.Printf("[DEBUG] Response written successfully: %d bytes", n)
-}
-
-func (s *Server) writeError(conn net.Conn, cmd, errType, desc string) {
-	log.Printf("[ERROR] Writing error response: cmd=%s, type=%s, desc=%s", cmd, errType, desc)
-	errorMsg := fmt.Sprintf("error-cmd: %s\nerror: %s\ndesc: %s\n\n\n", cmd, errType, desc)
-	s.writeResponse(conn, errorMsg)
-}
-
-// sortByRunFrequency sorts entries by run frequency in descending order (most frequent first)
-func (s *Server) sortByRunFrequency(entries []*indexer.Entry) {
-	// Collect all paths for batch frequency lookup
-	paths := make([]string, len(entries))
-	for i, entry := range entries {
-		paths[i] = entry.Path
-	}
-
-	// Get frequencies for all paths in one call
-	frequencies := s.runIndex.GetFrequencies(paths)
-
-	// Sort entries by frequency (descending), then by ID (ascending) for stable sort
-	sort.SliceStable(entries, func(i, j int) bool {
-		freqI := frequencies[entries[i].Path]
-		freqJ := frequencies[entries[j].Path]
-		if freqI != freqJ {
-			return freqI > freqJ // Higher frequency first
-		}
-		// If frequencies are equal, sort by ID for stable ordering
-		return entries[i].ID < entries[j].ID
-	})
-}
@@ -2,10 +2,23 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/launcher"
+	"github.com/0xADE/ade-ctld/internal/perm"
+	"github.com/0xADE/ade-ctld/internal/runindex"
 	"github.com/0xADE/ade-ctld/parser"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -15,7 +28,7 @@ import (
 var _ = Describe("handleReindex", func() {
 	var (
 		idx        *indexer.Indexer
-		srv        *Server
+		srv        *session
 		clientConn net.Conn
 		serverConn net.Conn
 		response   string
@@ -23,7 +36,7 @@ var _ = Describe("handleReindex", func() {
 
 	BeforeEach(func() {
 		idx = indexer.NewIndexer()
-		srv = &Server{indexer: idx}
+		srv = (&Server{indexer: idx}).newSession()
 	})
 
 	AfterEach(func() {
@@ -179,11 +192,1060 @@ var _ = Describe("handleReindex", func() {
 		It("should contain indexed count", func() {
 			Expect(response).To(ContainSubstring("indexed:"))
 		})
+
+		It("should contain the count of every scanner", func() {
+			Expect(response).To(MatchRegexp(`\nignored: \d+\npruned: \d+\n`))
+			Expect(response).To(MatchRegexp(`indexed-executable: \d+\n`))
+			Expect(response).To(MatchRegexp(`indexed-desktop: \d+\n`))
+			Expect(response).To(MatchRegexp(`indexed-appimage: \d+\n`))
+		})
+	})
+})
+
+var _ = Describe("handlePin", func() {
+	var (
+		srv      *session
+		cacheDir string
+		binDir   string
+		pinnedID int64
+		usedID   int64
+	)
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(cacheDir)
+
+		// Entries backed by real files, so canonical paths resolve
+		binDir = filepath.Join(cacheDir, "bin")
+		Expect(os.MkdirAll(binDir, 0755)).To(Succeed())
+		for _, name := range []string{"rarely-used", "often-used"} {
+			Expect(os.WriteFile(filepath.Join(binDir, name), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		}
+		index := srv.indexer.GetIndex()
+		pinnedID = index.Add(&indexer.Entry{Name: "rarely-used", Path: filepath.Join(binDir, "rarely-used")})
+		usedID = index.Add(&indexer.Entry{Name: "often-used", Path: filepath.Join(binDir, "often-used")})
+		for range 5 {
+			Expect(srv.runIndex.Increment(filepath.Join(binDir, "often-used"))).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+		os.RemoveAll(cacheDir)
+	})
+
+	listIDs := func() []string {
+		var buf bytes.Buffer
+		srv.handleList(&mockConn{writeBuf: &buf}, &parser.Command{Name: "list"})
+		_, body, _ := strings.Cut(buf.String(), "body:\n")
+		var ids []string
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			ids = append(ids, strings.Fields(line)[0])
+		}
+		return ids
+	}
+
+	It("should sort by frequency without pins", func() {
+		Expect(listIDs()).To(Equal([]string{itoa(usedID), itoa(pinnedID)}))
+	})
+
+	It("should sort a pinned app ahead of a more frequently used one", func() {
+		var buf bytes.Buffer
+		srv.handlePin(&mockConn{writeBuf: &buf}, intCommand("pin", pinnedID), true)
+		Expect(buf.String()).To(ContainSubstring("cmd: pin"))
+		Expect(buf.String()).To(ContainSubstring("status: 0"))
+
+		Expect(listIDs()).To(Equal([]string{itoa(pinnedID), itoa(usedID)}))
+	})
+
+	It("should keep the pin when the entry is reindexed under a new id", func() {
+		srv.handlePin(&mockConn{}, intCommand("pin", pinnedID), true)
+
+		_, err := srv.indexer.Reindex(context.Background(), []string{binDir})
+		Expect(err).NotTo(HaveOccurred())
+
+		var buf bytes.Buffer
+		srv.handleList(&mockConn{writeBuf: &buf}, &parser.Command{Name: "list"})
+		_, body, _ := strings.Cut(buf.String(), "body:\n")
+		Expect(strings.Fields(body)[1]).To(Equal("rarely-used"))
+	})
+
+	It("should restore frequency order after unpin", func() {
+		srv.handlePin(&mockConn{}, intCommand("pin", pinnedID), true)
+		srv.handlePin(&mockConn{}, intCommand("unpin", pinnedID), false)
+		Expect(listIDs()).To(Equal([]string{itoa(usedID), itoa(pinnedID)}))
+	})
+
+	It("should report the pin in info output", func() {
+		srv.handlePin(&mockConn{}, intCommand("pin", pinnedID), true)
+
+		var buf bytes.Buffer
+		srv.handleInfo(&mockConn{writeBuf: &buf}, intCommand("info", pinnedID))
+		Expect(buf.String()).To(ContainSubstring("pinned: t"))
+
+		buf.Reset()
+		srv.handleInfo(&mockConn{writeBuf: &buf}, intCommand("info", usedID))
+		Expect(buf.String()).To(ContainSubstring("pinned: f"))
+	})
+
+	It("should pin by key and keep the pin when the file moves", func() {
+		_, err := srv.indexer.Reindex(context.Background(), []string{binDir})
+		Expect(err).NotTo(HaveOccurred())
+		keyCommand := func(name string) *parser.Command {
+			return &parser.Command{Name: name, Args: []parser.Value{{Type: parser.TypeString, Str: "rarely-used"}}}
+		}
+
+		var buf bytes.Buffer
+		srv.handleInfo(&mockConn{writeBuf: &buf}, keyCommand("info"))
+		Expect(buf.String()).To(ContainSubstring("key: rarely-used\n"))
+		Expect(buf.String()).To(ContainSubstring("path: " + filepath.Join(binDir, "rarely-used") + "\n"))
+
+		buf.Reset()
+		srv.handlePin(&mockConn{writeBuf: &buf}, keyCommand("pin"), true)
+		Expect(buf.String()).To(ContainSubstring("status: 0"))
+		Expect(srv.runIndex.GetPinned()).To(Equal(map[string]bool{"rarely-used": true}))
+
+		// Moved to another directory of the search path
+		localDir := filepath.Join(cacheDir, "local", "bin")
+		Expect(os.MkdirAll(localDir, 0755)).To(Succeed())
+		Expect(os.Rename(filepath.Join(binDir, "rarely-used"), filepath.Join(localDir, "rarely-used"))).To(Succeed())
+		_, err = srv.indexer.Reindex(context.Background(), []string{localDir, binDir})
+		Expect(err).NotTo(HaveOccurred())
+
+		buf.Reset()
+		srv.handleList(&mockConn{writeBuf: &buf}, &parser.Command{Name: "list"})
+		_, body, _ := strings.Cut(buf.String(), "body:\n")
+		Expect(strings.Fields(body)[1]).To(Equal("rarely-used"))
+
+		srv.handlePin(&mockConn{}, keyCommand("unpin"), false)
+		Expect(srv.runIndex.GetPinned()).To(BeEmpty())
+	})
+
+	It("should fail for an unknown key", func() {
+		var buf bytes.Buffer
+		srv.handlePin(&mockConn{writeBuf: &buf}, &parser.Command{Name: "pin", Args: []parser.Value{{Type: parser.TypeString, Str: "nothing"}}}, true)
+		Expect(buf.String()).To(ContainSubstring("status: 3\n"))
+	})
+
+	It("should fail for an unknown id", func() {
+		var buf bytes.Buffer
+		srv.handlePin(&mockConn{writeBuf: &buf}, intCommand("pin", 9999), true)
+		Expect(buf.String()).To(ContainSubstring("error-cmd: pin"))
+		Expect(buf.String()).To(ContainSubstring("index not found"))
+	})
+})
+
+var _ = Describe("handleWhich", func() {
+	var (
+		srv       *session
+		tmpDir    string
+		binDir    string
+		toolID    int64
+		desktopID int64
+		sleepID   int64
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		tmpDir, err = filepath.EvalSymlinks(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(tmpDir)
+
+		binDir = filepath.Join(tmpDir, "bin")
+		Expect(os.MkdirAll(binDir, 0755)).To(Succeed())
+		tool := filepath.Join(binDir, "tool")
+		Expect(os.WriteFile(tool, []byte("#!/bin/sh\nsleep 5\n"), 0755)).To(Succeed())
+		Expect(os.Symlink("tool", filepath.Join(binDir, "tool-link"))).To(Succeed())
+
+		// A real binary, so /proc/<pid>/exe points at it when started outside the daemon
+		sleepBin, err := exec.LookPath("sleep")
+		Expect(err).NotTo(HaveOccurred())
+		data, err := os.ReadFile(sleepBin)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(binDir, "my-sleep"), data, 0755)).To(Succeed())
+
+		index := srv.indexer.GetIndex()
+		toolID = index.Add(&indexer.Entry{Name: "tool", Path: tool, Exec: tool, ResolvedExec: indexer.ResolveExec(tool)})
+		sleepID = index.Add(&indexer.Entry{
+			Name:         "my-sleep",
+			Path:         filepath.Join(binDir, "my-sleep"),
+			Exec:         filepath.Join(binDir, "my-sleep"),
+			ResolvedExec: indexer.ResolveExec(filepath.Join(binDir, "my-sleep")),
+		})
+		desktopID = index.Add(&indexer.Entry{
+			Name:         "Sleeper",
+			Path:         filepath.Join(tmpDir, "sleeper.desktop"),
+			Exec:         "my-sleep-link %f",
+			ResolvedExec: indexer.ResolveExec(filepath.Join(binDir, "my-sleep")),
+			DesktopID:    "org.example.Sleeper.desktop",
+			IsDesktop:    true,
+		})
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	which := func(arg parser.Value) string {
+		var buf bytes.Buffer
+		srv.handleWhich(&mockConn{writeBuf: &buf}, &parser.Command{Name: "which", Args: []parser.Value{arg}})
+		return buf.String()
+	}
+
+	It("should follow trivial wrapper scripts only when enabled", func() {
+		wrapper := filepath.Join(binDir, "sleep-wrapper")
+		Expect(os.WriteFile(wrapper, []byte("#!/bin/sh\nexec "+filepath.Join(binDir, "my-sleep")+" \"$@\"\n"), 0755)).To(Succeed())
+		arg := parser.Value{Type: parser.TypeString, Str: wrapper}
+
+		Expect(which(arg)).To(ContainSubstring("status: 3"))
+
+		srv.inspectWrappers = true
+		response := which(arg)
+		Expect(response).To(ContainSubstring("status: 0"))
+		Expect(response).To(ContainSubstring("idx: " + itoa(desktopID)))
+	})
+
+	It("should find an executable by a symlink to it", func() {
+		response := which(parser.Value{Type: parser.TypeString, Str: filepath.Join(binDir, "tool-link")})
+		Expect(response).To(ContainSubstring("status: 0"))
+		Expect(response).To(ContainSubstring("idx: " + itoa(toolID)))
+		Expect(response).To(ContainSubstring("name: tool"))
+		Expect(response).NotTo(ContainSubstring("desktop-id:"))
+	})
+
+	It("should prefer the desktop entry and report its desktop file id", func() {
+		response := which(parser.Value{Type: parser.TypeString, Str: filepath.Join(binDir, "my-sleep")})
+		Expect(response).To(ContainSubstring("idx: " + itoa(desktopID)))
+		Expect(response).To(ContainSubstring("name: Sleeper"))
+		Expect(response).To(ContainSubstring("desktop-id: org.example.Sleeper.desktop"))
+	})
+
+	It("should find a process started outside the daemon by its executable", func() {
+		child := exec.Command(filepath.Join(binDir, "my-sleep"), "5")
+		Expect(child.Start()).To(Succeed())
+		defer func() {
+			child.Process.Kill()
+			child.Wait()
+		}()
+
+		response := which(parser.Value{Type: parser.TypeInt, Int: int64(child.Process.Pid)})
+		Expect(response).To(ContainSubstring("idx: " + itoa(desktopID)))
+		Expect(sleepID).NotTo(BeZero())
+	})
+
+	It("should find a process launched by the daemon through the process table", func() {
+		proc, err := srv.launcher.Start(toolID, "tool", []string{filepath.Join(binDir, "tool")})
+		Expect(err).NotTo(HaveOccurred())
+		defer syscall.Kill(-proc.PID, syscall.SIGKILL)
+
+		// The script runs as /bin/sh, so only the table can attribute it
+		response := which(parser.Value{Type: parser.TypeInt, Int: int64(proc.PID)})
+		Expect(response).To(ContainSubstring("idx: " + itoa(toolID)))
+	})
+
+	It("should report unknown paths as not found", func() {
+		response := which(parser.Value{Type: parser.TypeString, Str: "/nonexistent/tool"})
+		Expect(response).To(ContainSubstring("error-cmd: which"))
+		Expect(response).To(ContainSubstring("error: not found"))
+	})
+
+	It("should report unknown pids as not found", func() {
+		response := which(parser.Value{Type: parser.TypeInt, Int: 1 << 30})
+		Expect(response).To(ContainSubstring("error: not found"))
+	})
+})
+
+var _ = Describe("handleRun field codes", func() {
+	var (
+		srv      *session
+		cacheDir string
+		out      string
+		id       int64
+	)
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(cacheDir)
+
+		// Records its arguments, one per line
+		out = filepath.Join(cacheDir, "args")
+		record := filepath.Join(cacheDir, "record")
+		Expect(os.WriteFile(record, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\" > "+out+".tmp && mv "+out+".tmp "+out+"\n"), 0755)).To(Succeed())
+
+		id = srv.indexer.GetIndex().Add(&indexer.Entry{
+			Name:      "Files",
+			Names:     map[string]string{"de": "Dateien", "pt_BR": "Arquivos"},
+			Path:      "/usr/share/applications/files.desktop",
+			Exec:      record + " --title %c --from %k",
+			IsDesktop: true,
+		})
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+		os.RemoveAll(cacheDir)
+	})
+
+	runArgs := func() []string {
+		var buf bytes.Buffer
+		srv.handleRun(&mockConn{writeBuf: &buf}, intCommand("run", id))
+		Expect(buf.String()).To(ContainSubstring("status: 0"))
+		Eventually(func() error { _, err := os.Stat(out); return err }).Should(Succeed())
+		data, err := os.ReadFile(out)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(out)).To(Succeed())
+		return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	It("should expand %c to the name in the current language", func() {
+		srv.lang = "de_DE"
+		Expect(runArgs()).To(Equal([]string{"--title", "Dateien", "--from", "/usr/share/applications/files.desktop"}))
+
+		srv.lang = "pt_BR"
+		Expect(runArgs()).To(ContainElement("Arquivos"))
+
+		srv.lang = "fr"
+		Expect(runArgs()).To(ContainElement("Files"))
+	})
+})
+
+var _ = Describe("handleRun nice", func() {
+	var (
+		srv      *session
+		cacheDir string
+		pids     []int
+	)
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(cacheDir)
+		pids = nil
+	})
+
+	AfterEach(func() {
+		for _, pid := range pids {
+			syscall.Kill(-pid, syscall.SIGKILL)
+		}
+		srv.runIndex.Close()
+		os.RemoveAll(cacheDir)
+	})
+
+	add := func(nice *int) int64 {
+		return srv.indexer.GetIndex().Add(&indexer.Entry{
+			Name:      "Build",
+			Path:      filepath.Join(cacheDir, "build.desktop"),
+			Exec:      "sleep 5",
+			Nice:      nice,
+			IsDesktop: true,
+		})
+	}
+
+	run := func(args ...parser.Value) string {
+		var buf bytes.Buffer
+		srv.handleRun(&mockConn{writeBuf: &buf}, &parser.Command{Name: "run", Args: args})
+		return buf.String()
+	}
+
+	// niceOf waits for the process started by a run reply and returns its niceness
+	niceOf := func(reply string) func() string {
+		m := regexp.MustCompile(`\npid: (\d+)\n`).FindStringSubmatch(reply)
+		Expect(m).NotTo(BeNil(), reply)
+		pid, _ := strconv.Atoi(m[1])
+		pids = append(pids, pid)
+		return func() string {
+			data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+			if err != nil {
+				return ""
+			}
+			return strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))[16]
+		}
+	}
+
+	It("should apply the nice option and prefer it over X-ADE-Nice", func() {
+		if runtime.GOOS != "linux" {
+			Skip("niceness is only applied on Linux")
+		}
+		five := 5
+		id := add(&five)
+
+		Eventually(niceOf(run(parser.Value{Type: parser.TypeInt, Int: id}))).Should(Equal("5"))
+		Eventually(niceOf(run(
+			parser.Value{Type: parser.TypeString, Str: "opt: nice=12"},
+			parser.Value{Type: parser.TypeInt, Int: id},
+		))).Should(Equal("12"))
+	})
+
+	It("should reject a nice option out of range or not a number", func() {
+		id := add(nil)
+		for _, value := range []string{"20", "-21", "low", ""} {
+			reply := run(
+				parser.Value{Type: parser.TypeString, Str: "opt: nice=" + value},
+				parser.Value{Type: parser.TypeInt, Int: id},
+			)
+			Expect(reply).To(ContainSubstring("error: invalid nice\n"), value)
+			Expect(reply).To(ContainSubstring("status: 2\n"))
+		}
+	})
+})
+
+var _ = Describe("handleLangList", func() {
+	var (
+		srv     *session
+		tmpDir  string
+		oldHome string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(tmpDir)
+
+		// Desktop files are looked up under $HOME/.local/share/applications
+		oldHome = os.Getenv("HOME")
+		os.Setenv("HOME", tmpDir)
+		appsDir := filepath.Join(tmpDir, ".local", "share", "applications")
+		Expect(os.MkdirAll(appsDir, 0755)).To(Succeed())
+		desktopFile := `[Desktop Entry]
+Type=Application
+Name=Files
+Name[ru]=Файлы
+Name[de]=Dateien
+Name[pt_BR]=Arquivos
+GenericName=File Manager
+GenericName[fr]=Gestionnaire de fichiers
+Exec=true
+`
+		Expect(os.WriteFile(filepath.Join(appsDir, "files.desktop"), []byte(desktopFile), 0644)).To(Succeed())
+
+		_, err = srv.indexer.Reindex(context.Background(), []string{filepath.Join(tmpDir, "empty")})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.Setenv("HOME", oldHome)
+		srv.runIndex.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	langList := func(id int64) string {
+		var buf bytes.Buffer
+		srv.handleLangList(&mockConn{writeBuf: &buf}, intCommand("lang-list", id))
+		return buf.String()
+	}
+
+	It("should list the locales of a multi-locale desktop entry", func() {
+		var id int64
+		for _, entry := range srv.indexer.GetIndex().GetAll() {
+			if entry.Name == "Files" {
+				id = entry.ID
+			}
+		}
+		Expect(id).NotTo(BeZero())
+
+		response := langList(id)
+		Expect(response).To(ContainSubstring("cmd: lang-list"))
+		Expect(response).To(ContainSubstring("status: 0"))
+		Expect(response).To(ContainSubstring("len: 4"))
+		Expect(response).To(HaveSuffix("body:\nde\nfr\npt_BR\nru\n\n\n"))
+	})
+
+	It("should return an empty list for entries without translations", func() {
+		id := srv.indexer.GetIndex().Add(&indexer.Entry{Name: "tool", Path: "/usr/bin/tool"})
+		response := langList(id)
+		Expect(response).To(ContainSubstring("len: 0"))
+		Expect(response).To(HaveSuffix("body:\n\n\n"))
+	})
+
+	It("should report unknown ids as not found", func() {
+		response := langList(999999)
+		Expect(response).To(ContainSubstring("error-cmd: lang-list"))
+		Expect(response).To(ContainSubstring("error: not found"))
+	})
+})
+
+var _ = Describe("handleRunStats", func() {
+	var (
+		srv    *session
+		tmpDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(tmpDir)
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	runStats := func(cmd *parser.Command) string {
+		var buf bytes.Buffer
+		srv.handleRunStats(&mockConn{writeBuf: &buf}, cmd)
+		return buf.String()
+	}
+
+	It("should report aggregates and the most run paths", func() {
+		for path, n := range map[string]int{"/usr/bin/vim": 4, "/usr/bin/htop": 2, "/usr/bin/less": 1} {
+			for range n {
+				Expect(srv.runIndex.Increment(path)).To(Succeed())
+			}
+		}
+
+		response := runStats(intCommand("runstats", 2))
+		Expect(response).To(HavePrefix("TXT01cmd: runstats\nstatus: 0\n"))
+		Expect(response).To(ContainSubstring("tracked: 3\n"))
+		Expect(response).To(ContainSubstring("total-runs: 7\n"))
+		Expect(response).To(ContainSubstring("len: 2\n"))
+		Expect(response).To(HaveSuffix("body:\n4 /usr/bin/vim\n2 /usr/bin/htop\n\n\n"))
+
+		Expect(runStats(&parser.Command{Name: "runstats"})).To(ContainSubstring("len: 3\n"))
+	})
+
+	It("should reject a non-integer top", func() {
+		response := runStats(&parser.Command{Name: "runstats", Args: []parser.Value{{Type: parser.TypeString, Str: "all"}}})
+		Expect(response).To(ContainSubstring("error: invalid top\n"))
+		Expect(response).To(ContainSubstring("status: 2\n"))
+	})
+})
+
+var _ = Describe("handleSources", func() {
+	It("should list scanners with their counts and roots", func() {
+		tmpDir, err := os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(tmpDir)
+		Expect(os.WriteFile(filepath.Join(tmpDir, "tool"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+		srv := &Server{indexer: indexer.NewIndexer()}
+		_, err = srv.indexer.Reindex(context.Background(), []string{tmpDir})
+		Expect(err).NotTo(HaveOccurred())
+
+		var buf bytes.Buffer
+		srv.handleSources(&mockConn{writeBuf: &buf}, &parser.Command{Name: "sources"})
+		response := buf.String()
+		Expect(response).To(HavePrefix("TXT01cmd: sources\nstatus: 0\nlen: 3\n\nbody:\n"))
+		Expect(response).To(ContainSubstring("\nexecutable 1 " + tmpDir + "\n"))
+		Expect(response).To(MatchRegexp(`\ndesktop \d+ /usr/share/applications:`))
+		Expect(response).To(ContainSubstring("\nappimage 0\n"))
+	})
+
+	It("should list the walks of the roots in the reindex reply and with opt: roots", func() {
+		tmpDir, err := os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(tmpDir)
+		Expect(os.WriteFile(filepath.Join(tmpDir, "tool"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(tmpDir, "notes"), []byte("notes\n"), 0644)).To(Succeed())
+
+		srv := (&Server{indexer: indexer.NewIndexer()}).newSession()
+		var buf bytes.Buffer
+		srv.handleReindex(&mockConn{writeBuf: &buf}, &parser.Command{Name: "reindex", Args: []parser.Value{{Type: parser.TypeString, Str: tmpDir}}})
+		response := buf.String()
+		Expect(response).To(MatchRegexp(`\nlen: \d+\n`))
+		Expect(response).To(MatchRegexp(`\nbody:\n(.*\n)*executable \d+ 2 1 0 ` + regexp.QuoteMeta(tmpDir) + `\n`))
+
+		buf.Reset()
+		srv.handleSources(&mockConn{writeBuf: &buf}, &parser.Command{Name: "sources", Args: []parser.Value{{Type: parser.TypeString, Str: rootsOpt}}})
+		response = buf.String()
+		Expect(response).To(HavePrefix("TXT01cmd: sources\nstatus: 0\nlen: "))
+		Expect(response).To(MatchRegexp(`\nbody:\nexecutable \d+ 2 1 0 ` + regexp.QuoteMeta(tmpDir) + `\n`))
+		Expect(response).To(MatchRegexp(`\ndesktop \d+ \d+ \d+ \d+ /usr/share/applications\n`))
+
+		buf.Reset()
+		srv.handleSources(&mockConn{writeBuf: &buf}, &parser.Command{Name: "sources", Args: []parser.Value{{Type: parser.TypeString, Str: "opt: all"}}})
+		Expect(buf.String()).To(ContainSubstring("error: invalid argument\nstatus: 2\n"))
+	})
+})
+
+var _ = Describe("handleClearCache", func() {
+	var (
+		srv      *session
+		cacheDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(cacheDir)
+		Expect(srv.runIndex.Increment("/usr/bin/vim")).To(Succeed())
+		Expect(srv.runIndex.SetPinned("/usr/bin/vim", true)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+		os.RemoveAll(cacheDir)
+	})
+
+	clearCache := func(args ...parser.Value) string {
+		var buf bytes.Buffer
+		srv.handleClearCache(&mockConn{writeBuf: &buf}, &parser.Command{Name: "clearcache", Args: args})
+		return buf.String()
+	}
+	target := func(name string) parser.Value {
+		return parser.Value{Type: parser.TypeString, Str: name}
+	}
+	runs := func() uint64 {
+		return srv.runIndex.GetFrequencies([]string{"/usr/bin/vim"})["/usr/bin/vim"]
+	}
+
+	It("should clear the run counts only", func() {
+		response := clearCache(target("runs"))
+		Expect(response).To(Equal("TXT01cmd: clearcache\nstatus: 0\ncleared: runs\nruns-removed: 1\n\n\n"))
+		Expect(runs()).To(BeZero())
+		Expect(srv.runIndex.GetPinned()).To(HaveKey("/usr/bin/vim"))
+		Expect(srv.indexer.Generation()).To(BeZero())
+	})
+
+	It("should rebuild the index only", func() {
+		response := clearCache(target("index"))
+		Expect(response).To(HavePrefix("TXT01cmd: clearcache\nstatus: 0\ncleared: index\nindexed: "))
+		Expect(srv.indexer.Generation()).To(Equal(uint64(1)))
+		Expect(runs()).To(Equal(uint64(1)))
+	})
+
+	It("should clear both", func() {
+		response := clearCache(target("all"))
+		Expect(response).To(ContainSubstring("cleared: all\nruns-removed: 1\nindexed: "))
+		Expect(srv.indexer.Generation()).To(Equal(uint64(1)))
+		Expect(runs()).To(BeZero())
+	})
+
+	It("should refuse missing, misspelled and extra targets", func() {
+		for _, args := range [][]parser.Value{
+			nil,
+			{target("al")},
+			{target("All")},
+			{target("runs"), target("index")},
+			{{Type: parser.TypeBool, Bool: true, Str: "t"}},
+		} {
+			Expect(clearCache(args...)).To(ContainSubstring("status: 2\n"))
+		}
+		Expect(runs()).To(Equal(uint64(1)))
+		Expect(srv.indexer.Generation()).To(BeZero())
+	})
+})
+
+var _ = Describe("list on an empty index", func() {
+	var (
+		srv      *session
+		cacheDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(cacheDir)
+		// Only the fixture, not the executables and desktop files of the machine
+		srv.indexer.RemoveScanners()
+		srv.indexer.AddScanner(localScanner{entries: []indexer.Entry{
+			{Name: "vim", Path: "/opt/fixture/bin/vim", Exec: "/opt/fixture/bin/vim"},
+		}})
+	})
+
+	AfterEach(func() {
+		srv.indexer.Stop()
+		Eventually(srv.autoIndexing.Load).Should(BeFalse())
+		srv.runIndex.Close()
+		os.RemoveAll(cacheDir)
+	})
+
+	list := func() string {
+		var buf bytes.Buffer
+		srv.handleList(&mockConn{writeBuf: &buf}, &parser.Command{Name: "list"})
+		return buf.String()
+	}
+
+	It("should start indexing a never built index and ask to retry", func() {
+		Expect(list()).To(HavePrefix("TXT01len: 0\nlist-len: 0\ntotal: 0\nrevision: 0\nsort: freq\nindexing: t\npartial: t\n"))
+		Eventually(srv.indexer.Generation, "10s").Should(Equal(uint64(1)))
+		Eventually(srv.autoIndexing.Load).Should(BeFalse())
+
+		reply := list()
+		Expect(reply).NotTo(ContainSubstring("indexing: "))
+		Expect(reply).To(HavePrefix("TXT01len: 1\n"))
+		Expect(srv.indexer.Generation()).To(Equal(uint64(1)))
+	})
+
+	It("should leave a built index alone", func() {
+		srv.indexer.GetIndex().Add(&indexer.Entry{Name: "vim", Path: "/usr/bin/vim"})
+		Expect(list()).NotTo(ContainSubstring("indexing: "))
+		Consistently(srv.indexer.Generation).Should(BeZero())
+	})
+})
+
+var _ = Describe("handleExcludeCat", func() {
+	It("should hide excluded categories even when included", func() {
+		srv := (&Server{indexer: indexer.NewIndexer()}).newSession()
+		index := srv.indexer.GetIndex()
+		editorID := index.Add(&indexer.Entry{Name: "Editor", Path: "/apps/editor.desktop", Categories: []string{"Utility", "TextEditor"}, IsDesktop: true})
+		index.Add(&indexer.Entry{Name: "Puzzle", Path: "/apps/puzzle.desktop", Categories: []string{"Game", "Utility"}, IsDesktop: true})
+		index.Add(&indexer.Entry{Name: "Chess", Path: "/apps/chess.desktop", Categories: []string{"Game"}, IsDesktop: true})
+		index.Add(&indexer.Entry{Name: "Browser", Path: "/apps/browser.desktop", Categories: []string{"Network"}, IsDesktop: true})
+		cat := func(name string) parser.Value { return parser.Value{Type: parser.TypeString, Str: name} }
+
+		var buf bytes.Buffer
+		srv.handleFilterCat(&mockConn{writeBuf: &buf}, &parser.Command{Name: "+filter-cat", Args: []parser.Value{cat("Utility")}})
+		buf.Reset()
+		srv.handleExcludeCat(&mockConn{writeBuf: &buf}, &parser.Command{Name: "-filter-cat", Args: []parser.Value{cat("game")}})
+		Expect(buf.String()).To(Equal("TXT01cmd: -filter-cat\nstatus: 0\nexclude-cats: 1\n\n\n"))
+
+		var ids []int64
+		for _, entry := range srv.filterEntries(index.GetAll()) {
+			ids = append(ids, entry.ID)
+		}
+		Expect(ids).To(Equal([]int64{editorID}))
+
+		// Without includes everything but the excluded categories is left
+		srv.filters.catFilters = nil
+		Expect(srv.filterEntries(index.GetAll())).To(HaveLen(2))
+
+		buf.Reset()
+		srv.handleExcludeCat(&mockConn{writeBuf: &buf}, &parser.Command{Name: "-filter-cat", Args: []parser.Value{{Type: parser.TypeInt, Int: 1}}})
+		Expect(buf.String()).To(ContainSubstring("status: 2\n"))
+
+		srv.handleResetFilters(&mockConn{writeBuf: &buf})
+		Expect(srv.filterEntries(index.GetAll())).To(HaveLen(4))
+	})
+})
+
+var _ = Describe("handleDiff", func() {
+	var (
+		srv    *session
+		tmpDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = (&Server{indexer: indexer.NewIndexer()}).newSession()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	diff := func(args ...parser.Value) string {
+		var buf bytes.Buffer
+		srv.handleDiff(&mockConn{writeBuf: &buf}, &parser.Command{Name: "diff", Args: args})
+		return buf.String()
+	}
+	gen := func(n int64) parser.Value {
+		return parser.Value{Type: parser.TypeInt, Int: n}
+	}
+
+	It("should list the changes of the last reindex by default", func() {
+		Expect(os.WriteFile(filepath.Join(tmpDir, "old"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		_, err := srv.indexer.Reindex(context.Background(), []string{tmpDir})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Rename(filepath.Join(tmpDir, "old"), filepath.Join(tmpDir, "new"))).To(Succeed())
+		_, err = srv.indexer.Reindex(context.Background(), []string{tmpDir})
+		Expect(err).NotTo(HaveOccurred())
+
+		response := diff()
+		Expect(response).To(HavePrefix("TXT01cmd: diff\nstatus: 0\nfrom: 1\nto: 2\nadded: 1\nremoved: 1\nrenamed: 0\nlen: 2\n\nbody:\n"))
+		Expect(response).To(ContainSubstring("\n+ " + filepath.Join(tmpDir, "new") + " new\n"))
+		Expect(response).To(ContainSubstring("\n- " + filepath.Join(tmpDir, "old") + " old\n"))
+
+		Expect(diff(gen(2), gen(2))).To(ContainSubstring("\nlen: 0\n"))
+		Expect(diff(gen(0))).To(ContainSubstring("\nfrom: 0\nto: 2\n"))
+	})
+
+	It("should reject generations missing from the history", func() {
+		response := diff(gen(7))
+		Expect(response).To(ContainSubstring("error: generation not found\nstatus: 3\n"))
+		Expect(response).To(ContainSubstring("history has generations 0 to 0"))
+
+		response = diff(parser.Value{Type: parser.TypeString, Str: "latest"})
+		Expect(response).To(ContainSubstring("status: 2\n"))
+	})
+})
+
+var _ = Describe("writeError", func() {
+	var (
+		srv    *session
+		tmpDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(tmpDir)
+	})
+
+	AfterEach(func() {
+		srv.runIndex.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	execute := func(name string, args ...parser.Value) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, &parser.Command{Name: name, Args: args})
+		return buf.String()
+	}
+
+	It("should echo an unknown key passed to run with the usage hint", func() {
+		response := execute("run", parser.Value{Type: parser.TypeString, Str: "firefox"})
+		Expect(response).To(HavePrefix("TXT01error-cmd: run\n"))
+		Expect(response).To(ContainSubstring("error: index not found\n"))
+		Expect(response).To(ContainSubstring("status: 3\n"))
+		Expect(response).To(ContainSubstring(`args: str:"firefox"` + "\n"))
+		Expect(response).To(ContainSubstring(`hint: ["opt: terminal] ["opt: nice=<n>] ["action: <key>] ["file: <path>] ["args: <command line>] <id:int>|<key:str> run` + "\n"))
+		Expect(response).To(HaveSuffix("\n\n\n"))
+	})
+
+	It("should require an id after run options", func() {
+		response := execute("run",
+			parser.Value{Type: parser.TypeString, Str: "opt: terminal"},
+			parser.Value{Type: parser.TypeString, Str: "file: /tmp/notes.txt"},
+		)
+		Expect(response).To(ContainSubstring("error: missing id\n"))
+		Expect(response).To(ContainSubstring(`args: str:"opt: terminal" str:"file: /tmp/notes.txt"` + "\n"))
+	})
+
+	It("should echo an int value passed to a filter", func() {
+		response := execute("+filter-name",
+			parser.Value{Type: parser.TypeString, Str: "fire"},
+			parser.Value{Type: parser.TypeInt, Int: 42},
+			parser.Value{Type: parser.TypeBool, Bool: true, Str: "or"},
+		)
+		Expect(response).To(ContainSubstring("error-cmd: +filter-name\n"))
+		Expect(response).To(ContainSubstring("error: invalid argument\n"))
+		Expect(response).To(ContainSubstring(`args: str:"fire" int:42 op:or` + "\n"))
+		Expect(response).To(ContainSubstring("hint: [\"opt: allow-empty] <name:str>... [and|or|not] +filter-name\n"))
+
+		// The rejected filter must not be applied
+		Expect(srv.filters.nameFilters).To(BeEmpty())
+	})
+
+	It("should report the not found status", func() {
+		response := execute("info", parser.Value{Type: parser.TypeInt, Int: 999999})
+		Expect(response).To(ContainSubstring("status: 3\n"))
+		Expect(response).To(ContainSubstring("args: int:999999\n"))
+	})
+
+	It("should strip control characters and truncate long arguments", func() {
+		response := execute("lang", parser.Value{Type: parser.TypeInt, Int: 1},
+			parser.Value{Type: parser.TypeString, Str: "a\x1b[31mb\rc\x00d" + strings.Repeat("x", 100)})
+		line := attrLine(response, "args")
+		Expect(line).To(HavePrefix(`int:1 str:"a[31mbcd`))
+		Expect(line).NotTo(ContainSubstring("\x1b"))
+		Expect(line).To(HaveSuffix(`..."`))
+		Expect(len(line)).To(BeNumerically("<", 100))
+	})
+
+	It("should report unknown commands without a hint", func() {
+		response := execute("frobnicate")
+		Expect(response).To(ContainSubstring("error: unknown command\n"))
+		Expect(response).To(ContainSubstring("status: 4\n"))
+		Expect(response).NotTo(ContainSubstring("hint:"))
+		Expect(response).NotTo(ContainSubstring("args:"))
+	})
+
+	It("should only register commands the parser recognizes", func() {
+		for name := range commands {
+			p, err := parser.NewParser(strings.NewReader("TXT01" + name + "\n"))
+			Expect(err).NotTo(HaveOccurred())
+			cmd, err := p.ParseCommand()
+			Expect(err).NotTo(HaveOccurred(), name)
+			Expect(cmd.Name).To(Equal(name))
+		}
+	})
+})
+
+var _ = Describe("checkSocketDir", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("should accept a private dir owned by the current user", func() {
+		dir := filepath.Join(tmpDir, "sock")
+		Expect(os.Mkdir(dir, 0700)).To(Succeed())
+		Expect(checkSocketDir(dir, os.Getuid())).To(Succeed())
+	})
+
+	It("should refuse a dir owned by another user", func() {
+		dir := filepath.Join(tmpDir, "sock")
+		Expect(os.Mkdir(dir, 0700)).To(Succeed())
+
+		// Pretend the daemon runs as someone else instead of chowning, which needs root
+		err := checkSocketDir(dir, os.Getuid()+1)
+		Expect(err).To(MatchError(ErrUnsafeSocketDir))
+		Expect(err.Error()).To(ContainSubstring("owned by uid"))
+	})
+
+	It("should refuse a group or world writable dir", func() {
+		dir := filepath.Join(tmpDir, "sock")
+		Expect(os.Mkdir(dir, 0700)).To(Succeed())
+		Expect(os.Chmod(dir, 0777)).To(Succeed())
+
+		err := checkSocketDir(dir, os.Getuid())
+		Expect(err).To(MatchError(ErrUnsafeSocketDir))
+		Expect(err.Error()).To(ContainSubstring("0777"))
+	})
+
+	It("should refuse a symlink to a directory", func() {
+		target := filepath.Join(tmpDir, "target")
+		Expect(os.Mkdir(target, 0700)).To(Succeed())
+		link := filepath.Join(tmpDir, "sock")
+		Expect(os.Symlink(target, link)).To(Succeed())
+
+		Expect(checkSocketDir(link, os.Getuid())).To(MatchError(ErrUnsafeSocketDir))
+	})
+})
+
+var _ = Describe("listenSocket", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("should bind the socket in a new directory", func() {
+		listener, err := listenSocket(filepath.Join(tmpDir, "sock", "indexd.sock"), false)
+		Expect(err).NotTo(HaveOccurred())
+		listener.Close()
+	})
+
+	It("should let every user connect to a shared socket", func() {
+		socketPath := filepath.Join(tmpDir, "ade", "indexd")
+		listener, err := listenSocket(socketPath, true)
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		dir, err := os.Stat(filepath.Dir(socketPath))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dir.Mode().Perm()).To(Equal(os.FileMode(0755)))
+		socket, err := os.Stat(socketPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(socket.Mode().Perm()).To(Equal(os.FileMode(0666)))
+	})
+
+	It("should create the socket and its dir with the configured mode", func() {
+		DeferCleanup(perm.Set, perm.DefaultFile)
+		perm.Set(0640)
+		socketPath := filepath.Join(tmpDir, "ade", "indexd")
+		listener, err := listenSocket(socketPath, false)
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		dir, err := os.Stat(filepath.Dir(socketPath))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dir.Mode().Perm()).To(Equal(os.FileMode(0750)))
+		socket, err := os.Stat(socketPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(socket.Mode().Perm()).To(Equal(os.FileMode(0640)))
+	})
+
+	It("should say which socket dir it failed to create", func() {
+		file := filepath.Join(tmpDir, "file")
+		Expect(os.WriteFile(file, nil, 0600)).To(Succeed())
+
+		_, err := listenSocket(filepath.Join(file, "sock", "indexd.sock"), false)
+		Expect(err).To(MatchError(syscall.ENOTDIR))
+		Expect(err.Error()).To(HavePrefix("failed to create socket dir " + filepath.Join(file, "sock") + ": "))
+	})
+
+	It("should say which socket it failed to bind", func() {
+		// Unix socket paths are limited to about 100 bytes
+		socketPath := filepath.Join(tmpDir, strings.Repeat("s", 120))
+
+		_, err := listenSocket(socketPath, false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("failed to bind socket " + socketPath + ": "))
+	})
+
+	It("should suggest checking permissions when binding is denied", func() {
+		if os.Geteuid() == 0 {
+			Skip("root binds regardless of permissions")
+		}
+		dir := filepath.Join(tmpDir, "sock")
+		Expect(os.Mkdir(dir, 0500)).To(Succeed())
+
+		_, err := listenSocket(filepath.Join(dir, "indexd.sock"), false)
+		Expect(err).To(MatchError(syscall.EACCES))
+		Expect(err.Error()).To(HaveSuffix("(check permissions of " + dir + ")"))
+	})
+
+	It("should explain the usual bind errors", func() {
+		inUse := &net.OpError{Op: "listen", Net: "unix", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}
+		err := socketError("failed to bind socket", "/run/ade/indexd.sock", inUse)
+		Expect(err).To(MatchError(syscall.EADDRINUSE))
+		Expect(err.Error()).To(Equal("failed to bind socket /run/ade/indexd.sock: listen unix: bind: address already in use (another daemon may be running)"))
+
+		denied := &net.OpError{Op: "listen", Net: "unix", Err: os.NewSyscallError("bind", syscall.EACCES)}
+		err = socketError("failed to bind socket", "/run/ade/indexd.sock", denied)
+		Expect(err.Error()).To(HaveSuffix("permission denied (check permissions of /run/ade)"))
 	})
 })
 
 // Helper functions
 
+// attrLine returns the value of the attribute in a raw response
+func attrLine(response, key string) string {
+	for _, line := range strings.Split(response, "\n") {
+		if value, ok := strings.CutPrefix(line, key+": "); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// newTestServer creates a server without a listener, backed by an empty indexer and a run
+// index stored in cacheDir, and returns the session of a client: commands executed through it
+// share their filters and language like those of one connection
+func newTestServer(cacheDir string) *session {
+	ri, err := runindex.NewRunIndexWithCacheDir(cacheDir)
+	Expect(err).NotTo(HaveOccurred())
+	srv := &Server{
+		indexer:  indexer.NewIndexer(),
+		runIndex: ri,
+		launcher: launcher.New(launcher.ModeExec),
+		hub:      newHub(10*time.Millisecond, 10*time.Millisecond, 0, eventQueueSize),
+	}
+	return srv.newSession()
+}
+
+// intCommand creates a test command with a single integer argument
+func intCommand(name string, n int64) *parser.Command {
+	return &parser.Command{
+		Name: name,
+		Args: []parser.Value{{Type: parser.TypeInt, Int: n}},
+	}
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
 // createPipeConnection creates a TCP pipe connection pair for testing
 func createPipeConnection() (clientConn, serverConn net.Conn, err error) {
 	clientConn, serverConn = net.Pipe()
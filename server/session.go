@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/runindex"
+	"github.com/0xADE/ade-ctld/parser"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// session is the state of a client connection: the filters, language and order set by its
+// commands, which other connections don't see. Every connection gets its own, handed to the
+// handlers of its commands, which reach the server through it.
+type session struct {
+	*Server
+	filters   *Filters
+	lang      string
+	sortOrder string // order of lists not asking for one, see parseSortOrder
+	// ctx is that of the command under way, set by the connection running it, see
+	// cmdContext
+	ctx context.Context
+}
+
+// newSession returns the session of a new connection, with no filters, the default language
+// and the configured order
+func (s *Server) newSession() *session {
+	return &session{Server: s, filters: &Filters{}, lang: "en", sortOrder: s.sortOrder}
+}
+
+// sessionState is the client visible state a persisted session restores on resume
+type sessionState struct {
+	Lang        string       `json:"lang"`
+	Sort        string       `json:"sort,omitempty"`
+	NameFilters []FilterExpr `json:"name-filters"`
+	CatFilters  []FilterExpr `json:"cat-filters"`
+	PathFilters []FilterExpr `json:"path-filters"`
+	ExcludeCats []string     `json:"exclude-cats,omitempty"`
+}
+
+func (s *session) handleSession(conn net.Conn, cmd *parser.Command) {
+	log.Printf("[DEBUG] Handling session command")
+	if !s.requireRunIndex(conn, cmd) {
+		return
+	}
+
+	if len(cmd.Args) == 0 || cmd.Args[0].Type != parser.TypeString {
+		log.Printf("[ERROR] Session command missing action parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing action", "session command requires persist or resume action")
+		return
+	}
+
+	switch cmd.Args[0].Str {
+	case "persist":
+		s.persistSession(conn, cmd)
+	case "resume":
+		s.resumeSession(conn, cmd)
+	default:
+		s.writeError(conn, cmd, response.StatusBadArgument, "invalid action", "session action must be persist or resume")
+	}
+}
+
+// persistSession snapshots the current state and replies with the token to resume it
+func (s *session) persistSession(conn net.Conn, cmd *parser.Command) {
+	s.filters.mu.RLock()
+	state := s.filters.snapshot()
+	state.Lang = s.lang
+	state.Sort = s.sortOrder
+	data, err := json.Marshal(state)
+	s.filters.mu.RUnlock()
+	if err != nil {
+		s.writeError(conn, cmd, response.StatusInternal, "storage failed", err.Error())
+		return
+	}
+
+	buf := make([]byte, 16)
+	if _, err := randRead(buf); err != nil {
+		s.writeError(conn, cmd, response.StatusInternal, "storage failed", err.Error())
+		return
+	}
+	token := hex.EncodeToString(buf)
+
+	now := timeNow()
+	expires := now.Add(config.Get().SessionTTL())
+	if err := s.runIndex.SaveSession(token, data, expires); err != nil {
+		log.Printf("[ERROR] Failed to persist session: %v", err)
+		s.writeError(conn, cmd, response.StatusInternal, "storage failed", err.Error())
+		return
+	}
+	// Nobody resumes expired snapshots, drop them while we're writing anyway
+	if _, err := s.runIndex.PruneSessions(now); err != nil {
+		log.Printf("[WARN] Failed to prune expired sessions: %v", err)
+	}
+
+	s.writeResponse(conn, response.OK("session").Set("token", token).Setf("expires", "%d", expires.Unix()))
+}
+
+// resumeSession restores a persisted snapshot. Unknown and expired tokens aren't errors:
+// the client is told resumed: f and is expected to rebuild its state itself.
+func (s *session) resumeSession(conn net.Conn, cmd *parser.Command) {
+	if len(cmd.Args) < 2 || cmd.Args[1].Type != parser.TypeString {
+		log.Printf("[ERROR] Session resume missing token parameter")
+		s.writeError(conn, cmd, response.StatusBadArgument, "missing token", "session resume requires a token parameter")
+		return
+	}
+
+	data, err := s.runIndex.LoadSession(cmd.Args[1].Str, timeNow())
+	if errors.Is(err, runindex.ErrSessionNotFound) || errors.Is(err, runindex.ErrSessionExpired) {
+		reason := "unknown"
+		if errors.Is(err, runindex.ErrSessionExpired) {
+			reason = "expired"
+		}
+		log.Printf("[DEBUG] Session not resumed: %s token", reason)
+		s.writeResponse(conn, response.OK("session").SetBool("resumed", false).Set("reason", reason))
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to load session: %v", err)
+		s.writeError(conn, cmd, response.StatusInternal, "storage failed", err.Error())
+		return
+	}
+
+	var state sessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("[ERROR] Corrupt session snapshot: %v", err)
+		s.writeError(conn, cmd, response.StatusInternal, "corrupt session", err.Error())
+		return
+	}
+	// Persisted before the limits were lowered
+	if desc := s.filterLimit(state); desc != "" {
+		log.Printf("[WARN] Session not resumed, filter limits reached")
+		s.writeError(conn, cmd, response.StatusLimit, "limit", desc)
+		return
+	}
+
+	s.filters.mu.Lock()
+	s.filters.nameFilters = state.NameFilters
+	s.filters.catFilters = state.CatFilters
+	s.filters.pathFilters = state.PathFilters
+	s.filters.excludeCats = state.ExcludeCats
+	s.filters.mu.Unlock()
+	if state.Lang != "" {
+		s.lang = state.Lang
+	}
+	// Older snapshots have no order, the current one stays
+	if order, err := parseSortOrder(state.Sort); state.Sort != "" && err == nil {
+		s.sortOrder = order
+	}
+
+	s.writeResponse(conn, response.OK("session").SetBool("resumed", true))
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("handleSession", func() {
+	var (
+		srv    *session
+		tmpDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		srv = newTestServer(tmpDir)
+	})
+
+	AfterEach(func() {
+		timeNow = time.Now
+		srv.runIndex.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	session := func(args ...string) string {
+		cmd := &parser.Command{Name: "session"}
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, parser.Value{Type: parser.TypeString, Str: arg})
+		}
+		var buf bytes.Buffer
+		srv.handleSession(&mockConn{writeBuf: &buf}, cmd)
+		return buf.String()
+	}
+
+	It("should restore filters and lang from a persisted snapshot", func() {
+		srv.filters.nameFilters = []FilterExpr{{Values: []string{"fire"}, Op: orOp}}
+		srv.filters.catFilters = []FilterExpr{{Values: []string{"Network"}, Op: andOp}}
+		srv.lang = "ru"
+
+		response := session("persist")
+		Expect(response).To(ContainSubstring("cmd: session"))
+		Expect(response).To(ContainSubstring("status: 0"))
+		token := attrLine(response, "token")
+		Expect(token).To(HaveLen(32))
+		Expect(attrLine(response, "expires")).NotTo(BeEmpty())
+
+		srv.filters.nameFilters = nil
+		srv.filters.catFilters = nil
+		srv.lang = "en"
+
+		response = session("resume", token)
+		Expect(response).To(ContainSubstring("resumed: t"))
+		Expect(srv.filters.nameFilters).To(Equal([]FilterExpr{{Values: []string{"fire"}, Op: orOp}}))
+		Expect(srv.filters.catFilters).To(Equal([]FilterExpr{{Values: []string{"Network"}, Op: andOp}}))
+		Expect(srv.filters.pathFilters).To(BeEmpty())
+		Expect(srv.lang).To(Equal("ru"))
+	})
+
+	It("should refuse a snapshot beyond the filter limits and keep the filters set", func() {
+		srv.filters.nameFilters = []FilterExpr{{Values: []string{"fire"}, Op: orOp}, {Values: []string{"sol"}, Op: orOp}}
+		token := attrLine(session("persist"), "token")
+
+		srv.maxFilters = 1
+		srv.filters.nameFilters = []FilterExpr{{Values: []string{"jail"}, Op: orOp}}
+		response := session("resume", token)
+		Expect(response).To(ContainSubstring("error: limit\n"))
+		Expect(response).To(ContainSubstring("status: 9\n"))
+		Expect(srv.filters.nameFilters).To(Equal([]FilterExpr{{Values: []string{"jail"}, Op: orOp}}))
+
+		srv.maxFilters = 2
+		Expect(session("resume", token)).To(ContainSubstring("resumed: t"))
+		Expect(srv.filters.nameFilters).To(HaveLen(2))
+	})
+
+	It("should not resume unknown tokens", func() {
+		srv.lang = "de"
+		response := session("resume", "0123456789abcdef")
+		Expect(response).To(ContainSubstring("status: 0"))
+		Expect(response).To(ContainSubstring("resumed: f"))
+		Expect(response).To(ContainSubstring("reason: unknown"))
+		Expect(srv.lang).To(Equal("de"))
+	})
+
+	It("should not resume expired tokens", func() {
+		token := attrLine(session("persist"), "token")
+
+		timeNow = func() time.Time { return time.Now().Add(48 * time.Hour) }
+		response := session("resume", token)
+		Expect(response).To(ContainSubstring("resumed: f"))
+		Expect(response).To(ContainSubstring("reason: expired"))
+
+		// The expired snapshot is gone for good
+		timeNow = time.Now
+		Expect(session("resume", token)).To(ContainSubstring("reason: unknown"))
+	})
+
+	It("should reject missing or unknown actions", func() {
+		Expect(session()).To(ContainSubstring("error: missing action"))
+		Expect(session("forget")).To(ContainSubstring("error: invalid action"))
+		Expect(session("resume")).To(ContainSubstring("error: missing token"))
+	})
+})
+
+var _ = Describe("Session resume after restart", func() {
+	var (
+		tmpDir     string
+		socketPath string
+		oldSock    string
+	)
+
+	// startServer runs a server over the shared cache dir, like a daemon restarted in place
+	startServer := func() (*Server, context.CancelFunc) {
+		srv := newTestServer(tmpDir).Server
+		listener, err := net.Listen("unix", socketPath)
+		Expect(err).NotTo(HaveOccurred())
+		srv.listener = listener
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go srv.Start(ctx)
+		return srv, cancel
+	}
+
+	stopServer := func(srv *Server, cancel context.CancelFunc) {
+		cancel()
+		Expect(srv.Stop()).To(Succeed())
+	}
+
+	// addEntries gives the filters restored something to tell apart
+	addEntries := func(srv *Server) {
+		index := srv.indexer.GetIndex()
+		index.Add(&indexer.Entry{Name: "Firefox", Path: "/usr/bin/firefox", Categories: []string{"Network"}})
+		index.Add(&indexer.Entry{Name: "firewall", Path: "/usr/bin/firewall", Categories: []string{"System"}})
+		index.Add(&indexer.Entry{Name: "Thunderbird", Path: "/usr/bin/thunderbird", Categories: []string{"Network"}})
+	}
+
+	// The state of a connection is its own, it shows in the replies of the client alone
+	listed := func(client *exe.Client) []string {
+		apps, err := client.List()
+		Expect(err).NotTo(HaveOccurred())
+		var names []string
+		for _, app := range apps {
+			names = append(names, app.Name)
+		}
+		return names
+	}
+	langOf := func(client *exe.Client) string {
+		reply, err := client.Exec("categories")
+		Expect(err).NotTo(HaveOccurred())
+		return reply.Attrs["lang"]
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ade-server-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		socketPath = filepath.Join(tmpDir, "indexd")
+		oldSock = os.Getenv("ADE_INDEXD_SOCK")
+		os.Setenv("ADE_INDEXD_SOCK", socketPath)
+	})
+
+	AfterEach(func() {
+		timeNow = time.Now
+		os.Setenv("ADE_INDEXD_SOCK", oldSock)
+		os.RemoveAll(tmpDir)
+	})
+
+	It("should resume the session on the restarted server", func() {
+		first, cancel := startServer()
+		client, err := exe.NewClient()
+		Expect(err).NotTo(HaveOccurred())
+		defer client.Close()
+
+		Expect(client.SetFilterName("fire")).To(Succeed())
+		Expect(client.SetLang("ru")).To(Succeed())
+		// Not set through a typed method, so only resuming can bring it back
+		Expect(client.SendCommand("+filter-cat", "Network")).To(Succeed())
+		exe.ReadResponse(client.Conn())
+
+		token, err := client.PersistSession()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).NotTo(BeEmpty())
+		stopServer(first, cancel)
+
+		second, cancel := startServer()
+		defer stopServer(second, cancel)
+
+		addEntries(second)
+
+		Expect(client.Reconnect()).To(Succeed())
+		Expect(listed(client)).To(Equal([]string{"Firefox"}))
+		Expect(langOf(client)).To(Equal("ru"))
+	})
+
+	It("should replay typed state when the session expired meanwhile", func() {
+		first, cancel := startServer()
+		client, err := exe.NewClient()
+		Expect(err).NotTo(HaveOccurred())
+		defer client.Close()
+
+		Expect(client.SetFilterName("fire")).To(Succeed())
+		Expect(client.SetLang("ru")).To(Succeed())
+		Expect(client.SendCommand("+filter-cat", "Network")).To(Succeed())
+		exe.ReadResponse(client.Conn())
+		_, err = client.PersistSession()
+		Expect(err).NotTo(HaveOccurred())
+		stopServer(first, cancel)
+
+		timeNow = func() time.Time { return time.Now().Add(48 * time.Hour) }
+		second, cancel := startServer()
+		defer stopServer(second, cancel)
+
+		addEntries(second)
+
+		Expect(client.Reconnect()).To(Succeed())
+		Expect(listed(client)).To(Equal([]string{"Firefox", "firewall"}))
+		Expect(langOf(client)).To(Equal("ru"))
+	})
+})
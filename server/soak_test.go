@@ -0,0 +1,246 @@
+//go:build soak
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xADE/ade-ctld/internal/config"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/runindex"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// The soak suite runs a real daemon in-process and hammers it with connections, overlapping
+// reindexes and short-lived children, then checks that goroutines and file descriptors return
+// to the baseline. Run it with:
+//
+//	go test -tags soak ./server/ -ginkgo.label-filter=soak
+
+const (
+	soakCycles  = 400
+	soakWorkers = 16
+	soakChilds  = 8
+	soakKinds   = 6 // kinds of cycles, see soakCycle
+)
+
+var soakDir string
+
+var _ = BeforeSuite(func() {
+	var err error
+	soakDir, err = os.MkdirTemp("", "ade-soak-*")
+	Expect(err).NotTo(HaveOccurred())
+
+	// Config is a process-wide singleton: point it at the sandbox before anything reads it
+	home := filepath.Join(soakDir, "home")
+	Expect(os.MkdirAll(home, 0755)).To(Succeed())
+	os.Setenv("HOME", home)
+	os.Setenv("ADE_INDEXD_SOCK", filepath.Join(soakDir, "sock", "indexd"))
+	Expect(config.Init()).To(Succeed())
+	config.Get().SetCacheDir(filepath.Join(soakDir, "cache"))
+})
+
+var _ = AfterSuite(func() {
+	if soakDir != "" {
+		os.RemoveAll(soakDir)
+	}
+})
+
+var _ = Describe("Soak", Label("soak"), func() {
+	It("returns goroutines and fds to the baseline after heavy use", func() {
+		binDir := filepath.Join(soakDir, "bin")
+		Expect(os.MkdirAll(binDir, 0755)).To(Succeed())
+		for i := range soakChilds {
+			script := fmt.Sprintf("#!/bin/sh\nsleep 0.0%d\nexit %d\n", rand.IntN(10), i%2)
+			path := filepath.Join(binDir, fmt.Sprintf("soak-child-%d", i))
+			Expect(os.WriteFile(path, []byte(script), 0755)).To(Succeed())
+		}
+
+		// Warm up lazily created runtime resources (netpoller, signal handling) before the baseline
+		warm, err := net.Listen("unix", filepath.Join(soakDir, "warm.sock"))
+		Expect(err).NotTo(HaveOccurred())
+		warm.Close()
+
+		baseGoroutines := runtime.NumGoroutine()
+		baseFds := countOpenFds()
+
+		idx := indexer.NewIndexer()
+		_, err = idx.Reindex(context.Background(), []string{binDir})
+		Expect(err).NotTo(HaveOccurred())
+
+		srv, err := NewServer(idx)
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		serverErr := make(chan error, 1)
+		go func() {
+			serverErr <- srv.Start(ctx)
+		}()
+
+		socketPath := config.Get().UnixSocket()
+		Eventually(func() error {
+			conn, err := net.Dial("unix", socketPath)
+			if err == nil {
+				conn.Close()
+			}
+			return err
+		}, 5*time.Second).Should(Succeed())
+		// One cycle of every kind starts what the daemon keeps running, e.g. the watcher of
+		// ignore files the first reindex sets up
+		for n := range soakKinds {
+			soakCycle(socketPath, binDir, n)
+		}
+		time.Sleep(500 * time.Millisecond)
+		serverGoroutines := runtime.NumGoroutine()
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for range soakWorkers {
+			wg.Go(func() {
+				defer GinkgoRecover()
+				for n := range jobs {
+					soakCycle(socketPath, binDir, n)
+				}
+			})
+		}
+		for n := range soakCycles {
+			jobs <- n
+		}
+		close(jobs)
+		wg.Wait()
+
+		// Subscriptions of connections gone without unsubscribe are torn down with them
+		subscribers := func() int {
+			_, n := srv.hub.Stats()
+			return n
+		}
+		Eventually(subscribers, 10*time.Second, 50*time.Millisecond).Should(BeZero())
+		Eventually(runtime.NumGoroutine, 10*time.Second, 50*time.Millisecond).Should(BeNumerically("<=", serverGoroutines))
+
+		// Leave a few connections hanging mid-command: Stop must reclaim them
+		var hanging []net.Conn
+		for range 4 {
+			conn, err := net.Dial("unix", socketPath)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = conn.Write([]byte("TXT01\"half-sent\n"))
+			Expect(err).NotTo(HaveOccurred())
+			hanging = append(hanging, conn)
+		}
+
+		cancel()
+		Eventually(serverErr, 5*time.Second).Should(Receive())
+		Expect(srv.Stop()).To(Succeed())
+		idx.Stop()
+		for _, conn := range hanging {
+			conn.Close()
+		}
+
+		// The bbolt file must be released: a second open would time out otherwise
+		cacheDir, err := config.Get().CacheDir()
+		Expect(err).NotTo(HaveOccurred())
+		ri, err := runindex.NewRunIndexWithCacheDir(cacheDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ri.Close()).To(Succeed())
+
+		// Children sleep for at most 90ms; give reapers time to collect them
+		Eventually(runtime.NumGoroutine, 10*time.Second, 50*time.Millisecond).Should(BeNumerically("<=", baseGoroutines))
+		Eventually(countOpenFds, 10*time.Second, 50*time.Millisecond).Should(BeNumerically("<=", baseFds))
+	})
+})
+
+// soakCycle runs one connect/commands/disconnect cycle. The command mix depends on n so the
+// whole run covers listing, filtering, launching, reindexing and abrupt disconnects.
+func soakCycle(socketPath, binDir string, n int) {
+	conn, err := net.Dial("unix", socketPath)
+	Expect(err).NotTo(HaveOccurred())
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("TXT01"))
+	Expect(err).NotTo(HaveOccurred())
+	reader := bufio.NewReader(conn)
+
+	send := func(req string) string {
+		_, err := conn.Write([]byte(req))
+		Expect(err).NotTo(HaveOccurred())
+		resp, err := readSoakResponse(reader)
+		Expect(err).NotTo(HaveOccurred())
+		return resp
+	}
+
+	send("\"soak-child\n+filter-name\n")
+	list := send("list\n")
+
+	switch n % soakKinds {
+	case 0:
+		// Subscribers must be torn down with their connection, events may still be in flight
+		send("subscribe\n")
+		send(fmt.Sprintf("\"%s\nreindex\n", binDir))
+	case 1, 2:
+		if id, ok := firstListedID(list); ok {
+			send(fmt.Sprintf("%d\nrun\n", id))
+		}
+	case 3:
+		// Drop the connection without reading the reply
+		conn.Write([]byte("list\n"))
+		return
+	case 4:
+		send("garbage-value\n")
+	case 5:
+		// Drop a subscribed connection without unsubscribe, events may still be in flight
+		send("subscribe\n")
+		send(fmt.Sprintf("\"%s\nreindex\n", binDir))
+		return
+	}
+	send("0filters\n")
+}
+
+// readSoakResponse reads one framed response: header, then lines up to the "\n\n\n" terminator
+func readSoakResponse(reader *bufio.Reader) (string, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for !strings.HasSuffix(sb.String(), "\n\n\n") {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(line)
+	}
+	return sb.String(), nil
+}
+
+func firstListedID(resp string) (int64, bool) {
+	_, body, ok := strings.Cut(resp, "body:\n")
+	if !ok {
+		return 0, false
+	}
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(fields[0], 10, 64)
+	return id, err == nil
+}
+
+func countOpenFds() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
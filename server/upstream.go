@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xADE/ade-ctld/client/exe"
+	"github.com/0xADE/ade-ctld/internal/indexer"
+	"github.com/0xADE/ade-ctld/internal/indexer/desktop"
+	"github.com/0xADE/ade-ctld/response"
+)
+
+// A daemon can run split: a system instance (ADE_INDEXD_SYSTEM) indexes what all users share
+// and serves read-only commands, and an instance per user (ADE_INDEXD_UPSTREAM set to the
+// socket of the system one) indexes the user's directories and mirrors the system index
+// into its own. Mirrored entries are indexed like the others, so lists, searches, runs, pins
+// and run counts of the user instance cover both and ids are the user instance's own. An
+// entry found by the user instance stands for a mirrored one of the same path or desktop
+// file ID. While the system instance can't be reached, the user instance serves its own
+// entries and says so in lists and searches.
+
+const (
+	upstreamScanner = "upstream" // name and origin of the scanner mirroring the system instance
+
+	minUpstreamDelay = time.Second
+	maxUpstreamDelay = time.Minute
+
+	upstreamRestarts = 3 // list restarts tolerated in one mirror, for indexes rebuilt meanwhile
+)
+
+// upstream is the scanner mirroring the index of the system instance
+type upstream struct {
+	socket      string
+	unavailable atomic.Bool // the last mirror failed
+}
+
+func newUpstream(socket string) *upstream {
+	return &upstream{socket: socket}
+}
+
+func (u *upstream) Name() string   { return upstreamScanner }
+func (u *upstream) Origin() string { return upstreamScanner }
+
+// Scan sends a copy of every entry listed by the system instance
+func (u *upstream) Scan(ctx context.Context, _ []string, out chan<- *indexer.Entry) error {
+	entries, err := u.mirror(ctx)
+	u.unavailable.Store(err != nil && ctx.Err() == nil)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		out <- entry
+	}
+	return ctx.Err()
+}
+
+// mirror lists the entries of the system instance page by page, then describes them with
+// get-many
+func (u *upstream) mirror(ctx context.Context) ([]*indexer.Entry, error) {
+	client, err := exe.Dial(u.socket)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	conn := client.Conn()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	caps, err := client.Capabilities()
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := listAll(client)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*indexer.Entry, 0, len(ids))
+	batch := caps.ListLimit
+	if batch <= 0 {
+		// The upstream daemon doesn't limit get-many
+		batch = max(len(ids), 1)
+	}
+	for start := 0; start < len(ids); start += batch {
+		args := make([]any, 0, batch)
+		for _, id := range ids[start:min(start+batch, len(ids))] {
+			args = append(args, id)
+		}
+		reply, err := client.Exec("get-many", args...)
+		if err != nil {
+			return nil, err
+		}
+		// Entries gone since they were listed are missing, the next mirror is due anyway
+		for line := range strings.Lines(reply.Body) {
+			if entry, ok := mirroredEntry(strings.TrimSuffix(line, "\n")); ok {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// listAll returns the ids of every entry listed by client, following cursors. A list
+// restarted because the index was rebuilt is read again from the first page.
+func listAll(client *exe.Client) ([]int64, error) {
+	var ids []int64
+	page, err := client.ListPage("")
+	for restarts := 0; err == nil; page, err = client.ListPage(page.Cursor) {
+		if page.Restarted {
+			if restarts++; restarts > upstreamRestarts {
+				return nil, fmt.Errorf("index rebuilt %d times while listing it", restarts)
+			}
+			ids = ids[:0]
+		}
+		for _, app := range page.Applications {
+			ids = append(ids, app.ID)
+		}
+		if page.Cursor == "" {
+			return ids, nil
+		}
+	}
+	return nil, err
+}
+
+// mirroredEntry makes an entry of a get-many body line: id, key, name, path, exec,
+// terminal, desktop, categories and pinned, tab separated. The id and pin are those of the
+// system instance and left out; what isn't listed, like localized names and keywords, isn't
+// mirrored.
+func mirroredEntry(line string) (*indexer.Entry, bool) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 8 {
+		return nil, false
+	}
+	if _, err := strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return nil, false
+	}
+	key, name, path, execLine := fields[1], fields[2], fields[3], fields[4]
+	entry := &indexer.Entry{
+		Name:      name,
+		Path:      path,
+		Exec:      execLine,
+		Terminal:  fields[5] == "t",
+		IsDesktop: fields[6] == "t",
+	}
+	if fields[7] != "" {
+		entry.Categories = strings.Split(fields[7], ";")
+	}
+	if entry.IsDesktop {
+		// Keys of desktop entries are their desktop file IDs, unless taken by another one
+		if !strings.Contains(key, "@") {
+			entry.DesktopID = key
+		}
+		entry.ResolvedExec = indexer.ResolveExec(desktop.ExecBinary(execLine))
+	} else {
+		entry.ResolvedExec = indexer.ResolveExec(path)
+	}
+	// The files are on this machine too
+	if info, err := os.Stat(path); err == nil {
+		entry.ModTime = info.ModTime()
+	}
+	return entry, true
+}
+
+// followUpstream mirrors the system instance again whenever its index changes: it subscribes
+// to its events, and reindexes on every index update, once connected and once the connection
+// is lost, so the entries of an instance gone away are dropped. It retries connecting with
+// backoff until done is closed.
+func (s *Server) followUpstream(done <-chan struct{}) {
+	delay := minUpstreamDelay
+	for {
+		connected, err := s.followUpstreamOnce(done)
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if connected {
+			log.Printf("[WARN] Lost the upstream daemon at %s: %v", s.upstream.socket, err)
+			go s.reindexForUpstream()
+			delay = minUpstreamDelay
+		} else {
+			log.Printf("[DEBUG] Upstream daemon at %s unreachable: %v; retrying in %v", s.upstream.socket, err, delay)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxUpstreamDelay)
+	}
+}
+
+// followUpstreamOnce subscribes to the events of the system instance and reindexes on every
+// index update until the connection ends or done is closed. Reports whether it subscribed.
+func (s *Server) followUpstreamOnce(done <-chan struct{}) (bool, error) {
+	client, err := exe.Dial(s.upstream.socket)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+	conn := client.Conn()
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-done:
+			conn.Close()
+		case <-stopped:
+		}
+	}()
+
+	if _, err := client.Exec("subscribe"); err != nil {
+		return false, err
+	}
+	log.Printf("[DEBUG] Following the upstream daemon at %s", s.upstream.socket)
+	// It may have changed, or come back, since the last mirror
+	go s.reindexForUpstream()
+	for {
+		event, err := client.ReadEvent()
+		if err != nil {
+			return true, err
+		}
+		if event.Attrs["event"] != "" {
+			go s.reindexForUpstream()
+		}
+	}
+}
+
+// reindexForUpstream rebuilds the index after the index of the system instance changed
+func (s *Server) reindexForUpstream() {
+	log.Printf("[DEBUG] Reindexing after the upstream index changed")
+	req := indexer.ReindexRequest{Scope: indexer.FullScope(nil), Priority: indexer.PriorityBackground, Trigger: indexer.TriggerUpstream, Fresh: true}
+	if _, err := s.indexer.Schedule(context.Background(), req); err != nil {
+		log.Printf("[ERROR] Reindex after the upstream index changed failed: %v", err)
+	}
+}
+
+// setUpstream makes the server mirror the index of the system instance listening on socket,
+// indexing the user's directories itself
+func (s *Server) setUpstream(socket string) {
+	s.upstream = newUpstream(socket)
+	s.indexer.AddScanner(s.upstream)
+	s.indexer.SetSplit(indexer.SplitUser)
+}
+
+// setUpstreamAttr sets the attribute lists and searches carry while the system instance
+// can't be mirrored and only the user's own entries are served
+func (s *Server) setUpstreamAttr(resp *response.Response) {
+	if s.upstream != nil && s.upstream.unavailable.Load() {
+		resp.Set("upstream", "unavailable")
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+
+	"github.com/0xADE/ade-ctld/internal/indexer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// localScanner sends the entries the daemon of a user finds itself
+type localScanner struct {
+	entries []indexer.Entry
+}
+
+func (localScanner) Name() string { return "local" }
+
+func (s localScanner) Scan(ctx context.Context, roots []string, out chan<- *indexer.Entry) error {
+	for _, entry := range s.entries {
+		out <- &entry
+	}
+	return nil
+}
+
+var _ = Describe("split daemons", func() {
+	var (
+		system, user *session
+		socketPath   string
+		serverErr    chan error
+	)
+
+	send := func(srv *session, lines ...string) string {
+		var buf bytes.Buffer
+		srv.executeCommand(&mockConn{writeBuf: &buf}, parseRequest(lines...))
+		return buf.String()
+	}
+	// byPath returns the entries of the user daemon
+	byPath := func() map[string]*indexer.Entry {
+		entries := make(map[string]*indexer.Entry)
+		for _, entry := range user.indexer.GetIndex().GetAll() {
+			entries[entry.Path] = entry
+		}
+		return entries
+	}
+	reindex := func() {
+		_, err := user.indexer.Reindex(context.Background(), nil)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		system = newTestServer(filepath.Join(dir, "system"))
+		DeferCleanup(system.runIndex.Close)
+		system.system = true
+		for _, entry := range []*indexer.Entry{
+			{Key: "firefox.desktop", Name: "Firefox", Path: "/usr/share/applications/firefox.desktop", Exec: "firefox %u", DesktopID: "firefox.desktop", IsDesktop: true, Categories: []string{"Network", "WebBrowser"}},
+			{Key: "gimp.desktop", Name: "GIMP", Path: "/usr/share/applications/gimp.desktop", Exec: "gimp %U", DesktopID: "gimp.desktop", IsDesktop: true, Categories: []string{"Graphics"}},
+			{Key: "tool", Name: "tool", Path: "/opt/shared/bin/tool", Exec: "/opt/shared/bin/tool"},
+			{Key: "shared", Name: "shared", Path: "/opt/system/bin/shared", Exec: "/opt/system/bin/shared"},
+		} {
+			system.indexer.GetIndex().Add(entry)
+		}
+
+		socketPath = filepath.Join(dir, "indexd")
+		listener, err := net.Listen("unix", socketPath)
+		Expect(err).NotTo(HaveOccurred())
+		system.listener = listener
+		serverErr = make(chan error, 1)
+		go func() {
+			serverErr <- system.Start(context.Background())
+		}()
+		DeferCleanup(func() {
+			Expect(system.Stop()).To(Succeed())
+			Eventually(serverErr).Should(Receive(BeNil()))
+		})
+
+		user = newTestServer(filepath.Join(dir, "user"))
+		DeferCleanup(user.runIndex.Close)
+		// Only the fixtures, not the executables and desktop files of the machine
+		user.indexer.RemoveScanners()
+		user.indexer.AddScanner(localScanner{entries: []indexer.Entry{
+			// The user's copy of a system desktop file stands for it
+			{Name: "Firefox (private)", Path: "/home/user/.local/share/applications/firefox.desktop", Exec: "firefox --private-window %u", DesktopID: "firefox.desktop", IsDesktop: true},
+			{Name: "tool", Path: "/opt/shared/bin/tool", Exec: "/opt/shared/bin/tool"},
+			{Name: "notes", Path: "/home/user/bin/notes", Exec: "/home/user/bin/notes"},
+		}})
+		user.setUpstream(socketPath)
+	})
+
+	It("should mirror the system entries, leaving out those found by the user's daemon", func() {
+		reindex()
+
+		entries := byPath()
+		Expect(entries).NotTo(HaveKey("/usr/share/applications/firefox.desktop"))
+		Expect(entries["/home/user/.local/share/applications/firefox.desktop"].Origin).To(BeEmpty())
+		Expect(entries["/opt/shared/bin/tool"].Origin).To(BeEmpty())
+		Expect(entries["/home/user/bin/notes"].Origin).To(BeEmpty())
+
+		gimp := entries["/usr/share/applications/gimp.desktop"]
+		Expect(gimp).NotTo(BeNil())
+		Expect(gimp.Origin).To(Equal("upstream"))
+		Expect(gimp.Name).To(Equal("GIMP"))
+		Expect(gimp.DesktopID).To(Equal("gimp.desktop"))
+		Expect(gimp.Exec).To(Equal("gimp %U"))
+		Expect(gimp.Categories).To(Equal([]string{"Graphics"}))
+
+		// Ids and keys are the user daemon's
+		Expect(send(user, `"gimp.desktop`, "info")).To(ContainSubstring("idx: " + itoa(gimp.ID) + "\n"))
+		Expect(send(user, itoa(gimp.ID), "info")).To(HaveSuffix("pinned: f\norigin: upstream\n\n\n"))
+		Expect(send(user, itoa(entries["/home/user/bin/notes"].ID), "info")).NotTo(ContainSubstring("origin:"))
+
+		reply := send(user, `"gimp`, "filter-name")
+		Expect(reply).To(ContainSubstring("status: 0\n"))
+		reply = send(user, "list")
+		Expect(reply).NotTo(ContainSubstring("upstream:"))
+		Expect(reply).To(ContainSubstring("\n" + itoa(gimp.ID) + " GIMP\n"))
+
+		// Pins stay with the user
+		Expect(send(user, `"gimp.desktop`, "pin")).To(ContainSubstring("status: 0\n"))
+		Expect(user.runIndex.GetPinned()).To(HaveKey("gimp.desktop"))
+	})
+
+	It("should serve only read-only commands from the system daemon", func() {
+		for _, request := range [][]string{
+			{"1", "run"},
+			{`"fire`, "filter-name"},
+			{"0filters"},
+			{`"de`, "lang"},
+			{"1", "pin"},
+			{`"opt: dry-run`, "1", "pin"},
+		} {
+			reply := send(system, request...)
+			Expect(reply).To(ContainSubstring("error: read-only\nstatus: 8\n"), "%v", request)
+		}
+		Expect(send(system, "list")).To(ContainSubstring("len: 4\n"))
+		Expect(send(system, `"gimp`, "search")).To(ContainSubstring("len: 1\n"))
+		Expect(send(system, "capabilities")).To(MatchRegexp(`features: .*\bsystem\b`))
+	})
+
+	It("should serve the user's entries alone while the system daemon is down", func() {
+		reindex()
+		Expect(byPath()).To(HaveKey("/opt/system/bin/shared"))
+
+		Expect(system.Stop()).To(Succeed())
+		Eventually(serverErr).Should(Receive(BeNil()))
+		serverErr <- nil // for the cleanup
+		reindex()
+
+		entries := byPath()
+		Expect(entries).NotTo(HaveKey("/opt/system/bin/shared"))
+		Expect(entries).To(HaveKey("/home/user/bin/notes"))
+		Expect(send(user, "list")).To(ContainSubstring("upstream: unavailable\n"))
+		Expect(send(user, `"notes`, "search")).To(ContainSubstring("upstream: unavailable\n"))
+		Expect(send(user, "capabilities")).To(MatchRegexp(`features: .*\bupstream\b`))
+	})
+
+	It("should mirror the system index again when it changes", func() {
+		done := make(chan struct{})
+		DeferCleanup(func() { close(done) })
+		go user.followUpstream(done)
+		// Following starts with a mirror
+		Eventually(byPath, "10s").Should(HaveKey("/opt/system/bin/shared"))
+
+		system.indexer.GetIndex().Add(&indexer.Entry{Key: "added", Name: "added", Path: "/opt/system/bin/added", Exec: "/opt/system/bin/added"})
+		system.hub.Publish(2, 1)
+		Eventually(byPath, "10s").Should(HaveKey("/opt/system/bin/added"))
+	})
+})
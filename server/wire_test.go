@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// Values of the reindex reply that depend on the machine: the time a root took to walk,
+// and what was found in the system's desktop directories, which are always walked
+var (
+	wireTiming       = regexp.MustCompile(`(?m)^(\S+) \d+ `)
+	wireDesktopRoot  = regexp.MustCompile(`(?m)^desktop \$MS \d+ \d+ \d+ `)
+	wireDesktopCount = regexp.MustCompile(`(?m)^(indexed|indexed-desktop): \d+$`)
+)
+
+// The wire specs run a server on one end of a net.Pipe and write raw bytes on the other,
+// the way a client in any language would, so that a change of the framing shows even
+// where the handlers are untouched: the header of every reply, the order of the attrs,
+// the body marker and the terminator.
+var _ = Describe("wire protocol", func() {
+	var (
+		srv        *session
+		dir        string
+		clientConn net.Conn
+		reader     *bufio.Reader
+		written    chan struct{} // closed once the server read all the bytes of the last write
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		srv = goldenServer(dir)
+
+		written = nil
+		var serverConn net.Conn
+		clientConn, serverConn = net.Pipe()
+		Expect(srv.trackConn(serverConn)).To(BeTrue())
+		go srv.handleConnection(serverConn)
+		reader = bufio.NewReader(clientConn)
+		DeferCleanup(func() {
+			clientConn.Close()
+			srv.connWg.Wait()
+			srv.runIndex.Close()
+		})
+	})
+
+	// write sends raw bytes, as many writes as there are chunks. A pipe write returns
+	// once read, and the server answers a command before reading the next one, so the
+	// bytes are written in the background while the replies are read.
+	write := func(chunks ...string) {
+		if written != nil {
+			Eventually(written).Should(BeClosed())
+		}
+		done := make(chan struct{})
+		written = done
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			for _, chunk := range chunks {
+				_, err := clientConn.Write([]byte(chunk))
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}()
+	}
+
+	// reply reads one reply, header included
+	reply := func() string {
+		Expect(clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+		return "TXT01" + readFrame(reader)
+	}
+
+	// expectNothingMore checks that no byte follows the replies read
+	expectNothingMore := func() {
+		Eventually(written).Should(BeClosed())
+		Expect(clientConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))).To(Succeed())
+		_, err := reader.Peek(1)
+		Expect(err).To(MatchError(os.ErrDeadlineExceeded))
+	}
+
+	It("should answer list byte for byte", func() {
+		write("TXT01", "list\n")
+		Expect(reply()).To(Equal("TXT01len: 4\nlist-len: 4\ntotal: 4\nrevision: 1\nsort: freq\n\nbody:\n1 Firefox\n4 missing\n2 Terminal\n3 true\n\n\n"))
+		expectNothingMore()
+	})
+
+	It("should read requests split anywhere and answer them in order", func() {
+		write("TX", "T01\"fi", "re\nfil", "ter-name", "\n", "li", "st\n")
+		Expect(reply()).To(Equal("TXT01cmd: filter-name\nstatus: 0\nname-filters: 1\n\n\n"))
+		Expect(reply()).To(Equal("TXT01len: 1\nlist-len: 1\ntotal: 4\nrevision: 1\nsort: freq\n\nbody:\n1 Firefox\n\n\n"))
+		expectNothingMore()
+
+		// Requests written at once are answered one after the other
+		write("0filters\n\"opt: dry-run\n3\nrun\n")
+		Expect(reply()).To(Equal("TXT01cmd: 0filters\nstatus: 0\n\n\n"))
+		Expect(reply()).To(Equal("TXT01cmd: run\nidx: 3\nstatus: 0\ndry-run: t\nlen: 1\n\nbody:\n/usr/bin/true\n\n\n"))
+		expectNothingMore()
+	})
+
+	It("should answer run byte for byte", func() {
+		write("TXT01", "3\nrun\n")
+		Expect(goldenPID.ReplaceAllString(reply(), "pid: $$PID")).To(Equal("TXT01cmd: run\nidx: 3\nstatus: 0\npid: $PID\n\n\n"))
+		expectNothingMore()
+	})
+
+	It("should answer reindex byte for byte", func() {
+		GinkgoT().Setenv("HOME", dir)
+		root := filepath.Join(dir, "bin")
+		Expect(os.Mkdir(root, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, "tool"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+		write("TXT01", `"`+root+"\nreindex\n")
+		got := wireTiming.ReplaceAllString(strings.ReplaceAll(reply(), dir, "$TMP"), "$1 $$MS ")
+		got = wireDesktopRoot.ReplaceAllString(got, "desktop $$MS $$N $$N $$N ")
+		got = wireDesktopCount.ReplaceAllString(got, "$1: $$N")
+		Expect(got).To(Equal("TXT01cmd: reindex\nstatus: 0\nindexed: $N\nignored: 0\npruned: 0\nlen: 4\n" +
+			"indexed-executable: 1\nindexed-desktop: $N\nindexed-appimage: 0\n\nbody:\n" +
+			"executable $MS 1 1 0 $TMP/bin\n" +
+			"desktop $MS $N $N $N /usr/share/applications\n" +
+			"desktop $MS $N $N $N /usr/local/share/applications\n" +
+			"desktop $MS $N $N $N $TMP/.local/share/applications\n\n\n"))
+		expectNothingMore()
+	})
+
+	It("should answer errors byte for byte and go on", func() {
+		write("TXT01", "999\ninfo\n")
+		Expect(reply()).To(Equal("TXT01error-cmd: info\nerror: index not found\nstatus: 3\n" +
+			"desc: Can't describe application, requested index not found.\nargs: int:999\nhint: <id:int>|<key:str> info\n\n\n"))
+
+		// A word that isn't a command is a syntax error, the connection stays usable
+		write("frobnicate\n")
+		Expect(reply()).To(Equal("TXT01error-cmd: parser\nerror: parse error\nstatus: 5\ndesc: parse error: cannot parse value: frobnicate\n\n\n"))
+		write("list\n")
+		Expect(reply()).To(HavePrefix("TXT01len: 4\n"))
+		expectNothingMore()
+	})
+
+	It("should answer a wrong header with a parser error and close", func() {
+		write("JSN01")
+		Expect(reply()).To(Equal("TXT01error-cmd: parser\nerror: invalid header\nstatus: 5\ndesc: unsupported format: JSN\n\n\n"))
+		_, err := reader.Peek(1)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -270,6 +270,19 @@ Categories=Utility;Test;
         finally:
             s.close()
 
+    def test_07_kill_unknown_pid(self):
+        s = self.connect()
+        try:
+            # Only processes started by the daemon may be killed
+            self.send_command(s, "1\nkill\n")
+            resp = self.read_response(s)
+            headers, _ = self.parse_response(resp)
+
+            self.assertEqual(headers.get("error-cmd"), "kill", f"Unexpected response: {resp}")
+            self.assertEqual(headers.get("error"), "process not found")
+        finally:
+            s.close()
+
 
 if __name__ == "__main__":
     unittest.main()